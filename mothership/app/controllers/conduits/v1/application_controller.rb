@@ -86,6 +86,26 @@ module Conduits
         @current_directive
       end
 
+      def terminal_directive?(directive)
+        directive.succeeded? || directive.failed? || directive.canceled? || directive.timed_out?
+      end
+
+      def base64_decoded_size(b64, field:)
+        len = b64.bytesize
+        raise ArgumentError, "#{field} must be base64" if (len % 4) != 0
+
+        padding =
+          if b64.end_with?("==")
+            2
+          elsif b64.end_with?("=")
+            1
+          else
+            0
+          end
+
+        (len / 4) * 3 - padding
+      end
+
       def extract_bearer_token
         auth_header = request.headers["Authorization"]
         return nil unless auth_header&.start_with?("Bearer ")
@@ -0,0 +1,122 @@
+require "digest"
+
+module Conduits
+  module V1
+    class DirectiveArtifactsController < Conduits::V1::ApplicationController
+      before_action :authenticate_directive!
+
+      # PUT /conduits/v1/directives/:id/artifacts?path=&size=&sha256=&mode=
+      #
+      # Body: the file (application/octet-stream), exactly `size` bytes whose
+      # SHA-256 is `sha256`. Idempotent per path: uploading the same content
+      # again is acknowledged, different content for a stored path conflicts.
+      def upload
+        unless current_directive.running? || terminal_directive?(current_directive)
+          render json: {
+                   error: "invalid_state",
+                   detail: "directive is #{current_directive.state}, expected running or terminal",
+                 },
+                 status: :conflict
+          return
+        end
+
+        path = params[:path].to_s
+        raise ArgumentError, "path must be a facility-relative path" unless Conduits::DirectiveArtifact.valid_path?(path)
+
+        size = Integer(params[:size])
+        raise ArgumentError, "size must be >= 0" if size < 0
+
+        sha256 = params[:sha256].to_s
+        raise ArgumentError, "sha256 must be lowercase hex" unless sha256.match?(/\A[0-9a-f]{64}\z/)
+
+        mode = params[:mode].presence
+        raise ArgumentError, "mode must be octal" unless mode.nil? || mode.match?(/\A0?[0-7]{3,4}\z/)
+
+        existing = current_directive.artifacts.find_by(path: path)
+        if existing
+          render_existing(existing, sha256)
+          return
+        end
+
+        if size > max_artifact_bytes
+          render json: { error: "too_large", detail: "artifact exceeds #{max_artifact_bytes} bytes" },
+                 status: :content_too_large
+          return
+        end
+        if current_directive.artifacts.sum(:size) + size > max_artifact_total_bytes
+          render json: { error: "too_large", detail: "directive artifacts exceed #{max_artifact_total_bytes} bytes" },
+                 status: :content_too_large
+          return
+        end
+
+        artifact = store_artifact(path: path, size: size, sha256: sha256, mode: mode)
+
+        render json: {
+          ok: true,
+          directive_id: current_directive.id,
+          path: artifact.path,
+          size: artifact.size,
+          sha256: artifact.sha256,
+          duplicate: false,
+        }
+      rescue ActiveRecord::RecordNotUnique
+        render_existing(current_directive.artifacts.find_by!(path: path), sha256)
+      rescue ArgumentError, TypeError => e
+        render json: { error: "invalid_param", detail: e.message }, status: :unprocessable_entity
+      end
+
+      private
+
+      # Streams the body into a blob, checking size and sha256 before the
+      # artifact is recorded.
+      def store_artifact(path:, size:, sha256:, mode:)
+        Tempfile.create(["artifact", ".bin"], binmode: true) do |tmp|
+          copied = IO.copy_stream(request.body, tmp, size + 1)
+          raise ArgumentError, "body is #{copied} bytes, expected #{size}" unless copied == size
+          raise ArgumentError, "sha256 mismatch" unless Digest::SHA256.file(tmp.path).hexdigest == sha256
+
+          tmp.rewind
+          blob = ActiveStorage::Blob.create_and_upload!(
+            io: tmp,
+            filename: File.basename(path),
+            content_type: "application/octet-stream"
+          )
+
+          artifact = current_directive.artifacts.new(path: path, size: size, sha256: sha256, mode: mode)
+          artifact.file.attach(blob)
+          artifact.save!
+          artifact
+        end
+      end
+
+      def render_existing(artifact, sha256)
+        unless artifact.sha256 == sha256
+          render json: { error: "invalid_state", detail: "artifact #{artifact.path} already stored with other content" },
+                 status: :conflict
+          return
+        end
+
+        render json: {
+          ok: true,
+          directive_id: current_directive.id,
+          path: artifact.path,
+          size: artifact.size,
+          sha256: artifact.sha256,
+          duplicate: true,
+        }
+      end
+
+      def max_artifact_bytes
+        default = 50.megabytes
+        max = ENV.fetch("CONDUITS_ARTIFACT_MAX_BYTES", default).to_i
+        max.positive? ? max : default
+      end
+
+      def max_artifact_total_bytes
+        default = 200.megabytes
+        max = ENV.fetch("CONDUITS_ARTIFACT_MAX_TOTAL_BYTES", default).to_i
+        max.positive? ? max : default
+      end
+    end
+  end
+end
@@ -0,0 +1,88 @@
+module Conduits
+  module V1
+    class DirectiveAuditEventsController < Conduits::V1::ApplicationController
+      MAX_EVENTS_PER_BATCH = 200
+      REQUIRED_EVENT_KEYS = %w[ts directive_id dest_host dest_port decision reason_code].freeze
+      EVENT_KEYS = (REQUIRED_EVENT_KEYS + %w[resolved_ip method query_type sni credential_injected http_rule cache]).freeze
+
+      before_action :authenticate_directive!
+
+      # POST /conduits/v1/directives/:id/audit_events
+      #
+      # Params: { seq, events: [{ ts, directive_id, dest_host, dest_port, decision, reason_code, ... }] }
+      # Egress proxy / DNS resolver decisions, batched apart from log_chunks.
+      # Nexus replays spooled batches after a restart, so terminal directives
+      # are accepted too; a seq already stored is acknowledged as a duplicate.
+      def create
+        unless current_directive.running? || terminal_directive?(current_directive)
+          render json: {
+                   error: "invalid_state",
+                   detail: "directive is #{current_directive.state}, expected running or terminal",
+                 },
+                 status: :conflict
+          return
+        end
+
+        seq = Integer(params[:seq])
+        raise ArgumentError, "seq must be >= 0" if seq < 0
+
+        events = normalize_events(params[:events])
+
+        if current_directive.egress_audit_batches.exists?(seq: seq)
+          render_result(seq, stored: false, duplicate: true)
+          return
+        end
+
+        current_directive.egress_audit_batches.create!(
+          seq: seq,
+          events: events,
+          event_count: events.size,
+          denied_count: events.count { |e| e["decision"] == "deny" }
+        )
+        render_result(seq, stored: true, duplicate: false)
+      rescue ActiveRecord::RecordNotUnique
+        render_result(seq, stored: false, duplicate: true)
+      rescue ArgumentError, TypeError => e
+        render json: { error: "invalid_param", detail: e.message }, status: :unprocessable_entity
+      end
+
+      private
+
+      def normalize_events(value)
+        events = params_to_h(value, nil)
+        raise ArgumentError, "events must be an array" unless events.is_a?(Array)
+        if events.size > MAX_EVENTS_PER_BATCH
+          raise ArgumentError, "too many events (max #{MAX_EVENTS_PER_BATCH})"
+        end
+
+        events.map.with_index do |raw, i|
+          event = params_to_h(raw, nil)
+          raise ArgumentError, "events[#{i}] must be an object" unless event.is_a?(Hash)
+
+          event = event.stringify_keys.slice(*EVENT_KEYS)
+          missing = REQUIRED_EVENT_KEYS.reject { |k| event.key?(k) }
+          raise ArgumentError, "events[#{i}] missing #{missing.join(", ")}" if missing.any?
+          unless %w[allow deny].include?(event["decision"])
+            raise ArgumentError, "events[#{i}].decision must be allow or deny"
+          end
+          unless event["directive_id"].to_s == current_directive.id.to_s
+            raise ArgumentError, "events[#{i}].directive_id does not match the directive"
+          end
+
+          event["dest_port"] = Integer(event["dest_port"])
+          event
+        end
+      end
+
+      def render_result(seq, stored:, duplicate:)
+        render json: {
+          ok: true,
+          directive_id: current_directive.id,
+          seq: seq,
+          stored: stored,
+          duplicate: duplicate,
+        }
+      end
+    end
+  end
+end
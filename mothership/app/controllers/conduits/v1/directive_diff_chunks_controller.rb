@@ -0,0 +1,84 @@
+module Conduits
+  module V1
+    class DirectiveDiffChunksController < Conduits::V1::ApplicationController
+      before_action :authenticate_directive!
+
+      # GET /conduits/v1/directives/:id/diff_chunks?sha256=
+      #
+      # How much of the diff identified by sha256 has been received, so Nexus
+      # can resume an interrupted upload.
+      def show
+        sha256 = params[:sha256].to_s
+        raise ArgumentError, "sha256 must be lowercase hex" unless sha256.match?(/\A[0-9a-f]{64}\z/)
+
+        render_result Conduits::DiffUploadIngestor.new(current_directive).status(sha256: sha256)
+      rescue ArgumentError => e
+        render json: { error: "invalid_param", detail: e.message }, status: :unprocessable_entity
+      end
+
+      # POST /conduits/v1/directives/:id/diff_chunks
+      #
+      # Params: { sha256, total_bytes, offset, bytes (base64), chunk_sha256 }
+      # Appends one chunk; offset must equal received_bytes (409 otherwise).
+      def create
+        unless current_directive.running?
+          render json: { error: "invalid_state", detail: "directive is #{current_directive.state}, expected running" },
+                 status: :conflict
+          return
+        end
+
+        sha256 = params[:sha256].to_s
+        raise ArgumentError, "sha256 must be lowercase hex" unless sha256.match?(/\A[0-9a-f]{64}\z/)
+
+        total_bytes = Integer(params[:total_bytes])
+        raise ArgumentError, "total_bytes must be > 0" unless total_bytes.positive?
+        if total_bytes > max_diff_upload_bytes
+          render json: { error: "too_large", detail: "diff exceeds #{max_diff_upload_bytes} bytes" },
+                 status: :content_too_large
+          return
+        end
+
+        offset = Integer(params[:offset])
+        raise ArgumentError, "offset must be >= 0" if offset < 0
+
+        b64 = params[:bytes].to_s
+        if base64_decoded_size(b64, field: "bytes") > max_diff_chunk_bytes
+          raise ArgumentError, "bytes too large (max #{max_diff_chunk_bytes} bytes)"
+        end
+        bytes = Base64.strict_decode64(b64)
+        unless Digest::SHA256.hexdigest(bytes) == params[:chunk_sha256].to_s
+          raise ArgumentError, "chunk_sha256 mismatch"
+        end
+
+        render_result Conduits::DiffUploadIngestor.new(current_directive).append!(
+          sha256: sha256,
+          total_bytes: total_bytes,
+          offset: offset,
+          bytes: bytes
+        )
+      rescue Conduits::DiffUploadIngestor::OffsetMismatch, Conduits::DiffUploadIngestor::Conflict => e
+        render json: { error: "invalid_state", detail: e.message }, status: :conflict
+      rescue ArgumentError, TypeError => e
+        render json: { error: "invalid_param", detail: e.message }, status: :unprocessable_entity
+      end
+
+      private
+
+      def render_result(result)
+        render json: { received_bytes: result.received_bytes, complete: result.complete }
+      end
+
+      def max_diff_upload_bytes
+        default = 64.megabytes
+        max = ENV.fetch("CONDUITS_DIFF_UPLOAD_MAX_BYTES", default).to_i
+        max.positive? ? max : default
+      end
+
+      def max_diff_chunk_bytes
+        default = 1.megabyte
+        max = ENV.fetch("CONDUITS_DIFF_CHUNK_MAX_BYTES", default).to_i
+        max.positive? ? max : default
+      end
+    end
+  end
+end
@@ -0,0 +1,55 @@
+module Conduits
+  module V1
+    class DirectiveInputsController < Conduits::V1::ApplicationController
+      MAX_WAIT_SECONDS = 20
+      MAX_EVENTS = 100
+      POLL_INTERVAL = 0.25
+
+      before_action :authenticate_directive!
+
+      # POST /conduits/v1/directives/:id/input
+      #
+      # Params: { after, wait_seconds }
+      # Long-poll for the terminal input of an interactive directive: answers
+      # with the events whose seq is greater than after as soon as there are
+      # any, or with none after wait_seconds (or once the directive ends).
+      def poll
+        unless current_directive.interactive?
+          render json: { error: "not_found", detail: "directive is not interactive" }, status: :not_found
+          return
+        end
+
+        unless current_directive.leased? || current_directive.running?
+          render json: { error: "invalid_state", detail: "directive is #{current_directive.state}, expected leased or running" },
+                 status: :conflict
+          return
+        end
+
+        after = Integer(params[:after])
+        raise ArgumentError, "after must be >= 0" if after < 0
+
+        wait = params[:wait_seconds].nil? ? 0 : Integer(params[:wait_seconds])
+        raise ArgumentError, "wait_seconds must be >= 0" if wait < 0
+
+        deadline = Time.current + [wait, MAX_WAIT_SECONDS].min.seconds
+        events = pending_events(after)
+        while events.empty? && Time.current < deadline
+          sleep POLL_INTERVAL
+          break if terminal_directive?(current_directive.reload)
+
+          events = pending_events(after)
+        end
+
+        render json: { events: events.map(&:as_event) }
+      rescue ArgumentError, TypeError => e
+        render json: { error: "invalid_param", detail: e.message }, status: :unprocessable_entity
+      end
+
+      private
+
+      def pending_events(after)
+        current_directive.inputs.where("seq > ?", after).order(:seq).limit(MAX_EVENTS).to_a
+      end
+    end
+  end
+end
@@ -0,0 +1,64 @@
+module Conduits
+  module V1
+    class DirectiveSecretsController < Conduits::V1::ApplicationController
+      before_action :authenticate_directive!
+
+      # Matches Nexus' cap on secret refs per directive.
+      MAX_REFS = 64
+
+      # POST /conduits/v1/directives/:id/secrets
+      #
+      # Params: { refs: [ref, ...] }
+      # Resolves refs granted to the directive by its effective capabilities
+      # (secrets.refs[].ref). Any other ref fails the whole request with 403.
+      def create
+        response.headers["Cache-Control"] = "no-store"
+
+        unless current_directive.leased? || current_directive.running?
+          render json: { error: "invalid_state", detail: "directive is #{current_directive.state}, expected leased or running" },
+                 status: :conflict
+          return
+        end
+
+        refs = params[:refs]
+        unless refs.is_a?(Array) && refs.any? && refs.all? { |ref| ref.is_a?(String) && ref.present? }
+          raise ArgumentError, "refs must be a non-empty array of strings"
+        end
+        raise ArgumentError, "too many refs (max #{MAX_REFS})" if refs.size > MAX_REFS
+
+        refs = refs.uniq
+        ungranted = refs - granted_refs
+        if ungranted.any?
+          render json: { error: "forbidden", detail: "secret refs not granted: #{ungranted.join(", ")}" },
+                 status: :forbidden
+          return
+        end
+
+        secrets = Conduits::Secret.where(account_id: current_directive.account_id, name: refs).index_by(&:name)
+        missing = refs - secrets.keys
+        if missing.any?
+          render json: { error: "not_found", detail: "secrets not found: #{missing.join(", ")}" },
+                 status: :not_found
+          return
+        end
+
+        Conduits::AuditService.new(account: current_directive.account, directive: current_directive)
+          .record("directive.secrets_resolved", payload: { "refs" => refs })
+
+        render json: { secrets: refs.map { |ref| { ref: ref, value: secrets[ref].value } } }
+      rescue ArgumentError => e
+        render json: { error: "invalid_param", detail: e.message }, status: :unprocessable_entity
+      end
+
+      private
+
+      def granted_refs
+        caps = current_directive.effective_capabilities
+        entries = caps.is_a?(Hash) ? caps.dig("secrets", "refs") : nil
+        return [] unless entries.is_a?(Array)
+
+        entries.filter_map { |entry| entry["ref"].to_s.presence if entry.is_a?(Hash) }
+      end
+    end
+  end
+end
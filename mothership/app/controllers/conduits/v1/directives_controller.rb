@@ -170,11 +170,11 @@ module Conduits
       #
       # Terminal state report from Nexus.
       # Params: { status, exit_code, stdout_truncated, stderr_truncated, diff_truncated,
-      #           snapshot_before, snapshot_after, artifacts_manifest, diff_base64, finished_at }
+      #           snapshot_before, snapshot_after, artifacts_manifest, egress_summary, diff_base64, finished_at }
       def finished
         status = params[:status].to_s.strip
-        unless %w[succeeded failed canceled timed_out].include?(status)
-          render json: { error: "invalid_param", detail: "status must be succeeded/failed/canceled/timed_out" },
+        unless %w[succeeded failed canceled timed_out disk_quota_exceeded].include?(status)
+          render json: { error: "invalid_param", detail: "status must be succeeded/failed/canceled/timed_out/disk_quota_exceeded" },
                  status: :unprocessable_entity
           return
         end
@@ -185,8 +185,20 @@ module Conduits
             value.nil? ? nil : Integer(value)
           end
 
+        egress_summary = params_to_h(params[:egress_summary], nil)
+        if params.key?("egress_summary") && !egress_summary.nil? && !egress_summary.is_a?(Hash)
+          raise ArgumentError, "egress_summary must be an object"
+        end
+
         diff_data = decode_diff_data(params[:diff_base64], max_bytes: current_directive.max_diff_bytes)
         diff_sha256 = diff_data.present? ? Digest::SHA256.hexdigest(diff_data) : nil
+        diff_bytesize = diff_data&.bytesize
+
+        # A diff sent through diff_chunks is already attached as diff_blob.
+        if diff_data.blank? && (upload = uploaded_diff(params_to_h(params[:artifacts_manifest], {})))
+          diff_sha256 = upload.sha256
+          diff_bytesize = upload.total_bytes
+        end
 
         result_hash = result_hash_for(
           status: status,
@@ -198,7 +210,7 @@ module Conduits
           snapshot_after: params[:snapshot_after],
           artifacts_manifest: params_to_h(params[:artifacts_manifest], {}),
           diff_sha256: diff_sha256,
-          diff_bytesize: diff_data&.bytesize
+          diff_bytesize: diff_bytesize
         )
 
         Conduits::Directive.transaction do
@@ -273,6 +285,7 @@ module Conduits
           attrs[:snapshot_before] = params[:snapshot_before] if params.key?("snapshot_before")
           attrs[:snapshot_after] = params[:snapshot_after] if params.key?("snapshot_after")
           attrs[:artifacts_manifest] = params_to_h(params[:artifacts_manifest]) if params.key?("artifacts_manifest")
+          attrs[:egress_summary] = egress_summary if params.key?("egress_summary")
           attrs[:finished_at] = Time.zone.parse(params[:finished_at]) if params[:finished_at].present?
           current_directive.assign_attributes(attrs)
 
@@ -280,6 +293,7 @@ module Conduits
           case status
           when "succeeded" then current_directive.succeed!
           when "failed"    then current_directive.fail!
+          when "disk_quota_exceeded" then current_directive.fail! # finished_status keeps the reason
           when "canceled"  then current_directive.cancel!
           when "timed_out" then current_directive.time_out!
           end
@@ -322,10 +336,6 @@ module Conduits
         value.to_s == "true"
       end
 
-      def terminal_directive?(directive)
-        directive.succeeded? || directive.failed? || directive.canceled? || directive.timed_out?
-      end
-
       def finished_status_matches?(directive, status)
         return true if directive.finished_status.to_s == status
 
@@ -340,6 +350,19 @@ module Conduits
         directive.facility.unlock!(directive)
       end
 
+      # The completed chunked upload referenced by artifacts_manifest.diff_upload.
+      def uploaded_diff(manifest)
+        ref = manifest["diff_upload"]
+        return nil unless ref.is_a?(Hash)
+
+        upload = current_directive.diff_upload
+        unless upload&.completed? && upload.sha256 == ref["sha256"].to_s
+          raise ArgumentError, "diff_upload does not match a completed upload"
+        end
+
+        upload
+      end
+
       def decode_diff_data(value, max_bytes:)
         return nil if value.blank?
 
@@ -352,22 +375,6 @@ module Conduits
         Base64.strict_decode64(b64)
       end
 
-      def base64_decoded_size(b64, field:)
-        len = b64.bytesize
-        raise ArgumentError, "#{field} must be base64" if (len % 4) != 0
-
-        padding =
-          if b64.end_with?("==")
-            2
-          elsif b64.end_with?("=")
-            1
-          else
-            0
-          end
-
-        (len / 4) * 3 - padding
-      end
-
       def max_log_chunk_bytes
         default = 256.kilobytes
         max = ENV.fetch("CONDUITS_LOG_CHUNK_MAX_BYTES", default).to_i
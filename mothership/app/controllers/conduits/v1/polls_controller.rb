@@ -3,7 +3,7 @@ module Conduits
     class PollsController < Conduits::V1::ApplicationController
       # POST /conduits/v1/polls
       #
-      # Params: { supported_sandbox_profiles, max_directives_to_claim }
+      # Params: { supported_sandbox_profiles, max_directives_to_claim, sessions }
       # Returns: { directives: [ { directive_id, directive_token, spec } ],
       #            lease_ttl_seconds, retry_after_seconds }
       def create
@@ -13,7 +13,8 @@ module Conduits
         result = Conduits::PollService.new(
           territory: current_territory,
           supported_profiles: profiles,
-          max_claims: max_claims
+          max_claims: max_claims,
+          sessions: Array(params[:sessions]).map(&:to_s)
         ).call
 
         render json: {
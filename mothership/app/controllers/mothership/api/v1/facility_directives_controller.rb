@@ -8,7 +8,9 @@ module Mothership
         #
         # Create a new directive for execution.
         # Params: { command, shell, cwd, sandbox_profile, timeout_seconds,
-        #           requested_capabilities, env_allowlist, env_refs, limits }
+        #           requested_capabilities, env_allowlist, env_refs, limits,
+        #           interactive: { rows, cols },
+        #           session: { id, idle_timeout_seconds, close } }
         def create
           sandbox_profile = params[:sandbox_profile] || "untrusted"
           requested_capabilities = params_to_h(params[:requested_capabilities])
@@ -24,6 +26,8 @@ module Mothership
             env_allowlist: params_to_h(params[:env_allowlist], []),
             env_refs: params_to_h(params[:env_refs], []),
             limits: params_to_h(params[:limits]),
+            interactive: params.key?(:interactive) ? params_to_h(params[:interactive]) : nil,
+            session: params.key?(:session) ? params_to_h(params[:session]) : nil,
             requested_by_user: current_user
           )
 
@@ -49,7 +53,12 @@ module Mothership
             return
           end
 
-          evaluation = Conduits::PolicyResolver.new(directive).call
+          begin
+            evaluation = Conduits::PolicyResolver.new(directive).call
+          rescue Conduits::NetPolicyV1::ParseError => e
+            render json: { error: "invalid_net_capability", details: e.message }, status: :unprocessable_entity
+            return
+          end
 
           # Combine command validation verdict with policy verdict (most restrictive wins)
           combined_verdict = combine_verdicts(evaluation.approval_verdict, cmd_result.verdict)
@@ -252,6 +261,52 @@ module Mothership
           }
         end
 
+        # POST /mothership/api/v1/facilities/:facility_id/directives/:id/input
+        #
+        # Send terminal input to an interactive directive.
+        # Params: { type: "data", bytes (base64) } | { type: "resize", rows, cols } | { type: "eof" }
+        # Input sent before the directive starts is delivered once it does.
+        def input
+          directive = @facility.directives.find(params[:id])
+
+          unless directive.interactive?
+            render json: { error: "not_interactive" }, status: :unprocessable_entity
+            return
+          end
+
+          unless directive.queued? || directive.awaiting_approval? || directive.leased? || directive.running?
+            render json: {
+              error: "state_conflict",
+              detail: "directive is #{directive.state}",
+            }, status: :conflict
+            return
+          end
+
+          event_type = params[:type].to_s
+          attrs = { event_type: event_type }
+          case event_type
+          when "data"
+            b64 = params[:bytes].to_s
+            if b64.bytesize > 4 * (Conduits::DirectiveInput::MAX_DATA_BYTES / 3 + 1)
+              render json: { error: "invalid_bytes", detail: "bytes too large" }, status: :unprocessable_entity
+              return
+            end
+            attrs[:bytes] = Base64.strict_decode64(b64)
+          when "resize"
+            attrs[:rows] = Integer(params[:rows])
+            attrs[:cols] = Integer(params[:cols])
+          end
+
+          event = Conduits::DirectiveInput.push!(directive, **attrs)
+
+          render json: { directive_id: directive.id, seq: event.seq }, status: :created
+        rescue ArgumentError, TypeError => e
+          render json: { error: "invalid_input", detail: e.message }, status: :unprocessable_entity
+        rescue ActiveRecord::RecordInvalid => e
+          render json: { error: "invalid_input", detail: e.record.errors.full_messages.join(", ") },
+                 status: :unprocessable_entity
+        end
+
         # GET /mothership/api/v1/facilities/:facility_id/directives
         def index
           directives = @facility.directives.order(created_at: :desc).limit(50)
@@ -285,6 +340,9 @@ module Mothership
             sandbox_profile: directive.sandbox_profile,
             exit_code: directive.exit_code,
             finished_status: directive.finished_status,
+            interactive: directive.interactive,
+            session: directive.session,
+            egress_summary: directive.egress_summary,
             territory_id: directive.territory_id,
             created_at: directive.created_at,
             updated_at: directive.updated_at,
@@ -0,0 +1,68 @@
+module Mothership
+  module API
+    module V1
+      class SecretsController < BaseController
+        before_action :set_secret, only: %i[update destroy]
+
+        # GET /mothership/api/v1/secrets
+        #
+        # Lists the account's secret names. Values are write-only.
+        def index
+          secrets = Conduits::Secret.where(account: @current_account).order(:name)
+
+          render json: { secrets: secrets.map { |s| secret_json(s) } }
+        end
+
+        # POST /mothership/api/v1/secrets
+        #
+        # Params: { name, value }
+        def create
+          secret = Conduits::Secret.new(account: @current_account, name: params[:name], value: params[:value])
+
+          if secret.save
+            render json: secret_json(secret), status: :created
+          else
+            render json: { error: "validation_failed", details: secret.errors.full_messages },
+                   status: :unprocessable_entity
+          end
+        end
+
+        # PATCH/PUT /mothership/api/v1/secrets/:id
+        #
+        # Params: { value }. Replaces the value; the name is immutable.
+        def update
+          if @secret.update(value: params[:value])
+            render json: secret_json(@secret)
+          else
+            render json: { error: "validation_failed", details: @secret.errors.full_messages },
+                   status: :unprocessable_entity
+          end
+        end
+
+        # DELETE /mothership/api/v1/secrets/:id
+        def destroy
+          @secret.destroy!
+
+          render json: { id: @secret.id, deleted: true }
+        end
+
+        private
+
+        def set_secret
+          @secret = Conduits::Secret.where(account: @current_account).find(params[:id])
+        rescue ActiveRecord::RecordNotFound
+          render json: { error: "not_found", detail: "secret not found" }, status: :not_found
+        end
+
+        def secret_json(secret)
+          {
+            id: secret.id,
+            name: secret.name,
+            created_at: secret.created_at,
+            updated_at: secret.updated_at,
+          }
+        end
+      end
+    end
+  end
+end
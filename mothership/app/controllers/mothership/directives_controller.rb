@@ -80,6 +80,18 @@ module Mothership
       redirect_to rails_blob_path(directive.diff_blob, disposition: "attachment")
     end
 
+    # GET /mothership/directives/:id/artifact?path=
+    def artifact
+      directive = Conduits::Directive.find(params[:id])
+      artifact = directive.artifacts.find_by(path: params[:path].to_s)
+      unless artifact&.file&.attached?
+        render plain: "artifact not found", status: :not_found
+        return
+      end
+
+      redirect_to rails_blob_path(artifact.file, disposition: "attachment")
+    end
+
     private
 
     def int_param(name, default:)
@@ -0,0 +1,123 @@
+module Conduits
+  # NetCapabilityV1.http_rules：HTTP 方法/路径规则（design 03 §7.9）
+  #
+  #   <METHOD|*> <http|https>://<host>[:port]<path-glob>
+  #
+  # 重要语义（与 Go 端 netpolicy.ParseHTTPRule 同构，共用测试向量
+  # nexus/docs/protocol/directivespec_capabilities_net.http_rules.v1.json 的 parse 部分）：
+  # - 方法不区分大小写，规范形式为大写；* 匹配任意方法
+  # - host 为精确主机名（不允许 *. 通配与 IP 字面量）；缺省端口（http 80 / https 443）在规范形式中省略
+  # - 路径 glob 以 / 开头，不含 ? 与 #（不匹配 query）；不允许连续三个以上的 *
+  # - 判定只在 Nexus 侧执行；Mothership 只做校验、规范化与多 policy 合并
+  module NetHttpRulesV1
+    ParseError = NetPolicyV1::ParseError
+
+    METHOD_RE = /\A([A-Za-z]+|\*)\z/
+    PORT_RE = /\A\d{1,5}\z/
+    PATH_RE = %r{\A/[!-~]*\z}
+    DEFAULT_PORTS = { "http" => 80, "https" => 443 }.freeze
+
+    Rule = Struct.new(:raw, :http_method, :scheme, :host, :port, :path, keyword_init: true) do
+      def origin
+        "#{scheme}://#{host}#{port == DEFAULT_PORTS[scheme] ? "" : ":#{port}"}"
+      end
+
+      def to_s
+        "#{http_method} #{origin}#{path}"
+      end
+    end
+
+    module_function
+
+    def parse_rule(str)
+      raw = str.to_s.strip
+      method, target = raw.split(/ /, 2)
+      raise ParseError, "invalid http rule #{raw.inspect}, expected \"<METHOD> <url>\"" if target.nil? || !METHOD_RE.match?(method)
+
+      scheme, rest = target.strip.split("://", 2)
+      raise ParseError, "invalid http rule #{raw.inspect}: scheme must be http or https" if rest.nil? || !DEFAULT_PORTS.key?(scheme)
+
+      idx = rest.index("/")
+      raise ParseError, "invalid http rule #{raw.inspect}: missing path" if idx.nil?
+
+      authority = rest[0...idx]
+      glob = rest[idx..]
+
+      port = DEFAULT_PORTS.fetch(scheme)
+      host_part = authority
+      colon = authority.rindex(":")
+      if colon && !authority.include?("]")
+        host_part = authority[0...colon]
+        port_part = authority[(colon + 1)..]
+        raise ParseError, "invalid http rule #{raw.inspect}: invalid port #{port_part.inspect}" unless PORT_RE.match?(port_part)
+
+        port = Integer(port_part, 10)
+      end
+      raise ParseError, "invalid http rule #{raw.inspect}: port out of range: #{port}" unless (1..65_535).cover?(port)
+
+      if NetPolicyV1.ip_literal?(host_part) || host_part.start_with?("*.")
+        raise ParseError, "invalid http rule #{raw.inspect}: host must be an exact name"
+      end
+
+      host = NetPolicyV1.normalize_host(host_part)
+      raise ParseError, "invalid http rule #{raw.inspect}: invalid host #{host_part.inspect}" unless NetPolicyV1::HOST_RE.match?(host)
+
+      if !PATH_RE.match?(glob) || glob.match?(/[?#]/) || glob.include?("***")
+        raise ParseError, "invalid http rule #{raw.inspect}: invalid path glob #{glob.inspect}"
+      end
+
+      Rule.new(raw: raw, http_method: method.upcase, scheme: scheme, host: host, port: port, path: glob)
+    end
+
+    # Returns rules ({"allow" => [...], "deny" => [...]}, string or symbol
+    # keys) with every rule in canonical form and deduplicated, or nil when
+    # there are none. Raises ParseError on an invalid rule.
+    def canonicalize(rules)
+      return nil if rules.blank?
+      raise ParseError, "http_rules must be a hash" unless rules.respond_to?(:to_h)
+
+      h = rules.to_h.transform_keys(&:to_s)
+      unknown = h.keys - %w[allow deny]
+      raise ParseError, "unknown http_rules keys: #{unknown.join(", ")}" if unknown.any?
+
+      out = {}
+      %w[allow deny].each do |k|
+        list = Array(h[k]).map { |r| parse_rule(r).to_s }.uniq
+        out[k] = list if list.any?
+      end
+      out.presence
+    end
+
+    # Merges the rules of two policies into a ceiling no looser than either
+    # (canonical form, nil when there are none): deny lists are united;
+    # allow rules are kept per origin, and where both sides have allow rules
+    # for an origin only the rules in both remain (none left denies the
+    # whole origin).
+    def merge(existing, incoming)
+      existing = canonicalize(existing)
+      incoming = canonicalize(incoming)
+      return incoming if existing.nil?
+      return existing if incoming.nil?
+
+      deny = Array(existing["deny"]) + Array(incoming["deny"])
+      ours = Array(existing["allow"]).group_by { |r| parse_rule(r).origin }
+      theirs = Array(incoming["allow"]).group_by { |r| parse_rule(r).origin }
+
+      allow = []
+      (ours.keys | theirs.keys).each do |origin|
+        if ours.key?(origin) && theirs.key?(origin)
+          common = ours[origin] & theirs[origin]
+          deny << "* #{origin}/**" if common.empty?
+          allow.concat(common)
+        else
+          allow.concat(ours[origin] || theirs[origin])
+        end
+      end
+
+      out = {}
+      out["allow"] = allow.uniq if allow.any?
+      out["deny"] = deny.uniq if deny.any?
+      out.presence
+    end
+  end
+end
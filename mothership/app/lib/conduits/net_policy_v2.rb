@@ -0,0 +1,180 @@
+require "ipaddr"
+
+module Conduits
+  # V2: allowlist 条目语法（allowlist_version: 2，design 03 §7.6.6）
+  #
+  #   [private:]<target>:<port>[-<port>][/udp]
+  #
+  # 重要语义（与 Go 端 netpolicy.ParseAllowlistEntryV2 / MatchV2 同构，共用测试向量
+  # nexus/docs/protocol/directivespec_capabilities_net.allowlist.v2.json）：
+  # - target 为 V1 host（含 *. 通配）、IPv4 地址/CIDR，或方括号包裹的 IPv6 地址/CIDR
+  # - V1 条目在 V2 下含义与规范形式不变
+  # - 端口区间 lo-hi（闭区间，lo <= hi）；lo == hi 规范化为单端口
+  # - 默认只匹配 TCP；/udp 限定的条目只匹配 UDP（SOCKS5 UDP ASSOCIATE）；/tcp 可写，规范形式省略
+  # - CIDR 不得带 host 位；IP/CIDR 条目只匹配 IP 字面量目的地
+  # - private: 才能访问私网（仅 OPT_IN_RANGES）；落在私网内的 IP/CIDR 条目必须带 private:，
+  #   落在 loopback/link-local/保留段内的条目一律拒绝
+  module NetPolicyV2
+    ParseError = NetPolicyV1::ParseError
+
+    PRIVATE_OPT_IN = "private:"
+
+    PROTO_TCP = "tcp"
+    PROTO_UDP = "udp"
+
+    # 与 Go 端 netpolicy.privateRanges / optInRanges 一致
+    PRIVATE_RANGES = %w[
+      0.0.0.0/8 10.0.0.0/8 172.16.0.0/12 192.168.0.0/16 127.0.0.0/8 169.254.0.0/16
+      100.64.0.0/10 224.0.0.0/4 240.0.0.0/4 ::1/128 fc00::/7 fe80::/10
+    ].map { |c| IPAddr.new(c) }.freeze
+    OPT_IN_RANGES = %w[10.0.0.0/8 172.16.0.0/12 192.168.0.0/16 100.64.0.0/10 fc00::/7].map { |c| IPAddr.new(c) }.freeze
+
+    PORTS_RE = /\A(?<from>\d{1,5})(?:-(?<to>\d{1,5}))?\z/
+
+    Entry = Struct.new(:raw, :host, :wildcard, :network, :port_from, :port_to, :proto, :private, keyword_init: true) do
+      def to_s
+        target =
+          if network.nil?
+            "#{wildcard ? "*." : ""}#{host}"
+          else
+            addr = network.prefix == (network.ipv4? ? 32 : 128) ? network.to_s : "#{network}/#{network.prefix}"
+            network.ipv6? ? "[#{addr}]" : addr
+          end
+        ports = port_from == port_to ? port_from.to_s : "#{port_from}-#{port_to}"
+        "#{private ? PRIVATE_OPT_IN : ""}#{target}:#{ports}#{proto == PROTO_UDP ? "/#{PROTO_UDP}" : ""}"
+      end
+    end
+
+    module_function
+
+    # Returns a parser for the allowlist_version (nil/1: V1, 2: V2). Both
+    # yield Entry (V2 form).
+    def parser_for(version)
+      case version
+      when nil, 1 then ->(s) { from_v1(NetPolicyV1.parse_entry(s)) }
+      when 2 then ->(s) { parse_entry(s) }
+      else raise ParseError, "unsupported allowlist_version: #{version.inspect}"
+      end
+    end
+
+    def parse_allowlist(version, list)
+      parse = parser_for(version)
+      Array(list).map { |e| parse.call(e) }
+    end
+
+    def from_v1(entry)
+      Entry.new(raw: entry.raw, host: entry.host, wildcard: entry.wildcard, network: nil,
+                port_from: entry.port, port_to: entry.port, proto: PROTO_TCP, private: false)
+    end
+
+    def parse_entry(str)
+      raw = str.to_s.strip
+      rest = raw
+      private = rest.start_with?(PRIVATE_OPT_IN)
+      rest = rest.delete_prefix(PRIVATE_OPT_IN) if private
+
+      idx = rest.rindex(":")
+      raise ParseError, "invalid entry format, expected host:port: #{raw.inspect}" if idx.nil? || idx.zero? || idx == rest.length - 1
+
+      host_part = rest[0...idx]
+      port_part, qualifier = rest[(idx + 1)..].split("/", 2)
+      proto = qualifier.nil? ? PROTO_TCP : qualifier
+      raise ParseError, "unsupported protocol #{qualifier.inspect}" unless [PROTO_TCP, PROTO_UDP].include?(proto)
+
+      port_from, port_to = parse_ports(port_part.to_s)
+
+      unless NetPolicyV1.ip_literal?(host_part) || host_part.include?("/")
+        v1 = NetPolicyV1.parse_entry("#{host_part}:#{port_from}")
+        return Entry.new(raw: raw, host: v1.host, wildcard: v1.wildcard, network: nil,
+                         port_from: port_from, port_to: port_to, proto: proto, private: private)
+      end
+
+      network = parse_ip_target(host_part)
+      if within?(network, PRIVATE_RANGES)
+        unless within?(network, OPT_IN_RANGES)
+          raise ParseError, "#{host_part} cannot be allowed: loopback, link-local and reserved ranges are never reachable"
+        end
+        raise ParseError, "#{host_part} is a private range and requires the #{PRIVATE_OPT_IN.inspect} opt-in" unless private
+      end
+
+      Entry.new(raw: raw, host: nil, wildcard: false, network: network,
+                port_from: port_from, port_to: port_to, proto: proto, private: private)
+    end
+
+    def parse_ports(str)
+      m = PORTS_RE.match(str)
+      raise ParseError, "invalid port #{str.inspect}" unless m
+
+      from = Integer(m[:from], 10)
+      to = m[:to] ? Integer(m[:to], 10) : from
+      [from, to].each { |p| raise ParseError, "port out of range: #{p}" unless (1..65_535).cover?(p) }
+      raise ParseError, "invalid port range #{str.inspect}" if from > to
+
+      [from, to]
+    end
+
+    def parse_ip_target(str)
+      target = str
+      if str.start_with?("[") && str.end_with?("]")
+        target = str[1..-2]
+        raise ParseError, "only IPv6 may be bracketed: #{str.inspect}" unless target.include?(":")
+      elsif str.include?(":")
+        raise ParseError, "IPv6 must be bracketed: #{str.inspect}"
+      end
+      raise ParseError, "invalid IP address #{str.inspect}" if target.include?("%")
+
+      addr, len = target.split("/", 2)
+      ip = IPAddr.new(addr)
+      raise ParseError, "invalid IP address #{str.inspect}" if ip.ipv6? && ip.ipv4_mapped?
+
+      return ip if len.nil?
+
+      raise ParseError, "invalid CIDR #{str.inspect}" unless len.match?(/\A\d{1,3}\z/)
+
+      network = ip.mask(Integer(len, 10))
+      raise ParseError, "CIDR #{str.inspect} has host bits set" unless network.to_i == ip.to_i
+
+      network
+    rescue IPAddr::Error, ArgumentError
+      raise ParseError, "invalid IP address or CIDR #{str.inspect}"
+    end
+
+    # Whether all of network lies inside one of ranges.
+    def within?(network, ranges)
+      ranges.any? { |r| r.family == network.family && r.prefix <= network.prefix && r.include?(network) }
+    end
+
+    def match_host?(entry, dest_host)
+      if entry.network.nil?
+        host = NetPolicyV1.normalize_host(dest_host)
+        return host.end_with?(".#{entry.host}") if entry.wildcard
+
+        return host == entry.host
+      end
+
+      ip = IPAddr.new(dest_host.to_s.strip.delete_prefix("[").delete_suffix("]"))
+      ip = ip.native if ip.ipv6? && ip.ipv4_mapped?
+      entry.network.family == ip.family && entry.network.include?(ip)
+    rescue IPAddr::Error, ArgumentError
+      false
+    end
+
+    def match?(entry, dest_host, dest_port, proto: PROTO_TCP)
+      port = Integer(dest_port.to_s, 10)
+      entry.proto == proto && (entry.port_from..entry.port_to).cover?(port) && match_host?(entry, dest_host)
+    rescue ArgumentError, TypeError
+      false
+    end
+
+    def private_ip?(ip)
+      ip = IPAddr.new(ip.to_s)
+      PRIVATE_RANGES.any? { |r| r.family == ip.family && r.include?(ip) }
+    end
+
+    def opt_in_private_ip?(ip)
+      ip = IPAddr.new(ip.to_s)
+      ip = ip.native if ip.ipv6? && ip.ipv4_mapped?
+      OPT_IN_RANGES.any? { |r| r.family == ip.family && r.include?(ip) }
+    end
+  end
+end
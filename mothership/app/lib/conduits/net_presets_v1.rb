@@ -0,0 +1,103 @@
+module Conduits
+  # NetCapabilityV1 preset 展开（design 03 §7.1.1）
+  #
+  # 重要语义（与 Go 端 netpolicy.ExpandPreset 同构，共用测试向量
+  # nexus/docs/protocol/directivespec_capabilities_net.presets.v1.json）：
+  # - effective allow = preset 的 allowlist + capability 自身的 allow（保持顺序）
+  # - mode 取 capability 与 preset 中更严格的一档（preset 不会放宽 mode）
+  # - allow 条目按 allowlist_version 规范化（NetPolicyV2::Entry#to_s）并去重；preset 条目两种语法下均合法；
+  #   非 allowlist mode 下去掉 allow
+  # - http_rules 规范化并去重（NetHttpRulesV1.canonicalize），mode none 下去掉
+  # - "custom" / 未指定 preset：不引入基础策略，只做规范化
+  # - 对已展开的 capability 再次展开结果不变
+  module NetPresetsV1
+    PRESETS = {
+      "off" => { "mode" => "unrestricted" },
+      "loose" => {
+        "mode" => "allowlist",
+        "allow" => [
+          # GitHub
+          "github.com:443",
+          "api.github.com:443",
+          "codeload.github.com:443",
+          "*.githubusercontent.com:443",
+          "ghcr.io:443",
+          # Ruby
+          "rubygems.org:443",
+          "index.rubygems.org:443",
+          "cache.ruby-lang.org:443",
+          # JavaScript
+          "registry.npmjs.org:443",
+          "registry.yarnpkg.com:443",
+          "nodejs.org:443",
+          # Python
+          "pypi.org:443",
+          "files.pythonhosted.org:443",
+          # Go
+          "proxy.golang.org:443",
+          "sum.golang.org:443",
+          "go.dev:443",
+          "dl.google.com:443",
+          # Rust
+          "crates.io:443",
+          "index.crates.io:443",
+          "static.crates.io:443",
+          "static.rust-lang.org:443",
+          # Java
+          "repo.maven.apache.org:443",
+          "repo1.maven.org:443",
+        ].freeze,
+      }.freeze,
+      "strict" => {
+        "mode" => "allowlist",
+        "allow" => [
+          "github.com:443",
+          "api.github.com:443",
+          "codeload.github.com:443",
+          "objects.githubusercontent.com:443",
+        ].freeze,
+      }.freeze,
+      "no_external" => { "mode" => "none" }.freeze,
+    }.freeze
+
+    MODE_RANK = { "none" => 0, "allowlist" => 1, "unrestricted" => 2 }.freeze
+
+    module_function
+
+    def preset_for(name)
+      PRESETS[name.to_s]
+    end
+
+    # Returns the effective capability hash for net (string keys).
+    # Raises NetPolicyV1::ParseError on an invalid mode, preset or entry.
+    def expand(net)
+      out = net.to_h.transform_keys(&:to_s)
+      mode = out["mode"].to_s
+      raise NetPolicyV1::ParseError, "invalid net mode: #{mode.inspect}" unless MODE_RANK.key?(mode)
+
+      parse = NetPolicyV2.parser_for(out["allowlist_version"])
+
+      base = []
+      preset = out["preset"].to_s
+      unless preset.empty? || preset == "custom"
+        spec = PRESETS.fetch(preset) { raise NetPolicyV1::ParseError, "unknown net preset: #{preset.inspect}" }
+        mode = spec["mode"] if MODE_RANK.fetch(spec["mode"]) < MODE_RANK.fetch(mode)
+        base = spec.fetch("allow", [])
+      end
+
+      out["mode"] = mode
+      http_rules = mode == "none" ? nil : NetHttpRulesV1.canonicalize(out["http_rules"])
+      if http_rules
+        out["http_rules"] = http_rules
+      else
+        out.delete("http_rules")
+      end
+      if mode == "allowlist"
+        out["allow"] = (base + Array(out["allow"])).map { |e| parse.call(e).to_s }.uniq
+      else
+        out.delete("allow")
+      end
+      out
+    end
+  end
+end
@@ -16,14 +16,18 @@ module Conduits
     SNI_MISMATCH     = "SNI_MISMATCH"
     DNS_DENIED       = "DNS_DENIED"
     POLICY_EXPIRED   = "POLICY_EXPIRED"      # reserved (future)
+    GRANT_EXPIRED    = "GRANT_EXPIRED"       # ttl_seconds 已过（自 directive 开始计）
+    CONN_LIMIT_EXCEEDED = "CONN_LIMIT_EXCEEDED"          # 并发连接数达到上限
+    TRANSFER_LIMIT_EXCEEDED = "TRANSFER_LIMIT_EXCEEDED"  # 累计流量达到上限
+    HTTP_RULE_DENIED = "HTTP_RULE_DENIED"    # HTTP 请求被 http_rules 拒绝
     APPROVAL_REQUIRED = "APPROVAL_REQUIRED"  # reserved (future)
     INTERNAL_ERROR   = "INTERNAL_ERROR"
     OTHER            = "OTHER"
 
     ALL = [
       OK, NET_MODE_NONE, NOT_IN_ALLOWLIST, PORT_NOT_ALLOWED, INVALID_DESTINATION,
-      PROXY_REQUIRED, SNI_MISMATCH, DNS_DENIED, POLICY_EXPIRED, APPROVAL_REQUIRED,
-      INTERNAL_ERROR, OTHER,
+      PROXY_REQUIRED, SNI_MISMATCH, DNS_DENIED, POLICY_EXPIRED, GRANT_EXPIRED,
+      CONN_LIMIT_EXCEEDED, TRANSFER_LIMIT_EXCEEDED, HTTP_RULE_DENIED, APPROVAL_REQUIRED, INTERNAL_ERROR, OTHER,
     ].freeze
   end
 
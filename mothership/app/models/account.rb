@@ -5,6 +5,7 @@ class Account < ApplicationRecord
   has_many :facilities,  class_name: "Conduits::Facility",  dependent: :restrict_with_error
   has_many :directives,  class_name: "Conduits::Directive",  dependent: :restrict_with_error
   has_many :policies,    class_name: "Conduits::Policy",     dependent: :restrict_with_error
+  has_many :secrets,     class_name: "Conduits::Secret",     dependent: :destroy
 
   validates :name, presence: true
 end
@@ -0,0 +1,21 @@
+module Conduits
+  # A diff too large for finished's diff_base64, sent by Nexus in chunks.
+  # Chunks are kept until received_bytes reaches total_bytes; the assembled
+  # diff then becomes the directive's diff_blob and the chunks are dropped.
+  class DiffUpload < ApplicationRecord
+    self.table_name = "conduits_diff_uploads"
+
+    belongs_to :directive, class_name: "Conduits::Directive", inverse_of: :diff_upload
+
+    has_many :chunks, class_name: "Conduits::DiffUploadChunk",
+             foreign_key: :diff_upload_id, inverse_of: :diff_upload, dependent: :delete_all
+
+    validates :sha256, presence: true, format: { with: /\A[0-9a-f]{64}\z/ }
+    validates :total_bytes, presence: true, numericality: { greater_than: 0 }
+    validates :received_bytes, presence: true, numericality: { greater_than_or_equal_to: 0 }
+
+    def completed?
+      completed_at.present?
+    end
+  end
+end
@@ -0,0 +1,11 @@
+module Conduits
+  class DiffUploadChunk < ApplicationRecord
+    self.table_name = "conduits_diff_upload_chunks"
+
+    belongs_to :diff_upload, class_name: "Conduits::DiffUpload", inverse_of: :chunks
+
+    validates :offset, presence: true, numericality: { greater_than_or_equal_to: 0 }
+    validates :bytes, presence: true
+    validates :bytesize, presence: true, numericality: { greater_than: 0 }
+  end
+end
@@ -4,6 +4,9 @@ module Conduits
 
     self.table_name = "conduits_directives"
 
+    # Session ids use the facility id charset, as Nexus enforces.
+    SESSION_ID_FORMAT = /\A[A-Za-z0-9_-]{1,128}\z/
+
     belongs_to :account
     belongs_to :facility,  class_name: "Conduits::Facility",  inverse_of: :directives
     belongs_to :territory, class_name: "Conduits::Territory", inverse_of: :directives,
@@ -18,10 +21,20 @@ module Conduits
              foreign_key: :directive_id, inverse_of: :directive, dependent: :delete_all
     has_many :audit_events, class_name: "Conduits::AuditEvent",
              foreign_key: :directive_id, inverse_of: :directive, dependent: :delete_all
+    has_many :egress_audit_batches, class_name: "Conduits::EgressAuditBatch",
+             foreign_key: :directive_id, inverse_of: :directive, dependent: :delete_all
+    has_many :inputs, class_name: "Conduits::DirectiveInput",
+             foreign_key: :directive_id, inverse_of: :directive, dependent: :delete_all
+    has_many :artifacts, class_name: "Conduits::DirectiveArtifact",
+             foreign_key: :directive_id, inverse_of: :directive, dependent: :destroy
+    has_one :diff_upload, class_name: "Conduits::DiffUpload",
+            foreign_key: :directive_id, inverse_of: :directive, dependent: :destroy
 
     validates :sandbox_profile, presence: true,
               inclusion: { in: %w[untrusted trusted host darwin-automation] }
     validates :command, presence: true
+    validate :interactive_spec_valid
+    validate :session_spec_valid
 
     aasm column: :state do
       state :queued, initial: true
@@ -80,6 +93,7 @@ module Conduits
     scope :assignable, -> { where(state: :queued) }
     scope :pending_approval, -> { where(state: :awaiting_approval) }
     scope :with_expired_lease, -> { where(state: :leased).where("lease_expires_at < ?", Time.current) }
+    scope :in_session, ->(session_id) { where("session ->> 'id' = ?", session_id) }
 
     def lease_expired?
       leased? && lease_expires_at.present? && lease_expires_at < Time.current
@@ -102,6 +116,21 @@ module Conduits
       max
     end
 
+    # Interactive directives (spec.interactive) run on a PTY fed from inputs.
+    def interactive?
+      interactive.present?
+    end
+
+    # Session directives (spec.session) run in a sandbox kept open on the
+    # territory across the directives sharing the session id.
+    def session?
+      session.present?
+    end
+
+    def session_id
+      session&.dig("id")
+    end
+
     def cancel_requested?
       cancel_requested_at.present?
     end
@@ -118,6 +147,44 @@ module Conduits
 
     private
 
+    # interactive is { rows, cols }: the initial terminal size, each 1..1000
+    # or omitted for the 24x80 default.
+    def interactive_spec_valid
+      return if interactive.nil?
+
+      unless interactive.is_a?(Hash) && (interactive.keys.map(&:to_s) - %w[rows cols]).empty?
+        errors.add(:interactive, "must be an object with rows and cols")
+        return
+      end
+
+      interactive.each do |key, value|
+        next if value.nil?
+        next if value.is_a?(Integer) && value.between?(1, Conduits::DirectiveInput::MAX_TERMINAL_SIZE)
+
+        errors.add(:interactive, "#{key} must be between 1 and #{Conduits::DirectiveInput::MAX_TERMINAL_SIZE}")
+      end
+    end
+
+    # session is { id, idle_timeout_seconds, close }: the id uses the facility
+    # id charset (at most 128 characters); the rest are optional.
+    def session_spec_valid
+      return if session.nil?
+
+      unless session.is_a?(Hash) && (session.keys.map(&:to_s) - %w[id idle_timeout_seconds close]).empty?
+        errors.add(:session, "must be an object with id, idle_timeout_seconds and close")
+        return
+      end
+
+      errors.add(:session, "id is invalid") unless session["id"].is_a?(String) && session["id"].match?(SESSION_ID_FORMAT)
+
+      timeout = session["idle_timeout_seconds"]
+      unless timeout.nil? || (timeout.is_a?(Integer) && timeout.positive?)
+        errors.add(:session, "idle_timeout_seconds must be a positive integer")
+      end
+
+      errors.add(:session, "close must be a boolean") unless [nil, true, false].include?(session["close"])
+    end
+
     def territory_assigned?
       territory_id.present?
     end
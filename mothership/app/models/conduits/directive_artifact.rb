@@ -0,0 +1,35 @@
+module Conduits
+  # A file collected by Nexus via artifacts.collect and uploaded before the
+  # directive finished. One per (directive, path); the content is an
+  # Active Storage blob.
+  class DirectiveArtifact < ApplicationRecord
+    self.table_name = "conduits_directive_artifacts"
+
+    MAX_PATH_BYTES = 4096
+
+    belongs_to :directive, class_name: "Conduits::Directive", inverse_of: :artifacts
+
+    has_one_attached :file
+
+    validates :path, presence: true, uniqueness: { scope: :directive_id }
+    validates :size, presence: true, numericality: { greater_than_or_equal_to: 0 }
+    validates :sha256, presence: true, format: { with: /\A[0-9a-f]{64}\z/ }
+    validates :mode, format: { with: /\A0?[0-7]{3,4}\z/ }, allow_nil: true
+    validate :path_must_be_relative
+
+    # Facility-relative, slash-separated, without empty, "." or ".." segments.
+    def self.valid_path?(path)
+      path = path.to_s
+      return false if path.empty? || path.bytesize > MAX_PATH_BYTES
+      return false if path.start_with?("/") || path.include?("\\") || path.include?("\0")
+
+      path.split("/", -1).none? { |segment| segment.empty? || segment == "." || segment == ".." }
+    end
+
+    private
+
+    def path_must_be_relative
+      errors.add(:path, "must be a facility-relative path") unless self.class.valid_path?(path)
+    end
+  end
+end
@@ -0,0 +1,41 @@
+module Conduits
+  # One terminal input event of an interactive directive, numbered from 1 in
+  # the order the user sent it. Nexus long-polls these through the input
+  # endpoint and delivers each seq once.
+  class DirectiveInput < ApplicationRecord
+    self.table_name = "conduits_directive_inputs"
+
+    EVENT_TYPES = %w[data resize eof].freeze
+    MAX_DATA_BYTES = 64.kilobytes
+    MAX_TERMINAL_SIZE = 1000
+
+    belongs_to :directive, class_name: "Conduits::Directive", inverse_of: :inputs
+
+    validates :seq, presence: true, numericality: { greater_than: 0 }
+    validates :event_type, presence: true, inclusion: { in: EVENT_TYPES }
+    validates :bytes, presence: true, length: { maximum: MAX_DATA_BYTES }, if: -> { event_type == "data" }
+    validates :rows, :cols, presence: true,
+              numericality: { only_integer: true, greater_than: 0, less_than_or_equal_to: MAX_TERMINAL_SIZE },
+              if: -> { event_type == "resize" }
+
+    # Appends an event to directive's input, assigning the next seq.
+    def self.push!(directive, event_type:, bytes: nil, rows: nil, cols: nil)
+      Directive.transaction do
+        directive.lock!
+        seq = directive.inputs.maximum(:seq).to_i + 1
+        directive.inputs.create!(seq: seq, event_type: event_type, bytes: bytes, rows: rows, cols: cols)
+      end
+    end
+
+    # The event as sent to Nexus (protocol.InputEvent).
+    def as_event
+      {
+        seq: seq,
+        type: event_type,
+        bytes: bytes && Base64.strict_encode64(bytes),
+        rows: rows,
+        cols: cols,
+      }.compact
+    end
+  end
+end
@@ -0,0 +1,14 @@
+module Conduits
+  # One batch of egress proxy / DNS resolver decisions uploaded by Nexus
+  # (audit_events endpoint). Distinct from AuditEvent, which records
+  # Mothership-side lifecycle events.
+  class EgressAuditBatch < ApplicationRecord
+    self.table_name = "conduits_egress_audit_batches"
+
+    belongs_to :directive, class_name: "Conduits::Directive", inverse_of: :egress_audit_batches
+
+    validates :seq, presence: true, numericality: { greater_than_or_equal_to: 0 }
+    validates :event_count, numericality: { greater_than_or_equal_to: 0 }
+    validates :denied_count, numericality: { greater_than_or_equal_to: 0 }
+  end
+end
@@ -93,30 +93,44 @@ module Conduits
         end
       end
 
-      # Net: restrictive ceiling — lower mode rank wins, allow lists intersect
+      # Net: restrictive ceiling — lower mode rank wins, allow lists intersect,
+      # http_rules merge (NetHttpRulesV1.merge)
       NET_MODE_RANK = { "none" => 0, "allowlist" => 1, "unrestricted" => 2 }.freeze
 
       def merge_net!(result, policy)
         return if policy.net.blank?
 
+        # Expand presets so allow lists intersect on their effective entries.
+        incoming = policy.net["preset"].present? ? NetPresetsV1.expand(policy.net) : policy.net.deep_dup
+        http_rules = NetHttpRulesV1.merge(result[:net]["http_rules"], incoming["http_rules"])
+
         if result[:net].empty?
-          result[:net] = policy.net.deep_dup
+          result[:net] = incoming
           return
         end
 
         existing_rank = NET_MODE_RANK.fetch(result[:net]["mode"], 2)
-        incoming_rank = NET_MODE_RANK.fetch(policy.net["mode"], 2)
+        incoming_rank = NET_MODE_RANK.fetch(incoming["mode"], 2)
 
         if incoming_rank < existing_rank
           # Incoming is more restrictive — take it entirely
-          result[:net] = policy.net.deep_dup
+          result[:net] = incoming
         elsif incoming_rank == existing_rank && result[:net]["mode"] == "allowlist"
           # Same rank + both allowlist → intersect allow lists
           existing_allow = Set.new(Array(result[:net]["allow"]))
-          incoming_allow = Set.new(Array(policy.net["allow"]))
+          incoming_allow = Set.new(Array(incoming["allow"]))
           result[:net]["allow"] = (existing_allow & incoming_allow).to_a
+          # No longer a preset's allowlist; Nexus must not expand it again.
+          result[:net]["preset"] = "custom" if result[:net].key?("preset")
         end
         # If incoming_rank > existing_rank, keep existing (already more restrictive)
+
+        # Either side's HTTP rules still apply; mode none has nothing to filter.
+        if http_rules && result[:net]["mode"] != "none"
+          result[:net]["http_rules"] = http_rules
+        else
+          result[:net].delete("http_rules")
+        end
       end
 
       # Secrets: priority replace (higher priority wins)
@@ -226,6 +240,14 @@ module Conduits
       if net.key?("mode") && !VALID_NET_MODES.include?(net["mode"])
         errors.add(:net, "mode must be one of: #{VALID_NET_MODES.join(", ")}")
       end
+
+      if net["preset"].present? || net.key?("allowlist_version") || net.key?("http_rules")
+        begin
+          NetPresetsV1.expand(net)
+        rescue NetPolicyV1::ParseError => e
+          errors.add(:net, e.message)
+        end
+      end
     end
 
     def validate_approval_structure
@@ -0,0 +1,28 @@
+module Conduits
+  # An account-level secret value, handed to Nexus for directives whose
+  # effective capabilities name it in secrets.refs. Values are encrypted at
+  # rest and never rendered by the user API.
+  class Secret < ApplicationRecord
+    self.table_name = "conduits_secrets"
+
+    NAME_FORMAT = %r{\A[A-Za-z0-9][A-Za-z0-9._/-]{0,127}\z}
+    # Matches Nexus' per-secret limit.
+    MAX_VALUE_BYTES = 64.kilobytes
+
+    belongs_to :account
+
+    encrypts :value
+
+    validates :name, presence: true, format: { with: NAME_FORMAT }, uniqueness: { scope: :account_id }
+    validates :value, presence: true
+    validate :value_within_limit
+
+    private
+
+    def value_within_limit
+      return if value.nil? || value.bytesize <= MAX_VALUE_BYTES
+
+      errors.add(:value, "must be at most #{MAX_VALUE_BYTES} bytes")
+    end
+  end
+end
@@ -68,6 +68,11 @@ module Conduits
       where("tags @> ?", [tag].to_json)
     }
 
+    # Territories whose last poll reported the session open.
+    scope :holding_session, ->(session_id) {
+      where("open_sessions @> ?", [session_id].to_json)
+    }
+
     scope :websocket_connected, -> {
       where.not(websocket_connected_at: nil)
     }
@@ -0,0 +1,109 @@
+require "digest"
+
+module Conduits
+  # Appends chunks of a diff sent through diff_chunks and, once all bytes are
+  # in, reassembles it into the directive's diff_blob. One upload per
+  # directive; a different sha256 (or size) starts over.
+  class DiffUploadIngestor
+    Result = Data.define(:received_bytes, :complete)
+
+    # The chunk does not start at received_bytes; the client resyncs.
+    class OffsetMismatch < StandardError; end
+
+    # The directive already holds a different, completed diff.
+    class Conflict < StandardError; end
+
+    def initialize(directive)
+      @directive = directive
+    end
+
+    def status(sha256:)
+      upload = DiffUpload.find_by(directive_id: @directive.id)
+      return Result.new(received_bytes: 0, complete: false) unless upload&.sha256 == sha256
+
+      result_for(upload)
+    end
+
+    def append!(sha256:, total_bytes:, offset:, bytes:)
+      bytes = bytes.to_s.b
+      raise ArgumentError, "bytes must not be empty" if bytes.empty?
+
+      mismatch = false
+      result = Directive.transaction do
+        @directive.lock!
+
+        upload = DiffUpload.find_by(directive_id: @directive.id)
+        if upload&.completed?
+          raise Conflict, "directive already has a different diff" unless upload.sha256 == sha256
+
+          next result_for(upload)
+        end
+
+        if upload.nil? || upload.sha256 != sha256 || upload.total_bytes != total_bytes
+          upload&.destroy!
+          upload = DiffUpload.create!(directive: @directive, sha256: sha256, total_bytes: total_bytes)
+        end
+
+        unless offset == upload.received_bytes
+          raise OffsetMismatch, "offset #{offset} does not match received_bytes #{upload.received_bytes}"
+        end
+        if offset + bytes.bytesize > total_bytes
+          raise ArgumentError, "chunk ends past total_bytes"
+        end
+
+        upload.chunks.create!(offset: offset, bytes: bytes, bytesize: bytes.bytesize)
+        upload.update!(received_bytes: offset + bytes.bytesize)
+
+        if upload.received_bytes == upload.total_bytes
+          # A bad whole-diff checksum restarts the upload; the reset is
+          # committed before the error is reported.
+          mismatch = !complete!(upload)
+        end
+
+        result_for(upload)
+      end
+
+      raise ArgumentError, "sha256 mismatch, upload restarted" if mismatch
+
+      result
+    end
+
+    private
+
+    # Concatenates the chunks, checks the whole-diff sha256 and attaches the
+    # result as diff_blob. Returns false (after resetting the upload) if the
+    # checksum does not match.
+    def complete!(upload)
+      Tempfile.create(["diff", ".patch"], binmode: true) do |tmp|
+        digest = Digest::SHA256.new
+        upload.chunks.order(:offset).pluck(:id).each do |chunk_id|
+          bytes = DiffUploadChunk.where(id: chunk_id).pick(:bytes)
+          digest.update(bytes)
+          tmp.write(bytes)
+        end
+
+        unless digest.hexdigest == upload.sha256
+          upload.chunks.delete_all
+          upload.update!(received_bytes: 0)
+          return false
+        end
+
+        tmp.rewind
+        blob = ActiveStorage::Blob.create_and_upload!(
+          io: tmp,
+          filename: "diff.patch",
+          content_type: "text/x-diff"
+        )
+        @directive.diff_blob.attach(blob)
+      end
+
+      upload.chunks.delete_all
+      upload.update!(completed_at: Time.current)
+      true
+    end
+
+    def result_for(upload)
+      Result.new(received_bytes: upload.received_bytes, complete: upload.completed?)
+    end
+  end
+end
@@ -55,7 +55,7 @@ module Conduits
       end
 
       # Base net: use policy ceiling if set
-      effective_net = apply_net_ceiling(resolved[:net], requested["net"])
+      effective_net = apply_net_ceiling(expand_net(resolved[:net]), expand_net(requested["net"]))
 
       {
         "fs" => effective_fs,
@@ -82,6 +82,14 @@ module Conduits
       end
     end
 
+    # Presets expand to their effective mode and allowlist before modes are
+    # compared (NetPresetsV1). Raises NetPolicyV1::ParseError if invalid.
+    def expand_net(net)
+      return net if net.blank? || net["preset"].blank?
+
+      NetPresetsV1.expand(net)
+    end
+
     def net_mode_rank(mode)
       { "none" => 0, "allowlist" => 1, "unrestricted" => 2 }.fetch(mode.to_s, 2)
     end
@@ -7,13 +7,15 @@ module Conduits
 
     Result = Data.define(:directives, :lease_ttl_seconds, :retry_after_seconds)
 
-    def initialize(territory:, supported_profiles:, max_claims: 1)
+    def initialize(territory:, supported_profiles:, max_claims: 1, sessions: [])
       @territory = territory
       @supported_profiles = supported_profiles
       @max_claims = [max_claims, 5].min # cap at 5 per poll
+      @sessions = sessions
     end
 
     def call
+      record_sessions
       lease_directives(skip_locked: true)
     rescue ActiveRecord::StatementInvalid => e
       # SQLite doesn't support SKIP LOCKED — fall back to advisory locking
@@ -55,6 +57,9 @@ module Conduits
           # Skip if this territory's sandbox driver for the profile is unhealthy
           next unless @territory.sandbox_healthy?(directive.sandbox_profile)
 
+          # Session directives run one at a time, on the territory holding the session
+          next unless session_routable?(directive)
+
           # Re-validate policy at lease time (may have changed since creation)
           current_eval = Conduits::PolicyResolver.new(directive).call
           if current_eval.approval_verdict == :forbidden
@@ -108,6 +113,48 @@ module Conduits
       )
     end
 
+    # Nexus reports the sessions it has open on every poll.
+    def record_sessions
+      sessions = @sessions.uniq.sort
+      @territory.update_columns(open_sessions: sessions) unless @territory.open_sessions == sessions
+    end
+
+    # A session directive is leased only after the earlier ones of its session
+    # (Nexus rejects a busy session), and only to the territory holding the
+    # session open. A session no territory holds is opened wherever it lands.
+    def session_routable?(directive)
+      return true unless directive.session?
+
+      siblings = Directive
+        .where(account_id: directive.account_id)
+        .in_session(directive.session_id)
+        .where.not(id: directive.id)
+      return false if siblings.where(state: %w[leased running]).exists?
+      return false if siblings.where(state: %w[queued awaiting_approval]).where("created_at < ?", directive.created_at).exists?
+
+      holder = session_holder(directive, siblings)
+      holder.nil? || holder == @territory.id
+    end
+
+    # The online territory holding the session: the one whose last poll
+    # reported it, or else the one whose last directive of the session left
+    # it open (that directive may have finished after the territory's poll).
+    def session_holder(directive, siblings)
+      online = Territory.where(account_id: directive.account_id, status: "online")
+
+      holder = online.holding_session(directive.session_id).pick(:id)
+      return holder if holder
+
+      last = siblings
+        .where(state: %w[succeeded failed canceled timed_out])
+        .where.not(territory_id: nil)
+        .order(created_at: :desc)
+        .first
+      return unless last&.artifacts_manifest&.dig("session", "open")
+
+      last.territory_id if online.exists?(id: last.territory_id)
+    end
+
     def audit_for(directive)
       AuditService.new(account: directive.account, directive: directive)
     end
@@ -129,14 +176,17 @@ module Conduits
           repo_url: directive.facility.repo_url,
         }.compact,
         sandbox_profile: directive.sandbox_profile,
+        tenant: directive.account_id,
         command: directive.command,
         shell: directive.shell || "/bin/sh",
         cwd: directive.cwd || "/workspace",
         timeout_seconds: directive.timeout_seconds,
         limits: directive.limits,
+        interactive: directive.interactive,
+        session: directive.session,
         capabilities: directive.effective_capabilities,
         artifacts: directive.artifacts_manifest,
-      }
+      }.compact
     end
   end
 end
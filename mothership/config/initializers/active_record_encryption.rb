@@ -0,0 +1,13 @@
+# Active Record encryption keys, used for Conduits::Secret values.
+#
+# Production should set the ACTIVE_RECORD_ENCRYPTION_* variables. Without
+# them the keys are derived from secret_key_base, so rotating it makes
+# stored secrets unreadable.
+key_generator = Rails.application.key_generator
+derived = ->(purpose) { key_generator.generate_key("active_record_encryption/#{purpose}", 32).unpack1("H*") }
+
+ActiveRecord::Encryption.configure(
+  primary_key: ENV.fetch("ACTIVE_RECORD_ENCRYPTION_PRIMARY_KEY") { derived.call("primary_key") },
+  deterministic_key: ENV.fetch("ACTIVE_RECORD_ENCRYPTION_DETERMINISTIC_KEY") { derived.call("deterministic_key") },
+  key_derivation_salt: ENV.fetch("ACTIVE_RECORD_ENCRYPTION_KEY_DERIVATION_SALT") { derived.call("key_derivation_salt") }
+)
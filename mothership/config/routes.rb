@@ -19,7 +19,13 @@ Rails.application.routes.draw do
           post :started
           post :heartbeat
           post :log_chunks
+          post :audit_events, to: "directive_audit_events#create"
           post :finished
+          post :input, to: "directive_inputs#poll"
+          put :artifacts, to: "directive_artifacts#upload"
+          get :diff_chunks, to: "directive_diff_chunks#show"
+          post :diff_chunks, to: "directive_diff_chunks#create"
+          post :secrets, to: "directive_secrets#create"
         end
       end
 
@@ -44,18 +50,21 @@ Rails.application.routes.draw do
       member do
         get :log
         get :diff
+        get :artifact
       end
     end
 
     namespace :api do
       namespace :v1 do
         resources :policies
+        resources :secrets, only: [:index, :create, :update, :destroy]
 
         resources :facilities, only: [] do
           resources :directives, only: [:create, :show, :index],
                     controller: "facility_directives" do
             member do
               get :log_chunks
+              post :input
               post :approve
               post :reject
             end
@@ -0,0 +1,18 @@
+class CreateConduitsDirectiveArtifacts < ActiveRecord::Migration[8.1]
+  def change
+    create_table :conduits_directive_artifacts, id: :uuid, default: -> { "uuidv7()" } do |t|
+      t.references :directive, type: :uuid, null: false,
+                   foreign_key: { to_table: :conduits_directives }, index: true
+
+      t.string :path,   null: false # facility-relative, slash-separated
+      t.bigint :size,   null: false
+      t.string :sha256, null: false
+      t.string :mode
+
+      t.timestamps
+
+      t.index %i[directive_id path], unique: true,
+              name: "index_conduits_directive_artifacts_uniqueness"
+    end
+  end
+end
@@ -0,0 +1,29 @@
+class CreateConduitsDiffUploads < ActiveRecord::Migration[8.1]
+  def change
+    create_table :conduits_diff_uploads, id: :uuid, default: -> { "uuidv7()" } do |t|
+      t.references :directive, type: :uuid, null: false,
+                   foreign_key: { to_table: :conduits_directives }, index: { unique: true }
+
+      t.string   :sha256,         null: false
+      t.bigint   :total_bytes,    null: false
+      t.bigint   :received_bytes, null: false, default: 0
+      t.datetime :completed_at
+
+      t.timestamps
+    end
+
+    create_table :conduits_diff_upload_chunks, id: :uuid, default: -> { "uuidv7()" } do |t|
+      t.references :diff_upload, type: :uuid, null: false,
+                   foreign_key: { to_table: :conduits_diff_uploads }, index: false
+
+      t.bigint  :offset,   null: false
+      t.binary  :bytes,    null: false
+      t.integer :bytesize, null: false
+
+      t.timestamps
+
+      t.index %i[diff_upload_id offset], unique: true,
+              name: "index_conduits_diff_upload_chunks_uniqueness"
+    end
+  end
+end
@@ -0,0 +1,14 @@
+class CreateConduitsSecrets < ActiveRecord::Migration[8.1]
+  def change
+    create_table :conduits_secrets, id: :uuid, default: -> { "uuidv7()" } do |t|
+      t.references :account, type: :uuid, null: false, foreign_key: true, index: true
+
+      t.string :name,  null: false # the ref named in capabilities.secrets.refs[].ref
+      t.text   :value, null: false # Active Record encrypted
+
+      t.timestamps
+
+      t.index %i[account_id name], unique: true
+    end
+  end
+end
@@ -0,0 +1,20 @@
+class CreateConduitsEgressAuditBatches < ActiveRecord::Migration[8.1]
+  def change
+    create_table :conduits_egress_audit_batches, id: :uuid, default: -> { "uuidv7()" } do |t|
+      t.references :directive, type: :uuid, null: false,
+                   foreign_key: { to_table: :conduits_directives }, index: false
+
+      t.integer :seq,          null: false
+      t.jsonb   :events,       null: false, default: []
+      t.integer :event_count,  null: false, default: 0
+      t.integer :denied_count, null: false, default: 0
+
+      t.timestamps
+
+      t.index %i[directive_id seq], unique: true,
+              name: "index_conduits_egress_audit_batches_uniqueness"
+    end
+
+    add_column :conduits_directives, :egress_summary, :jsonb
+  end
+end
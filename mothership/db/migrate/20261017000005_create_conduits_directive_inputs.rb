@@ -0,0 +1,21 @@
+class CreateConduitsDirectiveInputs < ActiveRecord::Migration[8.1]
+  def change
+    add_column :conduits_directives, :interactive, :jsonb
+
+    create_table :conduits_directive_inputs, id: :uuid, default: -> { "uuidv7()" } do |t|
+      t.references :directive, type: :uuid, null: false,
+                   foreign_key: { to_table: :conduits_directives }, index: false
+
+      t.integer :seq,        null: false
+      t.string  :event_type, null: false
+      t.binary  :bytes
+      t.integer :rows
+      t.integer :cols
+
+      t.timestamps
+
+      t.index %i[directive_id seq], unique: true,
+              name: "index_conduits_directive_inputs_uniqueness"
+    end
+  end
+end
@@ -0,0 +1,9 @@
+class AddSessionsToConduits < ActiveRecord::Migration[8.1]
+  def change
+    add_column :conduits_directives, :session, :jsonb
+    add_index :conduits_directives, "(session ->> 'id')",
+              name: "idx_directives_session_id", where: "session IS NOT NULL"
+
+    add_column :conduits_territories, :open_sessions, :jsonb, null: false, default: []
+  end
+end
@@ -10,7 +10,7 @@
 #
 # It's strongly recommended that you check this file into your version control system.
 
-ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
+ActiveRecord::Schema[8.1].define(version: 2026_10_17_000006) do
   # These are extensions that must be enabled in order to support this database
   enable_extension "pg_catalog.plpgsql"
 
@@ -116,6 +116,51 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
     t.index ["territory_id"], name: "index_conduits_commands_on_territory_id"
   end
 
+  create_table "conduits_diff_upload_chunks", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
+    t.binary "bytes", null: false
+    t.integer "bytesize", null: false
+    t.datetime "created_at", null: false
+    t.uuid "diff_upload_id", null: false
+    t.bigint "offset", null: false
+    t.datetime "updated_at", null: false
+    t.index ["diff_upload_id", "offset"], name: "index_conduits_diff_upload_chunks_uniqueness", unique: true
+  end
+
+  create_table "conduits_diff_uploads", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
+    t.datetime "completed_at"
+    t.datetime "created_at", null: false
+    t.uuid "directive_id", null: false
+    t.bigint "received_bytes", default: 0, null: false
+    t.string "sha256", null: false
+    t.bigint "total_bytes", null: false
+    t.datetime "updated_at", null: false
+    t.index ["directive_id"], name: "index_conduits_diff_uploads_on_directive_id", unique: true
+  end
+
+  create_table "conduits_directive_artifacts", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
+    t.datetime "created_at", null: false
+    t.uuid "directive_id", null: false
+    t.string "mode"
+    t.string "path", null: false
+    t.string "sha256", null: false
+    t.bigint "size", null: false
+    t.datetime "updated_at", null: false
+    t.index ["directive_id", "path"], name: "index_conduits_directive_artifacts_uniqueness", unique: true
+    t.index ["directive_id"], name: "index_conduits_directive_artifacts_on_directive_id"
+  end
+
+  create_table "conduits_directive_inputs", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
+    t.binary "bytes"
+    t.integer "cols"
+    t.datetime "created_at", null: false
+    t.uuid "directive_id", null: false
+    t.string "event_type", null: false
+    t.integer "rows"
+    t.integer "seq", null: false
+    t.datetime "updated_at", null: false
+    t.index ["directive_id", "seq"], name: "index_conduits_directive_inputs_uniqueness", unique: true
+  end
+
   create_table "conduits_directives", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
     t.uuid "account_id", null: false
     t.uuid "approved_by_user_id"
@@ -127,12 +172,14 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
     t.boolean "diff_truncated", default: false, null: false
     t.jsonb "effective_capabilities", default: {}, null: false
     t.jsonb "egress_proxy_policy_snapshot"
+    t.jsonb "egress_summary"
     t.jsonb "env_allowlist", default: [], null: false
     t.jsonb "env_refs", default: [], null: false
     t.integer "exit_code"
     t.uuid "facility_id", null: false
     t.datetime "finished_at"
     t.string "finished_status"
+    t.jsonb "interactive"
     t.datetime "last_heartbeat_at"
     t.datetime "lease_expires_at"
     t.jsonb "limits", default: {}, null: false
@@ -144,6 +191,7 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
     t.string "runtime_ref"
     t.string "sandbox_profile", default: "untrusted", null: false
     t.string "sandbox_version"
+    t.jsonb "session"
     t.string "shell"
     t.string "snapshot_after"
     t.string "snapshot_before"
@@ -155,6 +203,7 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
     t.uuid "territory_id"
     t.integer "timeout_seconds", default: 0, null: false
     t.datetime "updated_at", null: false
+    t.index "((session ->> 'id'::text))", name: "idx_directives_session_id", where: "(session IS NOT NULL)"
     t.index ["account_id"], name: "index_conduits_directives_on_account_id"
     t.index ["approved_by_user_id"], name: "index_conduits_directives_on_approved_by_user_id"
     t.index ["facility_id"], name: "index_conduits_directives_on_facility_id"
@@ -164,6 +213,17 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
     t.index ["territory_id"], name: "index_conduits_directives_on_territory_id"
   end
 
+  create_table "conduits_egress_audit_batches", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
+    t.datetime "created_at", null: false
+    t.integer "denied_count", default: 0, null: false
+    t.uuid "directive_id", null: false
+    t.integer "event_count", default: 0, null: false
+    t.jsonb "events", default: [], null: false
+    t.integer "seq", null: false
+    t.datetime "updated_at", null: false
+    t.index ["directive_id", "seq"], name: "index_conduits_egress_audit_batches_uniqueness", unique: true
+  end
+
   create_table "conduits_enrollment_tokens", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
     t.uuid "account_id", null: false
     t.datetime "created_at", null: false
@@ -231,6 +291,16 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
     t.index ["scope_type", "scope_id"], name: "index_conduits_policies_on_scope"
   end
 
+  create_table "conduits_secrets", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
+    t.uuid "account_id", null: false
+    t.datetime "created_at", null: false
+    t.string "name", null: false
+    t.datetime "updated_at", null: false
+    t.text "value", null: false
+    t.index ["account_id", "name"], name: "index_conduits_secrets_on_account_id_and_name", unique: true
+    t.index ["account_id"], name: "index_conduits_secrets_on_account_id"
+  end
+
   create_table "conduits_territories", id: :uuid, default: -> { "uuidv7()" }, force: :cascade do |t|
     t.uuid "account_id"
     t.jsonb "capabilities", default: [], null: false
@@ -244,6 +314,7 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
     t.string "location"
     t.string "name", null: false
     t.string "nexus_version"
+    t.jsonb "open_sessions", default: [], null: false
     t.string "platform"
     t.string "push_platform"
     t.string "push_token"
@@ -281,11 +352,16 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
   add_foreign_key "conduits_commands", "conduits_territories", column: "territory_id"
   add_foreign_key "conduits_commands", "users", column: "approved_by_user_id"
   add_foreign_key "conduits_commands", "users", column: "requested_by_user_id"
+  add_foreign_key "conduits_diff_upload_chunks", "conduits_diff_uploads", column: "diff_upload_id"
+  add_foreign_key "conduits_diff_uploads", "conduits_directives", column: "directive_id"
+  add_foreign_key "conduits_directive_artifacts", "conduits_directives", column: "directive_id"
+  add_foreign_key "conduits_directive_inputs", "conduits_directives", column: "directive_id"
   add_foreign_key "conduits_directives", "accounts"
   add_foreign_key "conduits_directives", "conduits_facilities", column: "facility_id"
   add_foreign_key "conduits_directives", "conduits_territories", column: "territory_id"
   add_foreign_key "conduits_directives", "users", column: "approved_by_user_id"
   add_foreign_key "conduits_directives", "users", column: "requested_by_user_id"
+  add_foreign_key "conduits_egress_audit_batches", "conduits_directives", column: "directive_id"
   add_foreign_key "conduits_enrollment_tokens", "accounts"
   add_foreign_key "conduits_enrollment_tokens", "users", column: "created_by_user_id"
   add_foreign_key "conduits_facilities", "accounts"
@@ -293,6 +369,7 @@ ActiveRecord::Schema[8.1].define(version: 2026_02_27_190000) do
   add_foreign_key "conduits_facilities", "users", column: "owner_id"
   add_foreign_key "conduits_log_chunks", "conduits_directives", column: "directive_id"
   add_foreign_key "conduits_policies", "accounts"
+  add_foreign_key "conduits_secrets", "accounts"
   add_foreign_key "conduits_territories", "accounts"
   add_foreign_key "users", "accounts"
 end
@@ -0,0 +1,89 @@
+require "test_helper"
+
+class Conduits::V1::DirectiveArtifactsControllerTest < ActionDispatch::IntegrationTest
+  include ConduitsDirectiveHelpers
+
+  setup do
+    setup_conduits_directive
+  end
+
+  test "stores an artifact and acknowledges a re-upload" do
+    body = "coverage: 93%\n"
+    upload("reports/coverage.txt", body, mode: "0644")
+
+    assert_response :success
+    assert_equal false, response.parsed_body["duplicate"]
+
+    artifact = @directive.artifacts.find_by!(path: "reports/coverage.txt")
+    assert_equal body.bytesize, artifact.size
+    assert_equal "0644", artifact.mode
+    assert_equal body, artifact.file.download
+
+    upload("reports/coverage.txt", body)
+    assert_response :success
+    assert_equal true, response.parsed_body["duplicate"]
+    assert_equal 1, @directive.artifacts.count
+  end
+
+  test "rejects other content for a stored path" do
+    upload("out.bin", "first")
+    assert_response :success
+
+    upload("out.bin", "second")
+    assert_response :conflict
+  end
+
+  test "rejects a body that does not match size or sha256" do
+    put artifacts_url("a.txt", size: 10, sha256: Digest::SHA256.hexdigest("short")),
+        params: "short",
+        headers: directive_headers("Content-Type" => "application/octet-stream")
+    assert_response :unprocessable_entity
+
+    put artifacts_url("a.txt", size: 5, sha256: Digest::SHA256.hexdigest("other")),
+        params: "short",
+        headers: directive_headers("Content-Type" => "application/octet-stream")
+    assert_response :unprocessable_entity
+    assert_equal 0, @directive.artifacts.count
+  end
+
+  test "rejects paths outside the facility" do
+    %w[/etc/passwd ../x a/../../b a//b].each do |path|
+      upload(path, "x")
+      assert_response :unprocessable_entity, path
+    end
+  end
+
+  test "rejects artifacts over the size limit" do
+    ENV["CONDUITS_ARTIFACT_MAX_BYTES"] = "4"
+    upload("big.txt", "12345")
+    assert_response :content_too_large
+  ensure
+    ENV.delete("CONDUITS_ARTIFACT_MAX_BYTES")
+  end
+
+  test "rejects uploads before the directive started" do
+    @directive.update!(state: "leased")
+    upload("a.txt", "x")
+    assert_response :conflict
+  end
+
+  test "requires the directive token" do
+    put artifacts_url("a.txt", size: 1, sha256: Digest::SHA256.hexdigest("x")),
+        params: "x",
+        headers: { "X-Nexus-Territory-Id" => @territory.id, "Content-Type" => "application/octet-stream" }
+    assert_response :unauthorized
+  end
+
+  private
+
+  def upload(path, body, mode: nil)
+    put artifacts_url(path, size: body.bytesize, sha256: Digest::SHA256.hexdigest(body), mode: mode),
+        params: body,
+        headers: directive_headers("Content-Type" => "application/octet-stream")
+  end
+
+  def artifacts_url(path, size:, sha256:, mode: nil)
+    query = { path: path, size: size, sha256: sha256, mode: mode }.compact.to_query
+    "/conduits/v1/directives/#{@directive.id}/artifacts?#{query}"
+  end
+end
@@ -0,0 +1,85 @@
+require "test_helper"
+
+class Conduits::V1::DirectiveAuditEventsControllerTest < ActionDispatch::IntegrationTest
+  include ConduitsDirectiveHelpers
+
+  setup do
+    setup_conduits_directive
+  end
+
+  test "stores a batch once per seq" do
+    post_batch(0, [event("github.com", "allow"), event("evil.example", "deny", reason_code: "NOT_IN_ALLOWLIST")])
+    assert_response :success
+    assert_equal true, response.parsed_body["stored"]
+
+    post_batch(0, [event("github.com", "allow")])
+    assert_response :success
+    assert_equal true, response.parsed_body["duplicate"]
+
+    batches = @directive.egress_audit_batches.to_a
+    assert_equal 1, batches.size
+    assert_equal 2, batches.first.event_count
+    assert_equal 1, batches.first.denied_count
+    assert_equal "evil.example", batches.first.events.last["dest_host"]
+  end
+
+  test "accepts batches replayed after finished" do
+    @directive.update!(state: "succeeded", finished_status: "succeeded")
+    post_batch(3, [event("github.com", "allow")])
+    assert_response :success
+    assert_equal 1, @directive.egress_audit_batches.count
+  end
+
+  test "rejects batches before the directive runs" do
+    @directive.update!(state: "leased")
+    post_batch(0, [event("github.com", "allow")])
+    assert_response :conflict
+  end
+
+  test "rejects malformed events" do
+    post_batch(0, [event("github.com", "maybe")])
+    assert_response :unprocessable_entity
+
+    post_batch(0, [event("github.com", "allow").except("reason_code")])
+    assert_response :unprocessable_entity
+
+    post_batch(0, [event("github.com", "allow", directive_id: "other")])
+    assert_response :unprocessable_entity
+
+    post_batch(0, Array.new(201) { event("github.com", "allow") })
+    assert_response :unprocessable_entity
+
+    assert_equal 0, @directive.egress_audit_batches.count
+  end
+
+  test "finished persists egress_summary" do
+    summary = { "allowed" => 3, "denied" => 1, "hosts" => { "github.com" => { "allowed" => 3, "denied" => 1 } } }
+    post "/conduits/v1/directives/#{@directive.id}/finished",
+         params: { status: "succeeded", exit_code: 0, egress_summary: summary },
+         headers: directive_headers,
+         as: :json
+    assert_response :success
+    assert_equal summary, @directive.reload.egress_summary
+  end
+
+  private
+
+  def event(host, decision, reason_code: "OK", directive_id: @directive.id)
+    {
+      "ts" => Time.current.iso8601,
+      "directive_id" => directive_id,
+      "dest_host" => host,
+      "dest_port" => 443,
+      "decision" => decision,
+      "reason_code" => reason_code,
+      "method" => "CONNECT",
+    }
+  end
+
+  def post_batch(seq, events)
+    post "/conduits/v1/directives/#{@directive.id}/audit_events",
+         params: { seq: seq, events: events },
+         headers: directive_headers,
+         as: :json
+  end
+end
@@ -0,0 +1,89 @@
+require "test_helper"
+
+class Conduits::V1::DirectiveDiffChunksControllerTest < ActionDispatch::IntegrationTest
+  include ConduitsDirectiveHelpers
+
+  setup do
+    setup_conduits_directive
+    @diff = "diff --git a/x b/x\n" + ("+line\n" * 100)
+    @sha = Digest::SHA256.hexdigest(@diff)
+  end
+
+  test "uploads a diff in chunks and references it from finished" do
+    get "/conduits/v1/directives/#{@directive.id}/diff_chunks", params: { sha256: @sha }, headers: directive_headers
+    assert_response :success
+    assert_equal({ "received_bytes" => 0, "complete" => false }, response.parsed_body)
+
+    post_chunk(0, @diff.byteslice(0, 300))
+    assert_response :success
+    assert_equal 300, response.parsed_body["received_bytes"]
+
+    post_chunk(0, @diff.byteslice(0, 300))
+    assert_response :conflict
+
+    post_chunk(300, @diff.byteslice(300..))
+    assert_response :success
+    assert_equal true, response.parsed_body["complete"]
+
+    post "/conduits/v1/directives/#{@directive.id}/finished",
+         params: {
+           status: "succeeded",
+           exit_code: 0,
+           artifacts_manifest: { diff_upload: { sha256: @sha, total_bytes: @diff.bytesize, chunks_sent: 2 } },
+         },
+         headers: directive_headers,
+         as: :json
+    assert_response :success
+
+    @directive.reload
+    assert @directive.succeeded?
+    assert_equal @diff, @directive.diff_blob.download
+    assert_equal @diff.bytesize, @directive.diff_blob.byte_size
+  end
+
+  test "finished rejects a diff_upload that was not completed" do
+    post_chunk(0, @diff.byteslice(0, 300))
+
+    post "/conduits/v1/directives/#{@directive.id}/finished",
+         params: { status: "succeeded", artifacts_manifest: { diff_upload: { sha256: @sha } } },
+         headers: directive_headers,
+         as: :json
+    assert_response :unprocessable_entity
+    assert @directive.reload.running?
+  end
+
+  test "rejects a chunk whose chunk_sha256 does not match" do
+    post "/conduits/v1/directives/#{@directive.id}/diff_chunks",
+         params: {
+           sha256: @sha,
+           total_bytes: @diff.bytesize,
+           offset: 0,
+           bytes: Base64.strict_encode64("abc"),
+           chunk_sha256: Digest::SHA256.hexdigest("abd"),
+         },
+         headers: directive_headers,
+         as: :json
+    assert_response :unprocessable_entity
+  end
+
+  test "rejects chunks unless the directive is running" do
+    @directive.update!(state: "leased")
+    post_chunk(0, @diff)
+    assert_response :conflict
+  end
+
+  private
+
+  def post_chunk(offset, bytes)
+    post "/conduits/v1/directives/#{@directive.id}/diff_chunks",
+         params: {
+           sha256: @sha,
+           total_bytes: @diff.bytesize,
+           offset: offset,
+           bytes: Base64.strict_encode64(bytes),
+           chunk_sha256: Digest::SHA256.hexdigest(bytes),
+         },
+         headers: directive_headers,
+         as: :json
+  end
+end
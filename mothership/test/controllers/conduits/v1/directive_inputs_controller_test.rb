@@ -0,0 +1,55 @@
+require "test_helper"
+
+class Conduits::V1::DirectiveInputsControllerTest < ActionDispatch::IntegrationTest
+  include ConduitsDirectiveHelpers
+
+  setup do
+    setup_conduits_directive(interactive: { "rows" => 24, "cols" => 80 })
+  end
+
+  test "returns the events after a seq, oldest first" do
+    Conduits::DirectiveInput.push!(@directive, event_type: "data", bytes: "ls\r")
+    Conduits::DirectiveInput.push!(@directive, event_type: "resize", rows: 40, cols: 120)
+    Conduits::DirectiveInput.push!(@directive, event_type: "eof")
+
+    poll(after: 0)
+    assert_response :success
+    assert_equal [
+      { "seq" => 1, "type" => "data", "bytes" => Base64.strict_encode64("ls\r") },
+      { "seq" => 2, "type" => "resize", "rows" => 40, "cols" => 120 },
+      { "seq" => 3, "type" => "eof" },
+    ], response.parsed_body["events"]
+
+    poll(after: 2)
+    assert_equal [3], response.parsed_body["events"].map { |e| e["seq"] }
+
+    poll(after: 3)
+    assert_equal [], response.parsed_body["events"]
+  end
+
+  test "returns 404 for a directive that is not interactive" do
+    @directive.update!(interactive: nil)
+    poll(after: 0)
+    assert_response :not_found
+  end
+
+  test "rejects polls once the directive has finished" do
+    @directive.update!(state: "succeeded")
+    poll(after: 0)
+    assert_response :conflict
+  end
+
+  test "rejects a negative after" do
+    poll(after: -1)
+    assert_response :unprocessable_entity
+  end
+
+  private
+
+  def poll(after:, wait_seconds: 0)
+    post "/conduits/v1/directives/#{@directive.id}/input",
+         params: { after: after, wait_seconds: wait_seconds },
+         headers: directive_headers,
+         as: :json
+  end
+end
@@ -0,0 +1,75 @@
+require "test_helper"
+
+class Conduits::V1::DirectiveSecretsControllerTest < ActionDispatch::IntegrationTest
+  include ConduitsDirectiveHelpers
+
+  setup do
+    setup_conduits_directive(
+      state: "leased",
+      effective_capabilities: {
+        "secrets" => { "refs" => [{ "ref" => "npm_token", "env" => "NPM_TOKEN" }, { "ref" => "gh", "file" => "gh" }] },
+      }
+    )
+    Conduits::Secret.create!(account: @account, name: "npm_token", value: "npm-123")
+    Conduits::Secret.create!(account: @account, name: "gh", value: "gh-456")
+    Conduits::Secret.create!(account: @account, name: "other", value: "not-granted")
+  end
+
+  test "returns granted secrets" do
+    post_refs %w[npm_token gh]
+
+    assert_response :success
+    assert_equal "no-store", response.headers["Cache-Control"]
+    assert_equal(
+      [{ "ref" => "npm_token", "value" => "npm-123" }, { "ref" => "gh", "value" => "gh-456" }],
+      response.parsed_body["secrets"]
+    )
+    assert Conduits::AuditEvent.exists?(directive: @directive, event_type: "directive.secrets_resolved")
+  end
+
+  test "rejects refs the directive was not granted" do
+    post_refs %w[npm_token other]
+
+    assert_response :forbidden
+    assert_nil response.parsed_body["secrets"]
+  end
+
+  test "reports granted refs without a stored value" do
+    Conduits::Secret.find_by!(name: "gh").destroy!
+    post_refs %w[gh]
+
+    assert_response :not_found
+  end
+
+  test "does not resolve another account's secret" do
+    other = Account.create!(name: "other-account")
+    Conduits::Secret.find_by!(name: "gh").destroy!
+    Conduits::Secret.create!(account: other, name: "gh", value: "foreign")
+    post_refs %w[gh]
+
+    assert_response :not_found
+  end
+
+  test "rejects finished directives" do
+    @directive.update!(state: "succeeded")
+    post_refs %w[npm_token]
+
+    assert_response :conflict
+  end
+
+  test "stores values encrypted" do
+    raw = Conduits::Secret.connection.select_value(
+      Conduits::Secret.where(name: "npm_token").select(:value).to_sql
+    )
+    assert_not_includes raw, "npm-123"
+  end
+
+  private
+
+  def post_refs(refs)
+    post "/conduits/v1/directives/#{@directive.id}/secrets",
+         params: { refs: refs },
+         headers: directive_headers,
+         as: :json
+  end
+end
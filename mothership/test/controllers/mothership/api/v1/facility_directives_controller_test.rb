@@ -112,6 +112,37 @@ class Mothership::API::V1::FacilityDirectivesControllerTest < ActionDispatch::In
     assert_equal "none", directive.effective_capabilities.dig("net", "mode")
   end
 
+  test "create expands the requested net preset" do
+    post facility_directives_url,
+      params: {
+        command: "bundle install",
+        sandbox_profile: "untrusted",
+        requested_capabilities: { net: { mode: "allowlist", preset: "loose", allow: ["example.com:443"] } },
+      },
+      headers: auth_headers,
+      as: :json
+
+    assert_response :created
+    directive = Conduits::Directive.find(response.parsed_body["directive_id"])
+    net = directive.effective_capabilities["net"]
+    assert_includes net["allow"], "rubygems.org:443"
+    assert_equal "example.com:443", net["allow"].last
+  end
+
+  test "create rejects an unknown net preset" do
+    post facility_directives_url,
+      params: {
+        command: "echo hi",
+        sandbox_profile: "untrusted",
+        requested_capabilities: { net: { mode: "allowlist", preset: "paranoid" } },
+      },
+      headers: auth_headers,
+      as: :json
+
+    assert_response :unprocessable_entity
+    assert_equal "invalid_net_capability", response.parsed_body["error"]
+  end
+
   test "create narrows requested FS via policy ceiling" do
     Conduits::Policy.create!(
       account: @account,
@@ -290,6 +321,99 @@ class Mothership::API::V1::FacilityDirectivesControllerTest < ActionDispatch::In
     assert_equal "queued", response.parsed_body["state"]
   end
 
+  # --- Interactive directives ---
+
+  test "create stores the interactive terminal size" do
+    post facility_directives_url,
+      params: { command: "python3", sandbox_profile: "untrusted", interactive: { rows: 40, cols: 120 } },
+      headers: auth_headers,
+      as: :json
+
+    assert_response :created
+    directive = Conduits::Directive.find(response.parsed_body["directive_id"])
+    assert_equal({ "rows" => 40, "cols" => 120 }, directive.interactive)
+  end
+
+  test "create rejects an out-of-range terminal size" do
+    post facility_directives_url,
+      params: { command: "python3", sandbox_profile: "untrusted", interactive: { rows: 0, cols: 5000 } },
+      headers: auth_headers,
+      as: :json
+
+    assert_response :unprocessable_entity
+    assert_equal 0, Conduits::Directive.count
+  end
+
+  test "create stores the session spec" do
+    post facility_directives_url,
+      params: {
+        command: "make test",
+        sandbox_profile: "untrusted",
+        session: { id: "build-1", idle_timeout_seconds: 900, close: true },
+      },
+      headers: auth_headers,
+      as: :json
+
+    assert_response :created
+    directive = Conduits::Directive.find(response.parsed_body["directive_id"])
+    assert_equal({ "id" => "build-1", "idle_timeout_seconds" => 900, "close" => true }, directive.session)
+    assert_equal "build-1", directive.session_id
+  end
+
+  test "create rejects an invalid session id" do
+    post facility_directives_url,
+      params: { command: "make test", sandbox_profile: "untrusted", session: { id: "../build" } },
+      headers: auth_headers,
+      as: :json
+
+    assert_response :unprocessable_entity
+    assert_equal 0, Conduits::Directive.count
+  end
+
+  test "input queues terminal events in order" do
+    directive = create_interactive_directive
+
+    post input_directive_url(directive), params: { type: "data", bytes: Base64.strict_encode64("1+1\n") },
+      headers: auth_headers, as: :json
+    assert_response :created
+    assert_equal 1, response.parsed_body["seq"]
+
+    post input_directive_url(directive), params: { type: "resize", rows: 50, cols: 200 },
+      headers: auth_headers, as: :json
+    assert_response :created
+    assert_equal 2, response.parsed_body["seq"]
+
+    inputs = directive.inputs.order(:seq).to_a
+    assert_equal "1+1\n", inputs.first.bytes
+    assert_equal [50, 200], [inputs.last.rows, inputs.last.cols]
+  end
+
+  test "input rejects invalid events" do
+    directive = create_interactive_directive
+
+    post input_directive_url(directive), params: { type: "keypress" }, headers: auth_headers, as: :json
+    assert_response :unprocessable_entity
+
+    post input_directive_url(directive), params: { type: "data", bytes: "not base64!" }, headers: auth_headers, as: :json
+    assert_response :unprocessable_entity
+
+    post input_directive_url(directive), params: { type: "resize", rows: 0, cols: 80 }, headers: auth_headers, as: :json
+    assert_response :unprocessable_entity
+
+    assert_equal 0, directive.inputs.count
+  end
+
+  test "input requires a running interactive directive" do
+    directive = create_interactive_directive
+    directive.update!(interactive: nil)
+    post input_directive_url(directive), params: { type: "eof" }, headers: auth_headers, as: :json
+    assert_response :unprocessable_entity
+
+    directive.update!(interactive: { "rows" => 24 }, state: "succeeded")
+    post input_directive_url(directive), params: { type: "eof" }, headers: auth_headers, as: :json
+    assert_response :conflict
+  end
+
   # --- Auth guards ---
 
   test "create without auth headers returns unauthorized" do
@@ -321,6 +445,24 @@ class Mothership::API::V1::FacilityDirectivesControllerTest < ActionDispatch::In
     "/mothership/api/v1/facilities/#{@facility.id}/directives/#{directive.id}/reject"
   end
 
+  def input_directive_url(directive)
+    "/mothership/api/v1/facilities/#{@facility.id}/directives/#{directive.id}/input"
+  end
+
+  def create_interactive_directive
+    Conduits::Directive.create!(
+      account: @account,
+      facility: @facility,
+      territory: @territory,
+      requested_by_user: @user,
+      command: "python3",
+      sandbox_profile: "untrusted",
+      timeout_seconds: 60,
+      state: "running",
+      interactive: { "rows" => 24, "cols" => 80 }
+    )
+  end
+
   def create_awaiting_directive
     directive = Conduits::Directive.new(
       account: @account,
@@ -0,0 +1,66 @@
+require "test_helper"
+
+class Mothership::API::V1::SecretsControllerTest < ActionDispatch::IntegrationTest
+  setup do
+    @account = Account.create!(name: "test-account")
+    @user = User.create!(account: @account, name: "test-user")
+  end
+
+  test "create stores a secret without echoing its value" do
+    post "/mothership/api/v1/secrets", params: { name: "npm_token", value: "npm-123" }, headers: auth_headers, as: :json
+
+    assert_response :created
+    assert_equal "npm_token", response.parsed_body["name"]
+    assert_not response.parsed_body.key?("value")
+    assert_equal "npm-123", Conduits::Secret.find(response.parsed_body["id"]).value
+  end
+
+  test "create rejects invalid names and duplicates" do
+    post "/mothership/api/v1/secrets", params: { name: "bad name", value: "x" }, headers: auth_headers, as: :json
+    assert_response :unprocessable_entity
+
+    Conduits::Secret.create!(account: @account, name: "dup", value: "x")
+    post "/mothership/api/v1/secrets", params: { name: "dup", value: "y" }, headers: auth_headers, as: :json
+    assert_response :unprocessable_entity
+  end
+
+  test "index lists names for the current account only" do
+    Conduits::Secret.create!(account: @account, name: "mine", value: "x")
+    Conduits::Secret.create!(account: Account.create!(name: "other"), name: "theirs", value: "y")
+
+    get "/mothership/api/v1/secrets", headers: auth_headers, as: :json
+
+    assert_response :ok
+    assert_equal %w[mine], response.parsed_body["secrets"].map { |s| s["name"] }
+    assert response.parsed_body["secrets"].none? { |s| s.key?("value") }
+  end
+
+  test "update replaces the value and destroy removes the secret" do
+    secret = Conduits::Secret.create!(account: @account, name: "rotating", value: "old")
+
+    patch "/mothership/api/v1/secrets/#{secret.id}", params: { value: "new" }, headers: auth_headers, as: :json
+    assert_response :ok
+    assert_equal "new", secret.reload.value
+
+    delete "/mothership/api/v1/secrets/#{secret.id}", headers: auth_headers, as: :json
+    assert_response :ok
+    assert_not Conduits::Secret.exists?(secret.id)
+  end
+
+  test "cannot touch another account's secret" do
+    foreign = Conduits::Secret.create!(account: Account.create!(name: "other"), name: "theirs", value: "y")
+
+    patch "/mothership/api/v1/secrets/#{foreign.id}", params: { value: "z" }, headers: auth_headers, as: :json
+    assert_response :not_found
+    assert_equal "y", foreign.reload.value
+  end
+
+  private
+
+  def auth_headers
+    {
+      "X-Account-Id" => @account.id,
+      "X-User-Id" => @user.id,
+    }
+  end
+end
@@ -0,0 +1,63 @@
+require "test_helper"
+
+class Conduits::NetHttpRulesV1Test < ActiveSupport::TestCase
+  # Shared with nexus/netpolicy (Go); see the file's description.
+  VECTORS_PATH = Rails.root.join("..", "nexus", "docs", "protocol", "directivespec_capabilities_net.http_rules.v1.json")
+
+  def vectors
+    @vectors ||= JSON.parse(VECTORS_PATH.read)
+  end
+
+  test "parse_rule matches the shared vectors" do
+    vectors["parse"].each do |c|
+      if c["error"]
+        assert_raises(Conduits::NetPolicyV1::ParseError, c["rule"]) { Conduits::NetHttpRulesV1.parse_rule(c["rule"]) }
+        next
+      end
+
+      rule = Conduits::NetHttpRulesV1.parse_rule(c["rule"])
+      assert_equal c["canonical"], rule.to_s, c["rule"]
+      assert_equal c["canonical"], Conduits::NetHttpRulesV1.parse_rule(c["canonical"]).to_s, "#{c["rule"]}: canonical form does not round-trip"
+    end
+  end
+
+  test "canonicalize normalizes, deduplicates and drops empty lists" do
+    got = Conduits::NetHttpRulesV1.canonicalize(allow: ["get https://Example.com:443/**", "GET https://example.com/**"], deny: [])
+    assert_equal({ "allow" => ["GET https://example.com/**"] }, got)
+
+    assert_nil Conduits::NetHttpRulesV1.canonicalize("allow" => [], "deny" => [])
+    assert_nil Conduits::NetHttpRulesV1.canonicalize(nil)
+    assert_raises(Conduits::NetPolicyV1::ParseError) { Conduits::NetHttpRulesV1.canonicalize("allowed" => []) }
+  end
+
+  test "merge unites deny lists and intersects allow rules per origin" do
+    existing = {
+      "allow" => ["GET https://registry.npmjs.org/**", "GET http://example.com/**"],
+      "deny" => ["DELETE https://api.github.com/**"],
+    }
+    incoming = {
+      "allow" => ["GET https://registry.npmjs.org/**", "HEAD https://registry.npmjs.org/**", "POST https://api.github.com/graphql"],
+      "deny" => ["DELETE https://api.github.com/**", "* http://example.com/admin/**"],
+    }
+    got = Conduits::NetHttpRulesV1.merge(existing, incoming)
+
+    assert_equal ["GET https://registry.npmjs.org/**", "GET http://example.com/**", "POST https://api.github.com/graphql"], got["allow"]
+    assert_equal ["DELETE https://api.github.com/**", "* http://example.com/admin/**"], got["deny"]
+  end
+
+  test "merge denies an origin whose allow rules have nothing in common" do
+    got = Conduits::NetHttpRulesV1.merge(
+      { "allow" => ["GET https://example.com:8443/a/**"] },
+      { "allow" => ["GET https://example.com:8443/b/**"] }
+    )
+
+    assert_nil got["allow"]
+    assert_equal ["* https://example.com:8443/**"], got["deny"]
+  end
+
+  test "merge with one side empty returns the other canonicalized" do
+    assert_equal({ "deny" => ["DELETE https://api.github.com/**"] },
+                 Conduits::NetHttpRulesV1.merge(nil, { "deny" => ["delete https://API.github.com/**"] }))
+    assert_nil Conduits::NetHttpRulesV1.merge(nil, nil)
+  end
+end
@@ -0,0 +1,55 @@
+require "test_helper"
+
+class Conduits::NetPolicyV2Test < ActiveSupport::TestCase
+  # Shared with nexus/netpolicy (Go); see the file's description.
+  VECTORS_PATH = Rails.root.join("..", "nexus", "docs", "protocol", "directivespec_capabilities_net.allowlist.v2.json")
+
+  def vectors
+    @vectors ||= JSON.parse(VECTORS_PATH.read)
+  end
+
+  test "parse_entry matches the shared vectors" do
+    vectors["parse"].each do |c|
+      if c["error"]
+        assert_raises(Conduits::NetPolicyV1::ParseError, c["entry"]) { Conduits::NetPolicyV2.parse_entry(c["entry"]) }
+        assert_raises(Conduits::NetPolicyV1::ParseError, c["entry"]) { Conduits::NetPolicyV1.parse_entry(c["entry"]) }
+        next
+      end
+
+      entry = Conduits::NetPolicyV2.parse_entry(c["entry"])
+      assert_equal c["canonical"], entry.to_s, c["entry"]
+      assert_equal c["canonical"], Conduits::NetPolicyV2.parse_entry(c["canonical"]).to_s, "#{c["entry"]}: canonical form does not round-trip"
+
+      if c["v1_error"]
+        assert_raises(Conduits::NetPolicyV1::ParseError, c["entry"]) { Conduits::NetPolicyV1.parse_entry(c["entry"]) }
+      else
+        assert_equal c["canonical"], Conduits::NetPolicyV1.parse_entry(c["entry"]).to_s, c["entry"]
+      end
+    end
+  end
+
+  test "match? matches the shared vectors" do
+    vectors["match"].each do |c|
+      entry = Conduits::NetPolicyV2.parse_entry(c["entry"])
+      proto = c["proto"] || Conduits::NetPolicyV2::PROTO_TCP
+      assert_equal c["expected"], Conduits::NetPolicyV2.match?(entry, c["host"], c["port"].to_s, proto: proto),
+                   "match?(#{c["entry"]}, #{c["host"]}, #{c["port"]}/#{proto})"
+    end
+  end
+
+  test "private opt-in matches the shared vectors" do
+    vectors["resolved"].each do |c|
+      entry = Conduits::NetPolicyV2.parse_entry(c["entry"])
+      got = !Conduits::NetPolicyV2.private_ip?(c["ip"]) ||
+            (entry.private && Conduits::NetPolicyV2.opt_in_private_ip?(c["ip"]))
+      assert_equal c["expected"], got, "#{c["entry"]} resolving to #{c["ip"]}"
+    end
+  end
+
+  test "parse_allowlist dispatches on allowlist_version" do
+    assert_equal ["github.com:443"], Conduits::NetPolicyV2.parse_allowlist(nil, ["GitHub.com:443"]).map(&:to_s)
+    assert_raises(Conduits::NetPolicyV1::ParseError) { Conduits::NetPolicyV2.parse_allowlist(1, ["10.0.0.0/8:443"]) }
+    assert_equal ["private:10.0.0.0/8:443"], Conduits::NetPolicyV2.parse_allowlist(2, ["private:10.0.0.0/8:443"]).map(&:to_s)
+    assert_raises(Conduits::NetPolicyV1::ParseError) { Conduits::NetPolicyV2.parse_allowlist(3, ["github.com:443"]) }
+  end
+end
@@ -0,0 +1,41 @@
+require "test_helper"
+
+class Conduits::NetPresetsV1Test < ActiveSupport::TestCase
+  # Shared with nexus/netpolicy (Go); see the file's description.
+  VECTORS_PATH = Rails.root.join("..", "nexus", "docs", "protocol", "directivespec_capabilities_net.presets.v1.json")
+
+  def vectors
+    @vectors ||= JSON.parse(VECTORS_PATH.read)
+  end
+
+  test "preset table matches the shared vectors" do
+    assert_equal vectors["presets"], Conduits::NetPresetsV1::PRESETS
+  end
+
+  test "custom and blank presets have no base policy" do
+    assert_nil Conduits::NetPresetsV1.preset_for("custom")
+    assert_nil Conduits::NetPresetsV1.preset_for("")
+  end
+
+  test "expand matches the shared vectors" do
+    vectors["cases"].each do |c|
+      if c["error"]
+        assert_raises(Conduits::NetPolicyV1::ParseError, c["name"]) { Conduits::NetPresetsV1.expand(c["input"]) }
+        next
+      end
+
+      got = Conduits::NetPresetsV1.expand(c["input"])
+      assert_equal c["expected"], got, c["name"]
+      assert_equal got, Conduits::NetPresetsV1.expand(got), "#{c["name"]}: expansion not idempotent"
+    end
+  end
+
+  test "expand accepts symbol keys and does not modify its input" do
+    input = { mode: "allowlist", preset: "strict", allow: ["Example.com:443"] }
+    got = Conduits::NetPresetsV1.expand(input)
+
+    assert_equal "allowlist", got["mode"]
+    assert_includes got["allow"], "example.com:443"
+    assert_equal ["Example.com:443"], input[:allow]
+  end
+end
@@ -165,6 +165,35 @@ class Conduits::DirectiveTest < ActiveSupport::TestCase
     assert_equal 1_048_576, @directive.max_diff_bytes
   end
 
+  # Sessions
+
+  test "session spec is optional and validated" do
+    assert_not @directive.session?
+
+    @directive.session = { "id" => "build-1", "idle_timeout_seconds" => 600, "close" => false }
+    assert @directive.valid?
+    assert_equal "build-1", @directive.session_id
+
+    @directive.session = { "id" => "a/b" }
+    assert_not @directive.valid?
+
+    @directive.session = { "id" => "x" * 129 }
+    assert_not @directive.valid?
+
+    @directive.session = { "id" => "build-1", "idle_timeout_seconds" => 0 }
+    assert_not @directive.valid?
+
+    @directive.session = { "id" => "build-1", "reuse" => true }
+    assert_not @directive.valid?
+  end
+
+  test "in_session scope matches the session id" do
+    @directive.update!(session: { "id" => "build-1" })
+
+    assert_includes Conduits::Directive.in_session("build-1"), @directive
+    assert_empty Conduits::Directive.in_session("build-2")
+  end
+
   # Approval transitions
 
   test "approve transitions from awaiting_approval to queued" do
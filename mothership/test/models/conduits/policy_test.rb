@@ -109,6 +109,84 @@ class Conduits::PolicyTest < ActiveSupport::TestCase
     assert_includes result[:net]["allow"], "github.com:443"
   end
 
+  test "effective_for expands net presets before intersecting allow lists" do
+    create_policy(
+      name: "global", priority: 0,
+      scope_type: nil, scope_id: nil,
+      net: { "mode" => "allowlist", "preset" => "loose" }
+    )
+    create_policy(
+      name: "account", priority: 10,
+      scope_type: "Account", scope_id: @account.id,
+      net: { "mode" => "allowlist", "allow" => ["rubygems.org:443", "example.com:443"] }
+    )
+
+    result = Conduits::Policy.effective_for(@directive)
+    assert_equal ["rubygems.org:443"], result[:net]["allow"]
+    # The intersection is no longer the preset's allowlist.
+    assert_equal "custom", result[:net]["preset"]
+  end
+
+  test "effective_for merges http_rules into a ceiling" do
+    create_policy(
+      name: "global", priority: 0,
+      scope_type: nil, scope_id: nil,
+      net: {
+        "mode" => "unrestricted",
+        "http_rules" => {
+          "allow" => ["GET https://registry.npmjs.org/**", "* http://example.com/api/**"],
+          "deny" => ["DELETE https://api.github.com/**"],
+        },
+      }
+    )
+    create_policy(
+      name: "account", priority: 10,
+      scope_type: "Account", scope_id: @account.id,
+      net: {
+        "mode" => "allowlist", "allow" => ["registry.npmjs.org:443", "api.github.com:443"],
+        "http_rules" => { "allow" => ["get https://registry.npmjs.org/**", "HEAD https://registry.npmjs.org/**", "GET http://example.com/"] },
+      }
+    )
+
+    result = Conduits::Policy.effective_for(@directive)
+    assert_equal "allowlist", result[:net]["mode"]
+    assert_equal ["GET https://registry.npmjs.org/**"], result[:net]["http_rules"]["allow"]
+    assert_equal ["DELETE https://api.github.com/**", "* http://example.com/**"], result[:net]["http_rules"]["deny"]
+  end
+
+  test "validate_net_structure checks http_rules" do
+    policy = Conduits::Policy.new(
+      account: @account, name: "rules", priority: 0,
+      net: { "mode" => "unrestricted", "http_rules" => { "deny" => ["DELETE https://api.github.com/**"] } }
+    )
+    assert policy.valid?, policy.errors.full_messages.inspect
+
+    policy.net = { "mode" => "unrestricted", "http_rules" => { "deny" => ["DELETE https://*.github.com/**"] } }
+    refute policy.valid?
+    assert policy.errors[:net].any? { |e| e.include?("exact name") }
+  end
+
+  test "validate_net_structure rejects unknown presets" do
+    policy = Conduits::Policy.new(
+      account: @account, name: "bad-preset", priority: 0,
+      net: { "mode" => "allowlist", "preset" => "paranoid" }
+    )
+    refute policy.valid?
+    assert policy.errors[:net].any? { |e| e.include?("unknown net preset") }
+  end
+
+  test "validate_net_structure checks allowlist V2 entries" do
+    policy = Conduits::Policy.new(
+      account: @account, name: "v2", priority: 0,
+      net: { "mode" => "allowlist", "allowlist_version" => 2, "allow" => ["private:10.20.0.0/16:443"] }
+    )
+    assert policy.valid?, policy.errors.full_messages.inspect
+
+    policy.net = { "mode" => "allowlist", "allowlist_version" => 2, "allow" => ["10.20.0.0/16:443"] }
+    refute policy.valid?
+    assert policy.errors[:net].any? { |e| e.include?("private") }
+  end
+
   # effective_for — secrets priority replace
 
   test "effective_for uses priority replace for secrets" do
@@ -0,0 +1,73 @@
+require "test_helper"
+
+class Conduits::DiffUploadIngestorTest < ActiveSupport::TestCase
+  include ConduitsDirectiveHelpers
+
+  setup do
+    setup_conduits_directive
+    @diff = "diff --git a/x b/x\n" + ("+line\n" * 100)
+    @sha = Digest::SHA256.hexdigest(@diff)
+    @ingestor = Conduits::DiffUploadIngestor.new(@directive)
+  end
+
+  test "reassembles chunks into diff_blob" do
+    first = @diff.byteslice(0, 200)
+    rest = @diff.byteslice(200..)
+
+    result = @ingestor.append!(sha256: @sha, total_bytes: @diff.bytesize, offset: 0, bytes: first)
+    assert_equal 200, result.received_bytes
+    assert_not result.complete
+    assert_equal 200, @ingestor.status(sha256: @sha).received_bytes
+
+    result = @ingestor.append!(sha256: @sha, total_bytes: @diff.bytesize, offset: 200, bytes: rest)
+    assert result.complete
+    assert_equal @diff.bytesize, result.received_bytes
+
+    @directive.reload
+    assert_equal @diff, @directive.diff_blob.download
+    assert_equal 0, @directive.diff_upload.chunks.count
+
+    # Replaying the last chunk after completion is acknowledged.
+    assert @ingestor.append!(sha256: @sha, total_bytes: @diff.bytesize, offset: 200, bytes: rest).complete
+  end
+
+  test "rejects chunks at the wrong offset" do
+    @ingestor.append!(sha256: @sha, total_bytes: @diff.bytesize, offset: 0, bytes: @diff.byteslice(0, 100))
+
+    assert_raises(Conduits::DiffUploadIngestor::OffsetMismatch) do
+      @ingestor.append!(sha256: @sha, total_bytes: @diff.bytesize, offset: 50, bytes: @diff.byteslice(50, 50))
+    end
+    assert_equal 100, @ingestor.status(sha256: @sha).received_bytes
+  end
+
+  test "a different sha256 starts over" do
+    @ingestor.append!(sha256: @sha, total_bytes: @diff.bytesize, offset: 0, bytes: @diff.byteslice(0, 100))
+
+    other = "other diff\n"
+    other_sha = Digest::SHA256.hexdigest(other)
+    assert_equal 0, @ingestor.status(sha256: other_sha).received_bytes
+
+    result = @ingestor.append!(sha256: other_sha, total_bytes: other.bytesize, offset: 0, bytes: other)
+    assert result.complete
+    assert_equal 0, @ingestor.status(sha256: @sha).received_bytes
+  end
+
+  test "restarts the upload when the whole diff does not match sha256" do
+    wrong = "x" * @diff.bytesize
+
+    assert_raises(ArgumentError) do
+      @ingestor.append!(sha256: @sha, total_bytes: wrong.bytesize, offset: 0, bytes: wrong)
+    end
+    assert_equal 0, @ingestor.status(sha256: @sha).received_bytes
+    assert_not @directive.reload.diff_blob.attached?
+  end
+
+  test "conflicts with another diff once complete" do
+    @ingestor.append!(sha256: @sha, total_bytes: @diff.bytesize, offset: 0, bytes: @diff)
+
+    other = "other diff\n"
+    assert_raises(Conduits::DiffUploadIngestor::Conflict) do
+      @ingestor.append!(sha256: Digest::SHA256.hexdigest(other), total_bytes: other.bytesize, offset: 0, bytes: other)
+    end
+  end
+end
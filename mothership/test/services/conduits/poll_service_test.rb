@@ -27,6 +27,7 @@ class Conduits::PollServiceTest < ActiveSupport::TestCase
     assert_equal 1, result.directives.size
     assert_equal directive.id, result.directives.first[:directive_id]
     assert result.directives.first[:directive_token].present?
+    assert_equal directive.account_id, result.directives.first[:tenant]
 
     directive.reload
     assert directive.leased?
@@ -309,16 +310,120 @@ class Conduits::PollServiceTest < ActiveSupport::TestCase
     assert_equal Conduits::PollService::DEFAULT_LEASE_TTL, result.lease_ttl_seconds
   end
 
+  test "spec carries interactive only for interactive directives" do
+    interactive = create_directive(interactive: { "rows" => 40, "cols" => 120 })
+
+    result = Conduits::PollService.new(
+      territory: @territory,
+      supported_profiles: %w[untrusted],
+      max_claims: 1
+    ).call
+
+    spec = result.directives.first[:spec]
+    assert_equal interactive.id, spec[:directive_id]
+    assert_equal({ "rows" => 40, "cols" => 120 }, spec[:interactive])
+
+    @facility.unlock!(interactive.reload)
+    create_directive
+    result = Conduits::PollService.new(
+      territory: @territory,
+      supported_profiles: %w[untrusted],
+      max_claims: 1
+    ).call
+    assert_not result.directives.first[:spec].key?(:interactive)
+  end
+
+  test "records the sessions the territory reports" do
+    poll(sessions: %w[s-2 s-1])
+    assert_equal %w[s-1 s-2], @territory.reload.open_sessions
+
+    poll
+    assert_equal [], @territory.reload.open_sessions
+  end
+
+  test "spec carries the session" do
+    directive = create_directive(session: { "id" => "s-1", "close" => true })
+
+    spec = poll.directives.first[:spec]
+    assert_equal directive.id, spec[:directive_id]
+    assert_equal({ "id" => "s-1", "close" => true }, spec[:session])
+  end
+
+  test "session directives go to the territory holding the session" do
+    other = Conduits::Territory.create!(account: @account, name: "other-territory")
+    other.activate!
+    other.update_columns(open_sessions: %w[s-1])
+    directive = create_directive(session: { "id" => "s-1" })
+
+    assert_empty poll.directives
+    assert directive.reload.queued?
+
+    result = poll(territory: other, sessions: %w[s-1])
+    assert_equal [directive.id], result.directives.map { |l| l[:directive_id] }
+  end
+
+  test "session stays with the territory its last directive left it open on" do
+    other = Conduits::Territory.create!(account: @account, name: "other-territory")
+    other.activate!
+    create_directive(
+      session: { "id" => "s-1" },
+      state: "succeeded",
+      territory: other,
+      artifacts_manifest: { "session" => { "id" => "s-1", "opened" => true, "open" => true } }
+    )
+    directive = create_directive(session: { "id" => "s-1" })
+
+    assert_empty poll.directives
+
+    result = poll(territory: other)
+    assert_equal [directive.id], result.directives.map { |l| l[:directive_id] }
+  end
+
+  test "a session nobody holds opens on the polling territory" do
+    directive = create_directive(session: { "id" => "s-1" })
+
+    result = poll
+    assert_equal [directive.id], result.directives.map { |l| l[:directive_id] }
+  end
+
+  test "session directives are leased one at a time in order" do
+    first = create_directive(session: { "id" => "s-1" })
+    second = create_directive(session: { "id" => "s-1" })
+    @territory.update!(capacity: { "max_concurrent" => 5 })
+
+    result = poll(max_claims: 5)
+    assert_equal [first.id], result.directives.map { |l| l[:directive_id] }
+
+    # Still running: the next one waits even with the facility free.
+    @facility.unlock!(first.reload)
+    assert_empty poll(max_claims: 5, sessions: %w[s-1]).directives
+
+    first.start!
+    first.succeed!
+    result = poll(max_claims: 5, sessions: %w[s-1])
+    assert_equal [second.id], result.directives.map { |l| l[:directive_id] }
+  end
+
   private
 
-  def create_directive(sandbox_profile: "untrusted")
+  def poll(territory: @territory, max_claims: 1, sessions: [])
+    Conduits::PollService.new(
+      territory: territory,
+      supported_profiles: %w[untrusted],
+      max_claims: max_claims,
+      sessions: sessions
+    ).call
+  end
+
+  def create_directive(sandbox_profile: "untrusted", **attrs)
     Conduits::Directive.create!(
       account: @account,
       facility: @facility,
       requested_by_user: @user,
       command: "echo hello",
       sandbox_profile: sandbox_profile,
-      timeout_seconds: 60
+      timeout_seconds: 60,
+      **attrs
     )
   end
 end
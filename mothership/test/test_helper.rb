@@ -16,3 +16,42 @@ module ActiveSupport
     # Add more helper methods to be used by all tests here...
   end
 end
+
+# Fixture for the Nexus-facing per-directive endpoints: an account with a
+# territory, a facility and a directive leased to that territory, plus the
+# headers Nexus sends with its directive token.
+module ConduitsDirectiveHelpers
+  def setup_conduits_directive(state: "running", **attrs)
+    @account = Account.create!(name: "test-account")
+    @user = User.create!(account: @account, name: "test-user")
+    @territory = Conduits::Territory.create!(account: @account, name: "test-territory")
+    @territory.activate!
+    @facility = Conduits::Facility.create!(
+      account: @account,
+      owner: @user,
+      territory: @territory,
+      kind: "repo",
+      retention_policy: "keep_last_5"
+    )
+    @directive = Conduits::Directive.create!(
+      account: @account,
+      facility: @facility,
+      territory: @territory,
+      requested_by_user: @user,
+      command: "echo hello",
+      sandbox_profile: "untrusted",
+      timeout_seconds: 60,
+      state: state,
+      **attrs
+    )
+    @directive_token = Conduits::DirectiveToken.encode(directive_id: @directive.id, territory_id: @territory.id)
+    @directive
+  end
+
+  def directive_headers(extra = {})
+    {
+      "X-Nexus-Territory-Id" => @territory.id,
+      "Authorization" => "Bearer #{@directive_token}",
+    }.merge(extra)
+  end
+end
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -22,6 +23,11 @@ type Client struct {
 	baseURL     string
 	hc          *http.Client
 	territoryID string
+
+	// uploadHC shares hc's transport but has no client-wide timeout: bulk
+	// uploads (artifacts) can legitimately outlast the long-poll timeout and
+	// are bounded by the caller's context instead.
+	uploadHC *http.Client
 }
 
 func New(cfg config.Config) (*Client, error) {
@@ -39,6 +45,7 @@ func New(cfg config.Config) (*Client, error) {
 		baseURL:     strings.TrimSuffix(cfg.ServerURL, "/"),
 		hc:          hc,
 		territoryID: cfg.TerritoryID,
+		uploadHC:    &http.Client{Transport: hc.Transport},
 	}, nil
 }
 
@@ -100,10 +107,79 @@ func (c *Client) LogChunk(ctx context.Context, directiveID, directiveToken strin
 	return c.postJSON(ctx, fmt.Sprintf("/conduits/v1/directives/%s/log_chunks", directiveID), directiveToken, req, nil)
 }
 
+// Input long-polls for the input events of an interactive directive.
+// WaitSeconds must stay below the client's request timeout.
+func (c *Client) Input(ctx context.Context, directiveID, directiveToken string, req protocol.InputRequest) (protocol.InputResponse, error) {
+	var out protocol.InputResponse
+	err := c.postJSON(ctx, fmt.Sprintf("/conduits/v1/directives/%s/input", directiveID), directiveToken, req, &out)
+	return out, err
+}
+
+// AuditEvents uploads one batch of egress audit events.
+func (c *Client) AuditEvents(ctx context.Context, directiveID, directiveToken string, req protocol.AuditEventsRequest) error {
+	return c.postJSON(ctx, fmt.Sprintf("/conduits/v1/directives/%s/audit_events", directiveID), directiveToken, req, nil)
+}
+
 func (c *Client) Finished(ctx context.Context, directiveID, directiveToken string, req protocol.FinishedRequest) error {
 	return c.postJSON(ctx, fmt.Sprintf("/conduits/v1/directives/%s/finished", directiveID), directiveToken, req, nil)
 }
 
+// FetchSecrets resolves the directive's secret refs. Values are returned in
+// the response body only; callers must keep them in memory.
+func (c *Client) FetchSecrets(ctx context.Context, directiveID, directiveToken string, req protocol.SecretsRequest) (protocol.SecretsResponse, error) {
+	var out protocol.SecretsResponse
+	err := c.postJSON(ctx, fmt.Sprintf("/conduits/v1/directives/%s/secrets", directiveID), directiveToken, req, &out)
+	return out, err
+}
+
+// UploadDiffChunk appends one chunk to a chunked diff upload and returns the
+// server's updated status.
+func (c *Client) UploadDiffChunk(ctx context.Context, directiveID, directiveToken string, req protocol.DiffChunkRequest) (protocol.DiffUploadStatus, error) {
+	var out protocol.DiffUploadStatus
+	err := c.postJSON(ctx, fmt.Sprintf("/conduits/v1/directives/%s/diff_chunks", directiveID), directiveToken, req, &out)
+	return out, err
+}
+
+// DiffUploadStatus returns how much of the diff identified by sha256 the
+// server has received, so an interrupted upload can resume.
+func (c *Client) DiffUploadStatus(ctx context.Context, directiveID, directiveToken, sha256 string) (protocol.DiffUploadStatus, error) {
+	var out protocol.DiffUploadStatus
+	req, err := c.newRequest(ctx, http.MethodGet,
+		fmt.Sprintf("/conduits/v1/directives/%s/diff_chunks?sha256=%s", directiveID, url.QueryEscape(sha256)),
+		directiveToken, "application/json", nil)
+	if err != nil {
+		return out, err
+	}
+	err = c.do(c.hc, req, &out)
+	return out, err
+}
+
+// UploadArtifact streams one collected artifact file. body must yield exactly
+// meta.Size bytes whose SHA-256 is meta.SHA256; the server verifies both.
+func (c *Client) UploadArtifact(ctx context.Context, directiveID, directiveToken string, meta protocol.ArtifactFile, body io.Reader) error {
+	q := url.Values{}
+	q.Set("path", meta.Path)
+	q.Set("size", strconv.FormatInt(meta.Size, 10))
+	q.Set("sha256", meta.SHA256)
+	q.Set("mode", meta.Mode)
+
+	req, err := c.newRequest(ctx, http.MethodPut,
+		fmt.Sprintf("/conduits/v1/directives/%s/artifacts?%s", directiveID, q.Encode()),
+		directiveToken, "application/octet-stream", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = meta.Size
+	return c.do(c.uploadClient(), req, nil)
+}
+
+func (c *Client) uploadClient() *http.Client {
+	if c.uploadHC != nil {
+		return c.uploadHC
+	}
+	return c.hc
+}
+
 func (c *Client) postJSON(ctx context.Context, path string, directiveToken string, in any, out any) error {
 	var body io.Reader
 	if in != nil {
@@ -116,11 +192,19 @@ func (c *Client) postJSON(ctx context.Context, path string, directiveToken strin
 		body = bytes.NewReader([]byte("{}"))
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, body)
+	req, err := c.newRequest(ctx, http.MethodPost, path, directiveToken, "application/json", body)
 	if err != nil {
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return c.do(c.hc, req, out)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path, directiveToken, contentType string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
 	if c.territoryID != "" {
 		// Dev convenience: real auth should come from mTLS identity at the edge.
 		req.Header.Set("X-Nexus-Territory-Id", c.territoryID)
@@ -128,8 +212,13 @@ func (c *Client) postJSON(ctx context.Context, path string, directiveToken strin
 	if directiveToken != "" {
 		req.Header.Set("Authorization", "Bearer "+directiveToken)
 	}
+	return req, nil
+}
 
-	resp, err := c.hc.Do(req)
+// do sends req and decodes a JSON response into out (if non-nil).
+// Non-2xx responses are returned as HTTPError.
+func (c *Client) do(hc *http.Client, req *http.Request, out any) error {
+	resp, err := hc.Do(req)
 	if err != nil {
 		return err
 	}
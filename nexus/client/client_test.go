@@ -3,9 +3,11 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -259,6 +261,38 @@ func TestLogChunk_Success(t *testing.T) {
 	}
 }
 
+// --- AuditEvents ---
+
+func TestAuditEvents_Success(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/conduits/v1/directives/d-3/audit_events" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		var req protocol.AuditEventsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		if req.Seq != 2 || len(req.Events) != 1 || req.Events[0].DestHost != "github.com" || req.Events[0].Decision != "allow" {
+			t.Errorf("unexpected batch: %+v", req)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(t, srv)
+	err := cli.AuditEvents(context.Background(), "d-3", "jwt-tok", protocol.AuditEventsRequest{
+		Seq: 2,
+		Events: []protocol.EgressAuditEvent{
+			{DirectiveID: "d-3", DestHost: "github.com", DestPort: 443, Decision: "allow", ReasonCode: "OK"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // --- Finished ---
 
 func TestFinished_Success(t *testing.T) {
@@ -415,6 +449,194 @@ func TestPostJSON_Headers(t *testing.T) {
 	}
 }
 
+// --- Secrets ---
+
+func TestFetchSecrets_Success(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/conduits/v1/directives/d-1/secrets" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("unexpected auth: %s", r.Header.Get("Authorization"))
+		}
+		var req protocol.SecretsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		if len(req.Refs) != 1 || req.Refs[0] != "github_token" {
+			t.Errorf("unexpected refs: %v", req.Refs)
+		}
+		_ = json.NewEncoder(w).Encode(protocol.SecretsResponse{
+			Secrets: []protocol.SecretValue{{Ref: "github_token", Value: "ghp_x"}},
+		})
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(t, srv)
+	resp, err := cli.FetchSecrets(context.Background(), "d-1", "tok", protocol.SecretsRequest{Refs: []string{"github_token"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Secrets) != 1 || resp.Secrets[0].Value != "ghp_x" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+func TestInput_Success(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/conduits/v1/directives/d-1/input" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("unexpected auth: %s", r.Header.Get("Authorization"))
+		}
+		var req protocol.InputRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		if req.After != 3 || req.WaitSeconds != 15 {
+			t.Errorf("unexpected request body: %+v", req)
+		}
+		_ = json.NewEncoder(w).Encode(protocol.InputResponse{
+			Events: []protocol.InputEvent{
+				{Seq: 4, Type: "data", BytesBase64: "bHMK"},
+				{Seq: 5, Type: "resize", Rows: 40, Cols: 120},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(t, srv)
+	resp, err := cli.Input(context.Background(), "d-1", "tok", protocol.InputRequest{After: 3, WaitSeconds: 15})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(resp.Events) != 2 || resp.Events[0].BytesBase64 != "bHMK" || resp.Events[1].Cols != 120 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+// --- Diff upload ---
+
+func TestUploadDiffChunk_Success(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/conduits/v1/directives/d-1/diff_chunks" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		var req protocol.DiffChunkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		if req.SHA256 != "abc" || req.Offset != 10 || req.TotalBytes != 20 || req.BytesBase64 != "eA==" {
+			t.Errorf("unexpected chunk: %+v", req)
+		}
+		_ = json.NewEncoder(w).Encode(protocol.DiffUploadStatus{ReceivedBytes: 11})
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(t, srv)
+	st, err := cli.UploadDiffChunk(context.Background(), "d-1", "tok", protocol.DiffChunkRequest{
+		SHA256: "abc", TotalBytes: 20, Offset: 10, BytesBase64: "eA==", ChunkSHA256: "def",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.ReceivedBytes != 11 || st.Complete {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+func TestDiffUploadStatus_Success(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/conduits/v1/directives/d-1/diff_chunks" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if r.URL.Query().Get("sha256") != "abc" {
+			t.Errorf("unexpected query: %s", r.URL.RawQuery)
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("unexpected auth: %s", r.Header.Get("Authorization"))
+		}
+		_ = json.NewEncoder(w).Encode(protocol.DiffUploadStatus{ReceivedBytes: 20, Complete: true})
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(t, srv)
+	st, err := cli.DiffUploadStatus(context.Background(), "d-1", "tok", "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if st.ReceivedBytes != 20 || !st.Complete {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+// --- UploadArtifact ---
+
+func TestUploadArtifact_Success(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		if r.URL.Path != "/conduits/v1/directives/d-1/artifacts" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("path") != "out/report.txt" || q.Get("size") != "5" || q.Get("sha256") != "abc" || q.Get("mode") != "0644" {
+			t.Errorf("unexpected query: %s", r.URL.RawQuery)
+		}
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			t.Errorf("unexpected auth: %s", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("Content-Type") != "application/octet-stream" {
+			t.Errorf("unexpected content type: %s", r.Header.Get("Content-Type"))
+		}
+		if r.ContentLength != 5 {
+			t.Errorf("expected content length 5, got %d", r.ContentLength)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "hello" {
+			t.Errorf("unexpected body: %q", body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(t, srv)
+	meta := protocol.ArtifactFile{Path: "out/report.txt", Size: 5, Mode: "0644", SHA256: "abc"}
+	if err := cli.UploadArtifact(context.Background(), "d-1", "tok", meta, strings.NewReader("hello")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestUploadArtifact_HTTPError(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		_, _ = w.Write([]byte("too large"))
+	}))
+	defer srv.Close()
+
+	cli := newTestClient(t, srv)
+	meta := protocol.ArtifactFile{Path: "big.bin", Size: 1, Mode: "0644", SHA256: "abc"}
+	err := cli.UploadArtifact(context.Background(), "d-1", "tok", meta, strings.NewReader("x"))
+	var he HTTPError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected HTTPError 413, got %v", err)
+	}
+}
+
 // --- HTTPError ---
 
 func TestHTTPError_Error(t *testing.T) {
@@ -3,8 +3,10 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -51,6 +53,105 @@ type LogOverflowConfig struct {
 	MaxBytesPerStream int64 `yaml:"max_bytes_per_stream"`
 }
 
+// LogRedactionConfig controls masking of secrets in streamed logs (and log
+// overflow files). Values from capabilities.secrets are always masked.
+type LogRedactionConfig struct {
+	// DefaultPatterns enables the built-in patterns: AWS access keys and
+	// secret keys, GitHub tokens and HTTP bearer credentials.
+	DefaultPatterns bool `yaml:"default_patterns"`
+
+	// Patterns are additional RE2 regexes. If a pattern has a named group
+	// "secret", only that group is masked (e.g. keep a "password=" prefix).
+	Patterns []RedactionPattern `yaml:"patterns"`
+
+	// MaxMatchBytes bounds the length of a pattern match; it is also how much
+	// output is held back at chunk boundaries so split matches are caught.
+	MaxMatchBytes int `yaml:"max_match_bytes"`
+}
+
+type RedactionPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+}
+
+// ArtifactsConfig bounds artifact collection (DirectiveSpec.artifacts.collect).
+type ArtifactsConfig struct {
+	// MaxFileBytes skips any single matched file larger than this.
+	MaxFileBytes int64 `yaml:"max_file_bytes"`
+	// MaxTotalBytes caps the combined size of collected files per directive.
+	MaxTotalBytes int64 `yaml:"max_total_bytes"`
+	// MaxFiles caps the number of collected files per directive.
+	MaxFiles int `yaml:"max_files"`
+}
+
+// DiffConfig controls how diffs larger than limits.max_diff_bytes are
+// delivered (chunked upload instead of inline diff_base64).
+type DiffConfig struct {
+	// ChunkBytes is the raw size of each uploaded diff chunk.
+	ChunkBytes int `yaml:"chunk_bytes"`
+	// MaxUploadBytes caps the diff size sent via chunked upload. Larger diffs
+	// are truncated inline and reported with diff_truncated.
+	MaxUploadBytes int64 `yaml:"max_upload_bytes"`
+}
+
+// DiskQuotaConfig controls enforcement of limits.disk_mb for facilities on
+// the host filesystem (host/bwrap/container drivers). Firecracker enforces
+// the limit by sizing the workspace image instead.
+type DiskQuotaConfig struct {
+	// ProjectQuota tags the facility with an XFS/ext4 project quota when the
+	// filesystem supports it (requires root and prjquota accounting). The
+	// usage watchdog runs either way.
+	ProjectQuota bool `yaml:"project_quota"`
+	// CheckInterval is how often the watchdog measures facility usage.
+	CheckInterval time.Duration `yaml:"check_interval"`
+}
+
+// EgressLimitsConfig caps every directive's traffic through its egress
+// proxy. A directive's own limits (limits.net_* or x_ext.x_egress_limits)
+// can only tighten these. Zero means no host-wide cap.
+type EgressLimitsConfig struct {
+	BytesPerSec int64         `yaml:"bytes_per_sec"`
+	MaxBytes    int64         `yaml:"max_bytes"`
+	MaxConns    int           `yaml:"max_conns"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+// EgressCacheConfig controls the registry cache of the egress proxies
+// (design 03 §7.10), shared by the directives of a tenant on this host.
+type EgressCacheConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir holds the cache. Empty means <work_dir>/.egress-cache.
+	Dir string `yaml:"dir"`
+	// MaxBytes bounds the cached bodies; least recently used entries are
+	// evicted beyond it.
+	MaxBytes int64 `yaml:"max_bytes"`
+	// MaxEntryBytes bounds one cached body. Zero means MaxBytes.
+	MaxEntryBytes int64 `yaml:"max_entry_bytes"`
+	// MaxAge caps how long a response is served from the cache, whatever
+	// its Cache-Control allows. Zero means no cap.
+	MaxAge time.Duration `yaml:"max_age"`
+	// Hosts are the exact host names whose responses are cached.
+	Hosts []string `yaml:"hosts"`
+}
+
+// SecretsConfig controls delivery of Capabilities.Secrets.
+type SecretsConfig struct {
+	// TmpfsDir is the memory-backed directory (tmpfs/ramfs) under which
+	// per-directive secret files are created. Directives with secrets are
+	// rejected on Linux if it is not memory-backed.
+	TmpfsDir string `yaml:"tmpfs_dir"`
+}
+
+// HelperConfig points nexusd at nexus-helper, the privileged companion
+// daemon. When SocketPath is set, root-only operations (cgroup setup, and
+// later TAP/nftables/jailer) go through the helper so nexusd can run
+// unprivileged.
+type HelperConfig struct {
+	// SocketPath is the helper's Unix socket (e.g. /run/cybros-nexus/helper.sock).
+	// Empty disables the helper.
+	SocketPath string `yaml:"socket_path"`
+}
+
 type DebugTapeConfig struct {
 	// Enabled writes a local JSONL tape for offline debugging.
 	Enabled bool `yaml:"enabled"`
@@ -72,6 +173,15 @@ type TerritoryHeartbeatConfig struct {
 	Interval time.Duration `yaml:"interval"`
 }
 
+// SessionsConfig bounds the long-lived sandboxes kept for
+// DirectiveSpec.Session.
+type SessionsConfig struct {
+	// MaxOpen caps the sessions open at once. 0 disables sessions.
+	MaxOpen int `yaml:"max_open"`
+	// MaxIdleTimeout caps the idle timeout a session may ask for.
+	MaxIdleTimeout time.Duration `yaml:"max_idle_timeout"`
+}
+
 type RootfsArchSourceConfig struct {
 	URL    string `yaml:"url"`
 	SHA256 string `yaml:"sha256"`
@@ -120,6 +230,78 @@ type FirecrackerConfig struct {
 	// ProxySocketDir is where per-directive proxy UDS files are created.
 	// Empty means <work_dir>/.proxy-sockets/
 	ProxySocketDir string `yaml:"proxy_socket_dir"`
+	// Jailer launches the VMM through the Firecracker jailer instead of
+	// exec'ing firecracker directly.
+	Jailer JailerConfig `yaml:"jailer"`
+	// Snapshot enables the snapshot-restore warm pool.
+	Snapshot SnapshotConfig `yaml:"snapshot"`
+	// FacilityMode selects how the facility reaches the guest:
+	//   "copy"  — build a workspace image from the facility directory before
+	//             each run and copy it back afterwards (default).
+	//   "block" — keep a long-lived ext4 image per facility and attach it
+	//             directly; the host directory is only updated by an explicit
+	//             export (done by nexusd when a diff or artifacts are needed).
+	FacilityMode string `yaml:"facility_mode"`
+	// FacilityImageDir holds per-facility images in block mode.
+	// Empty means <work_dir>/.fc-facilities.
+	FacilityImageDir string `yaml:"facility_image_dir"`
+	// NetworkMode selects the guest's network:
+	//   "vsock" — egress only through the vsock-bridged egress proxy (default).
+	//   "tap"   — additionally attach a TAP device with per-VM nftables rules
+	//             generated from the directive's net capability, so tools that
+	//             are not proxy-aware work. Requires helper.socket_path.
+	NetworkMode string `yaml:"network_mode"`
+	// TapSubnet is the private IPv4 range carved into one /30 per running VM
+	// in tap mode. Default: 172.30.0.0/16.
+	TapSubnet string `yaml:"tap_subnet"`
+}
+
+// SnapshotConfig controls the Firecracker warm pool. A template VM is booted
+// once per kernel/rootfs pair and snapshotted; directives then restore from
+// the snapshot (with fresh command/workspace drives) instead of booting.
+// Requires a rootfs whose nexus-init supports snapshot mode.
+type SnapshotConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir holds templates and pooled VMs. Empty means <work_dir>/.fc-snapshots.
+	Dir string `yaml:"dir"`
+	// PoolSize is how many restored, paused VMs are kept ready. 0 restores
+	// on demand only.
+	PoolSize int `yaml:"pool_size"`
+	// MaxAge rebuilds the template (and recycles pooled VMs) after this long.
+	// 0 rebuilds only when the kernel, rootfs or firecracker binary changes.
+	MaxAge time.Duration `yaml:"max_age"`
+	// BootTimeout bounds the template VM's boot.
+	BootTimeout time.Duration `yaml:"boot_timeout"`
+}
+
+// JailerConfig controls launching Firecracker through its jailer: each VMM
+// runs chrooted under a per-directive uid/gid, in its own cgroup, with the
+// kernel, rootfs and per-directive images hard-linked into the chroot.
+// With helper.socket_path set, nexus-helper creates the chroot and runs the
+// jailer (its own binary, chroot base and parent cgroup), so nexusd need not
+// be root; the uid range must then lie within the helper's
+// -jailer-uid-range, and the kernel and rootfs images must be
+// world-readable. Otherwise nexusd must run as root.
+type JailerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// JailerPath is the path to the jailer binary. Default: "jailer" (PATH lookup).
+	JailerPath string `yaml:"jailer_path"`
+	// ChrootBaseDir is the jailer --chroot-base-dir. Hard links need it on the
+	// same filesystem as the kernel/rootfs images; otherwise they are copied.
+	ChrootBaseDir string `yaml:"chroot_base_dir"`
+	// UIDRangeStart and UIDRangeSize define the uid/gid pool; each running
+	// VMM gets its own uid (gid = uid). The range must not overlap real users.
+	UIDRangeStart int `yaml:"uid_range_start"`
+	UIDRangeSize  int `yaml:"uid_range_size"`
+	// ParentCgroup is the cgroup (relative to the v2 root) under which each
+	// VMM's cgroup is created.
+	ParentCgroup string `yaml:"parent_cgroup"`
+	// SeccompLevel is "default" (Firecracker's built-in filters) or "none"
+	// (--no-seccomp; debugging only).
+	SeccompLevel string `yaml:"seccomp_level"`
+	// SeccompFilter optionally replaces the built-in filters with a compiled
+	// filter file (--seccomp-filter). Requires seccomp_level "default".
+	SeccompFilter string `yaml:"seccomp_filter"`
 }
 
 // ContainerConfig holds container sandbox driver settings (Linux only).
@@ -153,9 +335,18 @@ type Config struct {
 	Poll               PollConfig               `yaml:"poll"`
 	Log                LogConfig                `yaml:"log"`
 	LogOverflow        LogOverflowConfig        `yaml:"log_overflow"`
+	LogRedaction       LogRedactionConfig       `yaml:"log_redaction"`
+	Artifacts          ArtifactsConfig          `yaml:"artifacts"`
+	Diff               DiffConfig               `yaml:"diff"`
+	DiskQuota          DiskQuotaConfig          `yaml:"disk_quota"`
+	EgressLimits       EgressLimitsConfig       `yaml:"egress_limits"`
+	EgressCache        EgressCacheConfig        `yaml:"egress_cache"`
+	Secrets            SecretsConfig            `yaml:"secrets"`
+	Helper             HelperConfig             `yaml:"helper"`
 	DebugTape          DebugTapeConfig          `yaml:"debug_tape"`
 	Heartbeat          HeartbeatConfig          `yaml:"heartbeat"`
 	TerritoryHeartbeat TerritoryHeartbeatConfig `yaml:"territory_heartbeat"`
+	Sessions           SessionsConfig           `yaml:"sessions"`
 	Observability      ObservabilityConfig      `yaml:"observability"`
 
 	// ShutdownTimeout is the maximum time to wait for in-flight directives
@@ -197,6 +388,45 @@ func Default() Config {
 			Dir:               ".nexus/overflow",
 			MaxBytesPerStream: 50 * 1024 * 1024, // 50 MiB
 		},
+		LogRedaction: LogRedactionConfig{
+			DefaultPatterns: true,
+			MaxMatchBytes:   512,
+		},
+		Artifacts: ArtifactsConfig{
+			MaxFileBytes:  50 * 1024 * 1024,  // 50 MiB
+			MaxTotalBytes: 200 * 1024 * 1024, // 200 MiB
+			MaxFiles:      1000,
+		},
+		Diff: DiffConfig{
+			ChunkBytes:     512 * 1024,       // 512 KiB
+			MaxUploadBytes: 64 * 1024 * 1024, // 64 MiB
+		},
+		DiskQuota: DiskQuotaConfig{
+			ProjectQuota:  true,
+			CheckInterval: 5 * time.Second,
+		},
+		EgressLimits: EgressLimitsConfig{
+			MaxConns:    256,
+			IdleTimeout: 10 * time.Minute,
+		},
+		EgressCache: EgressCacheConfig{
+			MaxBytes:      2 << 30,   // 2 GiB
+			MaxEntryBytes: 512 << 20, // 512 MiB
+			MaxAge:        24 * time.Hour,
+			Hosts: []string{
+				"registry.npmjs.org",
+				"registry.yarnpkg.com",
+				"pypi.org",
+				"files.pythonhosted.org",
+				"proxy.golang.org",
+				"index.crates.io",
+				"static.crates.io",
+				"rubygems.org",
+			},
+		},
+		Secrets: SecretsConfig{
+			TmpfsDir: "/dev/shm",
+		},
 		DebugTape: DebugTapeConfig{
 			Enabled:  false,
 			Path:     "./nexus-debug-tape.jsonl",
@@ -208,6 +438,10 @@ func Default() Config {
 		TerritoryHeartbeat: TerritoryHeartbeatConfig{
 			Interval: 30 * time.Second,
 		},
+		Sessions: SessionsConfig{
+			MaxOpen:        4,
+			MaxIdleTimeout: time.Hour,
+		},
 		Observability: ObservabilityConfig{
 			Enabled:    false,
 			ListenAddr: ":9090",
@@ -239,6 +473,22 @@ func Default() Config {
 			VCPUs:            2,
 			MemSizeMiB:       512,
 			WorkspaceSizeMiB: 2048,
+			FacilityMode:     "copy",
+			NetworkMode:      "vsock",
+			TapSubnet:        "172.30.0.0/16",
+			Jailer: JailerConfig{
+				JailerPath:    "jailer",
+				ChrootBaseDir: "/srv/jailer",
+				UIDRangeStart: 900000,
+				UIDRangeSize:  1024,
+				ParentCgroup:  "nexus-firecracker",
+				SeccompLevel:  "default",
+			},
+			Snapshot: SnapshotConfig{
+				PoolSize:    2,
+				MaxAge:      24 * time.Hour,
+				BootTimeout: 30 * time.Second,
+			},
 		},
 		UntrustedDriver:          "bwrap",
 		SupportedSandboxProfiles: []string{"host"},
@@ -296,6 +546,70 @@ func (c Config) Validate() error {
 		}
 	}
 
+	if c.LogRedaction.MaxMatchBytes < 16 || c.LogRedaction.MaxMatchBytes > 64*1024 {
+		return errors.New("log_redaction.max_match_bytes must be between 16 and 65536")
+	}
+	names := map[string]bool{}
+	for _, p := range c.LogRedaction.Patterns {
+		if p.Name == "" {
+			return errors.New("log_redaction.patterns[].name is required")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("log_redaction.patterns: duplicate name %q", p.Name)
+		}
+		names[p.Name] = true
+		if _, err := regexp.Compile(p.Regex); err != nil || p.Regex == "" {
+			return fmt.Errorf("log_redaction.patterns[%s].regex is invalid", p.Name)
+		}
+	}
+
+	if c.Artifacts.MaxFileBytes <= 0 {
+		return errors.New("artifacts.max_file_bytes must be >= 1")
+	}
+	if c.Artifacts.MaxTotalBytes <= 0 {
+		return errors.New("artifacts.max_total_bytes must be >= 1")
+	}
+	if c.Artifacts.MaxFiles <= 0 {
+		return errors.New("artifacts.max_files must be >= 1")
+	}
+	if c.Diff.ChunkBytes <= 0 {
+		return errors.New("diff.chunk_bytes must be >= 1")
+	}
+	if c.Diff.MaxUploadBytes <= 0 {
+		return errors.New("diff.max_upload_bytes must be >= 1")
+	}
+	if c.DiskQuota.CheckInterval < 100*time.Millisecond {
+		return errors.New("disk_quota.check_interval must be >= 100ms")
+	}
+	if c.EgressLimits.BytesPerSec < 0 || c.EgressLimits.MaxBytes < 0 || c.EgressLimits.MaxConns < 0 || c.EgressLimits.IdleTimeout < 0 {
+		return errors.New("egress_limits values must not be negative")
+	}
+	if c.EgressCache.Enabled {
+		if c.EgressCache.MaxBytes <= 0 {
+			return errors.New("egress_cache.max_bytes must be >= 1 when enabled")
+		}
+		if c.EgressCache.MaxEntryBytes < 0 || c.EgressCache.MaxAge < 0 {
+			return errors.New("egress_cache values must not be negative")
+		}
+		for _, h := range c.EgressCache.Hosts {
+			if h == "" || strings.ContainsAny(h, ":/*") {
+				return fmt.Errorf("egress_cache.hosts: %q is not a host name", h)
+			}
+		}
+	}
+	if c.Sessions.MaxOpen < 0 {
+		return errors.New("sessions.max_open must not be negative")
+	}
+	if c.Sessions.MaxOpen > 0 && c.Sessions.MaxIdleTimeout < time.Second {
+		return errors.New("sessions.max_idle_timeout must be >= 1s")
+	}
+	if !filepath.IsAbs(c.Secrets.TmpfsDir) {
+		return errors.New("secrets.tmpfs_dir must be an absolute path")
+	}
+	if c.Helper.SocketPath != "" && !filepath.IsAbs(c.Helper.SocketPath) {
+		return errors.New("helper.socket_path must be an absolute path")
+	}
+
 	if c.DebugTape.Enabled {
 		if c.DebugTape.Path == "" {
 			return errors.New("debug_tape.path is required when enabled")
@@ -331,6 +645,50 @@ func (c Config) Validate() error {
 		if c.Firecracker.WorkspaceSizeMiB > 32768 {
 			return errors.New("firecracker.workspace_size_mib must be <= 32768 (32 GiB)")
 		}
+		if err := c.Firecracker.Jailer.validate(); err != nil {
+			return err
+		}
+		if err := c.Firecracker.Snapshot.validate(); err != nil {
+			return err
+		}
+		switch c.Firecracker.FacilityMode {
+		case "", "copy", "block":
+		default:
+			return fmt.Errorf("firecracker.facility_mode must be \"copy\" or \"block\", got %q", c.Firecracker.FacilityMode)
+		}
+		if c.Firecracker.FacilityImageDir != "" && !filepath.IsAbs(c.Firecracker.FacilityImageDir) {
+			return errors.New("firecracker.facility_image_dir must be an absolute path")
+		}
+		if c.Firecracker.Jailer.Enabled && c.Helper.SocketPath != "" {
+			// Through the helper nexusd cannot chown a facility image to the
+			// VM's uid, and the helper only runs Firecracker's built-in
+			// seccomp filters.
+			if c.Firecracker.FacilityMode == "block" {
+				return errors.New("firecracker.facility_mode block is not supported when the jailer runs through helper.socket_path")
+			}
+			if c.Firecracker.Jailer.SeccompLevel != "default" || c.Firecracker.Jailer.SeccompFilter != "" {
+				return errors.New("firecracker.jailer seccomp_level none and seccomp_filter are not supported when the jailer runs through helper.socket_path")
+			}
+		}
+		if c.Firecracker.Snapshot.Enabled && c.Firecracker.Jailer.Enabled {
+			return errors.New("firecracker.snapshot is not supported together with firecracker.jailer")
+		}
+		switch c.Firecracker.NetworkMode {
+		case "", "vsock":
+		case "tap":
+			if c.Helper.SocketPath == "" {
+				return errors.New("firecracker.network_mode tap requires helper.socket_path")
+			}
+			if c.Firecracker.Snapshot.Enabled {
+				return errors.New("firecracker.snapshot is not supported together with firecracker.network_mode tap")
+			}
+			prefix, err := netip.ParsePrefix(c.Firecracker.TapSubnet)
+			if err != nil || !prefix.Addr().Is4() || !prefix.Addr().IsPrivate() || prefix.Bits() < 16 || prefix.Bits() > 28 {
+				return fmt.Errorf("firecracker.tap_subnet must be a private IPv4 range between /16 and /28, got %q", c.Firecracker.TapSubnet)
+			}
+		default:
+			return fmt.Errorf("firecracker.network_mode must be \"vsock\" or \"tap\", got %q", c.Firecracker.NetworkMode)
+		}
 	}
 
 	if c.Rootfs.Auto {
@@ -355,3 +713,59 @@ func (c Config) Validate() error {
 
 	return nil
 }
+
+func (j JailerConfig) validate() error {
+	if !j.Enabled {
+		return nil
+	}
+	if j.JailerPath == "" {
+		return errors.New("firecracker.jailer.jailer_path is required when the jailer is enabled")
+	}
+	if !filepath.IsAbs(j.ChrootBaseDir) {
+		return errors.New("firecracker.jailer.chroot_base_dir must be an absolute path")
+	}
+	if j.UIDRangeStart < 1000 {
+		return errors.New("firecracker.jailer.uid_range_start must be >= 1000")
+	}
+	if j.UIDRangeSize < 1 {
+		return errors.New("firecracker.jailer.uid_range_size must be >= 1")
+	}
+	if j.UIDRangeStart+j.UIDRangeSize > 1<<31 {
+		return errors.New("firecracker.jailer.uid_range_start + uid_range_size must fit in a uid")
+	}
+	if j.ParentCgroup == "" || filepath.IsAbs(j.ParentCgroup) || strings.Contains(j.ParentCgroup, "..") {
+		return errors.New("firecracker.jailer.parent_cgroup must be a relative cgroup path")
+	}
+	switch j.SeccompLevel {
+	case "default":
+	case "none":
+		if j.SeccompFilter != "" {
+			return errors.New("firecracker.jailer.seccomp_filter requires seccomp_level \"default\"")
+		}
+	default:
+		return fmt.Errorf("firecracker.jailer.seccomp_level must be \"default\" or \"none\", got %q", j.SeccompLevel)
+	}
+	if j.SeccompFilter != "" && !filepath.IsAbs(j.SeccompFilter) {
+		return errors.New("firecracker.jailer.seccomp_filter must be an absolute path")
+	}
+	return nil
+}
+
+func (sc SnapshotConfig) validate() error {
+	if !sc.Enabled {
+		return nil
+	}
+	if sc.Dir != "" && !filepath.IsAbs(sc.Dir) {
+		return errors.New("firecracker.snapshot.dir must be an absolute path")
+	}
+	if sc.PoolSize < 0 || sc.PoolSize > 64 {
+		return errors.New("firecracker.snapshot.pool_size must be between 0 and 64")
+	}
+	if sc.MaxAge < 0 {
+		return errors.New("firecracker.snapshot.max_age must be >= 0")
+	}
+	if sc.BootTimeout <= 0 {
+		return errors.New("firecracker.snapshot.boot_timeout must be > 0")
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 // baseValidConfig returns a Config that passes all validation.
@@ -284,6 +285,171 @@ func TestValidate_DebugTape_DisabledSkipsValidation(t *testing.T) {
 	}
 }
 
+func TestValidate_Artifacts_Limits(t *testing.T) {
+	t.Parallel()
+
+	mutators := map[string]func(*Config){
+		"max_file_bytes":  func(c *Config) { c.Artifacts.MaxFileBytes = 0 },
+		"max_total_bytes": func(c *Config) { c.Artifacts.MaxTotalBytes = 0 },
+		"max_files":       func(c *Config) { c.Artifacts.MaxFiles = 0 },
+	}
+	for name, mutate := range mutators {
+		cfg := baseValidConfig()
+		mutate(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for artifacts.%s=0", name)
+		}
+	}
+}
+
+func TestValidate_Diff_Limits(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	cfg.Diff.ChunkBytes = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for diff.chunk_bytes=0")
+	}
+
+	cfg = baseValidConfig()
+	cfg.Diff.MaxUploadBytes = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for diff.max_upload_bytes=0")
+	}
+}
+
+func TestValidate_DiskQuota_CheckInterval(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	cfg.DiskQuota.CheckInterval = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for disk_quota.check_interval=0")
+	}
+}
+
+func TestValidate_EgressLimits(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("default egress limits should be valid: %v", err)
+	}
+	cfg.EgressLimits.MaxConns = -1
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for egress_limits.max_conns=-1")
+	}
+}
+
+func TestValidate_EgressCache(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	cfg.EgressCache.Enabled = true
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("default egress cache should be valid: %v", err)
+	}
+
+	bad := cfg
+	bad.EgressCache.MaxBytes = 0
+	if err := bad.Validate(); err == nil {
+		t.Error("expected error for egress_cache.max_bytes=0")
+	}
+
+	bad = cfg
+	bad.EgressCache.Hosts = []string{"*.npmjs.org"}
+	if err := bad.Validate(); err == nil {
+		t.Error("expected error for wildcard egress_cache.hosts entry")
+	}
+
+	off := cfg
+	off.EgressCache.Enabled = false
+	off.EgressCache.MaxBytes = 0
+	if err := off.Validate(); err != nil {
+		t.Errorf("disabled egress cache should not be validated: %v", err)
+	}
+}
+
+func TestValidate_Sessions(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("default sessions config should be valid: %v", err)
+	}
+
+	bad := cfg
+	bad.Sessions.MaxOpen = -1
+	if err := bad.Validate(); err == nil {
+		t.Error("expected error for sessions.max_open=-1")
+	}
+
+	bad = cfg
+	bad.Sessions.MaxIdleTimeout = 0
+	if err := bad.Validate(); err == nil {
+		t.Error("expected error for sessions.max_idle_timeout=0")
+	}
+
+	off := cfg
+	off.Sessions.MaxOpen = 0
+	off.Sessions.MaxIdleTimeout = 0
+	if err := off.Validate(); err != nil {
+		t.Errorf("disabled sessions should not be validated: %v", err)
+	}
+}
+
+func TestValidate_LogRedaction(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	cfg.LogRedaction.Patterns = []RedactionPattern{{Name: "internal", Regex: `tok_[a-z0-9]{20}`}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := map[string]func(*Config){
+		"bad regex":    func(c *Config) { c.LogRedaction.Patterns = []RedactionPattern{{Name: "x", Regex: "("}} },
+		"empty regex":  func(c *Config) { c.LogRedaction.Patterns = []RedactionPattern{{Name: "x"}} },
+		"missing name": func(c *Config) { c.LogRedaction.Patterns = []RedactionPattern{{Regex: "x"}} },
+		"duplicate name": func(c *Config) {
+			c.LogRedaction.Patterns = []RedactionPattern{{Name: "x", Regex: "a"}, {Name: "x", Regex: "b"}}
+		},
+		"tiny max match": func(c *Config) { c.LogRedaction.MaxMatchBytes = 1 },
+		"huge max match": func(c *Config) { c.LogRedaction.MaxMatchBytes = 1 << 20 },
+	}
+	for name, mutate := range cases {
+		cfg := baseValidConfig()
+		mutate(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestValidate_Secrets_TmpfsDir(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	cfg.Secrets.TmpfsDir = "shm"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for relative secrets.tmpfs_dir")
+	}
+}
+
+func TestValidate_Helper_SocketPath(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	cfg.Helper.SocketPath = "/run/cybros-nexus/helper.sock"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg.Helper.SocketPath = "helper.sock"
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for relative helper.socket_path")
+	}
+}
+
 func TestValidate_RootfsAuto_EmptyCacheDir(t *testing.T) {
 	t.Parallel()
 
@@ -465,3 +631,197 @@ work_dir: "/tmp/facilities"
 		t.Errorf("expected empty territory_id for unset var, got %q", cfg.TerritoryID)
 	}
 }
+
+func TestValidate_FirecrackerJailer(t *testing.T) {
+	t.Parallel()
+
+	valid := func() Config {
+		cfg := baseValidConfig()
+		cfg.UntrustedDriver = "firecracker"
+		cfg.Firecracker.KernelPath = "/vmlinux"
+		cfg.Firecracker.RootfsImagePath = "/rootfs.ext4"
+		cfg.Firecracker.Jailer.Enabled = true
+		return cfg
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("default jailer config should validate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*JailerConfig)
+		want   string
+	}{
+		{"relative chroot", func(j *JailerConfig) { j.ChrootBaseDir = "jail" }, "chroot_base_dir"},
+		{"system uid range", func(j *JailerConfig) { j.UIDRangeStart = 0 }, "uid_range_start"},
+		{"empty uid range", func(j *JailerConfig) { j.UIDRangeSize = 0 }, "uid_range_size"},
+		{"absolute parent cgroup", func(j *JailerConfig) { j.ParentCgroup = "/sys/fs/cgroup/x" }, "parent_cgroup"},
+		{"unknown seccomp level", func(j *JailerConfig) { j.SeccompLevel = "2" }, "seccomp_level"},
+		{"filter without seccomp", func(j *JailerConfig) { j.SeccompLevel = "none"; j.SeccompFilter = "/etc/fc.bpf" }, "seccomp_filter"},
+		{"relative filter", func(j *JailerConfig) { j.SeccompFilter = "fc.bpf" }, "seccomp_filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := valid()
+			tt.mutate(&cfg.Firecracker.Jailer)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error mentioning %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	// Disabled jailer settings are not validated.
+	cfg := valid()
+	cfg.Firecracker.Jailer.Enabled = false
+	cfg.Firecracker.Jailer.SeccompLevel = "bogus"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("disabled jailer should not be validated: %v", err)
+	}
+}
+
+func TestValidate_FirecrackerJailerThroughHelper(t *testing.T) {
+	t.Parallel()
+
+	valid := func() Config {
+		cfg := baseValidConfig()
+		cfg.UntrustedDriver = "firecracker"
+		cfg.Firecracker.KernelPath = "/vmlinux"
+		cfg.Firecracker.RootfsImagePath = "/rootfs.ext4"
+		cfg.Firecracker.Jailer.Enabled = true
+		cfg.Helper.SocketPath = "/run/cybros-nexus/helper.sock"
+		return cfg
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("jailer through the helper should validate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*Config)
+		want   string
+	}{
+		{"block facilities", func(c *Config) { c.Firecracker.FacilityMode = "block" }, "facility_mode"},
+		{"no seccomp", func(c *Config) { c.Firecracker.Jailer.SeccompLevel = "none" }, "seccomp"},
+		{"seccomp filter", func(c *Config) { c.Firecracker.Jailer.SeccompFilter = "/etc/fc.bpf" }, "seccomp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := valid()
+			tt.mutate(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error mentioning %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate_FirecrackerSnapshot(t *testing.T) {
+	t.Parallel()
+
+	valid := func() Config {
+		cfg := baseValidConfig()
+		cfg.UntrustedDriver = "firecracker"
+		cfg.Firecracker.KernelPath = "/vmlinux"
+		cfg.Firecracker.RootfsImagePath = "/rootfs.ext4"
+		cfg.Firecracker.Snapshot.Enabled = true
+		return cfg
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("default snapshot config should validate: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(*Config)
+		want   string
+	}{
+		{"relative dir", func(c *Config) { c.Firecracker.Snapshot.Dir = "snaps" }, "snapshot.dir"},
+		{"negative pool", func(c *Config) { c.Firecracker.Snapshot.PoolSize = -1 }, "pool_size"},
+		{"huge pool", func(c *Config) { c.Firecracker.Snapshot.PoolSize = 65 }, "pool_size"},
+		{"negative max age", func(c *Config) { c.Firecracker.Snapshot.MaxAge = -time.Second }, "max_age"},
+		{"no boot timeout", func(c *Config) { c.Firecracker.Snapshot.BootTimeout = 0 }, "boot_timeout"},
+		{"with jailer", func(c *Config) { c.Firecracker.Jailer.Enabled = true }, "jailer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := valid()
+			tt.mutate(&cfg)
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error mentioning %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate_FirecrackerFacilityMode(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	cfg.UntrustedDriver = "firecracker"
+	cfg.Firecracker.KernelPath = "/vmlinux"
+	cfg.Firecracker.RootfsImagePath = "/rootfs.ext4"
+
+	for _, mode := range []string{"", "copy", "block"} {
+		cfg.Firecracker.FacilityMode = mode
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("facility_mode %q: unexpected error: %v", mode, err)
+		}
+	}
+
+	cfg.Firecracker.FacilityMode = "qcow2"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "facility_mode") {
+		t.Errorf("expected facility_mode error, got %v", err)
+	}
+
+	cfg.Firecracker.FacilityMode = "block"
+	cfg.Firecracker.FacilityImageDir = "images"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "facility_image_dir") {
+		t.Errorf("expected facility_image_dir error, got %v", err)
+	}
+}
+
+func TestValidate_FirecrackerNetworkMode(t *testing.T) {
+	t.Parallel()
+
+	cfg := baseValidConfig()
+	cfg.UntrustedDriver = "firecracker"
+	cfg.Firecracker.KernelPath = "/vmlinux"
+	cfg.Firecracker.RootfsImagePath = "/rootfs.ext4"
+	if cfg.Firecracker.NetworkMode != "vsock" || cfg.Firecracker.TapSubnet != "172.30.0.0/16" {
+		t.Fatalf("unexpected defaults: %q %q", cfg.Firecracker.NetworkMode, cfg.Firecracker.TapSubnet)
+	}
+
+	cfg.Firecracker.NetworkMode = "tap"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "helper.socket_path") {
+		t.Errorf("expected helper error, got %v", err)
+	}
+	cfg.Helper.SocketPath = "/run/cybros-nexus/helper.sock"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("tap mode: unexpected error: %v", err)
+	}
+
+	for _, subnet := range []string{"8.8.0.0/16", "172.30.0.0/30", "fd00::/64", "nope"} {
+		bad := cfg
+		bad.Firecracker.TapSubnet = subnet
+		if err := bad.Validate(); err == nil || !strings.Contains(err.Error(), "tap_subnet") {
+			t.Errorf("tap_subnet %q: expected error, got %v", subnet, err)
+		}
+	}
+
+	snap := cfg
+	snap.Firecracker.Snapshot.Enabled = true
+	if err := snap.Validate(); err == nil || !strings.Contains(err.Error(), "network_mode tap") {
+		t.Errorf("expected snapshot/tap error, got %v", err)
+	}
+
+	cfg.Firecracker.NetworkMode = "bridge"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "network_mode") {
+		t.Errorf("expected network_mode error, got %v", err)
+	}
+}
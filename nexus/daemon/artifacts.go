@@ -0,0 +1,276 @@
+package daemon
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/protocol"
+)
+
+// artifactUploadTimeout bounds a single artifact upload (files can be up to
+// artifacts.max_file_bytes, so the default request timeout is too short).
+const artifactUploadTimeout = 5 * time.Minute
+
+// collectedArtifact is a matched facility file plus its on-host location.
+type collectedArtifact struct {
+	protocol.ArtifactFile
+	absPath string
+}
+
+// artifactSkip records a matched file that was not collected.
+type artifactSkip struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"` // too_large/total_limit/max_files/not_regular/unreadable
+}
+
+// artifactCollection is the result of matching ArtifactsSpec.Collect.
+type artifactCollection struct {
+	Files      []collectedArtifact
+	Skipped    []artifactSkip
+	TotalBytes int64
+	Truncated  bool // a total/count limit was hit
+}
+
+// validateArtifactPattern rejects patterns that could address files outside
+// the facility: absolute paths, "..", and backslashes.
+func validateArtifactPattern(p string) error {
+	if p == "" || p == "." {
+		return errors.New("empty pattern")
+	}
+	if strings.HasPrefix(p, "/") || filepath.IsAbs(p) {
+		return fmt.Errorf("pattern %q must be facility-relative", p)
+	}
+	if strings.Contains(p, `\`) {
+		return fmt.Errorf("pattern %q must use forward slashes", p)
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return fmt.Errorf("pattern %q escapes the facility", p)
+		}
+		if _, err := path.Match(seg, ""); err != nil && seg != "**" {
+			return fmt.Errorf("pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// matchArtifactPattern matches a slash-separated relative path against a
+// glob pattern. Segments use path.Match syntax; a "**" segment matches zero
+// or more whole path segments.
+func matchArtifactPattern(pattern, rel string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			rest := pat[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// collectArtifacts walks the facility and hashes every regular file matching
+// one of the patterns, enforcing per-file, total and count limits. Symlinks
+// are never followed (neither while walking nor when opening), so a sandbox
+// cannot point a matched name at a host file.
+func collectArtifacts(facilityPath string, patterns []string, limits config.ArtifactsConfig) (artifactCollection, error) {
+	var res artifactCollection
+	if len(patterns) == 0 {
+		return res, nil
+	}
+	cleaned := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		p = strings.TrimPrefix(path.Clean(strings.TrimSpace(p)), "./")
+		if err := validateArtifactPattern(p); err != nil {
+			return res, err
+		}
+		cleaned = append(cleaned, p)
+	}
+
+	var matched []string
+	err := filepath.WalkDir(facilityPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable subtree: skip it rather than failing the whole collection.
+			if d != nil && d.IsDir() && p != facilityPath {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" && p != facilityPath {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		rel, relErr := filepath.Rel(facilityPath, p)
+		if relErr != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		for _, pat := range cleaned {
+			if matchArtifactPattern(pat, rel) {
+				matched = append(matched, rel)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	sort.Strings(matched)
+
+	for _, rel := range matched {
+		if len(res.Files) >= limits.MaxFiles {
+			res.Truncated = true
+			res.Skipped = append(res.Skipped, artifactSkip{Path: rel, Reason: "max_files"})
+			continue
+		}
+		abs := filepath.Join(facilityPath, filepath.FromSlash(rel))
+		file, reason := hashArtifact(abs, rel, limits.MaxFileBytes)
+		if reason != "" {
+			res.Skipped = append(res.Skipped, artifactSkip{Path: rel, Reason: reason})
+			continue
+		}
+		if res.TotalBytes+file.Size > limits.MaxTotalBytes {
+			res.Truncated = true
+			res.Skipped = append(res.Skipped, artifactSkip{Path: rel, Reason: "total_limit"})
+			continue
+		}
+		res.TotalBytes += file.Size
+		res.Files = append(res.Files, collectedArtifact{ArtifactFile: file, absPath: abs})
+	}
+	return res, nil
+}
+
+// hashArtifact opens a file without following symlinks and returns its
+// metadata and SHA-256. A non-empty reason means the file was skipped.
+func hashArtifact(abs, rel string, maxBytes int64) (protocol.ArtifactFile, string) {
+	f, err := openNoFollow(abs)
+	if err != nil {
+		return protocol.ArtifactFile{}, "unreadable"
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return protocol.ArtifactFile{}, "unreadable"
+	}
+	if !fi.Mode().IsRegular() {
+		return protocol.ArtifactFile{}, "not_regular"
+	}
+	if fi.Size() > maxBytes {
+		return protocol.ArtifactFile{}, "too_large"
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(h, io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		return protocol.ArtifactFile{}, "unreadable"
+	}
+	if n > maxBytes {
+		// Grew after stat.
+		return protocol.ArtifactFile{}, "too_large"
+	}
+	return protocol.ArtifactFile{
+		Path:   rel,
+		Size:   n,
+		Mode:   fmt.Sprintf("%04o", fi.Mode().Perm()),
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, ""
+}
+
+func openNoFollow(p string) (*os.File, error) {
+	return os.OpenFile(p, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+}
+
+// uploadArtifacts uploads each collected file and records the outcome on it.
+// Uploads are best-effort: a failure is reported in the manifest but does not
+// change the directive status. The declared sha256 lets the server reject a
+// file that changed between hashing and upload.
+func (s *Service) uploadArtifacts(ctx context.Context, directiveID string, token *tokenHolder, files []collectedArtifact) {
+	for i := range files {
+		if ctx.Err() != nil {
+			files[i].Error = ctx.Err().Error()
+			continue
+		}
+		if err := s.uploadArtifact(ctx, directiveID, token, files[i]); err != nil {
+			slog.Warn("artifact upload failed", "directive_id", directiveID, "path", files[i].Path, "error", err)
+			files[i].Error = err.Error()
+			continue
+		}
+		files[i].Uploaded = true
+	}
+}
+
+func (s *Service) uploadArtifact(ctx context.Context, directiveID string, token *tokenHolder, file collectedArtifact) error {
+	f, err := openNoFollow(file.absPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reqCtx, cancel := context.WithTimeout(ctx, artifactUploadTimeout)
+	defer cancel()
+	return s.cli.UploadArtifact(reqCtx, directiveID, token.Get(), file.ArtifactFile, io.LimitReader(f, file.Size))
+}
+
+// buildArtifactsManifest renders the collection for artifacts_manifest.collected.
+func buildArtifactsManifest(c artifactCollection) map[string]any {
+	files := make([]protocol.ArtifactFile, 0, len(c.Files))
+	for _, f := range c.Files {
+		files = append(files, f.ArtifactFile)
+	}
+	m := map[string]any{
+		"files":       files,
+		"total_bytes": c.TotalBytes,
+		"truncated":   c.Truncated,
+	}
+	if len(c.Skipped) > 0 {
+		m["skipped"] = c.Skipped
+	}
+	return m
+}
+
+// collectAndUploadArtifacts handles spec.Artifacts.Collect after the command
+// finishes. It returns nil when nothing was requested.
+func (s *Service) collectAndUploadArtifacts(ctx context.Context, directiveID, facilityPath string, spec protocol.DirectiveSpec, token *tokenHolder) map[string]any {
+	if len(spec.Artifacts.Collect) == 0 {
+		return nil
+	}
+	c, err := collectArtifacts(facilityPath, spec.Artifacts.Collect, s.cfg.Artifacts)
+	if err != nil {
+		slog.Warn("artifact collection failed", "directive_id", directiveID, "error", err)
+		return map[string]any{"error": err.Error()}
+	}
+	s.uploadArtifacts(ctx, directiveID, token, c.Files)
+	return buildArtifactsManifest(c)
+}
@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cybros.ai/nexus/config"
+)
+
+func testArtifactsConfig() config.ArtifactsConfig {
+	return config.Default().Artifacts
+}
+
+func writeFile(t *testing.T, root, rel, content string) {
+	t.Helper()
+	p := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatchArtifactPattern(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		pattern, rel string
+		want         bool
+	}{
+		{"report.txt", "report.txt", true},
+		{"*.txt", "report.txt", true},
+		{"*.txt", "out/report.txt", false},
+		{"out/*.log", "out/a.log", true},
+		{"out/*.log", "out/sub/a.log", false},
+		{"**/*.log", "a.log", true},
+		{"**/*.log", "out/sub/a.log", true},
+		{"out/**", "out/sub/a.log", true},
+		{"out/**", "other/a.log", false},
+		{"out/**/a.log", "out/a.log", true},
+		{"out/**/a.log", "out/x/y/a.log", true},
+		{"coverage/*/index.html", "coverage/lib/index.html", true},
+	}
+	for _, tt := range tests {
+		if got := matchArtifactPattern(tt.pattern, tt.rel); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestCollectArtifacts_RejectsEscapingPatterns(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	for _, p := range []string{"/etc/passwd", "../secret", "out/../../x", `out\x`, "[", ""} {
+		if _, err := collectArtifacts(root, []string{p}, testArtifactsConfig()); err == nil {
+			t.Errorf("pattern %q should be rejected", p)
+		}
+	}
+}
+
+func TestCollectArtifacts_HashesMatchingFiles(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFile(t, root, "out/report.txt", "hello")
+	writeFile(t, root, "out/sub/data.json", "{}")
+	writeFile(t, root, "src/main.go", "package main")
+	writeFile(t, root, ".git/config", "x")
+
+	c, err := collectArtifacts(root, []string{"out/**", "./**/config"}, testArtifactsConfig())
+	if err != nil {
+		t.Fatalf("collectArtifacts: %v", err)
+	}
+	if len(c.Files) != 2 {
+		t.Fatalf("expected 2 files, got %+v", c.Files)
+	}
+	if c.Files[0].Path != "out/report.txt" || c.Files[1].Path != "out/sub/data.json" {
+		t.Fatalf("unexpected files: %+v", c.Files)
+	}
+	sum := sha256.Sum256([]byte("hello"))
+	if c.Files[0].SHA256 != hex.EncodeToString(sum[:]) || c.Files[0].Size != 5 || c.Files[0].Mode != "0644" {
+		t.Fatalf("unexpected metadata: %+v", c.Files[0])
+	}
+	if c.TotalBytes != 7 || c.Truncated {
+		t.Fatalf("unexpected totals: bytes=%d truncated=%v", c.TotalBytes, c.Truncated)
+	}
+}
+
+func TestCollectArtifacts_SkipsSymlinks(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "leak.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Dir(outside), filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := collectArtifacts(root, []string{"**"}, testArtifactsConfig())
+	if err != nil {
+		t.Fatalf("collectArtifacts: %v", err)
+	}
+	if len(c.Files) != 0 {
+		t.Fatalf("symlinks must not be collected, got %+v", c.Files)
+	}
+	for _, s := range c.Skipped {
+		if s.Reason != "unreadable" && s.Reason != "not_regular" {
+			t.Errorf("unexpected skip reason for %s: %s", s.Path, s.Reason)
+		}
+	}
+}
+
+func TestCollectArtifacts_Limits(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFile(t, root, "a.bin", "aaaa")
+	writeFile(t, root, "b.bin", "bbbbbbbbbb")
+	writeFile(t, root, "c.bin", "cccc")
+	writeFile(t, root, "d.bin", "dd")
+
+	limits := config.ArtifactsConfig{MaxFileBytes: 8, MaxTotalBytes: 9, MaxFiles: 2}
+	c, err := collectArtifacts(root, []string{"*.bin"}, limits)
+	if err != nil {
+		t.Fatalf("collectArtifacts: %v", err)
+	}
+	if len(c.Files) != 2 || c.Files[0].Path != "a.bin" || c.Files[1].Path != "c.bin" {
+		t.Fatalf("unexpected files: %+v", c.Files)
+	}
+	want := map[string]string{"b.bin": "too_large", "d.bin": "max_files"}
+	if len(c.Skipped) != len(want) {
+		t.Fatalf("unexpected skipped: %+v", c.Skipped)
+	}
+	for _, s := range c.Skipped {
+		if want[s.Path] != s.Reason {
+			t.Errorf("skip %s: got %s, want %s", s.Path, s.Reason, want[s.Path])
+		}
+	}
+	if !c.Truncated {
+		t.Error("expected truncated")
+	}
+
+	limits = config.ArtifactsConfig{MaxFileBytes: 8, MaxTotalBytes: 6, MaxFiles: 10}
+	c, err = collectArtifacts(root, []string{"a.bin", "c.bin"}, limits)
+	if err != nil {
+		t.Fatalf("collectArtifacts: %v", err)
+	}
+	if len(c.Files) != 1 || len(c.Skipped) != 1 || c.Skipped[0].Reason != "total_limit" || !c.Truncated {
+		t.Fatalf("expected total_limit skip, got files=%+v skipped=%+v", c.Files, c.Skipped)
+	}
+}
@@ -0,0 +1,386 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cybros.ai/nexus/client"
+	"cybros.ai/nexus/protocol"
+)
+
+const (
+	// auditBatchSize is the number of events sent per audit_events request.
+	auditBatchSize = 200
+	// auditFlushInterval bounds how long an event waits for a batch to fill.
+	auditFlushInterval = 2 * time.Second
+	// auditMaxBuffered caps events held in memory while uploads are stalled;
+	// beyond it events are dropped and counted in EgressSummary.EventsDropped.
+	auditMaxBuffered = 10000
+	// auditMaxSummaryHosts caps the per-host breakdown in EgressSummary.
+	auditMaxSummaryHosts = 256
+	// auditSpoolMaxBytes caps the on-disk spool across all directives.
+	auditSpoolMaxBytes = 64 * 1024 * 1024
+)
+
+// auditUploader receives the egress proxy's JSONL audit stream (it is the
+// sandbox.RunRequest.Audit writer) and uploads the events to Mothership in
+// batches via the audit_events endpoint, separate from the directive's log
+// streams. Batches that cannot be delivered go to the audit spool and are
+// re-sent on the next startup. It also keeps the per-host egress summary
+// reported in FinishedRequest.
+type auditUploader struct {
+	cli         *client.Client
+	directiveID string
+	token       *tokenHolder
+	spool       *auditSpool // nil: undeliverable batches are dropped
+
+	mu      sync.Mutex
+	partial []byte // trailing bytes of an incomplete line
+	pending []protocol.EgressAuditEvent
+	summary protocol.EgressSummary
+	seq     int
+	// offline is set after a batch fails to upload: later batches get a
+	// single attempt instead of the full retry schedule, so a down server
+	// does not stall the directive's audit stream.
+	offline bool
+	shut    bool // Close was called; later writes are ignored
+
+	kick   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	closed sync.Once
+}
+
+func (s *Service) newAuditUploader(ctx context.Context, directiveID string, token *tokenHolder) *auditUploader {
+	u := &auditUploader{
+		cli:         s.cli,
+		directiveID: directiveID,
+		token:       token,
+		spool:       s.auditSpool,
+		summary:     protocol.EgressSummary{Hosts: map[string]protocol.EgressHostCounts{}},
+		kick:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go u.run(ctx)
+	return u
+}
+
+// Write implements io.Writer over JSONL audit events. Lines that do not
+// decode are skipped. It never blocks on the network.
+func (u *auditUploader) Write(p []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.shut {
+		return len(p), nil
+	}
+
+	u.partial = append(u.partial, p...)
+	for {
+		i := bytes.IndexByte(u.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := u.partial[:i]
+		u.partial = u.partial[i+1:]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e protocol.EgressAuditEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			slog.Warn("audit: skipping malformed event", "directive_id", u.directiveID, "error", err)
+			continue
+		}
+		u.record(e)
+	}
+	if len(u.partial) == 0 {
+		u.partial = nil
+	}
+
+	if len(u.pending) >= auditBatchSize {
+		select {
+		case u.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// record counts e in the summary and queues it. Caller holds u.mu.
+func (u *auditUploader) record(e protocol.EgressAuditEvent) {
+	allowed := e.Decision == "allow"
+	if allowed {
+		u.summary.Allowed++
+	} else {
+		u.summary.Denied++
+	}
+	if c, ok := u.summary.Hosts[e.DestHost]; ok || len(u.summary.Hosts) < auditMaxSummaryHosts {
+		if allowed {
+			c.Allowed++
+		} else {
+			c.Denied++
+		}
+		u.summary.Hosts[e.DestHost] = c
+	} else {
+		u.summary.HostsTruncated = true
+	}
+
+	if len(u.pending) >= auditMaxBuffered {
+		u.summary.EventsDropped++
+		return
+	}
+	u.pending = append(u.pending, e)
+}
+
+func (u *auditUploader) run(ctx context.Context) {
+	defer close(u.done)
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.stop:
+			u.flush(ctx)
+			return
+		case <-ticker.C:
+			u.flush(ctx)
+		case <-u.kick:
+			u.flush(ctx)
+		}
+	}
+}
+
+// flush sends everything queued, one batch per request.
+func (u *auditUploader) flush(ctx context.Context) {
+	for {
+		u.mu.Lock()
+		n := min(len(u.pending), auditBatchSize)
+		if n == 0 {
+			u.mu.Unlock()
+			return
+		}
+		req := protocol.AuditEventsRequest{Seq: u.seq, Events: u.pending[:n:n]}
+		u.pending = u.pending[n:]
+		u.seq++
+		offline := u.offline
+		u.mu.Unlock()
+
+		err := u.send(ctx, req, offline)
+		u.mu.Lock()
+		u.offline = err != nil
+		u.mu.Unlock()
+		if err != nil {
+			u.spoolBatch(req, err)
+		}
+	}
+}
+
+func (u *auditUploader) send(ctx context.Context, req protocol.AuditEventsRequest, offline bool) error {
+	post := func() error {
+		reqCtx, cancel := client.WithTimeout(ctx)
+		defer cancel()
+		return u.cli.AuditEvents(reqCtx, u.directiveID, u.token.Get(), req)
+	}
+	if offline {
+		return post()
+	}
+	return postWithRetry(ctx, "audit_events", post)
+}
+
+// rejectedByServer reports whether err is a response that re-sending the same
+// request cannot change (a non-retryable HTTP status).
+func rejectedByServer(err error) bool {
+	var httpErr client.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	_, retryable := retryDelay(err)
+	return !retryable
+}
+
+func (u *auditUploader) spoolBatch(req protocol.AuditEventsRequest, postErr error) {
+	if u.spool != nil && !rejectedByServer(postErr) {
+		err := u.spool.Append(auditSpoolEntry{
+			Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+			DirectiveID: u.directiveID,
+			Token:       u.token.Get(),
+			Request:     req,
+		})
+		if err == nil {
+			return
+		}
+		slog.Error("audit spool append failed", "directive_id", u.directiveID, "error", err)
+	}
+	slog.Error("audit events dropped", "directive_id", u.directiveID, "seq", req.Seq, "count", len(req.Events), "error", postErr)
+	u.mu.Lock()
+	u.summary.EventsDropped += int64(len(req.Events))
+	u.mu.Unlock()
+}
+
+// Close uploads (or spools) the remaining events and returns the egress
+// summary, or nil if the directive produced no audit events. The proxy must
+// be stopped before Close; later writes are not uploaded.
+func (u *auditUploader) Close() *protocol.EgressSummary {
+	u.mu.Lock()
+	u.shut = true
+	u.mu.Unlock()
+	u.closed.Do(func() { close(u.stop) })
+	<-u.done
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.summary.Allowed+u.summary.Denied == 0 {
+		return nil
+	}
+	summary := u.summary
+	return &summary
+}
+
+// auditSpoolEntry is one JSONL line in the audit spool.
+type auditSpoolEntry struct {
+	Timestamp   string `json:"ts"`
+	DirectiveID string `json:"directive_id"`
+	// Token is the directive token, stored for replay (see walEntry.Token).
+	Token   string                      `json:"token"`
+	Request protocol.AuditEventsRequest `json:"request"`
+}
+
+// auditSpool persists audit_events batches that could not be uploaded, so
+// they can be re-sent on the next startup.
+type auditSpool struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newAuditSpool(workDir string) (*auditSpool, error) {
+	dir := filepath.Join(workDir, ".nexus")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create audit spool directory: %w", err)
+	}
+	return &auditSpool{path: filepath.Join(dir, "audit.spool")}, nil
+}
+
+// Append writes one batch to the spool. It fails once the spool has reached
+// auditSpoolMaxBytes.
+func (s *auditSpool) Append(entry auditSpoolEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size()+int64(len(b)) > auditSpoolMaxBytes {
+		return fmt.Errorf("audit spool full (%d bytes)", fi.Size())
+	}
+	_, err = f.Write(b)
+	return err
+}
+
+// Replay reads all spooled batches. Corrupt lines are skipped with a warning.
+func (s *auditSpool) Replay() ([]auditSpoolEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []auditSpoolEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, walMaxScanSize), walMaxScanSize)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e auditSpoolEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			slog.Warn("audit spool: skipping corrupt entry", "line", lineNum, "error", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Truncate removes all batches from the spool.
+func (s *auditSpool) Truncate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Truncate(s.path, 0)
+	if err != nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// replayAuditSpool re-sends audit_events batches spooled by a previous run.
+// Batches the server rejects outright (e.g. the directive token expired) are
+// dropped; the spool is kept only if some batch failed transiently.
+func (s *Service) replayAuditSpool(ctx context.Context) {
+	if s.auditSpool == nil {
+		return
+	}
+	entries, err := s.auditSpool.Replay()
+	if err != nil {
+		slog.Error("audit spool read failed", "error", err)
+		return
+	}
+	if len(entries) == 0 {
+		return
+	}
+
+	slog.Info("replaying audit spool", "batches", len(entries))
+	keep := false
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if postErr := postWithRetry(ctx, "audit-spool-replay", func() error {
+			reqCtx, cancel := client.WithTimeout(ctx)
+			defer cancel()
+			return s.cli.AuditEvents(reqCtx, e.DirectiveID, e.Token, e.Request)
+		}); postErr != nil {
+			if !rejectedByServer(postErr) {
+				keep = true
+			}
+			slog.Error("audit spool replay failed", "directive_id", e.DirectiveID, "seq", e.Request.Seq, "error", postErr)
+		}
+	}
+	if keep {
+		return
+	}
+	if err := s.auditSpool.Truncate(); err != nil {
+		slog.Error("audit spool truncate failed", "error", err)
+	} else {
+		slog.Info("audit spool replay complete, truncated")
+	}
+}
@@ -0,0 +1,255 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cybros.ai/nexus/client"
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/egressproxy"
+	"cybros.ai/nexus/protocol"
+)
+
+// fakeAuditServer records audit_events batches, answering status.
+type fakeAuditServer struct {
+	mu      sync.Mutex
+	status  int
+	batches []protocol.AuditEventsRequest
+	tokens  []string
+}
+
+func (f *fakeAuditServer) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/audit_events") {
+			http.NotFound(w, r)
+			return
+		}
+		var req protocol.AuditEventsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.status != 0 {
+			w.WriteHeader(f.status)
+			return
+		}
+		f.batches = append(f.batches, req)
+		f.tokens = append(f.tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	})
+}
+
+func newAuditTestService(t *testing.T, srv *httptest.Server) *Service {
+	t.Helper()
+	cfg := config.Default()
+	cfg.ServerURL = srv.URL
+	cli, err := client.New(cfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	spool, err := newAuditSpool(t.TempDir())
+	if err != nil {
+		t.Fatalf("newAuditSpool: %v", err)
+	}
+	return &Service{cfg: cfg, cli: cli, auditSpool: spool}
+}
+
+func TestAuditUploader_BatchesAndSummary(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeAuditServer{}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newAuditTestService(t, srv)
+
+	u := s.newAuditUploader(context.Background(), "d-1", newTokenHolder("tok"))
+	logger := egressproxy.NewAuditLogger(u, "d-1")
+	for i := range auditBatchSize + 50 {
+		if i%10 == 0 {
+			logger.Log(egressproxy.AuditEvent{DestHost: "evil.com", DestPort: 443, Decision: "deny", ReasonCode: "NOT_IN_ALLOWLIST"})
+			continue
+		}
+		logger.Log(egressproxy.AuditEvent{DestHost: "github.com", DestPort: 443, Decision: "allow", ReasonCode: "OK"})
+	}
+	summary := u.Close()
+
+	total := 0
+	for i, b := range fake.batches {
+		if b.Seq != i {
+			t.Errorf("batch %d has seq %d", i, b.Seq)
+		}
+		if len(b.Events) > auditBatchSize {
+			t.Errorf("batch %d has %d events", i, len(b.Events))
+		}
+		total += len(b.Events)
+	}
+	if total != auditBatchSize+50 {
+		t.Errorf("server got %d events, want %d", total, auditBatchSize+50)
+	}
+	if e := fake.batches[0].Events[0]; e.DirectiveID != "d-1" || e.Timestamp == "" || e.DestHost != "evil.com" {
+		t.Errorf("first event = %+v", e)
+	}
+
+	if summary == nil {
+		t.Fatal("expected egress summary")
+	}
+	if summary.Allowed != 225 || summary.Denied != 25 {
+		t.Errorf("totals = %d allowed, %d denied", summary.Allowed, summary.Denied)
+	}
+	want := map[string]protocol.EgressHostCounts{"github.com": {Allowed: 225}, "evil.com": {Denied: 25}}
+	if len(summary.Hosts) != len(want) || summary.Hosts["github.com"] != want["github.com"] || summary.Hosts["evil.com"] != want["evil.com"] {
+		t.Errorf("hosts = %+v", summary.Hosts)
+	}
+	if summary.HostsTruncated || summary.EventsDropped != 0 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestAuditUploader_NoEvents(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeAuditServer{}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newAuditTestService(t, srv)
+
+	u := s.newAuditUploader(context.Background(), "d-1", newTokenHolder("tok"))
+	if summary := u.Close(); summary != nil {
+		t.Errorf("expected nil summary, got %+v", summary)
+	}
+	if len(fake.batches) != 0 {
+		t.Errorf("expected no uploads, got %d", len(fake.batches))
+	}
+}
+
+func TestAuditUploader_SplitWrites(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeAuditServer{}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newAuditTestService(t, srv)
+
+	u := s.newAuditUploader(context.Background(), "d-1", newTokenHolder("tok"))
+	line := `{"ts":"t","directive_id":"d-1","dest_host":"github.com","dest_port":443,"decision":"allow","reason_code":"OK"}` + "\n"
+	u.Write([]byte(line[:20]))
+	u.Write([]byte(line[20:] + "not json\n" + line))
+	summary := u.Close()
+
+	if summary == nil || summary.Allowed != 2 || summary.Hosts["github.com"].Allowed != 2 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestAuditUploader_HostCap(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeAuditServer{}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newAuditTestService(t, srv)
+
+	u := s.newAuditUploader(context.Background(), "d-1", newTokenHolder("tok"))
+	logger := egressproxy.NewAuditLogger(u, "d-1")
+	for i := range auditMaxSummaryHosts + 10 {
+		logger.Log(egressproxy.AuditEvent{DestHost: fmt.Sprintf("h%d.example.com", i), DestPort: 53, Decision: "deny", ReasonCode: "DNS_DENIED", Method: "DNS"})
+	}
+	summary := u.Close()
+
+	if len(summary.Hosts) != auditMaxSummaryHosts || !summary.HostsTruncated {
+		t.Errorf("got %d hosts, truncated = %v", len(summary.Hosts), summary.HostsTruncated)
+	}
+	if summary.Denied != auditMaxSummaryHosts+10 {
+		t.Errorf("denied = %d", summary.Denied)
+	}
+}
+
+func TestAuditUploader_SpoolAndReplay(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeAuditServer{}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newAuditTestService(t, srv)
+
+	// The daemon is shutting down: nothing can be sent, so the batch is
+	// spooled rather than lost.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	u := s.newAuditUploader(ctx, "d-1", newTokenHolder("tok-1"))
+	egressproxy.NewAuditLogger(u, "d-1").Log(egressproxy.AuditEvent{DestHost: "github.com", DestPort: 443, Decision: "allow", ReasonCode: "OK"})
+	summary := u.Close()
+	if summary == nil || summary.EventsDropped != 0 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if len(fake.batches) != 0 {
+		t.Fatalf("expected no uploads, got %d", len(fake.batches))
+	}
+
+	entries, err := s.auditSpool.Replay()
+	if err != nil || len(entries) != 1 || entries[0].DirectiveID != "d-1" || len(entries[0].Request.Events) != 1 {
+		t.Fatalf("spool = %+v, %v", entries, err)
+	}
+
+	s.replayAuditSpool(context.Background())
+	if len(fake.batches) != 1 || fake.tokens[0] != "tok-1" || fake.batches[0].Events[0].DestHost != "github.com" {
+		t.Errorf("replayed batches = %+v", fake.batches)
+	}
+	if entries, _ := s.auditSpool.Replay(); len(entries) != 0 {
+		t.Errorf("spool not truncated: %d entries", len(entries))
+	}
+}
+
+func TestAuditUploader_RejectedBatchDropped(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeAuditServer{status: http.StatusNotFound}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newAuditTestService(t, srv)
+
+	u := s.newAuditUploader(context.Background(), "d-1", newTokenHolder("tok"))
+	egressproxy.NewAuditLogger(u, "d-1").Log(egressproxy.AuditEvent{DestHost: "github.com", DestPort: 443, Decision: "allow", ReasonCode: "OK"})
+	summary := u.Close()
+
+	if summary == nil || summary.EventsDropped != 1 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if entries, _ := s.auditSpool.Replay(); len(entries) != 0 {
+		t.Errorf("rejected batch spooled: %+v", entries)
+	}
+}
+
+func TestAuditSpool_ReplayKeepsTransientFailures(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeAuditServer{status: http.StatusForbidden}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newAuditTestService(t, srv)
+
+	if err := s.auditSpool.Append(auditSpoolEntry{DirectiveID: "d-1", Token: "tok", Request: protocol.AuditEventsRequest{Seq: 0}}); err != nil {
+		t.Fatal(err)
+	}
+	// Rejected outright (expired token): nothing left to retry.
+	s.replayAuditSpool(context.Background())
+	if entries, _ := s.auditSpool.Replay(); len(entries) != 0 {
+		t.Errorf("expected spool truncated, got %d entries", len(entries))
+	}
+
+	if err := s.auditSpool.Append(auditSpoolEntry{DirectiveID: "d-1", Token: "tok", Request: protocol.AuditEventsRequest{Seq: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.replayAuditSpool(ctx)
+	if entries, _ := s.auditSpool.Replay(); len(entries) != 1 {
+		t.Errorf("expected spool kept, got %d entries", len(entries))
+	}
+}
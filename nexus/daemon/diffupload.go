@@ -0,0 +1,191 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cybros.ai/nexus/client"
+	"cybros.ai/nexus/protocol"
+)
+
+// diffUploadMaxStalls bounds consecutive chunk rounds that make no progress
+// (e.g. repeated offset conflicts) before the upload is abandoned.
+const diffUploadMaxStalls = 3
+
+// maxDiffSummaryFiles caps the per-file entries in diff_summary.
+const maxDiffSummaryFiles = 1000
+
+// diffFileStat is one entry of the per-file diff summary.
+type diffFileStat struct {
+	Path    string `json:"path"`
+	OldPath string `json:"old_path,omitempty"` // set for renames/copies
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Binary  bool   `json:"binary,omitempty"`
+}
+
+// parseNumstat parses `git diff --numstat -z` output.
+func parseNumstat(out []byte) ([]diffFileStat, error) {
+	var stats []diffFileStat
+	fields := strings.Split(string(out), "\x00")
+	for i := 0; i < len(fields); i++ {
+		rec := fields[i]
+		if rec == "" {
+			continue
+		}
+		parts := strings.SplitN(rec, "\t", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("numstat: malformed record %q", rec)
+		}
+		st := diffFileStat{Path: parts[2]}
+		if parts[0] == "-" && parts[1] == "-" {
+			st.Binary = true
+		} else {
+			var err error
+			if st.Added, err = strconv.Atoi(parts[0]); err != nil {
+				return nil, fmt.Errorf("numstat: %w", err)
+			}
+			if st.Removed, err = strconv.Atoi(parts[1]); err != nil {
+				return nil, fmt.Errorf("numstat: %w", err)
+			}
+		}
+		if st.Path == "" {
+			// Rename/copy: the old and new paths follow as separate fields.
+			if i+2 >= len(fields) {
+				return nil, fmt.Errorf("numstat: truncated rename record")
+			}
+			st.OldPath, st.Path = fields[i+1], fields[i+2]
+			i += 2
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// buildDiffSummary renders the per-file summary for artifacts_manifest.diff_summary.
+func buildDiffSummary(stats []diffFileStat) map[string]any {
+	insertions, deletions := 0, 0
+	for _, st := range stats {
+		insertions += st.Added
+		deletions += st.Removed
+	}
+	files := stats
+	if len(files) > maxDiffSummaryFiles {
+		files = files[:maxDiffSummaryFiles]
+	}
+	if files == nil {
+		files = []diffFileStat{}
+	}
+	return map[string]any{
+		"files":           files,
+		"files_changed":   len(stats),
+		"insertions":      insertions,
+		"deletions":       deletions,
+		"files_truncated": len(stats) > len(files),
+	}
+}
+
+// truncateDiff cuts a unified diff to at most max bytes, preferring a file
+// boundary so that every included file patch is complete. If the first file
+// alone exceeds max, it is cut at the last full line.
+func truncateDiff(diff []byte, max int) []byte {
+	if len(diff) <= max {
+		return diff
+	}
+	head := diff[:max]
+	if i := bytes.LastIndex(head, []byte("\ndiff --git ")); i > 0 {
+		return head[:i+1]
+	}
+	if i := bytes.LastIndexByte(head, '\n'); i >= 0 {
+		return head[:i+1]
+	}
+	return head
+}
+
+// uploadDiff sends diff through the chunked diff upload endpoint. It first
+// asks the server how much it already has (an earlier attempt may have been
+// interrupted) and resumes from there; after a failed chunk it resyncs again
+// before continuing. It returns the manifest entry for artifacts_manifest.diff_upload.
+func (s *Service) uploadDiff(ctx context.Context, directiveID string, token *tokenHolder, diff []byte) (map[string]any, error) {
+	sum := sha256.Sum256(diff)
+	digest := hex.EncodeToString(sum[:])
+	total := int64(len(diff))
+	chunkBytes := int64(s.cfg.Diff.ChunkBytes)
+
+	var st protocol.DiffUploadStatus
+	resync := true
+	chunks, stalls := 0, 0
+	for !st.Complete && st.ReceivedBytes < total {
+		prev := st.ReceivedBytes
+		err := postWithRetry(ctx, "diff_chunk", func() error {
+			reqCtx, cancel := client.WithTimeout(ctx)
+			defer cancel()
+
+			if resync {
+				got, err := s.cli.DiffUploadStatus(reqCtx, directiveID, token.Get(), digest)
+				if err != nil {
+					return err
+				}
+				if got.ReceivedBytes < 0 || got.ReceivedBytes > total {
+					return fmt.Errorf("diff upload: server reports %d of %d bytes", got.ReceivedBytes, total)
+				}
+				st, resync = got, false
+				if st.Complete || st.ReceivedBytes == total {
+					return nil
+				}
+			}
+
+			off := st.ReceivedBytes
+			end := min(off+chunkBytes, total)
+			chunk := diff[off:end]
+			chunkSum := sha256.Sum256(chunk)
+			got, err := s.cli.UploadDiffChunk(reqCtx, directiveID, token.Get(), protocol.DiffChunkRequest{
+				SHA256:      digest,
+				TotalBytes:  total,
+				Offset:      off,
+				BytesBase64: base64.StdEncoding.EncodeToString(chunk),
+				ChunkSHA256: hex.EncodeToString(chunkSum[:]),
+			})
+			if err != nil {
+				resync = true
+				var httpErr client.HTTPError
+				if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusConflict {
+					// Offset mismatch: pick up the server's position next round.
+					return nil
+				}
+				return err
+			}
+			if got.ReceivedBytes < 0 || got.ReceivedBytes > total {
+				return fmt.Errorf("diff upload: server reports %d of %d bytes", got.ReceivedBytes, total)
+			}
+			st = got
+			chunks++
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if st.ReceivedBytes <= prev && !st.Complete {
+			stalls++
+			if stalls >= diffUploadMaxStalls {
+				return nil, fmt.Errorf("diff upload: no progress at %d of %d bytes", st.ReceivedBytes, total)
+			}
+		} else {
+			stalls = 0
+		}
+	}
+
+	return map[string]any{
+		"sha256":      digest,
+		"total_bytes": total,
+		"chunks_sent": chunks,
+	}, nil
+}
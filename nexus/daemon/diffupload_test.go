@@ -0,0 +1,218 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"cybros.ai/nexus/client"
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/protocol"
+)
+
+func TestParseNumstat(t *testing.T) {
+	t.Parallel()
+
+	out := []byte("3\t1\tREADME.md\x00-\t-\timg.png\x000\t0\t\x00old.txt\x00new.txt\x0010\t0\tdir/a b.go\x00")
+	stats, err := parseNumstat(out)
+	if err != nil {
+		t.Fatalf("parseNumstat: %v", err)
+	}
+	want := []diffFileStat{
+		{Path: "README.md", Added: 3, Removed: 1},
+		{Path: "img.png", Binary: true},
+		{Path: "new.txt", OldPath: "old.txt"},
+		{Path: "dir/a b.go", Added: 10},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("got %+v", stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("stats[%d] = %+v, want %+v", i, stats[i], want[i])
+		}
+	}
+
+	summary := buildDiffSummary(stats)
+	if summary["files_changed"] != 4 || summary["insertions"] != 13 || summary["deletions"] != 1 || summary["files_truncated"] != false {
+		t.Errorf("unexpected summary: %+v", summary)
+	}
+
+	if _, err := parseNumstat([]byte("garbage\x00")); err == nil {
+		t.Error("expected error for malformed record")
+	}
+}
+
+func TestTruncateDiff(t *testing.T) {
+	t.Parallel()
+
+	fileA := "diff --git a/a b/a\n+aaaa\n"
+	fileB := "diff --git a/b b/b\n+bbbbbbbbbbbbbbbbbbbb\n"
+	diff := []byte(fileA + fileB)
+
+	if got := truncateDiff(diff, len(diff)); !bytes.Equal(got, diff) {
+		t.Errorf("diff within limit should be unchanged")
+	}
+	if got := string(truncateDiff(diff, len(fileA)+10)); got != fileA {
+		t.Errorf("expected cut at file boundary, got %q", got)
+	}
+	if got := string(truncateDiff(diff, 22)); got != "diff --git a/a b/a\n" {
+		t.Errorf("expected cut at line boundary, got %q", got)
+	}
+}
+
+// fakeDiffServer implements the chunked diff upload endpoints.
+type fakeDiffServer struct {
+	mu        sync.Mutex
+	data      []byte
+	sha       string
+	failNext  int // fail this many chunk posts after storing them (lost response)
+	chunkHits int
+}
+
+func (f *fakeDiffServer) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if !strings.HasSuffix(r.URL.Path, "/diff_chunks") {
+			http.NotFound(w, r)
+			return
+		}
+		status := func(total int64) protocol.DiffUploadStatus {
+			return protocol.DiffUploadStatus{ReceivedBytes: int64(len(f.data)), Complete: total > 0 && int64(len(f.data)) == total}
+		}
+		if r.Method == http.MethodGet {
+			if r.URL.Query().Get("sha256") != f.sha {
+				f.data = nil
+			}
+			_ = json.NewEncoder(w).Encode(status(0))
+			return
+		}
+
+		var req protocol.DiffChunkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		f.chunkHits++
+		if req.Offset != int64(len(f.data)) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		chunk, err := base64.StdEncoding.DecodeString(req.BytesBase64)
+		if err != nil {
+			t.Errorf("base64: %v", err)
+		}
+		sum := sha256.Sum256(chunk)
+		if hex.EncodeToString(sum[:]) != req.ChunkSHA256 {
+			t.Errorf("chunk sha mismatch at offset %d", req.Offset)
+		}
+		f.sha = req.SHA256
+		f.data = append(f.data, chunk...)
+		if f.failNext > 0 {
+			f.failNext--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(status(req.TotalBytes))
+	})
+}
+
+func newDiffUploadService(t *testing.T, srv *httptest.Server, chunkBytes int) *Service {
+	t.Helper()
+	cfg := config.Default()
+	cfg.ServerURL = srv.URL
+	cfg.Diff.ChunkBytes = chunkBytes
+	cli, err := client.New(cfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	return &Service{cfg: cfg, cli: cli}
+}
+
+func TestUploadDiff_ChunksAndHashes(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeDiffServer{}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+
+	diff := bytes.Repeat([]byte("diff --git a/x b/x\n+line\n"), 40)
+	s := newDiffUploadService(t, srv, 100)
+	manifest, err := s.uploadDiff(context.Background(), "d-1", newTokenHolder("tok"), diff)
+	if err != nil {
+		t.Fatalf("uploadDiff: %v", err)
+	}
+	if !bytes.Equal(fake.data, diff) {
+		t.Fatalf("server got %d bytes, want %d", len(fake.data), len(diff))
+	}
+	sum := sha256.Sum256(diff)
+	if manifest["sha256"] != hex.EncodeToString(sum[:]) || manifest["total_bytes"] != int64(len(diff)) {
+		t.Errorf("unexpected manifest: %+v", manifest)
+	}
+	if want := (len(diff) + 99) / 100; manifest["chunks_sent"] != want {
+		t.Errorf("chunks_sent = %v, want %d", manifest["chunks_sent"], want)
+	}
+}
+
+func TestUploadDiff_ResumesAfterLostResponse(t *testing.T) {
+	t.Parallel()
+
+	// The server stores the chunk but the response is lost (502). The next
+	// attempt must resync instead of resending from the old offset.
+	fake := &fakeDiffServer{failNext: 1}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+
+	diff := bytes.Repeat([]byte("0123456789"), 25)
+	s := newDiffUploadService(t, srv, 100)
+	if _, err := s.uploadDiff(context.Background(), "d-1", newTokenHolder("tok"), diff); err != nil {
+		t.Fatalf("uploadDiff: %v", err)
+	}
+	if !bytes.Equal(fake.data, diff) {
+		t.Fatalf("server data mismatch: got %d bytes", len(fake.data))
+	}
+	if fake.chunkHits != 3 {
+		t.Errorf("expected 3 chunk posts (no duplicates), got %d", fake.chunkHits)
+	}
+}
+
+func TestUploadDiff_ResumesPartialUpload(t *testing.T) {
+	t.Parallel()
+
+	diff := bytes.Repeat([]byte("abcdefghij"), 30)
+	sum := sha256.Sum256(diff)
+	fake := &fakeDiffServer{data: append([]byte(nil), diff[:200]...), sha: hex.EncodeToString(sum[:])}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+
+	s := newDiffUploadService(t, srv, 100)
+	manifest, err := s.uploadDiff(context.Background(), "d-1", newTokenHolder("tok"), diff)
+	if err != nil {
+		t.Fatalf("uploadDiff: %v", err)
+	}
+	if !bytes.Equal(fake.data, diff) || manifest["chunks_sent"] != 1 {
+		t.Fatalf("expected resume from byte 200 with one chunk, got chunks_sent=%v", manifest["chunks_sent"])
+	}
+}
+
+func TestUploadDiff_NotSupported(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	s := newDiffUploadService(t, srv, 100)
+	if _, err := s.uploadDiff(context.Background(), "d-1", newTokenHolder("tok"), []byte("x")); err == nil {
+		t.Fatal("expected error when the endpoint is missing")
+	}
+}
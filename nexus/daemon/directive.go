@@ -10,12 +10,15 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"cybros.ai/nexus/client"
+	"cybros.ai/nexus/egressproxy"
 	"cybros.ai/nexus/logstream"
+	"cybros.ai/nexus/netpolicy"
 	"cybros.ai/nexus/protocol"
 	"cybros.ai/nexus/sandbox"
 	"cybros.ai/nexus/version"
@@ -47,6 +50,40 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 		return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
 			"failed", "invalid facility ID")
 	}
+	if err := validateInteractive(spec.Interactive); err != nil {
+		slog.Error("invalid interactive spec, rejecting directive", "directive_id", directiveID, "error", err)
+		return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+			"failed", "invalid interactive spec")
+	}
+	if err := validateSession(spec.Session); err != nil {
+		slog.Error("invalid session spec, rejecting directive", "directive_id", directiveID, "error", err)
+		return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+			"failed", "invalid session id")
+	}
+	// Expand the net preset once so every driver (egress proxy, tap
+	// firewall, audit echo) sees the same effective policy.
+	if net := spec.Capabilities.Net; net != nil {
+		eff, err := netpolicy.ExpandPreset(*net)
+		if err != nil {
+			slog.Error("invalid net capability, rejecting directive", "directive_id", directiveID, "error", err)
+			return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+				"failed", "invalid net capability")
+		}
+		spec.Capabilities.Net = &eff
+	}
+	egressLimits, err := egressproxy.LimitsFor(spec.Limits, spec.Capabilities.Net)
+	if err != nil {
+		slog.Error("invalid egress limits, rejecting directive", "directive_id", directiveID, "error", err)
+		return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+			"failed", "invalid egress limits")
+	}
+	// The host-wide egress_limits are a ceiling the directive can only tighten.
+	egressLimits = egressLimits.Tighten(egressproxy.Limits{
+		BytesPerSec: s.cfg.EgressLimits.BytesPerSec,
+		MaxBytes:    s.cfg.EgressLimits.MaxBytes,
+		MaxConns:    s.cfg.EgressLimits.MaxConns,
+		IdleTimeout: s.cfg.EgressLimits.IdleTimeout,
+	})
 	facilityPath := filepath.Join(s.cfg.WorkDir, spec.Facility.ID)
 	if err := os.MkdirAll(facilityPath, 0o755); err != nil {
 		return err
@@ -96,6 +133,85 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 		return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
 			"failed", "driver_unhealthy")
 	}
+	if spec.Interactive != nil {
+		if tr, ok := drv.(sandbox.TerminalRunner); !ok || !tr.RunsTerminal() {
+			slog.Error("driver cannot run a terminal, rejecting interactive directive",
+				"directive_id", directiveID, "driver", driverName)
+			return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+				"failed", "interactive unsupported by driver")
+		}
+	}
+
+	// Sessions: the first directive naming a session opens its sandbox and
+	// later ones run in it. The directive holds the session until it
+	// finishes; the session then idles or closes.
+	var sess *sessionEntry
+	followUp := false
+	closeSession := false
+	releaseSession := func() {}
+	if spec.Session != nil {
+		if _, ok := drv.(sandbox.SessionRunner); !ok {
+			slog.Error("driver cannot keep sessions, rejecting directive",
+				"directive_id", directiveID, "driver", driverName)
+			return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+				"failed", "sessions unsupported by driver")
+		}
+		var reason string
+		sess, reason = s.sessions.acquire(spec.Session.ID, sessionKey(spec, profile))
+		if reason != "" {
+			slog.Error("session unavailable, rejecting directive",
+				"directive_id", directiveID, "session_id", spec.Session.ID, "reason", reason)
+			return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+				"failed", reason)
+		}
+		sn, _ := sess.current()
+		followUp = sn != nil
+		closeSession = spec.Session.Close
+		idle := sessionIdleTimeout(spec.Session, s.cfg.Sessions.MaxIdleTimeout)
+		var once sync.Once
+		releaseSession = func() {
+			once.Do(func() { s.sessions.release(sess, idle, closeSession) })
+		}
+		defer releaseSession()
+	}
+
+	// Enforce limits.disk_mb: project quota and/or watchdog for host-side
+	// facilities, image sizing for firecracker.
+	diskGuard, err := s.newDiskQuotaGuard(directiveID, facilityPath, driverName, spec.Limits)
+	if err != nil {
+		slog.Error("invalid disk limit, rejecting directive", "directive_id", directiveID, "error", err)
+		return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+			"failed", "invalid disk limit")
+	}
+	defer diskGuard.Close()
+
+	// Resolve Capabilities.Secrets. Values stay in memory (process env and
+	// tmpfs) and are scrubbed from streamed logs; the tape only sees refs.
+	// http_auth secrets stay with the egress proxy, which injects them.
+	// A session's secrets are those resolved when it was opened; the
+	// session owns them from then on.
+	var secrets *sandbox.Secrets
+	keepSecrets := false
+	if followUp {
+		_, secrets = sess.current()
+		keepSecrets = true
+	} else {
+		err = checkHTTPAuthAllowed(spec.Capabilities.Secrets, spec.Capabilities.Net)
+		if err == nil {
+			secrets, err = s.loadSecrets(ctx, directiveID, token, drv, spec.Capabilities.Secrets)
+		}
+		if err != nil {
+			s.recordTape("secrets_failed", directiveID, spec, driverName, profile, map[string]any{"error": err.Error()})
+			slog.Error("secrets unavailable, rejecting directive", "directive_id", directiveID, "error", err)
+			return s.rejectDirective(ctx, directiveID, token, spec, directiveStart,
+				"failed", "secrets unavailable")
+		}
+	}
+	defer func() {
+		if !keepSecrets {
+			_ = secrets.Close()
+		}
+	}()
 
 	// Report started (must succeed before we upload log_chunks; otherwise the server is still in `leased`)
 	eff := map[string]any{
@@ -104,6 +220,17 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 		"net":     spec.Capabilities.Net, // echo for audit (actual enforcement depends on profile/driver)
 		"fs":      spec.Capabilities.Fs,
 	}
+	if cr, ok := drv.(sandbox.CapabilityReporter); ok {
+		for k, v := range cr.EffectiveCapabilities(spec.Capabilities) {
+			eff[k] = v
+		}
+	}
+	if diskGuard != nil {
+		eff["disk_quota"] = diskGuard.Summary()
+	}
+	if secrets != nil {
+		eff["secrets"] = secretsSummary(spec.Capabilities.Secrets, secrets)
+	}
 	startedReq := protocol.StartedRequest{
 		EffectiveCapabilitiesSummary: eff,
 		SandboxVersion:               fmt.Sprintf("phase1-%s", drv.Name()),
@@ -129,6 +256,12 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 	}
 	uploader := logstream.New(s.cli, directiveID, token.Get, s.cfg.Log.ChunkBytes, maxOutputBytes)
 	defer func() { _ = uploader.Close() }()
+	if r := s.newLogRedactor(secrets.Values()); r != nil {
+		uploader.SetRedactor(r)
+		if spec.Interactive != nil {
+			uploader.SetIdleFlush(terminalIdleFlush)
+		}
+	}
 	if s.cfg.LogOverflow.Enabled {
 		uploader.EnableOverflow(filepath.Join(facilityPath, s.cfg.LogOverflow.Dir, directiveID), s.cfg.LogOverflow.MaxBytesPerStream)
 	}
@@ -146,8 +279,9 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 
 	// Prepare facility: host-executing drivers clone on the host filesystem.
 	// Isolated drivers (bwrap/container/firecracker) handle facility prep inside
-	// the sandbox via RepoURL in RunRequest.
-	if driverName == "host" || driverName == "darwin-automation" {
+	// the sandbox via RepoURL in RunRequest. Follow-up directives of a
+	// session find the facility as the session left it.
+	if (driverName == "host" || driverName == "darwin-automation") && !followUp {
 		if err := s.prepareFacility(execCtx, directiveID, facilityPath, spec, uploader, driverName, profile); err != nil {
 			s.recordTape("prepare_failed", directiveID, spec, driverName, profile, map[string]any{"error": err.Error()})
 			uploader.UploadBytes(ctx, "stderr", []byte(fmt.Sprintf("[prepare] failed: %v\n", err)))
@@ -176,6 +310,10 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 			if manifest := buildLogOverflowManifest(spec, directiveID, s.cfg.LogOverflow, uploader); manifest != nil {
 				finishReq.ArtifactsManifest["log_overflow"] = manifest
 			}
+			if count, manifest := buildRedactionManifest(uploader); manifest != nil {
+				finishReq.RedactionCount = count
+				finishReq.ArtifactsManifest["log_redaction"] = manifest
+			}
 			if postErr := postWithRetry(ctx, "finished", func() error {
 				reqCtx, cancel := client.WithTimeout(ctx)
 				defer cancel()
@@ -197,8 +335,32 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 		}
 	}
 
+	// Snapshot the facility before execution so the diff covers exactly what
+	// this directive changed, including pre-existing dirty state.
+	var snapper *gitSnapshotter
+	var snapBefore *gitSnapshot
+	if diffEnabled(spec) {
+		s.exportFacility(execCtx, drv, directiveID, facilityPath)
+		var snapErr error
+		if snapper, snapErr = newGitSnapshotter(); snapErr != nil {
+			slog.Warn("git snapshotter unavailable", "directive_id", directiveID, "error", snapErr)
+		} else {
+			defer snapper.Close()
+			if snapBefore, snapErr = snapper.Snapshot(execCtx, facilityPath); snapErr != nil {
+				slog.Warn("git snapshot before failed", "directive_id", directiveID, "error", snapErr)
+			}
+		}
+	}
+
 	// Inject standard environment variables for the directive
 	env := buildDirectiveEnv(s.cfg, directiveID, spec)
+	for k, v := range secrets.TrustEnv() {
+		env[k] = v
+	}
+
+	// Egress audit events go to Mothership on their own channel (use parent
+	// ctx so the final batches are sent after execCtx ends).
+	audit := s.newAuditUploader(ctx, directiveID, token)
 
 	req := sandbox.RunRequest{
 		DirectiveID:    directiveID,
@@ -211,18 +373,69 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 		MaxOutputBytes: maxOutputBytes,
 		ChunkBytes:     s.cfg.Log.ChunkBytes,
 		LogSink:        uploader,
+		Audit:          audit,
+		Egress: egressproxy.Options{
+			Limits:    egressLimits,
+			Metrics:   s.metrics.Egress,
+			Intercept: secrets.EgressIntercept(),
+			Cache:     s.egressCache.Partition(spec.Tenant),
+		},
 		// Capability plumbing for sandbox drivers
 		NetCapability: spec.Capabilities.Net,
 		FsCapability:  spec.Capabilities.Fs,
 		RepoURL:       spec.Facility.RepoURL,
 		FacilityPath:  facilityPath,
 		Limits:        spec.Limits,
+		Cgroups:       s.cgroupApplier(),
+		Network:       s.tapNetwork(),
+		Secrets:       secrets,
+	}
+
+	// Interactive: the terminal reads input pulled from Mothership until
+	// the command ends.
+	inputCtx, inputCancel := context.WithCancel(execCtx)
+	inputDone := make(chan struct{})
+	if spec.Interactive != nil {
+		input := make(chan sandbox.TerminalInput)
+		req.Terminal = &sandbox.Terminal{
+			Rows:  spec.Interactive.Rows,
+			Cols:  spec.Interactive.Cols,
+			Input: input,
+		}
+		go func() {
+			defer close(inputDone)
+			s.pumpInput(inputCtx, directiveID, token, input)
+		}()
+	} else {
+		close(inputDone)
 	}
 
-	s.recordTape("run_started", directiveID, spec, driverName, profile, map[string]any{
-		"cwd": spec.Cwd,
-	})
-	res, err := drv.Run(execCtx, req)
+	runData := map[string]any{
+		"cwd":         spec.Cwd,
+		"interactive": spec.Interactive != nil,
+	}
+	if sess != nil {
+		runData["session_id"] = sess.id
+	}
+	s.recordTape("run_started", directiveID, spec, driverName, profile, runData)
+	s.watchDiskQuota(execCtx, diskGuard, directiveID, execCancel)
+	var res sandbox.RunResult
+	sessionOpened := false
+	if sess != nil {
+		res, sessionOpened, err = s.runInSession(execCtx, drv, sess, req, secrets)
+		if sessionOpened {
+			keepSecrets = true
+		}
+		// A session whose command failed to run (rather than ran and
+		// failed) cannot be trusted with the next one.
+		if err != nil && execCtx.Err() == nil {
+			closeSession = true
+		}
+	} else {
+		res, err = drv.Run(execCtx, req)
+	}
+	inputCancel()
+	<-inputDone
 	if err != nil {
 		slog.Error("driver run failed", "directive_id", directiveID, "driver", driverName, "error", err)
 		s.recordTape("driver_error", directiveID, spec, driverName, profile, map[string]any{"error": err.Error()})
@@ -238,6 +451,7 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 		}
 	}
 	s.recordTape("run_finished", directiveID, spec, driverName, profile, tapeData)
+	egressSummary := audit.Close()
 
 	// Stop heartbeat loop
 	heartbeatCancel()
@@ -254,16 +468,55 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 	if cancelRequested.Load() && status != "succeeded" {
 		status = "canceled"
 	}
+	if diskGuard.Exceeded(status) {
+		status = sandbox.StatusDiskQuotaExceeded
+	}
+	diskGuard.Close()
+
+	// Host-side steps below read the facility directory.
+	if snapper != nil || len(spec.Artifacts.Collect) > 0 {
+		s.exportFacility(ctx, drv, directiveID, facilityPath)
+	}
 
-	// Collect diff if this is a repo-type facility (use parent ctx, not execCtx
-	// which may already be canceled/timed out)
-	diffBase64 := s.collectDiff(ctx, facilityPath, spec)
+	// Collect diff for repo facilities, or any git facility with always_diff
+	// (use parent ctx, not execCtx which may already be canceled/timed out)
+	var diff diffResult
+	if snapper != nil {
+		diff = s.collectDiff(ctx, directiveID, token, snapper, facilityPath, spec, snapBefore)
+	}
 
 	// Report finished
 	artifacts := map[string]any{}
 	if manifest := buildLogOverflowManifest(spec, directiveID, s.cfg.LogOverflow, uploader); manifest != nil {
 		artifacts["log_overflow"] = manifest
 	}
+	redactionCount, redactionManifest := buildRedactionManifest(uploader)
+	if redactionManifest != nil {
+		artifacts["log_redaction"] = redactionManifest
+	}
+	if manifest := buildGitSnapshotManifest(snapBefore, diff.After); manifest != nil {
+		artifacts["git_snapshot"] = manifest
+	}
+	if diff.Summary != nil {
+		artifacts["diff_summary"] = diff.Summary
+	}
+	if diff.Upload != nil {
+		artifacts["diff_upload"] = diff.Upload
+	}
+	if manifest := s.collectAndUploadArtifacts(ctx, directiveID, facilityPath, spec, token); manifest != nil {
+		artifacts["collected"] = manifest
+	}
+	if sess != nil {
+		sn, _ := sess.current()
+		artifacts["session"] = map[string]any{
+			"id":     sess.id,
+			"opened": sessionOpened,
+			"open":   sn != nil && !closeSession,
+		}
+		// Hand the session back before reporting, so a follow-up directive
+		// dispatched on finished finds it idle.
+		releaseSession()
+	}
 
 	// log a local structured summary (helps offline debugging)
 	_ = json.NewEncoder(os.Stdout).Encode(map[string]any{
@@ -280,8 +533,13 @@ func (s *Service) handleDirective(ctx context.Context, lease protocol.DirectiveL
 		Status:            status,
 		StdoutTruncated:   res.StdoutTruncated,
 		StderrTruncated:   res.StderrTruncated,
-		DiffBase64:        diffBase64,
+		DiffTruncated:     diff.Truncated,
+		DiffBase64:        diff.Base64,
+		SnapshotBefore:    snapBefore.headOrEmpty(),
+		SnapshotAfter:     diff.After.headOrEmpty(),
+		RedactionCount:    redactionCount,
 		ArtifactsManifest: artifacts,
+		EgressSummary:     egressSummary,
 		FinishedAt:        time.Now().UTC().Format(time.RFC3339Nano),
 	}
 	if postErr := postWithRetry(ctx, "finished", func() error {
@@ -402,39 +660,73 @@ func (s *Service) prepareFacility(ctx context.Context, directiveID string, facil
 	return nil
 }
 
-// collectDiff runs `git diff HEAD` in the facility directory and returns
-// the diff as a base64-encoded string. Returns "" if the facility is not
-// a git repo or if the diff is empty/too large.
-func (s *Service) collectDiff(parentCtx context.Context, facilityPath string, spec protocol.DirectiveSpec) string {
-	// Only collect diff for facilities with a repo_url
-	if spec.Facility.RepoURL == "" {
-		return ""
+// exportFacility refreshes facilityPath from a driver that keeps the facility
+// elsewhere (firecracker block mode). The log overflow directory is written
+// by nexusd on the host and is preserved. Failures are logged; the host copy
+// is then stale but still consistent.
+func (s *Service) exportFacility(ctx context.Context, drv sandbox.Driver, directiveID, facilityPath string) {
+	fe, ok := drv.(sandbox.FacilityExporter)
+	if !ok {
+		return
 	}
-
-	// Check if it's a git repo
-	gitDir := filepath.Join(facilityPath, ".git")
-	if _, err := os.Stat(gitDir); err != nil {
-		return ""
+	var keep []string
+	if s.cfg.LogOverflow.Enabled {
+		keep = append(keep, s.cfg.LogOverflow.Dir)
 	}
+	if _, err := fe.ExportFacility(ctx, facilityPath, keep); err != nil {
+		slog.Warn("facility export failed", "directive_id", directiveID, "error", err)
+	}
+}
 
-	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
-	defer cancel()
+// diffEnabled reports whether a diff should be produced for the directive:
+// always for repo facilities, and for any git facility when always_diff is set.
+func diffEnabled(spec protocol.DirectiveSpec) bool {
+	return spec.Facility.RepoURL != "" || spec.Artifacts.AlwaysDiff
+}
 
-	cmd := exec.CommandContext(ctx, "git", "diff", "HEAD")
-	cmd.Dir = facilityPath
-	cmd.Env = append(minimalExecEnv(),
-		"GIT_TERMINAL_PROMPT=0",
-		"GIT_PAGER=cat",
-	)
+// diffResult is the outcome of collectDiff.
+type diffResult struct {
+	Base64    string
+	Truncated bool           // the diff the server receives is incomplete
+	Summary   map[string]any // artifacts_manifest.diff_summary
+	Upload    map[string]any // artifacts_manifest.diff_upload (chunked upload)
+	After     *gitSnapshot
+}
 
-	out, err := cmd.Output()
+// collectDiff snapshots the facility after execution and diffs it against the
+// pre-execution snapshot. Untracked (non-ignored) files are included. Without
+// a before snapshot (e.g. the repo was cloned inside the sandbox) the diff is
+// taken against the final HEAD.
+//
+// Diffs up to limits.max_diff_bytes are sent inline; larger ones go through
+// the chunked diff upload (up to diff.max_upload_bytes). If the diff exceeds
+// that too, or the upload fails, a diff truncated at a file boundary is sent
+// inline and Truncated is set. A per-file summary is always produced.
+func (s *Service) collectDiff(ctx context.Context, directiveID string, token *tokenHolder, snapper *gitSnapshotter, facilityPath string, spec protocol.DirectiveSpec, before *gitSnapshot) diffResult {
+	var res diffResult
+	after, err := snapper.Snapshot(ctx, facilityPath)
 	if err != nil {
-		slog.Warn("git diff failed", "path", facilityPath, "error", err)
-		return ""
+		slog.Warn("git snapshot failed", "path", facilityPath, "error", err)
+		return res
+	}
+	if after == nil {
+		return res
 	}
+	res.After = after
 
-	if len(out) == 0 {
-		return ""
+	base := after.headTree
+	if before != nil {
+		base = before.Tree
+	}
+	if base == after.Tree {
+		res.Summary = buildDiffSummary(nil)
+		return res
+	}
+
+	if stats, err := snapper.NumStat(ctx, facilityPath, base, after.Tree); err != nil {
+		slog.Warn("git diff --numstat failed", "path", facilityPath, "error", err)
+	} else {
+		res.Summary = buildDiffSummary(stats)
 	}
 
 	// Enforce max_diff_bytes from spec (default 1 MiB)
@@ -442,10 +734,48 @@ func (s *Service) collectDiff(parentCtx context.Context, facilityPath string, sp
 	if maxDiffBytes <= 0 {
 		maxDiffBytes = 1_048_576
 	}
-	if len(out) > maxDiffBytes {
-		slog.Warn("diff too large, skipping", "bytes", len(out), "max_bytes", maxDiffBytes)
-		return ""
+	readLimit := max(int64(maxDiffBytes), s.cfg.Diff.MaxUploadBytes)
+	out, over, err := snapper.Diff(ctx, facilityPath, base, after.Tree, readLimit)
+	if err != nil {
+		slog.Warn("git diff failed", "path", facilityPath, "error", err)
+		return res
+	}
+
+	if len(out) == 0 {
+		return res
+	}
+	if !over && len(out) <= maxDiffBytes {
+		res.Base64 = base64.StdEncoding.EncodeToString(out)
+		return res
 	}
 
-	return base64.StdEncoding.EncodeToString(out)
+	if !over {
+		upload, err := s.uploadDiff(ctx, directiveID, token, out)
+		if err == nil {
+			res.Upload = upload
+			return res
+		}
+		slog.Warn("chunked diff upload failed, sending truncated diff", "directive_id", directiveID, "bytes", len(out), "error", err)
+	} else {
+		slog.Warn("diff exceeds upload limit, sending truncated diff", "directive_id", directiveID, "max_upload_bytes", s.cfg.Diff.MaxUploadBytes)
+	}
+	res.Base64 = base64.StdEncoding.EncodeToString(truncateDiff(out, maxDiffBytes))
+	res.Truncated = true
+	return res
+}
+
+// buildGitSnapshotManifest renders before/after snapshots for
+// artifacts_manifest.git_snapshot.
+func buildGitSnapshotManifest(before, after *gitSnapshot) map[string]any {
+	if before == nil && after == nil {
+		return nil
+	}
+	m := map[string]any{}
+	if before != nil {
+		m["before"] = before
+	}
+	if after != nil {
+		m["after"] = after
+	}
+	return m
 }
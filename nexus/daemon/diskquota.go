@@ -0,0 +1,129 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+// diskQuotaGuard enforces limits.disk_mb for one directive. Facilities on the
+// host filesystem get a project quota when available plus a usage watchdog
+// that terminates the run once the limit is exceeded; firecracker enforces
+// the limit itself through the workspace image size.
+type diskQuotaGuard struct {
+	limit     int64
+	mechanism string // project_quota/watchdog/ext4_image
+	facility  string
+	quota     *sandbox.ProjectQuota
+
+	exceeded atomic.Bool
+	stop     context.CancelFunc
+	done     chan struct{}
+}
+
+// newDiskQuotaGuard prepares enforcement for limits.disk_mb. It returns nil
+// when no limit is set.
+func (s *Service) newDiskQuotaGuard(directiveID, facilityPath, driverName string, limits protocol.Limits) (*diskQuotaGuard, error) {
+	limit, err := sandbox.DiskLimitBytes(limits)
+	if err != nil || limit == 0 {
+		return nil, err
+	}
+	g := &diskQuotaGuard{limit: limit, facility: facilityPath}
+	if driverName == "firecracker" {
+		g.mechanism = "ext4_image"
+		return g, nil
+	}
+
+	g.mechanism = "watchdog"
+	if s.cfg.DiskQuota.ProjectQuota {
+		q, err := sandbox.ApplyProjectQuota(facilityPath, limit)
+		switch {
+		case err == nil && q != nil:
+			g.quota = q
+			g.mechanism = "project_quota"
+		case errors.Is(err, sandbox.ErrProjectQuotaUnsupported):
+			slog.Debug("project quota unavailable, using watchdog only", "directive_id", directiveID, "error", err)
+		case err != nil:
+			slog.Warn("project quota failed, using watchdog only", "directive_id", directiveID, "error", err)
+		}
+	}
+	return g, nil
+}
+
+// Summary describes enforcement for effective_capabilities_summary.
+func (g *diskQuotaGuard) Summary() map[string]any {
+	return map[string]any{
+		"limit_mb":  g.limit >> 20,
+		"mechanism": g.mechanism,
+	}
+}
+
+func (g *diskQuotaGuard) usage() (int64, error) {
+	if g.quota != nil {
+		return g.quota.Usage()
+	}
+	return sandbox.DiskUsage(g.facility)
+}
+
+// watchDiskQuota starts the usage watchdog; onExceeded is called (once) when the
+// facility grows past the limit. No-op for image-backed facilities.
+func (s *Service) watchDiskQuota(ctx context.Context, g *diskQuotaGuard, directiveID string, onExceeded func()) {
+	if g == nil || g.mechanism == "ext4_image" {
+		return
+	}
+	watchCtx, cancel := context.WithCancel(ctx)
+	g.stop = cancel
+	g.done = make(chan struct{})
+	go func() {
+		defer close(g.done)
+		sandbox.WatchDiskUsage(watchCtx, g.usage, g.limit, s.cfg.DiskQuota.CheckInterval, func(used int64) {
+			slog.Warn("facility exceeded disk limit, terminating directive",
+				"directive_id", directiveID, "used_bytes", used, "limit_bytes", g.limit)
+			g.exceeded.Store(true)
+			onExceeded()
+		})
+	}()
+}
+
+// Exceeded reports whether the run should be reported as disk_quota_exceeded:
+// the watchdog fired, or the command failed with the facility at its limit
+// (a hard project quota makes writes fail before the watchdog sees growth).
+func (g *diskQuotaGuard) Exceeded(status string) bool {
+	if g == nil {
+		return false
+	}
+	if g.exceeded.Load() {
+		return true
+	}
+	if status != "failed" || g.mechanism == "ext4_image" {
+		return false
+	}
+	used, err := g.usage()
+	if err != nil {
+		return false
+	}
+	// Allow for block rounding between quota accounting and the walk.
+	slack := min(max(g.limit/100, 64<<10), g.limit/2)
+	return used >= g.limit-slack
+}
+
+// Close stops the watchdog and lifts the project quota limit. Safe to call
+// more than once.
+func (g *diskQuotaGuard) Close() {
+	if g == nil {
+		return
+	}
+	if g.stop != nil {
+		g.stop()
+		<-g.done
+		g.stop = nil
+	}
+	if g.quota != nil {
+		g.quota.Cleanup()
+		g.quota = nil
+	}
+}
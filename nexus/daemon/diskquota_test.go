@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/protocol"
+)
+
+func newDiskQuotaService() *Service {
+	cfg := config.Default()
+	cfg.DiskQuota.ProjectQuota = false
+	cfg.DiskQuota.CheckInterval = 10 * time.Millisecond
+	return &Service{cfg: cfg}
+}
+
+func TestDiskQuotaGuard_NoLimit(t *testing.T) {
+	t.Parallel()
+
+	s := newDiskQuotaService()
+	g, err := s.newDiskQuotaGuard("d-1", t.TempDir(), "host", protocol.Limits{})
+	if err != nil || g != nil {
+		t.Fatalf("expected nil guard, got %+v, %v", g, err)
+	}
+	// nil guard is inert
+	s.watchDiskQuota(context.Background(), g, "d-1", func() { t.Error("unexpected call") })
+	if g.Exceeded("failed") {
+		t.Error("nil guard should never report exceeded")
+	}
+	g.Close()
+}
+
+func TestDiskQuotaGuard_InvalidLimit(t *testing.T) {
+	t.Parallel()
+
+	s := newDiskQuotaService()
+	if _, err := s.newDiskQuotaGuard("d-1", t.TempDir(), "host", protocol.Limits{DiskMB: 1 << 30}); err == nil {
+		t.Fatal("expected error for oversized limit")
+	}
+}
+
+func TestDiskQuotaGuard_FirecrackerUsesImage(t *testing.T) {
+	t.Parallel()
+
+	s := newDiskQuotaService()
+	g, err := s.newDiskQuotaGuard("d-1", t.TempDir(), "firecracker", protocol.Limits{DiskMB: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := g.Summary(); got["mechanism"] != "ext4_image" || got["limit_mb"] != int64(100) {
+		t.Errorf("unexpected summary: %+v", got)
+	}
+	s.watchDiskQuota(context.Background(), g, "d-1", func() { t.Error("unexpected call") })
+	if g.Exceeded("failed") {
+		t.Error("image-backed facilities are judged by the driver")
+	}
+	g.Close()
+}
+
+func TestDiskQuotaGuard_WatchdogTerminates(t *testing.T) {
+	t.Parallel()
+
+	s := newDiskQuotaService()
+	facility := t.TempDir()
+	g, err := s.newDiskQuotaGuard("d-1", facility, "host", protocol.Limits{DiskMB: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if g.Summary()["mechanism"] != "watchdog" {
+		t.Fatalf("unexpected summary: %+v", g.Summary())
+	}
+	if g.Exceeded("failed") {
+		t.Fatal("empty facility should not be over quota")
+	}
+
+	fired := make(chan struct{})
+	s.watchDiskQuota(context.Background(), g, "d-1", func() { close(fired) })
+	if err := os.WriteFile(filepath.Join(facility, "big.bin"), make([]byte, 2<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchdog did not fire")
+	}
+	if !g.Exceeded("canceled") {
+		t.Error("expected exceeded after watchdog fired")
+	}
+}
@@ -5,6 +5,7 @@ package daemon
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"runtime"
 	"time"
 
@@ -52,7 +53,14 @@ func newDriverFactory(cfg config.Config) (*sandbox.DriverFactory, error) {
 	}
 
 	if cfg.UntrustedDriver == "firecracker" {
-		drivers = append(drivers, firecrackerdriver.New(cfg.Firecracker))
+		fcCfg := cfg.Firecracker
+		if fcCfg.Snapshot.Dir == "" {
+			fcCfg.Snapshot.Dir = filepath.Join(cfg.WorkDir, ".fc-snapshots")
+		}
+		if fcCfg.FacilityImageDir == "" {
+			fcCfg.FacilityImageDir = filepath.Join(cfg.WorkDir, ".fc-facilities")
+		}
+		drivers = append(drivers, firecrackerdriver.New(fcCfg, newHelperJailer(cfg.Helper.SocketPath)))
 	}
 
 	factory := sandbox.NewFactory(drivers...)
@@ -0,0 +1,311 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gitSnapshotTimeout bounds each snapshot/diff step. Snapshots hash the whole
+// worktree, so this is longer than a typical git call.
+const gitSnapshotTimeout = 60 * time.Second
+
+// gitSnapshot records the state of a git facility at one point in time.
+type gitSnapshot struct {
+	Head  string `json:"head,omitempty"` // commit at HEAD ("" on an unborn branch)
+	Tree  string `json:"tree"`           // tree of the worktree incl. untracked, non-ignored files
+	Dirty bool   `json:"dirty"`          // Tree differs from HEAD's tree
+
+	headTree string // HEAD's tree, or the empty tree on an unborn branch
+}
+
+// headOrEmpty returns the HEAD commit, or "" for a nil snapshot.
+func (s *gitSnapshot) headOrEmpty() string {
+	if s == nil {
+		return ""
+	}
+	return s.Head
+}
+
+// gitSnapshotter captures worktree snapshots of a facility without touching
+// its index or object store: objects written while snapshotting go to a
+// private scratch directory that is removed by Close. The facility's repo
+// config is sandbox-controlled, so hooks, fsmonitor, filter drivers and
+// external diff/textconv commands are all disabled.
+type gitSnapshotter struct {
+	scratch string
+}
+
+func newGitSnapshotter() (*gitSnapshotter, error) {
+	scratch, err := os.MkdirTemp("", "nexus-gitsnap-")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(filepath.Join(scratch, "objects"), 0o700); err != nil {
+		_ = os.RemoveAll(scratch)
+		return nil, err
+	}
+	return &gitSnapshotter{scratch: scratch}, nil
+}
+
+// Close removes the scratch object directory.
+func (g *gitSnapshotter) Close() {
+	_ = os.RemoveAll(g.scratch)
+}
+
+// gitDir returns the .git directory of the worktree at dir, or "" if dir
+// is not a git worktree. Layouts that would point git at files outside the
+// facility are refused: .git or .git/objects as a file or symlink (gitdir
+// redirects, linked worktrees), a commondir file, and object alternates.
+func gitDir(dir string) (string, error) {
+	gd := filepath.Join(dir, ".git")
+	fi, err := os.Lstat(gd)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", errors.New("facility .git is not a directory")
+	}
+	if fi, err := os.Lstat(filepath.Join(gd, "objects")); err == nil && !fi.IsDir() {
+		return "", errors.New("facility .git/objects is not a directory")
+	}
+	for _, name := range []string{"commondir", filepath.Join("objects", "info", "alternates")} {
+		if _, err := os.Lstat(filepath.Join(gd, name)); err == nil {
+			return "", fmt.Errorf("facility repo has .git/%s", filepath.ToSlash(name))
+		}
+	}
+	return gd, nil
+}
+
+// Snapshot records HEAD and the full worktree tree of dir. It returns
+// (nil, nil) if dir is not a git repository.
+func (g *gitSnapshotter) Snapshot(parentCtx context.Context, dir string) (*gitSnapshot, error) {
+	if gd, err := gitDir(dir); err != nil || gd == "" {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(parentCtx, gitSnapshotTimeout)
+	defer cancel()
+
+	git, err := g.runner(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.CreateTemp(g.scratch, "index-")
+	if err != nil {
+		return nil, err
+	}
+	indexPath := index.Name()
+	_ = index.Close()
+	_ = os.Remove(indexPath) // git refuses to read an empty index file
+	defer os.Remove(indexPath)
+	git.env = append(git.env, "GIT_INDEX_FILE="+indexPath)
+
+	snap := &gitSnapshot{}
+	if out, err := git.run(ctx, "rev-parse", "--verify", "-q", "HEAD^{commit}"); err == nil {
+		snap.Head = out
+		if snap.headTree, err = git.run(ctx, "rev-parse", snap.Head+"^{tree}"); err != nil {
+			return nil, err
+		}
+		if _, err := git.run(ctx, "read-tree", snap.Head); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := git.run(ctx, "read-tree", "--empty"); err != nil {
+			return nil, err
+		}
+		// Hash of the empty tree in the repo's object format.
+		if snap.headTree, err = git.run(ctx, "write-tree"); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := git.run(ctx, "add", "-A"); err != nil {
+		return nil, err
+	}
+	if snap.Tree, err = git.run(ctx, "write-tree"); err != nil {
+		return nil, err
+	}
+	snap.Dirty = snap.Tree != snap.headTree
+	return snap, nil
+}
+
+// Diff returns the unified diff between two tree-ish objects previously
+// produced by Snapshot (or commits in the facility repo). At most limit bytes
+// are read; over reports whether the diff was longer.
+func (g *gitSnapshotter) Diff(parentCtx context.Context, dir, from, to string, limit int64) (out []byte, over bool, err error) {
+	ctx, cancel := context.WithTimeout(parentCtx, gitSnapshotTimeout)
+	defer cancel()
+
+	git, err := g.runner(ctx, dir)
+	if err != nil {
+		return nil, false, err
+	}
+	return git.outputLimited(ctx, limit, "diff", "-M", "--no-ext-diff", "--no-textconv", "--no-color", from, to, "--")
+}
+
+// NumStat returns per-file added/removed line counts between two tree-ish
+// objects, matching the rename detection used by Diff.
+func (g *gitSnapshotter) NumStat(parentCtx context.Context, dir, from, to string) ([]diffFileStat, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, gitSnapshotTimeout)
+	defer cancel()
+
+	git, err := g.runner(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	out, err := git.output(ctx, "diff", "-M", "--numstat", "-z", "--no-ext-diff", "--no-textconv", from, to, "--")
+	if err != nil {
+		return nil, err
+	}
+	return parseNumstat(out)
+}
+
+// gitRunner runs git in a facility with a fixed environment and config
+// overrides.
+type gitRunner struct {
+	dir  string
+	env  []string
+	args []string // -c overrides prepended to every invocation
+}
+
+// runner prepares a gitRunner for dir: repo and worktree pinned to dir, no
+// system/global config, repo config that could run commands neutralized,
+// and new objects written to the scratch directory with the repo's store as
+// an alternate.
+func (g *gitSnapshotter) runner(ctx context.Context, dir string) (*gitRunner, error) {
+	gd, err := gitDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if gd == "" {
+		return nil, errors.New("facility is not a git repository")
+	}
+	r := &gitRunner{
+		dir: dir,
+		env: append(minimalExecEnv(),
+			"GIT_DIR="+gd,
+			"GIT_WORK_TREE="+dir,
+			"GIT_TERMINAL_PROMPT=0",
+			"GIT_PAGER=cat",
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_CONFIG_GLOBAL="+os.DevNull,
+			"GIT_OPTIONAL_LOCKS=0",
+		),
+		args: []string{
+			"-c", "core.hooksPath=" + os.DevNull,
+			"-c", "core.fsmonitor=false",
+		},
+	}
+	filters, err := r.filterDrivers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range filters {
+		// An empty command disables the driver; process must be cleared too
+		// or git would still prefer a configured long-running filter.
+		for _, k := range []string{"clean", "smudge", "process"} {
+			r.args = append(r.args, "-c", "filter."+name+"."+k+"=")
+		}
+		r.args = append(r.args, "-c", "filter."+name+".required=false")
+	}
+
+	r.env = append(r.env,
+		"GIT_OBJECT_DIRECTORY="+filepath.Join(g.scratch, "objects"),
+		"GIT_ALTERNATE_OBJECT_DIRECTORIES="+filepath.Join(gd, "objects"),
+	)
+	return r, nil
+}
+
+// filterDrivers lists the filter driver names defined in the repo config.
+func (r *gitRunner) filterDrivers(ctx context.Context) ([]string, error) {
+	out, err := r.run(ctx, "config", "--name-only", "--get-regexp", `^filter\.`)
+	if err != nil {
+		var exitErr *exec.ExitError
+		// Exit status 1 means no matching keys.
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, key := range strings.Split(out, "\n") {
+		i, j := strings.Index(key, "."), strings.LastIndex(key, ".")
+		if i < 0 || j <= i {
+			continue
+		}
+		if name := key[i+1 : j]; !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// run executes git and returns its trimmed stdout.
+func (r *gitRunner) run(ctx context.Context, args ...string) (string, error) {
+	out, err := r.output(ctx, args...)
+	return strings.TrimSpace(string(out)), err
+}
+
+// outputLimited is like output but stops reading (and kills git) once more
+// than limit bytes have been produced.
+func (r *gitRunner) outputLimited(ctx context.Context, limit int64, args ...string) ([]byte, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", append(append([]string(nil), r.args...), args...)...)
+	cmd.Dir = r.dir
+	cmd.Env = r.env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, false, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	out, readErr := io.ReadAll(io.LimitReader(stdout, limit+1))
+	if int64(len(out)) > limit {
+		cancel()
+		_ = cmd.Wait()
+		return out[:limit], true, nil
+	}
+	if err := cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, false, fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return nil, false, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	if readErr != nil {
+		return nil, false, readErr
+	}
+	return out, false, nil
+}
+
+func (r *gitRunner) output(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append(append([]string(nil), r.args...), args...)...)
+	cmd.Dir = r.dir
+	cmd.Env = r.env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return out, fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return out, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
@@ -0,0 +1,282 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cybros.ai/nexus/protocol"
+)
+
+func gitCmd(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	gitCmd(t, dir, "init", "-q")
+	writeFile(t, dir, "README.md", "hello\n")
+	gitCmd(t, dir, "add", "README.md")
+	gitCmd(t, dir, "commit", "-q", "-m", "init")
+	return dir
+}
+
+func newTestSnapshotter(t *testing.T) *gitSnapshotter {
+	t.Helper()
+	g, err := newGitSnapshotter()
+	if err != nil {
+		t.Fatalf("newGitSnapshotter: %v", err)
+	}
+	t.Cleanup(g.Close)
+	return g
+}
+
+func TestGitSnapshot_NotARepo(t *testing.T) {
+	t.Parallel()
+
+	g := newTestSnapshotter(t)
+	snap, err := g.Snapshot(context.Background(), t.TempDir())
+	if err != nil || snap != nil {
+		t.Fatalf("expected nil snapshot, got %+v, %v", snap, err)
+	}
+}
+
+func TestGitSnapshot_CleanAndDirty(t *testing.T) {
+	t.Parallel()
+
+	dir := newTestRepo(t)
+	g := newTestSnapshotter(t)
+	ctx := context.Background()
+
+	clean, err := g.Snapshot(ctx, dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	head := gitCmd(t, dir, "rev-parse", "HEAD")
+	if clean.Head != head || clean.Dirty {
+		t.Fatalf("unexpected clean snapshot: %+v (HEAD %s)", clean, head)
+	}
+
+	writeFile(t, dir, "new.txt", "untracked\n")
+	dirty, err := g.Snapshot(ctx, dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if !dirty.Dirty || dirty.Head != head || dirty.Tree == clean.Tree {
+		t.Fatalf("untracked file should make the snapshot dirty: %+v", dirty)
+	}
+
+	// Snapshots must not touch the repo's own index or object store.
+	if status := gitCmd(t, dir, "status", "--porcelain"); status != "?? new.txt" {
+		t.Fatalf("index was modified: %q", status)
+	}
+	cmd := exec.Command("git", "cat-file", "-e", dirty.Tree+"^{tree}")
+	cmd.Dir = dir
+	if err := cmd.Run(); err == nil {
+		t.Fatal("snapshot tree should not be written to the repo object store")
+	}
+}
+
+func TestGitSnapshot_DiffIncludesCommitsAndUntracked(t *testing.T) {
+	t.Parallel()
+
+	dir := newTestRepo(t)
+	writeFile(t, dir, "dirty-before.txt", "pre-existing\n")
+	g := newTestSnapshotter(t)
+	ctx := context.Background()
+
+	before, err := g.Snapshot(ctx, dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	writeFile(t, dir, "README.md", "hello\nworld\n")
+	gitCmd(t, dir, "commit", "-q", "-am", "agent commit")
+	writeFile(t, dir, "added.txt", "brand new\n")
+
+	after, err := g.Snapshot(ctx, dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if after.Head == before.Head {
+		t.Fatal("expected HEAD to move")
+	}
+
+	out, _, err := g.Diff(ctx, dir, before.Tree, after.Tree, 1<<20)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	diff := string(out)
+	if !strings.Contains(diff, "+world") || !strings.Contains(diff, "b/added.txt") {
+		t.Fatalf("diff missing changes:\n%s", diff)
+	}
+	if strings.Contains(diff, "dirty-before.txt") {
+		t.Fatalf("diff should not include pre-existing changes:\n%s", diff)
+	}
+}
+
+func TestGitSnapshot_DiffLimitAndNumStat(t *testing.T) {
+	t.Parallel()
+
+	dir := newTestRepo(t)
+	g := newTestSnapshotter(t)
+	ctx := context.Background()
+
+	before, err := g.Snapshot(ctx, dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	writeFile(t, dir, "big.txt", strings.Repeat("line\n", 1000))
+	gitCmd(t, dir, "mv", "README.md", "README.txt")
+	after, err := g.Snapshot(ctx, dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	out, over, err := g.Diff(ctx, dir, before.Tree, after.Tree, 100)
+	if err != nil || !over || len(out) != 100 {
+		t.Fatalf("expected limited diff, got len=%d over=%v err=%v", len(out), over, err)
+	}
+
+	stats, err := g.NumStat(ctx, dir, before.Tree, after.Tree)
+	if err != nil {
+		t.Fatalf("NumStat: %v", err)
+	}
+	want := map[string]diffFileStat{
+		"README.txt": {Path: "README.txt", OldPath: "README.md"},
+		"big.txt":    {Path: "big.txt", Added: 1000},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	for _, st := range stats {
+		if want[st.Path] != st {
+			t.Errorf("stat %s = %+v, want %+v", st.Path, st, want[st.Path])
+		}
+	}
+}
+
+func TestGitSnapshot_UnbornBranch(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	gitCmd(t, dir, "init", "-q")
+	g := newTestSnapshotter(t)
+
+	empty, err := g.Snapshot(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if empty.Head != "" || empty.Dirty {
+		t.Fatalf("unexpected snapshot: %+v", empty)
+	}
+
+	writeFile(t, dir, "a.txt", "a\n")
+	snap, err := g.Snapshot(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if !snap.Dirty {
+		t.Fatalf("expected dirty snapshot: %+v", snap)
+	}
+	out, _, err := g.Diff(context.Background(), dir, snap.headTree, snap.Tree, 1<<20)
+	if err != nil || !strings.Contains(string(out), "+a") {
+		t.Fatalf("Diff: %v\n%s", err, out)
+	}
+}
+
+func TestGitSnapshot_DoesNotRunRepoCommands(t *testing.T) {
+	t.Parallel()
+
+	dir := newTestRepo(t)
+	marker := filepath.Join(t.TempDir(), "pwned")
+	writeFile(t, dir, ".gitattributes", "*.txt filter=evil diff=evil\n")
+	gitCmd(t, dir, "config", "filter.evil.clean", "touch "+marker+"; cat")
+	gitCmd(t, dir, "config", "filter.evil.process", "touch "+marker)
+	gitCmd(t, dir, "config", "diff.evil.command", "touch "+marker)
+	gitCmd(t, dir, "config", "diff.external", "touch "+marker)
+	gitCmd(t, dir, "config", "core.fsmonitor", "touch "+marker)
+
+	g := newTestSnapshotter(t)
+	ctx := context.Background()
+	before, err := g.Snapshot(ctx, dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	writeFile(t, dir, "x.txt", "payload\n")
+	after, err := g.Snapshot(ctx, dir)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if _, _, err := g.Diff(ctx, dir, before.Tree, after.Tree, 1<<20); err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("repo-configured command was executed")
+	}
+}
+
+func TestGitSnapshot_RefusesRedirectedRepos(t *testing.T) {
+	t.Parallel()
+
+	outside := newTestRepo(t)
+	g := newTestSnapshotter(t)
+	ctx := context.Background()
+
+	// .git file pointing at a repo outside the facility.
+	dir := t.TempDir()
+	writeFile(t, dir, ".git", "gitdir: "+filepath.Join(outside, ".git")+"\n")
+	if _, err := g.Snapshot(ctx, dir); err == nil {
+		t.Error("gitdir file: expected error")
+	}
+
+	// .git symlinked to another repo.
+	dir = t.TempDir()
+	if err := os.Symlink(filepath.Join(outside, ".git"), filepath.Join(dir, ".git")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.Snapshot(ctx, dir); err == nil {
+		t.Error(".git symlink: expected error")
+	}
+
+	// Object alternates borrowing another repo's store.
+	dir = newTestRepo(t)
+	writeFile(t, dir, ".git/objects/info/alternates", filepath.Join(outside, ".git", "objects")+"\n")
+	if _, err := g.Snapshot(ctx, dir); err == nil {
+		t.Error("alternates: expected error")
+	}
+	if _, _, err := g.Diff(ctx, dir, "HEAD", "HEAD", 1<<20); err == nil {
+		t.Error("alternates: Diff expected error")
+	}
+}
+
+func TestDiffEnabled(t *testing.T) {
+	t.Parallel()
+
+	if diffEnabled(protocol.DirectiveSpec{}) {
+		t.Error("plain facility without always_diff should not diff")
+	}
+	if !diffEnabled(protocol.DirectiveSpec{Facility: protocol.FacilitySpec{RepoURL: "https://example.com/r.git"}}) {
+		t.Error("repo facility should diff")
+	}
+	if !diffEnabled(protocol.DirectiveSpec{Artifacts: protocol.ArtifactsSpec{AlwaysDiff: true}}) {
+		t.Error("always_diff should diff")
+	}
+}
@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"cybros.ai/nexus/helper"
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+// helperCallTimeout bounds a single nexus-helper call.
+const helperCallTimeout = 10 * time.Second
+
+// newHelperClient returns a nexus-helper client when helper.socket_path is
+// configured (nil otherwise). An unreachable helper is logged, not fatal:
+// it may start after nexusd, and every call is retried per directive.
+func newHelperClient(socketPath string) *helper.Client {
+	if socketPath == "" {
+		return nil
+	}
+	cli := helper.NewClient(socketPath)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if res, err := cli.Ping(ctx); err != nil {
+		slog.Warn("nexus-helper not reachable", "socket", socketPath, "error", err)
+	} else {
+		slog.Info("nexus-helper connected", "socket", socketPath, "version", res.Version)
+	}
+	return cli
+}
+
+// cgroupApplier returns the sandbox.CgroupApplier drivers should use: the
+// helper when configured, nil (direct cgroupfs writes) otherwise.
+func (s *Service) cgroupApplier() sandbox.CgroupApplier {
+	if s.helper == nil {
+		return nil
+	}
+	return helperCgroups{cli: s.helper}
+}
+
+// helperCgroups applies cgroup limits through nexus-helper. The directive's
+// cgroup is then delegated to nexusd, so a runtime inside the sandbox may
+// nest cgroups under its limits; cgroup.remove takes them along.
+type helperCgroups struct {
+	cli *helper.Client
+}
+
+func (h helperCgroups) ApplyCgroup(ctx context.Context, directiveID string, pid int, limits protocol.Limits) (func(), error) {
+	callCtx, cancel := context.WithTimeout(ctx, helperCallTimeout)
+	defer cancel()
+
+	remove := func() {
+		rmCtx, cancel := context.WithTimeout(context.Background(), helperCallTimeout)
+		defer cancel()
+		if err := h.cli.RemoveCgroup(rmCtx, directiveID); err != nil {
+			slog.Warn("nexus-helper: cgroup cleanup failed", "directive_id", directiveID, "error", err)
+		}
+	}
+
+	if _, err := h.cli.CreateCgroup(callCtx, helper.CgroupCreateParams{
+		DirectiveID:   directiveID,
+		MemoryMB:      max(limits.MemoryMB, 0),
+		CPUMillicores: max(limits.CPU, 0),
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := h.cli.DelegateCgroup(callCtx, directiveID); err != nil {
+		remove()
+		return nil, err
+	}
+	if err := h.cli.AttachCgroup(callCtx, directiveID, pid); err != nil {
+		remove()
+		return nil, err
+	}
+	return remove, nil
+}
+
+// tapNetwork returns the sandbox.TapNetwork drivers should use (nil without
+// the helper).
+func (s *Service) tapNetwork() sandbox.TapNetwork {
+	if s.helper == nil {
+		return nil
+	}
+	return helperNetwork{cli: s.helper}
+}
+
+// sweepNetwork removes TAP devices and nft tables left behind by a previous
+// nexusd that crashed mid-directive. Called once before directives run.
+func (s *Service) sweepNetwork(ctx context.Context) {
+	if s.helper == nil || s.cfg.UntrustedDriver != "firecracker" || s.cfg.Firecracker.NetworkMode != "tap" {
+		return
+	}
+	callCtx, cancel := context.WithTimeout(ctx, helperCallTimeout)
+	defer cancel()
+	res, err := s.helper.SweepNetwork(callCtx)
+	if len(res.Removed) > 0 {
+		slog.Info("nexus-helper: removed stale TAP devices", "devices", res.Removed)
+	}
+	if err != nil {
+		slog.Warn("nexus-helper: network sweep failed", "error", err)
+	}
+}
+
+// helperNetwork manages TAP devices, network namespaces and nftables rules
+// through nexus-helper.
+type helperNetwork struct {
+	cli *helper.Client
+}
+
+func (h helperNetwork) CreateTap(ctx context.Context, directiveID, hostCIDR string, owner int) (string, error) {
+	callCtx, cancel := context.WithTimeout(ctx, helperCallTimeout)
+	defer cancel()
+	res, err := h.cli.CreateTap(callCtx, helper.TapCreateParams{DirectiveID: directiveID, HostCIDR: hostCIDR, Owner: owner})
+	return res.Name, err
+}
+
+func (h helperNetwork) ApplyEgress(ctx context.Context, rules helper.NftApplyParams) error {
+	callCtx, cancel := context.WithTimeout(ctx, helperCallTimeout)
+	defer cancel()
+	_, err := h.cli.ApplyNft(callCtx, rules)
+	return err
+}
+
+// RemoveTap removes the rules before the device, so an interrupted teardown
+// leaves a device that the next sweep finds.
+func (h helperNetwork) RemoveTap(ctx context.Context, directiveID string) error {
+	callCtx, cancel := context.WithTimeout(ctx, helperCallTimeout)
+	defer cancel()
+	return errors.Join(
+		h.cli.RemoveNft(callCtx, directiveID),
+		h.cli.DeleteTap(callCtx, helper.TapDeleteParams{DirectiveID: directiveID}),
+	)
+}
+
+func (h helperNetwork) CreateNetns(ctx context.Context, directiveID string) error {
+	callCtx, cancel := context.WithTimeout(ctx, helperCallTimeout)
+	defer cancel()
+	_, err := h.cli.CreateNetns(callCtx, directiveID)
+	return err
+}
+
+func (h helperNetwork) DeleteNetns(ctx context.Context, directiveID string) error {
+	callCtx, cancel := context.WithTimeout(ctx, helperCallTimeout)
+	defer cancel()
+	return h.cli.DeleteNetns(callCtx, directiveID)
+}
+
+// newHelperJailer returns the sandbox.Jailer the firecracker driver should
+// use: nexus-helper when helper.socket_path is configured, so nexusd need not
+// be root for the jailer; nil otherwise.
+func newHelperJailer(socketPath string) sandbox.Jailer {
+	if socketPath == "" {
+		return nil
+	}
+	return helperJailer{cli: helper.NewClient(socketPath)}
+}
+
+// helperJailer launches jailed Firecracker VMMs through nexus-helper.
+type helperJailer struct {
+	cli *helper.Client
+}
+
+func (h helperJailer) PrepareJail(ctx context.Context, directiveID string, uid int) (string, error) {
+	res, err := h.cli.PrepareJailer(ctx, helper.JailerPrepareParams{DirectiveID: directiveID, UID: uid})
+	return res.Root, err
+}
+
+func (h helperJailer) LaunchJail(ctx context.Context, p helper.JailerLaunchParams, stdin, stdout, stderr *os.File) error {
+	_, err := h.cli.LaunchJailer(ctx, p, stdin, stdout, stderr)
+	return err
+}
+
+func (h helperJailer) WaitJail(ctx context.Context, directiveID string) (int, error) {
+	res, err := h.cli.WaitJailer(ctx, directiveID)
+	return res.ExitCode, err
+}
+
+func (h helperJailer) KillJail(ctx context.Context, directiveID string) error {
+	return h.cli.KillJailer(ctx, directiveID)
+}
+
+func (h helperJailer) CleanupJail(ctx context.Context, directiveID string) error {
+	return h.cli.CleanupJailer(ctx, directiveID)
+}
@@ -0,0 +1,150 @@
+//go:build linux
+
+package daemon
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"cybros.ai/nexus/helper"
+	"cybros.ai/nexus/protocol"
+)
+
+// startHelper serves a nexus-helper on a socket in dir, with cgroups and
+// namespaces under dir. The returned function lists the commands it ran.
+func startHelper(t *testing.T, dir string) func() []string {
+	t.Helper()
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	srv := helper.NewServer(helper.ServerConfig{
+		SocketPath:  filepath.Join(dir, "helper.sock"),
+		SocketGID:   -1,
+		AllowedUIDs: []int{os.Getuid()},
+		CgroupRoot:  filepath.Join(dir, "cgroup"),
+		NetnsDir:    filepath.Join(dir, "netns"),
+	}, func(_ context.Context, _ []byte, name string, args ...string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, strings.Join(append([]string{name}, args...), " "))
+		return nil
+	})
+	ln, err := srv.Listen()
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(calls)
+	}
+}
+
+func TestHelperCgroups_ApplyCgroup(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	startHelper(t, dir)
+	s := &Service{helper: newHelperClient(filepath.Join(dir, "helper.sock"))}
+	applier := s.cgroupApplier()
+	if applier == nil {
+		t.Fatal("expected a helper-backed cgroup applier")
+	}
+
+	cmd := exec.Command("sleep", "5")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start child: %v", err)
+	}
+	defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+
+	cleanup, err := applier.ApplyCgroup(context.Background(), "d1", cmd.Process.Pid, protocol.Limits{MemoryMB: 32})
+	if err != nil {
+		t.Fatalf("ApplyCgroup: %v", err)
+	}
+	defer cleanup()
+
+	cg := filepath.Join(dir, "cgroup", "d1")
+	if b, _ := os.ReadFile(filepath.Join(cg, "memory.max")); string(b) != strconv.Itoa(32*1024*1024) {
+		t.Fatalf("memory.max = %q", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(cg, "cgroup.procs")); string(b) != strconv.Itoa(cmd.Process.Pid) {
+		t.Fatalf("cgroup.procs = %q", b)
+	}
+	fi, err := os.Stat(cg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := fi.Sys().(*syscall.Stat_t); int(st.Uid) != os.Getuid() {
+		t.Fatalf("cgroup owned by uid %d, want it delegated to %d", st.Uid, os.Getuid())
+	}
+}
+
+func TestHelperNetwork_Netns(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	calls := startHelper(t, dir)
+	network := (&Service{helper: newHelperClient(filepath.Join(dir, "helper.sock"))}).tapNetwork()
+	if network == nil {
+		t.Fatal("expected a helper-backed network")
+	}
+
+	ctx := context.Background()
+	if err := network.CreateNetns(ctx, "d1"); err != nil {
+		t.Fatalf("CreateNetns: %v", err)
+	}
+	// The fake runner creates nothing, so stand in for the bind mount.
+	ns := helper.NetnsName("d1")
+	if err := os.MkdirAll(filepath.Join(dir, "netns"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "netns", ns), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.DeleteNetns(ctx, "d1"); err != nil {
+		t.Fatalf("DeleteNetns: %v", err)
+	}
+
+	want := []string{"ip netns add " + ns, "ip -n " + ns + " link set lo up", "ip netns delete " + ns}
+	if got := calls(); !slices.Equal(got, want) {
+		t.Fatalf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestCgroupApplier_NoHelper(t *testing.T) {
+	t.Parallel()
+
+	if a := (&Service{}).cgroupApplier(); a != nil {
+		t.Fatalf("expected nil applier without helper, got %T", a)
+	}
+}
+
+func TestHelperJailer(t *testing.T) {
+	t.Parallel()
+
+	if j := newHelperJailer(""); j != nil {
+		t.Fatalf("expected no jailer without helper, got %T", j)
+	}
+	if _, ok := newHelperJailer("/run/cybros-nexus/helper.sock").(helperJailer); !ok {
+		t.Fatal("expected a helper-backed jailer")
+	}
+}
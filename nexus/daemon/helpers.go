@@ -74,6 +74,10 @@ func buildDirectiveEnv(cfg config.Config, directiveID string, spec protocol.Dire
 		// Conventional CI-like variable
 		"CI": "true",
 	}
+	// An interactive directive runs on a real terminal.
+	if spec.Interactive != nil {
+		env["TERM"] = "xterm-256color"
+	}
 
 	return env
 }
@@ -0,0 +1,109 @@
+package daemon
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+const (
+	// inputWaitSeconds is how long the server may hold an input poll open;
+	// it must stay below the client's request timeout (poll.long_poll_timeout).
+	inputWaitSeconds = 15
+	// inputRetryDelay spaces input polls after a transient failure.
+	inputRetryDelay = 2 * time.Second
+	// maxTerminalSize bounds the rows and columns of a directive's terminal.
+	maxTerminalSize = 1000
+	// terminalIdleFlush is how long terminal output must be quiet before
+	// the log redactor releases what it holds back (e.g. a prompt).
+	terminalIdleFlush = 100 * time.Millisecond
+)
+
+// validateInteractive checks the initial terminal size of an interactive
+// directive (zero means the default).
+func validateInteractive(spec *protocol.InteractiveSpec) error {
+	if spec == nil {
+		return nil
+	}
+	if spec.Rows < 0 || spec.Rows > maxTerminalSize || spec.Cols < 0 || spec.Cols > maxTerminalSize {
+		return fmt.Errorf("terminal size %dx%d out of range", spec.Rows, spec.Cols)
+	}
+	return nil
+}
+
+// pumpInput long-polls the input endpoint of an interactive directive and
+// feeds the events, in order and each once, to the directive's terminal.
+// It closes out when ctx ends (the command finished, timed out or was
+// canceled) or when the server does not serve input for the directive.
+func (s *Service) pumpInput(ctx context.Context, directiveID string, token *tokenHolder, out chan<- sandbox.TerminalInput) {
+	defer close(out)
+
+	after := 0
+	for {
+		// The server holds the request open for up to inputWaitSeconds.
+		reqCtx, cancel := context.WithTimeout(ctx, inputWaitSeconds*time.Second+10*time.Second)
+		resp, err := s.cli.Input(reqCtx, directiveID, token.Get(), protocol.InputRequest{
+			After:       after,
+			WaitSeconds: inputWaitSeconds,
+		})
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			delay, retryable := retryDelay(err)
+			if !retryable {
+				slog.Warn("input unavailable, terminal gets no more input", "directive_id", directiveID, "error", err)
+				return
+			}
+			slog.Warn("input poll failed, retrying", "directive_id", directiveID, "error", err)
+			if !sleepCtx(ctx, max(delay, inputRetryDelay)) {
+				return
+			}
+			continue
+		}
+
+		for _, ev := range resp.Events {
+			if ev.Seq <= after {
+				continue // already delivered
+			}
+			after = ev.Seq
+			in, err := terminalInput(ev)
+			if err != nil {
+				slog.Warn("skipping invalid input event", "directive_id", directiveID, "seq", ev.Seq, "error", err)
+				continue
+			}
+			select {
+			case out <- in:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// terminalInput converts an input event for the terminal.
+func terminalInput(ev protocol.InputEvent) (sandbox.TerminalInput, error) {
+	switch ev.Type {
+	case "data":
+		b, err := base64.StdEncoding.DecodeString(ev.BytesBase64)
+		if err != nil {
+			return sandbox.TerminalInput{}, fmt.Errorf("decode bytes: %w", err)
+		}
+		return sandbox.TerminalInput{Data: b}, nil
+	case "resize":
+		if ev.Rows < 1 || ev.Rows > maxTerminalSize || ev.Cols < 1 || ev.Cols > maxTerminalSize {
+			return sandbox.TerminalInput{}, fmt.Errorf("terminal size %dx%d out of range", ev.Rows, ev.Cols)
+		}
+		return sandbox.TerminalInput{Rows: ev.Rows, Cols: ev.Cols}, nil
+	case "eof":
+		return sandbox.TerminalInput{EOF: true}, nil
+	default:
+		return sandbox.TerminalInput{}, fmt.Errorf("unknown input event type %q", ev.Type)
+	}
+}
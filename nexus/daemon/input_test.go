@@ -0,0 +1,233 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/client"
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+// fakeInputServer serves queued input events, each once per answer, and
+// records the after values it was asked for.
+type fakeInputServer struct {
+	mu      sync.Mutex
+	answers [][]protocol.InputEvent
+	status  []int // answered before the events, one per request
+	afters  []int
+}
+
+func (f *fakeInputServer) handler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/d-1/input") {
+			http.NotFound(w, r)
+			return
+		}
+		var req protocol.InputRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		if req.WaitSeconds != inputWaitSeconds {
+			t.Errorf("wait_seconds = %d", req.WaitSeconds)
+		}
+		f.mu.Lock()
+		f.afters = append(f.afters, req.After)
+		if len(f.status) > 0 {
+			status := f.status[0]
+			f.status = f.status[1:]
+			f.mu.Unlock()
+			w.WriteHeader(status)
+			return
+		}
+		var events []protocol.InputEvent
+		if len(f.answers) > 0 {
+			events, f.answers = f.answers[0], f.answers[1:]
+		}
+		f.mu.Unlock()
+		if events == nil {
+			// Nothing more: hold the poll open like a real server.
+			select {
+			case <-r.Context().Done():
+			case <-time.After(2 * time.Second):
+			}
+			events = []protocol.InputEvent{}
+		}
+		_ = json.NewEncoder(w).Encode(protocol.InputResponse{Events: events})
+	})
+}
+
+func newInputTestService(t *testing.T, srv *httptest.Server) *Service {
+	t.Helper()
+	cfg := config.Default()
+	cfg.ServerURL = srv.URL
+	cli, err := client.New(cfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	return &Service{cfg: cfg, cli: cli}
+}
+
+// receive reads n inputs from in.
+func receive(t *testing.T, in <-chan sandbox.TerminalInput, n int) []sandbox.TerminalInput {
+	t.Helper()
+	var got []sandbox.TerminalInput
+	for len(got) < n {
+		select {
+		case ti, ok := <-in:
+			if !ok {
+				t.Fatalf("input closed after %d events", len(got))
+			}
+			got = append(got, ti)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d of %d events", len(got), n)
+		}
+	}
+	return got
+}
+
+func TestPumpInput_DeliversEventsInOrder(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeInputServer{answers: [][]protocol.InputEvent{
+		{
+			{Seq: 1, Type: "data", BytesBase64: "bHMK"}, // "ls\n"
+			{Seq: 2, Type: "resize", Rows: 40, Cols: 120},
+		},
+		{
+			{Seq: 2, Type: "resize", Rows: 40, Cols: 120}, // repeated: skipped
+			{Seq: 3, Type: "bogus"},                       // invalid: skipped
+			{Seq: 4, Type: "eof"},
+		},
+	}}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newInputTestService(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan sandbox.TerminalInput)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.pumpInput(ctx, "d-1", newTokenHolder("tok"), in)
+	}()
+
+	got := receive(t, in, 3)
+	if string(got[0].Data) != "ls\n" || got[1].Rows != 40 || got[1].Cols != 120 || !got[2].EOF {
+		t.Errorf("inputs = %+v", got)
+	}
+
+	// The next poll asks for what follows the last event delivered.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		fake.mu.Lock()
+		afters := append([]int(nil), fake.afters...)
+		fake.mu.Unlock()
+		if len(afters) >= 3 {
+			if afters[0] != 0 || afters[1] != 2 || afters[2] != 4 {
+				t.Errorf("after values = %v, want 0, 2, 4", afters)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after values = %v, want 0, 2, 4", afters)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+	if _, ok := <-in; ok {
+		t.Error("input not closed after the directive ended")
+	}
+}
+
+func TestPumpInput_RetriesTransientErrors(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeInputServer{
+		status:  []int{http.StatusServiceUnavailable},
+		answers: [][]protocol.InputEvent{{{Seq: 1, Type: "data", BytesBase64: "eQo="}}},
+	}
+	srv := httptest.NewServer(fake.handler(t))
+	defer srv.Close()
+	s := newInputTestService(t, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	in := make(chan sandbox.TerminalInput)
+	go s.pumpInput(ctx, "d-1", newTokenHolder("tok"), in)
+
+	if got := receive(t, in, 1); string(got[0].Data) != "y\n" {
+		t.Errorf("inputs = %+v", got)
+	}
+}
+
+func TestPumpInput_StopsWhenUnsupported(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	s := newInputTestService(t, srv)
+
+	in := make(chan sandbox.TerminalInput)
+	go s.pumpInput(context.Background(), "d-1", newTokenHolder("tok"), in)
+	select {
+	case _, ok := <-in:
+		if ok {
+			t.Fatal("unexpected input")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("input not closed after a 404")
+	}
+}
+
+func TestTerminalInput(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		ev      protocol.InputEvent
+		want    sandbox.TerminalInput
+		wantErr bool
+	}{
+		{ev: protocol.InputEvent{Type: "data", BytesBase64: "aGk="}, want: sandbox.TerminalInput{Data: []byte("hi")}},
+		{ev: protocol.InputEvent{Type: "data", BytesBase64: "not base64"}, wantErr: true},
+		{ev: protocol.InputEvent{Type: "resize", Rows: 50, Cols: 200}, want: sandbox.TerminalInput{Rows: 50, Cols: 200}},
+		{ev: protocol.InputEvent{Type: "resize", Rows: 0, Cols: 80}, wantErr: true},
+		{ev: protocol.InputEvent{Type: "resize", Rows: 24, Cols: maxTerminalSize + 1}, wantErr: true},
+		{ev: protocol.InputEvent{Type: "eof"}, want: sandbox.TerminalInput{EOF: true}},
+		{ev: protocol.InputEvent{Type: "signal"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := terminalInput(tt.ev)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("terminalInput(%+v) error = %v, wantErr %v", tt.ev, err, tt.wantErr)
+			continue
+		}
+		if string(got.Data) != string(tt.want.Data) || got.Rows != tt.want.Rows || got.Cols != tt.want.Cols || got.EOF != tt.want.EOF {
+			t.Errorf("terminalInput(%+v) = %+v, want %+v", tt.ev, got, tt.want)
+		}
+	}
+}
+
+func TestValidateInteractive(t *testing.T) {
+	t.Parallel()
+
+	for _, spec := range []*protocol.InteractiveSpec{nil, {}, {Rows: 24, Cols: 80}, {Rows: maxTerminalSize, Cols: maxTerminalSize}} {
+		if err := validateInteractive(spec); err != nil {
+			t.Errorf("validateInteractive(%+v) = %v", spec, err)
+		}
+	}
+	for _, spec := range []*protocol.InteractiveSpec{{Rows: -1}, {Cols: maxTerminalSize + 1}} {
+		if err := validateInteractive(spec); err == nil {
+			t.Errorf("validateInteractive(%+v) accepted", spec)
+		}
+	}
+}
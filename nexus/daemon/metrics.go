@@ -1,6 +1,8 @@
 package daemon
 
 import (
+	"cybros.ai/nexus/egressproxy"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -17,6 +19,9 @@ type Metrics struct {
 	HeartbeatErrorTotal prometheus.Counter
 
 	DriverHealthy *prometheus.GaugeVec
+
+	// Egress is shared by every directive's egress proxy.
+	Egress *egressproxy.Metrics
 }
 
 // NewMetrics creates and registers all daemon metrics on the given registry.
@@ -57,6 +62,8 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 			Name: "nexusd_driver_healthy",
 			Help: "Whether a sandbox driver is healthy (1=yes, 0=no).",
 		}, []string{"driver"}),
+
+		Egress: egressproxy.NewMetrics(),
 	}
 
 	reg.MustRegister(
@@ -68,6 +75,7 @@ func NewMetrics(reg prometheus.Registerer) *Metrics {
 		m.HeartbeatErrorTotal,
 		m.DriverHealthy,
 	)
+	reg.MustRegister(m.Egress.Collectors()...)
 
 	return m
 }
@@ -50,6 +50,9 @@ func TestNewMetrics_Registration(t *testing.T) {
 	if !found["nexusd_poll_errors_total"] {
 		t.Error("expected nexusd_poll_errors_total in gathered metrics")
 	}
+	if !found["nexusd_egress_connections_active"] {
+		t.Error("expected nexusd_egress_connections_active in gathered metrics")
+	}
 }
 
 func TestNewMetrics_DoubleRegistration_Panics(t *testing.T) {
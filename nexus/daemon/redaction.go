@@ -0,0 +1,54 @@
+package daemon
+
+import (
+	"fmt"
+	"regexp"
+
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/logstream"
+)
+
+// compileRedactionPatterns returns the log redaction rules enabled by cfg:
+// the built-in patterns (unless disabled) followed by the configured ones.
+func compileRedactionPatterns(cfg config.LogRedactionConfig) ([]logstream.Pattern, error) {
+	var patterns []logstream.Pattern
+	if cfg.DefaultPatterns {
+		patterns = logstream.DefaultPatterns()
+	}
+	for _, p := range cfg.Patterns {
+		re, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("log_redaction pattern %s: %w", p.Name, err)
+		}
+		patterns = append(patterns, logstream.Pattern{Name: p.Name, Re: re})
+	}
+	return patterns, nil
+}
+
+// newLogRedactor builds the redaction stage for a directive's logs: its
+// secret values plus the configured patterns. Returns nil if there is
+// nothing to redact.
+func (s *Service) newLogRedactor(secretValues []string) logstream.Redactor {
+	f := logstream.NewFilter(secretValues, s.redactPatterns, s.cfg.LogRedaction.MaxMatchBytes)
+	if f == nil {
+		return nil
+	}
+	return f
+}
+
+// buildRedactionManifest reports how many spans were masked in the
+// directive's logs, in total and by rule. Returns 0, nil when none were.
+func buildRedactionManifest(uploader *logstream.Uploader) (int64, map[string]any) {
+	byRule := uploader.Redactions()
+	var total int64
+	for _, n := range byRule {
+		total += n
+	}
+	if total == 0 {
+		return 0, nil
+	}
+	return total, map[string]any{
+		"count":   total,
+		"by_rule": byRule,
+	}
+}
@@ -0,0 +1,298 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cybros.ai/nexus/client"
+	"cybros.ai/nexus/egressproxy"
+	"cybros.ai/nexus/netpolicy"
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+const (
+	// maxSecretRefs caps Capabilities.Secrets.Refs per directive.
+	maxSecretRefs = 64
+	// maxSecretBytes caps a single secret value.
+	maxSecretBytes = 64 * 1024
+)
+
+var (
+	secretEnvRe    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	secretFileRe   = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+	secretSchemeRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]*$`)
+)
+
+// httpAuthDefaultPort is the port of an http_auth host without one.
+const httpAuthDefaultPort = 443
+
+// reservedSecretEnv are variables a secret must not override: they control
+// the sandbox plumbing (proxy, loader, PATH) rather than the user command.
+var reservedSecretEnv = map[string]bool{
+	"PATH": true, "HOME": true, "SHELL": true,
+	"LD_PRELOAD": true, "LD_LIBRARY_PATH": true, "LD_AUDIT": true,
+	"HTTP_PROXY": true, "HTTPS_PROXY": true, "NO_PROXY": true,
+	"http_proxy": true, "https_proxy": true, "no_proxy": true,
+}
+
+// validateSecretRefs checks Capabilities.Secrets before anything is fetched.
+func validateSecretRefs(caps *protocol.SecretsCapabilityV1) error {
+	if caps == nil {
+		return nil
+	}
+	if len(caps.Refs) > maxSecretRefs {
+		return fmt.Errorf("secrets: %d refs exceeds maximum %d", len(caps.Refs), maxSecretRefs)
+	}
+	envs := map[string]bool{}
+	files := map[string]bool{}
+	dests := map[string]bool{}
+	for _, r := range caps.Refs {
+		if strings.TrimSpace(r.Ref) == "" {
+			return errors.New("secrets: ref is required")
+		}
+		targets := 0
+		for _, set := range []bool{r.Env != "", r.File != "", r.HTTPAuth != nil} {
+			if set {
+				targets++
+			}
+		}
+		if targets != 1 {
+			return fmt.Errorf("secrets: ref %q must set exactly one of env, file and http_auth", r.Ref)
+		}
+		switch {
+		case r.HTTPAuth != nil:
+			host, port, err := parseHTTPAuthHost(r.HTTPAuth.Host)
+			if err != nil {
+				return fmt.Errorf("secrets: ref %q: %w", r.Ref, err)
+			}
+			if r.HTTPAuth.Scheme != "" && !secretSchemeRe.MatchString(r.HTTPAuth.Scheme) {
+				return fmt.Errorf("secrets: invalid http_auth scheme %q", r.HTTPAuth.Scheme)
+			}
+			dest := net.JoinHostPort(host, strconv.Itoa(port))
+			if dests[dest] {
+				return fmt.Errorf("secrets: duplicate http_auth host %q", dest)
+			}
+			dests[dest] = true
+		case r.Env != "":
+			if !secretEnvRe.MatchString(r.Env) {
+				return fmt.Errorf("secrets: invalid env name %q", r.Env)
+			}
+			upper := strings.ToUpper(r.Env)
+			if reservedSecretEnv[r.Env] || strings.HasPrefix(upper, "CYBROS_") || strings.HasPrefix(upper, "NEXUS_") {
+				return fmt.Errorf("secrets: env name %q is reserved", r.Env)
+			}
+			if envs[r.Env] {
+				return fmt.Errorf("secrets: duplicate env name %q", r.Env)
+			}
+			envs[r.Env] = true
+		default:
+			if !secretFileRe.MatchString(r.File) {
+				return fmt.Errorf("secrets: invalid file name %q", r.File)
+			}
+			if files[r.File] {
+				return fmt.Errorf("secrets: duplicate file name %q", r.File)
+			}
+			files[r.File] = true
+		}
+	}
+	if len(dests) > 0 {
+		// The sandbox trust settings for the proxy's CA come with http_auth.
+		for _, k := range sandbox.EgressCAEnv {
+			if envs[k] {
+				return fmt.Errorf("secrets: env name %q is reserved with http_auth", k)
+			}
+		}
+		if files[sandbox.EgressCAFile] {
+			return fmt.Errorf("secrets: file name %q is reserved with http_auth", sandbox.EgressCAFile)
+		}
+	}
+	return nil
+}
+
+// parseHTTPAuthHost parses an http_auth host: an exact host name (no
+// wildcard or IP literal) with an optional port.
+func parseHTTPAuthHost(s string) (string, int, error) {
+	hostport := strings.TrimSpace(s)
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(hostport, strconv.Itoa(httpAuthDefaultPort))
+	}
+	entry, err := netpolicy.ParseAllowlistEntry(hostport)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid http_auth host %q: %w", s, err)
+	}
+	if entry.Wildcard {
+		return "", 0, fmt.Errorf("invalid http_auth host %q: wildcards are not allowed", s)
+	}
+	return entry.Host, entry.Port, nil
+}
+
+// checkHTTPAuthAllowed fails unless every http_auth host is allowed by the
+// net capability, as the proxy injects credentials only into traffic it
+// lets through, and every https origin of the capability's HTTP rules has
+// an http_auth secret, as only intercepted TLS can be held to them.
+func checkHTTPAuthAllowed(caps *protocol.SecretsCapabilityV1, netCap *protocol.NetCapabilityV1) error {
+	var rules netpolicy.HTTPRules
+	if netCap != nil {
+		var err error
+		if rules, err = netpolicy.ParseHTTPRules(netCap.HTTPRules); err != nil {
+			return fmt.Errorf("net: %w", err)
+		}
+	}
+	var refs []protocol.SecretRefV1
+	if caps != nil {
+		refs = caps.Refs
+	}
+	intercepted := map[string]bool{}
+	var policy *egressproxy.Policy
+	for _, r := range refs {
+		if r.HTTPAuth == nil {
+			continue
+		}
+		host, port, err := parseHTTPAuthHost(r.HTTPAuth.Host)
+		if err != nil {
+			return fmt.Errorf("secrets: ref %q: %w", r.Ref, err)
+		}
+		if policy == nil {
+			if policy, err = egressproxy.NewPolicy(netCap); err != nil {
+				return fmt.Errorf("secrets: %w", err)
+			}
+		}
+		if res := policy.Check(host, port); !res.Allowed {
+			return fmt.Errorf("secrets: http_auth host %s:%d is not allowed by the net capability (%s)", host, port, res.ReasonCode)
+		}
+		intercepted[net.JoinHostPort(host, strconv.Itoa(port))] = true
+	}
+	for _, r := range slices.Concat(rules.Allow, rules.Deny) {
+		if r.Scheme == "https" && !intercepted[net.JoinHostPort(r.Host, strconv.Itoa(r.Port))] {
+			return fmt.Errorf("net: http rule %q needs an http_auth secret for %s:%d", r.String(), r.Host, r.Port)
+		}
+	}
+	return nil
+}
+
+// loadSecrets resolves Capabilities.Secrets for a directive: the values are
+// fetched from Mothership with the directive token and materialized for the
+// driver (process env and tmpfs only). Returns nil when no secrets are
+// requested. Errors never include secret values.
+func (s *Service) loadSecrets(ctx context.Context, directiveID string, token *tokenHolder, drv sandbox.Driver, caps *protocol.SecretsCapabilityV1) (*sandbox.Secrets, error) {
+	if caps == nil || len(caps.Refs) == 0 {
+		return nil, nil
+	}
+	if err := validateSecretRefs(caps); err != nil {
+		return nil, err
+	}
+	if sd, ok := drv.(sandbox.SecretsDeliverer); !ok || !sd.DeliversSecrets() {
+		return nil, fmt.Errorf("secrets: driver %s cannot deliver secrets", drv.Name())
+	}
+	for _, r := range caps.Refs {
+		if r.HTTPAuth == nil {
+			continue
+		}
+		if ci, ok := drv.(sandbox.CredentialInjector); !ok || !ci.InjectsCredentials() {
+			return nil, fmt.Errorf("secrets: driver %s cannot inject http_auth credentials", drv.Name())
+		}
+		break
+	}
+
+	refs := make([]string, 0, len(caps.Refs))
+	seen := map[string]bool{}
+	for _, r := range caps.Refs {
+		if !seen[r.Ref] {
+			seen[r.Ref] = true
+			refs = append(refs, r.Ref)
+		}
+	}
+
+	var resp protocol.SecretsResponse
+	if err := postWithRetry(ctx, "secrets", func() error {
+		reqCtx, cancel := client.WithTimeout(ctx)
+		defer cancel()
+		var err error
+		resp, err = s.cli.FetchSecrets(reqCtx, directiveID, token.Get(), protocol.SecretsRequest{Refs: refs})
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("secrets: fetch: %w", err)
+	}
+
+	values := make(map[string]string, len(resp.Secrets))
+	for _, sv := range resp.Secrets {
+		values[sv.Ref] = sv.Value
+	}
+	env := map[string]string{}
+	files := map[string]string{}
+	var creds []egressproxy.Credential
+	for _, r := range caps.Refs {
+		v, ok := values[r.Ref]
+		if !ok {
+			return nil, fmt.Errorf("secrets: ref %q not returned", r.Ref)
+		}
+		if len(v) > maxSecretBytes {
+			return nil, fmt.Errorf("secrets: ref %q exceeds %d bytes", r.Ref, maxSecretBytes)
+		}
+		switch {
+		case r.HTTPAuth != nil:
+			if v == "" || strings.ContainsAny(v, "\r\n\x00") {
+				return nil, fmt.Errorf("secrets: ref %q is empty or contains CR, LF or NUL and cannot be a header", r.Ref)
+			}
+			if r.HTTPAuth.Scheme != "" {
+				v = r.HTTPAuth.Scheme + " " + v
+			}
+			host, port, _ := parseHTTPAuthHost(r.HTTPAuth.Host) // validated above
+			creds = append(creds, egressproxy.Credential{Host: host, Port: port, Header: "Authorization", Value: v})
+		case r.Env != "":
+			if strings.IndexByte(v, 0) >= 0 {
+				return nil, fmt.Errorf("secrets: ref %q contains NUL and cannot be an env var", r.Ref)
+			}
+			env[r.Env] = v
+		default:
+			files[r.File] = v
+		}
+	}
+
+	var intercept *egressproxy.Intercept
+	if len(creds) > 0 {
+		var err error
+		if intercept, err = egressproxy.NewIntercept(creds); err != nil {
+			return nil, fmt.Errorf("secrets: %w", err)
+		}
+		files[sandbox.EgressCAFile] = string(intercept.CABundlePEM())
+	}
+	secrets, err := sandbox.NewSecrets(s.cfg.Secrets.TmpfsDir, directiveID, env, files)
+	if err != nil {
+		return nil, err
+	}
+	secrets.Intercept = intercept
+	return secrets, nil
+}
+
+// secretsSummary describes secret delivery for effective_capabilities_summary
+// (names only, never values).
+func secretsSummary(caps *protocol.SecretsCapabilityV1, secrets *sandbox.Secrets) map[string]any {
+	refs := make([]string, 0, len(caps.Refs))
+	for _, r := range caps.Refs {
+		refs = append(refs, r.Ref)
+	}
+	summary := map[string]any{
+		"refs":  refs,
+		"env":   secrets.EnvNames(),
+		"files": secrets.FileNames(),
+	}
+	var hosts []string
+	for _, r := range caps.Refs {
+		if r.HTTPAuth != nil {
+			host, port, _ := parseHTTPAuthHost(r.HTTPAuth.Host)
+			hosts = append(hosts, net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+	}
+	if len(hosts) > 0 {
+		summary["http_auth"] = hosts
+	}
+	return summary
+}
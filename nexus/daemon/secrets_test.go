@@ -0,0 +1,304 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"cybros.ai/nexus/client"
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+// secretsTestDriver is a no-op driver that optionally delivers secrets and
+// injects credentials.
+type secretsTestDriver struct{ delivers, injects bool }
+
+func (d secretsTestDriver) Name() string { return "test" }
+func (d secretsTestDriver) Run(context.Context, sandbox.RunRequest) (sandbox.RunResult, error) {
+	return sandbox.RunResult{}, errors.New("not implemented")
+}
+func (d secretsTestDriver) HealthCheck(context.Context) sandbox.HealthResult {
+	return sandbox.HealthResult{Healthy: true}
+}
+func (d secretsTestDriver) DeliversSecrets() bool    { return d.delivers }
+func (d secretsTestDriver) InjectsCredentials() bool { return d.injects }
+
+func newSecretsService(t *testing.T, values map[string]string) (*Service, *int) {
+	t.Helper()
+	if runtime.GOOS == "linux" {
+		if ok, _ := isTmpfs("/dev/shm"); !ok {
+			t.Skip("/dev/shm is not tmpfs")
+		}
+	}
+	hits := new(int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		var req protocol.SecretsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var resp protocol.SecretsResponse
+		for _, ref := range req.Refs {
+			if v, ok := values[ref]; ok {
+				resp.Secrets = append(resp.Secrets, protocol.SecretValue{Ref: ref, Value: v})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := config.Default()
+	cfg.ServerURL = srv.URL
+	cli, err := client.New(cfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+	return &Service{cfg: cfg, cli: cli}, hits
+}
+
+func isTmpfs(path string) (bool, error) {
+	s, err := sandbox.NewSecrets(path, "probe", map[string]string{"A": "b"}, nil)
+	if err != nil {
+		return false, err
+	}
+	return true, s.Close()
+}
+
+func TestValidateSecretRefs(t *testing.T) {
+	t.Parallel()
+
+	ok := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{
+		{Ref: "github_token", Env: "GITHUB_TOKEN"},
+		{Ref: "deploy_key", File: "id_ed25519"},
+		{Ref: "github_token", File: "gh-token"},
+		{Ref: "github_token", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com", Scheme: "Bearer"}},
+		{Ref: "registry", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "registry.example.com:5000"}},
+	}}
+	if err := validateSecretRefs(ok); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bad := map[string]protocol.SecretRefV1{
+		"missing ref":   {Env: "X"},
+		"env and file":  {Ref: "a", Env: "X", File: "x"},
+		"neither":       {Ref: "a"},
+		"bad env":       {Ref: "a", Env: "1X"},
+		"reserved env":  {Ref: "a", Env: "LD_PRELOAD"},
+		"cybros prefix": {Ref: "a", Env: "CYBROS_DIRECTIVE_ID"},
+		"file slash":    {Ref: "a", File: "../etc/passwd"},
+		"file dot":      {Ref: "a", File: ".hidden"},
+		"env and auth":  {Ref: "a", Env: "X", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com"}},
+		"auth wildcard": {Ref: "a", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "*.github.com"}},
+		"auth ip":       {Ref: "a", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "140.82.112.3"}},
+		"auth port":     {Ref: "a", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com:0"}},
+		"auth scheme":   {Ref: "a", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com", Scheme: "Bearer x"}},
+	}
+	for name, ref := range bad {
+		caps := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{ref}}
+		if err := validateSecretRefs(caps); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	dup := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{
+		{Ref: "a", Env: "TOKEN"}, {Ref: "b", Env: "TOKEN"},
+	}}
+	if err := validateSecretRefs(dup); err == nil {
+		t.Error("expected error for duplicate env name")
+	}
+
+	dupHost := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{
+		{Ref: "a", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com"}},
+		{Ref: "b", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "API.github.com:443"}},
+	}}
+	if err := validateSecretRefs(dupHost); err == nil {
+		t.Error("expected error for duplicate http_auth host")
+	}
+
+	reserved := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{
+		{Ref: "a", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com"}},
+		{Ref: "b", Env: "SSL_CERT_FILE"},
+	}}
+	if err := validateSecretRefs(reserved); err == nil {
+		t.Error("expected error for a trust variable next to http_auth")
+	}
+}
+
+func TestCheckHTTPAuthAllowed(t *testing.T) {
+	t.Parallel()
+
+	caps := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{
+		{Ref: "a", Env: "TOKEN"},
+		{Ref: "b", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com"}},
+	}}
+	allow := &protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"api.github.com:443"}}
+	if err := checkHTTPAuthAllowed(caps, allow); err != nil {
+		t.Errorf("allowed host: %v", err)
+	}
+	if err := checkHTTPAuthAllowed(caps, &protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"github.com:443"}}); err == nil {
+		t.Error("expected error for a host outside the allowlist")
+	}
+	if err := checkHTTPAuthAllowed(caps, nil); err == nil {
+		t.Error("expected error with net mode none")
+	}
+	if err := checkHTTPAuthAllowed(&protocol.SecretsCapabilityV1{Refs: caps.Refs[:1]}, nil); err != nil {
+		t.Errorf("no http_auth: %v", err)
+	}
+
+	ruled := *allow
+	ruled.HTTPRules = &protocol.NetHTTPRulesV1{
+		Deny: []string{"DELETE https://api.github.com/**", "* http://api.github.com/**"},
+	}
+	if err := checkHTTPAuthAllowed(caps, &ruled); err != nil {
+		t.Errorf("rules on an http_auth host: %v", err)
+	}
+	if err := checkHTTPAuthAllowed(nil, &ruled); err == nil {
+		t.Error("expected error for https rules without http_auth")
+	}
+	ruled.HTTPRules = &protocol.NetHTTPRulesV1{Deny: []string{"* http://api.github.com/**"}}
+	if err := checkHTTPAuthAllowed(nil, &ruled); err != nil {
+		t.Errorf("plain HTTP rules: %v", err)
+	}
+}
+
+func TestLoadSecrets_NoneRequested(t *testing.T) {
+	t.Parallel()
+
+	s := &Service{cfg: config.Default()}
+	secrets, err := s.loadSecrets(context.Background(), "d-1", newTokenHolder("tok"), secretsTestDriver{}, nil)
+	if err != nil || secrets != nil {
+		t.Fatalf("expected no secrets, got %v, %v", secrets, err)
+	}
+}
+
+func TestLoadSecrets_EnvAndFiles(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newSecretsService(t, map[string]string{"github_token": "ghp_abc123", "deploy_key": "KEY\n"})
+	s.cfg.Secrets.TmpfsDir = "/dev/shm"
+	caps := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{
+		{Ref: "github_token", Env: "GITHUB_TOKEN"},
+		{Ref: "deploy_key", File: "id_ed25519"},
+	}}
+
+	secrets, err := s.loadSecrets(context.Background(), "d-1", newTokenHolder("tok"), secretsTestDriver{delivers: true}, caps)
+	if runtime.GOOS != "linux" {
+		if !errors.Is(err, sandbox.ErrSecretsTmpfsUnsupported) {
+			t.Fatalf("expected tmpfs error off Linux, got %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("loadSecrets: %v", err)
+	}
+	defer secrets.Close()
+
+	if secrets.Env["GITHUB_TOKEN"] != "ghp_abc123" {
+		t.Errorf("unexpected env: %v", secrets.EnvNames())
+	}
+	b, err := os.ReadFile(filepath.Join(secrets.FilesDir(), "id_ed25519"))
+	if err != nil || string(b) != "KEY\n" {
+		t.Fatalf("read file secret: %q, %v", b, err)
+	}
+
+	summary := secretsSummary(caps, secrets)
+	raw, _ := json.Marshal(summary)
+	if string(raw) != `{"env":["GITHUB_TOKEN"],"files":["id_ed25519"],"refs":["github_token","deploy_key"]}` {
+		t.Errorf("unexpected summary: %s", raw)
+	}
+
+	dir := secrets.Dir
+	if err := secrets.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected secrets dir removed, got %v", err)
+	}
+}
+
+func TestLoadSecrets_HTTPAuth(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("the egress CA is delivered on tmpfs")
+	}
+	s, _ := newSecretsService(t, map[string]string{"github_token": "ghp_abc123"})
+	s.cfg.Secrets.TmpfsDir = "/dev/shm"
+	caps := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{
+		{Ref: "github_token", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com", Scheme: "Bearer"}},
+	}}
+
+	secrets, err := s.loadSecrets(context.Background(), "d-1", newTokenHolder("tok"), secretsTestDriver{delivers: true, injects: true}, caps)
+	if err != nil {
+		t.Fatalf("loadSecrets: %v", err)
+	}
+	defer secrets.Close()
+
+	if len(secrets.Env) != 0 || secrets.EgressIntercept() == nil {
+		t.Fatalf("env = %v, intercept = %v", secrets.EnvNames(), secrets.EgressIntercept())
+	}
+	b, err := os.ReadFile(filepath.Join(secrets.FilesDir(), sandbox.EgressCAFile))
+	if err != nil || !strings.HasPrefix(string(b), "-----BEGIN CERTIFICATE-----") || strings.Contains(string(b), "ghp_abc123") {
+		t.Fatalf("CA file = %.40q, %v", b, err)
+	}
+	if v := secrets.Values(); len(v) != 1 || v[0] != "Bearer ghp_abc123" {
+		t.Errorf("redaction values = %q", v)
+	}
+	if got := secrets.TrustEnv()["SSL_CERT_FILE"]; got != "/run/secrets/"+sandbox.EgressCAFile {
+		t.Errorf("SSL_CERT_FILE = %q", got)
+	}
+
+	raw, _ := json.Marshal(secretsSummary(caps, secrets))
+	if string(raw) != `{"env":[],"files":["cybros-egress-ca.pem"],"http_auth":["api.github.com:443"],"refs":["github_token"]}` {
+		t.Errorf("unexpected summary: %s", raw)
+	}
+}
+
+func TestLoadSecrets_DriverCannotInject(t *testing.T) {
+	t.Parallel()
+
+	s, hits := newSecretsService(t, map[string]string{"a": "value"})
+	caps := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{
+		{Ref: "a", HTTPAuth: &protocol.SecretHTTPAuthV1{Host: "api.github.com"}},
+	}}
+	if _, err := s.loadSecrets(context.Background(), "d-1", newTokenHolder("tok"), secretsTestDriver{delivers: true}, caps); err == nil {
+		t.Fatal("expected error for driver without credential injection")
+	}
+	if *hits != 0 {
+		t.Errorf("secrets must not be fetched for a driver that cannot inject them")
+	}
+}
+
+func TestLoadSecrets_MissingRef(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newSecretsService(t, map[string]string{})
+	s.cfg.Secrets.TmpfsDir = "/dev/shm"
+	caps := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{{Ref: "nope", Env: "TOKEN"}}}
+	if _, err := s.loadSecrets(context.Background(), "d-1", newTokenHolder("tok"), secretsTestDriver{delivers: true}, caps); err == nil {
+		t.Fatal("expected error for unresolved ref")
+	}
+}
+
+func TestLoadSecrets_DriverCannotDeliver(t *testing.T) {
+	t.Parallel()
+
+	s, hits := newSecretsService(t, map[string]string{"a": "value"})
+	caps := &protocol.SecretsCapabilityV1{Refs: []protocol.SecretRefV1{{Ref: "a", Env: "TOKEN"}}}
+	if _, err := s.loadSecrets(context.Background(), "d-1", newTokenHolder("tok"), secretsTestDriver{}, caps); err == nil {
+		t.Fatal("expected error for driver without secret delivery")
+	}
+	if *hits != 0 {
+		t.Errorf("secrets must not be fetched for a driver that cannot deliver them")
+	}
+}
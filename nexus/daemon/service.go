@@ -5,12 +5,16 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"cybros.ai/nexus/client"
 	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/egressproxy"
+	"cybros.ai/nexus/helper"
+	"cybros.ai/nexus/logstream"
 	"cybros.ai/nexus/protocol"
 	"cybros.ai/nexus/sandbox"
 	"cybros.ai/nexus/version"
@@ -33,6 +37,23 @@ type Service struct {
 	wal     *finishedWAL
 	cb      *circuitBreaker
 
+	// auditSpool holds egress audit batches that could not be uploaded.
+	auditSpool *auditSpool
+
+	// redactPatterns are the log redaction rules from cfg.LogRedaction.
+	redactPatterns []logstream.Pattern
+
+	// helper is the nexus-helper client (nil unless helper.socket_path is set).
+	helper *helper.Client
+
+	// egressCache is the egress proxies' registry cache (nil unless
+	// egress_cache.enabled).
+	egressCache *egressproxy.Cache
+
+	// sessions holds the sandboxes kept open for DirectiveSpec.Session
+	// (nil when sessions are disabled).
+	sessions *sessionTable
+
 	// runningCount tracks the number of currently executing directives.
 	runningCount atomic.Int32
 }
@@ -60,21 +81,56 @@ func New(cfg config.Config) (*Service, error) {
 	reg.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
 	reg.MustRegister(prometheus.NewGoCollector())
 	metrics := NewMetrics(reg)
+	for _, c := range factory.Collectors() {
+		reg.MustRegister(c)
+	}
 
 	wal, err := newFinishedWAL(cfg.WorkDir)
 	if err != nil {
 		return nil, fmt.Errorf("init finished WAL: %w", err)
 	}
 
+	spool, err := newAuditSpool(cfg.WorkDir)
+	if err != nil {
+		return nil, fmt.Errorf("init audit spool: %w", err)
+	}
+
+	redactPatterns, err := compileRedactionPatterns(cfg.LogRedaction)
+	if err != nil {
+		return nil, err
+	}
+
+	var egressCache *egressproxy.Cache
+	if cfg.EgressCache.Enabled {
+		dir := cfg.EgressCache.Dir
+		if dir == "" {
+			dir = filepath.Join(cfg.WorkDir, ".egress-cache")
+		}
+		egressCache, err = egressproxy.NewCache(dir, egressproxy.CacheOptions{
+			MaxBytes:      cfg.EgressCache.MaxBytes,
+			MaxEntryBytes: cfg.EgressCache.MaxEntryBytes,
+			MaxAge:        cfg.EgressCache.MaxAge,
+			Hosts:         cfg.EgressCache.Hosts,
+		}, metrics.Egress)
+		if err != nil {
+			return nil, fmt.Errorf("init egress cache: %w", err)
+		}
+	}
+
 	return &Service{
-		cfg:     cfg,
-		cli:     cli,
-		factory: factory,
-		tape:    tape,
-		metrics: metrics,
-		reg:     reg,
-		wal:     wal,
-		cb:      newCircuitBreaker(5, 30*time.Second, 5*time.Minute),
+		cfg:            cfg,
+		cli:            cli,
+		factory:        factory,
+		tape:           tape,
+		metrics:        metrics,
+		reg:            reg,
+		wal:            wal,
+		auditSpool:     spool,
+		cb:             newCircuitBreaker(5, 30*time.Second, 5*time.Minute),
+		redactPatterns: redactPatterns,
+		helper:         newHelperClient(cfg.Helper.SocketPath),
+		egressCache:    egressCache,
+		sessions:       newSessionTable(cfg.Sessions.MaxOpen),
 	}, nil
 }
 
@@ -149,6 +205,8 @@ func (s *Service) Serve(ctx context.Context) error {
 	}
 
 	s.replayWAL(ctx)
+	s.replayAuditSpool(ctx)
+	s.sweepNetwork(ctx)
 
 	go s.runTerritoryHeartbeatLoop(ctx)
 
@@ -165,6 +223,7 @@ func (s *Service) Serve(ctx context.Context) error {
 	var wg sync.WaitGroup
 
 	shutdown := func() error {
+		defer s.sessions.closeAll()
 		inFlight := s.runningCount.Load()
 		slog.Info("shutting down", "in_flight", inFlight, "timeout", s.cfg.ShutdownTimeout)
 
@@ -209,6 +268,7 @@ func (s *Service) Serve(ctx context.Context) error {
 		resp, err := s.cli.Poll(ctx, protocol.PollRequest{
 			SupportedSandboxProfiles: s.factory.SupportedProfiles(),
 			MaxDirectivesToClaim:     s.cfg.Poll.MaxDirectivesToClaim,
+			Sessions:                 s.sessions.ids(),
 		})
 		if err != nil {
 			s.cb.RecordFailure()
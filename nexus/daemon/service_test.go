@@ -160,3 +160,13 @@ func TestBuildDirectiveEnv(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildDirectiveEnv_Interactive(t *testing.T) {
+	t.Parallel()
+
+	spec := protocol.DirectiveSpec{Interactive: &protocol.InteractiveSpec{}}
+	env := buildDirectiveEnv(config.Config{}, "d1", spec)
+	if env["TERM"] != "xterm-256color" {
+		t.Errorf("TERM = %q, want xterm-256color", env["TERM"])
+	}
+}
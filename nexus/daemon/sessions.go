@@ -0,0 +1,269 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+const (
+	// defaultSessionIdleTimeout applies when a session names no idle timeout.
+	defaultSessionIdleTimeout = 10 * time.Minute
+	// maxSessionIDLen bounds session IDs (same charset as facility IDs).
+	maxSessionIDLen = 128
+)
+
+// validateSession checks a directive's session spec.
+func validateSession(spec *protocol.SessionSpec) error {
+	if spec == nil {
+		return nil
+	}
+	if !isValidFacilityID(spec.ID) || len(spec.ID) > maxSessionIDLen {
+		return fmt.Errorf("session id %q is invalid", spec.ID)
+	}
+	if spec.IdleTimeoutSeconds < 0 {
+		return errors.New("idle_timeout_seconds must not be negative")
+	}
+	return nil
+}
+
+// sessionIdleTimeout is how long the session may stay idle after this
+// directive, capped by sessions.max_idle_timeout.
+func sessionIdleTimeout(spec *protocol.SessionSpec, maxIdle time.Duration) time.Duration {
+	d := defaultSessionIdleTimeout
+	if spec.IdleTimeoutSeconds > 0 {
+		d = time.Duration(spec.IdleTimeoutSeconds) * time.Second
+	}
+	return min(d, maxIdle)
+}
+
+// sessionKey renders what a session's sandbox is set up with. A directive
+// can only join a session whose key it shares: profile, facility, tenant,
+// limits and (preset-expanded) capabilities.
+func sessionKey(spec protocol.DirectiveSpec, profile string) string {
+	b, _ := json.Marshal(struct {
+		Profile      string                `json:"profile"`
+		Facility     protocol.FacilitySpec `json:"facility"`
+		Tenant       string                `json:"tenant"`
+		Limits       protocol.Limits       `json:"limits"`
+		Capabilities protocol.Capabilities `json:"capabilities"`
+	}{profile, spec.Facility, spec.Tenant, spec.Limits, spec.Capabilities})
+	return string(b)
+}
+
+// auditSwitch is the audit writer a session's egress proxy is opened with.
+// It forwards to the audit uploader of the directive running in the
+// session; events between directives are dropped.
+type auditSwitch struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (a *auditSwitch) set(w io.Writer) {
+	a.mu.Lock()
+	a.w = w
+	a.mu.Unlock()
+}
+
+func (a *auditSwitch) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.w == nil {
+		return len(p), nil
+	}
+	return a.w.Write(p)
+}
+
+// sessionEntry is an open (or opening) session. It is held by one
+// directive at a time; the holder opens the session if it is not open yet.
+type sessionEntry struct {
+	id    string
+	key   string
+	audit *auditSwitch
+
+	// Guarded by sessionTable.mu.
+	busy bool
+	gen  int // bumped on acquire, invalidating a pending idle timer
+
+	mu      sync.Mutex
+	session sandbox.Session
+	secrets *sandbox.Secrets // the opener's, closed with the session
+	closed  bool
+}
+
+// current returns the open session, or nil before the first directive
+// opened it.
+func (e *sessionEntry) current() (sandbox.Session, *sandbox.Secrets) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.session, e.secrets
+}
+
+// setSession records the opened session, which then owns secrets. It
+// reports false if the entry was closed meanwhile (shutdown).
+func (e *sessionEntry) setSession(sn sandbox.Session, secrets *sandbox.Secrets) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return false
+	}
+	e.session, e.secrets = sn, secrets
+	return true
+}
+
+func (e *sessionEntry) close() {
+	e.mu.Lock()
+	sn, secrets := e.session, e.secrets
+	e.session, e.secrets, e.closed = nil, nil, true
+	e.mu.Unlock()
+	if sn != nil {
+		_ = sn.Close()
+		slog.Info("session closed", "session_id", e.id)
+	}
+	_ = secrets.Close()
+}
+
+// sessionTable holds the territory's sessions. A nil table has sessions
+// disabled.
+type sessionTable struct {
+	maxOpen int
+
+	mu       sync.Mutex
+	sessions map[string]*sessionEntry
+}
+
+func newSessionTable(maxOpen int) *sessionTable {
+	return &sessionTable{maxOpen: maxOpen, sessions: map[string]*sessionEntry{}}
+}
+
+// acquire hands the session id to a directive with the given key, adding
+// it (not yet opened) if unknown. A non-empty reason rejects the directive.
+func (t *sessionTable) acquire(id, key string) (*sessionEntry, string) {
+	if t == nil || t.maxOpen <= 0 {
+		return nil, "sessions disabled"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.sessions[id]; ok {
+		if e.key != key {
+			return nil, "session mismatch"
+		}
+		if e.busy {
+			return nil, "session busy"
+		}
+		e.busy = true
+		e.gen++
+		return e, ""
+	}
+	if len(t.sessions) >= t.maxOpen {
+		return nil, "too many sessions"
+	}
+	e := &sessionEntry{id: id, key: key, audit: &auditSwitch{}, busy: true}
+	t.sessions[id] = e
+	return e, ""
+}
+
+// release gives the session back after a directive. It stays open for
+// idle unless closeIt is set or it was never opened.
+func (t *sessionTable) release(e *sessionEntry, idle time.Duration, closeIt bool) {
+	t.mu.Lock()
+	e.busy = false
+	sn, _ := e.current()
+	if closeIt || sn == nil || t.sessions[e.id] != e {
+		if t.sessions[e.id] == e {
+			delete(t.sessions, e.id)
+		}
+		t.mu.Unlock()
+		e.close()
+		return
+	}
+	gen := e.gen
+	time.AfterFunc(idle, func() { t.expire(e, gen) })
+	t.mu.Unlock()
+}
+
+// expire closes e if it stayed idle since the release that armed gen.
+func (t *sessionTable) expire(e *sessionEntry, gen int) {
+	t.mu.Lock()
+	if t.sessions[e.id] != e || e.busy || e.gen != gen {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.sessions, e.id)
+	t.mu.Unlock()
+	slog.Info("session idle timeout", "session_id", e.id)
+	e.close()
+}
+
+// ids returns the IDs of the sessions in the table, sorted.
+func (t *sessionTable) ids() []string {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := make([]string, 0, len(t.sessions))
+	for id := range t.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// closeAll closes every session, including ones a directive still runs in
+// (used at shutdown, once in-flight directives finished or timed out).
+func (t *sessionTable) closeAll() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	entries := make([]*sessionEntry, 0, len(t.sessions))
+	for id, e := range t.sessions {
+		entries = append(entries, e)
+		delete(t.sessions, id)
+	}
+	t.mu.Unlock()
+	for _, e := range entries {
+		e.close()
+	}
+}
+
+// runInSession runs req in e's session, opening it first (with secrets,
+// which the session then owns) if this directive is the first. It reports
+// whether it opened the session.
+func (s *Service) runInSession(ctx context.Context, drv sandbox.Driver, e *sessionEntry, req sandbox.RunRequest, secrets *sandbox.Secrets) (sandbox.RunResult, bool, error) {
+	e.audit.set(req.Audit)
+	defer e.audit.set(nil)
+
+	sn, _ := e.current()
+	opened := false
+	if sn == nil {
+		sr, ok := drv.(sandbox.SessionRunner)
+		if !ok {
+			return sandbox.RunResult{}, false, errors.New("sessions unsupported by driver")
+		}
+		open := req
+		open.Audit = e.audit
+		var err error
+		if sn, err = sr.OpenSession(ctx, open); err != nil {
+			return sandbox.RunResult{}, false, fmt.Errorf("open session: %w", err)
+		}
+		if !e.setSession(sn, secrets) {
+			_ = sn.Close()
+			return sandbox.RunResult{}, false, errors.New("open session: nexusd is shutting down")
+		}
+		opened = true
+		slog.Info("session opened", "session_id", e.id, "directive_id", req.DirectiveID)
+	}
+	res, err := sn.Exec(ctx, req)
+	return res, opened, err
+}
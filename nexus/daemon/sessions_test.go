@@ -0,0 +1,247 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+// fakeSession counts Execs and Closes; Exec writes a line to the opener's
+// audit writer to show where egress events go.
+type fakeSession struct {
+	audit  io.Writer
+	execs  atomic.Int32
+	closes atomic.Int32
+}
+
+func (f *fakeSession) Exec(ctx context.Context, req sandbox.RunRequest) (sandbox.RunResult, error) {
+	f.execs.Add(1)
+	_, _ = f.audit.Write([]byte(req.Command + "\n"))
+	return sandbox.RunResult{Status: "succeeded"}, nil
+}
+
+func (f *fakeSession) Close() error {
+	f.closes.Add(1)
+	return nil
+}
+
+type fakeSessionDriver struct {
+	opens   atomic.Int32
+	session *fakeSession
+	openErr error
+}
+
+func (d *fakeSessionDriver) Name() string { return "fake" }
+
+func (d *fakeSessionDriver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunResult, error) {
+	return sandbox.RunResult{}, errors.New("Run called for a session")
+}
+
+func (d *fakeSessionDriver) HealthCheck(ctx context.Context) sandbox.HealthResult {
+	return sandbox.HealthResult{Healthy: true}
+}
+
+func (d *fakeSessionDriver) OpenSession(ctx context.Context, req sandbox.RunRequest) (sandbox.Session, error) {
+	d.opens.Add(1)
+	if d.openErr != nil {
+		return nil, d.openErr
+	}
+	d.session = &fakeSession{audit: req.Audit}
+	return d.session, nil
+}
+
+func TestValidateSession(t *testing.T) {
+	t.Parallel()
+
+	if err := validateSession(nil); err != nil {
+		t.Fatalf("nil spec: %v", err)
+	}
+	if err := validateSession(&protocol.SessionSpec{ID: "s-1", IdleTimeoutSeconds: 60}); err != nil {
+		t.Fatalf("valid spec: %v", err)
+	}
+	for _, spec := range []protocol.SessionSpec{
+		{ID: ""},
+		{ID: "../x"},
+		{ID: string(bytes.Repeat([]byte("a"), maxSessionIDLen+1))},
+		{ID: "s-1", IdleTimeoutSeconds: -1},
+	} {
+		if err := validateSession(&spec); err == nil {
+			t.Errorf("expected error for %+v", spec)
+		}
+	}
+}
+
+func TestSessionIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	if got := sessionIdleTimeout(&protocol.SessionSpec{ID: "s"}, time.Hour); got != defaultSessionIdleTimeout {
+		t.Errorf("default = %v", got)
+	}
+	if got := sessionIdleTimeout(&protocol.SessionSpec{ID: "s", IdleTimeoutSeconds: 30}, time.Hour); got != 30*time.Second {
+		t.Errorf("explicit = %v", got)
+	}
+	if got := sessionIdleTimeout(&protocol.SessionSpec{ID: "s", IdleTimeoutSeconds: 7200}, time.Hour); got != time.Hour {
+		t.Errorf("capped = %v", got)
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	t.Parallel()
+
+	base := protocol.DirectiveSpec{Command: "a", Facility: protocol.FacilitySpec{ID: "f-1"}}
+	other := base
+	other.Command = "b"
+	other.Cwd = "sub"
+	if sessionKey(base, "host") != sessionKey(other, "host") {
+		t.Error("per-command fields must not change the key")
+	}
+	other = base
+	other.Tenant = "t-2"
+	if sessionKey(base, "host") == sessionKey(other, "host") {
+		t.Error("tenant must change the key")
+	}
+	if sessionKey(base, "host") == sessionKey(base, "untrusted") {
+		t.Error("profile must change the key")
+	}
+}
+
+func TestSessionTable_Acquire(t *testing.T) {
+	t.Parallel()
+
+	if _, reason := (*sessionTable)(nil).acquire("s", "k"); reason != "sessions disabled" {
+		t.Fatalf("nil table: reason = %q", reason)
+	}
+
+	tbl := newSessionTable(1)
+	e, reason := tbl.acquire("s", "k")
+	if reason != "" {
+		t.Fatalf("acquire: %q", reason)
+	}
+	if _, reason := tbl.acquire("s", "k"); reason != "session busy" {
+		t.Errorf("second holder: reason = %q", reason)
+	}
+	if _, reason := tbl.acquire("s", "other"); reason != "session mismatch" {
+		t.Errorf("other key: reason = %q", reason)
+	}
+	if _, reason := tbl.acquire("t", "k"); reason != "too many sessions" {
+		t.Errorf("over max_open: reason = %q", reason)
+	}
+	if got := tbl.ids(); len(got) != 1 || got[0] != "s" {
+		t.Errorf("ids = %v", got)
+	}
+
+	// Released without having been opened: dropped.
+	tbl.release(e, time.Hour, false)
+	if got := tbl.ids(); len(got) != 0 {
+		t.Errorf("ids after releasing an unopened session = %v", got)
+	}
+}
+
+func TestSessionTable_IdleAndClose(t *testing.T) {
+	t.Parallel()
+
+	tbl := newSessionTable(2)
+	open := func(id string) (*sessionEntry, *fakeSession) {
+		e, reason := tbl.acquire(id, "k")
+		if reason != "" {
+			t.Fatalf("acquire %s: %q", id, reason)
+		}
+		sn := &fakeSession{}
+		e.setSession(sn, nil)
+		return e, sn
+	}
+
+	e, idle := open("idle")
+	tbl.release(e, 20*time.Millisecond, false)
+	deadline := time.Now().Add(5 * time.Second)
+	for idle.closes.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if idle.closes.Load() != 1 || len(tbl.ids()) != 0 {
+		t.Fatalf("idle session not closed: closes=%d ids=%v", idle.closes.Load(), tbl.ids())
+	}
+
+	// Reacquiring before the idle timer fires keeps the session.
+	e, kept := open("kept")
+	tbl.release(e, 50*time.Millisecond, false)
+	if _, reason := tbl.acquire("kept", "k"); reason != "" {
+		t.Fatalf("reacquire: %q", reason)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if kept.closes.Load() != 0 {
+		t.Fatal("session closed while held")
+	}
+	tbl.release(e, time.Hour, true)
+	if kept.closes.Load() != 1 || len(tbl.ids()) != 0 {
+		t.Fatalf("close flag: closes=%d ids=%v", kept.closes.Load(), tbl.ids())
+	}
+
+	e, last := open("last")
+	tbl.release(e, time.Hour, false)
+	tbl.closeAll()
+	if last.closes.Load() != 1 || len(tbl.ids()) != 0 {
+		t.Fatalf("closeAll: closes=%d ids=%v", last.closes.Load(), tbl.ids())
+	}
+	if e.setSession(&fakeSession{}, nil) {
+		t.Error("setSession succeeded on a closed entry")
+	}
+}
+
+func TestRunInSession(t *testing.T) {
+	t.Parallel()
+
+	s := &Service{sessions: newSessionTable(1)}
+	drv := &fakeSessionDriver{}
+	run := func(command string) (bool, string) {
+		e, reason := s.sessions.acquire("s", "k")
+		if reason != "" {
+			t.Fatalf("acquire: %q", reason)
+		}
+		defer s.sessions.release(e, time.Hour, false)
+		var audit bytes.Buffer
+		res, opened, err := s.runInSession(context.Background(), drv, e, sandbox.RunRequest{Command: command, Audit: &audit}, nil)
+		if err != nil || res.Status != "succeeded" {
+			t.Fatalf("run %s: %+v, %v", command, res, err)
+		}
+		return opened, audit.String()
+	}
+
+	if opened, audit := run("first"); !opened || audit != "first\n" {
+		t.Fatalf("first: opened=%v audit=%q", opened, audit)
+	}
+	// The follow-up reuses the sandbox; its egress audit reaches its own
+	// uploader, not the opener's.
+	if opened, audit := run("second"); opened || audit != "second\n" {
+		t.Fatalf("second: opened=%v audit=%q", opened, audit)
+	}
+	if drv.opens.Load() != 1 || drv.session.execs.Load() != 2 {
+		t.Fatalf("opens=%d execs=%d", drv.opens.Load(), drv.session.execs.Load())
+	}
+
+	s.sessions.closeAll()
+	if drv.session.closes.Load() != 1 {
+		t.Fatalf("closes = %d", drv.session.closes.Load())
+	}
+}
+
+func TestRunInSession_OpenError(t *testing.T) {
+	t.Parallel()
+
+	s := &Service{sessions: newSessionTable(1)}
+	drv := &fakeSessionDriver{openErr: errors.New("no sandbox")}
+	e, _ := s.sessions.acquire("s", "k")
+	if _, opened, err := s.runInSession(context.Background(), drv, e, sandbox.RunRequest{Command: "x"}, nil); err == nil || opened {
+		t.Fatalf("opened=%v err=%v", opened, err)
+	}
+	s.sessions.release(e, time.Hour, false)
+	if got := s.sessions.ids(); len(got) != 0 {
+		t.Fatalf("failed session kept: %v", got)
+	}
+}
@@ -5,19 +5,13 @@ import (
 	"io"
 	"sync"
 	"time"
+
+	"cybros.ai/nexus/protocol"
 )
 
-// AuditEvent records a single egress connection decision.
-type AuditEvent struct {
-	Timestamp   string `json:"ts"`
-	DirectiveID string `json:"directive_id"`
-	DestHost    string `json:"dest_host"`
-	DestPort    int    `json:"dest_port"`
-	ResolvedIP  string `json:"resolved_ip,omitempty"`
-	Decision    string `json:"decision"` // "allow" or "deny"
-	ReasonCode  string `json:"reason_code"`
-	Method      string `json:"method,omitempty"` // "CONNECT" or "HTTP"
-}
+// AuditEvent records a single egress connection decision. It is the event
+// shape uploaded to Mothership via the audit_events endpoint.
+type AuditEvent = protocol.EgressAuditEvent
 
 // AuditLogger writes audit events as JSONL to a writer.
 // It is safe for concurrent use.
@@ -0,0 +1,568 @@
+package egressproxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores registry responses for the egress proxies of a territory
+// (design 03 §7.10). Only requests the proxy parses (proxied plain HTTP
+// and intercepted TLS) to the configured hosts are considered: GETs
+// without a body, range, cookie or sandbox-supplied authorization, whose
+// 200 response is publicly cacheable with a max-age. The body is stored
+// while it streams to the sandbox and served from disk until it expires.
+//
+// Entries are keyed by URL, the Accept and Accept-Encoding headers and the
+// injected credential, within the partition of the directive's tenant:
+// directives of different tenants never see each other's entries. Bodies
+// are stored once per partition, named by their SHA-256. The total body
+// size is bounded by evicting the least recently used entries.
+//
+// Layout under the cache directory:
+//
+//	<partition>/entries/<id>.json  metadata (id: SHA-256 of the key)
+//	<partition>/blobs/<sha256>     bodies
+//	<partition>/tmp/               bodies being stored
+type Cache struct {
+	dir     string
+	opts    CacheOptions
+	hosts   map[string]bool
+	metrics *Metrics
+	now     func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List               // of *cacheEntry, most recently used first
+	entries map[string]*list.Element // by entry file
+	blobs   map[string]*cacheBlob    // by blob file
+	size    int64
+}
+
+// CacheOptions bounds a Cache.
+type CacheOptions struct {
+	MaxBytes      int64         // total body size; must be positive
+	MaxEntryBytes int64         // one body (0: MaxBytes)
+	MaxAge        time.Duration // caps an entry's lifetime (0: no cap)
+	Hosts         []string      // exact host names whose responses are cached
+}
+
+type cacheEntry struct {
+	path string // entry file
+	blob string // blob file
+	meta cacheMeta
+}
+
+type cacheBlob struct {
+	refs int
+	size int64
+}
+
+// cacheMeta is the content of an entry file. The key itself is not
+// stored, as it covers the injected credential.
+type cacheMeta struct {
+	URL     string      `json:"url"`
+	Header  http.Header `json:"header"`
+	Blob    string      `json:"blob"` // hex SHA-256 of the body
+	Size    int64       `json:"size"`
+	Stored  time.Time   `json:"stored"` // when the origin produced the response
+	Expires time.Time   `json:"expires"`
+}
+
+var (
+	partitionRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	blobRe      = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// cachedHeaders are the response headers kept with an entry.
+var cachedHeaders = []string{
+	"Cache-Control", "Content-Disposition", "Content-Encoding", "Content-Type",
+	"Etag", "Last-Modified", "Vary",
+}
+
+// NewCache opens the cache in dir, creating it if needed. Entries left by
+// a previous run are kept; partial bodies, expired entries and orphaned
+// blobs are removed. metrics may be nil.
+func NewCache(dir string, opts CacheOptions, metrics *Metrics) (*Cache, error) {
+	if opts.MaxBytes <= 0 {
+		return nil, errors.New("egress cache: max bytes must be positive")
+	}
+	if opts.MaxEntryBytes <= 0 || opts.MaxEntryBytes > opts.MaxBytes {
+		opts.MaxEntryBytes = opts.MaxBytes
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("egress cache: %w", err)
+	}
+	c := &Cache{
+		dir:     dir,
+		opts:    opts,
+		hosts:   map[string]bool{},
+		metrics: metrics,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		blobs:   map[string]*cacheBlob{},
+	}
+	for _, h := range opts.Hosts {
+		c.hosts[normalizeHost(h)] = true
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load indexes the entries on disk in order of last use (the entry file's
+// mtime) and cleans up everything else.
+func (c *Cache) load() error {
+	parts, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("egress cache: %w", err)
+	}
+	type loaded struct {
+		e    *cacheEntry
+		used time.Time
+	}
+	var found []loaded
+	var partDirs []string
+	now := c.now()
+	for _, part := range parts {
+		if !part.IsDir() || !partitionRe.MatchString(part.Name()) {
+			continue
+		}
+		pdir := filepath.Join(c.dir, part.Name())
+		partDirs = append(partDirs, pdir)
+		_ = os.RemoveAll(filepath.Join(pdir, "tmp"))
+		files, _ := os.ReadDir(filepath.Join(pdir, "entries"))
+		for _, f := range files {
+			path := filepath.Join(pdir, "entries", f.Name())
+			e, err := readCacheEntry(pdir, path)
+			info, statErr := f.Info()
+			if err != nil || statErr != nil || !now.Before(e.meta.Expires) {
+				_ = os.Remove(path)
+				continue
+			}
+			if bi, err := os.Stat(e.blob); err != nil || bi.Size() != e.meta.Size {
+				_ = os.Remove(path)
+				continue
+			}
+			found = append(found, loaded{e, info.ModTime()})
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].used.Before(found[j].used) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range found {
+		c.insert(l.e)
+	}
+	for _, pdir := range partDirs {
+		blobs, _ := os.ReadDir(filepath.Join(pdir, "blobs"))
+		for _, b := range blobs {
+			path := filepath.Join(pdir, "blobs", b.Name())
+			if c.blobs[path] == nil {
+				_ = os.Remove(path)
+			}
+		}
+	}
+	c.evict()
+	return nil
+}
+
+func readCacheEntry(pdir, path string) (*cacheEntry, error) {
+	id, ok := strings.CutSuffix(filepath.Base(path), ".json")
+	if !ok || !blobRe.MatchString(id) {
+		return nil, errors.New("not an entry file")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var meta cacheMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	if !blobRe.MatchString(meta.Blob) || meta.Size < 0 {
+		return nil, errors.New("invalid entry")
+	}
+	return &cacheEntry{path: path, blob: filepath.Join(pdir, "blobs", meta.Blob), meta: meta}, nil
+}
+
+// insert indexes e as the most recently used entry, replacing the entry
+// in the same file. c.mu must be held.
+func (c *Cache) insert(e *cacheEntry) {
+	if el, ok := c.entries[e.path]; ok {
+		c.remove(el)
+	}
+	if b := c.blobs[e.blob]; b != nil {
+		b.refs++
+	} else {
+		c.blobs[e.blob] = &cacheBlob{refs: 1, size: e.meta.Size}
+		c.size += e.meta.Size
+	}
+	c.entries[e.path] = c.lru.PushFront(e)
+}
+
+// remove drops an entry, and its blob once unreferenced. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.path)
+	_ = os.Remove(e.path)
+	b := c.blobs[e.blob]
+	if b.refs--; b.refs == 0 {
+		delete(c.blobs, e.blob)
+		_ = os.Remove(e.blob)
+		c.size -= b.size
+	}
+}
+
+// evict removes least recently used entries until the cache fits its
+// bound. c.mu must be held.
+func (c *Cache) evict() {
+	for c.size > c.opts.MaxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+		c.metrics.cacheEvicted()
+	}
+	c.metrics.cacheSize(c.size)
+}
+
+// open returns the fresh entry in path as a response, marking it used.
+func (c *Cache) open(path string) *http.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[path]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	now := c.now()
+	if !now.Before(e.meta.Expires) {
+		c.remove(el)
+		c.metrics.cacheSize(c.size)
+		return nil
+	}
+	f, err := os.Open(e.blob)
+	if err != nil {
+		c.remove(el)
+		c.metrics.cacheSize(c.size)
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	_ = os.Chtimes(path, now, now)
+
+	header := e.meta.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Age", strconv.FormatInt(int64(now.Sub(e.meta.Stored)/time.Second), 10))
+	header.Set("Content-Length", strconv.FormatInt(e.meta.Size, 10))
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          f,
+		ContentLength: e.meta.Size,
+	}
+}
+
+// storable returns the metadata to store resp under, if it may be cached.
+func (c *Cache) storable(resp *http.Response) (cacheMeta, bool) {
+	h := resp.Header
+	if resp.StatusCode != http.StatusOK || h.Get("Set-Cookie") != "" || h.Get("Content-Range") != "" ||
+		resp.ContentLength > c.opts.MaxEntryBytes {
+		return cacheMeta{}, false
+	}
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			switch http.CanonicalHeaderKey(strings.TrimSpace(name)) {
+			case "", "Accept", "Accept-Encoding":
+			default:
+				return cacheMeta{}, false // the key does not cover it
+			}
+		}
+	}
+	cc := parseCacheControl(h)
+	if cc.has("no-store") || cc.has("no-cache") || cc.has("private") {
+		return cacheMeta{}, false
+	}
+	maxAge, ok := cc.seconds("s-maxage")
+	if !ok {
+		maxAge, ok = cc.seconds("max-age")
+	}
+	age, _ := strconv.ParseInt(h.Get("Age"), 10, 64)
+	age = max(age, 0)
+	if !ok || maxAge <= age {
+		return cacheMeta{}, false
+	}
+
+	now := c.now()
+	stored := now.Add(-time.Duration(age) * time.Second)
+	expires := stored.Add(time.Duration(maxAge) * time.Second)
+	if c.opts.MaxAge > 0 && expires.After(now.Add(c.opts.MaxAge)) {
+		expires = now.Add(c.opts.MaxAge)
+	}
+	header := http.Header{}
+	for _, k := range cachedHeaders {
+		if vv := h.Values(k); len(vv) > 0 {
+			header[k] = slices.Clone(vv)
+		}
+	}
+	return cacheMeta{Header: header, Stored: stored, Expires: expires}, true
+}
+
+// commit moves a fully read body from tmp into the cache as the entry in
+// path, evicting as needed.
+func (c *Cache) commit(pdir, path, tmp string, meta cacheMeta) {
+	e := &cacheEntry{path: path, blob: filepath.Join(pdir, "blobs", meta.Blob), meta: meta}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[path]; ok {
+		c.remove(el)
+	}
+	if c.blobs[e.blob] == nil {
+		if err := os.MkdirAll(filepath.Dir(e.blob), 0o700); err != nil {
+			slog.Warn("egress cache: store failed", "url", meta.URL, "error", err)
+			_ = os.Remove(tmp)
+			return
+		}
+		if err := os.Rename(tmp, e.blob); err != nil {
+			slog.Warn("egress cache: store failed", "url", meta.URL, "error", err)
+			_ = os.Remove(tmp)
+			return
+		}
+	} else {
+		_ = os.Remove(tmp)
+	}
+	if err := writeEntryFile(path, data); err != nil {
+		slog.Warn("egress cache: store failed", "url", meta.URL, "error", err)
+		if c.blobs[e.blob] == nil {
+			_ = os.Remove(e.blob)
+		}
+		return
+	}
+	c.insert(e)
+	c.evict()
+}
+
+func writeEntryFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// CachePartition is the part of a Cache one tenant's directives share.
+type CachePartition struct {
+	cache *Cache
+	dir   string
+}
+
+// Partition returns the partition of tenant (the empty tenant has one of
+// its own). Safe on nil: a nil Cache has no partitions.
+func (c *Cache) Partition(tenant string) *CachePartition {
+	if c == nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(tenant))
+	return &CachePartition{cache: c, dir: filepath.Join(c.dir, hex.EncodeToString(sum[:16]))}
+}
+
+// cacheRequest is a request the cache may answer, or store the response
+// to. Its methods are safe on nil, which stands for a request the cache
+// ignores.
+type cacheRequest struct {
+	p    *CachePartition
+	url  string
+	path string // entry file
+}
+
+// request returns req to scheme://host:port, as received from the sandbox
+// (before cred is injected; the zero Credential for none), as a
+// cacheRequest, or nil when the cache ignores it. Safe on nil.
+func (p *CachePartition) request(req *http.Request, scheme, host string, port int, cred Credential) *cacheRequest {
+	if p == nil || req.Method != http.MethodGet || !p.cache.hosts[normalizeHost(host)] {
+		return nil
+	}
+	h := req.Header
+	if req.ContentLength != 0 || h.Get("Range") != "" || h.Get("Cookie") != "" ||
+		(cred.Value == "" && h.Get("Authorization") != "") {
+		return nil
+	}
+	if cc := parseCacheControl(h); cc.has("no-store") || cc.has("no-cache") ||
+		strings.EqualFold(h.Get("Pragma"), "no-cache") {
+		return nil
+	}
+
+	url := scheme + "://" + net.JoinHostPort(normalizeHost(host), strconv.Itoa(port)) + req.URL.RequestURI()
+	key := sha256.New()
+	fmt.Fprintf(key, "%s\n%s\n%s\n", url, h.Get("Accept"), h.Get("Accept-Encoding"))
+	if cred.Value != "" {
+		fmt.Fprintf(key, "%s: %s\n", cred.Header, cred.Value)
+	}
+	id := hex.EncodeToString(key.Sum(nil))
+	return &cacheRequest{p: p, url: url, path: filepath.Join(p.dir, "entries", id+".json")}
+}
+
+// lookup returns the cached response, if any, and the result to audit:
+// "hit", "miss", or "" for an ignored request.
+func (r *cacheRequest) lookup() (*http.Response, string) {
+	if r == nil {
+		return nil, ""
+	}
+	resp := r.p.cache.open(r.path)
+	if resp == nil {
+		r.p.cache.metrics.cacheResult("miss")
+		return nil, "miss"
+	}
+	r.p.cache.metrics.cacheResult("hit")
+	return resp, "hit"
+}
+
+// store has resp's body stored as it is read, if resp may be cached.
+func (r *cacheRequest) store(resp *http.Response) {
+	if r == nil {
+		return
+	}
+	c := r.p.cache
+	meta, ok := c.storable(resp)
+	if !ok {
+		return
+	}
+	meta.URL = r.url
+	tmpDir := filepath.Join(r.p.dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0o700); err != nil {
+		slog.Warn("egress cache: store failed", "url", r.url, "error", err)
+		return
+	}
+	tmp, err := os.CreateTemp(tmpDir, "body-*")
+	if err != nil {
+		slog.Warn("egress cache: store failed", "url", r.url, "error", err)
+		return
+	}
+	resp.Body = &cacheFill{
+		body: resp.Body,
+		tmp:  tmp,
+		hash: sha256.New(),
+		want: resp.ContentLength,
+		max:  c.opts.MaxEntryBytes,
+		commit: func(sum string, n int64) {
+			meta.Blob, meta.Size = sum, n
+			c.commit(r.p.dir, r.path, tmp.Name(), meta)
+		},
+	}
+}
+
+// cacheFill copies a response body into a temporary file as it is read.
+// The copy is committed once the body has been read to its end intact;
+// one closed early, failing, or over the entry bound is discarded.
+type cacheFill struct {
+	body   io.ReadCloser
+	tmp    *os.File
+	hash   hash.Hash
+	n      int64
+	want   int64 // Content-Length, -1 if unknown
+	max    int64
+	failed bool
+	done   bool
+	commit func(sum string, n int64)
+}
+
+func (f *cacheFill) Read(b []byte) (int, error) {
+	n, err := f.body.Read(b)
+	if n > 0 && !f.failed {
+		f.n += int64(n)
+		if f.n > f.max {
+			f.failed = true
+		} else if _, werr := f.tmp.Write(b[:n]); werr != nil {
+			f.failed = true
+		} else {
+			f.hash.Write(b[:n])
+		}
+	}
+	if err != nil {
+		f.finish(err == io.EOF)
+	}
+	return n, err
+}
+
+func (f *cacheFill) Close() error {
+	f.finish(false)
+	return f.body.Close()
+}
+
+func (f *cacheFill) finish(complete bool) {
+	if f.done {
+		return
+	}
+	f.done = true
+	err := f.tmp.Close()
+	if err != nil || !complete || f.failed || (f.want >= 0 && f.n != f.want) {
+		_ = os.Remove(f.tmp.Name())
+		return
+	}
+	f.commit(hex.EncodeToString(f.hash.Sum(nil)), f.n)
+}
+
+// cacheControl holds the directives of Cache-Control headers by lower-case
+// name.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, val, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+func (cc cacheControl) seconds(name string) (int64, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
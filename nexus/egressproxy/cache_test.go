@@ -0,0 +1,349 @@
+package egressproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+)
+
+func newTestCache(t *testing.T, dir string, opts CacheOptions) *Cache {
+	t.Helper()
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = 1 << 20
+	}
+	if opts.Hosts == nil {
+		opts.Hosts = []string{"registry.npmjs.org", "example.com"}
+	}
+	c, err := NewCache(dir, opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// registryHandler serves a cacheable body for every path, counting the
+// requests that reach it.
+func registryHandler(hits *atomic.Int32, cacheControl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Cache-Control", cacheControl)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Upstream", "yes")
+		io.WriteString(w, "tarball "+r.URL.Path+" "+r.Header.Get("Authorization"))
+	}
+}
+
+// fillCache stores body as the response to a GET of url, as the proxy
+// would while relaying it.
+func fillCache(t *testing.T, p *CachePartition, url, body string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", url, nil)
+	cr := p.request(req, "http", req.URL.Hostname(), 80, Credential{})
+	if cr == nil {
+		t.Fatalf("request to %s not cacheable", url)
+	}
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Cache-Control": {"max-age=300"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	cr.store(resp)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+}
+
+// cached reports whether p answers a GET of url.
+func cached(p *CachePartition, url string) bool {
+	req := httptest.NewRequest("GET", url, nil)
+	resp, _ := p.request(req, "http", req.URL.Hostname(), 80, Credential{}).lookup()
+	if resp == nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+func TestCache_PlainHTTP(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewServer(registryHandler(&hits, "public, max-age=300"))
+	defer upstream.Close()
+	cache := newTestCache(t, t.TempDir(), CacheOptions{})
+	proxyURL, _, audit, stop := startInterceptTestProxy(t, upstream, &protocol.NetCapabilityV1{
+		Mode:  "allowlist",
+		Allow: []string{"registry.npmjs.org:80"},
+	}, nil, withCache(cache.Partition("acct-1")))
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for i := range 2 {
+		resp, err := client.Get("http://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "tarball /left-pad/-/left-pad-1.3.0.tgz " {
+			t.Fatalf("request %d: %d %q", i, resp.StatusCode, body)
+		}
+		if resp.Header.Get("Content-Type") != "application/octet-stream" {
+			t.Errorf("request %d: Content-Type = %q", i, resp.Header.Get("Content-Type"))
+		}
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("upstream requests = %d, want 1", got)
+	}
+
+	stop()
+	events := auditEvents(t, audit)
+	if len(events) != 2 || events[0].Cache != "miss" || events[1].Cache != "hit" {
+		t.Fatalf("audit = %+v, want a miss then a hit", events)
+	}
+}
+
+func TestCache_Intercepted(t *testing.T) {
+	var hits atomic.Int32
+	upstream := httptest.NewTLSServer(registryHandler(&hits, "max-age=300"))
+	defer upstream.Close()
+	cache := newTestCache(t, t.TempDir(), CacheOptions{})
+	proxyURL, intercept, _, _ := startInterceptTestProxy(t, upstream, &protocol.NetCapabilityV1{
+		Mode:  "allowlist",
+		Allow: []string{"example.com:443"},
+	}, []Credential{testCredential}, withCache(cache.Partition("acct-1")))
+
+	client := sandboxClient(proxyURL, intercept)
+	for i := range 2 {
+		resp, err := client.Get("https://example.com/pkg.tgz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "tarball /pkg.tgz Bearer s3cret" {
+			t.Fatalf("request %d: body = %q", i, body)
+		}
+	}
+	client.CloseIdleConnections()
+	if got := hits.Load(); got != 1 {
+		t.Errorf("upstream requests = %d, want 1", got)
+	}
+}
+
+func TestCache_NotCacheable(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		cacheControl string
+		header       http.Header
+		url          string
+	}{
+		{"no-store", "no-store", nil, "http://registry.npmjs.org/a"},
+		{"private", "private, max-age=300", nil, "http://registry.npmjs.org/a"},
+		{"no max-age", "public", nil, "http://registry.npmjs.org/a"},
+		{"cookie", "max-age=300", http.Header{"Cookie": {"session=1"}}, "http://registry.npmjs.org/a"},
+		{"authorization", "max-age=300", http.Header{"Authorization": {"Basic eDp5"}}, "http://registry.npmjs.org/a"},
+		{"range", "max-age=300", http.Header{"Range": {"bytes=0-1"}}, "http://registry.npmjs.org/a"},
+		{"other host", "max-age=300", nil, "http://example.org/a"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var hits atomic.Int32
+			upstream := httptest.NewServer(registryHandler(&hits, tc.cacheControl))
+			defer upstream.Close()
+			cache := newTestCache(t, t.TempDir(), CacheOptions{})
+			proxyURL, _, _, _ := startInterceptTestProxy(t, upstream, &protocol.NetCapabilityV1{
+				Mode:  "allowlist",
+				Allow: []string{"registry.npmjs.org:80", "example.org:80"},
+			}, nil, withCache(cache.Partition("acct-1")))
+
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+			for range 2 {
+				req, _ := http.NewRequest("GET", tc.url, nil)
+				for k, v := range tc.header {
+					req.Header[k] = v
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if got := hits.Load(); got != 2 {
+				t.Errorf("upstream requests = %d, want 2", got)
+			}
+		})
+	}
+}
+
+func TestCache_Partitions(t *testing.T) {
+	cache := newTestCache(t, t.TempDir(), CacheOptions{})
+	a, b := cache.Partition("acct-1"), cache.Partition("acct-2")
+	fillCache(t, a, "http://registry.npmjs.org/a", "aaaa")
+
+	if !cached(a, "http://registry.npmjs.org/a") {
+		t.Error("entry missing from its own partition")
+	}
+	if cached(b, "http://registry.npmjs.org/a") {
+		t.Error("entry visible from another tenant's partition")
+	}
+	if cached(cache.Partition(""), "http://registry.npmjs.org/a") {
+		t.Error("entry visible from the empty tenant's partition")
+	}
+	if (*Cache)(nil).Partition("acct-1") != nil {
+		t.Error("nil cache returned a partition")
+	}
+}
+
+func TestCache_Credential(t *testing.T) {
+	cache := newTestCache(t, t.TempDir(), CacheOptions{})
+	p := cache.Partition("acct-1")
+	lookup := func(cred Credential) bool {
+		req := httptest.NewRequest("GET", "https://example.com/pkg.tgz", nil)
+		resp, _ := p.request(req, "https", "example.com", 443, cred).lookup()
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return resp != nil
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/pkg.tgz", nil)
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Cache-Control": {"max-age=300"}},
+		Body:          io.NopCloser(strings.NewReader("body")),
+		ContentLength: 4,
+	}
+	cr := p.request(req, "https", "example.com", 443, testCredential)
+	cr.store(resp)
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if !lookup(testCredential) {
+		t.Error("miss with the same credential")
+	}
+	other := testCredential
+	other.Value = "Bearer other"
+	if lookup(other) || lookup(Credential{}) {
+		t.Error("hit with a different credential")
+	}
+}
+
+func TestCache_PartialBodyDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	cache := newTestCache(t, dir, CacheOptions{MaxEntryBytes: 8})
+	p := cache.Partition("acct-1")
+
+	store := func(url, body string, read int) {
+		req := httptest.NewRequest("GET", url, nil)
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Cache-Control": {"max-age=300"}},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: int64(len(body)),
+		}
+		p.request(req, "http", "registry.npmjs.org", 80, Credential{}).store(resp)
+		io.ReadFull(resp.Body, make([]byte, read))
+		resp.Body.Close()
+	}
+	store("http://registry.npmjs.org/closed", "0123456", 3)
+	store("http://registry.npmjs.org/large", "0123456789", 10)
+
+	for _, url := range []string{"http://registry.npmjs.org/closed", "http://registry.npmjs.org/large"} {
+		if cached(p, url) {
+			t.Errorf("%s: cached", url)
+		}
+	}
+	if tmp, _ := os.ReadDir(filepath.Join(p.dir, "tmp")); len(tmp) != 0 {
+		t.Errorf("temporary files left: %d", len(tmp))
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTestCache(t, t.TempDir(), CacheOptions{MaxBytes: 10})
+	p := cache.Partition("acct-1")
+	fillCache(t, p, "http://registry.npmjs.org/a", "aaaa")
+	fillCache(t, p, "http://registry.npmjs.org/b", "bbbb")
+	cached(p, "http://registry.npmjs.org/a") // a is now more recent than b
+	fillCache(t, p, "http://registry.npmjs.org/c", "cccc")
+
+	if !cached(p, "http://registry.npmjs.org/a") || !cached(p, "http://registry.npmjs.org/c") {
+		t.Error("recently used entries evicted")
+	}
+	if cached(p, "http://registry.npmjs.org/b") {
+		t.Error("least recently used entry kept")
+	}
+	if cache.size != 8 {
+		t.Errorf("size = %d, want 8", cache.size)
+	}
+}
+
+func TestCache_SharedBlob(t *testing.T) {
+	cache := newTestCache(t, t.TempDir(), CacheOptions{})
+	p := cache.Partition("acct-1")
+	fillCache(t, p, "http://registry.npmjs.org/a", "same")
+	fillCache(t, p, "http://registry.npmjs.org/b", "same")
+
+	if cache.size != 4 {
+		t.Errorf("size = %d, want 4 (one blob)", cache.size)
+	}
+	blobs, _ := os.ReadDir(filepath.Join(p.dir, "blobs"))
+	if len(blobs) != 1 {
+		t.Errorf("blobs = %d, want 1", len(blobs))
+	}
+}
+
+func TestCache_Expiry(t *testing.T) {
+	cache := newTestCache(t, t.TempDir(), CacheOptions{MaxAge: time.Minute})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	p := cache.Partition("acct-1")
+	fillCache(t, p, "http://registry.npmjs.org/a", "aaaa") // max-age=300, capped at 1m
+
+	now = now.Add(59 * time.Second)
+	if !cached(p, "http://registry.npmjs.org/a") {
+		t.Fatal("fresh entry missing")
+	}
+	now = now.Add(time.Second)
+	if cached(p, "http://registry.npmjs.org/a") {
+		t.Fatal("entry served past max_age")
+	}
+	if cache.size != 0 {
+		t.Errorf("size = %d after expiry, want 0", cache.size)
+	}
+}
+
+func TestCache_Reload(t *testing.T) {
+	dir := t.TempDir()
+	cache := newTestCache(t, dir, CacheOptions{})
+	p := cache.Partition("acct-1")
+	fillCache(t, p, "http://registry.npmjs.org/a", "aaaa")
+	os.MkdirAll(filepath.Join(p.dir, "tmp"), 0o700)
+	os.WriteFile(filepath.Join(p.dir, "tmp", "body-1"), []byte("partial"), 0o600)
+	os.WriteFile(filepath.Join(p.dir, "blobs", strings.Repeat("0", 64)), []byte("orphan"), 0o600)
+
+	reopened := newTestCache(t, dir, CacheOptions{})
+	if !cached(reopened.Partition("acct-1"), "http://registry.npmjs.org/a") {
+		t.Error("entry lost on reopen")
+	}
+	if reopened.size != 4 {
+		t.Errorf("size = %d, want 4", reopened.size)
+	}
+	if _, err := os.Stat(filepath.Join(p.dir, "tmp")); !os.IsNotExist(err) {
+		t.Error("partial bodies kept on reopen")
+	}
+	if _, err := os.Stat(filepath.Join(p.dir, "blobs", strings.Repeat("0", 64))); !os.IsNotExist(err) {
+		t.Error("orphaned blob kept on reopen")
+	}
+
+	// Reopening with a smaller bound evicts.
+	small := newTestCache(t, dir, CacheOptions{MaxBytes: 2})
+	if small.size != 0 || cached(small.Partition("acct-1"), "http://registry.npmjs.org/a") {
+		t.Error("entry over the bound kept on reopen")
+	}
+}
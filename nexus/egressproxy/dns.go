@@ -0,0 +1,273 @@
+package egressproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNS record types and response codes used by the embedded resolver.
+const (
+	DNSTypeA    uint16 = 1
+	DNSTypeAAAA uint16 = 28
+
+	DNSRCodeSuccess  = 0
+	DNSRCodeFormErr  = 1
+	DNSRCodeServFail = 2
+	DNSRCodeNXDomain = 3
+	DNSRCodeNotImpl  = 4
+	DNSRCodeRefused  = 5
+)
+
+const (
+	// maxDNSInflight bounds concurrently handled queries per server.
+	maxDNSInflight = 64
+	// dnsSocketTimeout bounds one query on a socket connection.
+	dnsSocketTimeout = 10 * time.Second
+)
+
+// DNSQuestion is a parsed query. Name is normalized (lower-case, no
+// trailing dot).
+type DNSQuestion struct {
+	Name string
+	Type uint16
+}
+
+// DNSAnswer is a handler's reply. Addrs of the wrong family for the query
+// type are left out of the response.
+type DNSAnswer struct {
+	RCode int
+	Addrs []netip.Addr
+	TTL   uint32
+}
+
+// DNSHandler answers one question.
+type DNSHandler func(ctx context.Context, q DNSQuestion) DNSAnswer
+
+// DNSServer is a minimal DNS server for sandboxes: it answers single
+// A/AAAA questions through a DNSHandler and nothing else. It never recurses
+// on its own. It serves either UDP or a Unix socket (StartDNSSocket).
+type DNSServer struct {
+	conn     net.PacketConn
+	listener net.Listener
+	handler  DNSHandler
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// StartDNSServer listens on the UDP address addr (port 0 picks a free port)
+// and serves queries until Close.
+func StartDNSServer(addr string, handler DNSHandler) (*DNSServer, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &DNSServer{conn: conn, handler: handler, cancel: cancel}
+	s.wg.Add(1)
+	go s.serve(ctx)
+	return s, nil
+}
+
+// StartDNSSocket serves DNS on a Unix stream socket, for sandboxes that
+// reach the host only through bridged sockets (socat UDP4-RECVFROM in bwrap,
+// vsock in Firecracker). Each connection carries one raw query message,
+// without the TCP length prefix, and receives one response.
+func StartDNSSocket(socketPath string, handler DNSHandler) (*DNSServer, error) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &DNSServer{listener: listener, handler: handler, cancel: cancel}
+	s.wg.Add(1)
+	go s.serveSocket(ctx)
+	return s, nil
+}
+
+// Addr returns the listening address.
+func (s *DNSServer) Addr() net.Addr {
+	if s.listener != nil {
+		return s.listener.Addr()
+	}
+	return s.conn.LocalAddr()
+}
+
+// Close stops the server and waits for in-flight queries.
+func (s *DNSServer) Close() error {
+	s.cancel()
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	} else {
+		err = s.conn.Close()
+	}
+	s.wg.Wait()
+	return err
+}
+
+func (s *DNSServer) serve(ctx context.Context) {
+	defer s.wg.Done()
+	sem := make(chan struct{}, maxDNSInflight)
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		query := append([]byte(nil), buf[:n]...)
+		select {
+		case sem <- struct{}{}:
+		default:
+			continue // overloaded: drop, the client retries
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-sem }()
+			if resp := s.respond(ctx, query); resp != nil {
+				_, _ = s.conn.WriteTo(resp, from)
+			}
+		}()
+	}
+}
+
+func (s *DNSServer) serveSocket(ctx context.Context) {
+	defer s.wg.Done()
+	sem := make(chan struct{}, maxDNSInflight)
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			conn.Close() // overloaded: the client retries
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() { <-sem }()
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(dnsSocketTimeout))
+			buf := make([]byte, 1500)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if resp := s.respond(ctx, buf[:n]); resp != nil {
+				_, _ = conn.Write(resp)
+			}
+		}()
+	}
+}
+
+func (s *DNSServer) respond(ctx context.Context, query []byte) []byte {
+	q, qlen, rcode, ok := parseDNSQuery(query)
+	if !ok {
+		return nil
+	}
+	if rcode != DNSRCodeSuccess {
+		return buildDNSResponse(query, qlen, q, DNSAnswer{RCode: rcode})
+	}
+	return buildDNSResponse(query, qlen, q, s.handler(ctx, q))
+}
+
+// parseDNSQuery parses a query with exactly one question. It returns the
+// question, the length of header+question, and a non-zero rcode for
+// queries the server refuses to answer. ok is false for packets that get no
+// reply at all (truncated headers, responses).
+func parseDNSQuery(b []byte) (q DNSQuestion, qlen, rcode int, ok bool) {
+	if len(b) < 12 {
+		return q, 0, 0, false
+	}
+	flags := binary.BigEndian.Uint16(b[2:4])
+	if flags&0x8000 != 0 {
+		return q, 0, 0, false // a response
+	}
+	if opcode := (flags >> 11) & 0xf; opcode != 0 {
+		return q, 12, DNSRCodeNotImpl, true
+	}
+	if binary.BigEndian.Uint16(b[4:6]) != 1 {
+		return q, 12, DNSRCodeFormErr, true
+	}
+
+	var labels []string
+	off := 12
+	for {
+		if off >= len(b) {
+			return q, 12, DNSRCodeFormErr, true
+		}
+		l := int(b[off])
+		off++
+		if l == 0 {
+			break
+		}
+		if l > 63 || off+l > len(b) {
+			return q, 12, DNSRCodeFormErr, true // includes compression pointers
+		}
+		labels = append(labels, string(b[off:off+l]))
+		off += l
+	}
+	if off+4 > len(b) {
+		return q, 12, DNSRCodeFormErr, true
+	}
+	q.Name = strings.ToLower(strings.Join(labels, "."))
+	q.Type = binary.BigEndian.Uint16(b[off : off+2])
+	if class := binary.BigEndian.Uint16(b[off+2 : off+4]); class != 1 {
+		return q, off + 4, DNSRCodeNotImpl, true
+	}
+	return q, off + 4, DNSRCodeSuccess, true
+}
+
+// buildDNSResponse answers query, echoing its header and question (the first
+// qlen bytes). Additional records such as EDNS options are not echoed.
+func buildDNSResponse(query []byte, qlen int, q DNSQuestion, a DNSAnswer) []byte {
+	var answers []netip.Addr
+	if a.RCode == DNSRCodeSuccess {
+		for _, addr := range a.Addrs {
+			if (q.Type == DNSTypeA && addr.Is4()) || (q.Type == DNSTypeAAAA && addr.Is6() && !addr.Is4In6()) {
+				answers = append(answers, addr)
+			}
+		}
+	}
+
+	resp := make([]byte, qlen, qlen+len(answers)*28)
+	copy(resp, query[:qlen])
+	flags := binary.BigEndian.Uint16(query[2:4])
+	flags = 0x8000 | flags&0x7900 | 0x0080 | uint16(a.RCode&0xf) // QR, opcode+RD, RA, rcode
+	binary.BigEndian.PutUint16(resp[2:4], flags)
+	qd := uint16(1)
+	if qlen == 12 {
+		qd = 0
+	}
+	binary.BigEndian.PutUint16(resp[4:6], qd)
+	binary.BigEndian.PutUint16(resp[6:8], uint16(len(answers)))
+	binary.BigEndian.PutUint16(resp[8:10], 0)
+	binary.BigEndian.PutUint16(resp[10:12], 0)
+
+	for _, addr := range answers {
+		raw := addr.AsSlice()
+		rr := make([]byte, 12, 12+len(raw))
+		binary.BigEndian.PutUint16(rr[0:2], 0xc00c) // pointer to the question name
+		binary.BigEndian.PutUint16(rr[2:4], q.Type)
+		binary.BigEndian.PutUint16(rr[4:6], 1) // IN
+		binary.BigEndian.PutUint32(rr[6:10], a.TTL)
+		binary.BigEndian.PutUint16(rr[10:12], uint16(len(raw)))
+		resp = append(resp, append(rr, raw...)...)
+	}
+	return resp
+}
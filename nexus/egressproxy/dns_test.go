@@ -0,0 +1,161 @@
+package egressproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// dnsQuery builds a query for name/qtype with id 0x1234 and RD set.
+func dnsQuery(name string, qtype uint16) []byte {
+	b := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range splitLabels(name) {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	return binary.BigEndian.AppendUint16(b, 1)
+}
+
+func splitLabels(name string) []string {
+	var labels []string
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i == len(name) || name[i] == '.' {
+			labels = append(labels, name[start:i])
+			start = i + 1
+		}
+	}
+	return labels
+}
+
+func TestParseDNSQuery(t *testing.T) {
+	q, qlen, rcode, ok := parseDNSQuery(dnsQuery("GitHub.com", DNSTypeA))
+	if !ok || rcode != DNSRCodeSuccess || q.Name != "github.com" || q.Type != DNSTypeA {
+		t.Fatalf("parse = %+v rcode=%d ok=%v", q, rcode, ok)
+	}
+	if qlen != len(dnsQuery("github.com", DNSTypeA)) {
+		t.Errorf("qlen = %d", qlen)
+	}
+
+	if _, _, _, ok := parseDNSQuery([]byte{1, 2, 3}); ok {
+		t.Error("short packet should get no reply")
+	}
+	resp := dnsQuery("a.example", DNSTypeA)
+	resp[2] |= 0x80
+	if _, _, _, ok := parseDNSQuery(resp); ok {
+		t.Error("responses should get no reply")
+	}
+	ptr := dnsQuery("a.example", DNSTypeA)
+	ptr[12] = 0xc0
+	if _, _, rcode, _ := parseDNSQuery(ptr); rcode != DNSRCodeFormErr {
+		t.Errorf("compressed question: rcode = %d, want FORMERR", rcode)
+	}
+}
+
+func TestBuildDNSResponse(t *testing.T) {
+	query := dnsQuery("example.com", DNSTypeA)
+	q, qlen, _, _ := parseDNSQuery(query)
+	resp := buildDNSResponse(query, qlen, q, DNSAnswer{
+		Addrs: []netip.Addr{netip.MustParseAddr("93.184.216.34"), netip.MustParseAddr("2001:db8::1")},
+		TTL:   60,
+	})
+
+	if binary.BigEndian.Uint16(resp[0:2]) != 0x1234 {
+		t.Error("id not echoed")
+	}
+	flags := binary.BigEndian.Uint16(resp[2:4])
+	if flags&0x8000 == 0 || flags&0x0100 == 0 || flags&0xf != 0 {
+		t.Errorf("flags = %#04x", flags)
+	}
+	if an := binary.BigEndian.Uint16(resp[6:8]); an != 1 {
+		t.Fatalf("ancount = %d, want 1 (AAAA left out of an A answer)", an)
+	}
+	rr := resp[qlen:]
+	if binary.BigEndian.Uint32(rr[6:10]) != 60 || binary.BigEndian.Uint16(rr[10:12]) != 4 {
+		t.Errorf("rr header = %x", rr[:12])
+	}
+	if got := netip.AddrFrom4([4]byte(rr[12:16])); got.String() != "93.184.216.34" {
+		t.Errorf("rdata = %s", got)
+	}
+
+	refused := buildDNSResponse(query, qlen, q, DNSAnswer{RCode: DNSRCodeRefused, Addrs: []netip.Addr{netip.MustParseAddr("1.1.1.1")}})
+	if binary.BigEndian.Uint16(refused[2:4])&0xf != DNSRCodeRefused || binary.BigEndian.Uint16(refused[6:8]) != 0 {
+		t.Errorf("refused response = %x", refused[:12])
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	srv, err := StartDNSServer("127.0.0.1:0", func(_ context.Context, q DNSQuestion) DNSAnswer {
+		if q.Name != "allowed.example" {
+			return DNSAnswer{RCode: DNSRCodeRefused}
+		}
+		return DNSAnswer{Addrs: []netip.Addr{netip.MustParseAddr("192.0.2.10")}, TTL: 30}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	exchange := func(name string) []byte {
+		t.Helper()
+		if _, err := conn.Write(dnsQuery(name, DNSTypeA)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 512)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf[:n]
+	}
+
+	if resp := exchange("allowed.example"); binary.BigEndian.Uint16(resp[6:8]) != 1 {
+		t.Errorf("allowed: ancount = %d", binary.BigEndian.Uint16(resp[6:8]))
+	}
+	if resp := exchange("denied.example"); binary.BigEndian.Uint16(resp[2:4])&0xf != DNSRCodeRefused {
+		t.Errorf("denied: flags = %#04x", binary.BigEndian.Uint16(resp[2:4]))
+	}
+}
+
+func TestDNSSocket(t *testing.T) {
+	path := t.TempDir() + "/dns.sock"
+	srv, err := StartDNSSocket(path, func(_ context.Context, q DNSQuestion) DNSAnswer {
+		return DNSAnswer{Addrs: []netip.Addr{netip.MustParseAddr("192.0.2.10")}, TTL: 30}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	for range 2 { // one query per connection
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write(dnsQuery("allowed.example", DNSTypeA)); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp) < 12 || binary.BigEndian.Uint16(resp[6:8]) != 1 {
+			t.Errorf("response = %x", resp)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package egressproxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credential is an HTTP header the proxy sets on requests to Host:Port on
+// the directive's behalf (design 03 §7.8). Its value never enters the
+// sandbox.
+type Credential struct {
+	Host   string // exact host name
+	Port   int
+	Header string // e.g. "Authorization"
+	Value  string
+}
+
+// interceptCAValidity outlives any directive; the CA key only lives in the
+// proxy's memory.
+const interceptCAValidity = 7 * 24 * time.Hour
+
+// systemCertFiles are the usual locations of the host's root bundle, which
+// the sandbox's CA bundle extends (same list as crypto/x509 on Linux).
+var systemCertFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// Intercept terminates TLS for the destinations it holds credentials for
+// and injects them into every request. It mints leaf certificates from a
+// per-directive CA that only the sandbox trusts, name-constrained to those
+// hosts; all other TLS is tunneled untouched.
+type Intercept struct {
+	creds   map[string]Credential // by host:port
+	ca      *x509.Certificate
+	caKey   *ecdsa.PrivateKey
+	leafKey *ecdsa.PrivateKey
+	caPEM   []byte
+
+	// rootCAs verifies upstream servers (nil: system roots).
+	rootCAs *x509.CertPool
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// NewIntercept creates the CA for creds. Hosts must be exact names (no
+// wildcards or IP literals), each host:port at most once.
+func NewIntercept(creds []Credential) (*Intercept, error) {
+	if len(creds) == 0 {
+		return nil, errors.New("intercept: no credentials")
+	}
+	i := &Intercept{creds: make(map[string]Credential, len(creds)), leaves: map[string]*tls.Certificate{}}
+	var hosts []string
+	for _, c := range creds {
+		c.Host = normalizeHost(c.Host)
+		if c.Host == "" || strings.HasPrefix(c.Host, "*.") || net.ParseIP(c.Host) != nil {
+			return nil, fmt.Errorf("intercept: invalid host %q", c.Host)
+		}
+		if c.Port < 1 || c.Port > 65535 {
+			return nil, fmt.Errorf("intercept: port out of range: %d", c.Port)
+		}
+		if c.Header == "" || c.Value == "" || strings.ContainsAny(c.Value, "\r\n\x00") {
+			return nil, fmt.Errorf("intercept: invalid credential for %s", c.Host)
+		}
+		key := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+		if _, dup := i.creds[key]; dup {
+			return nil, fmt.Errorf("intercept: duplicate credential for %s", key)
+		}
+		i.creds[key] = c
+		hosts = append(hosts, c.Host)
+	}
+
+	var err error
+	if i.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, fmt.Errorf("intercept: %w", err)
+	}
+	if i.leafKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, fmt.Errorf("intercept: %w", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:                randomSerial(),
+		Subject:                     pkix.Name{CommonName: "Cybros egress proxy"},
+		NotBefore:                   now.Add(-time.Minute),
+		NotAfter:                    now.Add(interceptCAValidity),
+		KeyUsage:                    x509.KeyUsageCertSign,
+		BasicConstraintsValid:       true,
+		IsCA:                        true,
+		MaxPathLenZero:              true,
+		PermittedDNSDomains:         hosts,
+		PermittedDNSDomainsCritical: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &i.caKey.PublicKey, i.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("intercept: create CA: %w", err)
+	}
+	if i.ca, err = x509.ParseCertificate(der); err != nil {
+		return nil, fmt.Errorf("intercept: %w", err)
+	}
+	i.caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return i, nil
+}
+
+func randomSerial() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	return n
+}
+
+// CACertPEM returns the CA certificate.
+func (i *Intercept) CACertPEM() []byte { return i.caPEM }
+
+// CABundlePEM returns the CA certificate followed by the host's root
+// bundle, for clients that replace (rather than extend) their trust store.
+func (i *Intercept) CABundlePEM() []byte {
+	bundle := append([]byte(nil), i.caPEM...)
+	for _, f := range systemCertFiles {
+		if b, err := os.ReadFile(f); err == nil {
+			return append(bundle, b...)
+		}
+	}
+	return bundle
+}
+
+// Values returns the credential values, for log redaction.
+func (i *Intercept) Values() []string {
+	if i == nil {
+		return nil
+	}
+	out := make([]string, 0, len(i.creds))
+	for _, c := range i.creds {
+		out = append(out, c.Value)
+	}
+	return out
+}
+
+// credential returns the credential for destHost:destPort. Safe on nil.
+func (i *Intercept) credential(destHost string, destPort int) (Credential, bool) {
+	if i == nil {
+		return Credential{}, false
+	}
+	c, ok := i.creds[net.JoinHostPort(normalizeHost(destHost), strconv.Itoa(destPort))]
+	return c, ok
+}
+
+// leaf returns the (cached) server certificate for host.
+func (i *Intercept) leaf(host string) (*tls.Certificate, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if c, ok := i.leaves[host]; ok {
+		return c, nil
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     i.ca.NotAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, i.ca, &i.leafKey.PublicKey, i.caKey)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Certificate{Certificate: [][]byte{der, i.ca.Raw}, PrivateKey: i.leafKey}
+	i.leaves[host] = c
+	return c, nil
+}
+
+// injectCredential sets cred on an outgoing request, replacing whatever the
+// sandbox sent.
+func injectCredential(h http.Header, cred Credential) {
+	h.Set(cred.Header, cred.Value)
+}
+
+// stripCredential removes cred's header, and any header echoing its value,
+// from a response before it reaches the sandbox.
+func stripCredential(h http.Header, cred Credential) {
+	h.Del(cred.Header)
+	for k, vv := range h {
+		for _, v := range vv {
+			if strings.Contains(v, cred.Value) {
+				h.Del(k)
+				break
+			}
+		}
+	}
+}
+
+// errSNIMismatch fails the client handshake of an intercepted tunnel.
+var errSNIMismatch = errors.New("SNI does not name the tunnel's destination")
+
+// interceptTunnel serves a tunnel to a destination with a credential:
+// the client's TLS is terminated with a leaf certificate for the
+// destination, the proxy opens its own verified TLS connection upstream,
+// and HTTP/1.1 requests are relayed one at a time with the credential
+// injected. Unlike tunnel, only TLS clients are served, and the SNI must
+// name the destination itself. Each request is checked against the HTTP
+// rules; a denied one is logged and answered with 403, ending the tunnel.
+func (p *Proxy) interceptTunnel(clientConn net.Conn, br *bufio.Reader, targetConn *meteredConn, event AuditEvent, cred Credential) {
+	defer clientConn.Close()
+	defer targetConn.Close()
+	event.CredentialInjected = true
+
+	deny := func(reason string) {
+		event.Decision = "deny"
+		event.ReasonCode = reason
+		p.audit.Log(event)
+	}
+
+	sniDenied := false
+	client := tls.Server(&preReadConn{Conn: clientConn, r: br}, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			event.SNI = hello.ServerName
+			if !p.policy.CheckSNI(event.DestHost, event.DestPort, hello.ServerName).Allowed ||
+				(hello.ServerName != "" && normalizeHost(hello.ServerName) != cred.Host) {
+				sniDenied = true
+				return nil, errSNIMismatch
+			}
+			return p.intercept.leaf(cred.Host)
+		},
+	})
+	_ = clientConn.SetDeadline(time.Now().Add(clientHelloTimeout))
+	if err := client.Handshake(); err != nil {
+		if sniDenied {
+			deny("SNI_MISMATCH")
+		} else {
+			deny("OTHER")
+		}
+		return
+	}
+	_ = clientConn.SetDeadline(time.Time{})
+
+	upstream := tls.Client(targetConn, &tls.Config{
+		ServerName: cred.Host,
+		RootCAs:    p.intercept.rootCAs,
+		NextProtos: []string{"http/1.1"},
+	})
+	_ = targetConn.SetDeadline(time.Now().Add(clientHelloTimeout))
+	if err := upstream.Handshake(); err != nil {
+		slog.Warn("egress proxy: upstream TLS handshake failed", "host", cred.Host, "error", err)
+		deny("OTHER")
+		return
+	}
+	_ = targetConn.SetDeadline(time.Time{})
+
+	untrack, ok := p.track(event, func() {
+		targetConn.Close()
+		clientConn.Close()
+	})
+	if !ok {
+		deny("GRANT_EXPIRED")
+		return
+	}
+	defer untrack()
+	event.Decision = "allow"
+	event.ReasonCode = "OK"
+	p.audit.Log(event)
+
+	relayHTTP(client, upstream, cred, func(req *http.Request) *cacheRequest {
+		return p.cache.request(req, "https", event.DestHost, event.DestPort, cred)
+	}, func(req *http.Request) bool {
+		res := p.policy.CheckHTTP(req.Method, "https", event.DestHost, event.DestPort, req.URL.EscapedPath())
+		if !res.Allowed {
+			denied := event
+			denied.Decision = "deny"
+			denied.ReasonCode = res.ReasonCode
+			denied.HTTPRule = res.HTTPRule
+			p.audit.Log(denied)
+		}
+		return res.Allowed
+	})
+	if reason := targetConn.cutBy(); reason != "" {
+		deny(reason)
+	}
+}
+
+// relayHTTP relays HTTP/1.1 exchanges from client to upstream until either
+// side closes, injecting cred into requests and stripping it from
+// responses. A request admit refuses is answered with 403 instead and
+// ends the relay; one the cache (see cached) holds is answered from it. A
+// protocol switch (101) turns the connection into a splice.
+func relayHTTP(client, upstream net.Conn, cred Credential, cached func(*http.Request) *cacheRequest, admit func(*http.Request) bool) {
+	cr := bufio.NewReader(client)
+	ur := bufio.NewReader(upstream)
+	for {
+		req, err := http.ReadRequest(cr)
+		if err != nil {
+			return
+		}
+		if !admit(req) {
+			writeDenied(client, "HTTP_RULE_DENIED")
+			return
+		}
+		c := cached(req)
+		if resp, _ := c.lookup(); resp != nil {
+			resp.Request = req
+			err := resp.Write(client)
+			resp.Body.Close()
+			if err != nil || req.Close {
+				return
+			}
+			continue
+		}
+		injectCredential(req.Header, cred)
+		if _, ok := req.Header["User-Agent"]; !ok {
+			req.Header["User-Agent"] = nil // keep Write from adding Go's
+		}
+		if err := req.Write(upstream); err != nil {
+			return
+		}
+
+		resp, err := http.ReadResponse(ur, req)
+		for err == nil && resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			stripCredential(resp.Header, cred)
+			if err := resp.Write(client); err != nil {
+				return
+			}
+			resp, err = http.ReadResponse(ur, req)
+		}
+		if err != nil {
+			return
+		}
+		stripCredential(resp.Header, cred)
+		c.store(resp)
+		err = resp.Write(client)
+		resp.Body.Close()
+		if err != nil {
+			return
+		}
+
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			done := make(chan struct{}, 2)
+			go func() { io.Copy(client, ur); done <- struct{}{} }()
+			go func() { io.Copy(upstream, cr); done <- struct{}{} }()
+			<-done
+			return
+		}
+		if req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+// writeDenied answers a request inside an intercepted tunnel with 403.
+func writeDenied(w io.Writer, reason string) {
+	body := "egress denied: " + reason + "\n"
+	resp := &http.Response{
+		StatusCode:    http.StatusForbidden,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Close:         true,
+	}
+	_ = resp.Write(w)
+}
+
+// checkIntercept fails unless every destination i injects a credential
+// into is allowed by the policy, and every https origin with HTTP rules is
+// intercepted (otherwise its requests would go by unparsed). Safe on nil.
+func (p *Policy) checkIntercept(i *Intercept) error {
+	for _, r := range slices.Concat(p.httpRules.Allow, p.httpRules.Deny) {
+		if _, ok := i.credential(r.Host, r.Port); r.Scheme == "https" && !ok {
+			return fmt.Errorf("http rule %q needs an http_auth credential for %s:%d", r.String(), r.Host, r.Port)
+		}
+	}
+	if i == nil {
+		return nil
+	}
+	for _, c := range i.creds {
+		if r := p.Check(c.Host, c.Port); !r.Allowed {
+			return fmt.Errorf("credential destination %s:%d is not allowed: %s", c.Host, c.Port, r.ReasonCode)
+		}
+	}
+	return nil
+}
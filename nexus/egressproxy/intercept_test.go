@@ -0,0 +1,374 @@
+package egressproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// echoAuthHandler reports the Authorization header it got in the body and
+// tries to leak it back in response headers.
+var echoAuthHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	w.Header().Set("Authorization", auth)
+	w.Header().Set("X-Echo", "got "+auth)
+	w.Header().Set("X-Other", "kept")
+	fmt.Fprint(w, auth)
+})
+
+// newTestIntercept returns an Intercept injecting creds that trusts
+// upstream's certificate, or nil without creds.
+func newTestIntercept(t *testing.T, upstream *httptest.Server, creds []Credential) *Intercept {
+	t.Helper()
+	if len(creds) == 0 {
+		return nil
+	}
+	intercept, err := NewIntercept(creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream.Certificate() != nil {
+		intercept.rootCAs = x509.NewCertPool()
+		intercept.rootCAs.AddCert(upstream.Certificate())
+	}
+	return intercept
+}
+
+// startInterceptTestProxy starts a TCP proxy for cap whose dials land on
+// upstream and which injects creds; opts are further startTestProxy
+// options. intercept is nil without creds.
+func startInterceptTestProxy(t *testing.T, upstream *httptest.Server, cap *protocol.NetCapabilityV1, creds []Credential, opts ...testProxyOption) (proxyURL *url.URL, intercept *Intercept, audit *bytes.Buffer, stop func()) {
+	t.Helper()
+	intercept = newTestIntercept(t, upstream, creds)
+	opts = append([]testProxyOption{withTCP(), withUpstream(upstream.Listener.Addr().String()), withIntercept(intercept)}, opts...)
+	addr, audit, stop := startTestProxy(t, cap, opts...)
+	return &url.URL{Scheme: "http", Host: addr}, intercept, audit, stop
+}
+
+// sandboxClient is an HTTP client in the sandbox: it trusts only the
+// proxy's CA.
+func sandboxClient(proxyURL *url.URL, intercept *Intercept) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(intercept.CACertPEM())
+	return &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+}
+
+var testCredential = Credential{Host: "example.com", Port: 443, Header: "Authorization", Value: "Bearer s3cret"}
+
+func TestIntercept_CONNECT(t *testing.T) {
+	upstream := httptest.NewTLSServer(echoAuthHandler)
+	defer upstream.Close()
+	proxyURL, intercept, audit, stop := startInterceptTestProxy(t, upstream, &protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"example.com:443"}}, []Credential{testCredential})
+	client := sandboxClient(proxyURL, intercept)
+
+	for i := range 2 { // the second request reuses the tunnel
+		req, _ := http.NewRequest("GET", "https://Example.com/repos", nil)
+		if i == 1 {
+			req.Header.Set("Authorization", "Bearer sandbox-guess")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "Bearer s3cret" {
+			t.Errorf("request %d: upstream saw Authorization %q", i, body)
+		}
+		if resp.Header.Get("Authorization") != "" || resp.Header.Get("X-Echo") != "" || resp.Header.Get("X-Other") != "kept" {
+			t.Errorf("request %d: response headers = %v", i, resp.Header)
+		}
+	}
+	client.CloseIdleConnections()
+
+	stop()
+	events := auditEvents(t, audit)
+	if len(events) != 1 {
+		t.Fatalf("audit = %s", auditReasons(t, audit))
+	}
+	if e := events[0]; e.Decision != "allow" || e.Method != "CONNECT" || !e.CredentialInjected || e.SNI != "Example.com" {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestIntercept_SNIMismatch(t *testing.T) {
+	upstream := httptest.NewTLSServer(echoAuthHandler)
+	defer upstream.Close()
+	proxyURL, intercept, audit, stop := startInterceptTestProxy(t, upstream,
+		&protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"example.com:443", "api.example.com:443"}}, []Credential{testCredential})
+
+	conn, err := net.Dial("tcp", proxyURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	br := bufio.NewReader(conn)
+	if line, _ := br.ReadString('\n'); !strings.Contains(line, "200") {
+		t.Fatalf("CONNECT response = %q", line)
+	}
+	br.ReadString('\n')
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(intercept.CACertPEM())
+	// Even an allowed name must not ride on the credential's tunnel.
+	if err := tls.Client(conn, &tls.Config{ServerName: "api.example.com", RootCAs: roots}).Handshake(); err == nil {
+		t.Fatal("handshake succeeded")
+	}
+	conn.Close()
+
+	stop()
+	if got := auditReasons(t, audit); got != "deny:SNI_MISMATCH" {
+		t.Errorf("audit = %s", got)
+	}
+}
+
+func TestIntercept_OtherHostsTunneled(t *testing.T) {
+	upstream := httptest.NewTLSServer(echoAuthHandler)
+	defer upstream.Close()
+	proxyURL, _, audit, stop := startInterceptTestProxy(t, upstream,
+		&protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"example.com:443", "github.com:443"}}, []Credential{testCredential})
+
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	req, _ := http.NewRequest("GET", "https://github.com/", nil)
+	req.Header.Set("Authorization", "token mine")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "token mine" {
+		t.Errorf("upstream saw Authorization %q", body)
+	}
+	if !resp.TLS.PeerCertificates[0].Equal(upstream.Certificate()) {
+		t.Error("tunnel to a host without credential was intercepted")
+	}
+	client.CloseIdleConnections()
+
+	stop()
+	if e := auditEvents(t, audit)[0]; e.CredentialInjected || e.Decision != "allow" {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestIntercept_PlainHTTP(t *testing.T) {
+	upstream := httptest.NewServer(echoAuthHandler)
+	defer upstream.Close()
+	cred := testCredential
+	cred.Port = 80
+	proxyURL, _, audit, stop := startInterceptTestProxy(t, upstream, &protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"example.com:80"}}, []Credential{cred})
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "Bearer s3cret" || resp.Header.Get("Authorization") != "" || resp.Header.Get("X-Echo") != "" {
+		t.Errorf("body = %q, headers = %v", body, resp.Header)
+	}
+
+	stop()
+	if e := auditEvents(t, audit)[0]; !e.CredentialInjected || e.Method != "HTTP" {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestNewIntercept(t *testing.T) {
+	i, err := NewIntercept([]Credential{testCredential, {Host: "Registry.Example.com.", Port: 8443, Header: "Authorization", Value: "Basic eA=="}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(i.ca.PermittedDNSDomains, ","); got != "example.com,registry.example.com" {
+		t.Errorf("name constraints = %s", got)
+	}
+	if _, ok := i.credential("REGISTRY.example.com", 8443); !ok {
+		t.Error("credential not found by normalized host")
+	}
+	if _, ok := i.credential("registry.example.com", 443); ok {
+		t.Error("credential matched another port")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(i.CACertPEM())
+	leaf, err := i.leaf("registry.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(leaf.Certificate[0])
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "registry.example.com", Roots: roots}); err != nil {
+		t.Errorf("leaf does not verify: %v", err)
+	}
+	if !bytes.HasPrefix(i.CABundlePEM(), i.CACertPEM()) {
+		t.Error("bundle does not start with the CA")
+	}
+	if v := i.Values(); len(v) != 2 {
+		t.Errorf("values = %v", v)
+	}
+
+	bad := map[string][]Credential{
+		"none":      nil,
+		"wildcard":  {{Host: "*.example.com", Port: 443, Header: "Authorization", Value: "x"}},
+		"ip":        {{Host: "140.82.112.3", Port: 443, Header: "Authorization", Value: "x"}},
+		"port":      {{Host: "example.com", Header: "Authorization", Value: "x"}},
+		"empty":     {{Host: "example.com", Port: 443, Header: "Authorization"}},
+		"newline":   {{Host: "example.com", Port: 443, Header: "Authorization", Value: "x\r\nX-Evil: 1"}},
+		"duplicate": {testCredential, testCredential},
+	}
+	for name, creds := range bad {
+		if _, err := NewIntercept(creds); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPolicy_CheckIntercept(t *testing.T) {
+	i, err := NewIntercept([]Credential{testCredential})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := NewPolicy(&protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"example.com:443"}})
+	if err := p.checkIntercept(i); err != nil {
+		t.Errorf("allowed host: %v", err)
+	}
+	p, _ = NewPolicy(&protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"example.com:80"}})
+	if err := p.checkIntercept(i); err == nil {
+		t.Error("expected error for a host the policy does not allow")
+	}
+
+	rules := &protocol.NetHTTPRulesV1{Allow: []string{"GET https://example.com/**"}}
+	p, _ = NewPolicy(&protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"example.com:443"}, HTTPRules: rules})
+	if err := p.checkIntercept(i); err != nil {
+		t.Errorf("rules on an intercepted origin: %v", err)
+	}
+	if err := p.checkIntercept(nil); err == nil {
+		t.Error("expected error for https rules without interception")
+	}
+}
+
+func TestHTTPRules_PlainHTTP(t *testing.T) {
+	upstream := httptest.NewServer(echoAuthHandler)
+	defer upstream.Close()
+	proxyURL, _, audit, stop := startInterceptTestProxy(t, upstream, &protocol.NetCapabilityV1{
+		Mode:  "allowlist",
+		Allow: []string{"example.com:80"},
+		HTTPRules: &protocol.NetHTTPRulesV1{
+			Allow: []string{"GET http://example.com/pkg/**"},
+			Deny:  []string{"* http://example.com/pkg/private/**"},
+		},
+	}, nil)
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	for _, tc := range []struct {
+		method, url string
+		status      int
+	}{
+		{"GET", "http://example.com/pkg/left-pad", http.StatusOK},
+		{"POST", "http://example.com/pkg/left-pad", http.StatusForbidden},
+		{"GET", "http://example.com/pkg/%70rivate/key", http.StatusForbidden},
+	} {
+		req, _ := http.NewRequest(tc.method, tc.url, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: status = %d, want %d", tc.method, tc.url, resp.StatusCode, tc.status)
+		}
+	}
+
+	stop()
+	events := auditEvents(t, audit)
+	if got := auditReasons(t, audit); got != "allow:OK,deny:HTTP_RULE_DENIED,deny:HTTP_RULE_DENIED" {
+		t.Fatalf("audit = %s", got)
+	}
+	for i, want := range []string{"GET http://example.com/pkg/**", "", "* http://example.com/pkg/private/**"} {
+		if events[i].HTTPRule != want {
+			t.Errorf("event %d: http_rule = %q, want %q", i, events[i].HTTPRule, want)
+		}
+	}
+}
+
+func TestHTTPRules_Intercepted(t *testing.T) {
+	upstream := httptest.NewTLSServer(echoAuthHandler)
+	defer upstream.Close()
+	proxyURL, intercept, audit, stop := startInterceptTestProxy(t, upstream, &protocol.NetCapabilityV1{
+		Mode:      "allowlist",
+		Allow:     []string{"example.com:443"},
+		HTTPRules: &protocol.NetHTTPRulesV1{Deny: []string{"DELETE https://example.com/repos/**"}},
+	}, []Credential{testCredential})
+	client := sandboxClient(proxyURL, intercept)
+
+	resp, err := client.Get("https://example.com/repos/acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET: status = %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest("DELETE", "https://example.com/repos/acme", nil)
+	if resp, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "HTTP_RULE_DENIED") {
+		t.Errorf("DELETE: status = %d, body = %q", resp.StatusCode, body)
+	}
+	client.CloseIdleConnections()
+
+	stop()
+	events := auditEvents(t, audit)
+	if got := auditReasons(t, audit); got != "allow:OK,deny:HTTP_RULE_DENIED" {
+		t.Fatalf("audit = %s", got)
+	}
+	if e := events[1]; e.HTTPRule != "DELETE https://example.com/repos/**" || e.Method != "CONNECT" || !e.CredentialInjected {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestHTTPRules_TunnelRefused(t *testing.T) {
+	upstream := httptest.NewServer(echoAuthHandler)
+	defer upstream.Close()
+	proxyURL, _, audit, stop := startInterceptTestProxy(t, upstream, &protocol.NetCapabilityV1{
+		Mode:      "allowlist",
+		Allow:     []string{"example.com:80"},
+		HTTPRules: &protocol.NetHTTPRulesV1{Deny: []string{"DELETE http://example.com/**"}},
+	}, nil)
+
+	conn, err := net.Dial("tcp", proxyURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "CONNECT example.com:80 HTTP/1.1\r\nHost: example.com:80\r\n\r\nDELETE / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	b, _ := io.ReadAll(conn)
+	if strings.Contains(string(b), "Bearer") || strings.Count(string(b), "HTTP/1.1") > 1 {
+		t.Errorf("tunnel reached upstream: %q", b)
+	}
+
+	stop()
+	if got := auditReasons(t, audit); got != "deny:HTTP_RULE_DENIED" {
+		t.Errorf("audit = %s", got)
+	}
+}
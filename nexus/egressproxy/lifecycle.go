@@ -10,7 +10,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"sync"
+	"time"
 
+	"cybros.ai/nexus/netpolicy"
 	"cybros.ai/nexus/protocol"
 )
 
@@ -22,21 +24,39 @@ const maxSocketPathLen = 104
 
 // Instance represents a running per-directive proxy instance.
 type Instance struct {
-	socketPath string
-	proxyURL   string
-	cancel     context.CancelFunc
-	done       chan struct{}
-	stopOnce   sync.Once
+	socketPath    string
+	proxyURL      string
+	dnsSocketPath string
+	dns           *DNSServer
+	policy        *Policy
+	audit         *AuditLogger
+	cancel        context.CancelFunc
+	done          chan struct{}
+	stopOnce      sync.Once
+}
+
+// Options are the per-directive proxy settings beyond the net capability.
+type Options struct {
+	Limits  Limits
+	Metrics *Metrics // nil: not exported
+	// Intercept injects HTTP credentials (nil: none). Its hosts must be
+	// allowed by the net capability.
+	Intercept *Intercept
+	// Cache serves registry responses from the territory's cache (nil:
+	// none); it is the partition of the directive's tenant.
+	Cache *CachePartition
 }
 
 // StartForDirective creates and starts an egress proxy for a single directive.
-// The proxy listens on a UDS at <socketDir>/<directiveID>.sock.
+// The proxy listens on a UDS at <socketDir>/<directiveID>.sock, and the
+// directive's DNS resolver (see Resolver) on <socketDir>/<directiveID>.dns.sock.
 // The returned Instance must be stopped via Stop() when the directive completes.
 func StartForDirective(
 	socketDir string,
 	directiveID string,
 	cap *protocol.NetCapabilityV1,
 	auditWriter io.Writer,
+	opts Options,
 ) (*Instance, error) {
 	// FIX M5: validate directiveID to prevent path traversal
 	if !validDirectiveIDRe.MatchString(directiveID) {
@@ -48,27 +68,44 @@ func StartForDirective(
 	}
 
 	socketPath := filepath.Join(socketDir, directiveID+".sock")
+	dnsSocketPath := filepath.Join(socketDir, directiveID+".dns.sock")
 
 	// FIX M4: validate socket path length (Unix domain socket limit)
-	if len(socketPath) > maxSocketPathLen {
-		return nil, fmt.Errorf("socket path too long (%d > %d chars): %s",
-			len(socketPath), maxSocketPathLen, socketPath)
+	for _, p := range []string{socketPath, dnsSocketPath} {
+		if len(p) > maxSocketPathLen {
+			return nil, fmt.Errorf("socket path too long (%d > %d chars): %s",
+				len(p), maxSocketPathLen, p)
+		}
 	}
 
-	// Remove stale socket from previous run
+	// Remove stale sockets from previous run
 	os.Remove(socketPath)
+	os.Remove(dnsSocketPath)
 
 	policy, err := NewPolicy(cap)
 	if err != nil {
 		return nil, fmt.Errorf("create policy: %w", err)
 	}
+	if err := policy.checkIntercept(opts.Intercept); err != nil {
+		return nil, err
+	}
 
 	audit := NewAuditLogger(auditWriter, directiveID)
 
+	dns, err := StartDNSSocket(dnsSocketPath, NewResolver(policy, audit).Resolve)
+	if err != nil {
+		return nil, fmt.Errorf("start DNS resolver: %w", err)
+	}
+
 	proxy, err := New(socketPath, policy, audit)
 	if err != nil {
+		dns.Close()
+		os.Remove(dnsSocketPath)
 		return nil, fmt.Errorf("create proxy: %w", err)
 	}
+	proxy.SetLimits(opts.Limits, opts.Metrics)
+	proxy.SetIntercept(opts.Intercept)
+	proxy.SetCache(opts.Cache)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	done := make(chan struct{})
@@ -82,9 +119,13 @@ func StartForDirective(
 	}()
 
 	return &Instance{
-		socketPath: socketPath,
-		cancel:     cancel,
-		done:       done,
+		socketPath:    socketPath,
+		dnsSocketPath: dnsSocketPath,
+		dns:           dns,
+		policy:        policy,
+		audit:         audit,
+		cancel:        cancel,
+		done:          done,
 	}, nil
 }
 
@@ -95,6 +136,7 @@ func StartForDirectiveTCP(
 	directiveID string,
 	cap *protocol.NetCapabilityV1,
 	auditWriter io.Writer,
+	opts Options,
 ) (*Instance, error) {
 	// Keep directive ID validation consistent (also used in audit logs).
 	if !validDirectiveIDRe.MatchString(directiveID) {
@@ -105,6 +147,9 @@ func StartForDirectiveTCP(
 	if err != nil {
 		return nil, fmt.Errorf("create policy: %w", err)
 	}
+	if err := policy.checkIntercept(opts.Intercept); err != nil {
+		return nil, err
+	}
 
 	audit := NewAuditLogger(auditWriter, directiveID)
 
@@ -114,6 +159,9 @@ func StartForDirectiveTCP(
 	}
 
 	proxy := NewFromListener(listener, policy, audit)
+	proxy.SetLimits(opts.Limits, opts.Metrics)
+	proxy.SetIntercept(opts.Intercept)
+	proxy.SetCache(opts.Cache)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	done := make(chan struct{})
@@ -127,6 +175,8 @@ func StartForDirectiveTCP(
 
 	return &Instance{
 		proxyURL: "http://" + listener.Addr().String(),
+		policy:   policy,
+		audit:    audit,
 		cancel:   cancel,
 		done:     done,
 	}, nil
@@ -142,6 +192,28 @@ func (i *Instance) ProxyURL() string {
 	return i.proxyURL
 }
 
+// DNSSocketPath returns the UDS path of the directive's DNS resolver (see
+// StartDNSSocket). Empty for TCP instances.
+func (i *Instance) DNSSocketPath() string {
+	return i.dnsSocketPath
+}
+
+// NewResolver returns a resolver sharing the proxy's policy and audit log,
+// so its answers are pinned for the proxy. For resolvers served elsewhere
+// (e.g. on a VM's TAP address).
+func (i *Instance) NewResolver() *Resolver {
+	return NewResolver(i.policy, i.audit)
+}
+
+// GrantExpiresAt returns when the directive's time-boxed grant ends (see
+// Policy.ExpiresAt), or zero if it has no TTL. The proxy closes its own
+// connections then; drivers that route around it revoke theirs.
+func (i *Instance) GrantExpiresAt() time.Time { return i.policy.ExpiresAt() }
+
+// PrefixEntries returns the directive's IP/CIDR allowlist entries (see
+// Policy.PrefixEntries), for drivers that route around the proxy.
+func (i *Instance) PrefixEntries() []netpolicy.AllowlistEntryV2 { return i.policy.PrefixEntries() }
+
 // Stop shuts down the proxy and removes the socket file.
 // Safe to call multiple times (idempotent via sync.Once).
 func (i *Instance) Stop() {
@@ -151,5 +223,9 @@ func (i *Instance) Stop() {
 		if i.socketPath != "" {
 			os.Remove(i.socketPath)
 		}
+		if i.dns != nil {
+			i.dns.Close()
+			os.Remove(i.dnsSocketPath)
+		}
 	})
 }
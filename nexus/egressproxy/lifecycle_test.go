@@ -17,7 +17,7 @@ func TestStartForDirective_StartsAndStops(t *testing.T) {
 	var auditBuf bytes.Buffer
 
 	cap := &protocol.NetCapabilityV1{Mode: "none"}
-	inst, err := StartForDirective(socketDir, "test-123", cap, &auditBuf)
+	inst, err := StartForDirective(socketDir, "test-123", cap, &auditBuf, Options{})
 	if err != nil {
 		t.Fatalf("StartForDirective: %v", err)
 	}
@@ -39,12 +39,21 @@ func TestStartForDirective_StartsAndStops(t *testing.T) {
 	}
 	conn.Close()
 
+	dnsConn, err := net.DialTimeout("unix", inst.DNSSocketPath(), 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not connect to DNS socket: %v", err)
+	}
+	dnsConn.Close()
+
 	// Stop should clean up
 	inst.Stop()
 
 	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
 		t.Error("socket file should be removed after Stop()")
 	}
+	if _, err := os.Stat(inst.DNSSocketPath()); !os.IsNotExist(err) {
+		t.Error("DNS socket file should be removed after Stop()")
+	}
 }
 
 func TestStartForDirective_DoubleStop(t *testing.T) {
@@ -52,7 +61,7 @@ func TestStartForDirective_DoubleStop(t *testing.T) {
 	var auditBuf bytes.Buffer
 
 	cap := &protocol.NetCapabilityV1{Mode: "none"}
-	inst, err := StartForDirective(socketDir, "double-stop", cap, &auditBuf)
+	inst, err := StartForDirective(socketDir, "double-stop", cap, &auditBuf, Options{})
 	if err != nil {
 		t.Fatalf("StartForDirective: %v", err)
 	}
@@ -70,7 +79,7 @@ func TestStartForDirective_InvalidPolicy(t *testing.T) {
 		Mode:  "allowlist",
 		Allow: []string{"invalid-entry"},
 	}
-	_, err := StartForDirective(socketDir, "bad-policy", cap, &auditBuf)
+	_, err := StartForDirective(socketDir, "bad-policy", cap, &auditBuf, Options{})
 	if err == nil {
 		t.Fatal("expected error for invalid allowlist entry")
 	}
@@ -92,7 +101,7 @@ func TestStartForDirective_InvalidDirectiveID(t *testing.T) {
 	}
 
 	for _, id := range invalidIDs {
-		_, err := StartForDirective(socketDir, id, cap, &auditBuf)
+		_, err := StartForDirective(socketDir, id, cap, &auditBuf, Options{})
 		if err == nil {
 			t.Errorf("directive ID %q should be rejected", id)
 		}
@@ -108,7 +117,7 @@ func TestStartForDirective_SocketPathTooLong(t *testing.T) {
 
 	// Create a directive ID that makes the socket path exceed 104 chars
 	longID := strings.Repeat("a", 200)
-	_, err := StartForDirective(socketDir, longID, cap, &auditBuf)
+	_, err := StartForDirective(socketDir, longID, cap, &auditBuf, Options{})
 	if err == nil {
 		t.Error("expected error for overly long socket path")
 	}
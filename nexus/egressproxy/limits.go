@@ -0,0 +1,297 @@
+package egressproxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// Limits bounds a directive's egress traffic through the proxy. Zero fields
+// mean no limit.
+type Limits struct {
+	// BytesPerSec caps the combined rate of both directions across all of
+	// the directive's connections.
+	BytesPerSec int64
+	// MaxBytes caps the total bytes transferred (both directions). Once it
+	// is reached open connections are cut and new ones denied with
+	// TRANSFER_LIMIT_EXCEEDED.
+	MaxBytes int64
+	// MaxConns caps concurrent egress connections; further ones are denied
+	// with CONN_LIMIT_EXCEEDED.
+	MaxConns int
+	// IdleTimeout closes a connection that carried no bytes in either
+	// direction for this long.
+	IdleTimeout time.Duration
+}
+
+// limitsExtKey is the NetCapabilityV1.x_ext key carrying egress limits.
+const limitsExtKey = "x_egress_limits"
+
+// LimitsFor returns the egress limits a directive asks for: the net_* fields
+// of limits and the x_ext.x_egress_limits object of cap, the stricter value
+// winning where both are set.
+func LimitsFor(limits protocol.Limits, cap *protocol.NetCapabilityV1) (Limits, error) {
+	for name, v := range map[string]int64{
+		"net_bytes_per_sec":        limits.NetBytesPerSec,
+		"net_max_bytes":            limits.NetMaxBytes,
+		"net_max_conns":            int64(limits.NetMaxConns),
+		"net_idle_timeout_seconds": int64(limits.NetIdleTimeoutSeconds),
+	} {
+		if v < 0 {
+			return Limits{}, fmt.Errorf("limits.%s must not be negative", name)
+		}
+	}
+	l := Limits{
+		BytesPerSec: limits.NetBytesPerSec,
+		MaxBytes:    limits.NetMaxBytes,
+		MaxConns:    limits.NetMaxConns,
+		IdleTimeout: time.Duration(limits.NetIdleTimeoutSeconds) * time.Second,
+	}
+	if cap == nil || cap.XExt[limitsExtKey] == nil {
+		return l, nil
+	}
+
+	ext, ok := cap.XExt[limitsExtKey].(map[string]any)
+	if !ok {
+		return Limits{}, fmt.Errorf("x_ext.%s must be an object", limitsExtKey)
+	}
+	var x Limits
+	for k, raw := range ext {
+		f, ok := raw.(float64)
+		if !ok || f < 0 || f != math.Trunc(f) || f > 1<<53 {
+			return Limits{}, fmt.Errorf("x_ext.%s.%s must be a non-negative integer", limitsExtKey, k)
+		}
+		v := int64(f)
+		switch k {
+		case "bytes_per_sec":
+			x.BytesPerSec = v
+		case "max_bytes":
+			x.MaxBytes = v
+		case "max_conns":
+			x.MaxConns = int(v)
+		case "idle_timeout_seconds":
+			x.IdleTimeout = time.Duration(v) * time.Second
+		default:
+			return Limits{}, fmt.Errorf("x_ext.%s: unknown key %q", limitsExtKey, k)
+		}
+	}
+	return l.Tighten(x), nil
+}
+
+// Tighten returns the stricter of l and o for each limit.
+func (l Limits) Tighten(o Limits) Limits {
+	return Limits{
+		BytesPerSec: stricter(l.BytesPerSec, o.BytesPerSec),
+		MaxBytes:    stricter(l.MaxBytes, o.MaxBytes),
+		MaxConns:    stricter(l.MaxConns, o.MaxConns),
+		IdleTimeout: stricter(l.IdleTimeout, o.IdleTimeout),
+	}
+}
+
+// stricter returns the smaller of two limits, where zero means unlimited.
+func stricter[T int | int64 | time.Duration](a, b T) T {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+var errTransferLimit = errors.New("egress transfer limit reached")
+
+// limiter enforces a proxy's Limits across all of its connections.
+type limiter struct {
+	lim     Limits
+	metrics *Metrics // nil: not exported
+
+	active      atomic.Int64
+	transferred atomic.Int64
+
+	mu     sync.Mutex // guards the rate bucket
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+func newLimiter(lim Limits, metrics *Metrics) *limiter {
+	return &limiter{lim: lim, metrics: metrics, now: time.Now, sleep: time.Sleep}
+}
+
+// admit reserves a connection slot, to be given back with release (or by
+// closing the connection wrap returns). It reports the reason code when the
+// directive is out of connections or transfer budget.
+func (l *limiter) admit() (release func(), reason string) {
+	if l.lim.MaxBytes > 0 && l.transferred.Load() >= l.lim.MaxBytes {
+		l.metrics.limitHit("max_bytes")
+		return nil, "TRANSFER_LIMIT_EXCEEDED"
+	}
+	if n := l.active.Add(1); l.lim.MaxConns > 0 && n > int64(l.lim.MaxConns) {
+		l.active.Add(-1)
+		l.metrics.limitHit("max_conns")
+		return nil, "CONN_LIMIT_EXCEEDED"
+	}
+	l.metrics.connOpened()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.active.Add(-1)
+			l.metrics.connClosed()
+		})
+	}, ""
+}
+
+// chunk is the most bytes moved per read or write, so that a low rate
+// limit throttles in steps of about a second rather than one long stall.
+func (l *limiter) chunk() int {
+	const maxChunk = 32 * 1024
+	if l.lim.BytesPerSec > 0 && l.lim.BytesPerSec < maxChunk {
+		return max(int(l.lim.BytesPerSec), 512)
+	}
+	return maxChunk
+}
+
+// take accounts n bytes moved in direction ("upload" or "download"). It
+// returns how many of them fit the transfer budget (errTransferLimit if not
+// all), after waiting for the rate limit.
+func (l *limiter) take(n int, direction string) (int, error) {
+	allowed := n
+	var err error
+	if l.lim.MaxBytes > 0 {
+		total := l.transferred.Add(int64(n))
+		if over := total - l.lim.MaxBytes; over > 0 {
+			allowed = max(n-int(over), 0)
+			err = errTransferLimit
+			l.metrics.limitHit("max_bytes")
+		}
+	}
+	if l.lim.BytesPerSec > 0 && allowed > 0 {
+		if d := l.reserve(allowed); d > 0 {
+			l.metrics.throttled(d)
+			l.sleep(d)
+		}
+	}
+	l.metrics.bytes(direction, allowed)
+	return allowed, err
+}
+
+// reserve takes n bytes from the rate bucket (burst: one second's worth)
+// and returns how long to wait before they may move.
+func (l *limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	rate := float64(l.lim.BytesPerSec)
+	now := l.now()
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else {
+		l.tokens = min(rate, l.tokens+now.Sub(l.last).Seconds()*rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / rate * float64(time.Second))
+}
+
+// wrap meters conn, an upstream connection: writes count as upload, reads
+// as download. Closing it calls release (if non-nil).
+func (l *limiter) wrap(conn net.Conn, release func()) *meteredConn {
+	c := &meteredConn{Conn: conn, l: l, release: release}
+	c.touch()
+	return c
+}
+
+// meteredConn is an upstream connection subject to the proxy's Limits.
+// Reads also enforce the idle timeout: one that times out while the other
+// direction is still active is retried.
+type meteredConn struct {
+	net.Conn
+	l       *limiter
+	release func()
+
+	lastActive atomic.Int64 // unix nanos
+	cut        atomic.Value // string: the limit that ended the connection
+	closeOnce  sync.Once
+}
+
+func (c *meteredConn) touch() { c.lastActive.Store(c.l.now().UnixNano()) }
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	if len(p) > c.l.chunk() {
+		p = p[:c.l.chunk()]
+	}
+	idle := c.l.lim.IdleTimeout
+	for {
+		if idle > 0 {
+			_ = c.Conn.SetReadDeadline(time.Unix(0, c.lastActive.Load()).Add(idle))
+		}
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.touch()
+			n, limitErr := c.l.take(n, "download")
+			if limitErr != nil {
+				c.cut.Store("TRANSFER_LIMIT_EXCEEDED")
+				return n, limitErr
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = nil // the next Read re-arms the deadline
+			}
+			return n, err
+		}
+		if idle > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+			if c.l.now().Sub(time.Unix(0, c.lastActive.Load())) < idle {
+				continue // the upload direction kept the connection alive
+			}
+			c.l.metrics.limitHit("idle_timeout")
+		}
+		return n, err
+	}
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), c.l.chunk())
+		allowed, limitErr := c.l.take(n, "upload")
+		m, err := c.Conn.Write(p[:allowed])
+		written += m
+		if m > 0 {
+			c.touch()
+		}
+		if err != nil {
+			return written, err
+		}
+		if limitErr != nil {
+			c.cut.Store("TRANSFER_LIMIT_EXCEEDED")
+			return written, limitErr
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *meteredConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.release != nil {
+			c.release()
+		}
+	})
+	return err
+}
+
+// cutBy returns the reason code of the limit that cut the connection
+// (TRANSFER_LIMIT_EXCEEDED), or "" if none did. Idle timeouts are not policy
+// decisions and are only counted in Metrics.
+func (c *meteredConn) cutBy() string {
+	s, _ := c.cut.Load().(string)
+	return s
+}
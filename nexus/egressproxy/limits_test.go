@@ -0,0 +1,286 @@
+package egressproxy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+)
+
+func TestLimitsFor(t *testing.T) {
+	cap := &protocol.NetCapabilityV1{
+		Mode:  "allowlist",
+		Allow: []string{"github.com:443"},
+		XExt: map[string]any{limitsExtKey: map[string]any{
+			"bytes_per_sec":        float64(1000),
+			"max_bytes":            float64(1 << 20),
+			"idle_timeout_seconds": float64(30),
+		}},
+	}
+	got, err := LimitsFor(protocol.Limits{NetBytesPerSec: 500, NetMaxBytes: 1 << 30, NetMaxConns: 4}, cap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Limits{BytesPerSec: 500, MaxBytes: 1 << 20, MaxConns: 4, IdleTimeout: 30 * time.Second}
+	if got != want {
+		t.Errorf("LimitsFor = %+v, want %+v", got, want)
+	}
+
+	if got, err := LimitsFor(protocol.Limits{}, nil); err != nil || got != (Limits{}) {
+		t.Errorf("no limits = %+v, %v", got, err)
+	}
+}
+
+func TestLimitsFor_Invalid(t *testing.T) {
+	tests := map[string]struct {
+		limits protocol.Limits
+		ext    any
+	}{
+		"negative field":   {limits: protocol.Limits{NetMaxConns: -1}},
+		"not an object":    {ext: "fast"},
+		"negative ext":     {ext: map[string]any{"max_bytes": float64(-1)}},
+		"fractional ext":   {ext: map[string]any{"max_conns": 1.5}},
+		"string ext value": {ext: map[string]any{"max_conns": "2"}},
+		"unknown ext key":  {ext: map[string]any{"max_requests": float64(2)}},
+	}
+	for name, tt := range tests {
+		cap := &protocol.NetCapabilityV1{Mode: "unrestricted"}
+		if tt.ext != nil {
+			cap.XExt = map[string]any{limitsExtKey: tt.ext}
+		}
+		if _, err := LimitsFor(tt.limits, cap); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestLimits_Tighten(t *testing.T) {
+	host := Limits{MaxConns: 256, IdleTimeout: 10 * time.Minute}
+	got := Limits{MaxConns: 1000, MaxBytes: 5}.Tighten(host)
+	want := Limits{MaxBytes: 5, MaxConns: 256, IdleTimeout: 10 * time.Minute}
+	if got != want {
+		t.Errorf("Tighten = %+v, want %+v", got, want)
+	}
+}
+
+func TestLimiter_Admit(t *testing.T) {
+	l := newLimiter(Limits{MaxConns: 2, MaxBytes: 100}, nil)
+
+	r1, reason := l.admit()
+	if reason != "" {
+		t.Fatalf("first admit: %s", reason)
+	}
+	if _, reason := l.admit(); reason != "" {
+		t.Fatalf("second admit: %s", reason)
+	}
+	if _, reason := l.admit(); reason != "CONN_LIMIT_EXCEEDED" {
+		t.Errorf("third admit = %q, want CONN_LIMIT_EXCEEDED", reason)
+	}
+	r1()
+	r1() // releasing twice frees one slot only
+	if _, reason := l.admit(); reason != "" {
+		t.Errorf("admit after release: %s", reason)
+	}
+	if _, reason := l.admit(); reason != "CONN_LIMIT_EXCEEDED" {
+		t.Errorf("admit over limit = %q", reason)
+	}
+
+	if n, err := l.take(150, "upload"); n != 100 || !errors.Is(err, errTransferLimit) {
+		t.Errorf("take = %d, %v", n, err)
+	}
+	if _, reason := l.admit(); reason != "TRANSFER_LIMIT_EXCEEDED" {
+		t.Errorf("admit after budget spent = %q, want TRANSFER_LIMIT_EXCEEDED", reason)
+	}
+}
+
+func TestLimiter_Rate(t *testing.T) {
+	l := newLimiter(Limits{BytesPerSec: 1000}, nil)
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+	var slept time.Duration
+	l.sleep = func(d time.Duration) { slept += d }
+
+	l.take(1000, "download") // the initial burst
+	if slept != 0 {
+		t.Errorf("burst throttled for %v", slept)
+	}
+	l.take(500, "download")
+	if slept != 500*time.Millisecond {
+		t.Errorf("slept %v, want 500ms", slept)
+	}
+	now = now.Add(2 * time.Second) // refills, but only up to one second's worth
+	slept = 0
+	l.take(1000, "upload")
+	if slept != 0 {
+		t.Errorf("after refill slept %v", slept)
+	}
+	if got := l.chunk(); got != 1000 {
+		t.Errorf("chunk = %d, want 1000", got)
+	}
+}
+
+func TestMeteredConn_IdleTimeout(t *testing.T) {
+	l := newLimiter(Limits{IdleTimeout: 50 * time.Millisecond}, nil)
+	a, b := net.Pipe()
+	defer b.Close()
+	released := false
+	c := l.wrap(a, func() { released = true })
+
+	go b.Write([]byte("hi"))
+	buf := make([]byte, 8)
+	if n, err := c.Read(buf); err != nil || string(buf[:n]) != "hi" {
+		t.Fatalf("Read = %q, %v", buf[:n], err)
+	}
+	start := time.Now()
+	if _, err := c.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("idle Read err = %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("idle timeout took %v", d)
+	}
+	c.Close()
+	if !released {
+		t.Error("Close did not release the connection slot")
+	}
+}
+
+// startEchoServer starts a TCP server that sends back everything it reads.
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func dialConnect(t *testing.T, socketPath string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "CONNECT pypi.org:443 HTTP/1.1\r\nHost: pypi.org:443\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, _ := br.ReadString('\n')
+	return conn, br, resp
+}
+
+// pingTunnel completes a round trip through an established tunnel, which
+// also gets it logged.
+func pingTunnel(t *testing.T, conn net.Conn, br *bufio.Reader) {
+	t.Helper()
+	if line, err := br.ReadString('\n'); err != nil || line != "\r\n" {
+		t.Fatalf("CONNECT headers: %q, %v", line, err)
+	}
+	conn.Write([]byte("ping"))
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(br, got); err != nil || string(got) != "ping" {
+		t.Fatalf("echo = %q, %v", got, err)
+	}
+}
+
+func auditReasons(t *testing.T, audit *bytes.Buffer) string {
+	t.Helper()
+	var reasons []string
+	for _, e := range auditEvents(t, audit) {
+		reasons = append(reasons, e.Decision+":"+e.ReasonCode)
+	}
+	return strings.Join(reasons, ",")
+}
+
+func TestProxy_ConnLimit(t *testing.T) {
+	socketPath, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"pypi.org:443"}},
+		withUpstream(startEchoServer(t)), withLimits(Limits{MaxConns: 1}))
+
+	conn1, br1, resp := dialConnect(t, socketPath)
+	defer conn1.Close()
+	if !strings.Contains(resp, "200") {
+		t.Fatalf("first CONNECT = %q", resp)
+	}
+	pingTunnel(t, conn1, br1)
+
+	conn2, _, resp := dialConnect(t, socketPath)
+	conn2.Close()
+	if !strings.Contains(resp, "403") {
+		t.Errorf("second CONNECT = %q, want 403", resp)
+	}
+
+	// Closing the first tunnel frees its slot.
+	conn1.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn3, br3, resp := dialConnect(t, socketPath)
+		if strings.Contains(resp, "200") {
+			pingTunnel(t, conn3, br3)
+			conn3.Close()
+			break
+		}
+		conn3.Close()
+		if time.Now().After(deadline) {
+			t.Fatalf("slot not released: %q", resp)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	stop()
+	if got := auditReasons(t, audit); !strings.HasPrefix(got, "allow:OK,deny:CONN_LIMIT_EXCEEDED") {
+		t.Errorf("audit = %s", got)
+	}
+}
+
+func TestProxy_TransferLimit(t *testing.T) {
+	socketPath, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"pypi.org:443"}},
+		withUpstream(startEchoServer(t)), withLimits(Limits{MaxBytes: 16}))
+
+	conn, br, resp := dialConnect(t, socketPath)
+	defer conn.Close()
+	if !strings.Contains(resp, "200") {
+		t.Fatalf("CONNECT = %q", resp)
+	}
+	if line, err := br.ReadString('\n'); err != nil || line != "\r\n" {
+		t.Fatalf("CONNECT headers: %q, %v", line, err)
+	}
+	conn.Write(bytes.Repeat([]byte("x"), 100))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	echoed, err := io.ReadAll(br)
+	if err != nil {
+		t.Fatalf("tunnel not cut: %v", err)
+	}
+	if len(echoed) > 16 {
+		t.Errorf("echoed %d bytes past a 16-byte budget", len(echoed))
+	}
+
+	conn2, _, resp := dialConnect(t, socketPath)
+	conn2.Close()
+	if !strings.Contains(resp, "403") {
+		t.Errorf("CONNECT after budget spent = %q, want 403", resp)
+	}
+
+	stop()
+	want := "allow:OK,deny:TRANSFER_LIMIT_EXCEEDED,deny:TRANSFER_LIMIT_EXCEEDED"
+	if got := auditReasons(t, audit); got != want {
+		t.Errorf("audit = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+package egressproxy
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the egress proxies' Prometheus metrics, aggregated over all
+// directives. The daemon creates one set and registers Collectors; a nil
+// *Metrics records nothing.
+type Metrics struct {
+	bytesTotal     *prometheus.CounterVec
+	connsActive    prometheus.Gauge
+	connsTotal     prometheus.Counter
+	limitHits      *prometheus.CounterVec
+	throttledTotal prometheus.Counter
+
+	cacheRequests  *prometheus.CounterVec
+	cacheBytes     prometheus.Gauge
+	cacheEvictions prometheus.Counter
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		bytesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nexusd_egress_bytes_total",
+			Help: "Bytes relayed by egress proxies, by direction (upload, download).",
+		}, []string{"direction"}),
+
+		connsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nexusd_egress_connections_active",
+			Help: "Egress connections currently open through the proxies.",
+		}),
+
+		connsTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "nexusd_egress_connections_total",
+			Help: "Egress connections admitted by the proxies.",
+		}),
+
+		limitHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nexusd_egress_limit_hits_total",
+			Help: "Connections denied or closed by a per-directive egress limit (max_conns, max_bytes, idle_timeout).",
+		}, []string{"limit"}),
+
+		throttledTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "nexusd_egress_throttled_seconds_total",
+			Help: "Time egress transfers waited on per-directive rate limits.",
+		}),
+
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nexusd_egress_cache_requests_total",
+			Help: "Cacheable registry requests seen by the egress cache, by result (hit, miss).",
+		}, []string{"result"}),
+
+		cacheBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nexusd_egress_cache_bytes",
+			Help: "Size of the response bodies held by the egress cache.",
+		}),
+
+		cacheEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "nexusd_egress_cache_evictions_total",
+			Help: "Egress cache entries evicted to stay within the size bound.",
+		}),
+	}
+}
+
+// Collectors returns the collectors to register.
+func (m *Metrics) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.bytesTotal, m.connsActive, m.connsTotal, m.limitHits, m.throttledTotal,
+		m.cacheRequests, m.cacheBytes, m.cacheEvictions}
+}
+
+func (m *Metrics) bytes(direction string, n int) {
+	if m != nil && n > 0 {
+		m.bytesTotal.WithLabelValues(direction).Add(float64(n))
+	}
+}
+
+func (m *Metrics) connOpened() {
+	if m != nil {
+		m.connsActive.Inc()
+		m.connsTotal.Inc()
+	}
+}
+
+func (m *Metrics) connClosed() {
+	if m != nil {
+		m.connsActive.Dec()
+	}
+}
+
+func (m *Metrics) limitHit(limit string) {
+	if m != nil {
+		m.limitHits.WithLabelValues(limit).Inc()
+	}
+}
+
+func (m *Metrics) throttled(d time.Duration) {
+	if m != nil {
+		m.throttledTotal.Add(d.Seconds())
+	}
+}
+
+func (m *Metrics) cacheResult(result string) {
+	if m != nil {
+		m.cacheRequests.WithLabelValues(result).Inc()
+	}
+}
+
+func (m *Metrics) cacheSize(n int64) {
+	if m != nil {
+		m.cacheBytes.Set(float64(n))
+	}
+}
+
+func (m *Metrics) cacheEvicted() {
+	if m != nil {
+		m.cacheEvictions.Inc()
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"cybros.ai/nexus/netpolicy"
@@ -15,13 +18,30 @@ import (
 // a directive's network capability.
 type Policy struct {
 	mode    string // none/allowlist/unrestricted
-	entries []netpolicy.AllowlistEntry
+	entries []netpolicy.AllowlistEntryV2
+	sni     sniMode
+	// httpRules restrict the HTTP requests the proxy parses (CheckHTTP).
+	httpRules netpolicy.HTTPRules
 
 	lookupIP    func(host string) ([]net.IP, error)
 	dialTimeout func(network, address string, timeout time.Duration) (net.Conn, error)
+
+	// expiresAt ends the grant NetCapabilityV1.TTLSeconds after NewPolicy
+	// (directive start); zero means it never expires.
+	expiresAt time.Time
+	now       func() time.Time
+
+	// pins maps a host to the addresses the sandbox's resolver answered
+	// for it, most recent first (see Pin).
+	pinMu sync.Mutex
+	pins  map[string][]net.IP
 }
 
-// NewPolicy creates an enforcement policy from a NetCapabilityV1.
+// maxPinsPerHost bounds the pinned addresses kept per host.
+const maxPinsPerHost = 16
+
+// NewPolicy creates an enforcement policy from a NetCapabilityV1, with its
+// preset expanded (see netpolicy.ExpandPreset).
 // Returns a deny-all policy if cap is nil.
 func NewPolicy(cap *protocol.NetCapabilityV1) (*Policy, error) {
 	if cap == nil {
@@ -32,20 +52,28 @@ func NewPolicy(cap *protocol.NetCapabilityV1) (*Policy, error) {
 		}, nil
 	}
 
+	eff, err := netpolicy.ExpandPreset(*cap)
+	if err != nil {
+		return nil, err
+	}
 	p := &Policy{
-		mode:        cap.Mode,
+		mode:        eff.Mode,
+		sni:         sniModeFor(eff.Mode, eff.Preset),
 		lookupIP:    net.LookupIP,
 		dialTimeout: net.DialTimeout,
+		now:         time.Now,
 	}
-	if cap.Mode == "allowlist" {
-		for _, raw := range cap.Allow {
-			entry, err := netpolicy.ParseAllowlistEntry(raw)
-			if err != nil {
-				return nil, fmt.Errorf("invalid allowlist entry %q: %w", raw, err)
-			}
-			p.entries = append(p.entries, entry)
+	if eff.TTLSeconds > 0 && eff.Mode != "none" {
+		p.expiresAt = p.now().Add(time.Duration(eff.TTLSeconds) * time.Second)
+	}
+	if eff.Mode == "allowlist" {
+		if p.entries, err = netpolicy.ParseAllowlist(eff.AllowlistVersion, eff.Allow); err != nil {
+			return nil, err
 		}
 	}
+	if p.httpRules, err = netpolicy.ParseHTTPRules(eff.HTTPRules); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
@@ -53,10 +81,44 @@ func NewPolicy(cap *protocol.NetCapabilityV1) (*Policy, error) {
 type CheckResult struct {
 	Allowed    bool
 	ReasonCode string
+	HTTPRule   string // CheckHTTP: the deciding rule, if any
+}
+
+// ExpiresAt returns when the grant expires, or zero if it has no TTL.
+func (p *Policy) ExpiresAt() time.Time { return p.expiresAt }
+
+// Expired reports whether the grant's TTL has passed. An expired grant
+// allows nothing (reason GRANT_EXPIRED).
+func (p *Policy) Expired() bool {
+	return !p.expiresAt.IsZero() && !p.now().Before(p.expiresAt)
 }
 
 // Check evaluates whether connecting to destHost:destPort is allowed.
 func (p *Policy) Check(destHost string, destPort int) CheckResult {
+	return p.check(netpolicy.ProtoTCP, destHost, destPort)
+}
+
+// CheckUDP evaluates whether sending datagrams to destHost:destPort is
+// allowed. In allowlist mode only "/udp" entries (allowlist V2) match.
+func (p *Policy) CheckUDP(destHost string, destPort int) CheckResult {
+	return p.check(netpolicy.ProtoUDP, destHost, destPort)
+}
+
+// CheckHTTP evaluates an HTTP request to a destination that passed Check
+// against the HTTP rules (design 03 §7.9): scheme is "http" for proxied
+// plain HTTP and "https" inside an intercepted tunnel.
+func (p *Policy) CheckHTTP(method, scheme, destHost string, destPort int, escapedPath string) CheckResult {
+	allowed, rule := p.httpRules.Evaluate(method, scheme, destHost, destPort, escapedPath)
+	if !allowed {
+		return CheckResult{Allowed: false, ReasonCode: "HTTP_RULE_DENIED", HTTPRule: rule}
+	}
+	return CheckResult{Allowed: true, ReasonCode: "OK", HTTPRule: rule}
+}
+
+func (p *Policy) check(proto, destHost string, destPort int) CheckResult {
+	if p.Expired() {
+		return CheckResult{Allowed: false, ReasonCode: "GRANT_EXPIRED"}
+	}
 	switch p.mode {
 	case "none":
 		return CheckResult{Allowed: false, ReasonCode: "NET_MODE_NONE"}
@@ -66,10 +128,16 @@ func (p *Policy) Check(destHost string, destPort int) CheckResult {
 
 	case "allowlist":
 		for _, entry := range p.entries {
-			if netpolicy.Match(entry, destHost, destPort) {
+			if netpolicy.MatchProtoV2(entry, proto, destHost, destPort) {
 				return CheckResult{Allowed: true, ReasonCode: "OK"}
 			}
 		}
+		// An address the resolver handed out is checked as its host.
+		if ip := net.ParseIP(destHost); ip != nil {
+			if host := p.pinnedHost(ip); host != "" {
+				return p.check(proto, host, destPort)
+			}
+		}
 		return CheckResult{Allowed: false, ReasonCode: "NOT_IN_ALLOWLIST"}
 
 	default:
@@ -77,6 +145,117 @@ func (p *Policy) Check(destHost string, destPort int) CheckResult {
 	}
 }
 
+// AllowedPorts reports which TCP ports destHost may be reached on: any port
+// (unrestricted mode), the ports of matching allowlist entries, or none.
+func (p *Policy) AllowedPorts(destHost string) (ports []netpolicy.PortRange, anyPort bool) {
+	if p.Expired() {
+		return nil, false
+	}
+	switch p.mode {
+	case "unrestricted":
+		return nil, true
+	case "allowlist":
+		for _, entry := range p.entries {
+			if entry.Proto == netpolicy.ProtoTCP && netpolicy.MatchHostV2(entry, destHost) && !slices.Contains(ports, entry.Ports) {
+				ports = append(ports, entry.Ports)
+			}
+		}
+	}
+	return ports, false
+}
+
+// HostEntries returns the allowlist entries, of either protocol, whose
+// target is destHost (none outside allowlist mode or once the grant has
+// expired).
+func (p *Policy) HostEntries(destHost string) []netpolicy.AllowlistEntryV2 {
+	if p.Expired() || p.mode != "allowlist" {
+		return nil
+	}
+	var entries []netpolicy.AllowlistEntryV2
+	for _, entry := range p.entries {
+		if netpolicy.MatchHostV2(entry, destHost) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// PrefixEntries returns the allowlist entries whose target is an IP address
+// or CIDR. The resolver never sees them, so enforcement outside the proxy
+// (tap-mode nftables) opens them up front.
+func (p *Policy) PrefixEntries() []netpolicy.AllowlistEntryV2 {
+	if p.Expired() || p.mode != "allowlist" {
+		return nil
+	}
+	var entries []netpolicy.AllowlistEntryV2
+	for _, entry := range p.entries {
+		if entry.Prefix.IsValid() {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// allowsUDP reports whether a "/udp" allowlist entry (or unrestricted mode)
+// lets destHost receive datagrams on some port.
+func (p *Policy) allowsUDP(destHost string) bool {
+	if p.Expired() {
+		return false
+	}
+	switch p.mode {
+	case "unrestricted":
+		return true
+	case "allowlist":
+		for _, entry := range p.entries {
+			if entry.Proto == netpolicy.ProtoUDP && netpolicy.MatchHostV2(entry, destHost) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// privateAllowed reports whether proto traffic to destHost:destPort (any
+// protocol if proto is empty, any port if destPort is 0) matches an
+// allowlist V2 "private:" entry, so that it may resolve to opt-in private
+// addresses (netpolicy.IsOptInPrivateIP). A pinned address is checked as
+// its host.
+func (p *Policy) privateAllowed(proto, destHost string, destPort int) bool {
+	if p.mode != "allowlist" {
+		return false
+	}
+	for _, entry := range p.entries {
+		if entry.Private && (proto == "" || entry.Proto == proto) &&
+			netpolicy.MatchHostV2(entry, destHost) && (destPort == 0 || entry.Ports.Contains(destPort)) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(destHost); ip != nil {
+		if host := p.pinnedHost(ip); host != "" {
+			return p.privateAllowed(proto, host, destPort)
+		}
+	}
+	return false
+}
+
+// routable reports whether ip may be dialed for proto traffic to
+// destHost:destPort: public addresses always, opt-in private ones only
+// under a "private:" entry.
+func (p *Policy) routable(ip net.IP, proto, destHost string, destPort int) bool {
+	if !netpolicy.IsPrivateIP(ip) {
+		return true
+	}
+	return netpolicy.IsOptInPrivateIP(ip) && p.privateAllowed(proto, destHost, destPort)
+}
+
+// lookup resolves destHost; an IP literal resolves to itself.
+func (p *Policy) lookup(destHost string) ([]net.IP, error) {
+	if ip := net.ParseIP(strings.Trim(destHost, "[]")); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	return p.lookupIP(destHost)
+}
+
 // DialError indicates that a dial was rejected or failed with a specific reason code.
 // It is used to map errors to stable audit reason codes (V1).
 type DialError struct {
@@ -88,10 +267,63 @@ type DialError struct {
 func (e *DialError) Error() string { return e.Err.Error() }
 func (e *DialError) Unwrap() error { return e.Err }
 
+// Pin records addresses the sandbox's resolver answered for host (already
+// checked routable). Later dials to host use exactly the pinned addresses,
+// so the proxy connects where the sandbox expects, and connecting to a
+// pinned address directly is checked as host.
+func (p *Policy) Pin(host string, ips []net.IP) {
+	host = normalizeHost(host)
+	p.pinMu.Lock()
+	defer p.pinMu.Unlock()
+	if p.pins == nil {
+		p.pins = make(map[string][]net.IP)
+	}
+	pinned := slices.Clone(ips)
+	for _, old := range p.pins[host] {
+		if !slices.ContainsFunc(pinned, old.Equal) {
+			pinned = append(pinned, old)
+		}
+	}
+	if len(pinned) > maxPinsPerHost {
+		pinned = pinned[:maxPinsPerHost]
+	}
+	p.pins[host] = pinned
+}
+
+func (p *Policy) pinned(host string) []net.IP {
+	p.pinMu.Lock()
+	defer p.pinMu.Unlock()
+	return p.pins[normalizeHost(host)]
+}
+
+func (p *Policy) pinnedHost(ip net.IP) string {
+	p.pinMu.Lock()
+	defer p.pinMu.Unlock()
+	for host, ips := range p.pins {
+		if slices.ContainsFunc(ips, ip.Equal) {
+			return host
+		}
+	}
+	return ""
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
 // ResolveAndCheck performs DNS resolution and validates that all resolved IPs
-// are routable (non-private). Returns the first usable IP.
+// are routable (non-private, unless a "private:" entry for destHost opts in).
+// Returns the first usable IP, preferring the addresses pinned by the
+// sandbox's resolver.
 func (p *Policy) ResolveAndCheck(destHost string) (net.IP, error) {
-	ips, err := p.lookupIP(destHost)
+	return p.resolveAndCheck("", destHost, 0)
+}
+
+func (p *Policy) resolveAndCheck(proto, destHost string, destPort int) (net.IP, error) {
+	if pinned := p.pinned(destHost); len(pinned) > 0 {
+		return pinned[0], nil
+	}
+	ips, err := p.lookup(destHost)
 	if err != nil {
 		return nil, &DialError{
 			ReasonCode: "DNS_DENIED",
@@ -100,7 +332,7 @@ func (p *Policy) ResolveAndCheck(destHost string) (net.IP, error) {
 	}
 
 	for _, ip := range ips {
-		if !netpolicy.IsPrivateIP(ip) {
+		if p.routable(ip, proto, destHost, destPort) {
 			return ip, nil
 		}
 	}
@@ -116,10 +348,52 @@ func (p *Policy) ResolveAndCheck(destHost string) (net.IP, error) {
 	}
 }
 
+// ResolveRoutable resolves destHost and returns its routable addresses (as
+// in ResolveAndCheck), for callers that pin every address rather than dial
+// one.
+func (p *Policy) ResolveRoutable(destHost string) ([]net.IP, error) {
+	ips, err := p.lookupIP(destHost)
+	if err != nil {
+		return nil, &DialError{
+			ReasonCode: "DNS_DENIED",
+			Err:        fmt.Errorf("DNS lookup for %s failed: %w", destHost, err),
+		}
+	}
+	var routable []net.IP
+	for _, ip := range ips {
+		if p.routable(ip, "", destHost, 0) {
+			routable = append(routable, ip)
+		}
+	}
+	if len(routable) == 0 {
+		var firstIP string
+		if len(ips) > 0 {
+			firstIP = ips[0].String()
+		}
+		return nil, &DialError{
+			ReasonCode: "DNS_DENIED",
+			ResolvedIP: firstIP,
+			Err:        fmt.Errorf("all resolved IPs for %s are private/non-routable", destHost),
+		}
+	}
+	return routable, nil
+}
+
 // DialChecked resolves the hostname, validates the resolved IP, and dials.
 // Returns the connection and the resolved IP string (for audit logging).
 func (p *Policy) DialChecked(destHost string, destPort int) (net.Conn, string, error) {
-	ip, err := p.ResolveAndCheck(destHost)
+	return p.dialChecked(netpolicy.ProtoTCP, destHost, destPort)
+}
+
+// DialUDPChecked is DialChecked for UDP: the returned connection is a
+// connected UDP socket, which only receives datagrams from the resolved
+// address.
+func (p *Policy) DialUDPChecked(destHost string, destPort int) (net.Conn, string, error) {
+	return p.dialChecked(netpolicy.ProtoUDP, destHost, destPort)
+}
+
+func (p *Policy) dialChecked(proto, destHost string, destPort int) (net.Conn, string, error) {
+	ip, err := p.resolveAndCheck(proto, destHost, destPort)
 	if err != nil {
 		var de *DialError
 		if errors.As(err, &de) {
@@ -131,7 +405,7 @@ func (p *Policy) DialChecked(destHost string, destPort int) (net.Conn, string, e
 	// FIX C1: use net.JoinHostPort for correct IPv6 address formatting
 	// (e.g., "[2001:db8::1]:443" instead of "2001:db8::1:443").
 	addr := net.JoinHostPort(ip.String(), strconv.Itoa(destPort))
-	conn, err := p.dialTimeout("tcp", addr, dialTimeout)
+	conn, err := p.dialTimeout(proto, addr, dialTimeout)
 	if err != nil {
 		return nil, ip.String(), &DialError{
 			ReasonCode: "OTHER",
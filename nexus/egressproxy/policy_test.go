@@ -1,8 +1,13 @@
 package egressproxy
 
 import (
+	"errors"
+	"net"
+	"slices"
 	"testing"
+	"time"
 
+	"cybros.ai/nexus/netpolicy"
 	"cybros.ai/nexus/protocol"
 )
 
@@ -86,3 +91,212 @@ func TestNewPolicy_InvalidEntry(t *testing.T) {
 		t.Fatal("expected error for invalid entry")
 	}
 }
+
+func TestPolicy_AllowedPorts(t *testing.T) {
+	p, _ := NewPolicy(&protocol.NetCapabilityV1{
+		Mode:  "allowlist",
+		Allow: []string{"github.com:443", "github.com:22", "*.npmjs.org:443"},
+	})
+	if ports, anyPort := p.AllowedPorts("GitHub.com."); anyPort || !slices.Equal(ports, []netpolicy.PortRange{{From: 443, To: 443}, {From: 22, To: 22}}) {
+		t.Errorf("github.com: ports=%v any=%v", ports, anyPort)
+	}
+	if ports, _ := p.AllowedPorts("registry.npmjs.org"); !slices.Equal(ports, []netpolicy.PortRange{{From: 443, To: 443}}) {
+		t.Errorf("registry.npmjs.org: ports=%v", ports)
+	}
+	if ports, anyPort := p.AllowedPorts("evil.com"); anyPort || len(ports) != 0 {
+		t.Errorf("evil.com: ports=%v any=%v", ports, anyPort)
+	}
+
+	unrestricted, _ := NewPolicy(&protocol.NetCapabilityV1{Mode: "unrestricted"})
+	if _, anyPort := unrestricted.AllowedPorts("evil.com"); !anyPort {
+		t.Error("unrestricted should allow any port")
+	}
+	none, _ := NewPolicy(nil)
+	if ports, anyPort := none.AllowedPorts("github.com"); anyPort || len(ports) != 0 {
+		t.Errorf("none: ports=%v any=%v", ports, anyPort)
+	}
+}
+
+func TestPolicy_ResolveRoutable(t *testing.T) {
+	p, _ := NewPolicy(&protocol.NetCapabilityV1{Mode: "unrestricted"})
+	p.lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "mixed.example":
+			return []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("93.184.216.34"), net.ParseIP("93.184.216.35")}, nil
+		case "private.example":
+			return []net.IP{net.ParseIP("192.168.1.1")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	ips, err := p.ResolveRoutable("mixed.example")
+	if err != nil || len(ips) != 2 || !ips[0].Equal(net.ParseIP("93.184.216.34")) {
+		t.Fatalf("mixed: %v %v", ips, err)
+	}
+	for _, host := range []string{"private.example", "missing.example"} {
+		var de *DialError
+		if _, err := p.ResolveRoutable(host); !errors.As(err, &de) || de.ReasonCode != "DNS_DENIED" {
+			t.Errorf("%s: err = %v, want DNS_DENIED", host, err)
+		}
+	}
+}
+
+func TestPolicy_GrantTTL(t *testing.T) {
+	p, err := NewPolicy(&protocol.NetCapabilityV1{
+		Mode:       "allowlist",
+		Allow:      []string{"pypi.org:443"},
+		TTLSeconds: 300,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := p.ExpiresAt().Add(-300 * time.Second)
+	p.now = func() time.Time { return start.Add(299 * time.Second) }
+	if r := p.Check("pypi.org", 443); !r.Allowed || p.Expired() {
+		t.Errorf("before TTL: %+v", r)
+	}
+
+	p.now = func() time.Time { return start.Add(300 * time.Second) }
+	if r := p.Check("pypi.org", 443); r.Allowed || r.ReasonCode != "GRANT_EXPIRED" {
+		t.Errorf("after TTL: %+v", r)
+	}
+	if ports, anyPort := p.AllowedPorts("pypi.org"); len(ports) != 0 || anyPort {
+		t.Errorf("after TTL: ports = %v, any = %v", ports, anyPort)
+	}
+
+	none, _ := NewPolicy(&protocol.NetCapabilityV1{Mode: "none", TTLSeconds: 300})
+	if !none.ExpiresAt().IsZero() {
+		t.Error("mode none has no grant to expire")
+	}
+	open, _ := NewPolicy(&protocol.NetCapabilityV1{Mode: "unrestricted"})
+	if !open.ExpiresAt().IsZero() {
+		t.Error("no TTL should mean no expiry")
+	}
+}
+
+func TestPolicy_AllowlistV2(t *testing.T) {
+	p, err := NewPolicy(&protocol.NetCapabilityV1{
+		Mode:             "allowlist",
+		AllowlistVersion: netpolicy.AllowlistV2,
+		Allow: []string{
+			"private:10.20.0.0/16:443",
+			"private:pypi.corp.example:443",
+			"mirror.example.com:8000-8100",
+			"93.184.216.0/24:443",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "pypi.corp.example", "mirror.example.com":
+			return []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("10.30.0.5")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	var dialed []string
+	p.dialTimeout = func(_, addr string, _ time.Duration) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		c, _ := net.Pipe()
+		return c, nil
+	}
+
+	for _, tc := range []struct {
+		host    string
+		port    int
+		allowed bool
+	}{
+		{"10.20.1.2", 443, true},
+		{"10.21.1.2", 443, false},
+		{"93.184.216.34", 443, true},
+		{"mirror.example.com", 8080, true},
+		{"mirror.example.com", 8101, false},
+	} {
+		if r := p.Check(tc.host, tc.port); r.Allowed != tc.allowed {
+			t.Errorf("Check(%s:%d) = %+v", tc.host, tc.port, r)
+		}
+	}
+
+	// A private: entry opens private addresses, but never loopback.
+	if _, ip, err := p.DialChecked("pypi.corp.example", 443); err != nil || ip != "10.30.0.5" {
+		t.Errorf("private opt-in: ip = %q, err = %v", ip, err)
+	}
+	if _, ip, err := p.DialChecked("10.20.1.2", 443); err != nil || ip != "10.20.1.2" {
+		t.Errorf("private CIDR: ip = %q, err = %v", ip, err)
+	}
+	var de *DialError
+	if _, _, err := p.DialChecked("mirror.example.com", 8080); !errors.As(err, &de) || de.ReasonCode != "DNS_DENIED" {
+		t.Errorf("no opt-in: err = %v, want DNS_DENIED", err)
+	}
+	if ips, err := p.ResolveRoutable("pypi.corp.example"); err != nil || len(ips) != 1 || ips[0].String() != "10.30.0.5" {
+		t.Errorf("ResolveRoutable = %v, %v", ips, err)
+	}
+	if !slices.Equal(dialed, []string{"10.30.0.5:443", "10.20.1.2:443"}) {
+		t.Errorf("dialed %v", dialed)
+	}
+
+	if ports, _ := p.AllowedPorts("mirror.example.com"); !slices.Equal(ports, []netpolicy.PortRange{{From: 8000, To: 8100}}) {
+		t.Errorf("AllowedPorts = %v", ports)
+	}
+	if entries := p.HostEntries("pypi.corp.example"); len(entries) != 1 || !entries[0].Private {
+		t.Errorf("HostEntries = %+v", entries)
+	}
+	var prefixes []string
+	for _, e := range p.PrefixEntries() {
+		prefixes = append(prefixes, e.String())
+	}
+	if !slices.Equal(prefixes, []string{"private:10.20.0.0/16:443", "93.184.216.0/24:443"}) {
+		t.Errorf("PrefixEntries = %v", prefixes)
+	}
+
+	if _, err := NewPolicy(&protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"10.20.0.0/16:443"}}); err == nil {
+		t.Error("V1 capability accepted a CIDR entry")
+	}
+}
+
+func TestPolicy_CheckUDP(t *testing.T) {
+	p, err := NewPolicy(&protocol.NetCapabilityV1{
+		Mode:             "allowlist",
+		AllowlistVersion: netpolicy.AllowlistV2,
+		Allow:            []string{"quic.example.com:443/udp", "github.com:443", "private:ntp.corp.example:123/udp"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		host     string
+		port     int
+		tcp, udp bool
+	}{
+		{"quic.example.com", 443, false, true},
+		{"github.com", 443, true, false},
+		{"quic.example.com", 444, false, false},
+	} {
+		if got := p.Check(tc.host, tc.port).Allowed; got != tc.tcp {
+			t.Errorf("Check(%s:%d) = %v", tc.host, tc.port, got)
+		}
+		if got := p.CheckUDP(tc.host, tc.port).Allowed; got != tc.udp {
+			t.Errorf("CheckUDP(%s:%d) = %v", tc.host, tc.port, got)
+		}
+	}
+	if ports, _ := p.AllowedPorts("quic.example.com"); len(ports) != 0 {
+		t.Errorf("AllowedPorts = %v, want no TCP ports", ports)
+	}
+
+	p.lookupIP = func(string) ([]net.IP, error) { return []net.IP{net.ParseIP("10.30.0.7")}, nil }
+	var network string
+	p.dialTimeout = func(n, _ string, _ time.Duration) (net.Conn, error) {
+		network = n
+		c, _ := net.Pipe()
+		return c, nil
+	}
+	if _, ip, err := p.DialUDPChecked("ntp.corp.example", 123); err != nil || ip != "10.30.0.7" || network != "udp" {
+		t.Errorf("DialUDPChecked = %q, %v over %q", ip, err, network)
+	}
+	// The "private:" opt-in is per protocol.
+	var de *DialError
+	if _, _, err := p.DialChecked("ntp.corp.example", 123); !errors.As(err, &de) || de.ReasonCode != "DNS_DENIED" {
+		t.Errorf("TCP dial = %v, want DNS_DENIED", err)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,8 +26,29 @@ type Proxy struct {
 	listener net.Listener
 	server   *http.Server
 	wg       sync.WaitGroup
+	limits   *limiter
+
+	// intercept injects credentials (nil: every tunnel is spliced).
+	intercept *Intercept
+	// cache serves registry responses (nil: none).
+	cache *CachePartition
+
+	// live holds the connections open under the policy's grant, closed
+	// when it expires (see expireGrant).
+	liveMu  sync.Mutex
+	live    map[*liveConn]struct{}
+	expired bool
 }
 
+// liveConn is an egress connection open under the policy's grant.
+type liveConn struct {
+	event AuditEvent
+	stop  func()
+}
+
+// errGrantExpired cancels forwarded HTTP requests when the grant expires.
+var errGrantExpired = errors.New("egress grant expired")
+
 // New creates a proxy that listens on the given UDS path.
 func New(socketPath string, policy *Policy, audit *AuditLogger) (*Proxy, error) {
 	listener, err := net.Listen("unix", socketPath)
@@ -42,6 +64,7 @@ func NewFromListener(listener net.Listener, policy *Policy, audit *AuditLogger)
 		policy:   policy,
 		audit:    audit,
 		listener: listener,
+		limits:   newLimiter(Limits{}, nil),
 	}
 	p.server = &http.Server{
 		Handler:           p,
@@ -50,6 +73,25 @@ func NewFromListener(listener net.Listener, policy *Policy, audit *AuditLogger)
 	return p
 }
 
+// SetLimits applies per-directive egress limits, counted in metrics (nil:
+// not exported). Must be called before Serve.
+func (p *Proxy) SetLimits(lim Limits, metrics *Metrics) {
+	p.limits = newLimiter(lim, metrics)
+}
+
+// SetIntercept has the proxy terminate TLS for, and inject credentials
+// into, the destinations i holds credentials for. Must be called before
+// Serve.
+func (p *Proxy) SetIntercept(i *Intercept) {
+	p.intercept = i
+}
+
+// SetCache sets the cache partition the proxy reads and fills (nil: none).
+// Must be called before Serve.
+func (p *Proxy) SetCache(c *CachePartition) {
+	p.cache = c
+}
+
 // Serve starts the proxy. Blocks until the context is canceled.
 // It supports HTTP proxy (absolute-form + CONNECT) and SOCKS5 on the same listener.
 func (p *Proxy) Serve(ctx context.Context) error {
@@ -63,6 +105,11 @@ func (p *Proxy) Serve(ctx context.Context) error {
 		httpListener.Close()
 	}()
 
+	if exp := p.policy.ExpiresAt(); !exp.IsZero() {
+		t := time.AfterFunc(time.Until(exp), p.expireGrant)
+		defer t.Stop()
+	}
+
 	var acceptErr error
 	for {
 		conn, err := p.listener.Accept()
@@ -231,10 +278,23 @@ func (p *Proxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("egress denied: %s", result.ReasonCode), http.StatusForbidden)
 		return
 	}
+	release, reason := p.limits.admit()
+	if reason != "" {
+		p.audit.Log(AuditEvent{
+			DestHost:   destHost,
+			DestPort:   destPort,
+			Decision:   "deny",
+			ReasonCode: reason,
+			Method:     "CONNECT",
+		})
+		http.Error(w, fmt.Sprintf("egress denied: %s", reason), http.StatusForbidden)
+		return
+	}
 
 	// Resolve DNS and dial (proxy-side resolution prevents DNS rebinding)
-	targetConn, resolvedIP, dialErr := p.policy.DialChecked(destHost, destPort)
+	conn, resolvedIP, dialErr := p.policy.DialChecked(destHost, destPort)
 	if dialErr != nil {
+		release()
 		reasonCode := "OTHER"
 		var de *DialError
 		if errors.As(dialErr, &de) && de.ReasonCode != "" {
@@ -260,15 +320,7 @@ func (p *Proxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "connection failed", status)
 		return
 	}
-
-	p.audit.Log(AuditEvent{
-		DestHost:   destHost,
-		DestPort:   destPort,
-		ResolvedIP: resolvedIP,
-		Decision:   "allow",
-		ReasonCode: "OK",
-		Method:     "CONNECT",
-	})
+	targetConn := p.limits.wrap(conn, release)
 
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
@@ -277,7 +329,7 @@ func (p *Proxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	clientConn, _, err := hijacker.Hijack()
+	clientConn, clientBuf, err := hijacker.Hijack()
 	if err != nil {
 		targetConn.Close()
 		slog.Error("egress proxy: hijack failed", "error", err)
@@ -290,16 +342,136 @@ func (p *Proxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 	p.wg.Add(1)
 	go func() {
 		defer p.wg.Done()
-		defer clientConn.Close()
-		defer targetConn.Close()
+		p.tunnel(clientConn, clientBuf.Reader, targetConn, AuditEvent{
+			DestHost:   destHost,
+			DestPort:   destPort,
+			ResolvedIP: resolvedIP,
+			Method:     "CONNECT",
+		})
+	}()
+}
 
-		// wait for BOTH copy directions to prevent goroutine leak
-		done := make(chan struct{}, 2)
-		go func() { io.Copy(targetConn, clientConn); done <- struct{}{} }()
-		go func() { io.Copy(clientConn, targetConn); done <- struct{}{} }()
+// tunnel splices an established tunnel and logs its audit event. A TLS
+// ClientHello is checked against the destination first (CheckSNI); its SNI
+// is recorded, and a mismatch closes the tunnel before any client bytes
+// reach the target. Target bytes flow from the start, so server-first
+// protocols are unaffected. A tunnel cut by the transfer limit is logged
+// again as a deny. Tunnels to a destination with a credential are served
+// by interceptTunnel instead; those to a plain HTTP origin with HTTP rules
+// are refused, as the rules only hold for requests the proxy parses.
+func (p *Proxy) tunnel(clientConn net.Conn, br *bufio.Reader, targetConn *meteredConn, event AuditEvent) {
+	if cred, ok := p.intercept.credential(event.DestHost, event.DestPort); ok {
+		p.interceptTunnel(clientConn, br, targetConn, event, cred)
+		return
+	}
+	defer clientConn.Close()
+	defer targetConn.Close()
+	if p.policy.httpRules.Covers("http", event.DestHost, event.DestPort) {
+		event.Decision = "deny"
+		event.ReasonCode = "HTTP_RULE_DENIED"
+		p.audit.Log(event)
+		return
+	}
+
+	// Manage connection lifetime explicitly: when first copy finishes,
+	// close both sides to unblock the other direction (prevents goroutine leak).
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(clientConn, targetConn); done <- struct{}{} }()
+
+	hello, err := peekClientHello(clientConn, br)
+	event.SNI = hello.sni
+	result := CheckResult{Allowed: true, ReasonCode: "OK"}
+	switch {
+	case !hello.tls:
+	case err != nil:
+		result = CheckResult{Allowed: false, ReasonCode: "OTHER"} // incomplete ClientHello
+	case !hello.parsed && p.policy.sni != sniOff:
+		result = CheckResult{Allowed: false, ReasonCode: "SNI_MISMATCH"} // unverifiable
+	default:
+		result = p.policy.CheckSNI(event.DestHost, event.DestPort, hello.sni)
+	}
+	if !result.Allowed {
+		event.Decision = "deny"
+		event.ReasonCode = result.ReasonCode
+		p.audit.Log(event)
+		targetConn.Close()
+		clientConn.Close()
 		<-done
+		return
+	}
+	untrack, ok := p.track(event, func() {
+		targetConn.Close()
+		clientConn.Close()
+	})
+	if !ok {
+		event.Decision = "deny"
+		event.ReasonCode = "GRANT_EXPIRED"
+		p.audit.Log(event)
+		targetConn.Close()
+		clientConn.Close()
 		<-done
+		return
+	}
+	defer untrack()
+	event.Decision = "allow"
+	event.ReasonCode = "OK"
+	p.audit.Log(event)
+
+	go func() {
+		if _, err := targetConn.Write(hello.raw); err == nil {
+			io.Copy(targetConn, br)
+		}
+		done <- struct{}{}
 	}()
+	<-done
+	targetConn.Close()
+	clientConn.Close()
+	<-done
+	if reason := targetConn.cutBy(); reason != "" {
+		event.Decision = "deny"
+		event.ReasonCode = reason
+		p.audit.Log(event)
+	}
+}
+
+// track registers an egress connection to be closed by expireGrant, and
+// returns the func that unregisters it. It reports false, registering
+// nothing, once the grant has expired.
+func (p *Proxy) track(event AuditEvent, stop func()) (untrack func(), ok bool) {
+	p.liveMu.Lock()
+	defer p.liveMu.Unlock()
+	if p.expired {
+		return nil, false
+	}
+	if p.live == nil {
+		p.live = make(map[*liveConn]struct{})
+	}
+	c := &liveConn{event: event, stop: stop}
+	p.live[c] = struct{}{}
+	return func() {
+		p.liveMu.Lock()
+		delete(p.live, c)
+		p.liveMu.Unlock()
+	}, true
+}
+
+// expireGrant ends the policy's time-boxed grant: every connection opened
+// under it is closed and logged with GRANT_EXPIRED. New connections are
+// already denied by Policy.Check.
+func (p *Proxy) expireGrant() {
+	p.liveMu.Lock()
+	p.expired = true
+	live := p.live
+	p.live = nil
+	p.liveMu.Unlock()
+
+	for c := range live {
+		c.stop()
+		event := c.event
+		event.Decision = "deny"
+		event.ReasonCode = "GRANT_EXPIRED"
+		p.audit.Log(event)
+	}
 }
 
 // hopByHopHeaders are headers that must not be forwarded by a proxy (RFC 2616 §13.5.1).
@@ -361,17 +533,96 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("egress denied: %s", result.ReasonCode), http.StatusForbidden)
 		return
 	}
+	rule := p.policy.CheckHTTP(r.Method, "http", destHost, destPort, r.URL.EscapedPath())
+	if !rule.Allowed {
+		p.audit.Log(AuditEvent{
+			DestHost:   destHost,
+			DestPort:   destPort,
+			Decision:   "deny",
+			ReasonCode: rule.ReasonCode,
+			Method:     "HTTP",
+			HTTPRule:   rule.HTTPRule,
+		})
+		http.Error(w, fmt.Sprintf("egress denied: %s", rule.ReasonCode), http.StatusForbidden)
+		return
+	}
+
+	cred, inject := p.intercept.credential(destHost, destPort)
+	cached := p.cache.request(r, "http", destHost, destPort, cred)
+	hit, cacheResult := cached.lookup()
+	if hit != nil {
+		defer hit.Body.Close()
+		p.audit.Log(AuditEvent{
+			DestHost:   destHost,
+			DestPort:   destPort,
+			Decision:   "allow",
+			ReasonCode: "OK",
+			Method:     "HTTP",
+			HTTPRule:   rule.HTTPRule,
+			Cache:      cacheResult,
+		})
+		for k, vv := range hit.Header {
+			w.Header()[k] = vv
+		}
+		w.WriteHeader(hit.StatusCode)
+		io.Copy(w, hit.Body)
+		return
+	}
+
+	release, reason := p.limits.admit()
+	if reason != "" {
+		p.audit.Log(AuditEvent{
+			DestHost:   destHost,
+			DestPort:   destPort,
+			Decision:   "deny",
+			ReasonCode: reason,
+			Method:     "HTTP",
+		})
+		http.Error(w, fmt.Sprintf("egress denied: %s", reason), http.StatusForbidden)
+		return
+	}
+	defer release()
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+	untrack, ok := p.track(AuditEvent{
+		DestHost: destHost,
+		DestPort: destPort,
+		Method:   "HTTP",
+	}, func() { cancel(errGrantExpired) })
+	if !ok {
+		p.audit.Log(AuditEvent{
+			DestHost:   destHost,
+			DestPort:   destPort,
+			Decision:   "deny",
+			ReasonCode: "GRANT_EXPIRED",
+			Method:     "HTTP",
+		})
+		http.Error(w, "egress denied: GRANT_EXPIRED", http.StatusForbidden)
+		return
+	}
+	defer untrack()
+	r = r.WithContext(ctx)
 
 	// strip hop-by-hop headers before forwarding
 	for _, h := range hopByHopHeaders {
 		r.Header.Del(h)
 	}
+	if inject {
+		injectCredential(r.Header, cred)
+	}
 
 	// use DisableKeepAlives and close idle connections to prevent transport leak
+	var upstream atomic.Pointer[meteredConn]
 	transport := &http.Transport{
 		DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
 			conn, _, dialErr := p.policy.DialChecked(destHost, destPort)
-			return conn, dialErr
+			if dialErr != nil {
+				return nil, dialErr
+			}
+			c := p.limits.wrap(conn, nil)
+			upstream.Store(c)
+			return c, nil
 		},
 		ResponseHeaderTimeout: 30 * time.Second,
 		DisableKeepAlives:     true,
@@ -381,6 +632,10 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	r.RequestURI = ""
 	resp, err := transport.RoundTrip(r)
 	if err != nil {
+		if context.Cause(ctx) == errGrantExpired {
+			http.Error(w, "egress denied: GRANT_EXPIRED", http.StatusForbidden)
+			return // logged by expireGrant
+		}
 		reasonCode := "OTHER"
 		resolvedIP := ""
 		var de *DialError
@@ -388,6 +643,9 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 			reasonCode = de.ReasonCode
 			resolvedIP = de.ResolvedIP
 		}
+		if c := upstream.Load(); c != nil && c.cutBy() != "" {
+			reasonCode = c.cutBy()
+		}
 
 		p.audit.Log(AuditEvent{
 			DestHost:   destHost,
@@ -405,14 +663,18 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "upstream error", status)
 		return
 	}
+	cached.store(resp)
 	defer resp.Body.Close()
 
 	p.audit.Log(AuditEvent{
-		DestHost:   destHost,
-		DestPort:   destPort,
-		Decision:   "allow",
-		ReasonCode: "OK",
-		Method:     "HTTP",
+		DestHost:           destHost,
+		DestPort:           destPort,
+		Decision:           "allow",
+		ReasonCode:         "OK",
+		Method:             "HTTP",
+		CredentialInjected: inject,
+		HTTPRule:           rule.HTTPRule,
+		Cache:              cacheResult,
 	})
 
 	// strip hop-by-hop headers from response
@@ -425,8 +687,20 @@ func (p *Proxy) handleHTTP(w http.ResponseWriter, r *http.Request) {
 	for _, h := range hopByHopHeaders {
 		respHeader.Del(h)
 	}
+	if inject {
+		stripCredential(respHeader, cred)
+	}
 	w.WriteHeader(resp.StatusCode)
 	io.Copy(w, resp.Body)
+	if c := upstream.Load(); c != nil && c.cutBy() != "" {
+		p.audit.Log(AuditEvent{
+			DestHost:   destHost,
+			DestPort:   destPort,
+			Decision:   "deny",
+			ReasonCode: c.cutBy(),
+			Method:     "HTTP",
+		})
+	}
 }
 
 // splitHostPort parses "host:port" with a default port of 443.
@@ -506,6 +780,10 @@ func (p *Proxy) handleSOCKS5(conn net.Conn, br *bufio.Reader) {
 	cmd := reqHeader[1]
 	atyp := reqHeader[3]
 
+	if cmd == socks5CmdUDPAssociate {
+		p.udpAssociate(conn, br, atyp)
+		return
+	}
 	if cmd != socks5CmdConnect {
 		p.audit.Log(AuditEvent{
 			Decision:   "deny",
@@ -541,9 +819,22 @@ func (p *Proxy) handleSOCKS5(conn net.Conn, br *bufio.Reader) {
 		_ = writeSOCKS5Reply(conn, 0x02) // Connection not allowed by ruleset
 		return
 	}
+	release, reason := p.limits.admit()
+	if reason != "" {
+		p.audit.Log(AuditEvent{
+			DestHost:   destHost,
+			DestPort:   destPort,
+			Decision:   "deny",
+			ReasonCode: reason,
+			Method:     "SOCKS5",
+		})
+		_ = writeSOCKS5Reply(conn, 0x02) // Connection not allowed by ruleset
+		return
+	}
 
-	targetConn, resolvedIP, dialErr := p.policy.DialChecked(destHost, destPort)
+	upstream, resolvedIP, dialErr := p.policy.DialChecked(destHost, destPort)
 	if dialErr != nil {
+		release()
 		reasonCode := "OTHER"
 		var de *DialError
 		if errors.As(dialErr, &de) && de.ReasonCode != "" {
@@ -564,32 +855,18 @@ func (p *Proxy) handleSOCKS5(conn net.Conn, br *bufio.Reader) {
 		_ = writeSOCKS5Reply(conn, 0x02) // Connection not allowed by ruleset
 		return
 	}
-	p.audit.Log(AuditEvent{
-		DestHost:   destHost,
-		DestPort:   destPort,
-		ResolvedIP: resolvedIP,
-		Decision:   "allow",
-		ReasonCode: "OK",
-		Method:     "SOCKS5",
-	})
-
+	targetConn := p.limits.wrap(upstream, release)
 	if err := writeSOCKS5Reply(conn, 0x00); err != nil {
 		targetConn.Close()
 		return
 	}
 
-	// Use the bufio.Reader-backed conn to avoid losing buffered bytes.
-	clientConn := &preReadConn{Conn: conn, r: br}
-
-	// Manage connection lifetime explicitly: when first copy finishes,
-	// close both sides to unblock the other direction (prevents goroutine leak).
-	done := make(chan struct{}, 2)
-	go func() { io.Copy(targetConn, clientConn); done <- struct{}{} }()
-	go func() { io.Copy(clientConn, targetConn); done <- struct{}{} }()
-	<-done
-	targetConn.Close()
-	conn.Close()
-	<-done
+	p.tunnel(conn, br, targetConn, AuditEvent{
+		DestHost:   destHost,
+		DestPort:   destPort,
+		ResolvedIP: resolvedIP,
+		Method:     "SOCKS5",
+	})
 }
 
 func readSOCKS5DomainDest(r *bufio.Reader, atyp byte) (host string, port int, _ error) {
@@ -608,13 +885,8 @@ func readSOCKS5DomainDest(r *bufio.Reader, atyp byte) (host string, port int, _
 			return "", 0, err
 		}
 		host = string(hostBytes)
-		if strings.TrimSpace(host) != host {
-			return "", 0, errors.New("host contains whitespace")
-		}
-		// Reject null bytes, control characters, and non-DNS characters
-		// to prevent SSRF via DNS resolver edge cases.
-		if strings.ContainsAny(host, "\x00\n\r\t /\\@#?") {
-			return "", 0, errors.New("host contains invalid characters")
+		if err := checkSOCKS5Domain(host); err != nil {
+			return "", 0, err
 		}
 
 	default:
@@ -1,6 +1,7 @@
 package egressproxy
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/rand"
@@ -34,43 +35,149 @@ func tempSocketPath(t *testing.T) string {
 	return path
 }
 
-func startTestProxy(t *testing.T, cap *protocol.NetCapabilityV1) (*Proxy, string, *bytes.Buffer) {
+// testProxyConfig collects startTestProxy options.
+type testProxyConfig struct {
+	upstream  string
+	tcp       bool
+	limits    *Limits
+	intercept *Intercept
+	cache     *CachePartition
+	setup     func(*Policy)
+}
+
+type testProxyOption func(*testProxyConfig)
+
+// withUpstream resolves every name to 140.82.112.3 and lands every dial,
+// TCP or UDP, on addr.
+func withUpstream(addr string) testProxyOption {
+	return func(c *testProxyConfig) { c.upstream = addr }
+}
+
+// withTCP serves on a 127.0.0.1 listener instead of a Unix socket.
+func withTCP() testProxyOption {
+	return func(c *testProxyConfig) { c.tcp = true }
+}
+
+func withLimits(lim Limits) testProxyOption {
+	return func(c *testProxyConfig) { c.limits = &lim }
+}
+
+// withIntercept injects i's credentials (nil intercepts nothing).
+func withIntercept(i *Intercept) testProxyOption {
+	return func(c *testProxyConfig) { c.intercept = i }
+}
+
+func withCache(p *CachePartition) testProxyOption {
+	return func(c *testProxyConfig) { c.cache = p }
+}
+
+// withPolicy adjusts the policy before the proxy starts.
+func withPolicy(setup func(*Policy)) testProxyOption {
+	return func(c *testProxyConfig) { c.setup = setup }
+}
+
+// startTestProxy starts a proxy for cap (nil denies everything) and returns
+// its address: a socket path, or host:port with withTCP. stop shuts the
+// proxy down (once its clients have disconnected), after which the audit
+// buffer is safe to read.
+func startTestProxy(t *testing.T, cap *protocol.NetCapabilityV1, opts ...testProxyOption) (addr string, audit *bytes.Buffer, stop func()) {
 	t.Helper()
-	socketPath := tempSocketPath(t)
+	var cfg testProxyConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
 	policy, err := NewPolicy(cap)
 	if err != nil {
 		t.Fatalf("NewPolicy: %v", err)
 	}
+	if cfg.upstream != "" {
+		policy.lookupIP = func(string) ([]net.IP, error) {
+			return []net.IP{net.ParseIP("140.82.112.3")}, nil
+		}
+		policy.dialTimeout = func(network, _ string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(network, cfg.upstream, timeout)
+		}
+	}
+	if cfg.setup != nil {
+		cfg.setup(policy)
+	}
+	if err := policy.checkIntercept(cfg.intercept); err != nil {
+		t.Fatal(err)
+	}
 
-	var auditBuf bytes.Buffer
-	audit := NewAuditLogger(&auditBuf, "test-directive")
-
-	proxy, err := New(socketPath, policy, audit)
-	if err != nil {
-		t.Fatalf("New proxy: %v", err)
+	audit = &bytes.Buffer{}
+	logger := NewAuditLogger(audit, "test-directive")
+	var proxy *Proxy
+	if cfg.tcp {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = ln.Addr().String()
+		proxy = NewFromListener(ln, policy, logger)
+	} else {
+		addr = tempSocketPath(t)
+		if proxy, err = New(addr, policy, logger); err != nil {
+			t.Fatalf("New proxy: %v", err)
+		}
 	}
+	if cfg.limits != nil {
+		proxy.SetLimits(*cfg.limits, NewMetrics())
+	}
+	proxy.SetIntercept(cfg.intercept)
+	proxy.SetCache(cfg.cache)
 
 	ctx, cancel := context.WithCancel(context.Background())
-	t.Cleanup(cancel)
-
-	go proxy.Serve(ctx)
-
-	// Wait for proxy to be ready
-	for i := 0; i < 50; i++ {
-		conn, err := net.DialTimeout("unix", socketPath, 100*time.Millisecond)
-		if err == nil {
-			conn.Close()
-			break
+	served := make(chan struct{})
+	go func() {
+		proxy.Serve(ctx)
+		close(served)
+	}()
+	stop = func() {
+		cancel()
+		<-served
+	}
+	t.Cleanup(stop)
+
+	if !cfg.tcp {
+		// Wait for proxy to be ready
+		for i := 0; i < 50; i++ {
+			conn, err := net.DialTimeout("unix", addr, 100*time.Millisecond)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
 		}
-		time.Sleep(10 * time.Millisecond)
 	}
+	return addr, audit, stop
+}
 
-	return proxy, socketPath, &auditBuf
+// startRecordingServer starts a TCP server that reports everything its
+// first connection sends on received.
+func startRecordingServer(t *testing.T) (addr string, received chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received = make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- b
+	}()
+	return ln.Addr().String(), received
 }
 
 func TestProxy_CONNECT_Denied_ModeNone(t *testing.T) {
-	_, socketPath, _ := startTestProxy(t, nil) // nil = deny all
+	socketPath, _, _ := startTestProxy(t, nil) // nil = deny all
 
 	conn, err := net.Dial("unix", socketPath)
 	if err != nil {
@@ -93,7 +200,7 @@ func TestProxy_CONNECT_Denied_NotInAllowlist(t *testing.T) {
 		Mode:  "allowlist",
 		Allow: []string{"github.com:443"},
 	}
-	_, socketPath, _ := startTestProxy(t, cap)
+	socketPath, _, _ := startTestProxy(t, cap)
 
 	conn, err := net.Dial("unix", socketPath)
 	if err != nil {
@@ -112,7 +219,7 @@ func TestProxy_CONNECT_Denied_NotInAllowlist(t *testing.T) {
 }
 
 func TestProxy_HTTP_Denied(t *testing.T) {
-	_, socketPath, _ := startTestProxy(t, nil)
+	socketPath, _, _ := startTestProxy(t, nil)
 
 	// Configure transport as proxy client: set Proxy so the client sends
 	// absolute-form URI (GET http://example.com/test) which our proxy needs.
@@ -146,7 +253,7 @@ func TestProxy_HTTP_Allowed_Unrestricted(t *testing.T) {
 	defer target.Close()
 
 	cap := &protocol.NetCapabilityV1{Mode: "unrestricted"}
-	_, socketPath, _ := startTestProxy(t, cap)
+	socketPath, _, _ := startTestProxy(t, cap)
 
 	proxyURL := &url.URL{Scheme: "http", Host: "proxy.test"}
 	transport := &http.Transport{
@@ -196,7 +303,7 @@ func TestProxy_HTTP_HopByHopHeadersStripped(t *testing.T) {
 	defer target.Close()
 
 	cap := &protocol.NetCapabilityV1{Mode: "unrestricted"}
-	_, socketPath, _ := startTestProxy(t, cap)
+	socketPath, _, _ := startTestProxy(t, cap)
 
 	proxyURL := &url.URL{Scheme: "http", Host: "proxy.test"}
 	transport := &http.Transport{
@@ -262,7 +369,7 @@ func TestProxy_CONNECT_BidirectionalCleanup(t *testing.T) {
 	// should return cleanly when all connections are closed, proving both
 	// goroutines were awaited.
 	cap := &protocol.NetCapabilityV1{Mode: "none"} // deny all to avoid actual connection
-	_, socketPath, _ := startTestProxy(t, cap)
+	socketPath, _, _ := startTestProxy(t, cap)
 
 	// Make several CONNECT requests that get denied — these go through
 	// the handleConnect path without triggering the bidirectional copy.
@@ -327,3 +434,79 @@ func TestProxy_SplitHostPort_Errors(t *testing.T) {
 		}
 	}
 }
+
+func TestProxy_GrantExpiry(t *testing.T) {
+	target, received := startRecordingServer(t)
+	socketPath, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{
+		Mode:       "allowlist",
+		Allow:      []string{"pypi.org:443"},
+		TTLSeconds: 60,
+	}, withUpstream(target), withPolicy(func(p *Policy) {
+		p.expiresAt = time.Now().Add(300 * time.Millisecond)
+	}))
+
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT pypi.org:443 HTTP/1.1\r\nHost: pypi.org:443\r\n\r\n")
+	br := bufio.NewReader(conn)
+	if resp, _ := br.ReadString('\n'); !strings.Contains(resp, "200") {
+		t.Fatalf("CONNECT response = %q", resp)
+	}
+	conn.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+
+	// The tunnel stays open until the grant expires, then both sides close.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		t.Fatalf("tunnel not closed at expiry: %v", err)
+	}
+	select {
+	case b := <-received:
+		if string(b) != "GET / HTTP/1.0\r\n\r\n" {
+			t.Errorf("target received %q", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("target connection not closed")
+	}
+
+	// New connections are denied.
+	conn2, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+	fmt.Fprintf(conn2, "CONNECT pypi.org:443 HTTP/1.1\r\nHost: pypi.org:443\r\n\r\n")
+	if resp, _ := bufio.NewReader(conn2).ReadString('\n'); !strings.Contains(resp, "403") {
+		t.Errorf("after expiry: %q", resp)
+	}
+
+	stop()
+	var reasons []string
+	for _, e := range auditEvents(t, audit) {
+		reasons = append(reasons, e.Decision+":"+e.ReasonCode)
+	}
+	want := []string{"allow:OK", "deny:GRANT_EXPIRED", "deny:GRANT_EXPIRED"}
+	if strings.Join(reasons, ",") != strings.Join(want, ",") {
+		t.Errorf("audit = %v, want %v", reasons, want)
+	}
+}
+
+func connectAndSend(t *testing.T, socketPath, dest string, payload []byte) {
+	t.Helper()
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", dest, dest)
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.Contains(resp, "200") {
+		t.Fatalf("CONNECT response = %q, %v", resp, err)
+	}
+	conn.Write(payload)
+	conn.(*net.UnixConn).CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,133 @@
+package egressproxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"cybros.ai/nexus/netpolicy"
+)
+
+// resolverTTL is the TTL of resolver answers. Pins outlive it, so a short
+// TTL only bounds how stale a sandbox's view can get.
+const resolverTTL = 30
+
+// Resolver answers a sandbox's DNS queries under its directive's policy.
+// Names outside the allowlist are refused; allowed names resolve to their
+// routable addresses, which are pinned in the policy before the answer is
+// sent so the proxy dials exactly the addresses the sandbox saw. Every query
+// is audited with Method "DNS".
+type Resolver struct {
+	policy *Policy
+	audit  *AuditLogger
+
+	// IPv4Only answers AAAA queries with no records, for IPv4-only links.
+	IPv4Only bool
+
+	// Admit, when set, is called with an allowed answer's addresses and the
+	// allowlist entries naming it (Policy.HostEntries) before the answer is
+	// sent; not in unrestricted mode. An error fails the query with
+	// SERVFAIL.
+	Admit func(ctx context.Context, addrs []netip.Addr, entries []netpolicy.AllowlistEntryV2) error
+}
+
+// NewResolver creates a resolver enforcing policy and logging to audit.
+func NewResolver(policy *Policy, audit *AuditLogger) *Resolver {
+	return &Resolver{policy: policy, audit: audit}
+}
+
+// Resolve is a DNSHandler.
+func (r *Resolver) Resolve(ctx context.Context, q DNSQuestion) DNSAnswer {
+	event := AuditEvent{
+		DestHost:  q.Name,
+		Method:    "DNS",
+		QueryType: dnsTypeName(q.Type),
+	}
+
+	ports, anyPort := r.policy.AllowedPorts(q.Name)
+	if !anyPort && len(ports) == 0 && !r.policy.allowsUDP(q.Name) {
+		event.Decision = "deny"
+		event.ReasonCode = r.policy.Check(q.Name, 0).ReasonCode
+		r.audit.Log(event)
+		return DNSAnswer{RCode: DNSRCodeRefused}
+	}
+
+	event.Decision = "allow"
+	event.ReasonCode = "OK"
+	if (q.Type != DNSTypeA && q.Type != DNSTypeAAAA) || (r.IPv4Only && q.Type == DNSTypeAAAA) {
+		r.audit.Log(event)
+		return DNSAnswer{}
+	}
+
+	ips, err := r.policy.ResolveRoutable(q.Name)
+	if err != nil {
+		event.Decision = "deny"
+		event.ReasonCode = "DNS_DENIED"
+		var de *DialError
+		if errors.As(err, &de) {
+			event.ResolvedIP = de.ResolvedIP
+		}
+		r.audit.Log(event)
+
+		var dnsErr *net.DNSError
+		switch {
+		case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+			return DNSAnswer{RCode: DNSRCodeNXDomain}
+		case errors.As(err, &dnsErr):
+			return DNSAnswer{RCode: DNSRCodeServFail}
+		default: // every address is private
+			return DNSAnswer{RCode: DNSRCodeRefused}
+		}
+	}
+
+	var (
+		addrs  []netip.Addr
+		pinned []net.IP
+		shown  []string
+	)
+	for _, ip := range ips {
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		if addr.Is4() != (q.Type == DNSTypeA) {
+			continue
+		}
+		addrs = append(addrs, addr)
+		pinned = append(pinned, ip)
+		shown = append(shown, addr.String())
+	}
+	if len(addrs) == 0 {
+		r.audit.Log(event)
+		return DNSAnswer{}
+	}
+
+	if entries := r.policy.HostEntries(q.Name); r.Admit != nil && !anyPort && len(entries) > 0 {
+		if err := r.Admit(ctx, addrs, entries); err != nil {
+			event.Decision = "deny"
+			event.ReasonCode = "OTHER"
+			event.ResolvedIP = strings.Join(shown, ",")
+			r.audit.Log(event)
+			return DNSAnswer{RCode: DNSRCodeServFail}
+		}
+	}
+	r.policy.Pin(q.Name, pinned)
+
+	event.ResolvedIP = strings.Join(shown, ",")
+	r.audit.Log(event)
+	return DNSAnswer{Addrs: addrs, TTL: resolverTTL}
+}
+
+func dnsTypeName(t uint16) string {
+	switch t {
+	case DNSTypeA:
+		return "A"
+	case DNSTypeAAAA:
+		return "AAAA"
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
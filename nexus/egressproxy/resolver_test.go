@@ -0,0 +1,219 @@
+package egressproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/netpolicy"
+	"cybros.ai/nexus/protocol"
+)
+
+func newTestResolver(t *testing.T, cap *protocol.NetCapabilityV1) (*Resolver, *Policy, *bytes.Buffer) {
+	t.Helper()
+	p, err := NewPolicy(cap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "github.com":
+			return []net.IP{net.ParseIP("140.82.112.3"), net.ParseIP("2606:50c0::1"), net.ParseIP("10.0.0.1")}, nil
+		case "internal.example.com":
+			return []net.IP{net.ParseIP("10.1.2.3")}, nil
+		case "broken.example.com":
+			return nil, &net.DNSError{Err: "server misbehaving", Name: host}
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var audit bytes.Buffer
+	return NewResolver(p, NewAuditLogger(&audit, "d-1")), p, &audit
+}
+
+func auditEvents(t *testing.T, buf *bytes.Buffer) []AuditEvent {
+	t.Helper()
+	var events []AuditEvent
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e AuditEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestResolver_Allowlist(t *testing.T) {
+	r, p, audit := newTestResolver(t, &protocol.NetCapabilityV1{
+		Mode:  "allowlist",
+		Allow: []string{"github.com:443", "*.example.com:443"},
+	})
+	ctx := context.Background()
+
+	a := r.Resolve(ctx, DNSQuestion{Name: "github.com", Type: DNSTypeA})
+	if a.RCode != DNSRCodeSuccess || len(a.Addrs) != 1 || a.Addrs[0].String() != "140.82.112.3" || a.TTL == 0 {
+		t.Errorf("A github.com = %+v", a)
+	}
+	a = r.Resolve(ctx, DNSQuestion{Name: "github.com", Type: DNSTypeAAAA})
+	if len(a.Addrs) != 1 || a.Addrs[0].String() != "2606:50c0::1" {
+		t.Errorf("AAAA github.com = %+v", a)
+	}
+	if a := r.Resolve(ctx, DNSQuestion{Name: "evil.com", Type: DNSTypeA}); a.RCode != DNSRCodeRefused {
+		t.Errorf("unlisted: rcode = %d, want REFUSED", a.RCode)
+	}
+	if a := r.Resolve(ctx, DNSQuestion{Name: "internal.example.com", Type: DNSTypeA}); a.RCode != DNSRCodeRefused {
+		t.Errorf("private-only: rcode = %d, want REFUSED", a.RCode)
+	}
+	if a := r.Resolve(ctx, DNSQuestion{Name: "missing.example.com", Type: DNSTypeA}); a.RCode != DNSRCodeNXDomain {
+		t.Errorf("missing: rcode = %d, want NXDOMAIN", a.RCode)
+	}
+	if a := r.Resolve(ctx, DNSQuestion{Name: "broken.example.com", Type: DNSTypeA}); a.RCode != DNSRCodeServFail {
+		t.Errorf("lookup failure: rcode = %d, want SERVFAIL", a.RCode)
+	}
+	if a := r.Resolve(ctx, DNSQuestion{Name: "github.com", Type: 16}); a.RCode != DNSRCodeSuccess || len(a.Addrs) != 0 {
+		t.Errorf("TXT: %+v, want empty NOERROR", a)
+	}
+
+	// Both answers are pinned, most recent first.
+	if ip, err := p.ResolveAndCheck("GitHub.com."); err != nil || ip.String() != "2606:50c0::1" {
+		t.Errorf("pinned dial address = %v, %v", ip, err)
+	}
+
+	events := auditEvents(t, audit)
+	want := []struct{ host, qtype, decision, reason, ips string }{
+		{"github.com", "A", "allow", "OK", "140.82.112.3"},
+		{"github.com", "AAAA", "allow", "OK", "2606:50c0::1"},
+		{"evil.com", "A", "deny", "NOT_IN_ALLOWLIST", ""},
+		{"internal.example.com", "A", "deny", "DNS_DENIED", "10.1.2.3"},
+		{"missing.example.com", "A", "deny", "DNS_DENIED", ""},
+		{"broken.example.com", "A", "deny", "DNS_DENIED", ""},
+		{"github.com", "TYPE16", "allow", "OK", ""},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d audit events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		e := events[i]
+		if e.Method != "DNS" || e.DirectiveID != "d-1" || e.DestHost != w.host || e.QueryType != w.qtype ||
+			e.Decision != w.decision || e.ReasonCode != w.reason || e.ResolvedIP != w.ips {
+			t.Errorf("event %d = %+v, want %+v", i, e, w)
+		}
+	}
+}
+
+func TestResolver_ModeNone(t *testing.T) {
+	r, _, audit := newTestResolver(t, nil)
+	if a := r.Resolve(context.Background(), DNSQuestion{Name: "github.com", Type: DNSTypeA}); a.RCode != DNSRCodeRefused {
+		t.Errorf("rcode = %d, want REFUSED", a.RCode)
+	}
+	if e := auditEvents(t, audit)[0]; e.ReasonCode != "NET_MODE_NONE" {
+		t.Errorf("reason = %s", e.ReasonCode)
+	}
+}
+
+func TestResolver_GrantExpired(t *testing.T) {
+	r, p, audit := newTestResolver(t, &protocol.NetCapabilityV1{
+		Mode:       "allowlist",
+		Allow:      []string{"github.com:443"},
+		TTLSeconds: 60,
+	})
+	p.expiresAt = time.Now()
+	if a := r.Resolve(context.Background(), DNSQuestion{Name: "github.com", Type: DNSTypeA}); a.RCode != DNSRCodeRefused {
+		t.Errorf("rcode = %d, want REFUSED", a.RCode)
+	}
+	if e := auditEvents(t, audit)[0]; e.ReasonCode != "GRANT_EXPIRED" {
+		t.Errorf("reason = %s", e.ReasonCode)
+	}
+}
+
+func TestResolver_Admit(t *testing.T) {
+	r, p, _ := newTestResolver(t, &protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"github.com:443"}})
+	r.IPv4Only = true
+	var admitted []netip.Addr
+	var admitErr error
+	r.Admit = func(_ context.Context, addrs []netip.Addr, entries []netpolicy.AllowlistEntryV2) error {
+		if len(entries) != 1 || entries[0].Ports != (netpolicy.PortRange{From: 443, To: 443}) || entries[0].Proto != netpolicy.ProtoTCP {
+			t.Errorf("entries = %+v", entries)
+		}
+		admitted = append(admitted, addrs...)
+		return admitErr
+	}
+	ctx := context.Background()
+
+	if a := r.Resolve(ctx, DNSQuestion{Name: "github.com", Type: DNSTypeAAAA}); len(a.Addrs) != 0 || len(admitted) != 0 {
+		t.Errorf("IPv4Only AAAA = %+v, admitted %v", a, admitted)
+	}
+	if a := r.Resolve(ctx, DNSQuestion{Name: "github.com", Type: DNSTypeA}); len(a.Addrs) != 1 || len(admitted) != 1 {
+		t.Errorf("A = %+v, admitted %v", a, admitted)
+	}
+
+	admitErr = errors.New("rules full")
+	p.pins = nil
+	if a := r.Resolve(ctx, DNSQuestion{Name: "github.com", Type: DNSTypeA}); a.RCode != DNSRCodeServFail {
+		t.Errorf("admit failure: rcode = %d, want SERVFAIL", a.RCode)
+	}
+	if len(p.pinned("github.com")) != 0 {
+		t.Error("answer pinned although admit failed")
+	}
+}
+
+func TestResolver_UDPOnlyName(t *testing.T) {
+	r, p, _ := newTestResolver(t, &protocol.NetCapabilityV1{
+		Mode:             "allowlist",
+		AllowlistVersion: netpolicy.AllowlistV2,
+		Allow:            []string{"github.com:443/udp"},
+	})
+	var admitted []netpolicy.AllowlistEntryV2
+	r.Admit = func(_ context.Context, _ []netip.Addr, entries []netpolicy.AllowlistEntryV2) error {
+		admitted = append(admitted, entries...)
+		return nil
+	}
+	ctx := context.Background()
+
+	if a := r.Resolve(ctx, DNSQuestion{Name: "github.com", Type: DNSTypeA}); len(a.Addrs) != 1 {
+		t.Errorf("A = %+v", a)
+	}
+	if len(admitted) != 1 || admitted[0].Proto != netpolicy.ProtoUDP {
+		t.Errorf("admitted = %+v, want the UDP entry", admitted)
+	}
+	if !p.CheckUDP("140.82.112.3", 443).Allowed {
+		t.Error("pinned address not allowed over UDP")
+	}
+	if p.Check("140.82.112.3", 443).Allowed {
+		t.Error("pinned address allowed over TCP")
+	}
+}
+
+func TestPolicy_Pins(t *testing.T) {
+	p, _ := NewPolicy(&protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"github.com:443"}})
+	p.lookupIP = func(string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("140.82.112.99")}, nil
+	}
+
+	if r := p.Check("140.82.112.3", 443); r.Allowed {
+		t.Error("unpinned address should not match the allowlist")
+	}
+	p.Pin("GitHub.com", []net.IP{net.ParseIP("140.82.112.3")})
+	if r := p.Check("140.82.112.3", 443); !r.Allowed {
+		t.Errorf("pinned address: %+v", r)
+	}
+	if r := p.Check("140.82.112.3", 22); r.Allowed {
+		t.Error("pinned address must keep the host's port restriction")
+	}
+	if ip, _ := p.ResolveAndCheck("github.com"); ip.String() != "140.82.112.3" {
+		t.Errorf("dial address = %v, want the pinned one", ip)
+	}
+
+	for i := range maxPinsPerHost + 4 {
+		p.Pin("github.com", []net.IP{net.IPv4(140, 82, 113, byte(i))})
+	}
+	if n := len(p.pinned("github.com")); n != maxPinsPerHost {
+		t.Errorf("pins = %d, want %d", n, maxPinsPerHost)
+	}
+}
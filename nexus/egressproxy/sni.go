@@ -0,0 +1,124 @@
+package egressproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// sniMode controls the TLS SNI check on tunnels (design 7.3.2). It is
+// derived from the capability's preset.
+type sniMode int
+
+const (
+	// sniOff skips the check (unrestricted mode: every name is allowed).
+	sniOff sniMode = iota
+	// sniMatch denies a ClientHello whose SNI is neither the tunnel's
+	// destination nor otherwise allowed; a missing SNI is allowed.
+	sniMatch
+	// sniRequire is sniMatch, and also denies a ClientHello without SNI.
+	sniRequire
+)
+
+// sniModeFor maps a capability to its SNI check: strict presets require SNI
+// on every TLS tunnel, other allowlist/none policies only deny mismatches.
+func sniModeFor(mode, preset string) sniMode {
+	switch {
+	case mode == "unrestricted":
+		return sniOff
+	case preset == "strict":
+		return sniRequire
+	}
+	return sniMatch
+}
+
+// clientHelloTimeout bounds reading a ClientHello once its first byte is in.
+const clientHelloTimeout = 10 * time.Second
+
+// recordTypeHandshake is the TLS record type a ClientHello starts with.
+const recordTypeHandshake = 0x16
+
+// CheckSNI evaluates the SNI of a TLS tunnel to destHost:destPort. An empty
+// sni means the ClientHello had none.
+func (p *Policy) CheckSNI(destHost string, destPort int, sni string) CheckResult {
+	switch {
+	case p.sni == sniOff:
+	case sni == "":
+		if p.sni == sniRequire {
+			return CheckResult{Allowed: false, ReasonCode: "SNI_MISMATCH"}
+		}
+	case normalizeHost(sni) == normalizeHost(destHost):
+	case !p.Check(sni, destPort).Allowed:
+		return CheckResult{Allowed: false, ReasonCode: "SNI_MISMATCH"}
+	}
+	return CheckResult{Allowed: true, ReasonCode: "OK"}
+}
+
+// errHelloRead stops the handshake once the ClientHello has been parsed.
+var errHelloRead = errors.New("client hello read")
+
+// clientHello is what a tunnel's client opened with.
+type clientHello struct {
+	tls    bool   // a TLS handshake record
+	parsed bool   // a well-formed ClientHello
+	sni    string // its server name, if any
+	raw    []byte // bytes consumed, to replay upstream
+}
+
+// peekClientHello reads the ClientHello if the client opens with a TLS
+// handshake; the rest of the stream stays in br. Errors are read failures
+// (including a ClientHello not completed within clientHelloTimeout).
+func peekClientHello(conn net.Conn, br *bufio.Reader) (clientHello, error) {
+	b, err := br.Peek(1)
+	if err != nil {
+		return clientHello{}, err
+	}
+	if b[0] != recordTypeHandshake {
+		return clientHello{}, nil
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// Let crypto/tls parse the ClientHello (including one split across
+	// records) and abort before anything is written back.
+	var buf bytes.Buffer
+	var info *tls.ClientHelloInfo
+	hc := &helloConn{Conn: conn, r: io.TeeReader(br, &buf)}
+	_ = tls.Server(hc, &tls.Config{
+		GetConfigForClient: func(i *tls.ClientHelloInfo) (*tls.Config, error) {
+			info = i
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	hello := clientHello{tls: true, raw: buf.Bytes()}
+	if info == nil {
+		// Either the read failed or the ClientHello did not parse.
+		return hello, hc.err
+	}
+	hello.parsed = true
+	hello.sni = info.ServerName
+	return hello, nil
+}
+
+// helloConn feeds a TLS server the client's bytes, keeping the first read
+// error, and drops its writes.
+type helloConn struct {
+	net.Conn
+	r   io.Reader
+	err error
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+func (c *helloConn) Write(p []byte) (int, error) { return len(p), nil }
@@ -0,0 +1,207 @@
+package egressproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+)
+
+func TestSNIModeFor(t *testing.T) {
+	tests := []struct {
+		mode, preset string
+		want         sniMode
+	}{
+		{"unrestricted", "", sniOff},
+		{"unrestricted", "strict", sniOff},
+		{"allowlist", "strict", sniRequire},
+		{"allowlist", "loose", sniMatch},
+		{"allowlist", "custom", sniMatch},
+		{"allowlist", "", sniMatch},
+		{"none", "", sniMatch},
+	}
+	for _, tt := range tests {
+		if got := sniModeFor(tt.mode, tt.preset); got != tt.want {
+			t.Errorf("sniModeFor(%q, %q) = %d, want %d", tt.mode, tt.preset, got, tt.want)
+		}
+	}
+}
+
+func TestPolicy_CheckSNI(t *testing.T) {
+	loose, _ := NewPolicy(&protocol.NetCapabilityV1{
+		Mode:   "allowlist",
+		Preset: "loose",
+		Allow:  []string{"github.com:443", "*.githubusercontent.com:443"},
+	})
+	strict, _ := NewPolicy(&protocol.NetCapabilityV1{
+		Mode:   "allowlist",
+		Preset: "strict",
+		Allow:  []string{"github.com:443"},
+	})
+	open, _ := NewPolicy(&protocol.NetCapabilityV1{Mode: "unrestricted"})
+
+	tests := []struct {
+		name   string
+		policy *Policy
+		dest   string
+		sni    string
+		want   bool
+	}{
+		{"same name", loose, "github.com", "github.com", true},
+		{"case and trailing dot", loose, "github.com", "GitHub.com.", true},
+		{"other allowed name", loose, "github.com", "raw.githubusercontent.com", true},
+		{"mismatch", loose, "github.com", "evil.com", false},
+		{"pinned address, mismatched name", loose, "140.82.112.3", "evil.com", false},
+		{"missing, loose", loose, "github.com", "", true},
+		{"missing, strict", strict, "github.com", "", false},
+		{"mismatch, strict", strict, "github.com", "evil.com", false},
+		{"unrestricted", open, "example.com", "evil.com", true},
+	}
+	for _, tt := range tests {
+		r := tt.policy.CheckSNI(tt.dest, 443, tt.sni)
+		if r.Allowed != tt.want {
+			t.Errorf("%s: allowed = %v, want %v", tt.name, r.Allowed, tt.want)
+		}
+		if !r.Allowed && r.ReasonCode != "SNI_MISMATCH" {
+			t.Errorf("%s: reason = %s, want SNI_MISMATCH", tt.name, r.ReasonCode)
+		}
+	}
+}
+
+// clientHelloBytes returns the first flight of a TLS client for serverName.
+func clientHelloBytes(t *testing.T, serverName string) []byte {
+	t.Helper()
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+	}()
+
+	var hello []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := server.Read(buf)
+		hello = append(hello, buf[:n]...)
+		if err != nil {
+			t.Fatalf("read client hello: %v", err)
+		}
+		// One handshake record: 5-byte header plus its length.
+		if len(hello) >= 5 && len(hello) >= 5+int(hello[3])<<8|int(hello[4]) {
+			return hello
+		}
+	}
+}
+
+func TestPeekClientHello(t *testing.T) {
+	raw := clientHelloBytes(t, "github.com")
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write(raw)
+		client.Write([]byte("rest"))
+		client.Close()
+	}()
+
+	br := bufio.NewReader(server)
+	hello, err := peekClientHello(server, br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hello.tls || !hello.parsed || hello.sni != "github.com" {
+		t.Errorf("hello = %+v", hello)
+	}
+	if !bytes.Equal(hello.raw, raw) {
+		t.Errorf("consumed %d bytes, want the %d-byte ClientHello", len(hello.raw), len(raw))
+	}
+	if rest, _ := io.ReadAll(br); string(rest) != "rest" {
+		t.Errorf("remaining stream = %q", rest)
+	}
+}
+
+func TestPeekClientHello_NotTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		client.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+		client.Close()
+	}()
+
+	br := bufio.NewReader(server)
+	hello, err := peekClientHello(server, br)
+	if err != nil || hello.tls || len(hello.raw) != 0 {
+		t.Errorf("hello = %+v, %v", hello, err)
+	}
+	if line, _ := br.ReadString('\n'); !strings.HasPrefix(line, "SSH-2.0") {
+		t.Errorf("stream not left intact: %q", line)
+	}
+}
+
+func TestPeekClientHello_Malformed(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		// A handshake record carrying a truncated ClientHello.
+		client.Write([]byte{0x16, 0x03, 0x01, 0x00, 0x04, 0x01, 0x00, 0x00, 0x00})
+		client.Close()
+	}()
+
+	hello, err := peekClientHello(server, bufio.NewReader(server))
+	if err != nil || !hello.tls || hello.parsed {
+		t.Errorf("hello = %+v, %v", hello, err)
+	}
+}
+
+func TestProxy_CONNECT_SNIMismatch(t *testing.T) {
+	target, received := startRecordingServer(t)
+	socketPath, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{
+		Mode:  "allowlist",
+		Allow: []string{"github.com:443"},
+	}, withUpstream(target))
+	connectAndSend(t, socketPath, "github.com:443", clientHelloBytes(t, "evil.com"))
+
+	select {
+	case b := <-received:
+		if len(b) != 0 {
+			t.Errorf("target received %d bytes of a denied ClientHello", len(b))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel to target not closed")
+	}
+	stop()
+	events := auditEvents(t, audit)
+	e := events[len(events)-1]
+	if e.Decision != "deny" || e.ReasonCode != "SNI_MISMATCH" || e.SNI != "evil.com" || e.DestHost != "github.com" {
+		t.Errorf("audit = %+v", e)
+	}
+}
+
+func TestProxy_CONNECT_SNIMatch(t *testing.T) {
+	target, received := startRecordingServer(t)
+	socketPath, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{
+		Mode:  "allowlist",
+		Allow: []string{"github.com:443"},
+	}, withUpstream(target))
+	hello := clientHelloBytes(t, "github.com")
+	connectAndSend(t, socketPath, "github.com:443", hello)
+
+	select {
+	case b := <-received:
+		if !bytes.Equal(b, hello) {
+			t.Errorf("target received %d bytes, want the %d-byte ClientHello", len(b), len(hello))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel to target not closed")
+	}
+	stop()
+	e := auditEvents(t, audit)[0]
+	if e.Decision != "allow" || e.SNI != "github.com" || e.ResolvedIP != "140.82.112.3" {
+		t.Errorf("audit = %+v", e)
+	}
+}
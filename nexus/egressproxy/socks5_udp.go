@@ -0,0 +1,454 @@
+package egressproxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	socks5CmdUDPAssociate = 0x03
+
+	// socks5UDPMethod is the audit Method of UDP ASSOCIATE flows.
+	socks5UDPMethod = "SOCKS5_UDP"
+
+	// maxUDPFlows bounds the destinations one association may address.
+	maxUDPFlows = 64
+	// udpFlowIdleTimeout closes a flow without datagrams in either
+	// direction (sooner if Limits.IdleTimeout is shorter).
+	udpFlowIdleTimeout = 2 * time.Minute
+	// maxUDPDatagram is the largest datagram relayed, header included.
+	maxUDPDatagram = 64 * 1024
+)
+
+// udpAssociate serves a SOCKS5 UDP ASSOCIATE request (RFC 1928 §7). The
+// relay's client side is a UDP socket on the control connection's local
+// address, so it is only offered to clients that reached the proxy over
+// TCP (StartForDirectiveTCP); clients bridged over a Unix socket (bwrap,
+// Firecracker vsock) have no datagram path to it and are refused.
+//
+// Every datagram's destination is checked with Policy.CheckUDP. Each
+// destination is a flow with its own connected upstream socket, counted as
+// one connection by the Limits and audited once when it is allowed or
+// denied (and again if a limit or the grant's expiry cuts it). The
+// association ends with its control connection.
+func (p *Proxy) udpAssociate(conn net.Conn, br *bufio.Reader, atyp byte) {
+	deny := func(reason string, rep byte) {
+		p.audit.Log(AuditEvent{
+			Decision:   "deny",
+			ReasonCode: reason,
+			Method:     socks5UDPMethod,
+		})
+		_ = writeSOCKS5Reply(conn, rep)
+	}
+
+	// DST.ADDR/DST.PORT name where the client will send from; clients
+	// commonly send zeros, so only the control connection's IP is enforced.
+	if err := skipSOCKS5Addr(br, atyp); err != nil {
+		deny("INVALID_DESTINATION", 0x08) // Address type not supported
+		return
+	}
+	local, localTCP := conn.LocalAddr().(*net.TCPAddr)
+	remote, remoteTCP := conn.RemoteAddr().(*net.TCPAddr)
+	if !localTCP || !remoteTCP {
+		deny("INVALID_DESTINATION", 0x07) // Command not supported
+		return
+	}
+	switch {
+	case p.policy.Expired():
+		deny("GRANT_EXPIRED", 0x02) // Connection not allowed by ruleset
+		return
+	case p.policy.mode == "none":
+		deny("NET_MODE_NONE", 0x02)
+		return
+	}
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+	if err != nil {
+		deny("OTHER", 0x01) // General SOCKS server failure
+		return
+	}
+	if err := writeSOCKS5ReplyAddr(conn, 0x00, relay.LocalAddr().(*net.UDPAddr)); err != nil {
+		relay.Close()
+		return
+	}
+
+	a := &udpAssociation{
+		p:        p,
+		relay:    relay,
+		clientIP: remote.IP,
+		flows:    make(map[string]*udpFlow),
+	}
+	served := make(chan struct{})
+	go func() {
+		a.serve()
+		close(served)
+	}()
+	// The association lasts as long as its control connection.
+	_, _ = io.Copy(io.Discard, br)
+	a.close()
+	<-served
+}
+
+// udpAssociation is the relay state of one UDP ASSOCIATE request.
+type udpAssociation struct {
+	p        *Proxy
+	relay    *net.UDPConn
+	clientIP net.IP
+
+	mu     sync.Mutex
+	client *net.UDPAddr // fixed by the first datagram
+	flows  map[string]*udpFlow
+	full   bool // maxUDPFlows reached (logged once)
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// udpFlow is an association's traffic to one destination. Denied flows
+// have no conn; they are kept so that a denied destination is audited once.
+type udpFlow struct {
+	a       *udpAssociation
+	key     string
+	event   AuditEvent
+	header  []byte // SOCKS5 UDP header of replies: the destination as the client addressed it
+	conn    net.Conn
+	release func()
+	untrack func() // set once the flow is allowed; called when it ends
+
+	lastActive atomic.Int64 // unix nanos
+	cut        atomic.Bool
+	closeOnce  sync.Once
+}
+
+func (a *udpAssociation) serve() {
+	buf := make([]byte, maxUDPDatagram)
+	for {
+		n, from, err := a.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.IP.Equal(a.clientIP) {
+			continue // only the control connection's host may use the relay
+		}
+		a.mu.Lock()
+		if a.client == nil {
+			a.client = from
+		}
+		fromClient := a.client.Port == from.Port
+		a.mu.Unlock()
+		if !fromClient {
+			continue
+		}
+
+		host, port, hdrLen, err := parseSOCKS5UDPHeader(buf[:n])
+		if err != nil {
+			continue // malformed and fragmented datagrams are dropped (RFC 1928 §7)
+		}
+		if f := a.flow(host, port, buf[:hdrLen]); f != nil && f.conn != nil {
+			f.send(buf[hdrLen:n])
+		}
+	}
+}
+
+// flow returns the flow to host:port, setting it up on the first datagram.
+// It returns nil once the association is closed or has maxUDPFlows flows.
+func (a *udpAssociation) flow(host string, port int, header []byte) *udpFlow {
+	key := net.JoinHostPort(normalizeHost(host), strconv.Itoa(port))
+	a.mu.Lock()
+	f, ok := a.flows[key]
+	full := !ok && len(a.flows) >= maxUDPFlows
+	logFull := full && !a.full
+	a.full = a.full || full
+	a.mu.Unlock()
+	switch {
+	case ok:
+		return f
+	case logFull:
+		a.p.audit.Log(AuditEvent{
+			DestHost:   host,
+			DestPort:   port,
+			Decision:   "deny",
+			ReasonCode: "CONN_LIMIT_EXCEEDED",
+			Method:     socks5UDPMethod,
+		})
+		return nil
+	case full:
+		return nil
+	}
+
+	f = a.newFlow(key, host, port, header)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		f.close()
+		if f.untrack != nil {
+			f.untrack()
+		}
+		return nil
+	}
+	a.flows[key] = f
+	if f.conn != nil {
+		a.wg.Add(1)
+		go f.receive()
+	}
+	return f
+}
+
+// newFlow checks host:port and dials it, logging the flow's decision.
+func (a *udpAssociation) newFlow(key, host string, port int, header []byte) *udpFlow {
+	f := &udpFlow{
+		a:      a,
+		key:    key,
+		header: slices.Clone(header),
+		event: AuditEvent{
+			DestHost: host,
+			DestPort: port,
+			Method:   socks5UDPMethod,
+		},
+	}
+	deny := func(reason string) *udpFlow {
+		f.event.Decision = "deny"
+		f.event.ReasonCode = reason
+		a.p.audit.Log(f.event)
+		return f
+	}
+
+	if result := a.p.policy.CheckUDP(host, port); !result.Allowed {
+		return deny(result.ReasonCode)
+	}
+	release, reason := a.p.limits.admit()
+	if reason != "" {
+		return deny(reason)
+	}
+	conn, resolvedIP, err := a.p.policy.DialUDPChecked(host, port)
+	f.event.ResolvedIP = resolvedIP
+	if err != nil {
+		release()
+		reasonCode := "OTHER"
+		var de *DialError
+		if errors.As(err, &de) && de.ReasonCode != "" {
+			reasonCode = de.ReasonCode
+		}
+		return deny(reasonCode)
+	}
+	f.conn, f.release = conn, release
+	untrack, ok := a.p.track(f.event, f.close)
+	if !ok {
+		f.close()
+		f.conn = nil
+		return deny("GRANT_EXPIRED")
+	}
+	f.untrack = untrack
+	f.touch()
+
+	f.event.Decision = "allow"
+	f.event.ReasonCode = "OK"
+	a.p.audit.Log(f.event)
+	return f
+}
+
+// reply relays a datagram (header included) back to the client.
+func (a *udpAssociation) reply(b []byte) {
+	a.mu.Lock()
+	client := a.client
+	a.mu.Unlock()
+	_, _ = a.relay.WriteToUDP(b, client)
+}
+
+// close ends the association: the relay socket and every flow are closed.
+func (a *udpAssociation) close() {
+	a.mu.Lock()
+	a.closed = true
+	flows := a.flows
+	a.flows = nil
+	a.mu.Unlock()
+
+	a.relay.Close()
+	for _, f := range flows {
+		f.close()
+	}
+	a.wg.Wait()
+}
+
+func (a *udpAssociation) remove(f *udpFlow) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.flows[f.key] == f {
+		delete(a.flows, f.key)
+	}
+}
+
+func (f *udpFlow) touch() { f.lastActive.Store(time.Now().UnixNano()) }
+
+// send relays a client datagram upstream. Datagrams are never split: one
+// that does not fit the transfer budget cuts the flow.
+func (f *udpFlow) send(data []byte) {
+	if f.cut.Load() {
+		return
+	}
+	if n, err := f.a.p.limits.take(len(data), "upload"); err != nil || n < len(data) {
+		f.cutBy("TRANSFER_LIMIT_EXCEEDED")
+		return
+	}
+	f.touch()
+	_, _ = f.conn.Write(data)
+}
+
+// receive relays upstream datagrams to the client until the flow is closed
+// or idle.
+func (f *udpFlow) receive() {
+	defer f.a.wg.Done()
+	defer f.a.remove(f)
+	defer f.untrack()
+	defer f.close()
+
+	idle := udpFlowIdleTimeout
+	if lim := f.a.p.limits.lim.IdleTimeout; lim > 0 && lim < idle {
+		idle = lim
+	}
+	buf := make([]byte, maxUDPDatagram)
+	hdrLen := copy(buf, f.header)
+	for {
+		_ = f.conn.SetReadDeadline(time.Unix(0, f.lastActive.Load()).Add(idle))
+		n, err := f.conn.Read(buf[hdrLen:])
+		if err != nil {
+			switch {
+			case errors.Is(err, syscall.ECONNREFUSED):
+				continue // ICMP port unreachable for an earlier datagram
+			case errors.Is(err, os.ErrDeadlineExceeded) && time.Since(time.Unix(0, f.lastActive.Load())) < idle:
+				continue // the client kept the flow alive
+			}
+			return
+		}
+		if _, err := f.a.p.limits.take(n, "download"); err != nil {
+			f.cutBy("TRANSFER_LIMIT_EXCEEDED")
+			return
+		}
+		f.touch()
+		f.a.reply(buf[:hdrLen+n])
+	}
+}
+
+// cutBy closes an allowed flow on a limit, logging it as a deny.
+func (f *udpFlow) cutBy(reason string) {
+	if f.cut.Swap(true) {
+		return
+	}
+	event := f.event
+	event.Decision = "deny"
+	event.ReasonCode = reason
+	f.a.p.audit.Log(event)
+	f.close()
+}
+
+func (f *udpFlow) close() {
+	f.closeOnce.Do(func() {
+		if f.conn == nil {
+			return
+		}
+		f.conn.Close()
+		f.release()
+	})
+}
+
+// parseSOCKS5UDPHeader parses the header of a client datagram (RFC 1928
+// §7) and returns its destination and length.
+func parseSOCKS5UDPHeader(b []byte) (host string, port int, n int, _ error) {
+	if len(b) < 4 || b[0] != 0 || b[1] != 0 {
+		return "", 0, 0, errors.New("malformed UDP request header")
+	}
+	if b[2] != 0 {
+		return "", 0, 0, errors.New("fragmented datagram")
+	}
+	n = 4
+	switch b[3] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		size := net.IPv4len
+		if b[3] == socks5AtypIPv6 {
+			size = net.IPv6len
+		}
+		if len(b) < n+size {
+			return "", 0, 0, errors.New("short address")
+		}
+		host = net.IP(b[n : n+size]).String()
+		n += size
+	case socks5AtypDomain:
+		if len(b) < n+1 || b[n] == 0 || len(b) < n+1+int(b[n]) {
+			return "", 0, 0, errors.New("short address")
+		}
+		host = string(b[n+1 : n+1+int(b[n])])
+		if err := checkSOCKS5Domain(host); err != nil {
+			return "", 0, 0, err
+		}
+		n += 1 + int(b[n])
+	default:
+		return "", 0, 0, errors.New("unsupported address type")
+	}
+	if len(b) < n+2 {
+		return "", 0, 0, errors.New("short port")
+	}
+	port = int(binary.BigEndian.Uint16(b[n : n+2]))
+	if port == 0 {
+		return "", 0, 0, errors.New("port out of range")
+	}
+	return host, port, n + 2, nil
+}
+
+// skipSOCKS5Addr consumes a request's DST.ADDR and DST.PORT.
+func skipSOCKS5Addr(r *bufio.Reader, atyp byte) error {
+	var size int64
+	switch atyp {
+	case socks5AtypIPv4:
+		size = net.IPv4len
+	case socks5AtypIPv6:
+		size = net.IPv6len
+	case socks5AtypDomain:
+		ln, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		size = int64(ln)
+	default:
+		return errors.New("unsupported address type")
+	}
+	_, err := io.CopyN(io.Discard, r, size+2)
+	return err
+}
+
+// writeSOCKS5ReplyAddr writes a reply whose BND.ADDR/BND.PORT is addr.
+func writeSOCKS5ReplyAddr(w io.Writer, rep byte, addr *net.UDPAddr) error {
+	b := []byte{socks5Version, rep, 0x00}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		b = append(b, socks5AtypIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, socks5AtypIPv6)
+		b = append(b, addr.IP.To16()...)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+	_, err := w.Write(b)
+	return err
+}
+
+// checkSOCKS5Domain rejects domain names a resolver could misinterpret.
+func checkSOCKS5Domain(host string) error {
+	if strings.TrimSpace(host) != host {
+		return errors.New("host contains whitespace")
+	}
+	// Reject null bytes, control characters, and non-DNS characters
+	// to prevent SSRF via DNS resolver edge cases.
+	if strings.ContainsAny(host, "\x00\n\r\t /\\@#?") {
+		return errors.New("host contains invalid characters")
+	}
+	return nil
+}
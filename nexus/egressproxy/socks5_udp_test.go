@@ -0,0 +1,226 @@
+package egressproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/netpolicy"
+	"cybros.ai/nexus/protocol"
+)
+
+// startUDPEchoServer starts a UDP server that echoes every datagram.
+func startUDPEchoServer(t *testing.T) string {
+	t.Helper()
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return echo.LocalAddr().String()
+}
+
+// udpAssociate opens a UDP association and returns its control connection
+// and a client socket connected to the relay.
+func udpAssociate(t *testing.T, proxyAddr string) (ctrl net.Conn, client *net.UDPConn) {
+	t.Helper()
+	ctrl, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socks5Handshake(t, ctrl)
+	ctrl.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // UDP ASSOCIATE from 0.0.0.0:0
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(ctrl, reply); err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if reply[1] != 0x00 || reply[3] != 0x01 {
+		t.Fatalf("reply = %#v", reply)
+	}
+	relay := &net.UDPAddr{IP: net.IP(reply[4:8]), Port: int(binary.BigEndian.Uint16(reply[8:10]))}
+	client, err = net.DialUDP("udp", nil, relay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return ctrl, client
+}
+
+func udpDatagram(host string, port int, payload string) []byte {
+	b := []byte{0, 0, 0, 0x03, byte(len(host))}
+	b = append(b, host...)
+	b = binary.BigEndian.AppendUint16(b, uint16(port))
+	return append(b, payload...)
+}
+
+// udpRoundTrip sends payload to host:port through the relay and returns
+// the reply, or nil if none arrives.
+func udpRoundTrip(t *testing.T, client *net.UDPConn, host string, port int, payload string) []byte {
+	t.Helper()
+	if _, err := client.Write(udpDatagram(host, port, payload)); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	buf := make([]byte, 2048)
+	n, err := client.Read(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+func TestSOCKS5UDP_Allowlist(t *testing.T) {
+	proxyAddr, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{
+		Mode:             "allowlist",
+		AllowlistVersion: netpolicy.AllowlistV2,
+		Allow:            []string{"quic.example.com:443/udp", "github.com:443"},
+	}, withTCP(), withUpstream(startUDPEchoServer(t)))
+	ctrl, client := udpAssociate(t, proxyAddr)
+
+	want := udpDatagram("quic.example.com", 443, "ping")
+	if got := udpRoundTrip(t, client, "quic.example.com", 443, "ping"); !bytes.Equal(got, want) {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	if got := udpRoundTrip(t, client, "QUIC.example.com", 443, "again"); !bytes.HasSuffix(got, []byte("again")) {
+		t.Errorf("second reply = %q", got)
+	}
+	// A TCP-only entry does not allow UDP; the denial is audited once.
+	for range 2 {
+		if got := udpRoundTrip(t, client, "github.com", 443, "ping"); got != nil {
+			t.Errorf("relayed to a TCP-only destination: %q", got)
+		}
+	}
+
+	ctrl.Close()
+	stop()
+	if got := auditReasons(t, audit); got != "allow:OK,deny:NOT_IN_ALLOWLIST" {
+		t.Errorf("audit = %s", got)
+	}
+	for _, e := range auditEvents(t, audit) {
+		if e.Method != "SOCKS5_UDP" {
+			t.Errorf("method = %q", e.Method)
+		}
+	}
+	if e := auditEvents(t, audit)[0]; e.DestHost != "quic.example.com" || e.ResolvedIP != "140.82.112.3" {
+		t.Errorf("allow event = %+v", e)
+	}
+}
+
+func TestSOCKS5UDP_PrivateDestination(t *testing.T) {
+	proxyAddr, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{Mode: "unrestricted"}, withTCP(), withUpstream(startUDPEchoServer(t)))
+	ctrl, client := udpAssociate(t, proxyAddr)
+
+	b := []byte{0, 0, 0, 0x01, 10, 0, 0, 1, 0, 123} // 10.0.0.1:123
+	client.Write(b)
+	if got := udpRoundTrip(t, client, "time.example.com", 123, "ntp"); !bytes.HasSuffix(got, []byte("ntp")) {
+		t.Errorf("public destination reply = %q", got)
+	}
+
+	ctrl.Close()
+	stop()
+	if got := auditReasons(t, audit); got != "deny:DNS_DENIED,allow:OK" {
+		t.Errorf("audit = %s", got)
+	}
+}
+
+func TestSOCKS5UDP_TransferLimit(t *testing.T) {
+	proxyAddr, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{Mode: "unrestricted"},
+		withTCP(), withUpstream(startUDPEchoServer(t)), withLimits(Limits{MaxBytes: 10}))
+	ctrl, client := udpAssociate(t, proxyAddr)
+
+	if got := udpRoundTrip(t, client, "time.example.com", 123, "1234"); !bytes.HasSuffix(got, []byte("1234")) {
+		t.Fatalf("reply = %q", got)
+	}
+	// 8 of 10 bytes are spent; a datagram is never truncated.
+	if got := udpRoundTrip(t, client, "time.example.com", 123, "5678"); got != nil {
+		t.Errorf("relayed past the transfer limit: %q", got)
+	}
+
+	ctrl.Close()
+	stop()
+	if got := auditReasons(t, audit); got != "allow:OK,deny:TRANSFER_LIMIT_EXCEEDED" {
+		t.Errorf("audit = %s", got)
+	}
+}
+
+func TestSOCKS5UDP_Refused(t *testing.T) {
+	// Over a Unix socket there is no datagram path to the relay.
+	policy, err := NewPolicy(&protocol.NetCapabilityV1{Mode: "unrestricted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var auditBuf bytes.Buffer
+	conn, err := net.Dial("unix", startSOCKS5TestProxy(t, policy, &auditBuf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	socks5Handshake(t, conn)
+	conn.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil || reply[1] != 0x07 {
+		t.Errorf("reply = %#v, %v; want command not supported", reply, err)
+	}
+
+	// Mode none refuses the association itself.
+	proxyAddr, audit, stop := startTestProxy(t, &protocol.NetCapabilityV1{Mode: "none"}, withTCP(), withUpstream(startUDPEchoServer(t)))
+	ctrl, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	socks5Handshake(t, ctrl)
+	ctrl.Write([]byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	if _, err := io.ReadFull(ctrl, reply); err != nil || reply[1] != 0x02 {
+		t.Errorf("mode none reply = %#v, %v", reply, err)
+	}
+	ctrl.Close()
+	stop()
+	if got := auditReasons(t, audit); got != "deny:NET_MODE_NONE" {
+		t.Errorf("audit = %s", got)
+	}
+}
+
+func TestParseSOCKS5UDPHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		host string
+		port int
+		n    int
+		ok   bool
+	}{
+		{"ipv4", []byte{0, 0, 0, 1, 1, 2, 3, 4, 0, 53, 'x'}, "1.2.3.4", 53, 10, true},
+		{"ipv6", append(append([]byte{0, 0, 0, 4}, net.ParseIP("2001:db8::1")...), 1, 187), "2001:db8::1", 443, 22, true},
+		{"domain", udpDatagram("a.example", 443, "x"), "a.example", 443, 16, true},
+		{"fragment", []byte{0, 0, 1, 1, 1, 2, 3, 4, 0, 53}, "", 0, 0, false},
+		{"reserved bytes", []byte{0, 1, 0, 1, 1, 2, 3, 4, 0, 53}, "", 0, 0, false},
+		{"short address", []byte{0, 0, 0, 1, 1, 2}, "", 0, 0, false},
+		{"short domain", []byte{0, 0, 0, 3, 9, 'a'}, "", 0, 0, false},
+		{"bad domain", udpDatagram("a b", 443, ""), "", 0, 0, false},
+		{"port zero", []byte{0, 0, 0, 1, 1, 2, 3, 4, 0, 0}, "", 0, 0, false},
+		{"unknown atyp", []byte{0, 0, 0, 9, 1, 2, 3, 4, 0, 53}, "", 0, 0, false},
+	}
+	for _, tt := range tests {
+		host, port, n, err := parseSOCKS5UDPHeader(tt.b)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		if host != tt.host || port != tt.port || n != tt.n {
+			t.Errorf("%s: = %q, %d, %d", tt.name, host, port, n)
+		}
+	}
+}
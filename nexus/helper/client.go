@@ -0,0 +1,201 @@
+package helper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// maxMessageBytes bounds a single request or response line.
+const maxMessageBytes = 1 << 20
+
+// Client calls nexus-helper over its Unix socket. Each call uses a fresh
+// connection, so a Client is safe for concurrent use.
+type Client struct {
+	socketPath string
+}
+
+// NewClient returns a client for the helper listening on socketPath.
+func NewClient(socketPath string) *Client {
+	return &Client{socketPath: socketPath}
+}
+
+// SocketPath returns the helper socket path.
+func (c *Client) SocketPath() string { return c.socketPath }
+
+// Ping checks that the helper is reachable and speaks ProtocolVersion.
+func (c *Client) Ping(ctx context.Context) (PingResult, error) {
+	var res PingResult
+	err := c.call(ctx, OpPing, nil, &res)
+	return res, err
+}
+
+// CreateCgroup creates the directive's cgroup with the given limits.
+func (c *Client) CreateCgroup(ctx context.Context, p CgroupCreateParams) (CgroupResult, error) {
+	var res CgroupResult
+	err := c.call(ctx, OpCgroupCreate, p, &res)
+	return res, err
+}
+
+// AttachCgroup moves pid (a descendant of the caller) into the directive's cgroup.
+func (c *Client) AttachCgroup(ctx context.Context, directiveID string, pid int) error {
+	return c.call(ctx, OpCgroupAttach, CgroupAttachParams{DirectiveID: directiveID, PID: pid}, nil)
+}
+
+// DelegateCgroup hands the directive's cgroup to the caller (chown), so it can
+// create sub-cgroups and move its own processes in.
+func (c *Client) DelegateCgroup(ctx context.Context, directiveID string) (CgroupResult, error) {
+	var res CgroupResult
+	err := c.call(ctx, OpCgroupDelegate, DirectiveParams{DirectiveID: directiveID}, &res)
+	return res, err
+}
+
+// RemoveCgroup kills anything left in the directive's cgroup and removes it.
+func (c *Client) RemoveCgroup(ctx context.Context, directiveID string) error {
+	return c.call(ctx, OpCgroupRemove, DirectiveParams{DirectiveID: directiveID}, nil)
+}
+
+// CreateNetns creates the directive's network namespace.
+func (c *Client) CreateNetns(ctx context.Context, directiveID string) (NetnsResult, error) {
+	var res NetnsResult
+	err := c.call(ctx, OpNetnsCreate, DirectiveParams{DirectiveID: directiveID}, &res)
+	return res, err
+}
+
+// DeleteNetns deletes the directive's network namespace.
+func (c *Client) DeleteNetns(ctx context.Context, directiveID string) error {
+	return c.call(ctx, OpNetnsDelete, DirectiveParams{DirectiveID: directiveID}, nil)
+}
+
+// CreateTap creates the directive's TAP device, owned by the caller (or
+// p.Owner).
+func (c *Client) CreateTap(ctx context.Context, p TapCreateParams) (TapResult, error) {
+	var res TapResult
+	err := c.call(ctx, OpTapCreate, p, &res)
+	return res, err
+}
+
+// DeleteTap deletes the directive's TAP device.
+func (c *Client) DeleteTap(ctx context.Context, p TapDeleteParams) error {
+	return c.call(ctx, OpTapDelete, p, nil)
+}
+
+// ApplyNft installs (or replaces) the directive's nftables table.
+func (c *Client) ApplyNft(ctx context.Context, p NftApplyParams) (NftResult, error) {
+	var res NftResult
+	err := c.call(ctx, OpNftApply, p, &res)
+	return res, err
+}
+
+// RemoveNft deletes the directive's nftables table.
+func (c *Client) RemoveNft(ctx context.Context, directiveID string) error {
+	return c.call(ctx, OpNftRemove, DirectiveParams{DirectiveID: directiveID}, nil)
+}
+
+// SweepNetwork removes TAP devices and nft tables left by earlier runs. Call
+// it only while no directive of this nexusd is running.
+func (c *Client) SweepNetwork(ctx context.Context) (NetSweepResult, error) {
+	var res NetSweepResult
+	err := c.call(ctx, OpNetSweep, struct{}{}, &res)
+	return res, err
+}
+
+// PrepareJailer creates the directive's jailer chroot.
+func (c *Client) PrepareJailer(ctx context.Context, p JailerPrepareParams) (JailerPrepareResult, error) {
+	var res JailerPrepareResult
+	err := c.call(ctx, OpJailerPrepare, p, &res)
+	return res, err
+}
+
+// LaunchJailer starts Firecracker under the jailer with the given stdio.
+func (c *Client) LaunchJailer(ctx context.Context, p JailerLaunchParams, stdin, stdout, stderr *os.File) (JailerLaunchResult, error) {
+	if stdin == nil || stdout == nil || stderr == nil {
+		return JailerLaunchResult{}, errors.New("nexus-helper: jailer stdio is required")
+	}
+	var res JailerLaunchResult
+	err := c.call(ctx, OpJailerLaunch, p, &res, stdin, stdout, stderr)
+	return res, err
+}
+
+// WaitJailer blocks until the directive's jailed process exits.
+func (c *Client) WaitJailer(ctx context.Context, directiveID string) (JailerWaitResult, error) {
+	var res JailerWaitResult
+	err := c.call(ctx, OpJailerWait, DirectiveParams{DirectiveID: directiveID}, &res)
+	return res, err
+}
+
+// KillJailer kills the directive's jailed process group.
+func (c *Client) KillJailer(ctx context.Context, directiveID string) error {
+	return c.call(ctx, OpJailerKill, DirectiveParams{DirectiveID: directiveID}, nil)
+}
+
+// CleanupJailer kills the jailed process if still running and removes its chroot.
+func (c *Client) CleanupJailer(ctx context.Context, directiveID string) error {
+	return c.call(ctx, OpJailerCleanup, DirectiveParams{DirectiveID: directiveID}, nil)
+}
+
+func (c *Client) call(ctx context.Context, op string, params, result any, files ...*os.File) error {
+	req := Request{Version: ProtocolVersion, Op: op}
+	if params != nil {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("nexus-helper: encode %s params: %w", op, err)
+		}
+		req.Params = b
+	}
+	line, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("nexus-helper: encode %s: %w", op, err)
+	}
+	line = append(line, '\n')
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.socketPath)
+	if err != nil {
+		return fmt.Errorf("nexus-helper: dial %s: %w", c.socketPath, err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err := writeWithFiles(conn.(*net.UnixConn), line, files); err != nil {
+		return fmt.Errorf("nexus-helper: %s: %w", op, ctxErr(ctx, err))
+	}
+
+	raw, err := bufio.NewReader(io.LimitReader(conn, maxMessageBytes)).ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("nexus-helper: %s: read response: %w", op, ctxErr(ctx, err))
+	}
+	var resp Response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return fmt.Errorf("nexus-helper: %s: decode response: %w", op, err)
+	}
+	if !resp.OK {
+		if resp.Error == nil {
+			return &Error{Code: CodeFailed, Message: op + " failed"}
+		}
+		return resp.Error
+	}
+	if result != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("nexus-helper: %s: decode result: %w", op, err)
+		}
+	}
+	return nil
+}
+
+// ctxErr prefers the context's error over the I/O error it caused.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
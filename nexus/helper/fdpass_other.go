@@ -0,0 +1,17 @@
+//go:build !unix
+
+package helper
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+func writeWithFiles(conn *net.UnixConn, b []byte, files []*os.File) error {
+	if len(files) > 0 {
+		return errors.New("passing file descriptors is not supported on this platform")
+	}
+	_, err := conn.Write(b)
+	return err
+}
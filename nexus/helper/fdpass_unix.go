@@ -0,0 +1,29 @@
+//go:build unix
+
+package helper
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// writeWithFiles writes b, attaching files as SCM_RIGHTS to the first byte.
+func writeWithFiles(conn *net.UnixConn, b []byte, files []*os.File) error {
+	if len(files) == 0 {
+		_, err := conn.Write(b)
+		return err
+	}
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	n, _, err := conn.WriteMsgUnix(b, syscall.UnixRights(fds...), nil)
+	if err != nil {
+		return err
+	}
+	if n < len(b) {
+		_, err = conn.Write(b[n:])
+	}
+	return err
+}
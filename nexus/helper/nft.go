@@ -0,0 +1,132 @@
+package helper
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// maxNftRules caps the rules (Allow, Private, Local and Deny) per directive.
+const maxNftRules = 256
+
+// RenderNftTable renders the nftables script installed by nft.apply. The
+// table is recreated atomically ("add; delete; add" in one transaction), so
+// applying twice replaces the previous rules.
+func RenderNftTable(p NftApplyParams) (string, error) {
+	if !ValidDirectiveID(p.DirectiveID) {
+		return "", fmt.Errorf("invalid directive_id %q", p.DirectiveID)
+	}
+	if len(p.Allow)+len(p.Private)+len(p.Local)+len(p.Deny) > maxNftRules {
+		return "", fmt.Errorf("too many rules (max %d)", maxNftRules)
+	}
+	if p.DNSRedirectPort < 0 || p.DNSRedirectPort > 65535 {
+		return "", fmt.Errorf("invalid dns_redirect_port %d", p.DNSRedirectPort)
+	}
+	table := NftTableName(p.DirectiveID)
+	tap := TapName(p.DirectiveID)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s\n", table)
+	fmt.Fprintf(&b, "delete table inet %s\n", table)
+	fmt.Fprintf(&b, "table inet %s {\n", table)
+	for _, chain := range []struct {
+		name    string
+		private []NftRule
+		deny    []string
+		rules   []NftRule
+	}{{"forward", p.Private, p.Deny, p.Allow}, {"input", nil, nil, p.Local}} {
+		fmt.Fprintf(&b, "\tchain %s {\n", chain.name)
+		fmt.Fprintf(&b, "\t\ttype filter hook %s priority -10; policy accept;\n", chain.name)
+		if chain.name == "input" || !p.CutEstablished {
+			fmt.Fprintf(&b, "\t\tiifname %q ct state established,related accept\n", tap)
+		}
+		for _, r := range chain.private {
+			expr, err := nftRuleExpr(r)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "\t\tiifname %q %s accept\n", tap, expr)
+		}
+		for _, cidr := range chain.deny {
+			family, prefix, err := parseNftPrefix(cidr)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "\t\tiifname %q %s daddr %s drop\n", tap, family, prefix)
+		}
+		for _, r := range chain.rules {
+			expr, err := nftRuleExpr(r)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&b, "\t\tiifname %q %s accept\n", tap, expr)
+		}
+		fmt.Fprintf(&b, "\t\tiifname %q drop\n", tap)
+		b.WriteString("\t}\n")
+	}
+	if p.DNSRedirectPort != 0 {
+		b.WriteString("\tchain prerouting {\n")
+		b.WriteString("\t\ttype nat hook prerouting priority -100; policy accept;\n")
+		fmt.Fprintf(&b, "\t\tiifname %q udp dport 53 redirect to :%d\n", tap, p.DNSRedirectPort)
+		b.WriteString("\t}\n")
+	}
+	if p.Masquerade {
+		b.WriteString("\tchain postrouting {\n")
+		b.WriteString("\t\ttype nat hook postrouting priority 100; policy accept;\n")
+		fmt.Fprintf(&b, "\t\tiifname %q masquerade\n", tap)
+		b.WriteString("\t}\n")
+	}
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+// nftRuleExpr renders the match part of an allow rule. Every field is parsed
+// and re-formatted, so nothing from the request reaches nft verbatim.
+func nftRuleExpr(r NftRule) (string, error) {
+	family, prefix, err := parseNftPrefix(r.CIDR)
+	if err != nil {
+		return "", err
+	}
+
+	if r.Proto != "tcp" && r.Proto != "udp" {
+		return "", fmt.Errorf("invalid proto %q", r.Proto)
+	}
+	if r.PortFrom < 0 || r.PortFrom > 65535 || r.PortTo < 0 || r.PortTo > 65535 {
+		return "", fmt.Errorf("invalid port range %d-%d", r.PortFrom, r.PortTo)
+	}
+	if r.PortFrom == 0 && r.PortTo != 0 {
+		return "", fmt.Errorf("port_to requires port_from")
+	}
+	if r.PortTo != 0 && r.PortTo < r.PortFrom {
+		return "", fmt.Errorf("invalid port range %d-%d", r.PortFrom, r.PortTo)
+	}
+
+	expr := fmt.Sprintf("%s daddr %s", family, prefix)
+	switch {
+	case r.PortFrom == 0:
+		expr += " meta l4proto " + r.Proto
+	case r.PortTo == 0 || r.PortTo == r.PortFrom:
+		expr += fmt.Sprintf(" %s dport %d", r.Proto, r.PortFrom)
+	default:
+		expr += fmt.Sprintf(" %s dport %d-%d", r.Proto, r.PortFrom, r.PortTo)
+	}
+	return expr, nil
+}
+
+// parseNftPrefix parses a CIDR or bare address into its nft family ("ip" or
+// "ip6") and masked prefix.
+func parseNftPrefix(cidr string) (string, netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		addr, aerr := netip.ParseAddr(cidr)
+		if aerr != nil {
+			return "", netip.Prefix{}, fmt.Errorf("invalid cidr %q", cidr)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	prefix = prefix.Masked()
+	if prefix.Addr().Is6() {
+		return "ip6", prefix, nil
+	}
+	return "ip", prefix, nil
+}
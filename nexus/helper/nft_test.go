@@ -0,0 +1,137 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderNftTable(t *testing.T) {
+	t.Parallel()
+
+	script, err := RenderNftTable(NftApplyParams{
+		DirectiveID: "d-1",
+		Allow: []NftRule{
+			{CIDR: "10.1.2.3/8", Proto: "tcp", PortFrom: 443},
+			{CIDR: "2001:db8::1", Proto: "udp", PortFrom: 1000, PortTo: 2000},
+			{CIDR: "192.0.2.0/24", Proto: "tcp"},
+		},
+		Local: []NftRule{{CIDR: "172.16.0.1", Proto: "tcp", PortFrom: 9080}},
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	table, tap := NftTableName("d-1"), TapName("d-1")
+	for _, want := range []string{
+		"table inet " + table + "\ndelete table inet " + table + "\ntable inet " + table + " {\n",
+		"type filter hook forward priority -10; policy accept;",
+		`iifname "` + tap + `" ip daddr 10.0.0.0/8 tcp dport 443 accept`,
+		`iifname "` + tap + `" ip6 daddr 2001:db8::1/128 udp dport 1000-2000 accept`,
+		`iifname "` + tap + `" ip daddr 192.0.2.0/24 meta l4proto tcp accept`,
+		`iifname "` + tap + `" ip daddr 172.16.0.1/32 tcp dport 9080 accept`,
+		`iifname "` + tap + `" drop`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+	if strings.Count(script, "ct state established,related accept") != 2 {
+		t.Errorf("expected established rule in both chains:\n%s", script)
+	}
+}
+
+func TestRenderNftTable_CutEstablished(t *testing.T) {
+	t.Parallel()
+
+	script, err := RenderNftTable(NftApplyParams{
+		DirectiveID:    "d-1",
+		Local:          []NftRule{{CIDR: "172.16.0.1", Proto: "udp", PortFrom: 5353}},
+		CutEstablished: true,
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	forward := script[:strings.Index(script, "chain input")]
+	if strings.Contains(forward, "ct state established") {
+		t.Errorf("forward chain still accepts established flows:\n%s", script)
+	}
+	if strings.Count(script, "ct state established,related accept") != 1 {
+		t.Errorf("input chain should keep its established rule:\n%s", script)
+	}
+}
+
+func TestRenderNftTable_DenyAndNAT(t *testing.T) {
+	t.Parallel()
+
+	script, err := RenderNftTable(NftApplyParams{
+		DirectiveID:     "d-1",
+		Allow:           []NftRule{{CIDR: "0.0.0.0/0", Proto: "tcp"}},
+		Private:         []NftRule{{CIDR: "10.1.0.0/16", Proto: "udp", PortFrom: 53}},
+		Deny:            []string{"10.0.0.0/8", "fc00::/7"},
+		Masquerade:      true,
+		DNSRedirectPort: 5353,
+	})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	tap := TapName("d-1")
+	deny := `iifname "` + tap + `" ip daddr 10.0.0.0/8 drop`
+	allow := `iifname "` + tap + `" ip daddr 0.0.0.0/0 meta l4proto tcp accept`
+	private := `iifname "` + tap + `" ip daddr 10.1.0.0/16 udp dport 53 accept`
+	for _, want := range []string{
+		deny,
+		private,
+		`iifname "` + tap + `" ip6 daddr fc00::/7 drop`,
+		"type nat hook prerouting priority -100; policy accept;",
+		`iifname "` + tap + `" udp dport 53 redirect to :5353`,
+		"type nat hook postrouting priority 100; policy accept;",
+		`iifname "` + tap + `" masquerade`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script missing %q:\n%s", want, script)
+		}
+	}
+	if strings.Index(script, deny) > strings.Index(script, allow) {
+		t.Errorf("deny rules must precede allow rules:\n%s", script)
+	}
+	if strings.Index(script, private) > strings.Index(script, deny) {
+		t.Errorf("private rules must precede deny rules:\n%s", script)
+	}
+
+	if _, err := RenderNftTable(NftApplyParams{DirectiveID: "d", Deny: []string{"nope"}}); err == nil {
+		t.Error("expected error for invalid deny cidr")
+	}
+}
+
+func TestRenderNftTable_Invalid(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]NftApplyParams{
+		"directive id": {DirectiveID: "../x"},
+		"cidr":         {DirectiveID: "d", Allow: []NftRule{{CIDR: "1.2.3.4; flush ruleset", Proto: "tcp"}}},
+		"proto":        {DirectiveID: "d", Allow: []NftRule{{CIDR: "1.2.3.4", Proto: "icmp"}}},
+		"port":         {DirectiveID: "d", Allow: []NftRule{{CIDR: "1.2.3.4", Proto: "tcp", PortFrom: 70000}}},
+		"range":        {DirectiveID: "d", Allow: []NftRule{{CIDR: "1.2.3.4", Proto: "tcp", PortFrom: 20, PortTo: 10}}},
+		"port_to only": {DirectiveID: "d", Local: []NftRule{{CIDR: "1.2.3.4", Proto: "tcp", PortTo: 10}}},
+		"private cidr": {DirectiveID: "d", Private: []NftRule{{CIDR: "10.0.0.0/33", Proto: "tcp"}}},
+	}
+	for name, p := range cases {
+		if _, err := RenderNftTable(p); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestResourceNames(t *testing.T) {
+	t.Parallel()
+
+	id := "0f8e1c2a-7b7e-4f3e-9d1c-2b6a5e4d3c2b"
+	if n := TapName(id); len(n) > 15 {
+		t.Errorf("tap name %q exceeds IFNAMSIZ", n)
+	}
+	if TapName(id) == TapName(id+"x") {
+		t.Errorf("expected distinct names for distinct directives")
+	}
+	if TapName(id) != TapName(id) || NftTableName(id) != NftTableName(id) {
+		t.Errorf("expected stable names")
+	}
+}
@@ -0,0 +1,569 @@
+//go:build linux
+
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxCPUMillicores and maxMemoryMB mirror the sandbox cgroup bounds.
+	maxCPUMillicores = 1024000
+	maxMemoryMB      = 1 << 20
+	maxPids          = 1 << 22
+
+	// maxJailerArgs caps Firecracker arguments passed through jailer.launch.
+	maxJailerArgs = 32
+
+	// cgroupV2Root is where the jailer creates VMM cgroups.
+	cgroupV2Root = "/sys/fs/cgroup"
+)
+
+// --- cgroups ---
+
+func (s *Server) cgroupPath(directiveID string) string {
+	return filepath.Join(s.cfg.CgroupRoot, directiveID)
+}
+
+func (s *Server) cgroupCreate(p CgroupCreateParams) (CgroupResult, error) {
+	if !ValidDirectiveID(p.DirectiveID) {
+		return CgroupResult{}, invalidParams("invalid directive_id %q", p.DirectiveID)
+	}
+	if p.MemoryMB < 0 || p.MemoryMB > maxMemoryMB {
+		return CgroupResult{}, invalidParams("memory_mb out of range")
+	}
+	if p.CPUMillicores < 0 || p.CPUMillicores > maxCPUMillicores {
+		return CgroupResult{}, invalidParams("cpu_millicores out of range")
+	}
+	if p.PidsMax < 0 || p.PidsMax > maxPids {
+		return CgroupResult{}, invalidParams("pids_max out of range")
+	}
+
+	if err := os.MkdirAll(s.cfg.CgroupRoot, 0o755); err != nil {
+		return CgroupResult{}, fmt.Errorf("create cgroup root: %w", err)
+	}
+	// Enable the controllers for children; best effort since they may
+	// already be enabled (or unavailable, in which case the limit writes
+	// below fail).
+	_ = os.WriteFile(filepath.Join(s.cfg.CgroupRoot, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0o644)
+
+	path := s.cgroupPath(p.DirectiveID)
+	if err := os.Mkdir(path, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+		return CgroupResult{}, fmt.Errorf("create cgroup: %w", err)
+	}
+	if p.MemoryMB > 0 {
+		if err := writeCgroupFile(path, "memory.max", strconv.FormatInt(int64(p.MemoryMB)*1024*1024, 10)); err != nil {
+			return CgroupResult{}, err
+		}
+	}
+	if p.CPUMillicores > 0 {
+		if err := writeCgroupFile(path, "cpu.max", cpuMax(p.CPUMillicores)); err != nil {
+			return CgroupResult{}, err
+		}
+	}
+	if p.PidsMax > 0 {
+		if err := writeCgroupFile(path, "pids.max", strconv.Itoa(p.PidsMax)); err != nil {
+			return CgroupResult{}, err
+		}
+	}
+	return CgroupResult{Path: path}, nil
+}
+
+// cpuMax formats cpu.max for a millicore limit over a 100ms period.
+func cpuMax(millicores int) string {
+	const period = 100000
+	quota := max(millicores*period/1000, 1000)
+	return fmt.Sprintf("%d %d", quota, period)
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(value), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *Server) cgroupAttach(p CgroupAttachParams, cred PeerCred) error {
+	if !ValidDirectiveID(p.DirectiveID) {
+		return invalidParams("invalid directive_id %q", p.DirectiveID)
+	}
+	if p.PID <= 1 {
+		return invalidParams("invalid pid %d", p.PID)
+	}
+	// Only the caller's own children may be moved: a compromised nexusd
+	// must not be able to capture arbitrary host processes.
+	if !isDescendant(p.PID, cred.PID) {
+		return invalidParams("pid %d is not a descendant of the caller", p.PID)
+	}
+	path := s.cgroupPath(p.DirectiveID)
+	if _, err := os.Stat(path); err != nil {
+		return invalidParams("cgroup for %s does not exist", p.DirectiveID)
+	}
+	return writeCgroupFile(path, "cgroup.procs", strconv.Itoa(p.PID))
+}
+
+// isDescendant reports whether pid is ancestor or one of its descendants.
+func isDescendant(pid, ancestor int) bool {
+	for i := 0; i < 64 && pid > 1; i++ {
+		if pid == ancestor {
+			return true
+		}
+		ppid, err := parentPID(pid)
+		if err != nil {
+			return false
+		}
+		pid = ppid
+	}
+	return pid == ancestor
+}
+
+func parentPID(pid int) (int, error) {
+	b, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The comm field may contain spaces and parentheses; fields after the
+	// last ')' are "state ppid ...".
+	i := strings.LastIndexByte(string(b), ')')
+	if i < 0 {
+		return 0, errors.New("malformed stat")
+	}
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) < 2 {
+		return 0, errors.New("malformed stat")
+	}
+	return strconv.Atoi(fields[1])
+}
+
+// delegatedCgroupFiles are chowned with the directory on delegation (see
+// "Delegation Containment" in the cgroup v2 documentation).
+var delegatedCgroupFiles = []string{"cgroup.procs", "cgroup.threads", "cgroup.subtree_control"}
+
+func (s *Server) cgroupDelegate(p DirectiveParams, cred PeerCred) (CgroupResult, error) {
+	path := s.cgroupPath(p.DirectiveID)
+	if _, err := os.Stat(path); err != nil {
+		return CgroupResult{}, invalidParams("cgroup for %s is not created", p.DirectiveID)
+	}
+	if err := os.Chown(path, cred.UID, cred.GID); err != nil {
+		return CgroupResult{}, fmt.Errorf("delegate cgroup: %w", err)
+	}
+	for _, name := range delegatedCgroupFiles {
+		if err := os.Chown(filepath.Join(path, name), cred.UID, cred.GID); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return CgroupResult{}, fmt.Errorf("delegate cgroup: %w", err)
+		}
+	}
+	return CgroupResult{Path: path}, nil
+}
+
+func (s *Server) cgroupRemove(p DirectiveParams) error {
+	path := s.cgroupPath(p.DirectiveID)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	// cgroup.kill (Linux 5.14+) kills everything left, including processes
+	// in delegated sub-cgroups.
+	_ = os.WriteFile(filepath.Join(path, "cgroup.kill"), []byte("1"), 0o644)
+
+	var err error
+	for i := 0; i < 20; i++ {
+		if err = removeCgroupTree(path); err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("remove cgroup: %w", err)
+}
+
+// removeCgroupTree rmdirs path and its sub-cgroups, deepest first. cgroupfs
+// directories only contain interface files, which rmdir removes.
+func removeCgroupTree(path string) error {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			if err := removeCgroupTree(filepath.Join(path, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return syscall.Rmdir(path)
+}
+
+// --- network namespaces and TAP devices ---
+
+func (s *Server) netnsCreate(ctx context.Context, p DirectiveParams) (NetnsResult, error) {
+	name := NetnsName(p.DirectiveID)
+	res := NetnsResult{Name: name, Path: filepath.Join(s.cfg.NetnsDir, name)}
+	if _, err := os.Stat(res.Path); err == nil {
+		return res, nil
+	}
+	if err := s.run(ctx, nil, "ip", "netns", "add", name); err != nil {
+		return NetnsResult{}, err
+	}
+	if err := s.run(ctx, nil, "ip", "-n", name, "link", "set", "lo", "up"); err != nil {
+		return NetnsResult{}, err
+	}
+	return res, nil
+}
+
+func (s *Server) netnsDelete(ctx context.Context, p DirectiveParams) error {
+	name := NetnsName(p.DirectiveID)
+	if _, err := os.Stat(filepath.Join(s.cfg.NetnsDir, name)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return s.run(ctx, nil, "ip", "netns", "delete", name)
+}
+
+func (s *Server) tapCreate(ctx context.Context, p TapCreateParams, cred PeerCred) (TapResult, error) {
+	if !ValidDirectiveID(p.DirectiveID) {
+		return TapResult{}, invalidParams("invalid directive_id %q", p.DirectiveID)
+	}
+	prefix, err := netip.ParsePrefix(p.HostCIDR)
+	if err != nil || !prefix.Addr().Is4() || !prefix.Addr().IsPrivate() ||
+		prefix.Bits() < 16 || prefix.Bits() > 30 || prefix.Addr() == prefix.Masked().Addr() {
+		return TapResult{}, invalidParams("host_cidr must be a private IPv4 host address with a /16../30 prefix")
+	}
+
+	uid, gid := cred.UID, cred.GID
+	if p.Owner != 0 {
+		if cred.UID != 0 && !s.jailerUID(p.Owner) {
+			return TapResult{}, &Error{Code: CodeUnauthorized, Message: "owner may only be set by root or to a jailer uid"}
+		}
+		uid, gid = p.Owner, p.Owner
+	}
+
+	name := TapName(p.DirectiveID)
+	ip := s.ipCmd(p.DirectiveID, p.Netns)
+	// Recreate from scratch so a leftover device from a crashed run does not
+	// keep stale addresses or ownership.
+	_ = s.run(ctx, nil, "ip", ip("link", "del", name)...)
+	if err := s.run(ctx, nil, "ip", ip("tuntap", "add", "dev", name, "mode", "tap",
+		"user", strconv.Itoa(uid), "group", strconv.Itoa(gid))...); err != nil {
+		return TapResult{}, err
+	}
+	if err := s.run(ctx, nil, "ip", ip("addr", "add", prefix.String(), "dev", name)...); err != nil {
+		_ = s.run(ctx, nil, "ip", ip("link", "del", name)...)
+		return TapResult{}, err
+	}
+	if err := s.run(ctx, nil, "ip", ip("link", "set", name, "up")...); err != nil {
+		_ = s.run(ctx, nil, "ip", ip("link", "del", name)...)
+		return TapResult{}, err
+	}
+	return TapResult{Name: name}, nil
+}
+
+func (s *Server) tapDelete(ctx context.Context, p TapDeleteParams) error {
+	if !ValidDirectiveID(p.DirectiveID) {
+		return invalidParams("invalid directive_id %q", p.DirectiveID)
+	}
+	err := s.run(ctx, nil, "ip", s.ipCmd(p.DirectiveID, p.Netns)("link", "del", TapName(p.DirectiveID))...)
+	if err != nil && (strings.Contains(err.Error(), "Cannot find device") || strings.Contains(err.Error(), "No such file")) {
+		return nil
+	}
+	return err
+}
+
+// netSweep removes TAP devices (and their nft tables) left in the host
+// namespace by directives that never tore down, e.g. after nexusd crashed.
+// Devices and tables share the directive's resource key.
+func (s *Server) netSweep(ctx context.Context) (NetSweepResult, error) {
+	entries, err := os.ReadDir(s.cfg.SysClassNet)
+	if err != nil {
+		return NetSweepResult{}, err
+	}
+	res := NetSweepResult{Removed: []string{}}
+	var errs []error
+	for _, e := range entries {
+		key, ok := strings.CutPrefix(e.Name(), "nxtap")
+		if !ok {
+			continue
+		}
+		if err := s.run(ctx, nil, "nft", "delete", "table", "inet", "nexus_"+key); err != nil && !strings.Contains(err.Error(), "No such file") {
+			errs = append(errs, err)
+		}
+		if err := s.run(ctx, nil, "ip", "link", "del", e.Name()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		res.Removed = append(res.Removed, e.Name())
+	}
+	return res, errors.Join(errs...)
+}
+
+// ipCmd returns a function building `ip` arguments, inside the directive's
+// netns when inNetns is set.
+func (s *Server) ipCmd(directiveID string, inNetns bool) func(args ...string) []string {
+	return func(args ...string) []string {
+		if inNetns {
+			return append([]string{"-n", NetnsName(directiveID)}, args...)
+		}
+		return args
+	}
+}
+
+// --- nftables ---
+
+func (s *Server) nftApply(ctx context.Context, p NftApplyParams) (NftResult, error) {
+	script, err := RenderNftTable(p)
+	if err != nil {
+		return NftResult{}, invalidParams("%v", err)
+	}
+	if err := s.run(ctx, []byte(script), "nft", "-f", "-"); err != nil {
+		return NftResult{}, err
+	}
+	return NftResult{Table: NftTableName(p.DirectiveID)}, nil
+}
+
+func (s *Server) nftRemove(ctx context.Context, p DirectiveParams) error {
+	err := s.run(ctx, nil, "nft", "delete", "table", "inet", NftTableName(p.DirectiveID))
+	if err != nil && strings.Contains(err.Error(), "No such file") {
+		return nil
+	}
+	return err
+}
+
+// --- jailer ---
+
+// jailedProc is a Firecracker process started by jailer.launch.
+type jailedProc struct {
+	cmd      *exec.Cmd
+	done     chan struct{}
+	exitCode int
+}
+
+// jailerDir is <chroot_base>/<exec name>/<id>, the directory the jailer
+// creates (root/ inside it is the chroot).
+func (s *Server) jailerDir(directiveID string) string {
+	return filepath.Join(s.cfg.ChrootBase, filepath.Base(s.cfg.FirecrackerPath), JailerID(directiveID))
+}
+
+// jailerRoot is the chroot inside jailerDir.
+func (s *Server) jailerRoot(directiveID string) string {
+	return filepath.Join(s.jailerDir(directiveID), "root")
+}
+
+func (s *Server) jailerPrepare(p JailerPrepareParams, cred PeerCred) (JailerPrepareResult, error) {
+	if !ValidDirectiveID(p.DirectiveID) {
+		return JailerPrepareResult{}, invalidParams("invalid directive_id %q", p.DirectiveID)
+	}
+	if !s.jailerUID(p.UID) {
+		return JailerPrepareResult{}, invalidParams("uid %d is outside the jailer uid range", p.UID)
+	}
+	if s.cfg.JailerPath == "" || s.cfg.FirecrackerPath == "" {
+		return JailerPrepareResult{}, errors.New("jailer is not configured")
+	}
+	// Start from an empty chroot: anything left by a crashed run may belong
+	// to another VM's uid.
+	if err := os.RemoveAll(s.jailerDir(p.DirectiveID)); err != nil {
+		return JailerPrepareResult{}, fmt.Errorf("remove stale chroot: %w", err)
+	}
+	root := s.jailerRoot(p.DirectiveID)
+	if err := os.MkdirAll(filepath.Dir(root), 0o755); err != nil {
+		return JailerPrepareResult{}, fmt.Errorf("create jailer dir: %w", err)
+	}
+	if err := os.Mkdir(root, 0o770); err != nil {
+		return JailerPrepareResult{}, fmt.Errorf("create chroot: %w", err)
+	}
+	// The caller stages files; Firecracker (gid UID) uses them through the
+	// group, which setgid makes every new file inherit.
+	if err := os.Chown(root, cred.UID, p.UID); err != nil {
+		return JailerPrepareResult{}, fmt.Errorf("chown chroot: %w", err)
+	}
+	if err := os.Chmod(root, 0o770|os.ModeSetgid); err != nil {
+		return JailerPrepareResult{}, fmt.Errorf("chmod chroot: %w", err)
+	}
+	return JailerPrepareResult{ID: JailerID(p.DirectiveID), Root: root}, nil
+}
+
+// allowedFirecrackerFlags are the Firecracker flags jailer.launch passes on.
+var allowedFirecrackerFlags = map[string]bool{
+	"--config-file": true, "--no-api": true, "--api-sock": true,
+	"--log-path": true, "--level": true, "--metrics-path": true, "--boot-timer": true,
+	"--show-level": true, "--show-log-origin": true,
+}
+
+func validateFirecrackerArgs(args []string) error {
+	if len(args) > maxJailerArgs {
+		return fmt.Errorf("too many args (max %d)", maxJailerArgs)
+	}
+	for _, a := range args {
+		if strings.HasPrefix(a, "-") {
+			if !allowedFirecrackerFlags[a] {
+				return fmt.Errorf("firecracker flag %q is not allowed", a)
+			}
+			continue
+		}
+		if a == "" || strings.ContainsRune(a, 0) || strings.Contains(a, "..") {
+			return fmt.Errorf("invalid argument %q", a)
+		}
+	}
+	return nil
+}
+
+func (s *Server) jailerLaunch(p JailerLaunchParams, files []*os.File) (JailerLaunchResult, error) {
+	if !ValidDirectiveID(p.DirectiveID) {
+		return JailerLaunchResult{}, invalidParams("invalid directive_id %q", p.DirectiveID)
+	}
+	if len(files) != 3 {
+		return JailerLaunchResult{}, invalidParams("jailer.launch needs stdin, stdout and stderr descriptors")
+	}
+	if p.MemoryMB < 0 || p.MemoryMB > maxMemoryMB || p.CPUMillicores < 0 || p.CPUMillicores > maxCPUMillicores {
+		return JailerLaunchResult{}, invalidParams("limits out of range")
+	}
+	if err := validateFirecrackerArgs(p.Args); err != nil {
+		return JailerLaunchResult{}, invalidParams("%v", err)
+	}
+	if !s.jailerUID(p.UID) {
+		return JailerLaunchResult{}, invalidParams("uid %d is outside the jailer uid range", p.UID)
+	}
+	if s.cfg.JailerPath == "" || s.cfg.FirecrackerPath == "" {
+		return JailerLaunchResult{}, errors.New("jailer is not configured")
+	}
+	// The uid must be the one jailer.prepare gave the chroot's group.
+	fi, err := os.Stat(s.jailerRoot(p.DirectiveID))
+	if err != nil {
+		return JailerLaunchResult{}, invalidParams("chroot for %s is not prepared", p.DirectiveID)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); !ok || int(st.Gid) != p.UID {
+		return JailerLaunchResult{}, invalidParams("chroot for %s was prepared for another uid", p.DirectiveID)
+	}
+
+	id := JailerID(p.DirectiveID)
+	args := []string{
+		"--id", id,
+		"--exec-file", s.cfg.FirecrackerPath,
+		"--uid", strconv.Itoa(p.UID),
+		"--gid", strconv.Itoa(p.UID),
+		"--chroot-base-dir", s.cfg.ChrootBase,
+		"--cgroup-version", "2",
+		"--parent-cgroup", s.cfg.JailerParentCgroup,
+	}
+	if p.Netns {
+		netns := filepath.Join(s.cfg.NetnsDir, NetnsName(p.DirectiveID))
+		if _, err := os.Stat(netns); err != nil {
+			return JailerLaunchResult{}, invalidParams("netns for %s is not created", p.DirectiveID)
+		}
+		args = append(args, "--netns", netns)
+	}
+	if p.MemoryMB > 0 {
+		args = append(args, "--cgroup", "memory.max="+strconv.FormatInt(int64(p.MemoryMB)*1024*1024, 10))
+	}
+	if p.CPUMillicores > 0 {
+		args = append(args, "--cgroup", "cpu.max="+cpuMax(p.CPUMillicores))
+	}
+	args = append(args, "--")
+	args = append(args, p.Args...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if jp, ok := s.jailed[id]; ok {
+		select {
+		case <-jp.done:
+		default:
+			return JailerLaunchResult{}, invalidParams("jailer for %s is already running", p.DirectiveID)
+		}
+	}
+
+	cmd := exec.Command(s.cfg.JailerPath, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = files[0], files[1], files[2]
+	cmd.Env = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return JailerLaunchResult{}, fmt.Errorf("start jailer: %w", err)
+	}
+	jp := &jailedProc{cmd: cmd, done: make(chan struct{})}
+	s.jailed[id] = jp
+	go func() {
+		jp.exitCode = exitCode(cmd.Wait())
+		close(jp.done)
+	}()
+	return JailerLaunchResult{PID: cmd.Process.Pid}, nil
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var ee *exec.ExitError
+	if errors.As(err, &ee) {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+			if ws.Signaled() {
+				return 128 + int(ws.Signal())
+			}
+			return ws.ExitStatus()
+		}
+	}
+	return 1
+}
+
+func (s *Server) lookupJailed(directiveID string) (*jailedProc, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jp, ok := s.jailed[JailerID(directiveID)]
+	if !ok {
+		return nil, invalidParams("no jailer for %s", directiveID)
+	}
+	return jp, nil
+}
+
+func (s *Server) jailerWait(ctx context.Context, p DirectiveParams) (JailerWaitResult, error) {
+	jp, err := s.lookupJailed(p.DirectiveID)
+	if err != nil {
+		return JailerWaitResult{}, err
+	}
+	select {
+	case <-jp.done:
+		return JailerWaitResult{ExitCode: jp.exitCode}, nil
+	case <-ctx.Done():
+		return JailerWaitResult{}, ctx.Err()
+	}
+}
+
+func (s *Server) jailerKill(p DirectiveParams) error {
+	jp, err := s.lookupJailed(p.DirectiveID)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-jp.done:
+		return nil
+	default:
+	}
+	if err := syscall.Kill(-jp.cmd.Process.Pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) jailerCleanup(p DirectiveParams) error {
+	if jp, err := s.lookupJailed(p.DirectiveID); err == nil {
+		_ = s.jailerKill(p)
+		select {
+		case <-jp.done:
+		case <-time.After(5 * time.Second):
+			return errors.New("jailed process did not exit")
+		}
+		s.mu.Lock()
+		delete(s.jailed, JailerID(p.DirectiveID))
+		s.mu.Unlock()
+	}
+	if s.cfg.FirecrackerPath == "" {
+		return nil
+	}
+	// The jailer leaves the VMM's cgroup behind; it is empty once the VMM
+	// has exited.
+	_ = os.Remove(filepath.Join(cgroupV2Root, s.cfg.JailerParentCgroup, JailerID(p.DirectiveID)))
+	return os.RemoveAll(s.jailerDir(p.DirectiveID))
+}
@@ -0,0 +1,250 @@
+// Package helper implements the nexusd ↔ nexus-helper privileged RPC.
+//
+// nexus-helper runs as root and listens on a Unix socket (default
+// /run/cybros-nexus/helper.sock). nexusd runs unprivileged and asks the
+// helper for the few operations that need root: cgroup setup, TAP/netns
+// creation, nftables rules and launching the Firecracker jailer.
+//
+// The wire format is one JSON object per line in each direction, one request
+// per connection. Every request is authorized by the caller's peer
+// credentials (SO_PEERCRED) and strictly validated; resource names (TAP
+// devices, network namespaces, nft tables, jailer ids) are derived from the
+// directive ID by the helper, never supplied by the caller.
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// ProtocolVersion is the RPC version spoken by this package. The helper
+// rejects requests with a different version.
+const ProtocolVersion = 1
+
+// DefaultSocketPath is where nexus-helper listens by default.
+const DefaultSocketPath = "/run/cybros-nexus/helper.sock"
+
+// Operations.
+const (
+	OpPing           = "ping"
+	OpCgroupCreate   = "cgroup.create"
+	OpCgroupAttach   = "cgroup.attach"
+	OpCgroupDelegate = "cgroup.delegate"
+	OpCgroupRemove   = "cgroup.remove"
+	OpNetnsCreate    = "netns.create"
+	OpNetnsDelete    = "netns.delete"
+	OpTapCreate      = "tap.create"
+	OpTapDelete      = "tap.delete"
+	OpNftApply       = "nft.apply"
+	OpNftRemove      = "nft.remove"
+	OpNetSweep       = "net.sweep"
+	OpJailerPrepare  = "jailer.prepare"
+	OpJailerLaunch   = "jailer.launch"
+	OpJailerWait     = "jailer.wait"
+	OpJailerKill     = "jailer.kill"
+	OpJailerCleanup  = "jailer.cleanup"
+)
+
+// Error codes.
+const (
+	CodeUnauthorized       = "unauthorized"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownOp          = "unknown_op"
+	CodeInvalidParams      = "invalid_params"
+	CodeFailed             = "failed"
+)
+
+// Request is one RPC call.
+type Request struct {
+	Version int             `json:"version"`
+	Op      string          `json:"op"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is the reply to a Request. Exactly one of Error and Result is set
+// (Result may be empty for operations without output).
+type Response struct {
+	Version int             `json:"version"`
+	OK      bool            `json:"ok"`
+	Error   *Error          `json:"error,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+// Error is an RPC failure reported by the helper.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("nexus-helper: %s: %s", e.Code, e.Message)
+}
+
+// PingResult reports the helper's version and supported operations.
+type PingResult struct {
+	Version int      `json:"version"`
+	Ops     []string `json:"ops"`
+}
+
+// DirectiveParams identify the directive an operation applies to.
+type DirectiveParams struct {
+	DirectiveID string `json:"directive_id"`
+}
+
+// CgroupCreateParams create /sys/fs/cgroup/nexusd/<directive_id> with the
+// given limits (zero means unlimited).
+type CgroupCreateParams struct {
+	DirectiveID   string `json:"directive_id"`
+	MemoryMB      int    `json:"memory_mb,omitempty"`
+	CPUMillicores int    `json:"cpu_millicores,omitempty"`
+	PidsMax       int    `json:"pids_max,omitempty"`
+}
+
+// CgroupResult reports the cgroup path.
+type CgroupResult struct {
+	Path string `json:"path"`
+}
+
+// CgroupAttachParams move a process into the directive's cgroup. The process
+// must be a descendant of the caller.
+type CgroupAttachParams struct {
+	DirectiveID string `json:"directive_id"`
+	PID         int    `json:"pid"`
+}
+
+// NetnsResult reports the network namespace name and bind-mount path.
+type NetnsResult struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// TapCreateParams create the directive's TAP device owned by the caller.
+// HostCIDR is the host-side address (e.g. 172.16.0.1/30). With Netns the
+// device is created inside the directive's network namespace.
+// Owner, if set, owns the device (uid and gid) instead of the caller; root
+// callers may name any uid, others only one from the jailer uid range.
+type TapCreateParams struct {
+	DirectiveID string `json:"directive_id"`
+	HostCIDR    string `json:"host_cidr"`
+	Netns       bool   `json:"netns,omitempty"`
+	Owner       int    `json:"owner,omitempty"`
+}
+
+// TapDeleteParams remove the directive's TAP device.
+type TapDeleteParams struct {
+	DirectiveID string `json:"directive_id"`
+	Netns       bool   `json:"netns,omitempty"`
+}
+
+// TapResult reports the TAP device name.
+type TapResult struct {
+	Name string `json:"name"`
+}
+
+// NftApplyParams (re)install the directive's nftables table. Traffic from
+// the directive's TAP device is dropped unless it matches Allow (forwarded
+// traffic) or Local (traffic to the host itself, e.g. the egress proxy).
+// Forwarded traffic to a Deny CIDR is dropped even if Allow matches, but
+// Private rules are checked before Deny: they open the private
+// destinations a directive opted into.
+// Masquerade source-NATs forwarded traffic; DNSRedirectPort, if set,
+// redirects all UDP DNS from the device to that host port (which Local must
+// then allow). CutEstablished drops forwarded flows established under
+// earlier rules too, e.g. when a time-boxed grant ends.
+type NftApplyParams struct {
+	DirectiveID     string    `json:"directive_id"`
+	Allow           []NftRule `json:"allow,omitempty"`
+	Private         []NftRule `json:"private,omitempty"`
+	Local           []NftRule `json:"local,omitempty"`
+	Deny            []string  `json:"deny,omitempty"`
+	Masquerade      bool      `json:"masquerade,omitempty"`
+	DNSRedirectPort int       `json:"dns_redirect_port,omitempty"`
+	CutEstablished  bool      `json:"cut_established,omitempty"`
+}
+
+// NftRule allows traffic to CIDR (IPv4 or IPv6) over Proto ("tcp" or "udp")
+// on ports PortFrom..PortTo (PortTo 0 means PortFrom only; PortFrom 0 means
+// any port).
+type NftRule struct {
+	CIDR     string `json:"cidr"`
+	Proto    string `json:"proto"`
+	PortFrom int    `json:"port_from,omitempty"`
+	PortTo   int    `json:"port_to,omitempty"`
+}
+
+// NetSweepResult lists the TAP devices removed by net.sweep.
+type NetSweepResult struct {
+	Removed []string `json:"removed"`
+}
+
+// NftResult reports the table name.
+type NftResult struct {
+	Table string `json:"table"`
+}
+
+// JailerPrepareParams create the directive's jailer chroot for a Firecracker
+// running as UID (and gid UID), which must lie in the helper's jailer uid
+// range. The caller picks UID so each VM gets its own.
+type JailerPrepareParams struct {
+	DirectiveID string `json:"directive_id"`
+	UID         int    `json:"uid"`
+}
+
+// JailerPrepareResult reports the jailer chroot for a directive. Root is
+// owned by the caller and group UID, with the setgid bit, so the caller can
+// stage the kernel, rootfs and VM config and make them group-accessible to
+// the VMM.
+type JailerPrepareResult struct {
+	ID   string `json:"id"`
+	Root string `json:"root"`
+}
+
+// JailerLaunchParams start Firecracker under the jailer. The caller passes
+// stdin/stdout/stderr as file descriptors with the request (SCM_RIGHTS).
+// Args are passed to Firecracker after "--"; paths in them are relative to
+// the chroot. UID must match the one the chroot was prepared for.
+type JailerLaunchParams struct {
+	DirectiveID   string   `json:"directive_id"`
+	UID           int      `json:"uid"`
+	Netns         bool     `json:"netns,omitempty"`
+	MemoryMB      int      `json:"memory_mb,omitempty"`
+	CPUMillicores int      `json:"cpu_millicores,omitempty"`
+	Args          []string `json:"args,omitempty"`
+}
+
+// JailerLaunchResult reports the jailer process.
+type JailerLaunchResult struct {
+	PID int `json:"pid"`
+}
+
+// JailerWaitResult reports how the jailed process exited.
+type JailerWaitResult struct {
+	ExitCode int `json:"exit_code"`
+}
+
+var directiveIDRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,127}$`)
+
+// ValidDirectiveID reports whether id is acceptable as a directive ID.
+func ValidDirectiveID(id string) bool { return directiveIDRe.MatchString(id) }
+
+// resourceKey is a short stable key for names with tight length limits
+// (interface names are at most 15 bytes).
+func resourceKey(directiveID string) string {
+	sum := sha256.Sum256([]byte(directiveID))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// TapName returns the TAP device name the helper uses for a directive.
+func TapName(directiveID string) string { return "nxtap" + resourceKey(directiveID) }
+
+// NetnsName returns the network namespace name used for a directive.
+func NetnsName(directiveID string) string { return "nexus-" + resourceKey(directiveID) }
+
+// NftTableName returns the nftables table (family inet) used for a directive.
+func NftTableName(directiveID string) string { return "nexus_" + resourceKey(directiveID) }
+
+// JailerID returns the jailer --id used for a directive.
+func JailerID(directiveID string) string { return "nexus-" + resourceKey(directiveID) }
@@ -0,0 +1,476 @@
+//go:build linux
+
+package helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// requestReadTimeout bounds how long a client may take to send its request.
+const requestReadTimeout = 10 * time.Second
+
+// ServerConfig configures nexus-helper.
+type ServerConfig struct {
+	// SocketPath is the Unix socket to listen on.
+	SocketPath string
+	// SocketGID, if >= 0, is the group owning the socket (mode 0660).
+	SocketGID int
+
+	// AllowedUIDs and AllowedGIDs authorize callers by peer credentials.
+	AllowedUIDs []int
+	AllowedGIDs []int
+
+	// CgroupRoot is the parent cgroup for directives. Default /sys/fs/cgroup/nexusd.
+	CgroupRoot string
+	// NetnsDir is where `ip netns` bind-mounts namespaces. Default /var/run/netns.
+	NetnsDir string
+	// SysClassNet lists network devices for net.sweep. Default /sys/class/net.
+	SysClassNet string
+
+	// JailerPath and FirecrackerPath are the binaries launched by
+	// jailer.launch; callers cannot choose them.
+	JailerPath      string
+	FirecrackerPath string
+	// ChrootBase is the jailer --chroot-base-dir. Default /srv/jailer.
+	ChrootBase string
+	// JailerParentCgroup is the jailer --parent-cgroup (relative to the
+	// cgroup v2 root). Default nexus-firecracker.
+	JailerParentCgroup string
+	// JailerUIDStart and JailerUIDCount bound the uids Firecracker may run as
+	// (gid = uid). nexusd gives each VM its own uid from its
+	// firecracker.jailer uid range, which must lie within this one.
+	JailerUIDStart int
+	JailerUIDCount int
+}
+
+// Runner runs an external command (ip, nft) with optional stdin.
+type Runner func(ctx context.Context, stdin []byte, name string, args ...string) error
+
+// ExecRunner runs commands with os/exec, folding their output into errors.
+func ExecRunner(ctx context.Context, stdin []byte, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %v: %w: %s", name, args, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// PeerCred identifies the process on the other end of a connection.
+type PeerCred struct {
+	PID int
+	UID int
+	GID int
+}
+
+// Server serves the helper RPC.
+type Server struct {
+	cfg ServerConfig
+	run Runner
+
+	mu     sync.Mutex
+	jailed map[string]*jailedProc // by jailer id
+}
+
+// NewServer returns a Server. run executes ip/nft commands (ExecRunner in
+// production).
+func NewServer(cfg ServerConfig, run Runner) *Server {
+	if cfg.CgroupRoot == "" {
+		cfg.CgroupRoot = "/sys/fs/cgroup/nexusd"
+	}
+	if cfg.NetnsDir == "" {
+		cfg.NetnsDir = "/var/run/netns"
+	}
+	if cfg.SysClassNet == "" {
+		cfg.SysClassNet = "/sys/class/net"
+	}
+	if cfg.ChrootBase == "" {
+		cfg.ChrootBase = "/srv/jailer"
+	}
+	if cfg.JailerParentCgroup == "" {
+		cfg.JailerParentCgroup = "nexus-firecracker"
+	}
+	if run == nil {
+		run = ExecRunner
+	}
+	return &Server{cfg: cfg, run: run, jailed: map[string]*jailedProc{}}
+}
+
+// Listen creates the socket (replacing a stale one) with mode 0660 and the
+// configured group.
+func (s *Server) Listen() (*net.UnixListener, error) {
+	if err := os.MkdirAll(filepath.Dir(s.cfg.SocketPath), 0o750); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.cfg.SocketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: s.cfg.SocketPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(s.cfg.SocketPath, 0o660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if s.cfg.SocketGID >= 0 {
+		if err := os.Chown(s.cfg.SocketPath, -1, s.cfg.SocketGID); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// Serve accepts connections until ctx is canceled.
+func (s *Server) Serve(ctx context.Context, ln *net.UnixListener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handleConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) handleConn(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+
+	cred, err := peerCred(conn)
+	if err != nil {
+		slog.Warn("helper: peer credentials unavailable", "error", err)
+		return
+	}
+	if !s.authorized(cred) {
+		slog.Warn("helper: unauthorized peer", "peer_uid", cred.UID, "peer_gid", cred.GID, "peer_pid", cred.PID)
+		writeResponse(conn, errorResponse(CodeUnauthorized, "caller is not allowed"))
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(requestReadTimeout))
+	line, files, err := readRequest(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	defer closeFiles(files)
+	if err != nil {
+		writeResponse(conn, errorResponse(CodeInvalidParams, err.Error()))
+		return
+	}
+
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		writeResponse(conn, errorResponse(CodeInvalidParams, "malformed request"))
+		return
+	}
+	if req.Version != ProtocolVersion {
+		writeResponse(conn, errorResponse(CodeUnsupportedVersion, fmt.Sprintf("helper speaks version %d", ProtocolVersion)))
+		return
+	}
+
+	// A request is abandoned when its client hangs up (e.g. a canceled
+	// jailer.wait); the client never sends anything after the request line.
+	reqCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		var b [1]byte
+		_, _ = conn.Read(b[:])
+		cancel()
+	}()
+
+	result, err := s.dispatch(reqCtx, cred, req, files)
+	var directive DirectiveParams
+	_ = json.Unmarshal(req.Params, &directive)
+	attrs := []any{"op", req.Op, "directive_id", directive.DirectiveID,
+		"peer_uid", cred.UID, "peer_pid", cred.PID}
+	if err != nil {
+		slog.Warn("helper op failed", append(attrs, "error", err)...)
+		writeResponse(conn, errorResponseFor(err))
+		return
+	}
+	slog.Info("helper op", attrs...)
+
+	resp := Response{Version: ProtocolVersion, OK: true}
+	if result != nil {
+		resp.Result, _ = json.Marshal(result)
+	}
+	writeResponse(conn, resp)
+}
+
+func (s *Server) authorized(c PeerCred) bool {
+	return slices.Contains(s.cfg.AllowedUIDs, c.UID) || slices.Contains(s.cfg.AllowedGIDs, c.GID)
+}
+
+// jailerUID reports whether uid is in the configured jailer uid range.
+func (s *Server) jailerUID(uid int) bool {
+	return s.cfg.JailerUIDCount > 0 && uid >= s.cfg.JailerUIDStart && uid < s.cfg.JailerUIDStart+s.cfg.JailerUIDCount
+}
+
+// ops lists the supported operations (reported by ping).
+var ops = []string{
+	OpPing,
+	OpCgroupCreate, OpCgroupAttach, OpCgroupDelegate, OpCgroupRemove,
+	OpNetnsCreate, OpNetnsDelete,
+	OpTapCreate, OpTapDelete,
+	OpNftApply, OpNftRemove, OpNetSweep,
+	OpJailerPrepare, OpJailerLaunch, OpJailerWait, OpJailerKill, OpJailerCleanup,
+}
+
+func (s *Server) dispatch(ctx context.Context, cred PeerCred, req Request, files []*os.File) (any, error) {
+	if req.Op != OpJailerLaunch && len(files) > 0 {
+		return nil, invalidParams("%s does not take file descriptors", req.Op)
+	}
+	switch req.Op {
+	case OpPing:
+		return PingResult{Version: ProtocolVersion, Ops: ops}, nil
+	case OpCgroupCreate:
+		var p CgroupCreateParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.cgroupCreate(p)
+	case OpCgroupAttach:
+		var p CgroupAttachParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.cgroupAttach(p, cred)
+	case OpCgroupDelegate:
+		p, err := decodeDirective(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.cgroupDelegate(p, cred)
+	case OpCgroupRemove:
+		p, err := decodeDirective(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.cgroupRemove(p)
+	case OpNetnsCreate:
+		p, err := decodeDirective(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.netnsCreate(ctx, p)
+	case OpNetnsDelete:
+		p, err := decodeDirective(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.netnsDelete(ctx, p)
+	case OpTapCreate:
+		var p TapCreateParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.tapCreate(ctx, p, cred)
+	case OpTapDelete:
+		var p TapDeleteParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return nil, s.tapDelete(ctx, p)
+	case OpNftApply:
+		var p NftApplyParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.nftApply(ctx, p)
+	case OpNftRemove:
+		p, err := decodeDirective(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.nftRemove(ctx, p)
+	case OpNetSweep:
+		if err := decodeParams(req.Params, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return s.netSweep(ctx)
+	case OpJailerPrepare:
+		var p JailerPrepareParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.jailerPrepare(p, cred)
+	case OpJailerLaunch:
+		var p JailerLaunchParams
+		if err := decodeParams(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.jailerLaunch(p, files)
+	case OpJailerWait:
+		p, err := decodeDirective(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.jailerWait(ctx, p)
+	case OpJailerKill:
+		p, err := decodeDirective(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.jailerKill(p)
+	case OpJailerCleanup:
+		p, err := decodeDirective(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.jailerCleanup(p)
+	default:
+		return nil, &Error{Code: CodeUnknownOp, Message: fmt.Sprintf("unknown op %q", req.Op)}
+	}
+}
+
+// decodeParams strictly decodes params (unknown fields are rejected).
+func decodeParams(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return invalidParams("params are required")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return invalidParams("%v", err)
+	}
+	return nil
+}
+
+func decodeDirective(raw json.RawMessage) (DirectiveParams, error) {
+	var p DirectiveParams
+	if err := decodeParams(raw, &p); err != nil {
+		return p, err
+	}
+	if !ValidDirectiveID(p.DirectiveID) {
+		return p, invalidParams("invalid directive_id %q", p.DirectiveID)
+	}
+	return p, nil
+}
+
+func invalidParams(format string, args ...any) *Error {
+	return &Error{Code: CodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+func errorResponse(code, msg string) Response {
+	return Response{Version: ProtocolVersion, Error: &Error{Code: code, Message: msg}}
+}
+
+func errorResponseFor(err error) Response {
+	var herr *Error
+	if errors.As(err, &herr) {
+		return Response{Version: ProtocolVersion, Error: herr}
+	}
+	return errorResponse(CodeFailed, err.Error())
+}
+
+func writeResponse(conn net.Conn, resp Response) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(requestReadTimeout))
+	_, _ = conn.Write(append(b, '\n'))
+}
+
+// readRequest reads one request line and any file descriptors sent with it.
+func readRequest(conn *net.UnixConn) ([]byte, []*os.File, error) {
+	var (
+		line  []byte
+		files []*os.File
+	)
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(3*4))
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if oobn > 0 {
+			fs, perr := parseRights(oob[:oobn])
+			files = append(files, fs...)
+			if perr != nil {
+				return nil, files, perr
+			}
+		}
+		line = append(line, buf[:n]...)
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			return line[:i], files, nil
+		}
+		if len(line) > maxMessageBytes {
+			return nil, files, errors.New("request too large")
+		}
+		if err != nil {
+			return nil, files, fmt.Errorf("read request: %w", err)
+		}
+	}
+}
+
+func parseRights(oob []byte) ([]*os.File, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var files []*os.File
+	for _, m := range msgs {
+		fds, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			files = append(files, os.NewFile(uintptr(fd), "helper-fd"))
+		}
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// peerCred returns the SO_PEERCRED credentials of conn.
+func peerCred(conn *net.UnixConn) (PeerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCred{}, err
+	}
+	var (
+		ucred *syscall.Ucred
+		uerr  error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		ucred, uerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return PeerCred{}, err
+	}
+	if uerr != nil {
+		return PeerCred{}, uerr
+	}
+	return PeerCred{PID: int(ucred.Pid), UID: int(ucred.Uid), GID: int(ucred.Gid)}, nil
+}
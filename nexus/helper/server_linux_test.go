@@ -0,0 +1,377 @@
+//go:build linux
+
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// recordingRunner records ip/nft invocations instead of running them.
+type recordingRunner struct {
+	mu    sync.Mutex
+	calls []string
+	stdin []string
+}
+
+func (r *recordingRunner) run(_ context.Context, stdin []byte, name string, args ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, name+" "+strings.Join(args, " "))
+	r.stdin = append(r.stdin, string(stdin))
+	return nil
+}
+
+func startTestServer(t *testing.T, cfg ServerConfig) (*Client, *recordingRunner) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg.SocketPath = filepath.Join(dir, "helper.sock")
+	cfg.SocketGID = -1
+	if cfg.CgroupRoot == "" {
+		cfg.CgroupRoot = filepath.Join(dir, "cgroup")
+	}
+	if cfg.NetnsDir == "" {
+		cfg.NetnsDir = filepath.Join(dir, "netns")
+	}
+	rr := &recordingRunner{}
+	srv := NewServer(cfg, rr.run)
+	ln, err := srv.Listen()
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = srv.Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return NewClient(cfg.SocketPath), rr
+}
+
+func selfAllowed() ServerConfig {
+	return ServerConfig{AllowedUIDs: []int{os.Getuid()}}
+}
+
+func TestServer_PingAndSocketMode(t *testing.T) {
+	t.Parallel()
+
+	c, _ := startTestServer(t, selfAllowed())
+	res, err := c.Ping(context.Background())
+	if err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	if res.Version != ProtocolVersion || len(res.Ops) == 0 {
+		t.Fatalf("unexpected ping result: %+v", res)
+	}
+	st, err := os.Stat(c.SocketPath())
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if st.Mode().Perm() != 0o660 {
+		t.Fatalf("expected socket mode 0660, got %v", st.Mode().Perm())
+	}
+}
+
+func TestServer_RejectsUnauthorizedPeer(t *testing.T) {
+	t.Parallel()
+
+	c, _ := startTestServer(t, ServerConfig{AllowedUIDs: []int{os.Getuid() + 12345}})
+	_, err := c.Ping(context.Background())
+	var herr *Error
+	if !errors.As(err, &herr) || herr.Code != CodeUnauthorized {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+}
+
+func TestServer_RejectsInvalidParams(t *testing.T) {
+	t.Parallel()
+
+	c, rr := startTestServer(t, selfAllowed())
+	ctx := context.Background()
+	for name, call := range map[string]func() error{
+		"cgroup traversal": func() error {
+			_, err := c.CreateCgroup(ctx, CgroupCreateParams{DirectiveID: "../../etc"})
+			return err
+		},
+		"cgroup memory": func() error {
+			_, err := c.CreateCgroup(ctx, CgroupCreateParams{DirectiveID: "d1", MemoryMB: -1})
+			return err
+		},
+		"tap public cidr": func() error {
+			_, err := c.CreateTap(ctx, TapCreateParams{DirectiveID: "d1", HostCIDR: "8.8.8.8/30"})
+			return err
+		},
+		"tap network address": func() error {
+			_, err := c.CreateTap(ctx, TapCreateParams{DirectiveID: "d1", HostCIDR: "172.16.0.0/30"})
+			return err
+		},
+		"nft injection": func() error {
+			_, err := c.ApplyNft(ctx, NftApplyParams{DirectiveID: "d1", Allow: []NftRule{{CIDR: "0.0.0.0/0; flush ruleset", Proto: "tcp"}}})
+			return err
+		},
+		"attach foreign pid": func() error {
+			return c.AttachCgroup(ctx, "d1", 1)
+		},
+		"delegate missing cgroup": func() error {
+			_, err := c.DelegateCgroup(ctx, "d1")
+			return err
+		},
+		"netns traversal": func() error {
+			_, err := c.CreateNetns(ctx, "../../proc/1/ns/net")
+			return err
+		},
+	} {
+		var herr *Error
+		if err := call(); !errors.As(err, &herr) || herr.Code != CodeInvalidParams {
+			t.Errorf("%s: expected invalid_params, got %v", name, err)
+		}
+	}
+	if len(rr.calls) != 0 {
+		t.Fatalf("expected no commands for rejected requests, got %v", rr.calls)
+	}
+
+	var herr *Error
+	err := c.call(ctx, OpCgroupCreate, map[string]any{"directive_id": "d1", "path": "/etc"}, nil)
+	if !errors.As(err, &herr) || herr.Code != CodeInvalidParams {
+		t.Fatalf("expected unknown fields to be rejected, got %v", err)
+	}
+	if err := c.call(ctx, "mount", nil, nil); !errors.As(err, &herr) || herr.Code != CodeUnknownOp {
+		t.Fatalf("expected unknown_op, got %v", err)
+	}
+}
+
+func TestServer_Cgroup(t *testing.T) {
+	t.Parallel()
+
+	c, _ := startTestServer(t, selfAllowed())
+	ctx := context.Background()
+
+	res, err := c.CreateCgroup(ctx, CgroupCreateParams{DirectiveID: "d1", MemoryMB: 256, CPUMillicores: 500, PidsMax: 64})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for file, want := range map[string]string{
+		"memory.max": "268435456",
+		"cpu.max":    "50000 100000",
+		"pids.max":   "64",
+	} {
+		b, err := os.ReadFile(filepath.Join(res.Path, file))
+		if err != nil || string(b) != want {
+			t.Errorf("%s: got %q (%v), want %q", file, b, err, want)
+		}
+	}
+
+	cmd := exec.Command("sleep", "5")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start child: %v", err)
+	}
+	defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+	if err := c.AttachCgroup(ctx, "d1", cmd.Process.Pid); err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(res.Path, "cgroup.procs")); string(b) != strconv.Itoa(cmd.Process.Pid) {
+		t.Fatalf("cgroup.procs = %q", b)
+	}
+
+	if _, err := c.DelegateCgroup(ctx, "d1"); err != nil {
+		t.Fatalf("delegate: %v", err)
+	}
+	for _, name := range append([]string{"."}, delegatedCgroupFiles...) {
+		fi, err := os.Stat(filepath.Join(res.Path, name))
+		if err != nil {
+			continue // interface files exist only on a real cgroupfs
+		}
+		if st := fi.Sys().(*syscall.Stat_t); int(st.Uid) != os.Getuid() || int(st.Gid) != os.Getgid() {
+			t.Errorf("%s owned by %d:%d after delegation", name, st.Uid, st.Gid)
+		}
+	}
+}
+
+func TestServer_NetworkCommands(t *testing.T) {
+	t.Parallel()
+
+	c, rr := startTestServer(t, selfAllowed())
+	ctx := context.Background()
+
+	ns, err := c.CreateNetns(ctx, "d1")
+	if err != nil || ns.Name != NetnsName("d1") {
+		t.Fatalf("netns: %+v %v", ns, err)
+	}
+	tap, err := c.CreateTap(ctx, TapCreateParams{DirectiveID: "d1", HostCIDR: "172.16.0.1/30", Netns: true})
+	if err != nil || tap.Name != TapName("d1") {
+		t.Fatalf("tap: %+v %v", tap, err)
+	}
+	nft, err := c.ApplyNft(ctx, NftApplyParams{DirectiveID: "d1", Local: []NftRule{{CIDR: "172.16.0.1", Proto: "tcp", PortFrom: 9080}}})
+	if err != nil || nft.Table != NftTableName("d1") {
+		t.Fatalf("nft: %+v %v", nft, err)
+	}
+	if err := c.RemoveNft(ctx, "d1"); err != nil {
+		t.Fatalf("nft remove: %v", err)
+	}
+
+	uid, gid := strconv.Itoa(os.Getuid()), strconv.Itoa(os.Getgid())
+	want := []string{
+		"ip netns add " + ns.Name,
+		"ip -n " + ns.Name + " link set lo up",
+		"ip -n " + ns.Name + " link del " + tap.Name,
+		"ip -n " + ns.Name + " tuntap add dev " + tap.Name + " mode tap user " + uid + " group " + gid,
+		"ip -n " + ns.Name + " addr add 172.16.0.1/30 dev " + tap.Name,
+		"ip -n " + ns.Name + " link set " + tap.Name + " up",
+		"nft -f -",
+		"nft delete table inet " + nft.Table,
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if strings.Join(rr.calls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(rr.calls, "\n"))
+	}
+	if !strings.Contains(rr.stdin[6], "tcp dport 9080 accept") {
+		t.Fatalf("unexpected nft script:\n%s", rr.stdin[6])
+	}
+}
+
+func TestServer_TapOwnerAndSweep(t *testing.T) {
+	t.Parallel()
+
+	cfg := selfAllowed()
+	cfg.JailerUIDStart, cfg.JailerUIDCount = 900000, 16
+	cfg.SysClassNet = t.TempDir()
+	for _, dev := range []string{"eth0", "nxtap0123456789"} {
+		if err := os.Mkdir(filepath.Join(cfg.SysClassNet, dev), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, rr := startTestServer(t, cfg)
+	ctx := context.Background()
+
+	if _, err := c.CreateTap(ctx, TapCreateParams{DirectiveID: "d1", HostCIDR: "172.16.0.1/30", Owner: 900001}); err != nil {
+		t.Fatalf("tap: %v", err)
+	}
+	res, err := c.SweepNetwork(ctx)
+	if err != nil || len(res.Removed) != 1 || res.Removed[0] != "nxtap0123456789" {
+		t.Fatalf("sweep: %+v %v", res, err)
+	}
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	calls := strings.Join(rr.calls, "\n")
+	for _, want := range []string{
+		"mode tap user 900001 group 900001",
+		"nft delete table inet nexus_0123456789\nip link del nxtap0123456789",
+	} {
+		if !strings.Contains(calls, want) {
+			t.Errorf("missing %q in:\n%s", want, calls)
+		}
+	}
+	if strings.Contains(calls, "eth0") {
+		t.Errorf("sweep touched a foreign device:\n%s", calls)
+	}
+}
+
+func TestServer_Jailer(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	jailer := filepath.Join(dir, "jailer")
+	if err := os.WriteFile(jailer, []byte("#!/bin/sh\necho \"$@\"\nexit 3\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := selfAllowed()
+	cfg.JailerPath = jailer
+	cfg.FirecrackerPath = "/usr/bin/firecracker"
+	cfg.ChrootBase = filepath.Join(dir, "chroot")
+	cfg.NetnsDir = filepath.Join(dir, "netns")
+	// The chroot's group is the VM uid, so use one the test may chown to.
+	uid := os.Getgid()
+	cfg.JailerUIDStart, cfg.JailerUIDCount = uid, 1
+	c, _ := startTestServer(t, cfg)
+	ctx := context.Background()
+
+	var herr *Error
+	if _, err := c.PrepareJailer(ctx, JailerPrepareParams{DirectiveID: "d1", UID: uid + 1}); !errors.As(err, &herr) || herr.Code != CodeInvalidParams {
+		t.Fatalf("expected uid outside the range to be rejected, got %v", err)
+	}
+	prep, err := c.PrepareJailer(ctx, JailerPrepareParams{DirectiveID: "d1", UID: uid})
+	if err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if want := filepath.Join(cfg.ChrootBase, "firecracker", JailerID("d1"), "root"); prep.Root != want {
+		t.Fatalf("chroot = %q, want %q", prep.Root, want)
+	}
+	fi, err := os.Stat(prep.Root)
+	if err != nil || fi.Mode()&os.ModeSetgid == 0 || fi.Mode().Perm() != 0o770 {
+		t.Fatalf("chroot mode = %v, %v", fi.Mode(), err)
+	}
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+
+	_, err = c.LaunchJailer(ctx, JailerLaunchParams{DirectiveID: "d1", UID: uid, Args: []string{"--api-sock", "/run/firecracker.socket", "--seccomp-filter", "x"}}, devNull, pw, pw)
+	if !errors.As(err, &herr) || herr.Code != CodeInvalidParams {
+		t.Fatalf("expected disallowed flag to be rejected, got %v", err)
+	}
+
+	_, err = c.LaunchJailer(ctx, JailerLaunchParams{DirectiveID: "d1", UID: uid, Netns: true, Args: []string{"--no-api"}}, devNull, pw, pw)
+	if !errors.As(err, &herr) || herr.Code != CodeInvalidParams {
+		t.Fatalf("expected launch into a missing netns to be rejected, got %v", err)
+	}
+	// Stands in for the bind mount `ip netns add` leaves.
+	netns := filepath.Join(cfg.NetnsDir, NetnsName("d1"))
+	if err := os.MkdirAll(cfg.NetnsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(netns, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.LaunchJailer(ctx, JailerLaunchParams{DirectiveID: "d1", UID: uid, Netns: true, MemoryMB: 128, Args: []string{"--config-file", "vm.json", "--no-api"}}, devNull, pw, pw); err != nil {
+		t.Fatalf("launch: %v", err)
+	}
+	pw.Close()
+
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	res, err := c.WaitJailer(waitCtx, "d1")
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if res.ExitCode != 3 {
+		t.Fatalf("exit code = %d, want 3", res.ExitCode)
+	}
+	out, _ := io.ReadAll(pr)
+	for _, want := range []string{"--id " + JailerID("d1"), "--exec-file /usr/bin/firecracker", fmt.Sprintf("--uid %d --gid %d", uid, uid), "--parent-cgroup nexus-firecracker", "--netns " + netns, "--cgroup memory.max=134217728", "-- --config-file vm.json --no-api"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("jailer args missing %q: %s", want, out)
+		}
+	}
+
+	if err := c.CleanupJailer(ctx, "d1"); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(prep.Root)); !os.IsNotExist(err) {
+		t.Fatalf("expected chroot removed, got %v", err)
+	}
+}
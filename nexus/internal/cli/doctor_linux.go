@@ -3,14 +3,19 @@
 package cli
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/helper"
 )
 
 func platformChecks(cfg *config.Config) []DoctorCheck {
@@ -24,6 +29,10 @@ func platformChecks(cfg *config.Config) []DoctorCheck {
 		checkRootfs(cfg),
 	}
 
+	if cfg != nil && cfg.Helper.SocketPath != "" {
+		checks = append(checks, checkHelper(cfg.Helper.SocketPath))
+	}
+
 	// Add firecracker checks when configured as the untrusted driver.
 	if cfg != nil && cfg.UntrustedDriver == "firecracker" {
 		checks = append(checks,
@@ -34,6 +43,15 @@ func platformChecks(cfg *config.Config) []DoctorCheck {
 			checkVMAssets(cfg),
 			checkFirecrackerFunctional(cfg),
 		)
+		switch {
+		case cfg.Firecracker.Jailer.Enabled && cfg.Helper.SocketPath != "":
+			checks = append(checks, checkHelperJailer(cfg.Helper.SocketPath))
+		case cfg.Firecracker.Jailer.Enabled:
+			checks = append(checks, checkJailer(cfg), checkJailerChroot(cfg))
+		}
+		if cfg.Firecracker.NetworkMode == "tap" {
+			checks = append(checks, checkIPForward())
+		}
 	}
 
 	return checks
@@ -204,6 +222,16 @@ func checkBwrapFunctional() DoctorCheck {
 	return DoctorCheck{Name: "bwrap_functional", Status: "ok", Detail: "sandbox invocation succeeded"}
 }
 
+func checkHelper(socketPath string) DoctorCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := helper.NewClient(socketPath).Ping(ctx)
+	if err != nil {
+		return DoctorCheck{Name: "nexus-helper", Status: "fail", Detail: fmt.Sprintf("%v (is nexus-helper running and this user allowed?)", err)}
+	}
+	return DoctorCheck{Name: "nexus-helper", Status: "ok", Detail: fmt.Sprintf("protocol v%d at %s", res.Version, socketPath)}
+}
+
 // --- Firecracker checks (conditional on untrusted_driver: "firecracker") ---
 
 func checkKVM() DoctorCheck {
@@ -299,6 +327,77 @@ func checkVMAssets(cfg *config.Config) DoctorCheck {
 	return DoctorCheck{Name: "vm_assets", Status: "ok", Detail: fmt.Sprintf("kernel=%s rootfs=%s", kernelPath, imagePath)}
 }
 
+func checkJailer(cfg *config.Config) DoctorCheck {
+	jailerPath := cfg.Firecracker.Jailer.JailerPath
+	path, err := exec.LookPath(jailerPath)
+	if err != nil {
+		return DoctorCheck{Name: "jailer", Status: "fail", Detail: fmt.Sprintf("%s not found (install the jailer from the firecracker release)", jailerPath)}
+	}
+	if os.Geteuid() != 0 {
+		return DoctorCheck{Name: "jailer", Status: "fail", Detail: fmt.Sprintf("found at %s but nexusd is not running as root", path)}
+	}
+
+	out, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return DoctorCheck{Name: "jailer", Status: "warn", Detail: fmt.Sprintf("found at %s but --version failed", path)}
+	}
+	return DoctorCheck{Name: "jailer", Status: "ok", Detail: strings.TrimSpace(string(out))}
+}
+
+// checkHelperJailer verifies nexus-helper offers the jailer ops; with the
+// helper configured it launches the jailer (and owns its binary and chroot
+// base), so nexusd need not be root.
+func checkHelperJailer(socketPath string) DoctorCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	res, err := helper.NewClient(socketPath).Ping(ctx)
+	if err != nil {
+		return DoctorCheck{Name: "jailer", Status: "fail", Detail: fmt.Sprintf("nexus-helper: %v", err)}
+	}
+	if !slices.Contains(res.Ops, helper.OpJailerLaunch) {
+		return DoctorCheck{Name: "jailer", Status: "fail", Detail: "nexus-helper does not support jailer.launch (upgrade nexus-helper)"}
+	}
+	return DoctorCheck{Name: "jailer", Status: "ok", Detail: "launched through nexus-helper (check it with: nexus-helper -mode doctor)"}
+}
+
+func checkJailerChroot(cfg *config.Config) DoctorCheck {
+	base := cfg.Firecracker.Jailer.ChrootBaseDir
+	info, err := os.Stat(base)
+	if err != nil {
+		return DoctorCheck{Name: "jailer_chroot", Status: "fail", Detail: fmt.Sprintf("%s: %v (run: sudo mkdir -p %s)", base, err, base)}
+	}
+	if !info.IsDir() {
+		return DoctorCheck{Name: "jailer_chroot", Status: "fail", Detail: base + " is not a directory"}
+	}
+
+	// Kernel and rootfs are hard-linked into each chroot; across filesystems
+	// they are copied per run instead, which is slow for large images.
+	baseDev := info.Sys().(*syscall.Stat_t).Dev
+	for _, p := range []string{cfg.Firecracker.KernelPath, cfg.Firecracker.RootfsImagePath} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue // reported by vm_assets
+		}
+		if fi.Sys().(*syscall.Stat_t).Dev != baseDev {
+			return DoctorCheck{Name: "jailer_chroot", Status: "warn", Detail: fmt.Sprintf("%s is on a different filesystem than %s (copied per run instead of hard-linked)", p, base)}
+		}
+	}
+	return DoctorCheck{Name: "jailer_chroot", Status: "ok", Detail: base}
+}
+
+// checkIPForward verifies the host routes IPv4, which firecracker tap mode
+// needs to forward guest traffic.
+func checkIPForward() DoctorCheck {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	if err != nil {
+		return DoctorCheck{Name: "ip_forward", Status: "fail", Detail: err.Error()}
+	}
+	if strings.TrimSpace(string(data)) != "1" {
+		return DoctorCheck{Name: "ip_forward", Status: "fail", Detail: "disabled (run: sudo sysctl -w net.ipv4.ip_forward=1)"}
+	}
+	return DoctorCheck{Name: "ip_forward", Status: "ok", Detail: "enabled"}
+}
+
 func checkFirecrackerFunctional(cfg *config.Config) DoctorCheck {
 	if _, err := exec.LookPath("firecracker"); err != nil {
 		return DoctorCheck{Name: "firecracker_functional", Status: "skip", Detail: "firecracker not installed"}
@@ -16,6 +16,8 @@ import (
 	"cybros.ai/nexus/config"
 	"cybros.ai/nexus/daemon"
 	"cybros.ai/nexus/enroll"
+	"cybros.ai/nexus/sandbox/landlock"
+	"cybros.ai/nexus/sandbox/sessionagent"
 	"cybros.ai/nexus/version"
 )
 
@@ -26,6 +28,12 @@ type ConfigHook func(cfg *config.Config)
 
 // Run is the shared entry point for nexusd.
 func Run(defaultConfigPath string, hooks ...ConfigHook) {
+	// Drivers re-exec nexusd as the Landlock shim and as the session agent;
+	// this must run before any flag parsing or daemon setup and does not
+	// return in either mode.
+	landlock.RunShimIfRequested()
+	sessionagent.RunIfRequested()
+
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
 	var configPath string
 	var showVersion bool
@@ -0,0 +1,256 @@
+package logstream
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// redactedMarker replaces every redacted span.
+const redactedMarker = "[REDACTED]"
+
+// minRedactLen skips values too short to scrub without mangling ordinary
+// output (and whose redaction would itself reveal them).
+const minRedactLen = 4
+
+// SecretValueRule is the rule name counted for known secret values.
+const SecretValueRule = "secret_value"
+
+// DefaultMaxMatchBytes bounds pattern matches when NewFilter is given <= 0.
+const DefaultMaxMatchBytes = 512
+
+// Redactor is a stage between a command's output and the uploader. Write and
+// Flush are per stream: Write may hold back a tail of the input (a possible
+// partial match) that a later Write or Flush releases. Implementations must
+// be safe for concurrent use by different streams.
+type Redactor interface {
+	Write(stream string, b []byte) []byte
+	Flush(stream string) []byte
+	// Redact scrubs a self-contained message.
+	Redact(b []byte) []byte
+	// Redactions returns the number of redactions so far, by rule name.
+	Redactions() map[string]int64
+}
+
+// Pattern is a named regex redaction rule. If Re has a subexpression named
+// "secret", only that group is replaced; otherwise the whole match is.
+type Pattern struct {
+	Name string
+	Re   *regexp.Regexp
+}
+
+// DefaultPatterns returns the built-in rules: AWS access key ids and secret
+// keys, GitHub tokens and HTTP bearer credentials.
+func DefaultPatterns() []Pattern {
+	return []Pattern{
+		{Name: "aws_access_key_id", Re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		{Name: "aws_secret_access_key", Re: regexp.MustCompile(`(?i)aws_secret_access_key["']?\s*[=:]\s*["']?(?P<secret>[A-Za-z0-9/+=]{40})`)},
+		{Name: "github_token", Re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+		{Name: "bearer", Re: regexp.MustCompile(`(?i)\bbearer[ \t]+(?P<secret>[A-Za-z0-9\-._~+/]{8,}=*)`)},
+	}
+}
+
+// Filter is the default Redactor: it masks known secret values (which may
+// span lines) and regex patterns (which are assumed not to).
+//
+// To catch a match split across writes, each stream holds back a tail: the
+// longest value minus one byte, and the unterminated last line up to
+// maxMatchBytes. A pattern match touching the end of the input is held until
+// it can no longer grow. Pattern matches longer than maxMatchBytes that are
+// split across writes may be only partially masked.
+type Filter struct {
+	values   [][]byte // longest first
+	patterns []Pattern
+	valHold  int // longest value length - 1
+	maxMatch int
+
+	mu      sync.Mutex
+	pending map[string][]byte // per stream raw carry-over
+	counts  map[string]int64
+}
+
+// NewFilter builds a Filter for the given secret values and patterns. It
+// returns nil when there is nothing to redact.
+func NewFilter(values []string, patterns []Pattern, maxMatchBytes int) *Filter {
+	if maxMatchBytes <= 0 {
+		maxMatchBytes = DefaultMaxMatchBytes
+	}
+	f := &Filter{
+		maxMatch: maxMatchBytes,
+		pending:  map[string][]byte{},
+		counts:   map[string]int64{},
+	}
+	seen := map[string]bool{}
+	for _, v := range values {
+		if len(v) < minRedactLen || seen[v] {
+			continue
+		}
+		seen[v] = true
+		f.values = append(f.values, []byte(v))
+	}
+	for _, p := range patterns {
+		if p.Re != nil {
+			f.patterns = append(f.patterns, p)
+		}
+	}
+	if len(f.values) == 0 && len(f.patterns) == 0 {
+		return nil
+	}
+	sort.Slice(f.values, func(i, j int) bool { return len(f.values[i]) > len(f.values[j]) })
+	if len(f.values) > 0 {
+		f.valHold = len(f.values[0]) - 1
+	}
+	return f
+}
+
+// Write redacts b appended to the stream's carry-over and returns the part
+// that is safe to emit.
+func (f *Filter) Write(stream string, b []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	buf := append(f.pending[stream], b...)
+	out, rest := f.scan(buf, false)
+	f.pending[stream] = append([]byte(nil), rest...)
+	return out
+}
+
+// Flush redacts and returns the stream's carry-over.
+func (f *Filter) Flush(stream string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	buf := f.pending[stream]
+	delete(f.pending, stream)
+	out, _ := f.scan(buf, true)
+	return out
+}
+
+// Redact scrubs a self-contained message (no carry-over).
+func (f *Filter) Redact(b []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out, _ := f.scan(b, true)
+	return out
+}
+
+// Redactions returns a copy of the per-rule redaction counts.
+func (f *Filter) Redactions() map[string]int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make(map[string]int64, len(f.counts))
+	for k, v := range f.counts {
+		out[k] = v
+	}
+	return out
+}
+
+// match is a candidate redaction: [start, end) is the whole match and
+// [rstart, rend) the span replaced by the marker.
+type match struct {
+	start, end   int
+	rstart, rend int
+	rule         string
+	pattern      bool
+}
+
+// scan replaces matches in b. Unless final, output stops at the hold-back
+// point and the remainder is returned as rest; a match that starts before
+// that point is always redacted in full.
+func (f *Filter) scan(b []byte, final bool) (out, rest []byte) {
+	matches := f.find(b)
+
+	limit := len(b)
+	if !final {
+		limit = f.cut(b)
+		for _, m := range matches {
+			// A pattern match running into the end of the input may still
+			// grow (e.g. a token whose tail is in the next write).
+			if m.pattern && m.end == len(b) && len(b)-m.start <= f.maxMatch {
+				limit = min(limit, m.start)
+			}
+		}
+	}
+
+	out = make([]byte, 0, len(b))
+	i := 0
+	for _, m := range matches {
+		if m.start >= limit {
+			break
+		}
+		if m.rstart < i {
+			continue // overlaps an earlier redaction
+		}
+		out = append(out, b[i:m.rstart]...)
+		out = append(out, redactedMarker...)
+		i = m.rend
+		f.counts[m.rule]++
+	}
+	if i < limit {
+		out = append(out, b[i:limit]...)
+		i = limit
+	}
+	return out, b[i:]
+}
+
+// cut returns how much of b may be emitted before more input arrives.
+func (f *Filter) cut(b []byte) int {
+	cut := len(b)
+	if len(f.values) > 0 {
+		cut = max(len(b)-f.valHold, 0)
+	}
+	if len(f.patterns) == 0 {
+		return cut
+	}
+	pc := bytes.LastIndexByte(b, '\n') + 1
+	if len(b)-pc > f.maxMatch {
+		pc = len(b) - f.maxMatch
+		// Avoid splitting a word: the next scan starts at the cut, where
+		// \b would otherwise match spuriously.
+		for j := pc; j > 0 && pc-j < 64; j-- {
+			if !isWordByte(b[j-1]) || !isWordByte(b[j]) {
+				pc = j
+				break
+			}
+		}
+	}
+	return min(cut, pc)
+}
+
+// find returns all candidate matches in b ordered by start, longest first.
+func (f *Filter) find(b []byte) []match {
+	var ms []match
+	for _, v := range f.values {
+		for off := 0; off < len(b); {
+			j := bytes.Index(b[off:], v)
+			if j < 0 {
+				break
+			}
+			s := off + j
+			ms = append(ms, match{start: s, end: s + len(v), rstart: s, rend: s + len(v), rule: SecretValueRule})
+			off = s + len(v)
+		}
+	}
+	for _, p := range f.patterns {
+		group := p.Re.SubexpIndex("secret")
+		for _, loc := range p.Re.FindAllSubmatchIndex(b, -1) {
+			m := match{start: loc[0], end: loc[1], rstart: loc[0], rend: loc[1], rule: p.Name, pattern: true}
+			if group > 0 && loc[2*group] >= 0 {
+				m.rstart, m.rend = loc[2*group], loc[2*group+1]
+			}
+			if m.rend > m.rstart {
+				ms = append(ms, m)
+			}
+		}
+	}
+	sort.SliceStable(ms, func(i, j int) bool {
+		if ms[i].start != ms[j].start {
+			return ms[i].start < ms[j].start
+		}
+		return ms[i].end > ms[j].end
+	})
+	return ms
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
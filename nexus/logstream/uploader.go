@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"cybros.ai/nexus/client"
 	"cybros.ai/nexus/protocol"
@@ -41,6 +42,9 @@ type Uploader struct {
 
 	stdoutOverflow overflowStream
 	stderrOverflow overflowStream
+
+	redactor  Redactor
+	idleFlush time.Duration
 }
 
 func New(cli *client.Client, directiveID string, tokenFn TokenFunc, chunkBytes int, maxBytes int64) *Uploader {
@@ -53,16 +57,69 @@ func New(cli *client.Client, directiveID string, tokenFn TokenFunc, chunkBytes i
 	}
 }
 
+// SetRedactor scrubs everything the uploader sends or writes to overflow
+// files through r. Must be called before any output is ingested.
+func (u *Uploader) SetRedactor(r Redactor) {
+	u.redactor = r
+}
+
+// SetIdleFlush releases the redactor's held-back tail of a stream once the
+// stream has been quiet for d. Terminal output needs it: a prompt has no
+// newline, so without a flush it would wait for the next line. A secret
+// printed in pieces further apart than d may then be only partially masked.
+func (u *Uploader) SetIdleFlush(d time.Duration) {
+	u.idleFlush = d
+}
+
+// Redactions returns the redaction counts by rule, or nil without a redactor.
+func (u *Uploader) Redactions() map[string]int64 {
+	if u.redactor == nil {
+		return nil
+	}
+	return u.redactor.Redactions()
+}
+
 func (u *Uploader) StdoutTruncated() bool { return u.stdoutTruncated.Load() }
 func (u *Uploader) StderrTruncated() bool { return u.stderrTruncated.Load() }
 
 func (u *Uploader) Consume(ctx context.Context, stream string, r io.Reader) error {
 	buf := make([]byte, u.chunkBytes)
 
+	var (
+		mu   sync.Mutex // orders idle flushes against reads
+		idle *time.Timer
+	)
+	flush := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		u.ingestBytes(ctx, stream, u.redactor.Flush(stream))
+	}
+	if u.redactor != nil {
+		defer func() {
+			if idle != nil {
+				idle.Stop()
+			}
+			flush()
+		}()
+	}
+
 	for {
 		n, err := r.Read(buf)
 		if n > 0 {
-			u.ingestBytes(ctx, stream, buf[:n])
+			b := buf[:n]
+			mu.Lock()
+			if u.redactor != nil {
+				b = u.redactor.Write(stream, b)
+			}
+			u.ingestBytes(ctx, stream, b)
+			mu.Unlock()
+			if u.redactor != nil && u.idleFlush > 0 {
+				if idle == nil {
+					idle = time.AfterFunc(u.idleFlush, flush)
+				} else {
+					idle.Reset(u.idleFlush)
+				}
+			}
 		}
 		if err != nil {
 			if err == io.EOF {
@@ -80,6 +137,9 @@ func (u *Uploader) UploadBytes(ctx context.Context, stream string, b []byte) {
 	if len(b) == 0 {
 		return
 	}
+	if u.redactor != nil {
+		b = u.redactor.Redact(b)
+	}
 
 	for len(b) > 0 {
 		n := len(b)
@@ -176,7 +236,7 @@ func (u *Uploader) markTruncated(stream string) {
 }
 
 func (u *Uploader) ingestBytes(ctx context.Context, stream string, b []byte) {
-	if stream != "stdout" && stream != "stderr" {
+	if len(b) == 0 || (stream != "stdout" && stream != "stderr") {
 		return
 	}
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"cybros.ai/nexus/client"
@@ -271,3 +273,162 @@ func TestUploader_OverflowRespectsMaxBytesPerStream(t *testing.T) {
 		t.Fatalf("expected overflow file to include max-bytes notice, got %q", string(b))
 	}
 }
+
+func TestUploader_RedactsSecretSplitAcrossReads(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu  sync.Mutex
+		got strings.Builder
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Bytes string `json:"bytes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := base64.StdEncoding.DecodeString(req.Bytes)
+		mu.Lock()
+		got.Write(b)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := config.Default()
+	cfg.ServerURL = srv.URL
+	cfg.TerritoryID = "t1"
+	cfg.Poll.LongPollTimeout = 2 * time.Second
+
+	cli, err := client.New(cfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+
+	u := New(cli, "d5", func() string { return "token" }, 64, 0)
+	u.SetRedactor(NewFilter([]string{"s3cr3t-value"}, DefaultPatterns(), 0))
+	r := iotest.OneByteReader(strings.NewReader("token=s3cr3t-value done\nAuthorization: Bearer abcdefgh12345678\n"))
+	if err := u.Consume(context.Background(), "stdout", r); err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got.String() != "token=[REDACTED] done\nAuthorization: Bearer [REDACTED]\n" {
+		t.Fatalf("unexpected uploaded output: %q", got.String())
+	}
+	if counts := u.Redactions(); counts[SecretValueRule] != 1 || counts["bearer"] != 1 {
+		t.Fatalf("unexpected redaction counts: %v", counts)
+	}
+}
+
+func TestUploader_RedactsOverflowFile(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := config.Default()
+	cfg.ServerURL = srv.URL
+	cfg.TerritoryID = "t1"
+	cfg.Poll.LongPollTimeout = 2 * time.Second
+
+	cli, err := client.New(cfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+
+	overflowDir := t.TempDir()
+
+	u := New(cli, "d6", func() string { return "token" }, 64, 1)
+	u.EnableOverflow(overflowDir, 1_048_576)
+	u.SetRedactor(NewFilter([]string{"hunter22"}, nil, 0))
+	u.UploadBytes(context.Background(), "stderr", []byte("xpassword: hunter22\n"))
+
+	b, err := os.ReadFile(filepath.Join(overflowDir, "stderr.log"))
+	if err != nil {
+		t.Fatalf("read overflow stderr.log: %v", err)
+	}
+	if got := string(b); got != "password: [REDACTED]\n" {
+		t.Fatalf("unexpected overflow file bytes: %q", got)
+	}
+}
+
+func TestUploader_IdleFlushReleasesPrompt(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu  sync.Mutex
+		got strings.Builder
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Bytes string `json:"bytes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := base64.StdEncoding.DecodeString(req.Bytes)
+		mu.Lock()
+		got.Write(b)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := config.Default()
+	cfg.ServerURL = srv.URL
+	cfg.TerritoryID = "t1"
+	cfg.Poll.LongPollTimeout = 2 * time.Second
+
+	cli, err := client.New(cfg)
+	if err != nil {
+		t.Fatalf("client.New: %v", err)
+	}
+
+	u := New(cli, "d7", func() string { return "token" }, 64, 0)
+	u.SetRedactor(NewFilter([]string{"s3cr3t-value"}, DefaultPatterns(), 0))
+	u.SetIdleFlush(20 * time.Millisecond)
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- u.Consume(context.Background(), "stdout", pr) }()
+
+	// A prompt has no newline: it must show up while the command waits for
+	// input, not when the stream ends.
+	if _, err := pw.Write([]byte("key s3cr3t-value\nPassword: ")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		s := got.String()
+		mu.Unlock()
+		if s == "key [REDACTED]\nPassword: " {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("prompt not flushed while idle: %q", s)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if _, err := pw.Write([]byte("ok\n")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got.String() != "key [REDACTED]\nPassword: ok\n" {
+		t.Fatalf("unexpected uploaded output: %q", got.String())
+	}
+}
@@ -22,6 +22,16 @@ type AllowlistEntry struct {
 	Wildcard bool
 }
 
+// String returns the canonical form of the entry: normalized host, with its
+// "*." prefix if any, and port.
+func (e AllowlistEntry) String() string {
+	host := e.Host
+	if e.Wildcard {
+		host = "*." + host
+	}
+	return host + ":" + strconv.Itoa(e.Port)
+}
+
 func NormalizeHost(host string) string {
 	h := strings.TrimSpace(host)
 	h = strings.TrimSuffix(h, ".")
@@ -91,15 +101,19 @@ func ParseAllowlistEntry(s string) (AllowlistEntry, error) {
 }
 
 func Match(entry AllowlistEntry, destHost string, destPort int) bool {
-	h := NormalizeHost(destHost)
 	if destPort != entry.Port {
 		return false
 	}
-	if entry.Wildcard {
-		if h == entry.Host {
+	return matchHost(entry.Host, entry.Wildcard, destHost)
+}
+
+func matchHost(host string, wildcard bool, destHost string) bool {
+	h := NormalizeHost(destHost)
+	if wildcard {
+		if h == host {
 			return false // '*.example.com' does not match 'example.com'
 		}
-		return strings.HasSuffix(h, "."+entry.Host)
+		return strings.HasSuffix(h, "."+host)
 	}
-	return h == entry.Host
+	return h == host
 }
@@ -0,0 +1,292 @@
+package netpolicy
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Allowlist grammar versions (NetCapabilityV1.allowlist_version). V2 is a
+// superset of V1: every V1 entry parses to the same canonical form.
+const (
+	AllowlistV1 = 1
+	AllowlistV2 = 2
+)
+
+// privateOptIn marks a V2 entry that may reach private addresses.
+const privateOptIn = "private:"
+
+// Transport protocols of allowlist entries. V2 entries default to TCP; a
+// "/udp" qualifier makes an entry UDP-only.
+const (
+	ProtoTCP = "tcp"
+	ProtoUDP = "udp"
+)
+
+// PortRange is an inclusive range of ports; a single port has From == To.
+type PortRange struct {
+	From int
+	To   int
+}
+
+// Contains reports whether port is in the range.
+func (r PortRange) Contains(port int) bool {
+	return port >= r.From && port <= r.To
+}
+
+func (r PortRange) String() string {
+	if r.From == r.To {
+		return strconv.Itoa(r.From)
+	}
+	return strconv.Itoa(r.From) + "-" + strconv.Itoa(r.To)
+}
+
+// AllowlistEntryV2 is a parsed allowlist V2 entry (design 03 §7.6.6):
+//
+//	[private:]<target>:<port>[-<port>][/udp]
+//
+// where target is a V1 host (optionally "*."), an IPv4 address or CIDR, or
+// a bracketed IPv6 address or CIDR.
+type AllowlistEntryV2 struct {
+	Raw      string
+	Host     string // as AllowlistEntry.Host; empty for IP/CIDR entries
+	Wildcard bool
+	Prefix   netip.Prefix // IP/CIDR entries; a single address is a full-length prefix
+	Ports    PortRange
+	Proto    string // ProtoTCP or ProtoUDP
+	// Private opts the entry into private destinations (IsOptInPrivateIP)
+	// that IsPrivateIP would otherwise deny.
+	Private bool
+}
+
+// V2 returns e as the equivalent V2 entry.
+func (e AllowlistEntry) V2() AllowlistEntryV2 {
+	return AllowlistEntryV2{
+		Raw:      e.Raw,
+		Host:     e.Host,
+		Wildcard: e.Wildcard,
+		Ports:    PortRange{From: e.Port, To: e.Port},
+		Proto:    ProtoTCP,
+	}
+}
+
+// String returns the canonical form of the entry. For V1-expressible
+// entries it equals AllowlistEntry.String.
+func (e AllowlistEntryV2) String() string {
+	var b strings.Builder
+	if e.Private {
+		b.WriteString(privateOptIn)
+	}
+	switch {
+	case !e.Prefix.IsValid():
+		if e.Wildcard {
+			b.WriteString("*.")
+		}
+		b.WriteString(e.Host)
+	case e.Prefix.Addr().Is6():
+		b.WriteString("[")
+		b.WriteString(prefixString(e.Prefix))
+		b.WriteString("]")
+	default:
+		b.WriteString(prefixString(e.Prefix))
+	}
+	b.WriteString(":")
+	b.WriteString(e.Ports.String())
+	if e.Proto == ProtoUDP {
+		b.WriteString("/" + ProtoUDP)
+	}
+	return b.String()
+}
+
+// prefixString renders a single-address prefix as the bare address.
+func prefixString(p netip.Prefix) string {
+	if p.IsSingleIP() {
+		return p.Addr().String()
+	}
+	return p.String()
+}
+
+// ParseAllowlistEntryV2 parses an allowlist V2 entry.
+func ParseAllowlistEntryV2(s string) (AllowlistEntryV2, error) {
+	raw := strings.TrimSpace(s)
+	rest := raw
+	private := false
+	if strings.HasPrefix(rest, privateOptIn) {
+		private = true
+		rest = rest[len(privateOptIn):]
+	}
+
+	idx := strings.LastIndex(rest, ":")
+	if idx <= 0 || idx == len(rest)-1 {
+		return AllowlistEntryV2{}, errInvalidFormat
+	}
+	hostPart := rest[:idx]
+	portPart, proto, qualified := strings.Cut(rest[idx+1:], "/")
+	switch {
+	case !qualified:
+		proto = ProtoTCP
+	case proto != ProtoTCP && proto != ProtoUDP:
+		return AllowlistEntryV2{}, fmt.Errorf("unsupported protocol %q", proto)
+	}
+	ports, err := parsePortRange(portPart)
+	if err != nil {
+		return AllowlistEntryV2{}, err
+	}
+
+	entry := AllowlistEntryV2{Raw: raw, Ports: ports, Proto: proto, Private: private}
+	if !isIPLiteral(hostPart) && !strings.Contains(hostPart, "/") {
+		v1, err := ParseAllowlistEntry(hostPart + ":" + strconv.Itoa(ports.From))
+		if err != nil {
+			return AllowlistEntryV2{}, err
+		}
+		entry.Host = v1.Host
+		entry.Wildcard = v1.Wildcard
+		return entry, nil
+	}
+
+	prefix, err := parseIPTarget(hostPart)
+	if err != nil {
+		return AllowlistEntryV2{}, err
+	}
+	entry.Prefix = prefix
+	if within(prefix, privatePrefixes) {
+		if !within(prefix, optInPrefixes) {
+			return AllowlistEntryV2{}, fmt.Errorf("%s cannot be allowed: loopback, link-local and reserved ranges are never reachable", hostPart)
+		}
+		if !private {
+			return AllowlistEntryV2{}, fmt.Errorf("%s is a private range and requires the %q opt-in", hostPart, privateOptIn)
+		}
+	}
+	return entry, nil
+}
+
+// parsePortRange parses "port" or "from-to".
+func parsePortRange(s string) (PortRange, error) {
+	from, to, isRange := strings.Cut(s, "-")
+	if !isRange {
+		to = from
+	}
+	var r PortRange
+	for _, p := range []struct {
+		s   string
+		out *int
+	}{{from, &r.From}, {to, &r.To}} {
+		n, err := strconv.Atoi(p.s)
+		if err != nil {
+			return PortRange{}, fmt.Errorf("invalid port %q: %w", p.s, err)
+		}
+		if n < 1 || n > 65535 {
+			return PortRange{}, fmt.Errorf("port out of range: %d", n)
+		}
+		*p.out = n
+	}
+	if r.From > r.To {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	return r, nil
+}
+
+// parseIPTarget parses an IPv4 address or CIDR, or a bracketed IPv6 one.
+func parseIPTarget(s string) (netip.Prefix, error) {
+	target := s
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		target = s[1 : len(s)-1]
+		if !strings.Contains(target, ":") {
+			return netip.Prefix{}, fmt.Errorf("only IPv6 may be bracketed: %q", s)
+		}
+	} else if strings.Contains(s, ":") {
+		return netip.Prefix{}, fmt.Errorf("IPv6 must be bracketed: %q", s)
+	}
+
+	var prefix netip.Prefix
+	if strings.Contains(target, "/") {
+		p, err := netip.ParsePrefix(target)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		if p.Masked() != p {
+			return netip.Prefix{}, fmt.Errorf("CIDR %q has host bits set", s)
+		}
+		prefix = p
+	} else {
+		addr, err := netip.ParseAddr(target)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid IP address %q", s)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	if prefix.Addr().Zone() != "" || prefix.Addr().Is4In6() {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", s)
+	}
+	return prefix, nil
+}
+
+// within reports whether all of p lies inside one of cidrs.
+func within(p netip.Prefix, cidrs []netip.Prefix) bool {
+	for _, c := range cidrs {
+		if c.Bits() <= p.Bits() && c.Contains(p.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchHostV2 reports whether destHost is the entry's target, ignoring the
+// port. IP/CIDR entries match only IP-literal destinations (optionally
+// bracketed); DNS entries match as in Match.
+func MatchHostV2(entry AllowlistEntryV2, destHost string) bool {
+	if !entry.Prefix.IsValid() {
+		return matchHost(entry.Host, entry.Wildcard, destHost)
+	}
+	h := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(destHost), "["), "]")
+	addr, err := netip.ParseAddr(h)
+	if err != nil || addr.Zone() != "" {
+		return false
+	}
+	return entry.Prefix.Contains(addr.Unmap())
+}
+
+// MatchV2 reports whether a TCP connection to destHost:destPort is allowed
+// by entry.
+func MatchV2(entry AllowlistEntryV2, destHost string, destPort int) bool {
+	return MatchProtoV2(entry, ProtoTCP, destHost, destPort)
+}
+
+// MatchProtoV2 reports whether proto traffic to destHost:destPort is
+// allowed by entry.
+func MatchProtoV2(entry AllowlistEntryV2, proto, destHost string, destPort int) bool {
+	return entry.Proto == proto && entry.Ports.Contains(destPort) && MatchHostV2(entry, destHost)
+}
+
+// ParseAllowlist parses allow under the given grammar version (0 means
+// V1), returning the entries in V2 form.
+func ParseAllowlist(version int, allow []string) ([]AllowlistEntryV2, error) {
+	parse, err := entryParser(version)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]AllowlistEntryV2, 0, len(allow))
+	for _, raw := range allow {
+		entry, err := parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowlist entry %q: %w", raw, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func entryParser(version int) (func(string) (AllowlistEntryV2, error), error) {
+	switch version {
+	case 0, AllowlistV1:
+		return func(s string) (AllowlistEntryV2, error) {
+			e, err := ParseAllowlistEntry(s)
+			return e.V2(), err
+		}, nil
+	case AllowlistV2:
+		return ParseAllowlistEntryV2, nil
+	default:
+		return nil, fmt.Errorf("unsupported allowlist_version: %d", version)
+	}
+}
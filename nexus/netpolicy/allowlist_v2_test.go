@@ -0,0 +1,170 @@
+package netpolicy
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"testing"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// allowlistV2Vectors is docs/protocol/directivespec_capabilities_net.allowlist.v2.json,
+// shared with Mothership's Conduits::NetPolicyV2 tests.
+type allowlistV2Vectors struct {
+	Parse []struct {
+		Entry     string `json:"entry"`
+		Canonical string `json:"canonical"`
+		V1Error   bool   `json:"v1_error"`
+		Error     bool   `json:"error"`
+	} `json:"parse"`
+	Match []struct {
+		Entry    string `json:"entry"`
+		Host     string `json:"host"`
+		Port     int    `json:"port"`
+		Proto    string `json:"proto"`
+		Expected bool   `json:"expected"`
+	} `json:"match"`
+	Resolved []struct {
+		Entry    string `json:"entry"`
+		IP       string `json:"ip"`
+		Expected bool   `json:"expected"`
+	} `json:"resolved"`
+}
+
+func loadAllowlistV2Vectors(t *testing.T) allowlistV2Vectors {
+	t.Helper()
+	b, err := os.ReadFile("../docs/protocol/directivespec_capabilities_net.allowlist.v2.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v allowlistV2Vectors
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseAllowlistEntryV2_Vectors(t *testing.T) {
+	for _, tc := range loadAllowlistV2Vectors(t).Parse {
+		entry, err := ParseAllowlistEntryV2(tc.Entry)
+		v1, v1Err := ParseAllowlistEntry(tc.Entry)
+		if tc.Error {
+			if err == nil {
+				t.Errorf("%q: expected error, got %s", tc.Entry, entry)
+			}
+			if v1Err == nil {
+				t.Errorf("%q: V1 accepted an entry V2 rejects", tc.Entry)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.Entry, err)
+			continue
+		}
+		if got := entry.String(); got != tc.Canonical {
+			t.Errorf("%q: canonical = %q, want %q", tc.Entry, got, tc.Canonical)
+		}
+		if again, err := ParseAllowlistEntryV2(tc.Canonical); err != nil || again.String() != tc.Canonical {
+			t.Errorf("%q: canonical form does not round-trip: %v", tc.Entry, err)
+		}
+
+		switch {
+		case tc.V1Error && v1Err == nil:
+			t.Errorf("%q: V1 should reject, got %s", tc.Entry, v1)
+		case !tc.V1Error && v1Err != nil:
+			t.Errorf("%q: V1: %v", tc.Entry, v1Err)
+		case !tc.V1Error && v1.String() != tc.Canonical:
+			t.Errorf("%q: V1 canonical = %q, want %q", tc.Entry, v1, tc.Canonical)
+		}
+	}
+}
+
+func TestMatchV2_Vectors(t *testing.T) {
+	for _, tc := range loadAllowlistV2Vectors(t).Match {
+		entry, err := ParseAllowlistEntryV2(tc.Entry)
+		if err != nil {
+			t.Errorf("%q: %v", tc.Entry, err)
+			continue
+		}
+		proto := tc.Proto
+		if proto == "" {
+			proto = ProtoTCP
+		}
+		if got := MatchProtoV2(entry, proto, tc.Host, tc.Port); got != tc.Expected {
+			t.Errorf("MatchProtoV2(%q, %s, %q, %d) = %v, want %v", tc.Entry, proto, tc.Host, tc.Port, got, tc.Expected)
+		}
+		if proto == ProtoTCP && MatchV2(entry, tc.Host, tc.Port) != tc.Expected {
+			t.Errorf("MatchV2(%q, %q, %d) disagrees with MatchProtoV2", tc.Entry, tc.Host, tc.Port)
+		}
+	}
+}
+
+func TestPrivateOptIn_Vectors(t *testing.T) {
+	for _, tc := range loadAllowlistV2Vectors(t).Resolved {
+		entry, err := ParseAllowlistEntryV2(tc.Entry)
+		if err != nil {
+			t.Errorf("%q: %v", tc.Entry, err)
+			continue
+		}
+		ip := net.ParseIP(tc.IP)
+		got := !IsPrivateIP(ip) || (entry.Private && IsOptInPrivateIP(ip))
+		if got != tc.Expected {
+			t.Errorf("%q resolving to %s: allowed = %v, want %v", tc.Entry, tc.IP, got, tc.Expected)
+		}
+	}
+}
+
+func TestParseAllowlist_Versions(t *testing.T) {
+	allow := []string{"github.com:443", "10.0.0.0/8:443"}
+	if _, err := ParseAllowlist(0, allow[:1]); err != nil {
+		t.Errorf("default version: %v", err)
+	}
+	if _, err := ParseAllowlist(AllowlistV1, allow); err == nil {
+		t.Error("V1 accepted a CIDR entry")
+	}
+	if _, err := ParseAllowlist(AllowlistV2, []string{"private:" + allow[1]}); err != nil {
+		t.Errorf("V2: %v", err)
+	}
+	if _, err := ParseAllowlist(3, allow[:1]); err == nil {
+		t.Error("expected unsupported version error")
+	}
+}
+
+func TestExpandPreset_AllowlistV2(t *testing.T) {
+	got, err := ExpandPreset(protocol.NetCapabilityV1{
+		Mode:             "allowlist",
+		Preset:           "strict",
+		AllowlistVersion: AllowlistV2,
+		Allow:            []string{"private:10.20.0.0/16:443", "Mirror.example.com:8000-8100"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"github.com:443",
+		"api.github.com:443",
+		"codeload.github.com:443",
+		"objects.githubusercontent.com:443",
+		"private:10.20.0.0/16:443",
+		"mirror.example.com:8000-8100",
+	}
+	if len(got.Allow) != len(want) {
+		t.Fatalf("allow = %v, want %v", got.Allow, want)
+	}
+	for i := range want {
+		if got.Allow[i] != want[i] {
+			t.Errorf("allow[%d] = %q, want %q", i, got.Allow[i], want[i])
+		}
+	}
+	if got.AllowlistVersion != AllowlistV2 {
+		t.Errorf("allowlist_version = %d", got.AllowlistVersion)
+	}
+
+	if _, err := ExpandPreset(protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"10.20.0.0/16:443"}}); err == nil {
+		t.Error("V1 capability accepted a CIDR entry")
+	}
+	if _, err := ExpandPreset(protocol.NetCapabilityV1{Mode: "none", AllowlistVersion: 7}); err == nil {
+		t.Error("expected unsupported allowlist_version error")
+	}
+}
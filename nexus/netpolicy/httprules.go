@@ -0,0 +1,260 @@
+package netpolicy
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cybros.ai/nexus/protocol"
+)
+
+var (
+	httpMethodRe = regexp.MustCompile(`^([A-Za-z]+|\*)$`)
+	httpPortRe   = regexp.MustCompile(`^[0-9]{1,5}$`)
+	httpPathRe   = regexp.MustCompile(`^/[!-~]*$`)
+	encodedSlash = regexp.MustCompile(`(?i)%2f`)
+)
+
+// defaultHTTPPorts are the ports a rule's URL may leave out.
+var defaultHTTPPorts = map[string]int{"http": 80, "https": 443}
+
+// HTTPRule is a parsed HTTP rule (NetCapabilityV1.http_rules, design 03 §7.9):
+//
+//	<METHOD|*> <http|https>://<host>[:<port>]<path-glob>
+//
+// host is an exact V1 host name (no "*." wildcard, no IP literal). In the
+// path glob "*" matches within one path segment and "**" across segments;
+// a trailing "/**" also matches the path without it. Queries are never
+// matched, so the glob may not contain '?' or '#'.
+type HTTPRule struct {
+	Raw    string
+	Method string // upper-case; "*" matches any method
+	Scheme string // "http" or "https"
+	Host   string // normalized as AllowlistEntry.Host
+	Port   int
+	Path   string // glob
+
+	re *regexp.Regexp
+}
+
+// String returns the canonical form of the rule: upper-case method,
+// normalized host, and the port only when it is not the scheme's default.
+func (r HTTPRule) String() string {
+	origin := r.Host
+	if r.Port != defaultHTTPPorts[r.Scheme] {
+		origin += ":" + strconv.Itoa(r.Port)
+	}
+	return r.Method + " " + r.Scheme + "://" + origin + r.Path
+}
+
+// ParseHTTPRule parses one rule.
+func ParseHTTPRule(s string) (HTTPRule, error) {
+	raw := strings.TrimSpace(s)
+	method, target, ok := strings.Cut(raw, " ")
+	if !ok || !httpMethodRe.MatchString(method) {
+		return HTTPRule{}, fmt.Errorf("invalid http rule %q, expected \"<METHOD> <url>\"", raw)
+	}
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(target), "://")
+	if _, known := defaultHTTPPorts[scheme]; !ok || !known {
+		return HTTPRule{}, fmt.Errorf("invalid http rule %q: scheme must be http or https", raw)
+	}
+	idx := strings.Index(rest, "/")
+	if idx < 0 {
+		return HTTPRule{}, fmt.Errorf("invalid http rule %q: missing path", raw)
+	}
+	authority, glob := rest[:idx], rest[idx:]
+
+	port := defaultHTTPPorts[scheme]
+	hostPart := authority
+	if i := strings.LastIndex(authority, ":"); i >= 0 && !strings.Contains(authority, "]") {
+		hostPart = authority[:i]
+		if !httpPortRe.MatchString(authority[i+1:]) {
+			return HTTPRule{}, fmt.Errorf("invalid http rule %q: invalid port %q", raw, authority[i+1:])
+		}
+		port, _ = strconv.Atoi(authority[i+1:])
+	}
+	if port < 1 || port > 65535 {
+		return HTTPRule{}, fmt.Errorf("invalid http rule %q: port out of range: %d", raw, port)
+	}
+	if isIPLiteral(hostPart) || strings.HasPrefix(hostPart, "*.") {
+		return HTTPRule{}, fmt.Errorf("invalid http rule %q: host must be an exact name", raw)
+	}
+	host := NormalizeHost(hostPart)
+	if !hostRe.MatchString(host) {
+		return HTTPRule{}, fmt.Errorf("invalid http rule %q: invalid host %q", raw, hostPart)
+	}
+
+	if !httpPathRe.MatchString(glob) || strings.ContainsAny(glob, "?#") || strings.Contains(glob, "***") {
+		return HTTPRule{}, fmt.Errorf("invalid http rule %q: invalid path glob %q", raw, glob)
+	}
+
+	return HTTPRule{
+		Raw:    raw,
+		Method: strings.ToUpper(method),
+		Scheme: scheme,
+		Host:   host,
+		Port:   port,
+		Path:   glob,
+		re:     globRegexp(glob),
+	}, nil
+}
+
+// globRegexp compiles a path glob.
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	body, suffix := glob, ""
+	if strings.HasSuffix(glob, "/**") {
+		body, suffix = strings.TrimSuffix(glob, "/**"), "(?:/.*)?"
+	}
+	for i := 0; i < len(body); i++ {
+		switch {
+		case strings.HasPrefix(body[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case body[i] == '*':
+			b.WriteString("[^/]*")
+		default:
+			b.WriteString(regexp.QuoteMeta(body[i : i+1]))
+		}
+	}
+	b.WriteString(suffix)
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// sameOrigin reports whether the rule applies to scheme://host:port; host
+// must be normalized.
+func (r HTTPRule) sameOrigin(scheme, host string, port int) bool {
+	return r.Scheme == scheme && r.Host == host && r.Port == port
+}
+
+func (r HTTPRule) matches(method, reqPath string) bool {
+	return (r.Method == "*" || r.Method == method) && r.re.MatchString(reqPath)
+}
+
+// HTTPRules is a parsed NetHTTPRulesV1.
+type HTTPRules struct {
+	Allow []HTTPRule
+	Deny  []HTTPRule
+}
+
+// ParseHTTPRules parses both lists of r; a nil r yields no rules.
+func ParseHTTPRules(r *protocol.NetHTTPRulesV1) (HTTPRules, error) {
+	var rules HTTPRules
+	if r == nil {
+		return rules, nil
+	}
+	for _, s := range r.Allow {
+		rule, err := ParseHTTPRule(s)
+		if err != nil {
+			return HTTPRules{}, err
+		}
+		rules.Allow = append(rules.Allow, rule)
+	}
+	for _, s := range r.Deny {
+		rule, err := ParseHTTPRule(s)
+		if err != nil {
+			return HTTPRules{}, err
+		}
+		rules.Deny = append(rules.Deny, rule)
+	}
+	return rules, nil
+}
+
+// canonicalHTTPRules returns r with every rule in canonical form,
+// deduplicated; nil when r has no rules.
+func canonicalHTTPRules(r *protocol.NetHTTPRulesV1) (*protocol.NetHTTPRulesV1, error) {
+	rules, err := ParseHTTPRules(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(rules.Allow) == 0 && len(rules.Deny) == 0 {
+		return nil, nil
+	}
+	canonical := func(list []HTTPRule) []string {
+		var out []string
+		for _, rule := range list {
+			if s := rule.String(); !slices.Contains(out, s) {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return &protocol.NetHTTPRulesV1{Allow: canonical(rules.Allow), Deny: canonical(rules.Deny)}, nil
+}
+
+// Covers reports whether any rule applies to scheme://host:port. Requests
+// to other origins are not subject to HTTP rules.
+func (rs HTTPRules) Covers(scheme, host string, port int) bool {
+	host = NormalizeHost(host)
+	for _, r := range slices.Concat(rs.Allow, rs.Deny) {
+		if r.sameOrigin(scheme, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate decides a request to scheme://host:port whose URL path is
+// escapedPath (as url.URL.EscapedPath). A matching deny rule denies it;
+// otherwise, when allow rules exist for the origin, one of them must
+// match. rule is the canonical form of the deciding rule, or "" when no
+// rule matched.
+func (rs HTTPRules) Evaluate(method, scheme, host string, port int, escapedPath string) (allowed bool, rule string) {
+	if !rs.Covers(scheme, host, port) {
+		return true, ""
+	}
+	host = NormalizeHost(host)
+	reqPath, ok := requestPath(escapedPath)
+	if !ok {
+		return false, ""
+	}
+	method = strings.ToUpper(method)
+	for _, r := range rs.Deny {
+		if r.sameOrigin(scheme, host, port) && r.matches(method, reqPath) {
+			return false, r.String()
+		}
+	}
+	scoped := false
+	for _, r := range rs.Allow {
+		if !r.sameOrigin(scheme, host, port) {
+			continue
+		}
+		scoped = true
+		if r.matches(method, reqPath) {
+			return true, r.String()
+		}
+	}
+	return !scoped, ""
+}
+
+// requestPath returns the path rules are matched against: percent-decoded
+// except for encoded slashes (which stay "%2F", inside their segment),
+// with dot segments removed. ok is false for a path that does not decode.
+func requestPath(escapedPath string) (string, bool) {
+	if escapedPath == "" {
+		return "/", true
+	}
+	parts := encodedSlash.Split(escapedPath, -1)
+	for i, part := range parts {
+		dec, err := url.PathUnescape(part)
+		if err != nil {
+			return "", false
+		}
+		parts[i] = dec
+	}
+	p := strings.Join(parts, "%2F")
+	if !strings.HasPrefix(p, "/") {
+		return "", false
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, true
+}
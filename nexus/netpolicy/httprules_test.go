@@ -0,0 +1,100 @@
+package netpolicy
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// httpRulesVectors is docs/protocol/directivespec_capabilities_net.http_rules.v1.json;
+// its parse cases are shared with Mothership's Conduits::NetHttpRulesV1 tests.
+type httpRulesVectors struct {
+	Parse []struct {
+		Rule      string `json:"rule"`
+		Canonical string `json:"canonical"`
+		Error     bool   `json:"error"`
+	} `json:"parse"`
+	Evaluate struct {
+		Rules    protocol.NetHTTPRulesV1 `json:"rules"`
+		Requests []struct {
+			Method   string `json:"method"`
+			URL      string `json:"url"`
+			Expected bool   `json:"expected"`
+			Rule     string `json:"rule"`
+		} `json:"requests"`
+	} `json:"evaluate"`
+}
+
+func loadHTTPRulesVectors(t *testing.T) httpRulesVectors {
+	t.Helper()
+	b, err := os.ReadFile("../docs/protocol/directivespec_capabilities_net.http_rules.v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v httpRulesVectors
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestParseHTTPRule_Vectors(t *testing.T) {
+	for _, tc := range loadHTTPRulesVectors(t).Parse {
+		rule, err := ParseHTTPRule(tc.Rule)
+		if tc.Error {
+			if err == nil {
+				t.Errorf("%q: expected error, got %s", tc.Rule, rule)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.Rule, err)
+			continue
+		}
+		if got := rule.String(); got != tc.Canonical {
+			t.Errorf("%q: canonical = %q, want %q", tc.Rule, got, tc.Canonical)
+		}
+		if again, err := ParseHTTPRule(tc.Canonical); err != nil || again.String() != tc.Canonical {
+			t.Errorf("%q: canonical form does not round-trip: %v", tc.Rule, err)
+		}
+	}
+}
+
+func TestHTTPRulesEvaluate_Vectors(t *testing.T) {
+	v := loadHTTPRulesVectors(t)
+	rules, err := ParseHTTPRules(&v.Evaluate.Rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range v.Evaluate.Requests {
+		u, err := url.Parse(tc.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := defaultHTTPPorts[u.Scheme]
+		if u.Port() != "" {
+			port, _ = strconv.Atoi(u.Port())
+		}
+		allowed, rule := rules.Evaluate(tc.Method, u.Scheme, u.Hostname(), port, u.EscapedPath())
+		if allowed != tc.Expected || rule != tc.Rule {
+			t.Errorf("%s %s = %v by %q, want %v by %q", tc.Method, tc.URL, allowed, rule, tc.Expected, tc.Rule)
+		}
+	}
+}
+
+func TestHTTPRulesEvaluate_Unparsable(t *testing.T) {
+	rules, err := ParseHTTPRules(&protocol.NetHTTPRulesV1{Deny: []string{"* http://example.com/admin/**"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := rules.Evaluate("GET", "http", "example.com", 80, "/admin%zz"); allowed {
+		t.Error("path that does not decode was allowed")
+	}
+	if allowed, _ := rules.Evaluate("GET", "http", "other.example.com", 80, "/admin%zz"); !allowed {
+		t.Error("origin without rules was denied")
+	}
+}
@@ -1,33 +1,66 @@
 package netpolicy
 
-import "net"
+import (
+	"net"
+	"net/netip"
+)
 
-// privateCIDRs contains RFC1918, loopback, link-local, and other non-routable ranges.
-var privateCIDRs []*net.IPNet
+// privateRanges lists RFC1918, loopback, link-local, and other non-routable ranges.
+var privateRanges = []string{
+	"0.0.0.0/8",      // "this" network (RFC1122)
+	"10.0.0.0/8",     // RFC1918
+	"172.16.0.0/12",  // RFC1918
+	"192.168.0.0/16", // RFC1918
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local IPv4
+	"100.64.0.0/10",  // shared address space (CGNAT)
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved for future use
+	"::1/128",        // loopback IPv6
+	"fc00::/7",       // unique-local IPv6
+	"fe80::/10",      // link-local IPv6
+}
+
+// optInRanges are the private ranges an allowlist V2 "private:" entry may
+// open: internal networks, never the host itself, link-local services such
+// as cloud metadata endpoints, or reserved space.
+var optInRanges = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+}
+
+// privateCIDRs is privateRanges, parsed; privatePrefixes and optInPrefixes
+// are privateRanges and optInRanges as netip prefixes.
+var (
+	privateCIDRs    []*net.IPNet
+	privatePrefixes []netip.Prefix
+	optInPrefixes   []netip.Prefix
+)
 
 func init() {
-	for _, cidr := range []string{
-		"0.0.0.0/8",      // "this" network (RFC1122)
-		"10.0.0.0/8",     // RFC1918
-		"172.16.0.0/12",  // RFC1918
-		"192.168.0.0/16", // RFC1918
-		"127.0.0.0/8",    // loopback
-		"169.254.0.0/16", // link-local IPv4
-		"100.64.0.0/10",  // shared address space (CGNAT)
-		"224.0.0.0/4",    // multicast
-		"240.0.0.0/4",    // reserved for future use
-		"::1/128",        // loopback IPv6
-		"fc00::/7",       // unique-local IPv6
-		"fe80::/10",      // link-local IPv6
-	} {
+	for _, cidr := range privateRanges {
 		_, ipNet, err := net.ParseCIDR(cidr)
 		if err != nil {
 			panic("bad hardcoded CIDR " + cidr + ": " + err.Error())
 		}
 		privateCIDRs = append(privateCIDRs, ipNet)
+		privatePrefixes = append(privatePrefixes, netip.MustParsePrefix(cidr))
+	}
+	for _, cidr := range optInRanges {
+		optInPrefixes = append(optInPrefixes, netip.MustParsePrefix(cidr))
 	}
 }
 
+// PrivateCIDRs returns the ranges IsPrivateIP rejects (beyond the loopback
+// and link-local checks it also makes), for enforcement outside the proxy
+// such as nftables rules.
+func PrivateCIDRs() []string {
+	return append([]string(nil), privateRanges...)
+}
+
 // IsPrivateIP returns true if the given IP is in a private, loopback,
 // link-local, or otherwise non-routable range.
 // Used by the egress proxy to deny connections to internal addresses.
@@ -45,3 +78,37 @@ func IsPrivateIP(ip net.IP) bool {
 	}
 	return false
 }
+
+// IsOptInPrivateIP reports whether ip is private but in a range that an
+// allowlist V2 "private:" entry may open (see optInRanges).
+func IsOptInPrivateIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range optInPrefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// OptInPrivatePrefixes returns the part of p that an allowlist V2
+// "private:" entry opens: p itself if it lies in an opt-in range, otherwise
+// the opt-in ranges inside p (none for a public prefix). Like PrivateCIDRs,
+// it is for enforcement outside the proxy.
+func OptInPrivatePrefixes(p netip.Prefix) []netip.Prefix {
+	p = p.Masked()
+	var parts []netip.Prefix
+	for _, o := range optInPrefixes {
+		switch {
+		case o.Bits() <= p.Bits() && o.Contains(p.Addr()):
+			return []netip.Prefix{p}
+		case p.Bits() < o.Bits() && p.Contains(o.Addr()):
+			parts = append(parts, o)
+		}
+	}
+	return parts
+}
@@ -2,6 +2,8 @@ package netpolicy
 
 import (
 	"net"
+	"net/netip"
+	"slices"
 	"testing"
 )
 
@@ -78,3 +80,46 @@ func TestIsPrivateIP_Nil(t *testing.T) {
 		t.Error("IsPrivateIP(nil) = false, want true (fail-closed)")
 	}
 }
+
+func TestPrivateCIDRs(t *testing.T) {
+	cidrs := PrivateCIDRs()
+	if len(cidrs) == 0 {
+		t.Fatal("no private CIDRs")
+	}
+	for _, cidr := range cidrs {
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("%s: %v", cidr, err)
+		}
+		if !IsPrivateIP(ip) {
+			t.Errorf("%s is not rejected by IsPrivateIP", cidr)
+		}
+	}
+	cidrs[0] = "mutated"
+	if PrivateCIDRs()[0] == "mutated" {
+		t.Error("PrivateCIDRs exposes its backing array")
+	}
+}
+
+func TestOptInPrivatePrefixes(t *testing.T) {
+	for _, tc := range []struct {
+		prefix string
+		want   []string
+	}{
+		{"10.1.2.3/32", []string{"10.1.2.3/32"}},
+		{"192.168.1.0/24", []string{"192.168.1.0/24"}},
+		{"140.82.112.3/32", nil},
+		{"127.0.0.1/32", nil},
+		{"169.254.169.254/32", nil},
+		{"0.0.0.0/0", []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10"}},
+		{"fd00::1/128", []string{"fd00::1/128"}},
+	} {
+		var got []string
+		for _, p := range OptInPrivatePrefixes(netip.MustParsePrefix(tc.prefix)) {
+			got = append(got, p.String())
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("OptInPrivatePrefixes(%s) = %v, want %v", tc.prefix, got, tc.want)
+		}
+	}
+}
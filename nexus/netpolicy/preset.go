@@ -0,0 +1,123 @@
+package netpolicy
+
+import (
+	"fmt"
+	"slices"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// Preset is the base policy a NetCapabilityV1.preset stands for
+// (design 03 §7.1.1).
+type Preset struct {
+	Mode  string
+	Allow []string
+}
+
+// presets must stay identical to Conduits::NetPresetsV1::PRESETS in
+// Mothership; both are checked against
+// docs/protocol/directivespec_capabilities_net.presets.v1.json.
+var presets = map[string]Preset{
+	"off": {Mode: "unrestricted"},
+	"loose": {Mode: "allowlist", Allow: []string{
+		// GitHub
+		"github.com:443",
+		"api.github.com:443",
+		"codeload.github.com:443",
+		"*.githubusercontent.com:443",
+		"ghcr.io:443",
+		// Ruby
+		"rubygems.org:443",
+		"index.rubygems.org:443",
+		"cache.ruby-lang.org:443",
+		// JavaScript
+		"registry.npmjs.org:443",
+		"registry.yarnpkg.com:443",
+		"nodejs.org:443",
+		// Python
+		"pypi.org:443",
+		"files.pythonhosted.org:443",
+		// Go
+		"proxy.golang.org:443",
+		"sum.golang.org:443",
+		"go.dev:443",
+		"dl.google.com:443",
+		// Rust
+		"crates.io:443",
+		"index.crates.io:443",
+		"static.crates.io:443",
+		"static.rust-lang.org:443",
+		// Java
+		"repo.maven.apache.org:443",
+		"repo1.maven.org:443",
+	}},
+	"strict": {Mode: "allowlist", Allow: []string{
+		"github.com:443",
+		"api.github.com:443",
+		"codeload.github.com:443",
+		"objects.githubusercontent.com:443",
+	}},
+	"no_external": {Mode: "none"},
+}
+
+// PresetFor returns the named preset. "custom" and "" have none: the
+// capability's own mode and allowlist are the whole policy.
+func PresetFor(name string) (Preset, bool) {
+	p, ok := presets[name]
+	return p, ok
+}
+
+var modeRank = map[string]int{"none": 0, "allowlist": 1, "unrestricted": 2}
+
+// ExpandPreset returns the effective capability for cap: the preset's
+// allowlist followed by cap's own entries, under the more restrictive of the
+// two modes (a preset never widens cap.Mode). Entries are canonicalized
+// under cap.AllowlistVersion (see AllowlistEntryV2.String) and deduplicated;
+// preset entries are valid in either grammar. Outside allowlist mode the
+// allowlist is dropped. HTTP rules are canonicalized and deduplicated too
+// (see HTTPRule.String), and dropped in mode none. Expanding an effective
+// capability is a no-op.
+func ExpandPreset(cap protocol.NetCapabilityV1) (protocol.NetCapabilityV1, error) {
+	if _, ok := modeRank[cap.Mode]; !ok {
+		return cap, fmt.Errorf("invalid net mode: %q", cap.Mode)
+	}
+	if _, err := entryParser(cap.AllowlistVersion); err != nil {
+		return cap, err
+	}
+	var base []string
+	var err error
+	switch cap.Preset {
+	case "", "custom":
+	default:
+		preset, ok := presets[cap.Preset]
+		if !ok {
+			return cap, fmt.Errorf("unknown net preset: %q", cap.Preset)
+		}
+		if modeRank[preset.Mode] < modeRank[cap.Mode] {
+			cap.Mode = preset.Mode
+		}
+		base = preset.Allow
+	}
+
+	if cap.Mode == "none" {
+		cap.HTTPRules = nil
+	} else if cap.HTTPRules, err = canonicalHTTPRules(cap.HTTPRules); err != nil {
+		return cap, err
+	}
+	if cap.Mode != "allowlist" {
+		cap.Allow = nil
+		return cap, nil
+	}
+	entries, err := ParseAllowlist(cap.AllowlistVersion, slices.Concat(base, cap.Allow))
+	if err != nil {
+		return cap, err
+	}
+	var allow []string
+	for _, entry := range entries {
+		if s := entry.String(); !slices.Contains(allow, s) {
+			allow = append(allow, s)
+		}
+	}
+	cap.Allow = allow
+	return cap, nil
+}
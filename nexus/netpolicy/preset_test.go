@@ -0,0 +1,101 @@
+package netpolicy
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// presetVectors is docs/protocol/directivespec_capabilities_net.presets.v1.json,
+// shared with Mothership's Conduits::NetPresetsV1 tests.
+type presetVectors struct {
+	Presets map[string]struct {
+		Mode  string   `json:"mode"`
+		Allow []string `json:"allow"`
+	} `json:"presets"`
+	Cases []struct {
+		Name     string                   `json:"name"`
+		Input    protocol.NetCapabilityV1 `json:"input"`
+		Expected protocol.NetCapabilityV1 `json:"expected"`
+		Error    bool                     `json:"error"`
+	} `json:"cases"`
+}
+
+func loadPresetVectors(t *testing.T) presetVectors {
+	t.Helper()
+	b, err := os.ReadFile("../docs/protocol/directivespec_capabilities_net.presets.v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v presetVectors
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestPresets_MatchVectors(t *testing.T) {
+	v := loadPresetVectors(t)
+	if len(v.Presets) != len(presets) {
+		t.Errorf("got %d presets, vectors have %d", len(presets), len(v.Presets))
+	}
+	for name, want := range v.Presets {
+		got, ok := PresetFor(name)
+		if !ok {
+			t.Errorf("preset %q missing", name)
+			continue
+		}
+		if got.Mode != want.Mode || !slices.Equal(got.Allow, want.Allow) {
+			t.Errorf("preset %q = %+v, vectors have %+v", name, got, want)
+		}
+	}
+	for _, name := range []string{"", "custom"} {
+		if _, ok := PresetFor(name); ok {
+			t.Errorf("preset %q should have no base policy", name)
+		}
+	}
+}
+
+func TestExpandPreset_Vectors(t *testing.T) {
+	for _, tc := range loadPresetVectors(t).Cases {
+		got, err := ExpandPreset(tc.Input)
+		if tc.Error {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", tc.Name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.Name, err)
+			continue
+		}
+		if !equalCapability(got, tc.Expected) {
+			t.Errorf("%s: got %+v, want %+v", tc.Name, got, tc.Expected)
+		}
+		if again, err := ExpandPreset(got); err != nil || !equalCapability(again, got) {
+			t.Errorf("%s: expansion not idempotent: %+v, %v", tc.Name, again, err)
+		}
+	}
+}
+
+func equalCapability(a, b protocol.NetCapabilityV1) bool {
+	return a.Mode == b.Mode && a.Preset == b.Preset && slices.Equal(a.Allow, b.Allow) && a.AllowlistVersion == b.AllowlistVersion &&
+		a.TTLSeconds == b.TTLSeconds && reflect.DeepEqual(a.XExt, b.XExt)
+}
+
+func TestExpandPreset_DoesNotAliasInput(t *testing.T) {
+	in := protocol.NetCapabilityV1{Mode: "allowlist", Preset: "strict", Allow: []string{"Example.com:443"}}
+	if _, err := ExpandPreset(in); err != nil {
+		t.Fatal(err)
+	}
+	if in.Allow[0] != "Example.com:443" {
+		t.Errorf("input modified: %v", in.Allow)
+	}
+	if presets["strict"].Allow[0] != "github.com:443" {
+		t.Errorf("preset table modified: %v", presets["strict"].Allow)
+	}
+}
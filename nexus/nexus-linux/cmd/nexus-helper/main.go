@@ -1,25 +1,189 @@
+//go:build linux
+
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"cybros.ai/nexus/helper"
 )
 
 func main() {
-	var mode string
-	flag.StringVar(&mode, "mode", "noop", "helper mode (noop|doctor)")
+	var (
+		mode        string
+		socketPath  string
+		socketGroup string
+		allowUsers  string
+		allowGroups string
+		cgroupRoot  string
+		jailerPath  string
+		fcPath      string
+		chrootBase  string
+		jailerUIDs  string
+		vmmCgroup   string
+	)
+	flag.StringVar(&mode, "mode", "serve", "helper mode (serve|doctor)")
+	flag.StringVar(&socketPath, "socket", helper.DefaultSocketPath, "Unix socket to listen on")
+	flag.StringVar(&socketGroup, "socket-group", "cybros-nexus", "group owning the socket (empty: keep root)")
+	flag.StringVar(&allowUsers, "allow-users", "root,cybros-nexus", "comma-separated users allowed to call the helper")
+	flag.StringVar(&allowGroups, "allow-groups", "", "comma-separated groups allowed to call the helper")
+	flag.StringVar(&cgroupRoot, "cgroup-root", "/sys/fs/cgroup/nexusd", "parent cgroup for directives")
+	flag.StringVar(&jailerPath, "jailer", "/usr/local/bin/jailer", "Firecracker jailer binary")
+	flag.StringVar(&fcPath, "firecracker", "/usr/local/bin/firecracker", "Firecracker binary (jailer --exec-file)")
+	flag.StringVar(&chrootBase, "chroot-base", "/srv/jailer", "jailer --chroot-base-dir")
+	flag.StringVar(&jailerUIDs, "jailer-uid-range", "900000:1024", "start:count of the uids Firecracker may run as (gid = uid); must cover nexusd's firecracker.jailer uid range")
+	flag.StringVar(&vmmCgroup, "jailer-parent-cgroup", "nexus-firecracker", "jailer --parent-cgroup for VMM cgroups")
 	flag.Parse()
 
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
 	switch mode {
-	case "noop":
-		fmt.Println("nexus-helper: noop (privileged helper skeleton).")
-		fmt.Println("TODO: implement minimal privileged operations (netns/tap/nft/cgroup/jailer) behind a strict RPC API.")
+	case "serve":
+		cfg := helper.ServerConfig{
+			SocketPath:         socketPath,
+			SocketGID:          -1,
+			CgroupRoot:         cgroupRoot,
+			JailerPath:         jailerPath,
+			FirecrackerPath:    fcPath,
+			ChrootBase:         chrootBase,
+			JailerParentCgroup: vmmCgroup,
+		}
+		var err error
+		if cfg.JailerUIDStart, cfg.JailerUIDCount, err = parseUIDRange(jailerUIDs); err != nil {
+			fatal("parse -jailer-uid-range", err)
+		}
+		if cfg.AllowedUIDs, err = lookupIDs(allowUsers, lookupUID); err != nil {
+			fatal("resolve -allow-users", err)
+		}
+		if cfg.AllowedGIDs, err = lookupIDs(allowGroups, lookupGID); err != nil {
+			fatal("resolve -allow-groups", err)
+		}
+		if socketGroup != "" {
+			if cfg.SocketGID, err = lookupGID(socketGroup); err != nil {
+				fatal("resolve -socket-group", err)
+			}
+		}
+
+		srv := helper.NewServer(cfg, helper.ExecRunner)
+		ln, err := srv.Listen()
+		if err != nil {
+			fatal("listen", err)
+		}
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		slog.Info("nexus-helper listening", "socket", socketPath, "version", helper.ProtocolVersion,
+			"allowed_uids", cfg.AllowedUIDs, "allowed_gids", cfg.AllowedGIDs)
+		if err := srv.Serve(ctx, ln); err != nil {
+			fatal("serve", err)
+		}
 	case "doctor":
-		fmt.Println("nexus-helper: doctor (skeleton)")
-		fmt.Println("TODO: check nftables/iptables availability, CAP_NET_ADMIN, cgroups v2, etc.")
+		ok := true
+		check := func(name string, err error) {
+			status := "ok"
+			if err != nil {
+				status = "FAIL: " + err.Error()
+				ok = false
+			}
+			fmt.Printf("%-14s %s\n", name, status)
+		}
+		check("root", func() error {
+			if os.Geteuid() != 0 {
+				return fmt.Errorf("running as uid %d", os.Geteuid())
+			}
+			return nil
+		}())
+		check("cgroup v2", statErr("/sys/fs/cgroup/cgroup.controllers"))
+		check("ip", lookPathErr("ip"))
+		check("nft", lookPathErr("nft"))
+		check("jailer", statErr(jailerPath))
+		check("firecracker", statErr(fcPath))
+		if !ok {
+			os.Exit(1)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "unknown mode: %s\n", mode)
 		os.Exit(2)
 	}
 }
+
+func fatal(what string, err error) {
+	slog.Error("nexus-helper: "+what+" failed", "error", err)
+	os.Exit(1)
+}
+
+func lookupIDs(list string, lookup func(string) (int, error)) ([]int, error) {
+	var ids []int
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseUIDRange parses "start:count". The range must not reach real users
+// (start >= 1000) and must fit in a uid.
+func parseUIDRange(s string) (start, count int, err error) {
+	a, b, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("want start:count, got %q", s)
+	}
+	if start, err = strconv.Atoi(a); err != nil {
+		return 0, 0, err
+	}
+	if count, err = strconv.Atoi(b); err != nil {
+		return 0, 0, err
+	}
+	if start < 1000 || count < 1 || start+count > 1<<31 {
+		return 0, 0, fmt.Errorf("invalid range %q", s)
+	}
+	return start, count, nil
+}
+
+func lookupUID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGID(name string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+func statErr(path string) error {
+	_, err := os.Stat(path)
+	return err
+}
+
+func lookPathErr(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
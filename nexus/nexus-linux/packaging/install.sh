@@ -33,8 +33,10 @@ if [ ! -f "${CONFIG_DIR}/config.yaml" ]; then
 fi
 
 echo "[5/5] Install systemd units"
+cp ./nexus-linux/packaging/systemd/nexus-helper.service /etc/systemd/system/cybros-nexus-helper.service
 cp ./nexus-linux/packaging/systemd/nexusd.service /etc/systemd/system/cybros-nexusd.service
 systemctl daemon-reload
+systemctl enable --now cybros-nexus-helper.service
 systemctl enable --now cybros-nexusd.service
 
 echo "Done."
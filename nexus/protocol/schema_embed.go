@@ -7,3 +7,9 @@ var NetCapabilitySchemaV1 []byte
 
 //go:embed schema/directivespec_capabilities_fs.schema.v1.json
 var FsCapabilitySchemaV1 []byte
+
+//go:embed schema/directivespec_capabilities_secrets.schema.v1.json
+var SecretsCapabilitySchemaV1 []byte
+
+//go:embed schema/directivespec_capabilities_net_allowlist.schema.v2.json
+var NetAllowlistEntrySchemaV2 []byte
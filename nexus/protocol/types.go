@@ -7,6 +7,9 @@ type DirectiveSpec struct {
 	DirectiveID    string       `json:"directive_id"`
 	Facility       FacilitySpec `json:"facility"`
 	SandboxProfile string       `json:"sandbox_profile"` // untrusted/trusted/host/darwin-automation etc
+	// Tenant labels the account the directive runs for. Nexus never shares
+	// cached state (e.g. the egress cache) between different tenants.
+	Tenant string `json:"tenant,omitempty"`
 
 	Command string `json:"command"`         // shell command string (not JSON array)
 	Shell   string `json:"shell,omitempty"` // default /bin/sh; unified across all platforms
@@ -15,11 +18,40 @@ type DirectiveSpec struct {
 	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
 	Limits         Limits `json:"limits,omitempty"`
 
+	// Interactive, when set, runs the command on a pseudo-terminal whose
+	// input (keystrokes, resizes) Nexus pulls from the input endpoint.
+	Interactive *InteractiveSpec `json:"interactive,omitempty"`
+
+	// Session, when set, runs the command in a long-lived sandbox shared
+	// with the other directives of the same session (see SessionSpec).
+	Session *SessionSpec `json:"session,omitempty"`
+
 	Capabilities Capabilities `json:"capabilities,omitempty"`
 
 	Artifacts ArtifactsSpec `json:"artifacts,omitempty"`
 }
 
+// InteractiveSpec is the initial terminal size of an interactive
+// directive; zero means the default 24x80.
+type InteractiveSpec struct {
+	Rows int `json:"rows,omitempty"`
+	Cols int `json:"cols,omitempty"`
+}
+
+// SessionSpec attaches a directive to a session: a sandbox kept alive on
+// the territory across directives. The first directive naming an ID opens
+// the session (setup as for a one-shot directive); later ones run in it
+// and must match its profile, facility, tenant, limits and capabilities.
+// The session closes after IdleTimeoutSeconds without a directive, when a
+// directive sets Close (after running), or when its sandbox fails.
+type SessionSpec struct {
+	ID string `json:"id"`
+	// IdleTimeoutSeconds defaults to 600, capped by the territory's
+	// sessions.max_idle_timeout.
+	IdleTimeoutSeconds int  `json:"idle_timeout_seconds,omitempty"`
+	Close              bool `json:"close,omitempty"`
+}
+
 type FacilitySpec struct {
 	ID      string `json:"id"`
 	Mount   string `json:"mount,omitempty"`    // default /workspace
@@ -32,12 +64,18 @@ type Limits struct {
 	DiskMB         int `json:"disk_mb,omitempty"`
 	MaxOutputBytes int `json:"max_output_bytes,omitempty"`
 	MaxDiffBytes   int `json:"max_diff_bytes,omitempty"`
+
+	// Egress limits, enforced by the directive's egress proxy (design 03 §7.7).
+	NetBytesPerSec        int64 `json:"net_bytes_per_sec,omitempty"`
+	NetMaxBytes           int64 `json:"net_max_bytes,omitempty"`
+	NetMaxConns           int   `json:"net_max_conns,omitempty"`
+	NetIdleTimeoutSeconds int   `json:"net_idle_timeout_seconds,omitempty"`
 }
 
 type Capabilities struct {
-	Net *NetCapabilityV1 `json:"net,omitempty"`
-	Fs  *FsCapabilityV1  `json:"fs,omitempty"`
-	// TODO: env/secrets ...
+	Net     *NetCapabilityV1     `json:"net,omitempty"`
+	Fs      *FsCapabilityV1      `json:"fs,omitempty"`
+	Secrets *SecretsCapabilityV1 `json:"secrets,omitempty"`
 }
 
 type ArtifactsSpec struct {
@@ -47,11 +85,25 @@ type ArtifactsSpec struct {
 
 // NetCapabilityV1 corresponds to docs/protocol/directivespec_capabilities_net.schema.v1.json
 type NetCapabilityV1 struct {
-	Mode       string         `json:"mode"`                  // none/allowlist/unrestricted
-	Preset     string         `json:"preset,omitempty"`      // off/loose/strict/no_external/custom
-	Allow      []string       `json:"allow,omitempty"`       // required when mode=allowlist
-	TTLSeconds int            `json:"ttl_seconds,omitempty"` // hint for audit (future: enforcement)
-	XExt       map[string]any `json:"x_ext,omitempty"`
+	Mode       string   `json:"mode"`                  // none/allowlist/unrestricted
+	Preset     string   `json:"preset,omitempty"`      // off/loose/strict/no_external/custom
+	Allow      []string `json:"allow,omitempty"`       // required when mode=allowlist
+	TTLSeconds int      `json:"ttl_seconds,omitempty"` // grant expires this long after directive start
+	// AllowlistVersion selects the grammar of Allow: 1 (default) or 2, which
+	// adds IP/CIDR targets, port ranges and "private:" opt-ins.
+	AllowlistVersion int `json:"allowlist_version,omitempty"`
+	// HTTPRules restrict methods and paths of the HTTP requests the egress
+	// proxy can see: proxied plain HTTP and intercepted TLS.
+	HTTPRules *NetHTTPRulesV1 `json:"http_rules,omitempty"`
+	XExt      map[string]any  `json:"x_ext,omitempty"`
+}
+
+// NetHTTPRulesV1 lists HTTP rules, "<METHOD|*> <http|https>://<host>[:port]<path-glob>"
+// (design 03 §7.9). A request matching a Deny rule is denied; a request to
+// an origin that has Allow rules must match one of them.
+type NetHTTPRulesV1 struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
 }
 
 // FsCapabilityV1 corresponds to docs/protocol/directivespec_capabilities_fs.schema.v1.json
@@ -71,6 +123,31 @@ type FsCapabilityV1 struct {
 	ReadOnlySubpaths []string `json:"read_only_subpaths,omitempty"`
 }
 
+// SecretsCapabilityV1 lists secrets to deliver into the sandbox. The spec only
+// carries references; Nexus fetches the values per directive with the
+// directive token and holds them in memory (see 02_security_profiles.md §5.7).
+type SecretsCapabilityV1 struct {
+	Refs []SecretRefV1 `json:"refs"`
+}
+
+// SecretRefV1 delivers one secret as an environment variable (Env), as a
+// read-only file on tmpfs (File, a plain file name under /run/secrets), or
+// keeps it out of the sandbox as an HTTP credential the egress proxy
+// injects (HTTPAuth). Exactly one of them must be set.
+type SecretRefV1 struct {
+	Ref      string            `json:"ref"` // secret reference resolved by Mothership
+	Env      string            `json:"env,omitempty"`
+	File     string            `json:"file,omitempty"`
+	HTTPAuth *SecretHTTPAuthV1 `json:"http_auth,omitempty"`
+}
+
+// SecretHTTPAuthV1 has the egress proxy send the secret as the
+// Authorization header of every request to Host (design 03 §7.8).
+type SecretHTTPAuthV1 struct {
+	Host   string `json:"host"`             // exact host name, optionally with ":port" (default 443)
+	Scheme string `json:"scheme,omitempty"` // e.g. "Bearer"; empty sends the value as is
+}
+
 type DirectiveLease struct {
 	DirectiveID    string        `json:"directive_id"`
 	DirectiveToken string        `json:"directive_token"`
@@ -80,6 +157,9 @@ type DirectiveLease struct {
 type PollRequest struct {
 	SupportedSandboxProfiles []string `json:"supported_sandbox_profiles"`
 	MaxDirectivesToClaim     int      `json:"max_directives_to_claim,omitempty"`
+	// Sessions lists the IDs of the sessions open on this territory, so
+	// their directives can be routed here.
+	Sessions []string `json:"sessions,omitempty"`
 }
 
 type PollResponse struct {
@@ -151,15 +231,129 @@ type LogChunkRequest struct {
 	Truncated   bool   `json:"truncated,omitempty"`
 }
 
+// InputRequest long-polls for the input of an interactive directive: the
+// server answers with the events after seq After as soon as there are any,
+// or with none after WaitSeconds.
+type InputRequest struct {
+	After       int `json:"after"`
+	WaitSeconds int `json:"wait_seconds,omitempty"`
+}
+
+type InputResponse struct {
+	Events []InputEvent `json:"events"`
+}
+
+// InputEvent is one terminal input event, numbered from 1 by the server.
+// Type "data" types BytesBase64 into the terminal, "resize" sets its size
+// to Rows x Cols, and "eof" types ^D.
+type InputEvent struct {
+	Seq         int    `json:"seq"`
+	Type        string `json:"type"`
+	BytesBase64 string `json:"bytes,omitempty"` // base64
+	Rows        int    `json:"rows,omitempty"`
+	Cols        int    `json:"cols,omitempty"`
+}
+
+// DiffChunkRequest uploads one chunk of a diff too large to send inline as
+// FinishedRequest.DiffBase64. Chunks are appended in order; Offset must equal
+// the server's received_bytes for the upload identified by SHA256.
+type DiffChunkRequest struct {
+	SHA256      string `json:"sha256"`       // hex SHA-256 of the whole diff
+	TotalBytes  int64  `json:"total_bytes"`  // size of the whole diff
+	Offset      int64  `json:"offset"`       // byte offset of this chunk
+	BytesBase64 string `json:"bytes"`        // base64
+	ChunkSHA256 string `json:"chunk_sha256"` // hex SHA-256 of this chunk
+}
+
+// DiffUploadStatus is the server's view of a chunked diff upload, returned by
+// both the chunk and status endpoints (used to resume after failures).
+type DiffUploadStatus struct {
+	ReceivedBytes int64 `json:"received_bytes"`
+	Complete      bool  `json:"complete"`
+}
+
+// SecretsRequest asks Mothership for the values of the directive's secret refs.
+type SecretsRequest struct {
+	Refs []string `json:"refs"`
+}
+
+type SecretsResponse struct {
+	Secrets []SecretValue `json:"secrets"`
+}
+
+type SecretValue struct {
+	Ref   string `json:"ref"`
+	Value string `json:"value"`
+}
+
+// ArtifactFile describes one file collected via ArtifactsSpec.Collect.
+// Listed under artifacts_manifest.collected.files in FinishedRequest.
+type ArtifactFile struct {
+	Path     string `json:"path"`   // facility-relative, slash-separated
+	Size     int64  `json:"size"`   // bytes
+	Mode     string `json:"mode"`   // permission bits, octal (e.g. "0644")
+	SHA256   string `json:"sha256"` // hex-encoded
+	Uploaded bool   `json:"uploaded"`
+	Error    string `json:"error,omitempty"` // upload failure reason
+}
+
 type FinishedRequest struct {
 	ExitCode          *int           `json:"exit_code"` // pointer: 0 is valid, nil means not set
-	Status            string         `json:"status"`    // succeeded/failed/canceled/timed_out
+	Status            string         `json:"status"`    // succeeded/failed/canceled/timed_out/disk_quota_exceeded
 	StdoutTruncated   bool           `json:"stdout_truncated,omitempty"`
 	StderrTruncated   bool           `json:"stderr_truncated,omitempty"`
 	DiffTruncated     bool           `json:"diff_truncated,omitempty"`
 	DiffBase64        string         `json:"diff_base64,omitempty"`     // base64-encoded diff blob
 	SnapshotBefore    string         `json:"snapshot_before,omitempty"` // git HEAD hash before execution
 	SnapshotAfter     string         `json:"snapshot_after,omitempty"`  // git HEAD hash after execution
+	RedactionCount    int64          `json:"redaction_count,omitempty"` // spans masked in streamed logs
 	ArtifactsManifest map[string]any `json:"artifacts_manifest,omitempty"`
+	EgressSummary     *EgressSummary `json:"egress_summary,omitempty"` // nil when nothing went through the egress proxy
 	FinishedAt        string         `json:"finished_at,omitempty"`
 }
+
+// EgressAuditEvent records a single egress decision made by a directive's
+// egress proxy or DNS resolver (design 03 §7.5).
+type EgressAuditEvent struct {
+	Timestamp   string `json:"ts"`
+	DirectiveID string `json:"directive_id"`
+	DestHost    string `json:"dest_host"`
+	DestPort    int    `json:"dest_port"`
+	ResolvedIP  string `json:"resolved_ip,omitempty"` // DNS: comma-separated answers
+	Decision    string `json:"decision"`              // "allow" or "deny"
+	ReasonCode  string `json:"reason_code"`
+	Method      string `json:"method,omitempty"`     // "CONNECT", "HTTP", "SOCKS5", "SOCKS5_UDP" or "DNS"
+	QueryType   string `json:"query_type,omitempty"` // DNS only: "A", "AAAA", ...
+	SNI         string `json:"sni,omitempty"`        // TLS tunnels: ClientHello server name
+	// CredentialInjected marks traffic the proxy added a directive
+	// credential to (design 03 §7.8).
+	CredentialInjected bool `json:"credential_injected,omitempty"`
+	// HTTPRule is the HTTP rule that decided a request (design 03 §7.9).
+	HTTPRule string `json:"http_rule,omitempty"`
+	// Cache is "hit" or "miss" for proxied plain HTTP requests the egress
+	// cache could answer (design 03 §7.10).
+	Cache string `json:"cache,omitempty"`
+}
+
+// AuditEventsRequest uploads one batch of egress audit events. Seq numbers
+// the directive's batches from 0; re-sending a stored Seq is a no-op.
+type AuditEventsRequest struct {
+	Seq    int                `json:"seq"`
+	Events []EgressAuditEvent `json:"events"`
+}
+
+// EgressSummary counts a directive's egress decisions per destination host.
+// Hosts beyond the reporting cap are only counted in the totals.
+type EgressSummary struct {
+	Allowed        int64                       `json:"allowed"`
+	Denied         int64                       `json:"denied"`
+	Hosts          map[string]EgressHostCounts `json:"hosts"`
+	HostsTruncated bool                        `json:"hosts_truncated,omitempty"`
+	// EventsDropped counts events that could be neither uploaded nor spooled.
+	EventsDropped int64 `json:"events_dropped,omitempty"`
+}
+
+type EgressHostCounts struct {
+	Allowed int64 `json:"allowed"`
+	Denied  int64 `json:"denied"`
+}
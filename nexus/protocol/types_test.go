@@ -195,6 +195,27 @@ func TestDirectiveSpec_NestedJSON(t *testing.T) {
 	}
 }
 
+func TestSecretsCapability_JSON(t *testing.T) {
+	t.Parallel()
+
+	raw := `{"secrets":{"refs":[{"ref":"github_token","env":"GITHUB_TOKEN"},{"ref":"deploy_key","file":"id_ed25519"}]}}`
+	var caps Capabilities
+	if err := json.Unmarshal([]byte(raw), &caps); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if caps.Secrets == nil || len(caps.Secrets.Refs) != 2 {
+		t.Fatalf("expected 2 secret refs, got %+v", caps.Secrets)
+	}
+	if caps.Secrets.Refs[0].Env != "GITHUB_TOKEN" || caps.Secrets.Refs[1].File != "id_ed25519" {
+		t.Fatalf("unexpected refs: %+v", caps.Secrets.Refs)
+	}
+
+	b, _ := json.Marshal(Capabilities{})
+	if string(b) != "{}" {
+		t.Errorf("expected empty capabilities to omit secrets, got %s", b)
+	}
+}
+
 func TestLogChunkRequest_JSONRoundTrip(t *testing.T) {
 	t.Parallel()
 
@@ -208,3 +229,40 @@ func TestLogChunkRequest_JSONRoundTrip(t *testing.T) {
 		t.Fatalf("round-trip mismatch: %+v", got)
 	}
 }
+
+func TestSessionSpec_JSON(t *testing.T) {
+	t.Parallel()
+
+	spec := DirectiveSpec{
+		DirectiveID: "d-1",
+		Command:     "make test",
+		Session:     &SessionSpec{ID: "s-1", IdleTimeoutSeconds: 120},
+	}
+	b, _ := json.Marshal(spec)
+
+	var raw map[string]any
+	json.Unmarshal(b, &raw)
+	session, ok := raw["session"].(map[string]any)
+	if !ok {
+		t.Fatalf("expected session object, got %v", raw["session"])
+	}
+	if session["id"] != "s-1" || session["idle_timeout_seconds"] != float64(120) {
+		t.Fatalf("unexpected session JSON: %v", session)
+	}
+	if _, ok := session["close"]; ok {
+		t.Error("expected close to be omitted when false")
+	}
+
+	var got DirectiveSpec
+	json.Unmarshal([]byte(`{"directive_id":"d-2","session":{"id":"s-1","close":true}}`), &got)
+	if got.Session == nil || got.Session.ID != "s-1" || !got.Session.Close {
+		t.Fatalf("unexpected session: %+v", got.Session)
+	}
+
+	b, _ = json.Marshal(PollRequest{SupportedSandboxProfiles: []string{"host"}})
+	raw = nil
+	json.Unmarshal(b, &raw)
+	if _, ok := raw["sessions"]; ok {
+		t.Error("expected sessions to be omitted when empty")
+	}
+}
@@ -15,7 +15,9 @@ import (
 
 	"cybros.ai/nexus/config"
 	"cybros.ai/nexus/egressproxy"
+	"cybros.ai/nexus/protocol"
 	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/landlock"
 )
 
 // Driver implements sandbox.Driver using bubblewrap for untrusted workloads.
@@ -81,11 +83,30 @@ func (d *Driver) HealthCheck(ctx context.Context) sandbox.HealthResult {
 	return sandbox.HealthResult{Healthy: true, Details: details}
 }
 
+// EffectiveCapabilities reports Landlock filesystem enforcement applied
+// inside the sandbox on top of the bwrap mount namespace.
+func (d *Driver) EffectiveCapabilities(caps protocol.Capabilities) map[string]any {
+	return map[string]any{
+		"fs_enforcement": landlock.Summary(caps.Fs),
+	}
+}
+
 type truncationReporter interface {
 	StdoutTruncated() bool
 	StderrTruncated() bool
 }
 
+// DeliversSecrets implements sandbox.SecretsDeliverer: secrets are bind-mounted
+// from tmpfs and env secrets are exported by the wrapper script.
+func (d *Driver) DeliversSecrets() bool { return true }
+
+// InjectsCredentials implements sandbox.CredentialInjector: all egress goes
+// through the directive's egress proxy.
+func (d *Driver) InjectsCredentials() bool { return true }
+
+// RunsTerminal implements sandbox.TerminalRunner.
+func (d *Driver) RunsTerminal() bool { return true }
+
 // Run executes a command inside a bubblewrap sandbox.
 func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunResult, error) {
 	if req.Command == "" {
@@ -98,81 +119,12 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		return sandbox.RunResult{}, errors.New("FacilityPath is required for bwrap driver")
 	}
 
-	// 1. Start the egress proxy for this directive.
-	proxySocketDir := d.proxySocketDir(req)
-
-	auditWriter := io.Discard
-	if u, ok := req.LogSink.(interface {
-		UploadBytes(context.Context, string, []byte)
-	}); ok {
-		auditWriter = logSinkWriter{ctx: ctx, uploader: u, stream: "stderr"}
-	}
-
-	proxyInst, err := egressproxy.StartForDirective(
-		proxySocketDir, req.DirectiveID, req.NetCapability, auditWriter,
-	)
-	if err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("start egress proxy: %w", err)
-	}
-	defer proxyInst.Stop()
-
-	// 2. Prepare git clone args if needed.
-	var wrapperCfg WrapperConfig
-	wrapperCfg.SocatPath = d.cfg.SocatPath
-	wrapperCfg.UserCommand = req.Command
-	wrapperCfg.Shell = req.Shell
-	wrapperCfg.Env = req.Env
-
-	resolvedCwd, err := resolveCwd(req.Cwd)
+	// 1-4. Egress proxy, wrapper script and bwrap arguments.
+	bwrapArgs, cleanup, err := d.prepare(req, "")
 	if err != nil {
 		return sandbox.RunResult{}, err
 	}
-	wrapperCfg.Cwd = resolvedCwd
-
-	if req.RepoURL != "" {
-		cloneArgs, cloneEnv, cloneErr := sandbox.PrepareGitCloneArgs(req.RepoURL)
-		if cloneErr != nil {
-			return sandbox.RunResult{}, fmt.Errorf("prepare git clone: %w", cloneErr)
-		}
-		wrapperCfg.RepoURL = req.RepoURL
-		wrapperCfg.GitCloneArgs = cloneArgs
-		wrapperCfg.GitCloneEnv = cloneEnv
-	}
-
-	// 3. Generate the wrapper script.
-	wrapperScript, err := GenerateWrapper(wrapperCfg)
-	if err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("generate wrapper: %w", err)
-	}
-
-	// Write the wrapper to a temp file.
-	wrapperFile, err := os.CreateTemp("", "nexus-wrapper-*.sh")
-	if err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("create wrapper file: %w", err)
-	}
-	defer os.Remove(wrapperFile.Name())
-
-	if _, err := wrapperFile.WriteString(wrapperScript); err != nil {
-		wrapperFile.Close()
-		return sandbox.RunResult{}, fmt.Errorf("write wrapper: %w", err)
-	}
-	if err := wrapperFile.Close(); err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("close wrapper: %w", err)
-	}
-
-	// 4. Build bwrap command.
-	bwrapArgs, err := BuildArgs(CmdConfig{
-		BwrapPath:         d.cfg.BwrapPath,
-		RootfsPath:        d.cfg.RootfsPath,
-		FacilityPath:      req.FacilityPath,
-		ProxySocketPath:   proxyInst.SocketPath(),
-		WrapperScriptPath: wrapperFile.Name(),
-		Cwd:               req.Cwd,
-		HostHasLib64:      hostHasLib64(),
-	})
-	if err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("build bwrap args: %w", err)
-	}
+	defer cleanup()
 
 	// 5. Execute bwrap.
 	cmd := exec.CommandContext(ctx, bwrapArgs[0], bwrapArgs[1:]...)
@@ -186,14 +138,11 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		return cmd.Process.Kill()
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return sandbox.RunResult{}, err
-	}
-	stderr, err := cmd.StderrPipe()
+	cio, err := sandbox.AttachIO(cmd, req)
 	if err != nil {
 		return sandbox.RunResult{}, err
 	}
+	defer cio.Close()
 
 	if err := cmd.Start(); err != nil {
 		return sandbox.RunResult{}, fmt.Errorf("start bwrap: %w", err)
@@ -203,24 +152,16 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	// Fail-closed: if limits were explicitly requested but couldn't be applied,
 	// abort the directive rather than running without resource constraints.
 	var warnings []string
-	cg, cgErr := sandbox.ApplyCgroupLimits(req.DirectiveID, cmd.Process.Pid, req.Limits)
+	cgCleanup, cgErr := sandbox.ApplyLimits(ctx, req, cmd.Process.Pid)
 	if cgErr != nil {
 		_ = cmd.Process.Kill()
 		_, _ = cmd.Process.Wait()
 		return sandbox.RunResult{}, fmt.Errorf("cgroup limits required but failed to apply: %w", cgErr)
 	}
-	if cg != nil {
-		defer cg.Cleanup()
-	}
-
-	// Stream logs concurrently.
-	errCh := make(chan error, 2)
-	go func() { errCh <- req.LogSink.Consume(ctx, "stdout", stdout) }()
-	go func() { errCh <- req.LogSink.Consume(ctx, "stderr", stderr) }()
+	defer cgCleanup()
 
-	// Drain pipe readers BEFORE cmd.Wait() (same pattern as host driver).
-	consume1 := <-errCh
-	consume2 := <-errCh
+	// Stream logs BEFORE cmd.Wait() (same pattern as host driver).
+	consumeErr := cio.Stream(ctx, req.LogSink)
 
 	waitErr := cmd.Wait()
 
@@ -243,7 +184,6 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		result.Status = "canceled"
 	}
 
-	consumeErr := errors.Join(consume1, consume2)
 	if consumeErr != nil {
 		return result, consumeErr
 	}
@@ -251,24 +191,171 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	return result, nil
 }
 
-func (d *Driver) proxySocketDir(req sandbox.RunRequest) string {
-	if d.cfg.ProxySocketDir != "" {
-		return d.cfg.ProxySocketDir
-	}
-	return filepath.Join(filepath.Dir(req.FacilityPath), ".proxy-sockets")
+// OpenSession implements sandbox.SessionRunner: the sandbox is set up as in
+// Run (egress proxy, clone, secrets, limits) and then runs the session
+// agent, which starts each command (sessionCommand). Closing the session
+// ends the agent and with it the sandbox's PID namespace.
+func (d *Driver) OpenSession(ctx context.Context, req sandbox.RunRequest) (sandbox.Session, error) {
+	if req.FacilityPath == "" {
+		return nil, errors.New("FacilityPath is required for bwrap driver")
+	}
+	var cleanup func()
+	return sandbox.StartAgentSession(ctx, req, sandbox.SessionStart{
+		Cmd: func(socket string) (*exec.Cmd, error) {
+			bwrapArgs, c, err := d.prepare(req, socket)
+			if err != nil {
+				return nil, err
+			}
+			cleanup = c
+			cmd := exec.Command(bwrapArgs[0], bwrapArgs[1:]...)
+			cmd.Env = minimalExecEnv()
+			return cmd, nil
+		},
+		Command:      sessionCommand,
+		CgroupLimits: true,
+		Cleanup: func() {
+			if cleanup != nil {
+				cleanup()
+			}
+		},
+	})
 }
 
-type logSinkWriter struct {
-	ctx      context.Context
-	uploader interface {
-		UploadBytes(context.Context, string, []byte)
+// prepare starts the egress proxy for req, writes the wrapper script and
+// returns the bwrap arguments, plus the cleanup releasing both. With a
+// sessionSocket the sandbox runs the session agent connecting to it instead
+// of req.Command.
+func (d *Driver) prepare(req sandbox.RunRequest, sessionSocket string) (bwrapArgs []string, cleanup func(), err error) {
+	// 1. Start the egress proxy for this directive.
+	proxySocketDir := d.proxySocketDir(req)
+
+	auditWriter := io.Discard
+	if req.Audit != nil {
+		auditWriter = req.Audit
+	}
+
+	proxyInst, err := egressproxy.StartForDirective(
+		proxySocketDir, req.DirectiveID, req.NetCapability, auditWriter, req.Egress,
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("start egress proxy: %w", err)
+	}
+	// Error returns leave cleanup nil; release is what to undo meanwhile.
+	release := proxyInst.Stop
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	// 2. Prepare git clone args if needed.
+	var wrapperCfg WrapperConfig
+	wrapperCfg.SocatPath = d.cfg.SocatPath
+	wrapperCfg.DNS = proxyInst.DNSSocketPath() != ""
+	wrapperCfg.UserCommand = req.Command
+	wrapperCfg.SessionAgent = sessionSocket != ""
+	wrapperCfg.Shell = req.Shell
+	wrapperCfg.Env = req.Env
+	wrapperCfg.SecretEnv = req.Secrets.EnvNames()
+	wrapperCfg.SecretFiles = req.Secrets.FilesDir() != ""
+
+	resolvedCwd, err := resolveCwd(req.Cwd)
+	if err != nil {
+		return nil, nil, err
 	}
-	stream string
+	wrapperCfg.Cwd = resolvedCwd
+
+	// Landlock: the shim (nexusd itself) is bind-mounted into the sandbox and
+	// wraps only the user command, after facility preparation.
+	// Fail-closed: never run unconfined when enforcement was requested.
+	var landlockShimPath string
+	if landlock.Required(req.FsCapability) {
+		if landlock.ABIVersion() == 0 {
+			return nil, nil, errors.New("fs capability requires Landlock but the kernel does not support it")
+		}
+		if err := landlock.Check(req.FsCapability); err != nil {
+			return nil, nil, err
+		}
+		self, err := os.Executable()
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolve landlock shim: %w", err)
+		}
+		landlockShimPath = self
+		if sessionSocket == "" {
+			spec := SandboxLandlockSpec(landlock.FromFsCapability(req.FsCapability, req.FacilityPath).Spec(), req.FacilityPath)
+			wrapperCfg.Landlock = &spec
+		}
+	}
+	var sessionAgentPath string
+	if sessionSocket != "" {
+		if sessionAgentPath, err = os.Executable(); err != nil {
+			return nil, nil, fmt.Errorf("resolve session agent: %w", err)
+		}
+	}
+
+	if req.RepoURL != "" {
+		cloneArgs, cloneEnv, cloneErr := sandbox.PrepareGitCloneArgs(req.RepoURL)
+		if cloneErr != nil {
+			return nil, nil, fmt.Errorf("prepare git clone: %w", cloneErr)
+		}
+		wrapperCfg.RepoURL = req.RepoURL
+		wrapperCfg.GitCloneArgs = cloneArgs
+		wrapperCfg.GitCloneEnv = cloneEnv
+	}
+
+	// 3. Generate the wrapper script.
+	wrapperScript, err := GenerateWrapper(wrapperCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate wrapper: %w", err)
+	}
+
+	// Write the wrapper to a temp file.
+	wrapperFile, err := os.CreateTemp("", "nexus-wrapper-*.sh")
+	if err != nil {
+		return nil, nil, fmt.Errorf("create wrapper file: %w", err)
+	}
+	stopProxy := release
+	release = func() {
+		stopProxy()
+		_ = os.Remove(wrapperFile.Name())
+	}
+
+	if _, err := wrapperFile.WriteString(wrapperScript); err != nil {
+		wrapperFile.Close()
+		return nil, nil, fmt.Errorf("write wrapper: %w", err)
+	}
+	if err := wrapperFile.Close(); err != nil {
+		return nil, nil, fmt.Errorf("close wrapper: %w", err)
+	}
+
+	// 4. Build bwrap command.
+	bwrapArgs, err = BuildArgs(CmdConfig{
+		BwrapPath:         d.cfg.BwrapPath,
+		RootfsPath:        d.cfg.RootfsPath,
+		FacilityPath:      req.FacilityPath,
+		ProxySocketPath:   proxyInst.SocketPath(),
+		DNSSocketPath:     proxyInst.DNSSocketPath(),
+		WrapperScriptPath: wrapperFile.Name(),
+		Cwd:               req.Cwd,
+		LandlockShimPath:  landlockShimPath,
+		SecretsFilesDir:   req.Secrets.FilesDir(),
+		SecretsEnvDir:     req.Secrets.EnvDir(),
+		HostHasLib64:      hostHasLib64(),
+		SessionAgentPath:  sessionAgentPath,
+		SessionSocketPath: sessionSocket,
+		Terminal:          req.Terminal != nil && sessionSocket == "",
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("build bwrap args: %w", err)
+	}
+	return bwrapArgs, release, nil
 }
 
-func (w logSinkWriter) Write(p []byte) (int, error) {
-	w.uploader.UploadBytes(w.ctx, w.stream, p)
-	return len(p), nil
+func (d *Driver) proxySocketDir(req sandbox.RunRequest) string {
+	if d.cfg.ProxySocketDir != "" {
+		return d.cfg.ProxySocketDir
+	}
+	return filepath.Join(filepath.Dir(req.FacilityPath), ".proxy-sockets")
 }
 
 func minimalExecEnv() []string {
@@ -8,12 +8,15 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"cybros.ai/nexus/config"
 	"cybros.ai/nexus/protocol"
 	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/landlock"
 )
 
 // testLogSink implements sandbox.LogSink for testing.
@@ -165,6 +168,64 @@ func TestDriver_Run_WorkspaceWritable(t *testing.T) {
 	_ = result
 }
 
+func TestDriver_Run_LandlockDevicesAndTmp(t *testing.T) {
+	skipIfNoBwrap(t)
+	skipIfNoSocat(t)
+	if landlock.ABIVersion() == 0 {
+		t.Skip("Landlock not available on this kernel")
+	}
+
+	facilityDir := t.TempDir()
+	drv := New(config.BwrapConfig{BwrapPath: "bwrap", SocatPath: "socat"})
+	sink := &testLogSink{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := drv.Run(ctx, sandbox.RunRequest{
+		DirectiveID:   "test-landlock-dev",
+		Command:       "echo x >/dev/null && touch /tmp/x",
+		LogSink:       sink,
+		FacilityPath:  facilityDir,
+		NetCapability: &protocol.NetCapabilityV1{Mode: "none"},
+		FsCapability:  &protocol.FsCapabilityV1{WritableRoots: []string{facilityDir}},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if result.Status != "succeeded" {
+		t.Errorf("status = %q, want succeeded; stderr: %s", result.Status, sink.stderr.String())
+	}
+}
+
+func TestPrepare_LandlockNestedReadOnlyRefused(t *testing.T) {
+	if landlock.ABIVersion() == 0 {
+		t.Skip("Landlock not available on this kernel")
+	}
+
+	facilityDir := filepath.Join(t.TempDir(), "f1")
+	// A short socket dir: t.TempDir paths can exceed the socket path limit.
+	socketDir, err := os.MkdirTemp("", "nx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(socketDir)
+	drv := New(config.BwrapConfig{BwrapPath: "bwrap", SocatPath: "socat", ProxySocketDir: socketDir})
+	_, _, err = drv.prepare(sandbox.RunRequest{
+		DirectiveID:   "test-nested",
+		Command:       "touch /workspace/.git/x",
+		FacilityPath:  facilityDir,
+		NetCapability: &protocol.NetCapabilityV1{Mode: "none"},
+		FsCapability: &protocol.FsCapabilityV1{
+			WritableRoots:    []string{facilityDir},
+			ReadOnlySubpaths: []string{filepath.Join(facilityDir, ".git")},
+		},
+	}, "")
+	if err == nil || !strings.Contains(err.Error(), "cannot be enforced") {
+		t.Fatalf("prepare = %v, want the nested read-only subpath refused", err)
+	}
+}
+
 func TestDriver_Run_ReadOnlyRoot(t *testing.T) {
 	skipIfNoBwrap(t)
 	skipIfNoSocat(t)
@@ -281,3 +342,51 @@ func findStr(s, sub string) bool {
 	}
 	return false
 }
+
+func TestDriver_Session(t *testing.T) {
+	skipIfNoBwrap(t)
+	skipIfNoSocat(t)
+
+	facilityDir := t.TempDir()
+	drv := New(config.BwrapConfig{BwrapPath: "bwrap", SocatPath: "socat"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sess, err := drv.OpenSession(ctx, sandbox.RunRequest{
+		DirectiveID:   "test-session",
+		LogSink:       &testLogSink{},
+		FacilityPath:  facilityDir,
+		NetCapability: &protocol.NetCapabilityV1{Mode: "none"},
+	})
+	if err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	defer sess.Close()
+
+	sink := &testLogSink{}
+	res, err := sess.Exec(ctx, sandbox.RunRequest{
+		Command: "echo kept > /tmp/state; sleep 60 >/dev/null 2>&1 & echo $! > /tmp/bg.pid",
+		LogSink: sink,
+	})
+	if err != nil || res.Status != "succeeded" {
+		t.Fatalf("first exec = %+v, %v; stderr: %s", res, err, sink.stderr.String())
+	}
+
+	// The sandbox's own /tmp and background processes survive between execs.
+	sink = &testLogSink{}
+	res, err = sess.Exec(ctx, sandbox.RunRequest{
+		Command: `cat /tmp/state; kill -0 "$(cat /tmp/bg.pid)" && echo alive; pwd; exit 4`,
+		Cwd:     "/workspace",
+		LogSink: sink,
+	})
+	if err != nil {
+		t.Fatalf("second exec: %v", err)
+	}
+	if res.Status != "failed" || res.ExitCode != 4 {
+		t.Errorf("second exec = %+v, want failed with exit code 4", res)
+	}
+	if got := sink.stdout.String(); got != "kept\nalive\n/workspace\n" {
+		t.Errorf("second exec stdout = %q; stderr: %s", got, sink.stderr.String())
+	}
+}
@@ -27,6 +27,10 @@ type CmdConfig struct {
 	// Bind-mounted read-only at /run/egress-proxy.sock.
 	ProxySocketPath string
 
+	// DNSSocketPath is the host-side path to the directive's DNS resolver
+	// UDS. Bind-mounted read-only at /run/nexus-dns.sock. Empty means no DNS.
+	DNSSocketPath string
+
 	// WrapperScriptPath is the host-side path to the wrapper script.
 	// Bind-mounted read-only at /run/wrapper.sh.
 	WrapperScriptPath string
@@ -34,6 +38,34 @@ type CmdConfig struct {
 	// Cwd is the working directory inside the sandbox. Default: /workspace.
 	Cwd string
 
+	// LandlockShimPath is the host-side path to the Landlock shim binary
+	// (normally the nexusd executable). Empty means no Landlock enforcement.
+	// Bind-mounted read-only at /run/nexus-landlock-shim.
+	LandlockShimPath string
+
+	// SecretsFilesDir is the host tmpfs directory holding file secrets.
+	// Bind-mounted read-only at /run/secrets. Empty means none.
+	SecretsFilesDir string
+
+	// SecretsEnvDir is the host tmpfs directory holding env secret values,
+	// one file per variable, read by the wrapper script. Bind-mounted
+	// read-only at /run/nexus-secret-env. Empty means none.
+	SecretsEnvDir string
+
+	// SessionAgentPath is the host-side path to the session agent binary
+	// (normally the nexusd executable), bind-mounted read-only at
+	// /run/nexus-agent. Empty unless the sandbox hosts a session.
+	SessionAgentPath string
+
+	// SessionSocketPath is the host-side UDS the session agent connects to,
+	// bind-mounted read-only at /run/nexus-session.sock.
+	SessionSocketPath string
+
+	// Terminal indicates the command runs on a pseudo-terminal private to
+	// the directive, which it keeps as its controlling terminal (so ^C and
+	// job control work) instead of being moved to a new session.
+	Terminal bool
+
 	// HostHasLib64 indicates whether the host has /lib64 (x86_64 systems).
 	// When true, a /lib64 -> usr/lib64 symlink is created in the sandbox.
 	HostHasLib64 bool
@@ -41,9 +73,19 @@ type CmdConfig struct {
 
 const (
 	sandboxWorkspace = "/workspace"
+	sandboxTmp       = "/tmp"
 	sandboxProxySock = "/run/egress-proxy.sock"
+	sandboxDNSSock   = "/run/nexus-dns.sock"
 	sandboxWrapperSh = "/run/wrapper.sh"
 	sandboxProxyPort = 9080
+
+	sandboxLandlockShim = "/run/nexus-landlock-shim"
+
+	sandboxSessionAgent = "/run/nexus-agent"
+	sandboxSessionSock  = "/run/nexus-session.sock"
+
+	sandboxSecrets   = "/run/secrets"
+	sandboxSecretEnv = "/run/nexus-secret-env"
 )
 
 // SandboxWorkspace returns the sandbox-internal workspace path.
@@ -93,7 +135,7 @@ func BuildArgs(cfg CmdConfig) ([]string, error) {
 	// Virtual filesystems
 	args = append(args, "--proc", "/proc")
 	args = append(args, "--dev", "/dev")
-	args = append(args, "--tmpfs", "/tmp")
+	args = append(args, "--tmpfs", sandboxTmp)
 
 	// Writable /run for proxy socket and wrapper script
 	args = append(args, "--tmpfs", "/run")
@@ -104,9 +146,35 @@ func BuildArgs(cfg CmdConfig) ([]string, error) {
 	// Proxy socket (read-only inside sandbox)
 	args = append(args, "--ro-bind", cfg.ProxySocketPath, sandboxProxySock)
 
+	// DNS resolver socket (read-only inside sandbox)
+	if cfg.DNSSocketPath != "" {
+		args = append(args, "--ro-bind", cfg.DNSSocketPath, sandboxDNSSock)
+	}
+
 	// Wrapper script (read-only inside sandbox)
 	args = append(args, "--ro-bind", cfg.WrapperScriptPath, sandboxWrapperSh)
 
+	// Landlock shim binary (read-only inside sandbox)
+	if cfg.LandlockShimPath != "" {
+		args = append(args, "--ro-bind", cfg.LandlockShimPath, sandboxLandlockShim)
+	}
+
+	// Session agent and the socket it connects to (read-only inside sandbox)
+	if cfg.SessionAgentPath != "" {
+		args = append(args, "--ro-bind", cfg.SessionAgentPath, sandboxSessionAgent)
+	}
+	if cfg.SessionSocketPath != "" {
+		args = append(args, "--ro-bind", cfg.SessionSocketPath, sandboxSessionSock)
+	}
+
+	// Secrets (read-only inside sandbox; tmpfs-backed on the host)
+	if cfg.SecretsFilesDir != "" {
+		args = append(args, "--ro-bind", cfg.SecretsFilesDir, sandboxSecrets)
+	}
+	if cfg.SecretsEnvDir != "" {
+		args = append(args, "--ro-bind", cfg.SecretsEnvDir, sandboxSecretEnv)
+	}
+
 	// Lock down the root filesystem after all mounts are set up.
 	// This makes the tmpfs root read-only while preserving writable
 	// submounts (/workspace, /tmp, /run).
@@ -120,12 +188,21 @@ func BuildArgs(cfg CmdConfig) ([]string, error) {
 		"--unshare-ipc",
 	)
 
-	// Security hardening
+	// Security hardening. --new-session keeps the command from injecting
+	// input into a terminal it shares with us (TIOCSTI); an interactive
+	// directive's terminal is its own, so there is nothing to protect.
+	if !cfg.Terminal {
+		args = append(args, "--new-session")
+	}
 	args = append(args,
-		"--new-session",
 		"--die-with-parent",
 		"--cap-drop", "ALL",
 	)
+	// The wrapper's DNS bridge listens on 127.0.0.1:53 of the sandbox's
+	// private network namespace.
+	if cfg.DNSSocketPath != "" {
+		args = append(args, "--cap-add", "CAP_NET_BIND_SERVICE")
+	}
 
 	// Working directory
 	args = append(args, "--chdir", sandboxWorkspace)
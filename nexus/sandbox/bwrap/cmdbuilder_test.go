@@ -271,6 +271,81 @@ func TestBuildArgs_CwdPathTraversal(t *testing.T) {
 	}
 }
 
+func TestBuildArgs_LandlockShim(t *testing.T) {
+	base := CmdConfig{
+		BwrapPath:         "/usr/bin/bwrap",
+		FacilityPath:      "/data/facilities/abc",
+		ProxySocketPath:   "/tmp/proxy.sock",
+		WrapperScriptPath: "/tmp/wrapper.sh",
+	}
+
+	args, err := BuildArgs(base)
+	if err != nil {
+		t.Fatalf("BuildArgs: %v", err)
+	}
+	assertNotContains(t, args, "/run/nexus-landlock-shim")
+
+	withShim := base
+	withShim.LandlockShimPath = "/usr/local/bin/nexusd"
+	args, err = BuildArgs(withShim)
+	if err != nil {
+		t.Fatalf("BuildArgs: %v", err)
+	}
+	assertContainsSequence(t, args, "--ro-bind", "/usr/local/bin/nexusd", "/run/nexus-landlock-shim")
+}
+
+func TestBuildArgs_Session(t *testing.T) {
+	base := CmdConfig{
+		BwrapPath:         "/usr/bin/bwrap",
+		FacilityPath:      "/data/facilities/abc",
+		ProxySocketPath:   "/tmp/proxy.sock",
+		WrapperScriptPath: "/tmp/wrapper.sh",
+	}
+
+	args, err := BuildArgs(base)
+	if err != nil {
+		t.Fatalf("BuildArgs: %v", err)
+	}
+	assertNotContains(t, args, "/run/nexus-agent")
+	assertNotContains(t, args, "/run/nexus-session.sock")
+
+	withSession := base
+	withSession.SessionAgentPath = "/usr/local/bin/nexusd"
+	withSession.SessionSocketPath = "/tmp/nexus-session-1/agent.sock"
+	args, err = BuildArgs(withSession)
+	if err != nil {
+		t.Fatalf("BuildArgs: %v", err)
+	}
+	assertContainsSequence(t, args, "--ro-bind", "/usr/local/bin/nexusd", "/run/nexus-agent")
+	assertContainsSequence(t, args, "--ro-bind", "/tmp/nexus-session-1/agent.sock", "/run/nexus-session.sock")
+}
+
+func TestBuildArgs_DNSSocket(t *testing.T) {
+	base := CmdConfig{
+		BwrapPath:         "/usr/bin/bwrap",
+		FacilityPath:      "/data/facilities/abc",
+		ProxySocketPath:   "/tmp/proxy.sock",
+		WrapperScriptPath: "/tmp/wrapper.sh",
+	}
+
+	args, err := BuildArgs(base)
+	if err != nil {
+		t.Fatalf("BuildArgs: %v", err)
+	}
+	assertNotContains(t, args, "/run/nexus-dns.sock")
+	assertNotContains(t, args, "--cap-add")
+
+	withDNS := base
+	withDNS.DNSSocketPath = "/tmp/proxy.dns.sock"
+	args, err = BuildArgs(withDNS)
+	if err != nil {
+		t.Fatalf("BuildArgs: %v", err)
+	}
+	assertContainsSequence(t, args, "--ro-bind", "/tmp/proxy.dns.sock", "/run/nexus-dns.sock")
+	// Only the port-53 bind capability comes back after dropping all.
+	assertContainsSequence(t, args, "--cap-drop", "ALL", "--cap-add", "CAP_NET_BIND_SERVICE")
+}
+
 func TestSandboxConstants(t *testing.T) {
 	if SandboxWorkspace() != "/workspace" {
 		t.Error("workspace constant changed")
@@ -340,3 +415,36 @@ func assertNotContainsSequence(t *testing.T, args []string, seq ...string) {
 		}
 	}
 }
+
+func TestBuildArgs_Secrets(t *testing.T) {
+	args, err := BuildArgs(CmdConfig{
+		BwrapPath:         "/usr/bin/bwrap",
+		FacilityPath:      "/data/facilities/abc",
+		ProxySocketPath:   "/tmp/proxy.sock",
+		WrapperScriptPath: "/tmp/wrapper.sh",
+		SecretsFilesDir:   "/dev/shm/nexus-secrets-d1/files",
+		SecretsEnvDir:     "/dev/shm/nexus-secrets-d1/env",
+	})
+	if err != nil {
+		t.Fatalf("BuildArgs: %v", err)
+	}
+	assertContainsSequence(t, args, "--ro-bind", "/dev/shm/nexus-secrets-d1/files", "/run/secrets")
+	assertContainsSequence(t, args, "--ro-bind", "/dev/shm/nexus-secrets-d1/env", "/run/nexus-secret-env")
+}
+
+func TestBuildArgs_Terminal(t *testing.T) {
+	args, err := BuildArgs(CmdConfig{
+		BwrapPath:         "/usr/bin/bwrap",
+		FacilityPath:      "/data/facilities/abc",
+		ProxySocketPath:   "/tmp/proxy.sock",
+		WrapperScriptPath: "/tmp/wrapper.sh",
+		Terminal:          true,
+	})
+	if err != nil {
+		t.Fatalf("BuildArgs: %v", err)
+	}
+	// The command keeps the directive's terminal as controlling terminal.
+	assertNotContains(t, args, "--new-session")
+	assertContains(t, args, "--die-with-parent")
+	assertContainsSequence(t, args, "--cap-drop", "ALL")
+}
@@ -0,0 +1,18 @@
+package bwrap
+
+import (
+	"os"
+	"testing"
+
+	"cybros.ai/nexus/sandbox/landlock"
+	"cybros.ai/nexus/sandbox/sessionagent"
+)
+
+// TestMain lets the test binary double as the Landlock shim and the
+// session agent (bind-mounted into the sandbox), mirroring what nexusd
+// does in cli.Run.
+func TestMain(m *testing.M) {
+	landlock.RunShimIfRequested()
+	sessionagent.RunIfRequested()
+	os.Exit(m.Run())
+}
@@ -0,0 +1,50 @@
+package bwrap
+
+import (
+	"fmt"
+	"sort"
+
+	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/landlock"
+	"cybros.ai/nexus/sandbox/sessionagent"
+)
+
+// sessionCommand maps a session exec to what the session agent runs inside
+// the sandbox: the shell command in its resolved cwd, with the request's
+// env on top of the environment the wrapper exported, through the Landlock
+// shim when the fs capability requires it (as the wrapper does in Run).
+func sessionCommand(req sandbox.RunRequest) (sessionagent.Request, error) {
+	cwd, err := resolveCwd(req.Cwd)
+	if err != nil {
+		return sessionagent.Request{}, err
+	}
+	shell := req.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	argv := []string{shell, "-c", req.Command}
+
+	keys := make([]string, 0, len(req.Env))
+	for k := range req.Env {
+		if !validEnvKeyRe.MatchString(k) {
+			return sessionagent.Request{}, fmt.Errorf("invalid env key: %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		env = append(env, k+"="+req.Env[k])
+	}
+
+	if landlock.Required(req.FsCapability) {
+		spec := SandboxLandlockSpec(landlock.FromFsCapability(req.FsCapability, req.FacilityPath).Spec(), req.FacilityPath)
+		var landlockEnv string
+		argv, landlockEnv, err = landlock.ShimCommand(sandboxLandlockShim, spec, argv)
+		if err != nil {
+			return sessionagent.Request{}, err
+		}
+		env = append(env, landlockEnv)
+	}
+	return sessionagent.Request{Argv: argv, Dir: cwd, Env: env}, nil
+}
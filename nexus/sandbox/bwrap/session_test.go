@@ -0,0 +1,56 @@
+package bwrap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+func TestSessionCommand(t *testing.T) {
+	req, err := sessionCommand(sandbox.RunRequest{
+		Command: "make test",
+		Cwd:     "sub",
+		Env:     map[string]string{"B": "2", "A": "1"},
+	})
+	if err != nil {
+		t.Fatalf("sessionCommand: %v", err)
+	}
+	if want := []string{"/bin/sh", "-c", "make test"}; !slices.Equal(req.Argv, want) {
+		t.Errorf("argv = %q, want %q", req.Argv, want)
+	}
+	if req.Dir != "/workspace/sub" {
+		t.Errorf("dir = %q", req.Dir)
+	}
+	if want := []string{"A=1", "B=2"}; !slices.Equal(req.Env, want) {
+		t.Errorf("env = %q, want %q", req.Env, want)
+	}
+}
+
+func TestSessionCommand_Landlock(t *testing.T) {
+	req, err := sessionCommand(sandbox.RunRequest{
+		Command:      "ls",
+		FacilityPath: "/data/facilities/abc",
+		FsCapability: &protocol.FsCapabilityV1{WritableRoots: []string{"/data/facilities/abc/out"}},
+	})
+	if err != nil {
+		t.Fatalf("sessionCommand: %v", err)
+	}
+	if want := []string{"/run/nexus-landlock-shim", "__nexus_landlock_shim", "--", "/bin/sh", "-c", "ls"}; !slices.Equal(req.Argv, want) {
+		t.Errorf("argv = %q, want %q", req.Argv, want)
+	}
+	if len(req.Env) != 1 || !strings.HasPrefix(req.Env[0], "NEXUS_LANDLOCK_SPEC=") || !strings.Contains(req.Env[0], "/workspace/out") {
+		t.Errorf("env = %q, want the sandbox-internal Landlock spec", req.Env)
+	}
+}
+
+func TestSessionCommand_Errors(t *testing.T) {
+	if _, err := sessionCommand(sandbox.RunRequest{Command: "ls", Cwd: "../etc"}); err == nil {
+		t.Error("expected an error for a cwd outside the workspace")
+	}
+	if _, err := sessionCommand(sandbox.RunRequest{Command: "ls", Env: map[string]string{"BAD;KEY": "x"}}); err == nil {
+		t.Error("expected an error for an invalid env key")
+	}
+}
@@ -1,9 +1,14 @@
 package bwrap
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
+
+	"cybros.ai/nexus/sandbox/landlock"
+	"cybros.ai/nexus/sandbox/sessionagent"
 )
 
 // validEnvKeyRe matches safe POSIX environment variable names.
@@ -18,6 +23,10 @@ type WrapperConfig struct {
 	// ProxyPort is the local TCP port for the socat bridge. Default: 9080.
 	ProxyPort int
 
+	// DNS bridges 127.0.0.1:53 to the resolver socket at /run/nexus-dns.sock
+	// and points resolv.conf at it (see CmdConfig.DNSSocketPath).
+	DNS bool
+
 	// Cwd is the working directory inside the sandbox after prepare. Default: /workspace.
 	// Must be an absolute path under /workspace (validated by the caller).
 	Cwd string
@@ -39,16 +48,34 @@ type WrapperConfig struct {
 
 	// Env is additional environment variables to export (key=value pairs).
 	Env map[string]string
+
+	// SecretEnv lists env secrets to export. Values are read from files under
+	// /run/nexus-secret-env so they never appear in the script itself.
+	SecretEnv []string
+
+	// SecretFiles exports CYBROS_SECRETS_DIR=/run/secrets.
+	SecretFiles bool
+
+	// Landlock, when set, runs the user command through the Landlock shim
+	// bind-mounted at /run/nexus-landlock-shim. Paths are sandbox-internal
+	// (see SandboxLandlockSpec).
+	Landlock *landlock.ShimSpec
+
+	// SessionAgent runs the session agent bind-mounted at /run/nexus-agent
+	// instead of a user command: it connects to /run/nexus-session.sock and
+	// runs the session's commands in the environment set up here.
+	SessionAgent bool
 }
 
 // GenerateWrapper produces a shell script that:
-//  1. Starts socat to bridge the proxy UDS to a local TCP port.
+//  1. Starts socat to bridge the proxy UDS to a local TCP port (and the DNS
+//     resolver UDS to 127.0.0.1:53).
 //  2. Exports HTTP_PROXY/HTTPS_PROXY pointing at the socat bridge.
 //  3. Optionally runs git clone for facility preparation.
-//  4. Runs the user command.
+//  4. Runs the user command (or the session agent).
 //  5. Captures the exit code and cleans up.
 func GenerateWrapper(cfg WrapperConfig) (string, error) {
-	if cfg.UserCommand == "" {
+	if cfg.UserCommand == "" && !cfg.SessionAgent {
 		return "", fmt.Errorf("user command is required")
 	}
 
@@ -92,8 +119,27 @@ func GenerateWrapper(cfg WrapperConfig) (string, error) {
 	)
 	b.WriteString("SOCAT_PID=$!\n")
 
+	// DNS bridge: one query per socket connection. /etc is the host's, so
+	// resolv.conf is only redirected when it points into the sandbox's /run
+	// (the systemd-resolved stub on Ubuntu).
+	if cfg.DNS {
+		fmt.Fprintf(&b,
+			"%s UDP4-RECVFROM:53,bind=127.0.0.1,fork UNIX-CONNECT:%s &\n",
+			socatPath, sandboxDNSSock,
+		)
+		b.WriteString("DNS_PID=$!\n")
+		b.WriteString("RESOLV_CONF=$(readlink -m /etc/resolv.conf)\n")
+		b.WriteString("case \"$RESOLV_CONF\" in\n")
+		b.WriteString("/run/*) mkdir -p \"${RESOLV_CONF%/*}\" && echo 'nameserver 127.0.0.1' > \"$RESOLV_CONF\" || true ;;\n")
+		b.WriteString("esac\n")
+	}
+
 	// Trap for cleanup
-	b.WriteString("cleanup() { kill \"$SOCAT_PID\" 2>/dev/null || true; }\n")
+	if cfg.DNS {
+		b.WriteString("cleanup() { kill \"$SOCAT_PID\" \"$DNS_PID\" 2>/dev/null || true; }\n")
+	} else {
+		b.WriteString("cleanup() { kill \"$SOCAT_PID\" 2>/dev/null || true; }\n")
+	}
 	b.WriteString("trap cleanup EXIT\n\n")
 
 	// Wait briefly for socat to start listening
@@ -118,6 +164,18 @@ func GenerateWrapper(cfg WrapperConfig) (string, error) {
 		}
 		fmt.Fprintf(&b, "export %s=%s\n", k, shellQuote(v))
 	}
+	// Secret env: the trailing "x" keeps command substitution from
+	// stripping trailing newlines of the value.
+	for _, k := range cfg.SecretEnv {
+		if !validEnvKeyRe.MatchString(k) {
+			return "", fmt.Errorf("invalid secret env key: %q", k)
+		}
+		fmt.Fprintf(&b, "%s=$(cat %s && printf x); %s=${%s%%x}; export %s\n",
+			k, shellQuote(sandboxSecretEnv+"/"+k), k, k, k)
+	}
+	if cfg.SecretFiles {
+		fmt.Fprintf(&b, "export CYBROS_SECRETS_DIR=%s\n", sandboxSecrets)
+	}
 	b.WriteString("\n")
 
 	// Optional git clone
@@ -144,12 +202,28 @@ func GenerateWrapper(cfg WrapperConfig) (string, error) {
 		b.WriteString("fi\n\n")
 	}
 
+	if cfg.SessionAgent {
+		// Each command chooses its own cwd; the session ends with the agent.
+		b.WriteString("set +e\n")
+		fmt.Fprintf(&b, "%s %s %s\n", sandboxSessionAgent, sessionagent.Arg, sandboxSessionSock)
+		b.WriteString("exit $?\n")
+		return b.String(), nil
+	}
+
 	if cfg.Cwd != "" && cfg.Cwd != sandboxWorkspace {
 		fmt.Fprintf(&b, "cd %s\n\n", shellQuote(cfg.Cwd))
 	}
 
 	// Run user command (allow non-zero exit)
 	b.WriteString("set +e\n")
+	if cfg.Landlock != nil {
+		spec, err := json.Marshal(cfg.Landlock)
+		if err != nil {
+			return "", fmt.Errorf("encode landlock spec: %w", err)
+		}
+		fmt.Fprintf(&b, "NEXUS_LANDLOCK_SPEC=%s %s %s -- ",
+			shellQuote(string(spec)), sandboxLandlockShim, landlock.ShimArg)
+	}
 	fmt.Fprintf(&b, "%s -c %s\n", shell, shellQuote(cfg.UserCommand))
 	b.WriteString("EXIT_CODE=$?\n")
 	b.WriteString("set -e\n\n")
@@ -160,6 +234,37 @@ func GenerateWrapper(cfg WrapperConfig) (string, error) {
 	return b.String(), nil
 }
 
+// SandboxLandlockSpec rewrites a host-side Landlock spec into sandbox paths:
+// entries under facilityPath are mapped to /workspace, everything else is
+// passed through unchanged (system paths are mounted at the same location;
+// paths that do not exist inside the sandbox are dropped by the shim). The
+// sandbox's /tmp is a tmpfs of its own, so it is writable.
+func SandboxLandlockSpec(spec landlock.ShimSpec, facilityPath string) landlock.ShimSpec {
+	translate := func(paths []string) []string {
+		out := make([]string, 0, len(paths))
+		for _, p := range paths {
+			out = append(out, toSandboxPath(p, facilityPath))
+		}
+		return out
+	}
+	return landlock.ShimSpec{
+		Writable: append(translate(spec.Writable), sandboxTmp),
+		ReadOnly: translate(spec.ReadOnly),
+	}
+}
+
+func toSandboxPath(p, facilityPath string) string {
+	p = path.Clean(p)
+	root := path.Clean(facilityPath)
+	if p == root {
+		return sandboxWorkspace
+	}
+	if strings.HasPrefix(p, root+"/") {
+		return sandboxWorkspace + p[len(root):]
+	}
+	return p
+}
+
 // shellQuote wraps a string in single quotes, escaping internal single quotes.
 func shellQuote(s string) string {
 	return "'" + strings.ReplaceAll(s, "'", "'\"'\"'") + "'"
@@ -3,6 +3,8 @@ package bwrap
 import (
 	"strings"
 	"testing"
+
+	"cybros.ai/nexus/sandbox/landlock"
 )
 
 func TestGenerateWrapper_Basic(t *testing.T) {
@@ -327,3 +329,113 @@ func TestShellQuote(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateWrapper_DNS(t *testing.T) {
+	script, err := GenerateWrapper(WrapperConfig{UserCommand: "echo hello"})
+	if err != nil {
+		t.Fatalf("GenerateWrapper: %v", err)
+	}
+	if strings.Contains(script, "nexus-dns.sock") {
+		t.Error("DNS bridge started without DNS")
+	}
+
+	script, err = GenerateWrapper(WrapperConfig{UserCommand: "echo hello", DNS: true})
+	if err != nil {
+		t.Fatalf("GenerateWrapper: %v", err)
+	}
+	for _, want := range []string{
+		"socat UDP4-RECVFROM:53,bind=127.0.0.1,fork UNIX-CONNECT:/run/nexus-dns.sock &",
+		"RESOLV_CONF=$(readlink -m /etc/resolv.conf)",
+		"echo 'nameserver 127.0.0.1' > \"$RESOLV_CONF\"",
+		`kill "$SOCAT_PID" "$DNS_PID"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("missing %q in:\n%s", want, script)
+		}
+	}
+	// The bridge must be up before the user command runs.
+	if strings.Index(script, "UDP4-RECVFROM") > strings.Index(script, "echo hello") {
+		t.Error("DNS bridge starts after the user command")
+	}
+}
+
+func TestGenerateWrapper_Landlock(t *testing.T) {
+	script, err := GenerateWrapper(WrapperConfig{
+		UserCommand: "make test",
+		Landlock: &landlock.ShimSpec{
+			Writable: []string{"/workspace"},
+			ReadOnly: []string{"/usr"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("GenerateWrapper: %v", err)
+	}
+
+	want := `NEXUS_LANDLOCK_SPEC='{"writable":["/workspace"],"read_only":["/usr"]}' /run/nexus-landlock-shim __nexus_landlock_shim -- /bin/sh -c 'make test'`
+	if !strings.Contains(script, want) {
+		t.Errorf("missing landlock shim invocation %q in:\n%s", want, script)
+	}
+}
+
+func TestSandboxLandlockSpec(t *testing.T) {
+	spec := SandboxLandlockSpec(landlock.ShimSpec{
+		Writable: []string{"/srv/facilities/f1", "/srv/facilities/f1/build"},
+		ReadOnly: []string{"/srv/facilities/f1/.git", "/srv/facilities/f10", "/usr"},
+	}, "/srv/facilities/f1/")
+
+	wantWritable := []string{"/workspace", "/workspace/build", "/tmp"}
+	wantReadOnly := []string{"/workspace/.git", "/srv/facilities/f10", "/usr"}
+	if strings.Join(spec.Writable, ",") != strings.Join(wantWritable, ",") {
+		t.Errorf("writable = %v, want %v", spec.Writable, wantWritable)
+	}
+	if strings.Join(spec.ReadOnly, ",") != strings.Join(wantReadOnly, ",") {
+		t.Errorf("read_only = %v, want %v", spec.ReadOnly, wantReadOnly)
+	}
+}
+
+func TestGenerateWrapper_SecretEnv(t *testing.T) {
+	script, err := GenerateWrapper(WrapperConfig{
+		UserCommand: "env",
+		SecretEnv:   []string{"GITHUB_TOKEN"},
+		SecretFiles: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "GITHUB_TOKEN=$(cat '/run/nexus-secret-env/GITHUB_TOKEN' && printf x); GITHUB_TOKEN=${GITHUB_TOKEN%x}; export GITHUB_TOKEN\n"
+	if !strings.Contains(script, want) {
+		t.Errorf("secret env not read from file, got:\n%s", script)
+	}
+	if !strings.Contains(script, "export CYBROS_SECRETS_DIR=/run/secrets\n") {
+		t.Errorf("CYBROS_SECRETS_DIR not exported, got:\n%s", script)
+	}
+
+	if _, err := GenerateWrapper(WrapperConfig{UserCommand: "env", SecretEnv: []string{"BAD;KEY"}}); err == nil {
+		t.Error("expected error for invalid secret env key")
+	}
+}
+
+func TestGenerateWrapper_SessionAgent(t *testing.T) {
+	script, err := GenerateWrapper(WrapperConfig{
+		SessionAgent: true,
+		Cwd:          "/workspace/sub",
+		Env:          map[string]string{"FOO": "bar"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateWrapper: %v", err)
+	}
+	if !strings.Contains(script, "export FOO='bar'") {
+		t.Errorf("missing env export in:\n%s", script)
+	}
+	if !strings.HasSuffix(script, "/run/nexus-agent __nexus_session_agent /run/nexus-session.sock\nexit $?\n") {
+		t.Errorf("script does not end by running the session agent:\n%s", script)
+	}
+	// Commands pick their own cwd.
+	if strings.Contains(script, "cd '/workspace/sub'") {
+		t.Errorf("unexpected cd in:\n%s", script)
+	}
+
+	if _, err := GenerateWrapper(WrapperConfig{}); err == nil {
+		t.Error("expected an error without a user command or session agent")
+	}
+}
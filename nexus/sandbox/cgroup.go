@@ -0,0 +1,37 @@
+package sandbox
+
+import (
+	"context"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// CgroupApplier places a driver's process into a resource-limited cgroup on
+// behalf of a daemon that cannot write /sys/fs/cgroup itself (e.g. through
+// nexus-helper). The returned cleanup removes the cgroup.
+type CgroupApplier interface {
+	ApplyCgroup(ctx context.Context, directiveID string, pid int, limits protocol.Limits) (cleanup func(), err error)
+}
+
+// ApplyLimits applies req.Limits to pid through req.Cgroups when set, and
+// directly (ApplyCgroupLimits) otherwise. cleanup is never nil.
+func ApplyLimits(ctx context.Context, req RunRequest, pid int) (cleanup func(), err error) {
+	if req.Limits.CPU <= 0 && req.Limits.MemoryMB <= 0 {
+		return func() {}, nil
+	}
+	if req.Cgroups != nil {
+		cleanup, err := req.Cgroups.ApplyCgroup(ctx, req.DirectiveID, pid, req.Limits)
+		if err != nil {
+			return func() {}, err
+		}
+		if cleanup == nil {
+			cleanup = func() {}
+		}
+		return cleanup, nil
+	}
+	cg, err := ApplyCgroupLimits(req.DirectiveID, pid, req.Limits)
+	if err != nil {
+		return func() {}, err
+	}
+	return cg.Cleanup, nil
+}
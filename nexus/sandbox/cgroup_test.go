@@ -1,6 +1,7 @@
 package sandbox
 
 import (
+	"context"
 	"runtime"
 	"testing"
 
@@ -98,3 +99,40 @@ func TestApplyCgroupLimits_BoundsCheck(t *testing.T) {
 		})
 	}
 }
+
+type fakeCgroupApplier struct {
+	pid     int
+	limits  protocol.Limits
+	cleaned bool
+}
+
+func (f *fakeCgroupApplier) ApplyCgroup(_ context.Context, _ string, pid int, limits protocol.Limits) (func(), error) {
+	f.pid, f.limits = pid, limits
+	return func() { f.cleaned = true }, nil
+}
+
+func TestApplyLimits_UsesApplier(t *testing.T) {
+	t.Parallel()
+
+	f := &fakeCgroupApplier{}
+	req := RunRequest{DirectiveID: "d-1", Limits: protocol.Limits{MemoryMB: 64}, Cgroups: f}
+	cleanup, err := ApplyLimits(context.Background(), req, 4321)
+	if err != nil {
+		t.Fatalf("ApplyLimits: %v", err)
+	}
+	if f.pid != 4321 || f.limits.MemoryMB != 64 {
+		t.Fatalf("applier got pid=%d limits=%+v", f.pid, f.limits)
+	}
+	cleanup()
+	if !f.cleaned {
+		t.Fatal("expected cleanup to reach the applier")
+	}
+
+	// No limits: the applier is not consulted.
+	f2 := &fakeCgroupApplier{}
+	cleanup, err = ApplyLimits(context.Background(), RunRequest{DirectiveID: "d-2", Cgroups: f2}, 1)
+	if err != nil || f2.pid != 0 {
+		t.Fatalf("expected no-op, got err=%v pid=%d", err, f2.pid)
+	}
+	cleanup()
+}
@@ -8,6 +8,8 @@ import (
 	"path"
 	"regexp"
 	"strings"
+
+	"cybros.ai/nexus/sandbox/sessionagent"
 )
 
 // validEnvKeyRe matches safe POSIX environment variable names.
@@ -37,6 +39,15 @@ type CmdConfig struct {
 	// Env is additional environment variables.
 	Env map[string]string
 
+	// SecretEnv lists env secret names. They are passed as bare --env NAME so
+	// the runtime copies values from its own environment (see SecretEnviron);
+	// values never appear in the argument list.
+	SecretEnv []string
+
+	// SecretsFilesDir is the host tmpfs directory holding file secrets,
+	// mounted read-only at /run/secrets. Empty means none.
+	SecretsFilesDir string
+
 	// ProxyMode controls proxy injection: "env" or "none".
 	ProxyMode string
 
@@ -44,6 +55,24 @@ type CmdConfig struct {
 	// Only used when ProxyMode is "env".
 	ProxyURL string
 
+	// TTY runs the container interactively on a terminal (-i -t): the
+	// runtime client's own stdin must be the directive's pseudo-terminal,
+	// whose input and resizes it forwards.
+	TTY bool
+
+	// Name names the container (--name), so it can be removed by name.
+	Name string
+
+	// SessionAgentPath is the host-side path to the session agent binary
+	// (normally the nexusd executable, which must run in the image: a
+	// static build), mounted read-only at /run/nexus-agent. When set, the
+	// container runs the agent instead of Command.
+	SessionAgentPath string
+
+	// SessionSocketPath is the host-side UDS the session agent connects to,
+	// mounted at /run/nexus-session.sock.
+	SessionSocketPath string
+
 	// RepoURL triggers a git clone before the user command.
 	RepoURL string
 
@@ -53,6 +82,12 @@ type CmdConfig struct {
 	GitCloneEnv []string
 }
 
+const (
+	sandboxSecrets      = "/run/secrets"
+	sandboxSessionAgent = "/run/nexus-agent"
+	sandboxSessionSock  = "/run/nexus-session.sock"
+)
+
 // BuildArgs constructs the runtime run argument slice.
 func BuildArgs(cfg CmdConfig) ([]string, error) {
 	if cfg.Runtime == "" {
@@ -64,9 +99,12 @@ func BuildArgs(cfg CmdConfig) ([]string, error) {
 	if cfg.FacilityPath == "" {
 		return nil, fmt.Errorf("facility path is required")
 	}
-	if cfg.Command == "" {
+	if cfg.Command == "" && cfg.SessionAgentPath == "" {
 		return nil, fmt.Errorf("command is required")
 	}
+	if (cfg.SessionAgentPath == "") != (cfg.SessionSocketPath == "") {
+		return nil, fmt.Errorf("session agent and socket paths go together")
+	}
 
 	shell := cfg.Shell
 	if shell == "" {
@@ -79,6 +117,12 @@ func BuildArgs(cfg CmdConfig) ([]string, error) {
 	}
 
 	args := []string{cfg.Runtime, "run", "--rm"}
+	if cfg.Name != "" {
+		args = append(args, "--name", cfg.Name)
+	}
+	if cfg.TTY {
+		args = append(args, "--interactive", "--tty")
+	}
 
 	// Network: use host networking so the container can reach the proxy
 	args = append(args, "--network=host")
@@ -114,6 +158,23 @@ func BuildArgs(cfg CmdConfig) ([]string, error) {
 		args = append(args, "--env", k+"="+v)
 	}
 
+	for _, k := range cfg.SecretEnv {
+		if !validEnvKeyRe.MatchString(k) {
+			return nil, fmt.Errorf("invalid secret env key: %q", k)
+		}
+		args = append(args, "--env", k)
+	}
+	if cfg.SecretsFilesDir != "" {
+		args = append(args, "--volume", cfg.SecretsFilesDir+":"+sandboxSecrets+":ro")
+		args = append(args, "--env", "CYBROS_SECRETS_DIR="+sandboxSecrets)
+	}
+
+	// Session agent and the socket it connects to
+	if cfg.SessionAgentPath != "" {
+		args = append(args, "--volume", cfg.SessionAgentPath+":"+sandboxSessionAgent+":ro")
+		args = append(args, "--volume", cfg.SessionSocketPath+":"+sandboxSessionSock)
+	}
+
 	// Image
 	args = append(args, cfg.Image)
 
@@ -158,6 +219,12 @@ func buildInnerCommand(cfg CmdConfig, shell string, cwd string) string {
 		parts = append(parts, b.String())
 	}
 
+	// Session: the agent runs the commands, each in its own cwd.
+	if cfg.SessionAgentPath != "" {
+		parts = append(parts, "exec "+sandboxSessionAgent+" "+sessionagent.Arg+" "+sandboxSessionSock)
+		return strings.Join(parts, " && ")
+	}
+
 	if cwd != "" && cwd != "/workspace" {
 		parts = append(parts, "cd "+shellQuote(cwd))
 	}
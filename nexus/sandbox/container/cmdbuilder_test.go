@@ -319,3 +319,83 @@ func assertContainsSequence(t *testing.T, args []string, seq ...string) {
 	}
 	t.Errorf("args missing sequence %v\nfull args: %v", seq, args)
 }
+
+func TestBuildArgs_Secrets(t *testing.T) {
+	cfg := CmdConfig{
+		Runtime:         "podman",
+		Image:           "ubuntu:24.04",
+		FacilityPath:    "/data/fac",
+		Command:         "env",
+		SecretEnv:       []string{"GITHUB_TOKEN"},
+		SecretsFilesDir: "/dev/shm/nexus-secrets-d1/files",
+	}
+
+	args, err := BuildArgs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bare name: the runtime takes the value from its own environment.
+	assertContainsSequence(t, args, "--env", "GITHUB_TOKEN")
+	assertContainsSequence(t, args, "--volume", "/dev/shm/nexus-secrets-d1/files:/run/secrets:ro")
+	assertContainsSequence(t, args, "--env", "CYBROS_SECRETS_DIR=/run/secrets")
+
+	cfg.SecretEnv = []string{"BAD=KEY"}
+	if _, err := BuildArgs(cfg); err == nil {
+		t.Error("expected error for invalid secret env key")
+	}
+}
+
+func TestBuildArgs_TTY(t *testing.T) {
+	cfg := CmdConfig{
+		Runtime:      "podman",
+		Image:        "ubuntu:24.04",
+		FacilityPath: "/data/fac",
+		Command:      "python3",
+	}
+
+	args, err := BuildArgs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range args {
+		if a == "--interactive" || a == "--tty" {
+			t.Errorf("non-interactive run has %s", a)
+		}
+	}
+
+	cfg.TTY = true
+	args, err = BuildArgs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContainsSequence(t, args, "run", "--rm", "--interactive", "--tty")
+}
+
+func TestBuildArgs_Session(t *testing.T) {
+	cfg := CmdConfig{
+		Runtime:           "podman",
+		Image:             "ubuntu:24.04",
+		FacilityPath:      "/data/fac",
+		Cwd:               "sub",
+		Name:              "nexus-session-abc",
+		SessionAgentPath:  "/usr/local/bin/nexusd",
+		SessionSocketPath: "/tmp/nexus-session-1/agent.sock",
+	}
+
+	args, err := BuildArgs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContainsSequence(t, args, "run", "--rm", "--name", "nexus-session-abc")
+	assertContainsSequence(t, args, "--volume", "/usr/local/bin/nexusd:/run/nexus-agent:ro")
+	assertContainsSequence(t, args, "--volume", "/tmp/nexus-session-1/agent.sock:/run/nexus-session.sock")
+	if inner := args[len(args)-1]; inner != "exec /run/nexus-agent __nexus_session_agent /run/nexus-session.sock" {
+		t.Errorf("inner command = %q, want the session agent without cd", inner)
+	}
+
+	cfg.SessionSocketPath = ""
+	if _, err := BuildArgs(cfg); err == nil {
+		t.Error("expected error for a session agent without socket")
+	}
+}
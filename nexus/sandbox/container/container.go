@@ -4,9 +4,12 @@ package container
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"syscall"
 	"time"
@@ -70,6 +73,17 @@ type truncationReporter interface {
 	StderrTruncated() bool
 }
 
+// DeliversSecrets implements sandbox.SecretsDeliverer: env secrets are passed
+// through the runtime's environment and file secrets are mounted from tmpfs.
+func (d *Driver) DeliversSecrets() bool { return true }
+
+// InjectsCredentials implements sandbox.CredentialInjector when the
+// container reaches the network through the egress proxy (proxy_mode env).
+func (d *Driver) InjectsCredentials() bool { return d.cfg.ProxyMode == "env" }
+
+// RunsTerminal implements sandbox.TerminalRunner.
+func (d *Driver) RunsTerminal() bool { return true }
+
 // Run executes a command inside a rootless container with proxy env injection.
 func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunResult, error) {
 	if req.Command == "" {
@@ -82,57 +96,12 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		return sandbox.RunResult{}, errors.New("FacilityPath is required for container driver")
 	}
 
-	// 1. Start egress proxy if proxy mode is "env".
-	var proxyURL string
-	var proxyInst *egressproxy.Instance
-	if d.cfg.ProxyMode == "env" {
-		auditWriter := io.Discard
-		if u, ok := req.LogSink.(interface {
-			UploadBytes(context.Context, string, []byte)
-		}); ok {
-			auditWriter = logSinkWriter{ctx: ctx, uploader: u, stream: "stderr"}
-		}
-
-		var err error
-		proxyInst, err = egressproxy.StartForDirectiveTCP(
-			req.DirectiveID, req.NetCapability, auditWriter,
-		)
-		if err != nil {
-			return sandbox.RunResult{}, fmt.Errorf("start egress proxy: %w", err)
-		}
-		defer proxyInst.Stop()
-		proxyURL = proxyInst.ProxyURL()
-	}
-
-	// 2. Prepare git clone args if needed.
-	var cloneArgs []string
-	var cloneEnv []string
-	if req.RepoURL != "" {
-		var err error
-		cloneArgs, cloneEnv, err = sandbox.PrepareGitCloneArgs(req.RepoURL)
-		if err != nil {
-			return sandbox.RunResult{}, fmt.Errorf("prepare git clone: %w", err)
-		}
-	}
-
-	// 3. Build container run args.
-	cmdArgs, err := BuildArgs(CmdConfig{
-		Runtime:      d.cfg.Runtime,
-		Image:        d.cfg.Image,
-		FacilityPath: req.FacilityPath,
-		Command:      req.Command,
-		Shell:        req.Shell,
-		Cwd:          req.Cwd,
-		Env:          req.Env,
-		ProxyMode:    d.cfg.ProxyMode,
-		ProxyURL:     proxyURL,
-		RepoURL:      req.RepoURL,
-		GitCloneArgs: cloneArgs,
-		GitCloneEnv:  cloneEnv,
-	})
+	// 1-3. Egress proxy and container run arguments.
+	cmdArgs, cleanup, err := d.prepare(req, "", "")
 	if err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("build container args: %w", err)
+		return sandbox.RunResult{}, err
 	}
+	defer cleanup()
 
 	// 4. Execute the container.
 	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
@@ -145,26 +114,19 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		return cmd.Process.Kill()
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return sandbox.RunResult{}, err
-	}
-	stderr, err := cmd.StderrPipe()
+	cmd.Env = runtimeEnv(req)
+
+	cio, err := sandbox.AttachIO(cmd, req)
 	if err != nil {
 		return sandbox.RunResult{}, err
 	}
+	defer cio.Close()
 
 	if err := cmd.Start(); err != nil {
 		return sandbox.RunResult{}, fmt.Errorf("start container: %w", err)
 	}
 
-	// Stream logs concurrently.
-	errCh := make(chan error, 2)
-	go func() { errCh <- req.LogSink.Consume(ctx, "stdout", stdout) }()
-	go func() { errCh <- req.LogSink.Consume(ctx, "stderr", stderr) }()
-
-	consume1 := <-errCh
-	consume2 := <-errCh
+	consumeErr := cio.Stream(ctx, req.LogSink)
 
 	waitErr := cmd.Wait()
 
@@ -186,7 +148,6 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		result.Status = "canceled"
 	}
 
-	consumeErr := errors.Join(consume1, consume2)
 	if consumeErr != nil {
 		return result, consumeErr
 	}
@@ -194,17 +155,138 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	return result, nil
 }
 
-type logSinkWriter struct {
-	ctx      context.Context
-	uploader interface {
-		UploadBytes(context.Context, string, []byte)
+// OpenSession implements sandbox.SessionRunner: the container is set up as
+// in Run and then runs the session agent (the nexusd binary, mounted from
+// the host), which starts each command (sessionCommand). Closing the
+// session ends the agent and with it the container; a container that does
+// not stop is removed by name.
+func (d *Driver) OpenSession(ctx context.Context, req sandbox.RunRequest) (sandbox.Session, error) {
+	if req.FacilityPath == "" {
+		return nil, errors.New("FacilityPath is required for container driver")
+	}
+	var suffix [6]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return nil, err
+	}
+	name := "nexus-session-" + hex.EncodeToString(suffix[:])
+	runtime := d.cfg.Runtime
+	if runtime == "" {
+		runtime = "podman"
 	}
-	stream string
+
+	var cleanup func()
+	return sandbox.StartAgentSession(ctx, req, sandbox.SessionStart{
+		Cmd: func(socket string) (*exec.Cmd, error) {
+			cmdArgs, c, err := d.prepare(req, name, socket)
+			if err != nil {
+				return nil, err
+			}
+			cleanup = c
+			cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
+			cmd.Env = runtimeEnv(req)
+			return cmd, nil
+		},
+		Command: sessionCommand,
+		Kill: func() {
+			rmCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			_ = exec.CommandContext(rmCtx, runtime, "rm", "--force", name).Run()
+		},
+		Cleanup: func() {
+			if cleanup != nil {
+				cleanup()
+			}
+		},
+	})
+}
+
+// prepare starts the egress proxy for req (proxy mode env) and returns the
+// runtime's run arguments, plus the cleanup stopping the proxy. With a
+// sessionSocket the container, named name, runs the session agent
+// connecting to it instead of req.Command.
+func (d *Driver) prepare(req sandbox.RunRequest, name, sessionSocket string) ([]string, func(), error) {
+	var sessionAgentPath string
+	if sessionSocket != "" {
+		self, err := os.Executable()
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolve session agent: %w", err)
+		}
+		sessionAgentPath = self
+	}
+
+	// 1. Start egress proxy if proxy mode is "env".
+	cleanup := func() {}
+	var proxyURL string
+	var proxyInst *egressproxy.Instance
+	if d.cfg.ProxyMode == "env" {
+		auditWriter := io.Discard
+		if req.Audit != nil {
+			auditWriter = req.Audit
+		}
+
+		var err error
+		proxyInst, err = egressproxy.StartForDirectiveTCP(
+			req.DirectiveID, req.NetCapability, auditWriter, req.Egress,
+		)
+		if err != nil {
+			return nil, nil, fmt.Errorf("start egress proxy: %w", err)
+		}
+		cleanup = proxyInst.Stop
+		proxyURL = proxyInst.ProxyURL()
+	}
+
+	// 2. Prepare git clone args if needed.
+	var cloneArgs []string
+	var cloneEnv []string
+	if req.RepoURL != "" {
+		var err error
+		cloneArgs, cloneEnv, err = sandbox.PrepareGitCloneArgs(req.RepoURL)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("prepare git clone: %w", err)
+		}
+	}
+
+	// 3. Build container run args.
+	cmdArgs, err := BuildArgs(CmdConfig{
+		Runtime:           d.cfg.Runtime,
+		Image:             d.cfg.Image,
+		FacilityPath:      req.FacilityPath,
+		Command:           req.Command,
+		Shell:             req.Shell,
+		Cwd:               req.Cwd,
+		Env:               req.Env,
+		SecretEnv:         req.Secrets.EnvNames(),
+		SecretsFilesDir:   req.Secrets.FilesDir(),
+		ProxyMode:         d.cfg.ProxyMode,
+		ProxyURL:          proxyURL,
+		RepoURL:           req.RepoURL,
+		GitCloneArgs:      cloneArgs,
+		GitCloneEnv:       cloneEnv,
+		TTY:               req.Terminal != nil && sessionSocket == "",
+		Name:              name,
+		SessionAgentPath:  sessionAgentPath,
+		SessionSocketPath: sessionSocket,
+	})
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("build container args: %w", err)
+	}
+	return cmdArgs, cleanup, nil
 }
 
-func (w logSinkWriter) Write(p []byte) (int, error) {
-	w.uploader.UploadBytes(w.ctx, w.stream, p)
-	return len(p), nil
+// runtimeEnv is the runtime's environment: its own plus the env secret
+// values, which it resolves for bare --env NAME arguments. nil (inherit)
+// without secrets.
+func runtimeEnv(req sandbox.RunRequest) []string {
+	if len(req.Secrets.EnvNames()) == 0 {
+		return nil
+	}
+	env := os.Environ()
+	for _, k := range req.Secrets.EnvNames() {
+		env = append(env, k+"="+req.Secrets.Env[k])
+	}
+	return env
 }
 
 func statusFrom(waitErr error, ctx context.Context) string {
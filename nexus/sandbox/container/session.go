@@ -0,0 +1,37 @@
+package container
+
+import (
+	"fmt"
+	"sort"
+
+	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/sessionagent"
+)
+
+// sessionCommand maps a session exec to what the session agent runs inside
+// the container: the shell command in its resolved cwd, with the request's
+// env on top of the container's.
+func sessionCommand(req sandbox.RunRequest) (sessionagent.Request, error) {
+	cwd, err := resolveCwd(req.Cwd)
+	if err != nil {
+		return sessionagent.Request{}, err
+	}
+	shell := req.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	keys := make([]string, 0, len(req.Env))
+	for k := range req.Env {
+		if !validEnvKeyRe.MatchString(k) {
+			return sessionagent.Request{}, fmt.Errorf("invalid env key: %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+req.Env[k])
+	}
+	return sessionagent.Request{Argv: []string{shell, "-c", req.Command}, Dir: cwd, Env: env}, nil
+}
@@ -0,0 +1,36 @@
+package container
+
+import (
+	"slices"
+	"testing"
+
+	"cybros.ai/nexus/sandbox"
+)
+
+func TestSessionCommand(t *testing.T) {
+	req, err := sessionCommand(sandbox.RunRequest{
+		Command: "make test",
+		Shell:   "/bin/bash",
+		Cwd:     "/workspace/sub",
+		Env:     map[string]string{"B": "2", "A": "1"},
+	})
+	if err != nil {
+		t.Fatalf("sessionCommand: %v", err)
+	}
+	if want := []string{"/bin/bash", "-c", "make test"}; !slices.Equal(req.Argv, want) {
+		t.Errorf("argv = %q, want %q", req.Argv, want)
+	}
+	if req.Dir != "/workspace/sub" {
+		t.Errorf("dir = %q", req.Dir)
+	}
+	if want := []string{"A=1", "B=2"}; !slices.Equal(req.Env, want) {
+		t.Errorf("env = %q, want %q", req.Env, want)
+	}
+
+	if _, err := sessionCommand(sandbox.RunRequest{Command: "ls", Cwd: "/etc"}); err == nil {
+		t.Error("expected an error for a cwd outside the workspace")
+	}
+	if _, err := sessionCommand(sandbox.RunRequest{Command: "ls", Env: map[string]string{"1BAD": "x"}}); err == nil {
+		t.Error("expected an error for an invalid env key")
+	}
+}
@@ -27,6 +27,10 @@ func New() *Driver { return &Driver{} }
 // Name returns the driver identifier.
 func (d *Driver) Name() string { return "darwin-automation" }
 
+// DeliversSecrets implements sandbox.SecretsDeliverer (env secrets only;
+// macOS has no tmpfs for file secrets).
+func (d *Driver) DeliversSecrets() bool { return true }
+
 // HealthCheck reports the driver as healthy (no external dependencies required).
 // TCC permission status is included in Details for observability but does not
 // gate health — not all directives require TCC permissions.
@@ -96,6 +100,9 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	for k, v := range req.Env {
 		envMap[k] = v
 	}
+	for k, v := range req.Secrets.HostEnv() {
+		envMap[k] = v
+	}
 	env := make([]string, 0, len(envMap))
 	for k, v := range envMap {
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
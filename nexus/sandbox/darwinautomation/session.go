@@ -0,0 +1,58 @@
+package darwinautomation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/sessionagent"
+)
+
+// OpenSession implements sandbox.SessionRunner: the session agent runs on
+// the host in the facility and starts each command with the same shell and
+// environment as Run.
+func (d *Driver) OpenSession(ctx context.Context, req sandbox.RunRequest) (sandbox.Session, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("resolve session agent: %w", err)
+	}
+	return sandbox.StartAgentSession(ctx, req, sandbox.SessionStart{
+		Cmd: func(socket string) (*exec.Cmd, error) {
+			argv := sessionagent.Command(self, socket)
+			cmd := exec.Command(argv[0], argv[1:]...)
+			cmd.Dir = req.WorkDir
+			for k, v := range minimalDarwinEnv() {
+				cmd.Env = append(cmd.Env, k+"="+v)
+			}
+			return cmd, nil
+		},
+		Command:      sessionCommand,
+		CgroupLimits: true,
+	})
+}
+
+// sessionCommand mirrors Run's command setup for a session exec.
+func sessionCommand(req sandbox.RunRequest) (sessionagent.Request, error) {
+	shell := req.Shell
+	if shell == "" {
+		shell = "/bin/zsh"
+	}
+	cwd, err := sandbox.ResolveWorkspaceCwd(req.WorkDir, req.Cwd)
+	if err != nil {
+		return sessionagent.Request{}, err
+	}
+	envMap := minimalDarwinEnv()
+	for k, v := range req.Env {
+		envMap[k] = v
+	}
+	for k, v := range req.Secrets.HostEnv() {
+		envMap[k] = v
+	}
+	env := make([]string, 0, len(envMap))
+	for k, v := range envMap {
+		env = append(env, k+"="+v)
+	}
+	return sessionagent.Request{Argv: []string{shell, "-c", req.Command}, Dir: cwd, Env: env}, nil
+}
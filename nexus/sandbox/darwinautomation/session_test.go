@@ -0,0 +1,37 @@
+package darwinautomation
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"cybros.ai/nexus/sandbox"
+)
+
+func TestSessionCommand(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	req, err := sessionCommand(sandbox.RunRequest{
+		Command: "echo hi",
+		Cwd:     "sub",
+		WorkDir: workDir,
+		Env:     map[string]string{"FOO": "bar"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/zsh", "-c", "echo hi"}; !slices.Equal(req.Argv, want) {
+		t.Errorf("argv = %q, want %q", req.Argv, want)
+	}
+	if req.Dir != filepath.Join(workDir, "sub") {
+		t.Errorf("dir = %q", req.Dir)
+	}
+	if !slices.Contains(req.Env, "FOO=bar") {
+		t.Errorf("env %q lacks FOO=bar", req.Env)
+	}
+
+	if _, err := sessionCommand(sandbox.RunRequest{Command: "true", Cwd: "../escape", WorkDir: workDir}); err == nil {
+		t.Error("expected an error for a cwd outside the workspace")
+	}
+}
@@ -0,0 +1,98 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// StatusDiskQuotaExceeded is the directive status reported when a run was
+// terminated (or failed) because its facility outgrew Limits.DiskMB.
+const StatusDiskQuotaExceeded = "disk_quota_exceeded"
+
+// maxDiskMB caps the disk limit to prevent integer overflow.
+const maxDiskMB = 1 << 24 // 16 TiB
+
+// DiskLimitBytes returns Limits.DiskMB in bytes, or 0 if no limit is set.
+func DiskLimitBytes(limits protocol.Limits) (int64, error) {
+	if limits.DiskMB <= 0 {
+		return 0, nil
+	}
+	if limits.DiskMB > maxDiskMB {
+		return 0, fmt.Errorf("disk limit %d MB exceeds maximum %d MB", limits.DiskMB, maxDiskMB)
+	}
+	return int64(limits.DiskMB) << 20, nil
+}
+
+// DiskUsage returns the space allocated to files under root, counting each
+// inode once and never following symlinks. Entries that vanish or cannot be
+// read during the walk are skipped.
+func DiskUsage(root string) (int64, error) {
+	type inode struct {
+		dev uint64
+		ino uint64
+	}
+	seen := map[inode]bool{}
+	var total int64
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			total += fi.Size()
+			return nil
+		}
+		key := inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+		if seen[key] {
+			return nil
+		}
+		seen[key] = true
+		total += int64(st.Blocks) * 512
+		return nil
+	})
+	return total, err
+}
+
+// WatchDiskUsage polls usage every interval until ctx is done. The first time
+// usage exceeds limitBytes it calls onExceeded with the observed usage and
+// returns. Measurement errors are ignored (the facility may be mid-rewrite).
+func WatchDiskUsage(ctx context.Context, usage func() (int64, error), limitBytes int64, interval time.Duration, onExceeded func(used int64)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		used, err := usage()
+		if err != nil {
+			continue
+		}
+		if used > limitBytes {
+			onExceeded(used)
+			return
+		}
+	}
+}
+
+// ErrProjectQuotaUnsupported is returned by ApplyProjectQuota when the
+// platform or filesystem cannot enforce project quotas.
+var ErrProjectQuotaUnsupported = errors.New("project quota not supported")
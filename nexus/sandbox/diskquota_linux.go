@@ -0,0 +1,245 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Project quota support (XFS, or ext4 mounted with prjquota). The facility
+// tree is tagged with a project ID (inherited by new files) and a hard block
+// limit is set for that project, so writes past Limits.DiskMB fail with
+// EDQUOT in the kernel rather than relying on the polling watchdog alone.
+// Requires CAP_SYS_ADMIN and quota accounting enabled on the filesystem.
+
+const (
+	fsIocFSGetXattr    = 0x801c581f // _IOR('X', 31, struct fsxattr)
+	fsIocFSSetXattr    = 0x401c5820 // _IOW('X', 32, struct fsxattr)
+	fsXflagProjInherit = 0x00000200
+
+	qGetQuota   = 0x800007
+	qSetQuota   = 0x800008
+	prjQuota    = 2
+	qifBLimits  = 1
+	qifDQBlkSiz = 1024 // if_dqblk block limits are in 1 KiB units
+
+	// projectIDBase keeps generated IDs clear of hand-assigned projects.
+	projectIDBase = 0x40000000
+)
+
+// fsxattr mirrors struct fsxattr from <linux/fs.h>.
+type fsxattr struct {
+	Xflags     uint32
+	Extsize    uint32
+	Nextents   uint32
+	Projid     uint32
+	Cowextsize uint32
+	Pad        [8]byte
+}
+
+// ifDqblk mirrors struct if_dqblk from <linux/quota.h>.
+type ifDqblk struct {
+	BHardlimit uint64
+	BSoftlimit uint64
+	CurSpace   uint64
+	IHardlimit uint64
+	ISoftlimit uint64
+	CurInodes  uint64
+	BTime      uint64
+	ITime      uint64
+	Valid      uint32
+	_          uint32
+}
+
+// ProjectQuota is an applied project quota on a facility directory.
+type ProjectQuota struct {
+	device string
+	projID uint32
+}
+
+// ProjectID derives a stable project ID for a facility path.
+func ProjectID(root string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(filepath.Clean(root)))
+	return projectIDBase | (h.Sum32() & 0x3fffffff)
+}
+
+// ApplyProjectQuota tags root and everything below it with a project ID and
+// sets a hard block limit of limitBytes. The returned quota must be cleaned
+// up after the directive finishes. ErrProjectQuotaUnsupported (wrapped) is
+// returned when the filesystem or privileges do not allow it.
+func ApplyProjectQuota(root string, limitBytes int64) (*ProjectQuota, error) {
+	if limitBytes <= 0 {
+		return nil, nil
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(root, &st); err != nil {
+		return nil, err
+	}
+	device, err := blockDeviceFor(uint64(st.Dev))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProjectQuotaUnsupported, err)
+	}
+	q := &ProjectQuota{device: device, projID: ProjectID(root)}
+
+	// Probe before touching the tree: fails fast without quota support.
+	if _, err := q.get(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProjectQuotaUnsupported, err)
+	}
+	if err := setProjectTree(root, q.projID); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProjectQuotaUnsupported, err)
+	}
+	if err := q.setLimit(uint64(limitBytes)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrProjectQuotaUnsupported, err)
+	}
+	return q, nil
+}
+
+// Usage returns the bytes currently charged to the project.
+func (q *ProjectQuota) Usage() (int64, error) {
+	dq, err := q.get()
+	if err != nil {
+		return 0, err
+	}
+	return int64(dq.CurSpace), nil
+}
+
+// Cleanup lifts the limit. The project ID stays on the files so the next
+// directive on the facility reuses it.
+func (q *ProjectQuota) Cleanup() {
+	if q == nil {
+		return
+	}
+	_ = q.setLimit(0)
+}
+
+func (q *ProjectQuota) get() (ifDqblk, error) {
+	var dq ifDqblk
+	err := quotactl(qGetQuota, q.device, q.projID, &dq)
+	return dq, err
+}
+
+func (q *ProjectQuota) setLimit(limitBytes uint64) error {
+	blocks := (limitBytes + qifDQBlkSiz - 1) / qifDQBlkSiz
+	dq := ifDqblk{BHardlimit: blocks, BSoftlimit: blocks, Valid: qifBLimits}
+	return quotactl(qSetQuota, q.device, q.projID, &dq)
+}
+
+func quotactl(cmd int, device string, id uint32, dq *ifDqblk) error {
+	dev, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL,
+		uintptr(cmd<<8|prjQuota), uintptr(unsafe.Pointer(dev)), uintptr(id),
+		uintptr(unsafe.Pointer(dq)), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("quotactl %s: %w", device, errno)
+	}
+	return nil
+}
+
+// setProjectTree assigns projID (with inheritance on directories) to root
+// and every regular file and directory below it. Symlinks and special files
+// are skipped; opens never follow symlinks.
+func setProjectTree(root string, projID uint32) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		err = setProjectID(p, projID, d.IsDir())
+		if err != nil && p == root {
+			return err
+		}
+		return nil
+	})
+}
+
+func setProjectID(p string, projID uint32, dir bool) error {
+	f, err := os.OpenFile(p, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var attr fsxattr
+	if err := ioctlPtr(f.Fd(), fsIocFSGetXattr, unsafe.Pointer(&attr)); err != nil {
+		return fmt.Errorf("FS_IOC_FSGETXATTR %s: %w", p, err)
+	}
+	attr.Projid = projID
+	if dir {
+		attr.Xflags |= fsXflagProjInherit
+	}
+	if err := ioctlPtr(f.Fd(), fsIocFSSetXattr, unsafe.Pointer(&attr)); err != nil {
+		return fmt.Errorf("FS_IOC_FSSETXATTR %s: %w", p, err)
+	}
+	return nil
+}
+
+func ioctlPtr(fd uintptr, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// blockDeviceFor finds the block device backing dev via /proc/self/mountinfo.
+func blockDeviceFor(dev uint64) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return findMountDevice(f, devMajor(dev), devMinor(dev))
+}
+
+// findMountDevice returns the mount source of the first mountinfo entry with
+// the given major:minor whose source is a block device path.
+func findMountDevice(r io.Reader, major, minor uint32) (string, error) {
+	want := strconv.FormatUint(uint64(major), 10) + ":" + strconv.FormatUint(uint64(minor), 10)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 3 || fields[2] != want {
+			continue
+		}
+		// Optional fields end at "-", followed by fstype and source.
+		for i := 6; i+2 < len(fields); i++ {
+			if fields[i] == "-" {
+				if src := fields[i+2]; strings.HasPrefix(src, "/dev/") {
+					return src, nil
+				}
+				break
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no block device for " + want)
+}
+
+func devMajor(dev uint64) uint32 {
+	return uint32((dev>>8)&0xfff) | uint32((dev>>32)&^0xfff)
+}
+
+func devMinor(dev uint64) uint32 {
+	return uint32(dev&0xff) | uint32((dev>>12)&^0xff)
+}
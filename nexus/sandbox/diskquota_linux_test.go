@@ -0,0 +1,74 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFindMountDevice(t *testing.T) {
+	t.Parallel()
+
+	mountinfo := `22 1 0:21 / /proc rw,nosuid - proc proc rw
+25 1 253:1 / / rw,relatime shared:1 - xfs /dev/mapper/root rw,prjquota
+26 25 253:1 /data /srv/data rw,relatime shared:1 master:2 - xfs /dev/mapper/root rw,prjquota
+30 1 0:45 / /tmp rw - tmpfs tmpfs rw
+`
+	dev, err := findMountDevice(strings.NewReader(mountinfo), 253, 1)
+	if err != nil || dev != "/dev/mapper/root" {
+		t.Fatalf("got %q, %v", dev, err)
+	}
+	if _, err := findMountDevice(strings.NewReader(mountinfo), 0, 45); err == nil {
+		t.Error("tmpfs has no block device; expected error")
+	}
+	if _, err := findMountDevice(strings.NewReader(mountinfo), 8, 1); err == nil {
+		t.Error("unknown device; expected error")
+	}
+}
+
+func TestDevMajorMinor(t *testing.T) {
+	t.Parallel()
+
+	// makedev(259, 70000)
+	dev := uint64(70000&0xff) | uint64(259&0xfff)<<8 | uint64(70000&^0xff)<<12
+	if devMajor(dev) != 259 || devMinor(dev) != 70000 {
+		t.Fatalf("got %d:%d", devMajor(dev), devMinor(dev))
+	}
+}
+
+func TestProjectID(t *testing.T) {
+	t.Parallel()
+
+	a := ProjectID("/var/lib/nexus/facilities/a")
+	if a != ProjectID("/var/lib/nexus/facilities/a/") {
+		t.Error("project ID should be stable across equivalent paths")
+	}
+	if a == ProjectID("/var/lib/nexus/facilities/b") {
+		t.Error("different facilities should get different IDs")
+	}
+	if a&projectIDBase == 0 {
+		t.Errorf("project ID %#x outside generated range", a)
+	}
+}
+
+func TestApplyProjectQuota_UnsupportedOrApplied(t *testing.T) {
+	t.Parallel()
+
+	if q, err := ApplyProjectQuota(t.TempDir(), 0); q != nil || err != nil {
+		t.Fatalf("zero limit should be a no-op, got %v, %v", q, err)
+	}
+
+	q, err := ApplyProjectQuota(t.TempDir(), 64<<20)
+	if err != nil {
+		if !errors.Is(err, ErrProjectQuotaUnsupported) {
+			t.Fatalf("expected ErrProjectQuotaUnsupported, got %v", err)
+		}
+		return
+	}
+	defer q.Cleanup()
+	if _, err := q.Usage(); err != nil {
+		t.Errorf("Usage: %v", err)
+	}
+}
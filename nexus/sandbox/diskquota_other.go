@@ -0,0 +1,22 @@
+//go:build !linux
+
+package sandbox
+
+// ProjectQuota is a no-op on non-Linux platforms.
+type ProjectQuota struct{}
+
+// ApplyProjectQuota is not supported on non-Linux platforms.
+func ApplyProjectQuota(root string, limitBytes int64) (*ProjectQuota, error) {
+	if limitBytes <= 0 {
+		return nil, nil
+	}
+	return nil, ErrProjectQuotaUnsupported
+}
+
+// Usage is never called on non-Linux platforms (no quota is ever applied).
+func (q *ProjectQuota) Usage() (int64, error) {
+	return 0, ErrProjectQuotaUnsupported
+}
+
+// Cleanup is a no-op on non-Linux platforms.
+func (q *ProjectQuota) Cleanup() {}
@@ -0,0 +1,98 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/protocol"
+)
+
+func TestDiskLimitBytes(t *testing.T) {
+	t.Parallel()
+
+	if n, err := DiskLimitBytes(protocol.Limits{}); n != 0 || err != nil {
+		t.Errorf("no limit: got %d, %v", n, err)
+	}
+	if n, err := DiskLimitBytes(protocol.Limits{DiskMB: 10}); n != 10<<20 || err != nil {
+		t.Errorf("10 MB: got %d, %v", n, err)
+	}
+	if _, err := DiskLimitBytes(protocol.Limits{DiskMB: maxDiskMB + 1}); err == nil {
+		t.Error("expected error above maximum")
+	}
+}
+
+func TestDiskUsage(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	data := make([]byte, 256<<10)
+	if err := os.WriteFile(filepath.Join(root, "a.bin"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	base, err := DiskUsage(root)
+	if err != nil {
+		t.Fatalf("DiskUsage: %v", err)
+	}
+	if base < int64(len(data)) {
+		t.Fatalf("usage %d < file size %d", base, len(data))
+	}
+
+	// Hard links are counted once; symlinks are not followed.
+	if err := os.Link(filepath.Join(root, "a.bin"), filepath.Join(root, "b.bin")); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "big.bin"), make([]byte, 1<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DiskUsage(root)
+	if err != nil {
+		t.Fatalf("DiskUsage: %v", err)
+	}
+	if got-base > 64<<10 {
+		t.Fatalf("hard link/symlink inflated usage: %d -> %d", base, got)
+	}
+
+	if _, err := DiskUsage(filepath.Join(root, "missing")); err == nil {
+		t.Error("expected error for missing root")
+	}
+}
+
+func TestWatchDiskUsage(t *testing.T) {
+	t.Parallel()
+
+	var used int64 = 10
+	usage := func() (int64, error) {
+		used += 10
+		if used == 20 {
+			return 0, errors.New("transient")
+		}
+		return used, nil
+	}
+	fired := make(chan int64, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	WatchDiskUsage(ctx, usage, 35, time.Millisecond, func(u int64) { fired <- u })
+	select {
+	case u := <-fired:
+		if u != 40 {
+			t.Errorf("fired at %d, want 40", u)
+		}
+	default:
+		t.Fatal("onExceeded not called")
+	}
+
+	// Returns on cancellation without firing.
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	cancel2()
+	WatchDiskUsage(ctx2, func() (int64, error) { return 100, nil }, 1, time.Hour, func(int64) {
+		t.Error("should not fire after cancel")
+	})
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"io"
 
+	"cybros.ai/nexus/egressproxy"
 	"cybros.ai/nexus/protocol"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Driver is the interface that all sandbox drivers must implement.
@@ -18,6 +21,29 @@ type Driver interface {
 	HealthCheck(ctx context.Context) HealthResult
 }
 
+// CapabilityReporter is implemented by drivers that can describe, before a
+// directive runs, how its capabilities will actually be enforced. The daemon
+// merges the returned keys into effective_capabilities_summary.
+type CapabilityReporter interface {
+	EffectiveCapabilities(caps protocol.Capabilities) map[string]any
+}
+
+// FacilityExporter is implemented by drivers that can keep a facility outside
+// the host filesystem (e.g. a block-backed image attached to a microVM).
+// ExportFacility makes facilityPath mirror the facility's current contents so
+// host-side steps (git diff, artifact collection) can read it. Paths in keep
+// (facility-relative) are host-owned and preserved as they are. It reports
+// false when the facility is not held outside facilityPath.
+type FacilityExporter interface {
+	ExportFacility(ctx context.Context, facilityPath string, keep []string) (bool, error)
+}
+
+// MetricsProvider is implemented by drivers that export their own
+// Prometheus metrics. The daemon registers the collectors on its registry.
+type MetricsProvider interface {
+	Collectors() []prometheus.Collector
+}
+
 // HealthResult reports the health status of a sandbox driver.
 type HealthResult struct {
 	Healthy bool              `json:"healthy"`
@@ -44,6 +70,19 @@ type RunRequest struct {
 
 	LogSink LogSink
 
+	// Terminal, when set, runs the command interactively on a
+	// pseudo-terminal (drivers implementing TerminalRunner only).
+	Terminal *Terminal
+
+	// Audit receives the egress proxy's audit events as JSONL (see
+	// egressproxy.AuditLogger), kept apart from the stdout/stderr streams.
+	// nil discards them.
+	Audit io.Writer
+
+	// Egress carries the directive's egress limits (and the daemon's proxy
+	// metrics) to drivers that start an egress proxy.
+	Egress egressproxy.Options
+
 	// Phase 1 additions: capability plumbing for sandbox drivers
 
 	// NetCapability describes the network policy for this directive.
@@ -66,6 +105,19 @@ type RunRequest struct {
 	// Limits contains resource limits (CPU, memory) from the directive spec.
 	// Used by host/bwrap drivers to apply cgroup v2 constraints on Linux.
 	Limits protocol.Limits
+
+	// Cgroups, when set, applies Limits instead of writing /sys/fs/cgroup
+	// directly (see ApplyLimits).
+	Cgroups CgroupApplier
+
+	// Network provisions TAP devices and egress firewall rules for drivers
+	// that attach the sandbox to a host network device. nil when
+	// nexus-helper is not configured.
+	Network TapNetwork
+
+	// Secrets are resolved Capabilities.Secrets values (nil if none). Only
+	// drivers implementing SecretsDeliverer receive them.
+	Secrets *Secrets
 }
 
 type LogSink interface {
@@ -74,7 +126,7 @@ type LogSink interface {
 
 type RunResult struct {
 	ExitCode int
-	Status   string // succeeded/failed/canceled/timed_out
+	Status   string // succeeded/failed/canceled/timed_out/disk_quota_exceeded
 
 	StdoutTruncated bool
 	StderrTruncated bool
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // DriverFactory creates sandbox drivers by profile name.
@@ -92,6 +94,18 @@ func (f *DriverFactory) HealthCheckAll(ctx context.Context) map[string]HealthRes
 	return results
 }
 
+// Collectors returns the Prometheus collectors of every registered driver
+// that implements MetricsProvider.
+func (f *DriverFactory) Collectors() []prometheus.Collector {
+	var cs []prometheus.Collector
+	for _, drv := range f.drivers {
+		if mp, ok := drv.(MetricsProvider); ok {
+			cs = append(cs, mp.Collectors()...)
+		}
+	}
+	return cs
+}
+
 // UntrustedDriverName returns the driver name used for the "untrusted" profile.
 // Included in heartbeat payloads so Mothership can map profiles to the correct driver.
 func (f *DriverFactory) UntrustedDriverName() string {
@@ -0,0 +1,118 @@
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// apiClient talks to the Firecracker API socket (--api-sock). Only the
+// endpoints needed for snapshots are implemented.
+type apiClient struct {
+	hc *http.Client
+}
+
+func newAPIClient(socketPath string) *apiClient {
+	return &apiClient{hc: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+		Timeout: 60 * time.Second,
+	}}
+}
+
+// SnapshotCreate is the body of PUT /snapshot/create.
+type SnapshotCreate struct {
+	SnapshotType string `json:"snapshot_type"`
+	SnapshotPath string `json:"snapshot_path"`
+	MemFilePath  string `json:"mem_file_path"`
+}
+
+// SnapshotLoad is the body of PUT /snapshot/load.
+type SnapshotLoad struct {
+	SnapshotPath string     `json:"snapshot_path"`
+	MemBackend   MemBackend `json:"mem_backend"`
+	ResumeVM     bool       `json:"resume_vm"`
+}
+
+// MemBackend selects how guest memory is restored.
+type MemBackend struct {
+	BackendType string `json:"backend_type"`
+	BackendPath string `json:"backend_path"`
+}
+
+// DriveUpdate is the body of PATCH /drives/{id}.
+type DriveUpdate struct {
+	DriveID    string `json:"drive_id"`
+	PathOnHost string `json:"path_on_host"`
+}
+
+type vmState struct {
+	State string `json:"state"`
+}
+
+func (c *apiClient) pause(ctx context.Context) error {
+	return c.do(ctx, http.MethodPatch, "/vm", vmState{State: "Paused"})
+}
+
+func (c *apiClient) resume(ctx context.Context) error {
+	return c.do(ctx, http.MethodPatch, "/vm", vmState{State: "Resumed"})
+}
+
+func (c *apiClient) createSnapshot(ctx context.Context, snapshotPath, memPath string) error {
+	return c.do(ctx, http.MethodPut, "/snapshot/create", SnapshotCreate{
+		SnapshotType: "Full",
+		SnapshotPath: snapshotPath,
+		MemFilePath:  memPath,
+	})
+}
+
+func (c *apiClient) loadSnapshot(ctx context.Context, snapshotPath, memPath string) error {
+	return c.do(ctx, http.MethodPut, "/snapshot/load", SnapshotLoad{
+		SnapshotPath: snapshotPath,
+		MemBackend:   MemBackend{BackendType: "File", BackendPath: memPath},
+	})
+}
+
+// updateDrive points a restored VM's drive at a new backing file. The guest
+// is notified of the (possibly different) size.
+func (c *apiClient) updateDrive(ctx context.Context, driveID, path string) error {
+	return c.do(ctx, http.MethodPatch, "/drives/"+driveID, DriveUpdate{DriveID: driveID, PathOnHost: path})
+}
+
+func (c *apiClient) do(ctx context.Context, method, path string, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://localhost"+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("firecracker API %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	var fault struct {
+		FaultMessage string `json:"fault_message"`
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(raw, &fault) == nil && fault.FaultMessage != "" {
+		return fmt.Errorf("firecracker API %s %s: %s", method, path, fault.FaultMessage)
+	}
+	return fmt.Errorf("firecracker API %s %s: HTTP %d", method, path, resp.StatusCode)
+}
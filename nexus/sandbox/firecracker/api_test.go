@@ -0,0 +1,105 @@
+package firecracker
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type apiCall struct {
+	method, path string
+	body         map[string]any
+}
+
+// startFakeAPI serves a fake Firecracker API on a Unix socket, recording
+// calls. Requests to failPath get a 400 with a fault message.
+func startFakeAPI(t *testing.T, failPath string) (*apiClient, func() []apiCall) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "api.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var calls []apiCall
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]any
+		_ = json.Unmarshal(raw, &body)
+		mu.Lock()
+		calls = append(calls, apiCall{r.Method, r.URL.Path, body})
+		mu.Unlock()
+		if r.URL.Path == failPath {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"fault_message":"drive not found"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return newAPIClient(sock), func() []apiCall {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]apiCall(nil), calls...)
+	}
+}
+
+func TestAPIClient_SnapshotRequests(t *testing.T) {
+	api, calls := startFakeAPI(t, "")
+	ctx := context.Background()
+
+	if err := api.pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.createSnapshot(ctx, "vm.snap", "vm.mem"); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.loadSnapshot(ctx, "/s/vm.snap", "/s/vm.mem"); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.updateDrive(ctx, "workspace", "/tmp/ws.ext4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.resume(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	got := calls()
+	if len(got) != 5 {
+		t.Fatalf("calls = %d, want 5", len(got))
+	}
+	checks := []struct {
+		method, path, key string
+		want              any
+	}{
+		{"PATCH", "/vm", "state", "Paused"},
+		{"PUT", "/snapshot/create", "snapshot_type", "Full"},
+		{"PUT", "/snapshot/load", "resume_vm", false},
+		{"PATCH", "/drives/workspace", "path_on_host", "/tmp/ws.ext4"},
+		{"PATCH", "/vm", "state", "Resumed"},
+	}
+	for i, c := range checks {
+		if got[i].method != c.method || got[i].path != c.path || got[i].body[c.key] != c.want {
+			t.Errorf("call %d = %s %s %v, want %s %s %s=%v", i, got[i].method, got[i].path, got[i].body, c.method, c.path, c.key, c.want)
+		}
+	}
+	mem, _ := got[2].body["mem_backend"].(map[string]any)
+	if mem["backend_type"] != "File" || mem["backend_path"] != "/s/vm.mem" {
+		t.Errorf("mem_backend = %v", got[2].body["mem_backend"])
+	}
+}
+
+func TestAPIClient_FaultMessage(t *testing.T) {
+	api, _ := startFakeAPI(t, "/drives/cmd")
+	err := api.updateDrive(context.Background(), "cmd", "/tmp/cmd.ext4")
+	if err == nil || !strings.Contains(err.Error(), "drive not found") {
+		t.Fatalf("expected fault message in error, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os/exec"
 	"strconv"
+	"strings"
 )
 
 // CreateImageFromDir creates an ext4 image from a directory using mke2fs -d.
@@ -99,3 +100,58 @@ exit $CP_EXIT
 	return nil
 }
 
+// ImageFull reports whether an ext4 image has (almost) no space left for
+// unprivileged writes: free blocks no more than the root-reserved blocks plus
+// a small margin. Used to attribute a failed run to limits.disk_mb.
+func ImageFull(imagePath string) (bool, error) {
+	if imagePath == "" {
+		return false, fmt.Errorf("image path is required")
+	}
+	out, err := exec.Command("dumpe2fs", "-h", imagePath).Output()
+	if err != nil {
+		return false, fmt.Errorf("dumpe2fs: %w", err)
+	}
+	st, err := parseDumpe2fsHeader(string(out))
+	if err != nil {
+		return false, err
+	}
+	const marginBytes = 2 << 20 // 2 MiB
+	return (st.freeBlocks-st.reservedBlocks)*st.blockSize < marginBytes, nil
+}
+
+type ext4Usage struct {
+	blockSize      int64
+	freeBlocks     int64
+	reservedBlocks int64
+}
+
+// parseDumpe2fsHeader extracts block accounting from `dumpe2fs -h` output.
+func parseDumpe2fsHeader(out string) (ext4Usage, error) {
+	var st ext4Usage
+	fields := map[string]*int64{
+		"Block size":           &st.blockSize,
+		"Free blocks":          &st.freeBlocks,
+		"Reserved block count": &st.reservedBlocks,
+	}
+	found := 0
+	for _, line := range strings.Split(out, "\n") {
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		dst, ok := fields[strings.TrimSpace(key)]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		if err != nil {
+			return ext4Usage{}, fmt.Errorf("dumpe2fs: parse %s: %w", key, err)
+		}
+		*dst = n
+		found++
+	}
+	if found != len(fields) || st.blockSize <= 0 {
+		return ext4Usage{}, fmt.Errorf("dumpe2fs: incomplete header")
+	}
+	return st, nil
+}
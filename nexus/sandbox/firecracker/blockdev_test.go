@@ -1,6 +1,10 @@
 package firecracker
 
 import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 )
 
@@ -55,3 +59,56 @@ func TestExtractImageToDir_Validation(t *testing.T) {
 	}
 }
 
+func TestParseDumpe2fsHeader(t *testing.T) {
+	out := `dumpe2fs 1.47.0 (5-Feb-2023)
+Filesystem volume name:   <none>
+Block count:              16384
+Reserved block count:     819
+Free blocks:              15000
+Block size:               1024
+`
+	st, err := parseDumpe2fsHeader(out)
+	if err != nil {
+		t.Fatalf("parseDumpe2fsHeader: %v", err)
+	}
+	if st.blockSize != 1024 || st.freeBlocks != 15000 || st.reservedBlocks != 819 {
+		t.Errorf("unexpected usage: %+v", st)
+	}
+
+	if _, err := parseDumpe2fsHeader("Block size: 4096\n"); err == nil {
+		t.Error("expected error for incomplete header")
+	}
+}
+
+func TestImageFull(t *testing.T) {
+	if _, err := exec.LookPath("mke2fs"); err != nil {
+		t.Skip("mke2fs not available")
+	}
+	if _, err := exec.LookPath("dumpe2fs"); err != nil {
+		t.Skip("dumpe2fs not available")
+	}
+
+	empty := t.TempDir()
+	img := filepath.Join(t.TempDir(), "ws.ext4")
+	if err := CreateImageFromDir(empty, img, 16); err != nil {
+		t.Fatalf("CreateImageFromDir: %v", err)
+	}
+	full, err := ImageFull(img)
+	if err != nil || full {
+		t.Fatalf("empty image: full=%v err=%v", full, err)
+	}
+
+	src := t.TempDir()
+	// Non-zero data: mke2fs -d stores all-zero blocks sparsely.
+	if err := os.WriteFile(filepath.Join(src, "fill.bin"), bytes.Repeat([]byte{0xab}, 13<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	img2 := filepath.Join(t.TempDir(), "ws2.ext4")
+	if err := CreateImageFromDir(src, img2, 16); err != nil {
+		t.Fatalf("CreateImageFromDir: %v", err)
+	}
+	full, err = ImageFull(img2)
+	if err != nil || !full {
+		t.Fatalf("filled image: full=%v err=%v", full, err)
+	}
+}
@@ -8,6 +8,8 @@ type VMConfig struct {
 	Drives        []Drive       `json:"drives"`
 	MachineConfig MachineConfig `json:"machine-config"`
 	Vsock         *VsockConfig  `json:"vsock,omitempty"`
+
+	NetworkInterfaces []NetworkInterface `json:"network-interfaces,omitempty"`
 }
 
 // BootSource configures the guest kernel.
@@ -38,15 +40,33 @@ type VsockConfig struct {
 	UDSPath  string `json:"uds_path"`
 }
 
+// NetworkInterface attaches a host TAP device as a virtio-net interface.
+type NetworkInterface struct {
+	IfaceID     string `json:"iface_id"`
+	GuestMAC    string `json:"guest_mac"`
+	HostDevName string `json:"host_dev_name"`
+}
+
+// GuestNetwork describes the guest's TAP-backed interface. nexus-init
+// configures eth0 from the nexus.net= boot arg and uses the gateway as its
+// DNS server.
+type GuestNetwork struct {
+	TapDevice string
+	GuestMAC  string
+	GuestCIDR string // e.g. 172.30.0.2/30
+	Gateway   string // host side of the TAP device
+}
+
 // VMConfigInput holds the inputs for building a VM config.
 type VMConfigInput struct {
-	KernelPath    string
-	RootfsPath    string
-	CmdImagePath  string
-	WsImagePath   string
-	VCPUs         int
-	MemSizeMiB    int
-	VsockUDSPath  string // empty = no vsock
+	KernelPath   string
+	RootfsPath   string
+	CmdImagePath string
+	WsImagePath  string
+	VCPUs        int
+	MemSizeMiB   int
+	VsockUDSPath string        // empty = no vsock
+	Network      *GuestNetwork // nil = no network interface
 }
 
 // Default boot args for the microVM.
@@ -86,6 +106,15 @@ func BuildVMConfig(input VMConfigInput) VMConfig {
 		},
 	}
 
+	if n := input.Network; n != nil {
+		cfg.BootSource.BootArgs += " nexus.net=" + n.GuestCIDR + "," + n.Gateway
+		cfg.NetworkInterfaces = []NetworkInterface{{
+			IfaceID:     "eth0",
+			GuestMAC:    n.GuestMAC,
+			HostDevName: n.TapDevice,
+		}}
+	}
+
 	if input.VsockUDSPath != "" {
 		cfg.Vsock = &VsockConfig{
 			VsockID:  "vsock0",
@@ -2,6 +2,7 @@ package firecracker
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -124,3 +125,42 @@ func TestBuildVMConfig_DriveOrder(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildVMConfig_WithNetwork(t *testing.T) {
+	cfg := BuildVMConfig(VMConfigInput{
+		KernelPath:   "/opt/nexus/vmlinux",
+		RootfsPath:   "/opt/nexus/rootfs.ext4",
+		CmdImagePath: "/tmp/cmd.ext4",
+		WsImagePath:  "/tmp/ws.ext4",
+		VCPUs:        2,
+		MemSizeMiB:   512,
+		Network: &GuestNetwork{
+			TapDevice: "nxtap0123456789",
+			GuestMAC:  "06:00:ac:1e:00:02",
+			GuestCIDR: "172.30.0.2/30",
+			Gateway:   "172.30.0.1",
+		},
+	})
+
+	if len(cfg.NetworkInterfaces) != 1 {
+		t.Fatalf("network interfaces = %+v", cfg.NetworkInterfaces)
+	}
+	iface := cfg.NetworkInterfaces[0]
+	if iface.IfaceID != "eth0" || iface.HostDevName != "nxtap0123456789" || iface.GuestMAC != "06:00:ac:1e:00:02" {
+		t.Errorf("interface = %+v", iface)
+	}
+	if !strings.HasSuffix(cfg.BootSource.BootArgs, " nexus.net=172.30.0.2/30,172.30.0.1") {
+		t.Errorf("boot args = %q", cfg.BootSource.BootArgs)
+	}
+
+	data, err := MarshalVMConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"network-interfaces"`) {
+		t.Errorf("marshaled config lacks network-interfaces:\n%s", data)
+	}
+	if plain, _ := MarshalVMConfig(BuildVMConfig(VMConfigInput{})); strings.Contains(string(plain), "network-interfaces") {
+		t.Error("network-interfaces present without a network")
+	}
+}
@@ -0,0 +1,252 @@
+//go:build linux
+
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Block mode (firecracker.facility_mode: block): each facility lives in a
+// long-lived ext4 image that is attached to the VM as the workspace drive.
+// The image is the source of truth; the facility directory on the host is
+// only refreshed by ExportFacility.
+
+// facilityImage is a locked facility image. Only one VM (or export) uses an
+// image at a time.
+type facilityImage struct {
+	path string
+	lock *os.File
+}
+
+func (f *facilityImage) close() {
+	if f.lock != nil {
+		_ = f.lock.Close() // releases the flock
+		f.lock = nil
+	}
+}
+
+func (d *Driver) blockFacilities() bool { return d.cfg.FacilityMode == "block" }
+
+func (d *Driver) facilityImagePath(facilityPath string) string {
+	return filepath.Join(d.cfg.FacilityImageDir, filepath.Base(facilityPath)+".ext4")
+}
+
+// openFacilityImage locks the facility's image, creating it from the
+// facility directory on first use. The image is checked with e2fsck (which
+// replays the journal of a VM that was killed mid-write) and resized to
+// sizeMiB. Warnings describe non-fatal problems, such as a failed shrink.
+func (d *Driver) openFacilityImage(ctx context.Context, facilityPath string, sizeMiB int) (*facilityImage, []string, error) {
+	if err := os.MkdirAll(d.cfg.FacilityImageDir, 0o700); err != nil {
+		return nil, nil, err
+	}
+	path := d.facilityImagePath(facilityPath)
+	lock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return nil, nil, err
+	}
+	img := &facilityImage{path: path, lock: lock}
+
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		// First attach: import the facility directory.
+		tmp := path + ".tmp"
+		_ = os.Remove(tmp)
+		if err := CreateImageFromDir(facilityPath, tmp, sizeMiB); err != nil {
+			img.close()
+			return nil, nil, fmt.Errorf("import facility: %w", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			img.close()
+			return nil, nil, err
+		}
+		return img, nil, nil
+	} else if err != nil {
+		img.close()
+		return nil, nil, err
+	}
+
+	if err := checkImage(path, false); err != nil {
+		img.close()
+		return nil, nil, err
+	}
+	warning, err := resizeImage(path, sizeMiB)
+	if err != nil {
+		img.close()
+		return nil, nil, err
+	}
+	var warnings []string
+	if warning != "" {
+		warnings = append(warnings, warning)
+	}
+	return img, warnings, nil
+}
+
+// ExportFacility replaces facilityPath with the contents of the facility's
+// image, keeping the host-owned paths in keep. Implements
+// sandbox.FacilityExporter.
+func (d *Driver) ExportFacility(ctx context.Context, facilityPath string, keep []string) (bool, error) {
+	if !d.blockFacilities() {
+		return false, nil
+	}
+	for _, k := range keep {
+		if !filepath.IsLocal(k) {
+			return false, fmt.Errorf("export: keep path %q is not facility-relative", k)
+		}
+	}
+	path := d.facilityImagePath(facilityPath)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return false, nil // never attached; the directory is still authoritative
+	}
+	lock, err := lockFile(ctx, path+".lock")
+	if err != nil {
+		return false, err
+	}
+	defer lock.Close()
+
+	if err := checkImage(path, false); err != nil {
+		return false, err
+	}
+
+	staging := facilityPath + ".export"
+	if err := os.RemoveAll(staging); err != nil {
+		return false, err
+	}
+	if err := os.Mkdir(staging, 0o755); err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(staging)
+	if err := ExtractImageToDir(path, staging); err != nil {
+		return false, err
+	}
+	_ = os.Remove(filepath.Join(staging, "lost+found")) // only if empty
+
+	for _, k := range keep {
+		src := filepath.Join(facilityPath, k)
+		dst := filepath.Join(staging, k)
+		if err := os.RemoveAll(dst); err != nil {
+			return false, err
+		}
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return false, err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			return false, err
+		}
+	}
+
+	old := facilityPath + ".export-old"
+	if err := os.RemoveAll(old); err != nil {
+		return false, err
+	}
+	if err := os.Rename(facilityPath, old); err != nil {
+		return false, err
+	}
+	if err := os.Rename(staging, facilityPath); err != nil {
+		_ = os.Rename(old, facilityPath)
+		return false, err
+	}
+	_ = os.RemoveAll(old)
+	return true, nil
+}
+
+// checkImage runs e2fsck in preen mode. Without force, a cleanly unmounted
+// image is skipped quickly; an unclean one (VM killed) is replayed and
+// checked. Exit codes 1 and 2 mean errors were corrected.
+func checkImage(path string, force bool) error {
+	args := []string{"-p"}
+	if force {
+		args = append(args, "-f")
+	}
+	out, err := exec.Command("e2fsck", append(args, path)...).CombinedOutput()
+	var ee *exec.ExitError
+	if errors.As(err, &ee) && ee.ExitCode()&^3 == 0 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("e2fsck %s: %w (image needs manual repair): %s", filepath.Base(path), err, out)
+	}
+	return nil
+}
+
+// resizeImage grows or shrinks the image's filesystem to sizeMiB. A failed
+// shrink (the data no longer fits) is reported as a warning and the image
+// keeps its size.
+func resizeImage(path string, sizeMiB int) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	want := int64(sizeMiB) << 20
+	switch {
+	case fi.Size() == want:
+		return "", nil
+	case fi.Size() < want:
+		// resize2fs insists on a fresh forced check.
+		if err := checkImage(path, true); err != nil {
+			return "", err
+		}
+		if err := os.Truncate(path, want); err != nil {
+			return "", err
+		}
+		if out, err := exec.Command("resize2fs", path).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("resize2fs grow: %w: %s", err, out)
+		}
+		return "", nil
+	default:
+		if err := checkImage(path, true); err != nil {
+			return "", err
+		}
+		if out, err := exec.Command("resize2fs", path, strconv.Itoa(sizeMiB)+"M").CombinedOutput(); err != nil {
+			return fmt.Sprintf("facility image is %d MiB, above the %d MiB limit, and could not be shrunk: %s",
+				fi.Size()>>20, sizeMiB, firstLine(out)), nil
+		}
+		if err := os.Truncate(path, want); err != nil {
+			return "", err
+		}
+		return "", nil
+	}
+}
+
+// lockFile takes an exclusive flock on path, waiting until ctx is done.
+func lockFile(ctx context.Context, path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("facility image in use: %w", ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func firstLine(b []byte) string {
+	for i, c := range b {
+		if c == '\n' {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
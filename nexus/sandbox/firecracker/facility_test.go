@@ -0,0 +1,220 @@
+//go:build linux
+
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/config"
+)
+
+func requireE2fsprogs(t *testing.T) {
+	t.Helper()
+	for _, tool := range []string{"mke2fs", "e2fsck", "resize2fs", "debugfs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+}
+
+func newBlockDriver(t *testing.T) *Driver {
+	t.Helper()
+	return &Driver{cfg: config.FirecrackerConfig{
+		FacilityMode:     "block",
+		FacilityImageDir: filepath.Join(t.TempDir(), "images"),
+	}}
+}
+
+func imageFile(t *testing.T, img, name string) string {
+	t.Helper()
+	out, err := exec.Command("debugfs", "-R", "cat /"+name, img).Output()
+	if err != nil {
+		t.Fatalf("debugfs cat %s: %v", name, err)
+	}
+	return string(out)
+}
+
+func TestOpenFacilityImage_ImportAndReuse(t *testing.T) {
+	requireE2fsprogs(t)
+	d := newBlockDriver(t)
+	facility := filepath.Join(t.TempDir(), "fac-1")
+	if err := os.Mkdir(facility, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(facility, "hello.txt"), []byte("hi"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	img, warnings, err := d.openFacilityImage(context.Background(), facility, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %q", warnings)
+	}
+	if img.path != filepath.Join(d.cfg.FacilityImageDir, "fac-1.ext4") {
+		t.Errorf("image path = %q", img.path)
+	}
+	if got := imageFile(t, img.path, "hello.txt"); got != "hi" {
+		t.Errorf("imported file = %q", got)
+	}
+	img.close()
+
+	// The image, not the directory, is authoritative from now on.
+	if err := os.WriteFile(filepath.Join(facility, "hello.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	img, _, err = d.openFacilityImage(context.Background(), facility, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.close()
+	if got := imageFile(t, img.path, "hello.txt"); got != "hi" {
+		t.Errorf("image re-imported: file = %q", got)
+	}
+}
+
+func TestOpenFacilityImage_Resize(t *testing.T) {
+	requireE2fsprogs(t)
+	d := newBlockDriver(t)
+	facility := t.TempDir()
+
+	img, _, err := d.openFacilityImage(context.Background(), facility, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.close()
+
+	img, warnings, err := d.openFacilityImage(context.Background(), facility, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.close()
+	if fi, _ := os.Stat(img.path); fi.Size() != 32<<20 || len(warnings) != 0 {
+		t.Fatalf("grow: size = %d, warnings = %q", fi.Size(), warnings)
+	}
+
+	img, warnings, err = d.openFacilityImage(context.Background(), facility, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.close()
+	if fi, _ := os.Stat(img.path); fi.Size() != 24<<20 || len(warnings) != 0 {
+		t.Fatalf("shrink: size = %d, warnings = %q", fi.Size(), warnings)
+	}
+}
+
+func TestOpenFacilityImage_ShrinkTooSmallWarns(t *testing.T) {
+	requireE2fsprogs(t)
+	d := newBlockDriver(t)
+	facility := t.TempDir()
+	// Non-zero data: mke2fs -d stores all-zero blocks sparsely.
+	if err := os.WriteFile(filepath.Join(facility, "fill.bin"), bytes.Repeat([]byte{0xab}, 12<<20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	img, _, err := d.openFacilityImage(context.Background(), facility, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.close()
+
+	img, warnings, err := d.openFacilityImage(context.Background(), facility, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.close()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "could not be shrunk") {
+		t.Fatalf("warnings = %q", warnings)
+	}
+	if fi, _ := os.Stat(img.path); fi.Size() != 32<<20 {
+		t.Errorf("size after failed shrink = %d, want unchanged", fi.Size())
+	}
+}
+
+func TestOpenFacilityImage_Locked(t *testing.T) {
+	requireE2fsprogs(t)
+	d := newBlockDriver(t)
+	facility := t.TempDir()
+
+	img, _, err := d.openFacilityImage(context.Background(), facility, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, _, err := d.openFacilityImage(ctx, facility, 16); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("expected in-use error, got %v", err)
+	}
+}
+
+func TestExportFacility_NotBlockMode(t *testing.T) {
+	d := &Driver{}
+	ok, err := d.ExportFacility(context.Background(), t.TempDir(), nil)
+	if ok || err != nil {
+		t.Fatalf("ExportFacility = %v, %v; want false, nil", ok, err)
+	}
+
+	d = newBlockDriver(t)
+	if ok, err := d.ExportFacility(context.Background(), t.TempDir(), nil); ok || err != nil {
+		t.Fatalf("no image: ExportFacility = %v, %v; want false, nil", ok, err)
+	}
+	if _, err := d.ExportFacility(context.Background(), t.TempDir(), []string{"../x"}); err == nil {
+		t.Fatal("expected error for non-local keep path")
+	}
+}
+
+func TestExportFacility(t *testing.T) {
+	requireE2fsprogs(t)
+	if _, err := exec.LookPath("fuse2fs"); err != nil {
+		t.Skip("fuse2fs not available")
+	}
+	d := newBlockDriver(t)
+	facility := filepath.Join(t.TempDir(), "fac")
+	if err := os.MkdirAll(facility, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(facility, "a.txt"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := d.openFacilityImage(context.Background(), facility, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.close()
+
+	// Host-only changes: a stray file (dropped) and a kept log directory.
+	if err := os.WriteFile(filepath.Join(facility, "stray.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logDir := filepath.Join(facility, ".nexus", "logs")
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(logDir, "out.log"), []byte("log"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := d.ExportFacility(context.Background(), facility, []string{".nexus/logs"})
+	if err != nil || !ok {
+		t.Fatalf("ExportFacility = %v, %v", ok, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(facility, "a.txt")); err != nil || string(data) != "a" {
+		t.Errorf("a.txt = %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(facility, "stray.txt")); !os.IsNotExist(err) {
+		t.Errorf("stray.txt survived export: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(logDir, "out.log")); err != nil || string(data) != "log" {
+		t.Errorf("kept log = %q, %v", data, err)
+	}
+}
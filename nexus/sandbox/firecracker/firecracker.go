@@ -9,24 +9,92 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net/netip"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"syscall"
+	"time"
 
 	"cybros.ai/nexus/config"
 	"cybros.ai/nexus/egressproxy"
+	"cybros.ai/nexus/helper"
+	"cybros.ai/nexus/protocol"
 	"cybros.ai/nexus/sandbox"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Driver implements sandbox.Driver using Firecracker microVMs.
 type Driver struct {
-	cfg config.FirecrackerConfig
+	cfg     config.FirecrackerConfig
+	jail    sandbox.Jailer // runs the jailer through nexus-helper; nil runs it directly (as root)
+	uids    *idPool        // jailer uid/gid pool; nil when the jailer is disabled
+	pool    *snapshotPool  // warm pool; nil when snapshots are disabled
+	metrics *metrics
+
+	// poolUnsupported says why snapshot.enabled could not start the warm
+	// pool (config validation rejects these combinations); HealthCheck
+	// reports it.
+	poolUnsupported string
+
+	taps      *idPool // tap subnet slots; nil unless network_mode is tap
+	tapSubnet netip.Prefix
+}
+
+// New creates a Firecracker Driver with the given config. With the jailer
+// enabled, jail (nexus-helper, when configured) launches it so nexusd need
+// not be root; nil runs the jailer directly. With snapshots enabled it starts
+// building the template and filling the warm pool in the background.
+func New(cfg config.FirecrackerConfig, jail sandbox.Jailer) *Driver {
+	d := &Driver{cfg: cfg, jail: jail, metrics: newMetrics()}
+	if cfg.Jailer.Enabled {
+		d.uids = newUIDPool(cfg.Jailer.UIDRangeStart, cfg.Jailer.UIDRangeSize)
+	}
+	if cfg.NetworkMode == "tap" {
+		if subnet, err := netip.ParsePrefix(cfg.TapSubnet); err == nil && subnet.Addr().Is4() && subnet.Bits() <= 30 {
+			d.tapSubnet = subnet.Masked()
+			d.taps = newIDPool(0, tapSlots(subnet), errTapSubnetExhausted)
+		}
+	}
+	if cfg.Snapshot.Enabled {
+		if reason := snapshotUnsupported(cfg); reason != "" {
+			d.poolUnsupported = reason
+			slog.Error("firecracker: warm pool not started", "reason", reason)
+		} else {
+			d.pool = newSnapshotPool(d)
+			d.pool.start()
+			d.metrics.poolEnabled.Set(1)
+		}
+	}
+	return d
+}
+
+// snapshotUnsupported reports why the warm pool cannot serve cfg's VMs:
+// restored VMs run unjailed and have no TAP device.
+func snapshotUnsupported(cfg config.FirecrackerConfig) string {
+	switch {
+	case cfg.Jailer.Enabled:
+		return "firecracker.snapshot is not supported together with firecracker.jailer"
+	case cfg.NetworkMode == "tap":
+		return "firecracker.snapshot is not supported together with firecracker.network_mode tap"
+	}
+	return ""
+}
+
+// Collectors returns the driver's Prometheus collectors.
+// Implements sandbox.MetricsProvider.
+func (d *Driver) Collectors() []prometheus.Collector {
+	return d.metrics.collectors()
 }
 
-// New creates a Firecracker Driver with the given config.
-func New(cfg config.FirecrackerConfig) *Driver {
-	return &Driver{cfg: cfg}
+// EffectiveCapabilities reports that capabilities.secrets is unsupported:
+// the driver has no channel into the guest that leaves the rootfs and the
+// facility image untouched, so directives with secrets are rejected
+// (sandbox.SecretsDeliverer is not implemented).
+func (d *Driver) EffectiveCapabilities(protocol.Capabilities) map[string]any {
+	return map[string]any{"secrets": "unsupported"}
 }
 
 // Name returns "firecracker".
@@ -36,6 +104,11 @@ func (d *Driver) Name() string { return "firecracker" }
 func (d *Driver) HealthCheck(ctx context.Context) sandbox.HealthResult {
 	details := map[string]string{"driver": "firecracker"}
 
+	if d.poolUnsupported != "" {
+		details["error"] = d.poolUnsupported
+		return sandbox.HealthResult{Healthy: false, Details: details}
+	}
+
 	// 1. Check /dev/kvm is accessible for read/write.
 	f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0)
 	if err != nil {
@@ -45,11 +118,7 @@ func (d *Driver) HealthCheck(ctx context.Context) sandbox.HealthResult {
 	defer f.Close()
 
 	// 2. Check firecracker binary exists.
-	fcPath := d.cfg.FirecrackerPath
-	if fcPath == "" {
-		fcPath = "firecracker"
-	}
-	resolvedPath, err := exec.LookPath(fcPath)
+	resolvedPath, err := exec.LookPath(d.firecrackerPath())
 	if err != nil {
 		details["error"] = "firecracker binary not found in PATH"
 		return sandbox.HealthResult{Healthy: false, Details: details}
@@ -76,7 +145,34 @@ func (d *Driver) HealthCheck(ctx context.Context) sandbox.HealthResult {
 		return sandbox.HealthResult{Healthy: false, Details: details}
 	}
 
-	// 5. Check mke2fs (needed for workspace images).
+	// 5. Check the jailer when enabled. Through nexus-helper the jailer and
+	//    its privileges are the helper's.
+	switch {
+	case d.cfg.Jailer.Enabled && d.jail != nil:
+		details["jailer_path"] = "nexus-helper"
+	case d.cfg.Jailer.Enabled:
+		jailerPath, err := exec.LookPath(d.jailerPath())
+		if err != nil {
+			details["error"] = "jailer binary not found: " + d.jailerPath()
+			return sandbox.HealthResult{Healthy: false, Details: details}
+		}
+		details["jailer_path"] = jailerPath
+		if os.Geteuid() != 0 {
+			details["error"] = "jailer requires nexusd to run as root, or helper.socket_path"
+			return sandbox.HealthResult{Healthy: false, Details: details}
+		}
+	}
+
+	// 6. Tap mode routes guest traffic through the host.
+	if d.tapMode() {
+		if err := checkIPForward(); err != nil {
+			details["error"] = "network_mode tap: " + err.Error()
+			return sandbox.HealthResult{Healthy: false, Details: details}
+		}
+		details["network_mode"] = "tap"
+	}
+
+	// 7. Check mke2fs (needed for workspace images).
 	if _, err := exec.LookPath("mke2fs"); err != nil {
 		details["warning"] = "mke2fs not found (needed for workspace ext4 images)"
 	}
@@ -101,6 +197,8 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		return sandbox.RunResult{}, errors.New("FacilityPath is required for firecracker driver")
 	}
 
+	runStart := time.Now()
+
 	// 1. Create temp dir for ephemeral files.
 	tmpDir, err := os.MkdirTemp("", "nexus-fc-"+req.DirectiveID+"-")
 	if err != nil {
@@ -108,35 +206,41 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	}
 	defer os.RemoveAll(tmpDir)
 
+	// VM files live in tmpDir, or in the jailer chroot when jailed.
+	vm, err := d.prepareVMDir(ctx, req.DirectiveID, tmpDir)
+	if err != nil {
+		return sandbox.RunResult{}, err
+	}
+	defer vm.cleanup()
+
 	// 2. Start the egress proxy (UDS mode).
 	proxySocketDir := d.proxySocketDir(req)
 
 	auditWriter := io.Discard
-	if u, ok := req.LogSink.(interface {
-		UploadBytes(context.Context, string, []byte)
-	}); ok {
-		auditWriter = logSinkWriter{ctx: ctx, uploader: u, stream: "stderr"}
+	if req.Audit != nil {
+		auditWriter = req.Audit
 	}
 
 	proxyInst, err := egressproxy.StartForDirective(
-		proxySocketDir, req.DirectiveID, req.NetCapability, auditWriter,
+		proxySocketDir, req.DirectiveID, req.NetCapability, auditWriter, req.Egress,
 	)
 	if err != nil {
 		return sandbox.RunResult{}, fmt.Errorf("start egress proxy: %w", err)
 	}
 	defer proxyInst.Stop()
 
-	// 3. Start the vsock bridge (vsock UDS → egress proxy UDS).
-	vsockPath := filepath.Join(tmpDir, "vsock.sock")
-	vsockListenPath := vsockPath + "_9080"
-
-	bridge, err := egressproxy.StartVsockBridge(ctx, vsockListenPath, proxyInst.SocketPath())
+	// Attach a TAP device with nftables egress rules (network_mode: tap).
+	vmNet, err := d.setupNetwork(ctx, req, vm, proxyInst)
 	if err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("start vsock bridge: %w", err)
+		return sandbox.RunResult{}, err
+	}
+	var guestNet *GuestNetwork
+	if vmNet != nil {
+		defer vmNet.teardown()
+		guestNet = &vmNet.guest
 	}
-	defer bridge.Stop()
 
-	// 4. Generate wrapper script → create command ext4 image.
+	// 3. Generate wrapper script → create command ext4 image.
 	//    Include a per-execution nonce to prevent exit code spoofing.
 	nonce, err := generateNonce()
 	if err != nil {
@@ -180,77 +284,55 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		return sandbox.RunResult{}, fmt.Errorf("write wrapper script: %w", err)
 	}
 
-	cmdImagePath := filepath.Join(tmpDir, "cmd.ext4")
+	cmdImagePath := vm.hostPath("cmd.ext4")
 	if err := CreateImageFromDir(cmdDir, cmdImagePath, 1); err != nil {
 		return sandbox.RunResult{}, fmt.Errorf("create cmd image: %w", err)
 	}
-
-	// 5. Create workspace ext4 image from facility directory.
-	wsImagePath := filepath.Join(tmpDir, "workspace.ext4")
-	wsSizeMiB := d.cfg.WorkspaceSizeMiB
-	if wsSizeMiB <= 0 {
-		wsSizeMiB = 2048
-	}
-	if err := CreateImageFromDir(req.FacilityPath, wsImagePath, wsSizeMiB); err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("create workspace image: %w", err)
-	}
-
-	// 6. Build VM config JSON.
-	vmCfg := BuildVMConfig(VMConfigInput{
-		KernelPath:   d.cfg.KernelPath,
-		RootfsPath:   d.cfg.RootfsImagePath,
-		CmdImagePath: cmdImagePath,
-		WsImagePath:  wsImagePath,
-		VCPUs:        d.vcpus(),
-		MemSizeMiB:   d.memSizeMiB(),
-		VsockUDSPath: vsockPath,
-	})
-
-	cfgData, err := MarshalVMConfig(vmCfg)
-	if err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("marshal VM config: %w", err)
-	}
-
-	cfgPath := filepath.Join(tmpDir, "vm-config.json")
-	if err := os.WriteFile(cfgPath, cfgData, 0o644); err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("write VM config: %w", err)
-	}
-
-	// 7. Start firecracker.
-	fcPath := d.cfg.FirecrackerPath
-	if fcPath == "" {
-		fcPath = "firecracker"
+	if err := vm.own(cmdImagePath); err != nil {
+		return sandbox.RunResult{}, fmt.Errorf("chown cmd image: %w", err)
 	}
 
-	cmd := exec.CommandContext(ctx, fcPath, "--no-api", "--config-file", cfgPath)
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Env = minimalExecEnv()
-	cmd.Cancel = func() error {
-		pgid, err := syscall.Getpgid(cmd.Process.Pid)
-		if err == nil {
-			return syscall.Kill(-pgid, syscall.SIGKILL)
+	// 4. Create workspace ext4 image from facility directory, or attach the
+	//    facility's persistent image in block mode.
+	// The image size is the hard disk limit for the guest.
+	wsSizeMiB := d.workspaceSizeMiB(req.Limits)
+	var wsImagePath string
+	var warnings []string
+	if d.blockFacilities() {
+		img, imgWarnings, err := d.openFacilityImage(ctx, req.FacilityPath, wsSizeMiB)
+		if err != nil {
+			return sandbox.RunResult{}, fmt.Errorf("open facility image: %w", err)
+		}
+		defer img.close()
+		warnings = imgWarnings
+		if wsImagePath, err = vm.attach(img.path, "workspace.ext4"); err != nil {
+			return sandbox.RunResult{}, fmt.Errorf("attach facility image: %w", err)
+		}
+	} else {
+		wsImagePath = vm.hostPath("workspace.ext4")
+		if err := CreateImageFromDir(req.FacilityPath, wsImagePath, wsSizeMiB); err != nil {
+			return sandbox.RunResult{}, fmt.Errorf("create workspace image: %w", err)
 		}
-		return cmd.Process.Kill()
 	}
-
-	// Serial console output goes to stdout.
-	// We need to both stream it to the LogSink and capture NEXUS_EXIT_CODE.
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return sandbox.RunResult{}, err
+	if err := vm.own(wsImagePath); err != nil {
+		return sandbox.RunResult{}, fmt.Errorf("chown workspace image: %w", err)
 	}
-	stderr, err := cmd.StderrPipe()
+
+	// 6. Start the VM: restore a snapshot from the warm pool when one is
+	//    ready, otherwise boot it.
+	proc, err := d.startVM(ctx, vm, proxyInst, cmdImagePath, wsImagePath, guestNet)
 	if err != nil {
 		return sandbox.RunResult{}, err
 	}
+	defer proc.stop()
+	stdout, stderr := proc.stdout, proc.stderr
 
-	if err := cmd.Start(); err != nil {
-		return sandbox.RunResult{}, fmt.Errorf("start firecracker: %w", err)
-	}
-
-	// 8. Stream logs and capture exit code from serial output.
+	// 7. Stream logs and capture exit code from serial output.
 	serialCapture := newExitCodeCapture(exitMarker)
-	tee := io.TeeReader(stdout, serialCapture)
+	guestReady := newMarkerWatch(guestReadyMarker, func() {
+		d.metrics.startLatency.WithLabelValues(proc.mode).Observe(time.Since(runStart).Seconds())
+	})
+	tee := io.TeeReader(stdout, io.MultiWriter(serialCapture, guestReady))
 
 	errCh := make(chan error, 2)
 	go func() { errCh <- req.LogSink.Consume(ctx, "stdout", tee) }()
@@ -259,7 +341,7 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	consume1 := <-errCh
 	consume2 := <-errCh
 
-	waitErr := cmd.Wait()
+	waitErr := proc.wait()
 
 	// Parse exit code from captured serial output (nonce-tagged marker).
 	serialCapture.Flush()
@@ -283,6 +365,7 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	result := sandbox.RunResult{
 		ExitCode: exitCode,
 		Status:   status,
+		Warnings: warnings,
 	}
 
 	if tr, ok := req.LogSink.(truncationReporter); ok {
@@ -298,8 +381,17 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		result.Status = "canceled"
 	}
 
-	// 9. Extract workspace changes back to facility directory.
-	if result.Status == "succeeded" || result.Status == "failed" {
+	// A failed run on a full workspace image hit limits.disk_mb.
+	if req.Limits.DiskMB > 0 && result.Status == "failed" {
+		if full, err := ImageFull(wsImagePath); err == nil && full {
+			result.Status = sandbox.StatusDiskQuotaExceeded
+		}
+	}
+
+	// 8. Extract workspace changes back to facility directory. A block-backed
+	//    facility stays in its image; the daemon exports it when needed.
+	extract := result.Status == "succeeded" || result.Status == "failed" || result.Status == sandbox.StatusDiskQuotaExceeded
+	if extract && !d.blockFacilities() {
 		if extractErr := ExtractImageToDir(wsImagePath, req.FacilityPath); extractErr != nil {
 			// Log but don't fail the directive — the command itself succeeded/failed.
 			// Surface as a warning so callers can report it.
@@ -317,6 +409,316 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	return result, nil
 }
 
+// vmProcess is a started VMM. Its serial console is on stdout; wait returns
+// once it has exited, and stop then releases what the VM used on the host.
+type vmProcess struct {
+	wait   func() error
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+	mode   string // "cold", "warm" (pooled VM) or "restore" (restored on demand)
+	stop   func()
+}
+
+// startVM starts the directive's VM with the given command and workspace
+// images, falling back to a cold boot if a snapshot restore fails.
+func (d *Driver) startVM(ctx context.Context, vm *vmDir, proxy *egressproxy.Instance, cmdImagePath, wsImagePath string, guestNet *GuestNetwork) (*vmProcess, error) {
+	if d.pool != nil {
+		if w, mode := d.pool.take(ctx); w != nil {
+			proc, err := w.handoff(ctx, mode, proxy, cmdImagePath, wsImagePath)
+			if err == nil {
+				return proc, nil
+			}
+			slog.Warn("firecracker: snapshot restore failed, booting cold", "error", err)
+		}
+	}
+	return d.bootCold(ctx, vm, proxy, cmdImagePath, wsImagePath, guestNet)
+}
+
+// startVsockBridges bridges the guest's vsock ports to the directive's egress
+// proxy and DNS resolver sockets. vsockPath is the VMM's host-side vsock UDS;
+// own, when set, hands each listening socket to the VMM's uid.
+func startVsockBridges(ctx context.Context, vsockPath string, proxy *egressproxy.Instance, own func(string) error) (stop func(), err error) {
+	var bridges []*egressproxy.VsockBridge
+	stop = func() {
+		for _, b := range bridges {
+			b.Stop()
+		}
+	}
+	defer func() {
+		if err != nil {
+			stop()
+		}
+	}()
+	for _, b := range []struct {
+		port   int
+		target string
+	}{
+		{guestProxyPort, proxy.SocketPath()},
+		{guestDNSPort, proxy.DNSSocketPath()},
+	} {
+		listenPath := fmt.Sprintf("%s_%d", vsockPath, b.port)
+		bridge, err := egressproxy.StartVsockBridge(ctx, listenPath, b.target)
+		if err != nil {
+			return nil, fmt.Errorf("start vsock bridge: %w", err)
+		}
+		bridges = append(bridges, bridge)
+		if own != nil {
+			if err := own(listenPath); err != nil {
+				return nil, fmt.Errorf("chown vsock bridge socket: %w", err)
+			}
+		}
+	}
+	return stop, nil
+}
+
+// bootCold boots a fresh VM from the kernel.
+func (d *Driver) bootCold(ctx context.Context, vm *vmDir, proxy *egressproxy.Instance, cmdImagePath, wsImagePath string, guestNet *GuestNetwork) (*vmProcess, error) {
+	// Start the vsock bridges (vsock UDS → egress proxy / DNS UDS).
+	stopBridges, err := startVsockBridges(ctx, vm.hostPath("vsock.sock"), proxy, vm.own)
+	if err != nil {
+		return nil, err
+	}
+	started := false
+	defer func() {
+		if !started {
+			stopBridges()
+		}
+	}()
+
+	// Build the VM config.
+	kernelPath, err := vm.stage(d.cfg.KernelPath, "vmlinux")
+	if err != nil {
+		return nil, fmt.Errorf("stage kernel: %w", err)
+	}
+	rootfsPath, err := vm.stage(d.cfg.RootfsImagePath, "rootfs.ext4")
+	if err != nil {
+		return nil, fmt.Errorf("stage rootfs: %w", err)
+	}
+
+	vmCfg := BuildVMConfig(VMConfigInput{
+		KernelPath:   kernelPath,
+		RootfsPath:   rootfsPath,
+		CmdImagePath: vm.vmPathOf(cmdImagePath),
+		WsImagePath:  vm.vmPathOf(wsImagePath),
+		VCPUs:        d.vcpus(),
+		MemSizeMiB:   d.memSizeMiB(),
+		VsockUDSPath: vm.vmPath("vsock.sock"),
+		Network:      guestNet,
+	})
+
+	cfgData, err := MarshalVMConfig(vmCfg)
+	if err != nil {
+		return nil, fmt.Errorf("marshal VM config: %w", err)
+	}
+
+	if err := os.WriteFile(vm.hostPath("vm-config.json"), cfgData, 0o644); err != nil {
+		return nil, fmt.Errorf("write VM config: %w", err)
+	}
+
+	// Through nexus-helper the helper starts (and owns) the jailer.
+	if vm.jail != nil {
+		proc, err := d.launchJailed(ctx, vm, vm.vmPath("vm-config.json"))
+		if err != nil {
+			return nil, err
+		}
+		started = true
+		stopKill := proc.stop
+		proc.stop = func() {
+			stopKill()
+			stopBridges()
+		}
+		return proc, nil
+	}
+
+	// Start firecracker (directly, or through the jailer).
+	cmd, err := d.command(ctx, vm, vm.vmPath("vm-config.json"))
+	if err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = minimalExecEnv()
+	cmd.Cancel = func() error {
+		pgid, err := syscall.Getpgid(cmd.Process.Pid)
+		if err == nil {
+			return syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+		return cmd.Process.Kill()
+	}
+
+	// Serial console output goes to stdout.
+	// We need to both stream it to the LogSink and capture NEXUS_EXIT_CODE.
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start firecracker: %w", err)
+	}
+	started = true
+	return &vmProcess{wait: cmd.Wait, stdout: stdout, stderr: stderr, mode: "cold", stop: stopBridges}, nil
+}
+
+// launchJailed starts the VMM through nexus-helper, handing it the VMM's
+// stdio. Canceling ctx kills the VMM; wait reports its exit code as a
+// jailExitError.
+func (d *Driver) launchJailed(ctx context.Context, vm *vmDir, cfgPath string) (*vmProcess, error) {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return nil, err
+	}
+	defer devNull.Close()
+	stdout, stdoutW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, stderrW, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutW.Close()
+		return nil, err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, jailCallTimeout)
+	err = vm.jail.LaunchJail(callCtx, helper.JailerLaunchParams{
+		DirectiveID:   vm.directiveID,
+		UID:           vm.uid,
+		Netns:         vm.netns != nil,
+		MemoryMB:      d.memSizeMiB() + vmmMemOverheadMiB,
+		CPUMillicores: d.vcpus() * 1000,
+		Args:          []string{"--no-api", "--config-file", cfgPath},
+	}, devNull, stdoutW, stderrW)
+	cancel()
+	// The VMM holds the write ends now; ours must close for reads to end
+	// when it exits.
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, fmt.Errorf("start firecracker through nexus-helper: %w", err)
+	}
+
+	stopKill := context.AfterFunc(ctx, func() {
+		killCtx, cancel := context.WithTimeout(context.Background(), jailCallTimeout)
+		defer cancel()
+		if err := vm.jail.KillJail(killCtx, vm.directiveID); err != nil {
+			slog.Warn("firecracker: killing jailed VMM failed", "directive_id", vm.directiveID, "error", err)
+		}
+	})
+	return &vmProcess{
+		wait: func() error {
+			// Keep waiting after ctx ends: the kill above makes it return.
+			code, err := vm.jail.WaitJail(context.WithoutCancel(ctx), vm.directiveID)
+			if err != nil {
+				return err
+			}
+			if code != 0 {
+				return &jailExitError{code: code}
+			}
+			return nil
+		},
+		stdout: stdout,
+		stderr: stderr,
+		mode:   "cold",
+		stop:   func() { stopKill() },
+	}, nil
+}
+
+// jailExitError is a non-zero exit of a VMM started through nexus-helper.
+type jailExitError struct {
+	code int
+}
+
+func (e *jailExitError) Error() string {
+	return fmt.Sprintf("jailed firecracker exited with code %d", e.code)
+}
+
+// prepareVMDir picks where the VM's files live. Without the jailer that is
+// tmpDir; with it, a fresh chroot for a uid taken from the pool, created by
+// nexus-helper when configured.
+func (d *Driver) prepareVMDir(ctx context.Context, directiveID, tmpDir string) (*vmDir, error) {
+	if !d.cfg.Jailer.Enabled {
+		return &vmDir{dir: tmpDir}, nil
+	}
+	uid, err := d.uids.acquire(directiveID)
+	if err != nil {
+		return nil, err
+	}
+	id := helper.JailerID(directiveID)
+	vm := &vmDir{
+		dir:          JailerChrootDir(d.cfg.Jailer.ChrootBaseDir, d.firecrackerPath(), id),
+		jailed:       true,
+		directiveID:  directiveID,
+		id:           id,
+		uid:          uid,
+		gid:          uid,
+		cgroupDir:    filepath.Join(cgroupV2Root, d.cfg.Jailer.ParentCgroup, id),
+		jail:         d.jail,
+		releaseOwner: func() { d.uids.release(uid) },
+	}
+	if err := vm.create(ctx); err != nil {
+		vm.cleanup()
+		return nil, fmt.Errorf("prepare jailer chroot: %w", err)
+	}
+	return vm, nil
+}
+
+func (d *Driver) firecrackerPath() string {
+	if d.cfg.FirecrackerPath != "" {
+		return d.cfg.FirecrackerPath
+	}
+	return "firecracker"
+}
+
+func (d *Driver) jailerPath() string {
+	if d.cfg.Jailer.JailerPath != "" {
+		return d.cfg.Jailer.JailerPath
+	}
+	return "jailer"
+}
+
+// command builds the VMM command. cfgPath is the config file as the VMM
+// sees it (inside the chroot when jailed).
+func (d *Driver) command(ctx context.Context, vm *vmDir, cfgPath string) (*exec.Cmd, error) {
+	if !vm.jailed {
+		return exec.CommandContext(ctx, d.firecrackerPath(), "--no-api", "--config-file", cfgPath), nil
+	}
+
+	// The jailer requires an absolute --exec-file.
+	fcPath, err := exec.LookPath(d.firecrackerPath())
+	if err != nil {
+		return nil, fmt.Errorf("resolve firecracker binary: %w", err)
+	}
+	if fcPath, err = filepath.Abs(fcPath); err != nil {
+		return nil, fmt.Errorf("resolve firecracker binary: %w", err)
+	}
+	var seccompFilter string
+	if d.cfg.Jailer.SeccompFilter != "" {
+		if seccompFilter, err = vm.stage(d.cfg.Jailer.SeccompFilter, "seccomp.bpf"); err != nil {
+			return nil, fmt.Errorf("stage seccomp filter: %w", err)
+		}
+	}
+	args := BuildJailerArgs(JailerArgsInput{
+		FirecrackerPath: fcPath,
+		ID:              vm.id,
+		UID:             vm.uid,
+		GID:             vm.gid,
+		ChrootBaseDir:   d.cfg.Jailer.ChrootBaseDir,
+		ParentCgroup:    d.cfg.Jailer.ParentCgroup,
+		MemoryMaxMiB:    d.memSizeMiB() + vmmMemOverheadMiB,
+		VCPUs:           d.vcpus(),
+		SeccompLevel:    d.cfg.Jailer.SeccompLevel,
+		SeccompFilter:   seccompFilter,
+		ConfigFile:      cfgPath,
+	})
+	return exec.CommandContext(ctx, d.jailerPath(), args...), nil
+}
+
 func (d *Driver) proxySocketDir(req sandbox.RunRequest) string {
 	if d.cfg.ProxySocketDir != "" {
 		return d.cfg.ProxySocketDir
@@ -324,6 +726,19 @@ func (d *Driver) proxySocketDir(req sandbox.RunRequest) string {
 	return filepath.Join(filepath.Dir(req.FacilityPath), ".proxy-sockets")
 }
 
+// workspaceSizeMiB sizes the workspace image: limits.disk_mb when set,
+// capped by firecracker.workspace_size_mib.
+func (d *Driver) workspaceSizeMiB(limits protocol.Limits) int {
+	size := d.cfg.WorkspaceSizeMiB
+	if size <= 0 {
+		size = 2048
+	}
+	if limits.DiskMB > 0 && limits.DiskMB < size {
+		size = limits.DiskMB
+	}
+	return size
+}
+
 func (d *Driver) vcpus() int {
 	if d.cfg.VCPUs > 0 {
 		return d.cfg.VCPUs
@@ -338,19 +753,6 @@ func (d *Driver) memSizeMiB() int {
 	return 512
 }
 
-type logSinkWriter struct {
-	ctx      context.Context
-	uploader interface {
-		UploadBytes(context.Context, string, []byte)
-	}
-	stream string
-}
-
-func (w logSinkWriter) Write(p []byte) (int, error) {
-	w.uploader.UploadBytes(w.ctx, w.stream, p)
-	return len(p), nil
-}
-
 func statusFrom(waitErr error, ctx context.Context) string {
 	if waitErr == nil {
 		return "succeeded"
@@ -377,6 +779,10 @@ func processExitCode(waitErr error) int {
 	if waitErr == nil {
 		return 0
 	}
+	var je *jailExitError
+	if errors.As(waitErr, &je) {
+		return je.code
+	}
 	var ee *exec.ExitError
 	if errors.As(waitErr, &ee) {
 		if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
@@ -0,0 +1,178 @@
+//go:build linux
+
+package firecracker
+
+import (
+	"context"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/helper"
+	"cybros.ai/nexus/protocol"
+	"cybros.ai/nexus/sandbox"
+)
+
+func TestWorkspaceSizeMiB(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    int
+		diskMB int
+		want   int
+	}{
+		{"default", 0, 0, 2048},
+		{"config only", 4096, 0, 4096},
+		{"disk limit below config", 4096, 512, 512},
+		{"disk limit capped by config", 1024, 8192, 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(config.FirecrackerConfig{WorkspaceSizeMiB: tt.cfg}, nil)
+			if got := d.workspaceSizeMiB(protocol.Limits{DiskMB: tt.diskMB}); got != tt.want {
+				t.Errorf("workspaceSizeMiB = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeJailer stands in for nexus-helper: Launch writes to the VMM's stdout
+// and the VMM "exits" with exitCode once killed.
+type fakeJailer struct {
+	root     string
+	exitCode int
+
+	mu       sync.Mutex
+	prepared int
+	launch   helper.JailerLaunchParams
+	killed   chan struct{}
+	cleaned  bool
+}
+
+func (f *fakeJailer) PrepareJail(_ context.Context, _ string, uid int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.prepared = uid
+	return f.root, nil
+}
+
+func (f *fakeJailer) LaunchJail(_ context.Context, p helper.JailerLaunchParams, _, stdout, _ *os.File) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.launch = p
+	_, err := stdout.WriteString("serial")
+	return err
+}
+
+func (f *fakeJailer) WaitJail(ctx context.Context, _ string) (int, error) {
+	select {
+	case <-f.killed:
+		return f.exitCode, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+func (f *fakeJailer) KillJail(context.Context, string) error {
+	close(f.killed)
+	return nil
+}
+
+func (f *fakeJailer) CleanupJail(context.Context, string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cleaned = true
+	return nil
+}
+
+func TestLaunchJailed_ThroughHelper(t *testing.T) {
+	jail := &fakeJailer{root: t.TempDir(), exitCode: 137, killed: make(chan struct{})}
+	d := New(config.FirecrackerConfig{
+		VCPUs:      2,
+		MemSizeMiB: 256,
+		Jailer:     config.JailerConfig{Enabled: true, UIDRangeStart: 900000, UIDRangeSize: 4},
+	}, jail)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	vm, err := d.prepareVMDir(ctx, "d1", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.dir != jail.root || jail.prepared != vm.uid || vm.uid < 900000 || vm.uid >= 900004 {
+		t.Fatalf("vm dir %q uid %d, prepared for %d", vm.dir, vm.uid, jail.prepared)
+	}
+
+	img := vm.hostPath("cmd.ext4")
+	if err := os.WriteFile(img, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.own(img); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(img); fi.Mode().Perm() != 0o660 {
+		t.Errorf("own: mode = %v, want group read-write", fi.Mode())
+	}
+
+	// Without a TAP device the VMM gets a netns of its own.
+	network := &fakeTapNetwork{}
+	if vmNet, err := d.setupNetwork(ctx, sandbox.RunRequest{DirectiveID: "d1", Network: network}, vm, nil); err != nil || vmNet != nil {
+		t.Fatalf("setupNetwork = %v, %v", vmNet, err)
+	}
+
+	proc, err := d.launchJailed(ctx, vm, vm.vmPath("vm-config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := helper.JailerLaunchParams{
+		DirectiveID:   "d1",
+		UID:           vm.uid,
+		Netns:         true,
+		MemoryMB:      256 + vmmMemOverheadMiB,
+		CPUMillicores: 2000,
+		Args:          []string{"--no-api", "--config-file", "/vm-config.json"},
+	}
+	if got := jail.launch; got.DirectiveID != want.DirectiveID || got.UID != want.UID || got.Netns != want.Netns || got.MemoryMB != want.MemoryMB ||
+		got.CPUMillicores != want.CPUMillicores || len(got.Args) != 3 || got.Args[2] != want.Args[2] {
+		t.Errorf("launch params = %+v, want %+v", got, want)
+	}
+	if out, _ := io.ReadAll(proc.stdout); string(out) != "serial" {
+		t.Errorf("stdout = %q", out)
+	}
+
+	// Canceling the directive kills the VMM through the helper.
+	cancel()
+	waitErr := proc.wait()
+	if code := processExitCode(waitErr); code != 137 {
+		t.Errorf("exit code = %d (%v), want 137", code, waitErr)
+	}
+	if status := statusFrom(waitErr, ctx); status != "canceled" {
+		t.Errorf("status = %q, want canceled", status)
+	}
+	proc.stop()
+
+	vm.cleanup()
+	if !jail.cleaned {
+		t.Error("jail not cleaned up")
+	}
+	if !slices.Equal(network.netns, []string{"create d1", "delete d1"}) {
+		t.Errorf("netns calls = %q", network.netns)
+	}
+	// A released uid is handed to the same directive again.
+	if uid, err := d.uids.acquire("d1"); err != nil || uid != vm.uid {
+		t.Errorf("uid not released: got %d, %v", uid, err)
+	}
+}
+
+func TestDriver_SecretsUnsupported(t *testing.T) {
+	var d sandbox.Driver = New(config.FirecrackerConfig{}, nil)
+	if _, ok := d.(sandbox.SecretsDeliverer); ok {
+		t.Fatal("firecracker must not claim to deliver secrets")
+	}
+	eff := d.(sandbox.CapabilityReporter).EffectiveCapabilities(protocol.Capabilities{})
+	if eff["secrets"] != "unsupported" {
+		t.Fatalf("secrets = %v, want unsupported", eff["secrets"])
+	}
+}
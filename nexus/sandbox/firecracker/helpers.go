@@ -111,3 +111,41 @@ func minimalExecEnv() []string {
 		"LC_ALL=C",
 	}
 }
+
+// markerWatch is an io.Writer that calls onSeen the first time a line
+// containing marker is written. Like exitCodeCapture it buffers partial lines.
+type markerWatch struct {
+	marker  string
+	onSeen  func()
+	seen    bool
+	partial string
+}
+
+func newMarkerWatch(marker string, onSeen func()) *markerWatch {
+	return &markerWatch{marker: marker, onSeen: onSeen}
+}
+
+func (w *markerWatch) Write(p []byte) (int, error) {
+	if w.seen {
+		return len(p), nil
+	}
+	data := w.partial + string(p)
+	w.partial = ""
+	for {
+		idx := strings.IndexByte(data, '\n')
+		if idx < 0 {
+			if len(data) > 4096 {
+				data = data[len(data)-4096:]
+			}
+			w.partial = data
+			return len(p), nil
+		}
+		if strings.Contains(data[:idx], w.marker) {
+			w.seen = true
+			w.partial = ""
+			w.onSeen()
+			return len(p), nil
+		}
+		data = data[idx+1:]
+	}
+}
@@ -0,0 +1,285 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"cybros.ai/nexus/sandbox"
+)
+
+// vmmMemOverheadMiB is added to the guest memory for the jailed VMM's
+// memory.max, covering Firecracker's own allocations.
+const vmmMemOverheadMiB = 64
+
+// JailerArgsInput holds the inputs for a jailer command line.
+type JailerArgsInput struct {
+	FirecrackerPath string // --exec-file (absolute)
+	ID              string // --id
+	UID             int
+	GID             int
+	ChrootBaseDir   string
+	ParentCgroup    string
+	MemoryMaxMiB    int    // memory.max for the VMM cgroup; 0 = unset
+	VCPUs           int    // cpu.max quota in whole CPUs; 0 = unset
+	SeccompLevel    string // "default" or "none"
+	SeccompFilter   string // path inside the chroot; empty = built-in filters
+	ConfigFile      string // --config-file, path inside the chroot
+}
+
+// BuildJailerArgs builds the jailer argument list (without the jailer binary).
+// Arguments after "--" are passed to firecracker, whose paths are relative to
+// the chroot.
+func BuildJailerArgs(in JailerArgsInput) []string {
+	args := []string{
+		"--id", in.ID,
+		"--exec-file", in.FirecrackerPath,
+		"--uid", strconv.Itoa(in.UID),
+		"--gid", strconv.Itoa(in.GID),
+		"--chroot-base-dir", in.ChrootBaseDir,
+		"--cgroup-version", "2",
+	}
+	if in.ParentCgroup != "" {
+		args = append(args, "--parent-cgroup", in.ParentCgroup)
+	}
+	if in.MemoryMaxMiB > 0 {
+		args = append(args, "--cgroup", fmt.Sprintf("memory.max=%d", int64(in.MemoryMaxMiB)<<20))
+	}
+	if in.VCPUs > 0 {
+		args = append(args, "--cgroup", fmt.Sprintf("cpu.max=%d 100000", in.VCPUs*100000))
+	}
+
+	args = append(args, "--", "--no-api", "--config-file", in.ConfigFile)
+	switch {
+	case in.SeccompLevel == "none":
+		args = append(args, "--no-seccomp")
+	case in.SeccompFilter != "":
+		args = append(args, "--seccomp-filter", in.SeccompFilter)
+	}
+	return args
+}
+
+// JailerChrootDir returns the chroot the jailer builds for id:
+// <base>/<exec-file name>/<id>/root.
+func JailerChrootDir(chrootBase, firecrackerPath, id string) string {
+	return filepath.Join(chrootBase, filepath.Base(firecrackerPath), id, "root")
+}
+
+// idPool hands out ids from [start, start+size): jailer uids, and tap
+// subnet slots. A directive's preferred id is derived from its ID so reruns
+// tend to reuse the same one.
+type idPool struct {
+	mu        sync.Mutex
+	start     int
+	size      int
+	inUse     map[int]bool
+	exhausted error
+}
+
+func newIDPool(start, size int, exhausted error) *idPool {
+	return &idPool{start: start, size: size, inUse: make(map[int]bool), exhausted: exhausted}
+}
+
+var errUIDPoolExhausted = errors.New("jailer uid pool exhausted")
+
+func newUIDPool(start, size int) *idPool {
+	return newIDPool(start, size, errUIDPoolExhausted)
+}
+
+func (p *idPool) acquire(directiveID string) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size <= 0 {
+		return 0, p.exhausted
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(directiveID))
+	first := int(h.Sum32() % uint32(p.size))
+	for i := 0; i < p.size; i++ {
+		id := p.start + (first+i)%p.size
+		if !p.inUse[id] {
+			p.inUse[id] = true
+			return id, nil
+		}
+	}
+	return 0, p.exhausted
+}
+
+func (p *idPool) release(id int) {
+	p.mu.Lock()
+	delete(p.inUse, id)
+	p.mu.Unlock()
+}
+
+// linkOrCopy hard-links src to dst, copying when they are on different
+// filesystems (or links are otherwise refused).
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// copyFile copies src to a new file dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// cgroupV2Root is where the jailer creates VMM cgroups.
+const cgroupV2Root = "/sys/fs/cgroup"
+
+// jailCallTimeout bounds a nexus-helper jailer call that is not waiting for
+// the VMM.
+const jailCallTimeout = 10 * time.Second
+
+// vmDir is the directory holding a VM's files. hostPath is where nexusd
+// reads/writes a file; vmPath is how the VMM refers to it (chroot-relative
+// when jailed).
+//
+// A jailed VM's chroot is set up by nexusd itself (running as root), or by
+// nexus-helper when jail is set. Then nexusd owns the chroot, which is
+// setgid to the VM's gid, and hands files to the VMM through the group.
+type vmDir struct {
+	dir    string
+	jailed bool
+
+	// Jailer-only fields.
+	directiveID  string
+	id           string
+	uid, gid     int
+	cgroupDir    string
+	jail         sandbox.Jailer
+	netns        sandbox.TapNetwork // set when the VMM runs in its own netns
+	releaseOwner func()
+}
+
+func (v *vmDir) hostPath(name string) string { return filepath.Join(v.dir, name) }
+
+func (v *vmDir) vmPath(name string) string {
+	if v.jailed {
+		return "/" + name
+	}
+	return v.hostPath(name)
+}
+
+// vmPathOf maps a file in the VM directory, or a file outside it when
+// unjailed, to the path the VMM should use.
+func (v *vmDir) vmPathOf(hostPath string) string {
+	if v.jailed {
+		return v.vmPath(filepath.Base(hostPath))
+	}
+	return hostPath
+}
+
+// create makes a fresh chroot owned by the VM's uid (or, through the helper,
+// writable by its group), so the VMM can create its vsock socket there.
+// Anything left by a crashed run is removed first.
+func (v *vmDir) create(ctx context.Context) error {
+	if v.jail != nil {
+		ctx, cancel := context.WithTimeout(ctx, jailCallTimeout)
+		defer cancel()
+		root, err := v.jail.PrepareJail(ctx, v.directiveID, v.uid)
+		if err != nil {
+			return err
+		}
+		v.dir = root
+		return nil
+	}
+	if err := os.RemoveAll(filepath.Dir(v.dir)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(v.dir, 0o750); err != nil {
+		return err
+	}
+	return os.Chown(v.dir, v.uid, v.gid)
+}
+
+// own hands a per-directive file to the VM's uid/gid (jailed only). Through
+// the helper the file already has the VM's gid (from the setgid chroot) and
+// is made group read-write instead.
+func (v *vmDir) own(path string) error {
+	if !v.jailed {
+		return nil
+	}
+	if v.jail != nil {
+		return os.Chmod(path, 0o660)
+	}
+	return os.Lchown(path, v.uid, v.gid)
+}
+
+// stage makes a shared, read-only input (kernel, rootfs, seccomp filter)
+// visible to the VMM and returns the path it should use.
+func (v *vmDir) stage(src, name string) (string, error) {
+	if !v.jailed {
+		return src, nil
+	}
+	if err := linkOrCopy(src, v.hostPath(name)); err != nil {
+		return "", err
+	}
+	return v.vmPath(name), nil
+}
+
+// attach makes a writable, long-lived file (a block-backed facility image)
+// visible to the VMM and returns its host path. Jailed VMs get a hard link in
+// the chroot; a copy would not persist the guest's writes.
+func (v *vmDir) attach(src, name string) (string, error) {
+	if !v.jailed {
+		return src, nil
+	}
+	dst := v.hostPath(name)
+	if err := os.Link(src, dst); err != nil {
+		return "", fmt.Errorf("%w (facility_image_dir must be on the jailer chroot filesystem)", err)
+	}
+	return dst, nil
+}
+
+// cleanup removes the jail (chroot, the VMM's cgroup and netns) and returns
+// the uid to the pool. The cgroup is only removable once the VMM has exited.
+func (v *vmDir) cleanup() {
+	if !v.jailed {
+		return
+	}
+	if v.jail != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), jailCallTimeout)
+		if err := v.jail.CleanupJail(ctx, v.directiveID); err != nil {
+			slog.Warn("firecracker: jail cleanup failed", "directive_id", v.directiveID, "error", err)
+		}
+		if v.netns != nil {
+			if err := v.netns.DeleteNetns(ctx, v.directiveID); err != nil {
+				slog.Warn("firecracker: netns cleanup failed", "directive_id", v.directiveID, "error", err)
+			}
+			v.netns = nil
+		}
+		cancel()
+	} else {
+		_ = os.RemoveAll(filepath.Dir(v.dir))
+		if v.cgroupDir != "" {
+			_ = os.Remove(v.cgroupDir)
+		}
+	}
+	if v.releaseOwner != nil {
+		v.releaseOwner()
+		v.releaseOwner = nil
+	}
+}
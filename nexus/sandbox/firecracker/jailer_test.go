@@ -0,0 +1,209 @@
+package firecracker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBuildJailerArgs(t *testing.T) {
+	args := BuildJailerArgs(JailerArgsInput{
+		FirecrackerPath: "/usr/local/bin/firecracker",
+		ID:              "nexus-0123456789",
+		UID:             900007,
+		GID:             900007,
+		ChrootBaseDir:   "/srv/jailer",
+		ParentCgroup:    "nexus-firecracker",
+		MemoryMaxMiB:    576,
+		VCPUs:           2,
+		SeccompLevel:    "default",
+		ConfigFile:      "/vm-config.json",
+	})
+
+	want := []string{
+		"--id", "nexus-0123456789",
+		"--exec-file", "/usr/local/bin/firecracker",
+		"--uid", "900007",
+		"--gid", "900007",
+		"--chroot-base-dir", "/srv/jailer",
+		"--cgroup-version", "2",
+		"--parent-cgroup", "nexus-firecracker",
+		"--cgroup", "memory.max=603979776",
+		"--cgroup", "cpu.max=200000 100000",
+		"--", "--no-api", "--config-file", "/vm-config.json",
+	}
+	if !slices.Equal(args, want) {
+		t.Errorf("args =\n  %q\nwant\n  %q", args, want)
+	}
+}
+
+func TestBuildJailerArgs_Seccomp(t *testing.T) {
+	base := JailerArgsInput{FirecrackerPath: "/fc", ID: "x", ChrootBaseDir: "/j", ConfigFile: "/c.json"}
+
+	none := base
+	none.SeccompLevel = "none"
+	if args := BuildJailerArgs(none); args[len(args)-1] != "--no-seccomp" {
+		t.Errorf("seccomp none: args = %q", args)
+	}
+
+	filter := base
+	filter.SeccompLevel = "default"
+	filter.SeccompFilter = "/seccomp.bpf"
+	args := BuildJailerArgs(filter)
+	if got := strings.Join(args[len(args)-2:], " "); got != "--seccomp-filter /seccomp.bpf" {
+		t.Errorf("seccomp filter: args = %q", args)
+	}
+
+	// Firecracker flags come after "--", jailer flags before.
+	sep := slices.Index(args, "--")
+	if sep < 0 || slices.Index(args, "--seccomp-filter") < sep || slices.Index(args, "--uid") > sep {
+		t.Errorf("flags on the wrong side of --: %q", args)
+	}
+}
+
+func TestJailerChrootDir(t *testing.T) {
+	got := JailerChrootDir("/srv/jailer", "/usr/local/bin/firecracker", "nexus-abc")
+	if want := "/srv/jailer/firecracker/nexus-abc/root"; got != want {
+		t.Errorf("chroot = %q, want %q", got, want)
+	}
+}
+
+func TestUIDPool(t *testing.T) {
+	p := newUIDPool(900000, 2)
+
+	a, err := p.acquire("dir-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.acquire("dir-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatalf("same uid handed out twice: %d", a)
+	}
+	for _, uid := range []int{a, b} {
+		if uid < 900000 || uid >= 900002 {
+			t.Errorf("uid %d outside range", uid)
+		}
+	}
+	if _, err := p.acquire("dir-c"); err != errUIDPoolExhausted {
+		t.Fatalf("expected pool exhausted, got %v", err)
+	}
+
+	p.release(a)
+	c, err := p.acquire("dir-c")
+	if err != nil || c != a {
+		t.Fatalf("released uid not reused: got %d, %v (want %d)", c, err, a)
+	}
+}
+
+func TestVMDir_Unjailed(t *testing.T) {
+	vm := &vmDir{dir: "/tmp/nexus-fc-x"}
+	if got := vm.vmPath("cmd.ext4"); got != "/tmp/nexus-fc-x/cmd.ext4" {
+		t.Errorf("vmPath = %q", got)
+	}
+	if got, err := vm.stage("/opt/vmlinux", "vmlinux"); err != nil || got != "/opt/vmlinux" {
+		t.Errorf("stage = %q, %v; want the source path", got, err)
+	}
+	if err := vm.own("/nonexistent"); err != nil {
+		t.Errorf("own should be a no-op: %v", err)
+	}
+	vm.cleanup() // must not remove anything
+}
+
+func TestVMDir_JailedStage(t *testing.T) {
+	base := t.TempDir()
+	src := filepath.Join(base, "vmlinux")
+	if err := os.WriteFile(src, []byte("kernel"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	released := false
+	vm := &vmDir{
+		dir:          filepath.Join(base, "jail", "firecracker", "nexus-x", "root"),
+		jailed:       true,
+		uid:          os.Getuid(),
+		gid:          os.Getgid(),
+		releaseOwner: func() { released = true },
+	}
+	if err := vm.create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := vm.stage(src, "vmlinux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/vmlinux" {
+		t.Errorf("vmPath = %q, want /vmlinux", got)
+	}
+	data, err := os.ReadFile(vm.hostPath("vmlinux"))
+	if err != nil || string(data) != "kernel" {
+		t.Fatalf("staged file = %q, %v", data, err)
+	}
+
+	vm.cleanup()
+	if _, err := os.Stat(filepath.Dir(vm.dir)); !os.IsNotExist(err) {
+		t.Errorf("jail dir not removed: %v", err)
+	}
+	if !released {
+		t.Error("uid not released")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("source removed with the jail: %v", err)
+	}
+}
+
+func TestLinkOrCopy_RefusesExisting(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	if err := os.WriteFile(src, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, []byte("b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkOrCopy(src, dst); err == nil {
+		t.Fatal("expected error for existing destination")
+	}
+}
+
+func TestVMDir_Attach(t *testing.T) {
+	base := t.TempDir()
+	img := filepath.Join(base, "fac.ext4")
+	if err := os.WriteFile(img, []byte("image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	unjailed := &vmDir{dir: filepath.Join(base, "tmp")}
+	if got, err := unjailed.attach(img, "workspace.ext4"); err != nil || got != img {
+		t.Errorf("unjailed attach = %q, %v; want the image itself", got, err)
+	}
+	if got := unjailed.vmPathOf(img); got != img {
+		t.Errorf("unjailed vmPathOf = %q", got)
+	}
+
+	jailed := &vmDir{dir: filepath.Join(base, "jail", "root"), jailed: true}
+	if err := os.MkdirAll(jailed.dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	got, err := jailed.attach(img, "workspace.ext4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != jailed.hostPath("workspace.ext4") || jailed.vmPathOf(got) != "/workspace.ext4" {
+		t.Errorf("jailed attach = %q (vm %q)", got, jailed.vmPathOf(got))
+	}
+	// Writes through the link reach the persistent image.
+	if err := os.WriteFile(got, []byte("written"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(img); string(data) != "written" {
+		t.Errorf("image = %q, want writes to persist", data)
+	}
+}
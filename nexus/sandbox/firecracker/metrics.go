@@ -0,0 +1,41 @@
+package firecracker
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// metrics are the driver's Prometheus metrics, registered by the daemon via
+// sandbox.MetricsProvider.
+type metrics struct {
+	startLatency   *prometheus.HistogramVec
+	poolReady      prometheus.Gauge
+	poolEnabled    prometheus.Gauge
+	templateBuilds *prometheus.CounterVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		startLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "nexusd_firecracker_start_seconds",
+			Help:    "Time from Run until the guest starts the directive command, by start mode (cold, warm, restore).",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32},
+		}, []string{"mode"}),
+
+		poolReady: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nexusd_firecracker_pool_ready",
+			Help: "Restored, paused VMs waiting in the warm pool.",
+		}),
+
+		poolEnabled: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "nexusd_firecracker_pool_enabled",
+			Help: "1 while the snapshot warm pool is in use; 0 when it is off or cannot run with the jailer/network mode.",
+		}),
+
+		templateBuilds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nexusd_firecracker_snapshot_builds_total",
+			Help: "Snapshot template builds, by result (ok, error).",
+		}, []string{"result"}),
+	}
+}
+
+func (m *metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.startLatency, m.poolReady, m.poolEnabled, m.templateBuilds}
+}
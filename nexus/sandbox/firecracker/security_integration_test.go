@@ -100,7 +100,7 @@ func newTestDriver(t *testing.T) *Driver {
 		VCPUs:            1,
 		MemSizeMiB:       256,
 		WorkspaceSizeMiB: 64,
-	})
+	}, nil)
 }
 
 // --- I1: Filesystem Isolation ---
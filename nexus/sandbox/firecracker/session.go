@@ -0,0 +1,313 @@
+//go:build linux
+
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"cybros.ai/nexus/egressproxy"
+	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/sessionagent"
+)
+
+const (
+	// sessionStopTimeout is how long a session's VM may take to power off
+	// after its agent was told to stop, before it is killed.
+	sessionStopTimeout = 10 * time.Second
+	// maxSessionSetupLog bounds the boot and setup output passed on to the
+	// opener.
+	maxSessionSetupLog = 1 << 20
+	// sessionCmdImageSlackMiB is the command disk's room beyond the agent
+	// binary (wrapper script, ext4 metadata).
+	sessionCmdImageSlackMiB = 8
+)
+
+// OpenSession implements sandbox.SessionRunner: it boots the VM as Run
+// would, but the wrapper's last step runs the session agent (a copy of this
+// binary, on the command disk), which connects back over vsock. Commands
+// run as root in the guest like Run's. After each command the guest's
+// workspace is frozen while the image is extracted to the facility, so the
+// facility sees the session's files as in a run.
+//
+// Sessions always boot cold (the warm pool's VMs run a fixed command disk)
+// and are not supported with facility_mode: block, whose image the daemon
+// exports between directives.
+func (d *Driver) OpenSession(ctx context.Context, req sandbox.RunRequest) (_ sandbox.Session, err error) {
+	if req.FacilityPath == "" {
+		return nil, errors.New("FacilityPath is required for firecracker driver")
+	}
+	if d.blockFacilities() {
+		return nil, errors.New("firecracker sessions are not supported with facility_mode: block")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("locate session agent: %w", err)
+	}
+
+	// The VM outlives the directive opening the session; Close ends it.
+	vmCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	var undo []func()
+	release := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	undo = append(undo, cancel)
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	tmpDir, err := os.MkdirTemp("", "nexus-fc-"+req.DirectiveID+"-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	undo = append(undo, func() { os.RemoveAll(tmpDir) })
+
+	vm, err := d.prepareVMDir(vmCtx, req.DirectiveID, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	undo = append(undo, vm.cleanup)
+
+	auditWriter := io.Discard
+	if req.Audit != nil {
+		auditWriter = req.Audit
+	}
+	proxyInst, err := egressproxy.StartForDirective(
+		d.proxySocketDir(req), req.DirectiveID, req.NetCapability, auditWriter, req.Egress,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("start egress proxy: %w", err)
+	}
+	undo = append(undo, proxyInst.Stop)
+
+	vmNet, err := d.setupNetwork(vmCtx, req, vm, proxyInst)
+	if err != nil {
+		return nil, err
+	}
+	var guestNet *GuestNetwork
+	if vmNet != nil {
+		undo = append(undo, vmNet.teardown)
+		guestNet = &vmNet.guest
+	}
+
+	// Command disk: the wrapper and the agent.
+	wrapperCfg := WrapperConfig{Shell: req.Shell, Env: req.Env, SessionAgent: true}
+	if req.RepoURL != "" {
+		cloneArgs, cloneEnv, cloneErr := sandbox.PrepareGitCloneArgs(req.RepoURL)
+		if cloneErr != nil {
+			return nil, fmt.Errorf("prepare git clone: %w", cloneErr)
+		}
+		wrapperCfg.RepoURL = req.RepoURL
+		wrapperCfg.GitCloneArgs = cloneArgs
+		wrapperCfg.GitCloneEnv = cloneEnv
+	}
+	wrapperScript, err := GenerateWrapper(wrapperCfg)
+	if err != nil {
+		return nil, fmt.Errorf("generate wrapper: %w", err)
+	}
+	cmdDir := filepath.Join(tmpDir, "cmd")
+	if err := os.MkdirAll(cmdDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cmd dir: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(cmdDir, "run.sh"), []byte(wrapperScript), 0o755); err != nil {
+		return nil, fmt.Errorf("write wrapper script: %w", err)
+	}
+	agentPath := filepath.Join(cmdDir, filepath.Base(guestSessionAgent))
+	if err := copyFile(self, agentPath); err != nil {
+		return nil, fmt.Errorf("copy session agent: %w", err)
+	}
+	if err := os.Chmod(agentPath, 0o755); err != nil {
+		return nil, fmt.Errorf("copy session agent: %w", err)
+	}
+	fi, err := os.Stat(agentPath)
+	if err != nil {
+		return nil, fmt.Errorf("copy session agent: %w", err)
+	}
+	cmdImagePath := vm.hostPath("cmd.ext4")
+	if err := CreateImageFromDir(cmdDir, cmdImagePath, int(fi.Size()>>20)+sessionCmdImageSlackMiB); err != nil {
+		return nil, fmt.Errorf("create cmd image: %w", err)
+	}
+	if err := vm.own(cmdImagePath); err != nil {
+		return nil, fmt.Errorf("chown cmd image: %w", err)
+	}
+
+	wsImagePath := vm.hostPath("workspace.ext4")
+	if err := CreateImageFromDir(req.FacilityPath, wsImagePath, d.workspaceSizeMiB(req.Limits)); err != nil {
+		return nil, fmt.Errorf("create workspace image: %w", err)
+	}
+	if err := vm.own(wsImagePath); err != nil {
+		return nil, fmt.Errorf("chown workspace image: %w", err)
+	}
+
+	// The agent connects to guestSessionPort, which the VMM forwards to
+	// this socket; listen before the guest can get there.
+	agentSocket := fmt.Sprintf("%s_%d", vm.hostPath("vsock.sock"), guestSessionPort)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: agentSocket, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("listen for session agent: %w", err)
+	}
+	if err := vm.own(agentSocket); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("chown session agent socket: %w", err)
+	}
+
+	proc, err := d.bootCold(vmCtx, vm, proxyInst, cmdImagePath, wsImagePath, guestNet)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	undo = append(undo, proc.stop)
+
+	// Keep the boot and setup output for the opener; once the agent is up
+	// the console only tells about the guest shutting down.
+	bootLog := &setupLog{}
+	exited := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		for _, r := range []io.Reader{proc.stdout, proc.stderr} {
+			go func() {
+				defer wg.Done()
+				_, _ = io.Copy(bootLog, r)
+			}()
+		}
+		wg.Wait()
+		_ = proc.wait()
+		close(exited)
+	}()
+	stop := func() {
+		select {
+		case <-exited:
+		case <-time.After(sessionStopTimeout):
+		}
+		cancel()
+		<-exited
+		release()
+	}
+	undo = append(undo, func() {
+		cancel()
+		<-exited
+	})
+
+	agent, err := sessionagent.AcceptRelay(ctx, l, exited)
+	if req.LogSink != nil {
+		_ = req.LogSink.Consume(ctx, "stderr", bytes.NewReader(bootLog.done()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session{
+		AgentSession: sandbox.NewAgentSession(agent, sessionCommand, stop),
+		agent:        agent,
+		wsImagePath:  wsImagePath,
+		facilityPath: req.FacilityPath,
+		diskLimited:  req.Limits.DiskMB > 0,
+	}, nil
+}
+
+// session is an AgentSession whose workspace lives in the VM's image and is
+// extracted to the facility after each command.
+type session struct {
+	*sandbox.AgentSession
+	agent        *sessionagent.Conn
+	wsImagePath  string
+	facilityPath string
+	diskLimited  bool
+}
+
+// Exec implements sandbox.Session.
+func (s *session) Exec(ctx context.Context, req sandbox.RunRequest) (sandbox.RunResult, error) {
+	result, err := s.AgentSession.Exec(ctx, req)
+	extract := result.Status == "succeeded" || result.Status == "failed"
+	if !extract {
+		return result, err
+	}
+
+	// Freezing flushes the guest's writes, so the image reads consistently
+	// until the thaw.
+	if freezeErr := s.agent.Freeze(guestWorkspace); freezeErr != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("workspace extraction skipped: %v", freezeErr))
+		return result, err
+	}
+	// A failed command on a full workspace image hit limits.disk_mb.
+	if s.diskLimited && result.Status == "failed" {
+		if full, fullErr := ImageFull(s.wsImagePath); fullErr == nil && full {
+			result.Status = sandbox.StatusDiskQuotaExceeded
+		}
+	}
+	if extractErr := ExtractImageToDir(s.wsImagePath, s.facilityPath); extractErr != nil {
+		msg := fmt.Sprintf("workspace extraction error: %v", extractErr)
+		fmt.Fprintf(os.Stderr, "firecracker: %s\n", msg)
+		result.Warnings = append(result.Warnings, msg)
+	}
+	if thawErr := s.agent.Thaw(guestWorkspace); thawErr != nil {
+		result.Warnings = append(result.Warnings, fmt.Sprintf("workspace thaw: %v", thawErr))
+	}
+	return result, err
+}
+
+// sessionCommand maps a session exec to what the session agent runs inside
+// the guest: the shell command in its resolved cwd, with the request's env
+// on top of the environment the wrapper exported.
+func sessionCommand(req sandbox.RunRequest) (sessionagent.Request, error) {
+	cwd, err := resolveCwd(req.Cwd)
+	if err != nil {
+		return sessionagent.Request{}, err
+	}
+	shell := req.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	keys := make([]string, 0, len(req.Env))
+	for k := range req.Env {
+		if !validEnvKeyRe.MatchString(k) {
+			return sessionagent.Request{}, fmt.Errorf("invalid env key: %q", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+req.Env[k])
+	}
+	return sessionagent.Request{Argv: []string{shell, "-c", req.Command}, Dir: cwd, Env: env}, nil
+}
+
+// setupLog keeps the first maxSessionSetupLog bytes of a session VM's
+// output until done, and discards it after.
+type setupLog struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (l *setupLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed && l.buf.Len() < maxSessionSetupLog {
+		l.buf.Write(p[:min(len(p), maxSessionSetupLog-l.buf.Len())])
+	}
+	return len(p), nil
+}
+
+// done returns what was kept and stops keeping more.
+func (l *setupLog) done() []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return l.buf.Bytes()
+}
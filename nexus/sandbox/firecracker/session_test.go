@@ -0,0 +1,65 @@
+//go:build linux
+
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+
+	"cybros.ai/nexus/config"
+	"cybros.ai/nexus/sandbox"
+)
+
+func TestSessionCommand(t *testing.T) {
+	req, err := sessionCommand(sandbox.RunRequest{
+		Command: "make test",
+		Shell:   "/bin/bash",
+		Cwd:     "sub",
+		Env:     map[string]string{"B": "2", "A": "1"},
+	})
+	if err != nil {
+		t.Fatalf("sessionCommand: %v", err)
+	}
+	if want := []string{"/bin/bash", "-c", "make test"}; !slices.Equal(req.Argv, want) {
+		t.Errorf("argv = %q, want %q", req.Argv, want)
+	}
+	if req.Dir != "/workspace/sub" {
+		t.Errorf("dir = %q", req.Dir)
+	}
+	if want := []string{"A=1", "B=2"}; !slices.Equal(req.Env, want) {
+		t.Errorf("env = %q, want %q", req.Env, want)
+	}
+
+	if _, err := sessionCommand(sandbox.RunRequest{Command: "ls", Cwd: "../etc"}); err == nil {
+		t.Error("expected an error for a cwd outside the workspace")
+	}
+	if _, err := sessionCommand(sandbox.RunRequest{Command: "ls", Env: map[string]string{"BAD;KEY": "x"}}); err == nil {
+		t.Error("expected an error for an invalid env key")
+	}
+}
+
+func TestOpenSession_BlockFacilitiesRejected(t *testing.T) {
+	d := &Driver{cfg: config.FirecrackerConfig{FacilityMode: "block"}}
+	if _, err := d.OpenSession(context.Background(), sandbox.RunRequest{FacilityPath: t.TempDir()}); err == nil {
+		t.Fatal("expected sessions to be rejected in block facility mode")
+	}
+}
+
+func TestSetupLog(t *testing.T) {
+	l := &setupLog{}
+	big := bytes.Repeat([]byte("x"), maxSessionSetupLog)
+	l.Write([]byte("boot\n"))
+	if n, err := l.Write(big); n != len(big) || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	kept := l.done()
+	if len(kept) != maxSessionSetupLog || !bytes.HasPrefix(kept, []byte("boot\n")) {
+		t.Errorf("kept %d bytes, want the first %d", len(kept), maxSessionSetupLog)
+	}
+	l.Write([]byte("shutdown\n"))
+	if len(l.buf.Bytes()) != maxSessionSetupLog {
+		t.Error("kept output after done")
+	}
+}
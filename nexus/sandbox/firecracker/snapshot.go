@@ -0,0 +1,504 @@
+//go:build linux
+
+package firecracker
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"cybros.ai/nexus/egressproxy"
+)
+
+// Guest protocol for snapshot mode (see tools/build-fc-rootfs.sh). With
+// snapshotBootArg on the kernel command line, nexus-init prints
+// snapshotReadyMarker and blocks on vsock port handoffPort before mounting
+// the command/workspace drives. The host snapshots it there; after a
+// restore it swaps the drives and connects to handoffPort, sending the wall
+// clock and an RNG seed. guestReadyMarker is printed right before the
+// command runs in both modes.
+const (
+	snapshotBootArg     = "nexus.snapshot=1"
+	snapshotReadyMarker = "NEXUS_SNAPSHOT_READY"
+	guestReadyMarker    = "NEXUS_GUEST_READY"
+	handoffPort         = 9081
+)
+
+const (
+	// poolRefreshInterval is how often the pool checks the template (asset
+	// changes, max_age) besides being woken after each take.
+	poolRefreshInterval = 30 * time.Second
+	// templateRetryDelay throttles template builds after a failure.
+	templateRetryDelay = time.Minute
+	// apiSocketTimeout bounds waiting for a restored VMM's API socket.
+	apiSocketTimeout = 5 * time.Second
+)
+
+// snapshotTemplate is a snapshot of a VM paused in nexus-init, waiting for
+// the handoff. The command/workspace drive and vsock paths inside it are
+// relative, so a restore resolves them against the restoring VMM's working
+// directory; the rootfs is pinned in the template directory.
+type snapshotTemplate struct {
+	key   string
+	dir   string
+	built time.Time
+}
+
+func (t *snapshotTemplate) snapPath() string { return filepath.Join(t.dir, "vm.snap") }
+func (t *snapshotTemplate) memPath() string  { return filepath.Join(t.dir, "vm.mem") }
+
+// placeholders are the template's command/workspace images. Restored VMs
+// open copies of them until handoff points the drives at the real images.
+var placeholders = []string{"cmd.ext4", "workspace.ext4"}
+
+// warmVM is a VMM restored from a template and paused.
+type warmVM struct {
+	dir    string
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+	api    *apiClient
+}
+
+// discard kills the VMM and removes its directory.
+func (w *warmVM) discard() {
+	_ = syscall.Kill(-w.cmd.Process.Pid, syscall.SIGKILL)
+	_ = w.cmd.Wait()
+	_ = os.RemoveAll(w.dir)
+}
+
+// handoff gives a paused VM the directive's drives and resumes it. On error
+// the VM has been discarded.
+func (w *warmVM) handoff(ctx context.Context, mode string, proxy *egressproxy.Instance, cmdImagePath, wsImagePath string) (_ *vmProcess, err error) {
+	defer func() {
+		if err != nil {
+			w.discard()
+		}
+	}()
+
+	stopBridges, err := startVsockBridges(ctx, filepath.Join(w.dir, "vsock.sock"), proxy, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			stopBridges()
+		}
+	}()
+
+	if err := w.api.updateDrive(ctx, "cmd", cmdImagePath); err != nil {
+		return nil, err
+	}
+	if err := w.api.updateDrive(ctx, "workspace", wsImagePath); err != nil {
+		return nil, err
+	}
+	if err := w.api.resume(ctx); err != nil {
+		return nil, err
+	}
+	if err := sendHandoff(ctx, filepath.Join(w.dir, "vsock.sock")); err != nil {
+		return nil, err
+	}
+
+	// The directive's context now owns the VMM.
+	stopKill := context.AfterFunc(ctx, func() { _ = syscall.Kill(-w.cmd.Process.Pid, syscall.SIGKILL) })
+	return &vmProcess{
+		wait:   w.cmd.Wait,
+		stdout: w.stdout,
+		stderr: w.stderr,
+		mode:   mode,
+		stop: func() {
+			stopKill()
+			stopBridges()
+			_ = os.RemoveAll(w.dir)
+		},
+	}, nil
+}
+
+// sendHandoff connects to the guest's handoff port through the VMM's vsock
+// socket and sends the current time and a fresh RNG seed. Restored VMs would
+// otherwise share the template's clock and entropy pool.
+func sendHandoff(ctx context.Context, vsockPath string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", vsockPath)
+	if err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(apiSocketTimeout))
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", handoffPort); err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("handoff: unexpected vsock reply %q", strings.TrimSpace(line))
+	}
+
+	seed := make([]byte, 64)
+	if _, err := rand.Read(seed); err != nil {
+		return err
+	}
+	msg := append([]byte(strconv.FormatInt(time.Now().Unix(), 10)+"\n"), seed...)
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	return nil
+}
+
+// snapshotPool owns the current template and a set of restored, paused VMs.
+type snapshotPool struct {
+	d   *Driver
+	dir string
+
+	wake chan struct{}
+
+	mu       sync.Mutex
+	tmpl     *snapshotTemplate
+	ready    []*warmVM
+	lastFail time.Time
+}
+
+func newSnapshotPool(d *Driver) *snapshotPool {
+	// Snapshot paths are handed to VMMs running in other directories.
+	dir, err := filepath.Abs(d.cfg.Snapshot.Dir)
+	if err != nil {
+		dir = d.cfg.Snapshot.Dir
+	}
+	return &snapshotPool{
+		d:    d,
+		dir:  dir,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// start clears what a previous nexusd left behind (its VMs died with it)
+// and starts filling the pool in the background.
+func (p *snapshotPool) start() {
+	_ = os.RemoveAll(filepath.Join(p.dir, "templates"))
+	_ = os.RemoveAll(filepath.Join(p.dir, "vms"))
+	go p.loop()
+}
+
+func (p *snapshotPool) loop() {
+	ticker := time.NewTicker(poolRefreshInterval)
+	defer ticker.Stop()
+	for {
+		p.refresh(context.Background())
+		select {
+		case <-p.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *snapshotPool) kick() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns a paused VM and how it was obtained: "warm" from the pool, or
+// "restore" when restored on demand because the pool was empty. It returns
+// nil when no template is ready; the caller then boots cold.
+func (p *snapshotPool) take(ctx context.Context) (*warmVM, string) {
+	p.mu.Lock()
+	var w *warmVM
+	if len(p.ready) > 0 {
+		w = p.ready[0]
+		p.ready = p.ready[1:]
+		p.d.metrics.poolReady.Set(float64(len(p.ready)))
+	}
+	tmpl := p.tmpl
+	p.mu.Unlock()
+	p.kick()
+
+	if w != nil {
+		return w, "warm"
+	}
+	if tmpl == nil {
+		return nil, ""
+	}
+	w, err := p.restore(ctx, tmpl)
+	if err != nil {
+		slog.Warn("firecracker: on-demand snapshot restore failed", "error", err)
+		return nil, ""
+	}
+	return w, "restore"
+}
+
+// refresh rebuilds the template when it is missing, stale or expired, then
+// tops the pool up to pool_size.
+func (p *snapshotPool) refresh(ctx context.Context) {
+	key, err := p.d.templateKey()
+	if err != nil {
+		slog.Warn("firecracker: snapshot template key", "error", err)
+		return
+	}
+
+	p.mu.Lock()
+	tmpl, lastFail := p.tmpl, p.lastFail
+	p.mu.Unlock()
+
+	maxAge := p.d.cfg.Snapshot.MaxAge
+	if tmpl == nil || tmpl.key != key || (maxAge > 0 && time.Since(tmpl.built) > maxAge) {
+		if time.Since(lastFail) < templateRetryDelay {
+			return
+		}
+		nt, err := p.buildTemplate(ctx, key)
+		if err != nil {
+			p.d.metrics.templateBuilds.WithLabelValues("error").Inc()
+			slog.Warn("firecracker: snapshot template build failed; booting cold", "error", err)
+			p.mu.Lock()
+			p.lastFail = time.Now()
+			p.mu.Unlock()
+			return
+		}
+		p.d.metrics.templateBuilds.WithLabelValues("ok").Inc()
+
+		p.mu.Lock()
+		old, stale := p.tmpl, p.ready
+		p.tmpl, p.ready = nt, nil
+		p.d.metrics.poolReady.Set(0)
+		p.mu.Unlock()
+
+		for _, w := range stale {
+			w.discard()
+		}
+		// Restored VMs map the memory file privately, so it can go now.
+		if old != nil {
+			_ = os.RemoveAll(old.dir)
+		}
+		tmpl = nt
+	}
+
+	for {
+		p.mu.Lock()
+		n := len(p.ready)
+		p.mu.Unlock()
+		if n >= p.d.cfg.Snapshot.PoolSize {
+			return
+		}
+		w, err := p.restore(ctx, tmpl)
+		if err != nil {
+			slog.Warn("firecracker: warm pool restore failed", "error", err)
+			return
+		}
+		p.mu.Lock()
+		if p.tmpl != tmpl {
+			p.mu.Unlock()
+			w.discard()
+			return
+		}
+		p.ready = append(p.ready, w)
+		p.d.metrics.poolReady.Set(float64(len(p.ready)))
+		p.mu.Unlock()
+	}
+}
+
+// buildTemplate boots a VM in snapshot mode, waits for nexus-init to reach
+// the handoff point and snapshots it.
+func (p *snapshotPool) buildTemplate(ctx context.Context, key string) (*snapshotTemplate, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.d.cfg.Snapshot.BootTimeout)
+	defer cancel()
+
+	dir := filepath.Join(p.dir, "templates", key+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	emptyDir := filepath.Join(dir, "empty")
+	if err := os.Mkdir(emptyDir, 0o700); err != nil {
+		return nil, err
+	}
+	for _, name := range placeholders {
+		if err := CreateImageFromDir(emptyDir, filepath.Join(dir, name), 1); err != nil {
+			return nil, fmt.Errorf("create placeholder %s: %w", name, err)
+		}
+	}
+	_ = os.Remove(emptyDir)
+
+	// The guest's page cache in the snapshot reflects this exact rootfs, so
+	// pin it: replacing the configured image must not change what restores
+	// of this template see.
+	rootfsPath := filepath.Join(dir, "rootfs.ext4")
+	if err := linkOrCopy(p.d.cfg.RootfsImagePath, rootfsPath); err != nil {
+		return nil, fmt.Errorf("pin rootfs: %w", err)
+	}
+
+	vmCfg := BuildVMConfig(VMConfigInput{
+		KernelPath:   p.d.cfg.KernelPath,
+		RootfsPath:   rootfsPath,
+		CmdImagePath: "cmd.ext4",
+		WsImagePath:  "workspace.ext4",
+		VCPUs:        p.d.vcpus(),
+		MemSizeMiB:   p.d.memSizeMiB(),
+		VsockUDSPath: "vsock.sock",
+	})
+	vmCfg.BootSource.BootArgs += " " + snapshotBootArg
+	cfgData, err := MarshalVMConfig(vmCfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "vm-config.json"), cfgData, 0o600); err != nil {
+		return nil, err
+	}
+
+	cmd := p.vmmCommand(dir, "--config-file", "vm-config.json")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start template VM: %w", err)
+	}
+	defer func() {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		_ = cmd.Wait()
+	}()
+
+	ready := make(chan struct{})
+	go func() {
+		w := newMarkerWatch(snapshotReadyMarker, func() { close(ready) })
+		_, _ = io.Copy(w, stdout)
+	}()
+	select {
+	case <-ready:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("template VM did not reach %s (does the rootfs nexus-init support snapshot mode?): %w", snapshotReadyMarker, ctx.Err())
+	}
+
+	api := newAPIClient(filepath.Join(dir, "api.sock"))
+	if err := api.pause(ctx); err != nil {
+		return nil, err
+	}
+	if err := api.createSnapshot(ctx, "vm.snap", "vm.mem"); err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{"api.sock", "vsock.sock", "vm-config.json"} {
+		_ = os.Remove(filepath.Join(dir, name))
+	}
+	ok = true
+	return &snapshotTemplate{key: key, dir: dir, built: time.Now()}, nil
+}
+
+// restore starts a VMM and loads tmpl into it, leaving the VM paused.
+func (p *snapshotPool) restore(ctx context.Context, tmpl *snapshotTemplate) (_ *warmVM, err error) {
+	vmsDir := filepath.Join(p.dir, "vms")
+	if err := os.MkdirAll(vmsDir, 0o700); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(vmsDir, "vm-")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range placeholders {
+		if err := copyFile(filepath.Join(tmpl.dir, name), filepath.Join(dir, name)); err != nil {
+			_ = os.RemoveAll(dir)
+			return nil, err
+		}
+	}
+
+	cmd := p.vmmCommand(dir)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("start VMM: %w", err)
+	}
+	w := &warmVM{dir: dir, cmd: cmd, stdout: stdout, stderr: stderr, api: newAPIClient(filepath.Join(dir, "api.sock"))}
+	defer func() {
+		if err != nil {
+			w.discard()
+		}
+	}()
+
+	if err := waitForSocket(ctx, filepath.Join(dir, "api.sock"), apiSocketTimeout); err != nil {
+		return nil, err
+	}
+	if err := w.api.loadSnapshot(ctx, tmpl.snapPath(), tmpl.memPath()); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// vmmCommand returns a firecracker command with its API socket in dir. The
+// VMM dies with nexusd so pooled VMs never outlive it.
+func (p *snapshotPool) vmmCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command(p.d.firecrackerPath(), append([]string{"--api-sock", "api.sock"}, args...)...)
+	cmd.Dir = dir
+	cmd.Env = minimalExecEnv()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	return cmd
+}
+
+// templateKey identifies the inputs a snapshot depends on: a new kernel,
+// rootfs or firecracker binary (or VM shape) needs a new template.
+func (d *Driver) templateKey() (string, error) {
+	fcPath, err := exec.LookPath(d.firecrackerPath())
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, path := range []string{fcPath, d.cfg.KernelPath, d.cfg.RootfsImagePath} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", path, fi.Size(), fi.ModTime().UnixNano())
+	}
+	fmt.Fprintf(h, "%d\x00%d\x00%s", d.vcpus(), d.memSizeMiB(), defaultBootArgs)
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// waitForSocket polls until path accepts connections.
+func waitForSocket(ctx context.Context, path string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("VMM API socket %s not ready: %w", path, err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,169 @@
+//go:build linux
+
+package firecracker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/config"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSendHandoff(t *testing.T) {
+	// Fake the VMM side of Firecracker's host-initiated vsock protocol.
+	sock := filepath.Join(t.TempDir(), "vsock.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type received struct {
+		connect string
+		payload []byte
+	}
+	got := make(chan received, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		line, _ := r.ReadString('\n')
+		_, _ = conn.Write([]byte("OK 1073741824\n"))
+		payload, _ := io.ReadAll(r)
+		got <- received{line, payload}
+	}()
+
+	before := time.Now().Unix()
+	if err := sendHandoff(context.Background(), sock); err != nil {
+		t.Fatal(err)
+	}
+	r := <-got
+	if r.connect != "CONNECT 9081\n" {
+		t.Errorf("connect line = %q", r.connect)
+	}
+	clock, seed, ok := bytes.Cut(r.payload, []byte("\n"))
+	if !ok {
+		t.Fatalf("payload has no clock line: %q", r.payload)
+	}
+	if now, err := strconv.ParseInt(string(clock), 10, 64); err != nil || now < before {
+		t.Errorf("clock = %q, want unix time >= %d", clock, before)
+	}
+	if len(seed) != 64 {
+		t.Errorf("seed length = %d, want 64", len(seed))
+	}
+}
+
+func TestSendHandoff_Refused(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "vsock.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+		// Firecracker closes the connection when nothing listens on the port.
+	}()
+
+	if err := sendHandoff(context.Background(), sock); err == nil {
+		t.Fatal("expected error when the guest is not listening")
+	}
+}
+
+func TestTemplateKey_ChangesWithAssets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(data), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	d := New(config.FirecrackerConfig{
+		FirecrackerPath: write("firecracker", "#!/bin/sh\n"),
+		KernelPath:      write("vmlinux", "kernel"),
+		RootfsImagePath: write("rootfs.ext4", "rootfs"),
+	}, nil)
+
+	k1, err := d.templateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k2, _ := d.templateKey(); k2 != k1 {
+		t.Fatalf("key not stable: %s vs %s", k1, k2)
+	}
+
+	write("rootfs.ext4", "rootfs v2")
+	if k3, _ := d.templateKey(); k3 == k1 {
+		t.Error("key unchanged after rootfs update")
+	}
+
+	d.cfg.MemSizeMiB = 1024
+	k4, _ := d.templateKey()
+	d.cfg.MemSizeMiB = 0
+	if k5, _ := d.templateKey(); k4 == k5 {
+		t.Error("key unchanged after mem_size_mib change")
+	}
+}
+
+func TestSnapshotPool_TakeWithoutTemplate(t *testing.T) {
+	d := New(config.FirecrackerConfig{}, nil)
+	p := newSnapshotPool(d)
+	if w, mode := p.take(context.Background()); w != nil || mode != "" {
+		t.Fatalf("take without template = %v, %q; want cold boot", w, mode)
+	}
+}
+
+func TestNew_SnapshotUnsupported(t *testing.T) {
+	for _, cfg := range []config.FirecrackerConfig{
+		{Snapshot: config.SnapshotConfig{Enabled: true}, Jailer: config.JailerConfig{Enabled: true}},
+		{Snapshot: config.SnapshotConfig{Enabled: true}, NetworkMode: "tap"},
+	} {
+		d := New(cfg, nil)
+		if d.pool != nil {
+			t.Fatalf("pool started for %+v", cfg)
+		}
+		if got := testutil.ToFloat64(d.metrics.poolEnabled); got != 0 {
+			t.Errorf("pool_enabled = %v, want 0", got)
+		}
+		res := d.HealthCheck(context.Background())
+		if res.Healthy || !strings.Contains(res.Details["error"], "firecracker.snapshot") {
+			t.Errorf("health = %+v, want the unsupported snapshot reported", res)
+		}
+	}
+}
+
+func TestMarkerWatch(t *testing.T) {
+	calls := 0
+	w := newMarkerWatch(guestReadyMarker, func() { calls++ })
+	for _, chunk := range []string{"[ 0.1] boot\nNEXUS_GUE", "ST_READY\nout", "put\nNEXUS_GUEST_READY\n"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("onSeen called %d times, want 1", calls)
+	}
+	if strings.Contains(w.partial, "NEXUS") {
+		t.Errorf("partial not cleared: %q", w.partial)
+	}
+}
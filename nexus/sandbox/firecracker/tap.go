@@ -0,0 +1,148 @@
+//go:build linux
+
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"cybros.ai/nexus/egressproxy"
+	"cybros.ai/nexus/sandbox"
+)
+
+// tapTeardownTimeout bounds removing a VM's TAP device and rules.
+const tapTeardownTimeout = 10 * time.Second
+
+// vmNetwork is a VM's TAP attachment (network_mode: tap).
+type vmNetwork struct {
+	guest    GuestNetwork
+	teardown func()
+}
+
+func (d *Driver) tapMode() bool { return d.cfg.NetworkMode == "tap" }
+
+// setupNetwork attaches the VM to a fresh TAP device with egress rules
+// derived from the directive's net capability. The guest's resolver shares
+// the proxy's policy, so the proxy also dials the addresses the guest saw.
+// It returns nil when the VM gets no network device: vsock mode, or a
+// capability that allows nothing. A VMM jailed through nexus-helper then
+// runs in a network namespace of its own (see isolateVMM).
+func (d *Driver) setupNetwork(ctx context.Context, req sandbox.RunRequest, vm *vmDir, proxy *egressproxy.Instance) (*vmNetwork, error) {
+	if !d.tapMode() || req.NetCapability == nil || req.NetCapability.Mode == "none" {
+		return nil, d.isolateVMM(ctx, req, vm)
+	}
+	if req.Network == nil {
+		return nil, errors.New("firecracker network_mode tap requires nexus-helper")
+	}
+
+	if d.taps == nil {
+		return nil, fmt.Errorf("invalid firecracker tap_subnet %q", d.cfg.TapSubnet)
+	}
+	slot, err := d.taps.acquire(req.DirectiveID)
+	if err != nil {
+		return nil, err
+	}
+	var undo []func()
+	teardown := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}
+	undo = append(undo, func() { d.taps.release(slot) })
+
+	hostIP, guestIP := tapAddrs(d.tapSubnet, slot)
+	owner := 0
+	if vm.jailed {
+		owner = vm.uid
+	}
+	tap, err := req.Network.CreateTap(ctx, req.DirectiveID, netip.PrefixFrom(hostIP, 30).String(), owner)
+	if err != nil {
+		teardown()
+		return nil, fmt.Errorf("create tap: %w", err)
+	}
+	undo = append(undo, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tapTeardownTimeout)
+		defer cancel()
+		if err := req.Network.RemoveTap(ctx, req.DirectiveID); err != nil {
+			slog.Warn("firecracker: tap teardown failed", "directive_id", req.DirectiveID, "error", err)
+		}
+	})
+
+	egress, err := newTapEgress(req.Network, req.DirectiveID, req.NetCapability.Mode == "unrestricted", hostIP, proxy.PrefixEntries())
+	if err != nil {
+		teardown()
+		return nil, err
+	}
+	resolver := proxy.NewResolver()
+	resolver.IPv4Only = true // the TAP link has no IPv6
+	resolver.Admit = egress.permit
+	dns, err := egressproxy.StartDNSServer(net.JoinHostPort(hostIP.String(), "0"), resolver.Resolve)
+	if err != nil {
+		teardown()
+		return nil, fmt.Errorf("start DNS resolver: %w", err)
+	}
+	undo = append(undo, func() { _ = dns.Close() })
+	egress.dnsPort = dns.Addr().(*net.UDPAddr).Port
+
+	if err := egress.apply(ctx); err != nil {
+		teardown()
+		return nil, fmt.Errorf("apply egress rules: %w", err)
+	}
+	if exp := proxy.GrantExpiresAt(); !exp.IsZero() {
+		t := time.AfterFunc(time.Until(exp), func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tapTeardownTimeout)
+			defer cancel()
+			if err := egress.expire(ctx); err != nil {
+				// Fail closed: without its TAP device the VM has no network.
+				slog.Error("firecracker: revoking expired grant failed, removing tap", "directive_id", req.DirectiveID, "error", err)
+				_ = req.Network.RemoveTap(ctx, req.DirectiveID)
+			}
+		})
+		undo = append(undo, func() { t.Stop() })
+	}
+
+	return &vmNetwork{
+		guest: GuestNetwork{
+			TapDevice: tap,
+			GuestMAC:  tapGuestMAC(guestIP),
+			GuestCIDR: netip.PrefixFrom(guestIP, 30).String(),
+			Gateway:   hostIP.String(),
+		},
+		teardown: teardown,
+	}, nil
+}
+
+// isolateVMM has nexus-helper create a network namespace holding only a
+// loopback device for a jailed VMM without a TAP device: it reaches the
+// egress proxy through its vsock sockets and needs no host network. The
+// namespace goes with the jail (vmDir.cleanup).
+func (d *Driver) isolateVMM(ctx context.Context, req sandbox.RunRequest, vm *vmDir) error {
+	if vm.jail == nil || req.Network == nil {
+		return nil
+	}
+	if err := req.Network.CreateNetns(ctx, req.DirectiveID); err != nil {
+		return fmt.Errorf("create netns: %w", err)
+	}
+	vm.netns = req.Network
+	return nil
+}
+
+// checkIPForward reports whether the host forwards IPv4, which tap mode
+// needs to route guest traffic.
+func checkIPForward() error {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(data)) != "1" {
+		return errors.New("net.ipv4.ip_forward is disabled")
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package firecracker
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"cybros.ai/nexus/helper"
+	"cybros.ai/nexus/netpolicy"
+	"cybros.ai/nexus/sandbox"
+)
+
+// maxTapAllowRules caps forwarded allow rules (allowlisted prefixes and
+// pinned addresses) per VM, leaving room for the deny and local rules under
+// the helper's limit.
+const maxTapAllowRules = 200
+
+var errTapSubnetExhausted = errors.New("firecracker tap_subnet exhausted")
+
+// tapSlots returns how many /30 VM subnets fit in subnet.
+func tapSlots(subnet netip.Prefix) int {
+	return 1 << (30 - subnet.Bits())
+}
+
+// tapAddrs returns the host and guest addresses of /30 slot i of subnet.
+func tapAddrs(subnet netip.Prefix, slot int) (host, guest netip.Addr) {
+	base := subnet.Masked().Addr().As4()
+	n := binary.BigEndian.Uint32(base[:]) + uint32(slot)*4
+	var h, g [4]byte
+	binary.BigEndian.PutUint32(h[:], n+1)
+	binary.BigEndian.PutUint32(g[:], n+2)
+	return netip.AddrFrom4(h), netip.AddrFrom4(g)
+}
+
+// tapGuestMAC derives a locally administered MAC from the guest address.
+func tapGuestMAC(guest netip.Addr) string {
+	b := guest.As4()
+	return fmt.Sprintf("06:00:%02x:%02x:%02x:%02x", b[0], b[1], b[2], b[3])
+}
+
+// tapEgress keeps a tap-mode VM's nftables table in sync with its net
+// capability. IP/CIDR allowlist entries are opened up front. The guest's DNS
+// is redirected to the directive's resolver (egressproxy.Resolver), which
+// answers only allowlisted names and admits each address it hands out
+// through permit, as an allow rule for the name's allowlisted ports and
+// protocols. Private ranges are dropped except where a "private:" entry
+// opts in, and nothing else is reachable.
+type tapEgress struct {
+	network     sandbox.TapNetwork
+	directiveID string
+	hostIP      netip.Addr
+	dnsPort     int
+
+	mu      sync.Mutex
+	allow   []helper.NftRule
+	private []helper.NftRule // opted-in private destinations, before the deny
+	expired bool
+}
+
+func newTapEgress(network sandbox.TapNetwork, directiveID string, unrestricted bool, hostIP netip.Addr, prefixes []netpolicy.AllowlistEntryV2) (*tapEgress, error) {
+	e := &tapEgress{network: network, directiveID: directiveID, hostIP: hostIP}
+	if unrestricted {
+		e.allow = []helper.NftRule{
+			{CIDR: "0.0.0.0/0", Proto: "tcp"},
+			{CIDR: "0.0.0.0/0", Proto: "udp"},
+		}
+	}
+	for _, entry := range prefixes {
+		if entry.Prefix.Addr().Is4() { // the TAP link has no IPv6
+			e.add(entry.Prefix, entry)
+		}
+	}
+	if len(e.allow)+len(e.private) > maxTapAllowRules {
+		return nil, fmt.Errorf("tap egress: more than %d allowlisted destinations", maxTapAllowRules)
+	}
+	return e, nil
+}
+
+// add records the rules opening entry's ports over its protocol on target:
+// an allow rule, checked after the private deny, and for a "private:" entry
+// rules for the opt-in private part of target, checked before it. It
+// reports whether anything was new. Callers hold e.mu.
+func (e *tapEgress) add(target netip.Prefix, entry netpolicy.AllowlistEntryV2) bool {
+	rule := func(p netip.Prefix) helper.NftRule {
+		r := helper.NftRule{CIDR: p.String(), Proto: entry.Proto, PortFrom: entry.Ports.From}
+		if p.IsSingleIP() {
+			r.CIDR = p.Addr().String()
+		}
+		if entry.Ports.To != entry.Ports.From {
+			r.PortTo = entry.Ports.To
+		}
+		return r
+	}
+	added := false
+	covered := false
+	if entry.Private {
+		for _, p := range netpolicy.OptInPrivatePrefixes(target) {
+			if r := rule(p); !slices.Contains(e.private, r) {
+				e.private = append(e.private, r)
+				added = true
+			}
+			covered = covered || p == target.Masked()
+		}
+	}
+	if r := rule(target.Masked()); !covered && !slices.Contains(e.allow, r) {
+		e.allow = append(e.allow, r)
+		added = true
+	}
+	return added
+}
+
+// rules renders the current table. Callers hold e.mu.
+func (e *tapEgress) rules() helper.NftApplyParams {
+	return helper.NftApplyParams{
+		DirectiveID: e.directiveID,
+		Allow:       slices.Clone(e.allow),
+		Private:     slices.Clone(e.private),
+		Local: []helper.NftRule{
+			{CIDR: e.hostIP.String(), Proto: "udp", PortFrom: e.dnsPort},
+		},
+		Deny:            netpolicy.PrivateCIDRs(),
+		Masquerade:      true,
+		DNSRedirectPort: e.dnsPort,
+		CutEstablished:  e.expired,
+	}
+}
+
+// apply installs the current table.
+func (e *tapEgress) apply(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.network.ApplyEgress(ctx, e.rules())
+}
+
+// permit pins addrs for the ports and protocols of entries (the allowlist
+// entries naming them) and reinstalls the table if anything changed. The
+// table is applied before the DNS answer is sent, so the guest never sees
+// an address it cannot reach.
+func (e *tapEgress) permit(ctx context.Context, addrs []netip.Addr, entries []netpolicy.AllowlistEntryV2) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.expired {
+		return errors.New("tap egress: grant expired")
+	}
+	prevAllow, prevPrivate := e.allow, e.private
+	e.allow, e.private = slices.Clone(e.allow), slices.Clone(e.private)
+	added := false
+	for _, addr := range addrs {
+		for _, entry := range entries {
+			if e.add(netip.PrefixFrom(addr, addr.BitLen()), entry) {
+				added = true
+			}
+		}
+	}
+	if !added {
+		e.allow, e.private = prevAllow, prevPrivate
+		return nil
+	}
+	if len(e.allow)+len(e.private) > maxTapAllowRules {
+		e.allow, e.private = prevAllow, prevPrivate
+		return fmt.Errorf("tap egress: more than %d pinned destinations", maxTapAllowRules)
+	}
+	if err := e.network.ApplyEgress(ctx, e.rules()); err != nil {
+		e.allow, e.private = prevAllow, prevPrivate
+		return err
+	}
+	return nil
+}
+
+// expire ends the directive's time-boxed grant: every forwarded
+// destination is dropped, along with flows already established to them.
+func (e *tapEgress) expire(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.allow = nil
+	e.private = nil
+	e.expired = true
+	return e.network.ApplyEgress(ctx, e.rules())
+}
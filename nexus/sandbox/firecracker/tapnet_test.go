@@ -0,0 +1,303 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"cybros.ai/nexus/egressproxy"
+	"cybros.ai/nexus/helper"
+	"cybros.ai/nexus/netpolicy"
+	"cybros.ai/nexus/protocol"
+)
+
+// fakeTapNetwork records applied rule sets and netns calls.
+type fakeTapNetwork struct {
+	applied []helper.NftApplyParams
+	netns   []string
+	fail    bool
+}
+
+func (f *fakeTapNetwork) CreateTap(context.Context, string, string, int) (string, error) {
+	return "nxtap0", nil
+}
+
+func (f *fakeTapNetwork) ApplyEgress(_ context.Context, rules helper.NftApplyParams) error {
+	if f.fail {
+		return errors.New("nft failed")
+	}
+	f.applied = append(f.applied, rules)
+	return nil
+}
+
+func (f *fakeTapNetwork) RemoveTap(context.Context, string) error { return nil }
+
+func (f *fakeTapNetwork) CreateNetns(_ context.Context, directiveID string) error {
+	f.netns = append(f.netns, "create "+directiveID)
+	return nil
+}
+
+func (f *fakeTapNetwork) DeleteNetns(_ context.Context, directiveID string) error {
+	f.netns = append(f.netns, "delete "+directiveID)
+	return nil
+}
+
+func (f *fakeTapNetwork) last() helper.NftApplyParams { return f.applied[len(f.applied)-1] }
+
+func TestTapAddrs(t *testing.T) {
+	subnet := netip.MustParsePrefix("172.30.0.0/16")
+	if n := tapSlots(subnet); n != 16384 {
+		t.Errorf("slots = %d", n)
+	}
+	host, guest := tapAddrs(subnet, 0)
+	if host.String() != "172.30.0.1" || guest.String() != "172.30.0.2" {
+		t.Errorf("slot 0 = %s, %s", host, guest)
+	}
+	host, guest = tapAddrs(subnet, 64)
+	if host.String() != "172.30.1.1" || guest.String() != "172.30.1.2" {
+		t.Errorf("slot 64 = %s, %s", host, guest)
+	}
+	if mac := tapGuestMAC(guest); mac != "06:00:ac:1e:01:02" {
+		t.Errorf("mac = %s", mac)
+	}
+}
+
+func newTestTapEgress(unrestricted bool) (*tapEgress, *fakeTapNetwork) {
+	fake := &fakeTapNetwork{}
+	e, _ := newTapEgress(fake, "d-1", unrestricted, netip.MustParseAddr("172.30.0.1"), nil)
+	e.dnsPort = 40053
+	return e, fake
+}
+
+// testEntries parses allowlist V2 entries.
+func testEntries(t *testing.T, allow ...string) []netpolicy.AllowlistEntryV2 {
+	t.Helper()
+	entries, err := netpolicy.ParseAllowlist(netpolicy.AllowlistV2, allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestTapEgress_BaseRules(t *testing.T) {
+	e, fake := newTestTapEgress(false)
+	if err := e.apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rules := fake.last()
+	if len(rules.Allow) != 0 {
+		t.Errorf("allowlist starts with no forwarded destinations, got %+v", rules.Allow)
+	}
+	if rules.DNSRedirectPort != 40053 || !rules.Masquerade {
+		t.Errorf("rules = %+v", rules)
+	}
+	wantLocal := helper.NftRule{CIDR: "172.30.0.1", Proto: "udp", PortFrom: 40053}
+	if len(rules.Local) != 1 || rules.Local[0] != wantLocal {
+		t.Errorf("local = %+v", rules.Local)
+	}
+	if !slices.Contains(rules.Deny, "10.0.0.0/8") || !slices.Contains(rules.Deny, "169.254.0.0/16") {
+		t.Errorf("private ranges not denied: %v", rules.Deny)
+	}
+	if _, err := helper.RenderNftTable(rules); err != nil {
+		t.Errorf("rules do not render: %v", err)
+	}
+
+	open, fake := newTestTapEgress(true)
+	if err := open.apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.last().Allow; len(got) != 2 || got[0].CIDR != "0.0.0.0/0" || got[0].PortFrom != 0 {
+		t.Errorf("unrestricted allow = %+v", got)
+	}
+}
+
+func TestTapEgress_Permit(t *testing.T) {
+	port443 := testEntries(t, "github.com:443")
+	e, fake := newTestTapEgress(false)
+	ctx := context.Background()
+	addrs := []netip.Addr{netip.MustParseAddr("140.82.112.3"), netip.MustParseAddr("140.82.112.4")}
+
+	if err := e.permit(ctx, addrs, port443); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.applied) != 1 || len(fake.last().Allow) != 2 {
+		t.Fatalf("applied = %+v", fake.applied)
+	}
+	if r := fake.last().Allow[0]; r != (helper.NftRule{CIDR: "140.82.112.3", Proto: "tcp", PortFrom: 443}) {
+		t.Errorf("rule = %+v", r)
+	}
+
+	// Already pinned: no reapply.
+	if err := e.permit(ctx, addrs[:1], port443); err != nil || len(fake.applied) != 1 {
+		t.Errorf("duplicate permit reapplied: %d, %v", len(fake.applied), err)
+	}
+
+	// A failed apply leaves the pinned set unchanged.
+	fake.fail = true
+	if err := e.permit(ctx, []netip.Addr{netip.MustParseAddr("140.82.112.5")}, port443); err == nil {
+		t.Fatal("expected apply error")
+	}
+	if len(e.allow) != 2 {
+		t.Errorf("allow = %+v after failed apply", e.allow)
+	}
+
+	// Port ranges become a single rule.
+	fake.fail = false
+	if err := e.permit(ctx, addrs[:1], testEntries(t, "github.com:8000-8100")); err != nil {
+		t.Fatal(err)
+	}
+	if r := fake.last().Allow[2]; r != (helper.NftRule{CIDR: "140.82.112.3", Proto: "tcp", PortFrom: 8000, PortTo: 8100}) {
+		t.Errorf("range rule = %+v", r)
+	}
+}
+
+func TestTapEgress_PrefixEntries(t *testing.T) {
+	fake := &fakeTapNetwork{}
+	e, err := newTapEgress(fake, "d-1", false, netip.MustParseAddr("172.30.0.1"), testEntries(t,
+		"93.184.216.0/24:443",
+		"203.0.113.7:5000-5100/udp",
+		"private:10.20.0.0/16:5432",
+		"[2001:db8::1]:443",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.dnsPort = 40053
+	if err := e.apply(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rules := fake.last()
+	wantAllow := []helper.NftRule{
+		{CIDR: "93.184.216.0/24", Proto: "tcp", PortFrom: 443},
+		{CIDR: "203.0.113.7", Proto: "udp", PortFrom: 5000, PortTo: 5100},
+	}
+	if !slices.Equal(rules.Allow, wantAllow) {
+		t.Errorf("allow = %+v, want %+v", rules.Allow, wantAllow)
+	}
+	wantPrivate := []helper.NftRule{{CIDR: "10.20.0.0/16", Proto: "tcp", PortFrom: 5432}}
+	if !slices.Equal(rules.Private, wantPrivate) {
+		t.Errorf("private = %+v, want %+v", rules.Private, wantPrivate)
+	}
+	if _, err := helper.RenderNftTable(rules); err != nil {
+		t.Errorf("rules do not render: %v", err)
+	}
+
+	if err := e.expire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rules := fake.last(); len(rules.Allow) != 0 || len(rules.Private) != 0 {
+		t.Errorf("rules after expiry = %+v", rules)
+	}
+
+	var many []string
+	for i := 0; i <= maxTapAllowRules; i++ {
+		many = append(many, netip.AddrFrom4([4]byte{93, 184, byte(i >> 8), byte(i)}).String()+":443")
+	}
+	if _, err := newTapEgress(fake, "d-1", false, netip.MustParseAddr("172.30.0.1"), testEntries(t, many...)); err == nil {
+		t.Error("expected limit error")
+	}
+}
+
+func TestTapEgress_PermitProtocols(t *testing.T) {
+	e, fake := newTestTapEgress(false)
+	ctx := context.Background()
+	entries := testEntries(t, "db.corp.example:443", "private:db.corp.example:5432", "db.corp.example:3478/udp")
+
+	// A public address gets every entry's ports and protocols; the private
+	// opt-in adds nothing before the deny for it.
+	if err := e.permit(ctx, []netip.Addr{netip.MustParseAddr("198.51.100.9")}, entries); err != nil {
+		t.Fatal(err)
+	}
+	wantAllow := []helper.NftRule{
+		{CIDR: "198.51.100.9", Proto: "tcp", PortFrom: 443},
+		{CIDR: "198.51.100.9", Proto: "tcp", PortFrom: 5432},
+		{CIDR: "198.51.100.9", Proto: "udp", PortFrom: 3478},
+	}
+	if rules := fake.last(); !slices.Equal(rules.Allow, wantAllow) || len(rules.Private) != 0 {
+		t.Errorf("public address: allow = %+v, private = %+v", rules.Allow, rules.Private)
+	}
+
+	// A private address is opened, ahead of the private deny, only on the
+	// "private:" entry's port.
+	if err := e.permit(ctx, []netip.Addr{netip.MustParseAddr("10.30.0.5")}, entries); err != nil {
+		t.Fatal(err)
+	}
+	rules := fake.last()
+	if want := []helper.NftRule{{CIDR: "10.30.0.5", Proto: "tcp", PortFrom: 5432}}; !slices.Equal(rules.Private, want) {
+		t.Errorf("private = %+v, want %+v", rules.Private, want)
+	}
+	if script, err := helper.RenderNftTable(rules); err != nil {
+		t.Errorf("rules do not render: %v", err)
+	} else if strings.Index(script, "10.30.0.5/32 tcp dport 5432 accept") > strings.Index(script, "10.0.0.0/8 drop") {
+		t.Errorf("private opt-in rendered after the deny:\n%s", script)
+	}
+}
+
+func TestTapEgress_Expire(t *testing.T) {
+	port443 := testEntries(t, "pypi.org:443")
+	e, fake := newTestTapEgress(false)
+	ctx := context.Background()
+	if err := e.permit(ctx, []netip.Addr{netip.MustParseAddr("151.101.0.223")}, port443); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.expire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	rules := fake.last()
+	if len(rules.Allow) != 0 || !rules.CutEstablished {
+		t.Errorf("rules after expiry = %+v", rules)
+	}
+	if rules.DNSRedirectPort != 40053 {
+		t.Error("DNS must stay redirected to the resolver, which refuses every name")
+	}
+	if err := e.permit(ctx, []netip.Addr{netip.MustParseAddr("151.101.0.224")}, port443); err == nil {
+		t.Error("permit after expiry should fail")
+	}
+
+	open, fake := newTestTapEgress(true)
+	if err := open.expire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.last().Allow) != 0 {
+		t.Errorf("unrestricted grant not revoked: %+v", fake.last().Allow)
+	}
+}
+
+func TestTapEgress_PermitLimit(t *testing.T) {
+	e, _ := newTestTapEgress(false)
+	var addrs []netip.Addr
+	for i := 0; i <= maxTapAllowRules; i++ {
+		addrs = append(addrs, netip.AddrFrom4([4]byte{93, 184, byte(i >> 8), byte(i)}))
+	}
+	if err := e.permit(context.Background(), addrs, testEntries(t, "example.com:443")); err == nil {
+		t.Fatal("expected limit error")
+	}
+}
+
+func TestTapEgress_Resolver(t *testing.T) {
+	policy, err := egressproxy.NewPolicy(&protocol.NetCapabilityV1{Mode: "allowlist", Allow: []string{"localhost:8080"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, fake := newTestTapEgress(false)
+	resolver := egressproxy.NewResolver(policy, egressproxy.NewAuditLogger(io.Discard, "d-1"))
+	resolver.IPv4Only = true
+	resolver.Admit = e.permit
+	ctx := context.Background()
+
+	if a := resolver.Resolve(ctx, egressproxy.DNSQuestion{Name: "evil.example", Type: egressproxy.DNSTypeA}); a.RCode != egressproxy.DNSRCodeRefused {
+		t.Errorf("unlisted name: rcode = %d, want REFUSED", a.RCode)
+	}
+	// localhost resolves only to loopback, which is never routable.
+	if a := resolver.Resolve(ctx, egressproxy.DNSQuestion{Name: "localhost", Type: egressproxy.DNSTypeA}); a.RCode != egressproxy.DNSRCodeRefused {
+		t.Errorf("private-only name: answer = %+v, want REFUSED", a)
+	}
+	if len(fake.applied) != 0 {
+		t.Errorf("rules applied for unreachable names: %+v", fake.applied)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+
+	"cybros.ai/nexus/sandbox/sessionagent"
 )
 
 // validEnvKeyRe matches safe POSIX environment variable names.
@@ -12,6 +14,12 @@ var validEnvKeyRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 const (
 	guestWorkspace = "/workspace"
 	guestProxyPort = 9080
+	// guestDNSPort is the vsock port nexus-init bridges 127.0.0.1:53 to.
+	guestDNSPort = 9053
+	// guestSessionPort is the vsock port the session agent connects to.
+	guestSessionPort = 9100
+	// guestSessionAgent is the session agent binary on the command disk.
+	guestSessionAgent = "/mnt/cmd/nexus-agent"
 )
 
 // WrapperConfig holds the inputs for generating the wrapper shell script
@@ -44,16 +52,21 @@ type WrapperConfig struct {
 	// e.g., "NEXUS_EXIT_a1b2c3d4=". The wrapper echoes this with the exit code
 	// so the host can identify it without risk of spoofing by guest commands.
 	ExitMarker string
+
+	// SessionAgent runs the session agent copied to the command disk
+	// instead of a user command: it connects to the host over vsock and
+	// runs the session's commands in the environment set up here.
+	SessionAgent bool
 }
 
 // GenerateWrapper produces a shell script for the Firecracker guest:
 //  1. Exports HTTP_PROXY/HTTPS_PROXY pointing at the socat bridge (started by nexus-init).
 //  2. Exports user environment variables.
 //  3. Optionally runs git clone for facility preparation.
-//  4. Runs the user command.
+//  4. Runs the user command (or the session agent).
 //  5. Exits with the command's exit code.
 func GenerateWrapper(cfg WrapperConfig) (string, error) {
-	if cfg.UserCommand == "" {
+	if cfg.UserCommand == "" && !cfg.SessionAgent {
 		return "", fmt.Errorf("user command is required")
 	}
 
@@ -112,6 +125,14 @@ func GenerateWrapper(cfg WrapperConfig) (string, error) {
 		b.WriteString("fi\n\n")
 	}
 
+	if cfg.SessionAgent {
+		// Each command chooses its own cwd; the VM powers off after the agent.
+		b.WriteString("set +e\n")
+		fmt.Fprintf(&b, "%s %s %s\n", guestSessionAgent, sessionagent.Arg, sessionagent.VsockTarget(guestSessionPort))
+		b.WriteString("exit $?\n")
+		return b.String(), nil
+	}
+
 	if cfg.Cwd != "" && cfg.Cwd != guestWorkspace {
 		fmt.Fprintf(&b, "cd %s\n\n", shellQuote(cfg.Cwd))
 	}
@@ -268,3 +268,25 @@ func TestShellQuote(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateWrapper_SessionAgent(t *testing.T) {
+	script, err := GenerateWrapper(WrapperConfig{
+		SessionAgent: true,
+		Cwd:          "/workspace/sub",
+		Env:          map[string]string{"FOO": "bar"},
+		ExitMarker:   "NEXUS_EXIT_abc=",
+	})
+	if err != nil {
+		t.Fatalf("GenerateWrapper: %v", err)
+	}
+	if !strings.Contains(script, "export FOO='bar'") {
+		t.Errorf("missing env export in:\n%s", script)
+	}
+	if !strings.HasSuffix(script, "/mnt/cmd/nexus-agent __nexus_session_agent vsock:9100\nexit $?\n") {
+		t.Errorf("script does not end by running the session agent:\n%s", script)
+	}
+	// Commands pick their own cwd.
+	if strings.Contains(script, "cd '/workspace/sub'") {
+		t.Errorf("unexpected cd in:\n%s", script)
+	}
+}
@@ -8,7 +8,9 @@ import (
 	"os/exec"
 	"syscall"
 
+	"cybros.ai/nexus/protocol"
 	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/landlock"
 )
 
 type Driver struct{}
@@ -25,6 +27,22 @@ func (d *Driver) HealthCheck(_ context.Context) sandbox.HealthResult {
 	}
 }
 
+// EffectiveCapabilities reports Landlock filesystem enforcement. The host
+// driver has no other isolation, so net capabilities are not enforced.
+func (d *Driver) EffectiveCapabilities(caps protocol.Capabilities) map[string]any {
+	return map[string]any{
+		"fs_enforcement": landlock.Summary(caps.Fs),
+	}
+}
+
+// DeliversSecrets implements sandbox.SecretsDeliverer: env secrets are set in
+// the command's environment and file secrets stay in their tmpfs directory.
+func (d *Driver) DeliversSecrets() bool { return true }
+
+// RunsTerminal implements sandbox.TerminalRunner where the platform has
+// pseudo-terminals.
+func (d *Driver) RunsTerminal() bool { return sandbox.TerminalSupported() }
+
 // minimalHostEnv returns the minimum set of environment variables inherited
 // from the host process. The host driver does not provide isolation, but
 // we avoid leaking the full process environment (which may contain secrets
@@ -53,11 +71,17 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	// The caller (daemon) is responsible for setting the context deadline.
 	// The driver uses the context as-is to avoid double timeouts.
 
-	shell := req.Shell
-	if shell == "" {
-		shell = "/bin/sh"
+	tmpDir, tmpCleanup, err := landlockTmpDir(req)
+	if err != nil {
+		return sandbox.RunResult{}, err
+	}
+	defer tmpCleanup()
+	argv, dir, env, err := command(req, tmpDir)
+	if err != nil {
+		return sandbox.RunResult{}, err
 	}
-	cmd := exec.CommandContext(ctx, shell, "-c", req.Command)
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Cancel = func() error {
 		// Kill the entire process group to ensure child processes are also terminated.
@@ -67,32 +91,15 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		}
 		return cmd.Process.Kill()
 	}
-
-	cwd, err := sandbox.ResolveWorkspaceCwd(req.WorkDir, req.Cwd)
-	if err != nil {
-		return sandbox.RunResult{}, err
-	}
-	cmd.Dir = cwd
-
-	// Env: start with minimal host env, then apply directive-specific overrides.
-	envMap := minimalHostEnv()
-	for k, v := range req.Env {
-		envMap[k] = v
-	}
-	env := make([]string, 0, len(envMap))
-	for k, v := range envMap {
-		env = append(env, fmt.Sprintf("%s=%s", k, v))
-	}
+	cmd.Dir = dir
 	cmd.Env = env
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return sandbox.RunResult{}, err
-	}
-	stderr, err := cmd.StderrPipe()
+	// Pipes, or the pseudo-terminal of an interactive directive.
+	cio, err := sandbox.AttachIO(cmd, req)
 	if err != nil {
 		return sandbox.RunResult{}, err
 	}
+	defer cio.Close()
 
 	if err := cmd.Start(); err != nil {
 		return sandbox.RunResult{}, err
@@ -102,28 +109,21 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	// Fail-closed: if limits were explicitly requested but couldn't be applied,
 	// abort the directive rather than running without resource constraints.
 	var warnings []string
-	cg, cgErr := sandbox.ApplyCgroupLimits(req.DirectiveID, cmd.Process.Pid, req.Limits)
+	cgCleanup, cgErr := sandbox.ApplyLimits(ctx, req, cmd.Process.Pid)
 	if cgErr != nil {
 		_ = cmd.Process.Kill()
 		_, _ = cmd.Process.Wait()
 		return sandbox.RunResult{}, fmt.Errorf("cgroup limits required but failed to apply: %w", cgErr)
 	}
-	if cg != nil {
-		defer cg.Cleanup()
-	}
-
-	// Stream logs concurrently.
-	errCh := make(chan error, 2)
-	go func() { errCh <- req.LogSink.Consume(ctx, "stdout", stdout) }()
-	go func() { errCh <- req.LogSink.Consume(ctx, "stderr", stderr) }()
+	defer cgCleanup()
 
+	// Stream logs (and terminal input) concurrently.
 	// IMPORTANT: Drain pipe readers BEFORE cmd.Wait().
 	// Go's exec.Cmd.Wait() closes the pipe read ends. If we call Wait() first,
 	// any data still in the kernel pipe buffer is discarded — causing truncation
-	// for fast-completing commands. The goroutines will see io.EOF naturally when
+	// for fast-completing commands. The readers see io.EOF naturally when
 	// the child process exits (closing the write end of the pipe).
-	consume1 := <-errCh
-	consume2 := <-errCh
+	consumeErr := cio.Stream(ctx, req.LogSink)
 
 	waitErr := cmd.Wait()
 
@@ -147,7 +147,6 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 		result.Status = "canceled"
 	}
 
-	consumeErr := errors.Join(consume1, consume2)
 	if consumeErr != nil {
 		return result, consumeErr
 	}
@@ -155,4 +154,77 @@ func (d *Driver) Run(ctx context.Context, req sandbox.RunRequest) (sandbox.RunRe
 	return result, nil
 }
 
+// landlockTmpDir creates the temporary directory of commands run under
+// Landlock: the host's /tmp is shared with everything else, so it stays
+// read-only and TMPDIR points here instead. It returns "" when req needs no
+// Landlock.
+func landlockTmpDir(req sandbox.RunRequest) (dir string, cleanup func(), err error) {
+	if !landlock.Required(req.FsCapability) {
+		return "", func() {}, nil
+	}
+	dir, err = os.MkdirTemp("", "nexus-tmp-")
+	if err != nil {
+		return "", nil, fmt.Errorf("create landlock tmp dir: %w", err)
+	}
+	return dir, func() { _ = os.RemoveAll(dir) }, nil
+}
+
+// command returns the argv, working directory and environment of req's
+// command, shared by Run and session execs. tmpDir is landlockTmpDir's.
+func command(req sandbox.RunRequest, tmpDir string) (argv []string, dir string, env []string, err error) {
+	shell := req.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	argv = []string{shell, "-c", req.Command}
+
+	// Landlock: when the fs capability carries enforceable paths, re-exec
+	// through the shim so the restriction applies to the command only.
+	// Fail-closed: never run unconfined when enforcement was requested.
+	var landlockEnv string
+	if landlock.Required(req.FsCapability) {
+		if landlock.ABIVersion() == 0 {
+			return nil, "", nil, errors.New("fs capability requires Landlock but the kernel does not support it")
+		}
+		if err := landlock.Check(req.FsCapability); err != nil {
+			return nil, "", nil, err
+		}
+		self, err := os.Executable()
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("resolve landlock shim: %w", err)
+		}
+		rs := landlock.FromFsCapability(req.FsCapability, req.WorkDir)
+		if tmpDir != "" {
+			rs.AddWritable(tmpDir)
+		}
+		argv, landlockEnv, err = landlock.ShimCommand(self, rs.Spec(), argv)
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
 
+	dir, err = sandbox.ResolveWorkspaceCwd(req.WorkDir, req.Cwd)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Env: start with minimal host env, then apply directive-specific overrides.
+	envMap := minimalHostEnv()
+	if tmpDir != "" {
+		envMap["TMPDIR"] = tmpDir
+	}
+	for k, v := range req.Env {
+		envMap[k] = v
+	}
+	for k, v := range req.Secrets.HostEnv() {
+		envMap[k] = v
+	}
+	env = make([]string, 0, len(envMap))
+	for k, v := range envMap {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	if landlockEnv != "" {
+		env = append(env, landlockEnv)
+	}
+	return argv, dir, env, nil
+}
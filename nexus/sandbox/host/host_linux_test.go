@@ -0,0 +1,102 @@
+//go:build linux
+
+package host
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/sandbox"
+)
+
+// bufferSink keeps everything written to "stdout".
+type bufferSink struct {
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (s *bufferSink) Consume(_ context.Context, stream string, r io.Reader) error {
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		if stream == "stdout" {
+			s.mu.Lock()
+			s.out.Write(buf[:n])
+			s.mu.Unlock()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *bufferSink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.ReplaceAll(s.out.String(), "\r\n", "\n")
+}
+
+func TestDriver_Run_Terminal(t *testing.T) {
+	t.Parallel()
+
+	drv := New()
+	if !drv.RunsTerminal() {
+		t.Fatal("host driver should run terminals on linux")
+	}
+
+	input := make(chan sandbox.TerminalInput)
+	sink := &bufferSink{}
+	go func() {
+		// Type only once echo is off.
+		for !strings.Contains(sink.String(), "tty\n") {
+			time.Sleep(10 * time.Millisecond)
+		}
+		input <- sandbox.TerminalInput{Data: []byte("7\n")}
+	}()
+	res, err := drv.Run(context.Background(), sandbox.RunRequest{
+		Command:  `stty -echo; [ -t 0 ] && echo tty; read n; exit "$n"`,
+		WorkDir:  t.TempDir(),
+		LogSink:  sink,
+		Terminal: &sandbox.Terminal{Input: input},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.ExitCode != 7 {
+		t.Errorf("exit code = %d, want 7 (output %q)", res.ExitCode, sink.String())
+	}
+	if got := sink.String(); got != "tty\n" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestDriver_Run_TerminalTimeout(t *testing.T) {
+	t.Parallel()
+
+	drv := New()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// The shell waits on input that never comes; its background child keeps
+	// the terminal open too, so the whole session must be killed.
+	res, err := drv.Run(ctx, sandbox.RunRequest{
+		Command:  "sleep 30 & read x",
+		WorkDir:  t.TempDir(),
+		LogSink:  &sandbox.DiscardSink{},
+		Terminal: &sandbox.Terminal{Input: make(chan sandbox.TerminalInput)},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != "timed_out" || res.ExitCode != 124 {
+		t.Fatalf("status = %s, exit code = %d, want timed_out/124", res.Status, res.ExitCode)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"testing"
 	"time"
 
+	"cybros.ai/nexus/protocol"
 	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/landlock"
 )
 
 func TestDriver_MinimalHostEnv(t *testing.T) {
@@ -218,3 +220,149 @@ func TestDriver_Run_EnvOverride(t *testing.T) {
 		t.Fatalf("expected exit code 0, got %d", res.ExitCode)
 	}
 }
+
+func TestDriver_Run_LandlockEnforced(t *testing.T) {
+	if landlock.ABIVersion() == 0 {
+		t.Skip("Landlock not available on this kernel")
+	}
+	t.Parallel()
+
+	workDir := t.TempDir()
+	outside := t.TempDir()
+	drv := New()
+	sink := &sandbox.DiscardSink{}
+
+	res, err := drv.Run(context.Background(), sandbox.RunRequest{
+		Command: "echo ok > inside.txt; echo leak > " + filepath.Join(outside, "leak.txt"),
+		WorkDir: workDir,
+		LogSink: sink,
+		FsCapability: &protocol.FsCapabilityV1{
+			WritableRoots: []string{workDir},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != "failed" {
+		t.Errorf("expected status failed (write outside writable root), got %s", res.Status)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "inside.txt")); err != nil {
+		t.Errorf("write inside writable root should succeed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "leak.txt")); err == nil {
+		t.Error("write outside writable root should have been denied")
+	}
+}
+
+func TestDriver_Run_LandlockDevicesAndTmp(t *testing.T) {
+	if landlock.ABIVersion() == 0 {
+		t.Skip("Landlock not available on this kernel")
+	}
+	t.Parallel()
+
+	workDir := t.TempDir()
+	sink := &sandbox.DiscardSink{}
+
+	// The host's /tmp stays read-only; TMPDIR is the directive's own.
+	res, err := New().Run(context.Background(), sandbox.RunRequest{
+		Command:      `echo x >/dev/null && touch "$TMPDIR/x" && ! touch /tmp/nexus-landlock-leak`,
+		WorkDir:      workDir,
+		LogSink:      sink,
+		FsCapability: &protocol.FsCapabilityV1{WritableRoots: []string{workDir}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != "succeeded" {
+		t.Errorf("status = %s, want succeeded (exit code %d)", res.Status, res.ExitCode)
+	}
+}
+
+func TestDriver_Run_LandlockNestedReadOnlyRefused(t *testing.T) {
+	if landlock.ABIVersion() == 0 {
+		t.Skip("Landlock not available on this kernel")
+	}
+	t.Parallel()
+
+	workDir := t.TempDir()
+	gitDir := filepath.Join(workDir, ".git")
+	if err := os.Mkdir(gitDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Landlock cannot take write access away from .git inside a writable
+	// workspace, so the command must not run at all.
+	_, err := New().Run(context.Background(), sandbox.RunRequest{
+		Command: "echo tampered > .git/HEAD",
+		WorkDir: workDir,
+		LogSink: &sandbox.DiscardSink{},
+		FsCapability: &protocol.FsCapabilityV1{
+			WritableRoots:    []string{workDir},
+			ReadOnlySubpaths: []string{gitDir},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected Run to refuse a read-only subpath inside a writable root")
+	}
+	if _, statErr := os.Stat(filepath.Join(gitDir, "HEAD")); statErr == nil {
+		t.Error("write under the read-only subpath was not refused")
+	}
+
+	fs := New().EffectiveCapabilities(protocol.Capabilities{Fs: &protocol.FsCapabilityV1{
+		WritableRoots:    []string{workDir},
+		ReadOnlySubpaths: []string{gitDir},
+	}})["fs_enforcement"].(map[string]any)
+	if fs["enforced"] != false || fs["unenforced_read_only"] == nil {
+		t.Errorf("fs_enforcement = %v, want enforced false with the unenforced path", fs)
+	}
+}
+
+func TestDriver_EffectiveCapabilities(t *testing.T) {
+	t.Parallel()
+
+	eff := New().EffectiveCapabilities(protocol.Capabilities{
+		Fs: &protocol.FsCapabilityV1{WritableRoots: []string{"/srv/ws"}},
+	})
+	fs, ok := eff["fs_enforcement"].(map[string]any)
+	if !ok {
+		t.Fatalf("missing fs_enforcement in %v", eff)
+	}
+	if fs["mechanism"] != "landlock" || fs["required"] != true {
+		t.Errorf("unexpected fs_enforcement: %v", fs)
+	}
+	if fs["abi"] != landlock.ABIVersion() {
+		t.Errorf("abi = %v, want %d", fs["abi"], landlock.ABIVersion())
+	}
+}
+
+func TestDriver_Run_Secrets(t *testing.T) {
+	t.Parallel()
+
+	drv := New()
+	workDir := t.TempDir()
+	filesDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(filesDir, "files"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(filesDir, "files", "token"), []byte("from-file"), 0o400); err != nil {
+		t.Fatal(err)
+	}
+	secrets := &sandbox.Secrets{
+		Env:   map[string]string{"MY_SECRET": "from-env"},
+		Files: map[string]string{"token": "from-file"},
+		Dir:   filesDir,
+	}
+
+	res, err := drv.Run(context.Background(), sandbox.RunRequest{
+		Command: `[ "$MY_SECRET" = from-env ] && [ "$(cat "$CYBROS_SECRETS_DIR/token")" = from-file ]`,
+		WorkDir: workDir,
+		LogSink: &sandbox.DiscardSink{},
+		Secrets: secrets,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.ExitCode != 0 {
+		t.Fatalf("expected secrets in env and files dir, exit code %d", res.ExitCode)
+	}
+}
@@ -0,0 +1,46 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"cybros.ai/nexus/sandbox"
+	"cybros.ai/nexus/sandbox/sessionagent"
+)
+
+// OpenSession implements sandbox.SessionRunner: the session agent runs on
+// the host in the facility, and starts each command as Run would (same
+// environment, Landlock and working directory rules). Commands left in the
+// background are killed when the session closes.
+func (d *Driver) OpenSession(ctx context.Context, req sandbox.RunRequest) (sandbox.Session, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("resolve session agent: %w", err)
+	}
+	tmpDir, tmpCleanup, err := landlockTmpDir(req)
+	if err != nil {
+		return nil, err
+	}
+	return sandbox.StartAgentSession(ctx, req, sandbox.SessionStart{
+		Cmd: func(socket string) (*exec.Cmd, error) {
+			argv := sessionagent.Command(self, socket)
+			cmd := exec.Command(argv[0], argv[1:]...)
+			cmd.Dir = req.WorkDir
+			for k, v := range minimalHostEnv() {
+				cmd.Env = append(cmd.Env, k+"="+v)
+			}
+			return cmd, nil
+		},
+		Command: func(r sandbox.RunRequest) (sessionagent.Request, error) {
+			argv, dir, env, err := command(r, tmpDir)
+			if err != nil {
+				return sessionagent.Request{}, err
+			}
+			return sessionagent.Request{Argv: argv, Dir: dir, Env: env}, nil
+		},
+		CgroupLimits: true,
+		Cleanup:      tmpCleanup,
+	})
+}
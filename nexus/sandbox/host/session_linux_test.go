@@ -0,0 +1,141 @@
+//go:build linux
+
+package host
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"cybros.ai/nexus/sandbox"
+)
+
+func openTestSession(t *testing.T, workDir string) sandbox.Session {
+	t.Helper()
+	s, err := New().OpenSession(context.Background(), sandbox.RunRequest{
+		DirectiveID: "d-open",
+		WorkDir:     workDir,
+		LogSink:     &sandbox.DiscardSink{},
+	})
+	if err != nil {
+		t.Fatalf("OpenSession: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestSession_ExecKeepsState(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	s := openTestSession(t, workDir)
+
+	sink := &bufferSink{}
+	res, err := s.Exec(context.Background(), sandbox.RunRequest{
+		Command: "echo kept > state.txt; sleep 60 >/dev/null 2>&1 & echo $! > bg.pid; echo first",
+		WorkDir: workDir,
+		LogSink: sink,
+	})
+	if err != nil {
+		t.Fatalf("first exec: %v", err)
+	}
+	if res.Status != "succeeded" || res.ExitCode != 0 || sink.String() != "first\n" {
+		t.Fatalf("first exec = %+v, stdout %q", res, sink.String())
+	}
+
+	sink = &bufferSink{}
+	res, err = s.Exec(context.Background(), sandbox.RunRequest{
+		Command: `cat state.txt; kill -0 "$(cat bg.pid)" && echo alive; echo "$NEXUS_TEST"; exit 3`,
+		WorkDir: workDir,
+		Env:     map[string]string{"NEXUS_TEST": "second"},
+		LogSink: sink,
+	})
+	if err != nil {
+		t.Fatalf("second exec: %v", err)
+	}
+	if res.Status != "failed" || res.ExitCode != 3 {
+		t.Errorf("second exec = %+v, want failed with exit code 3", res)
+	}
+	if got := sink.String(); got != "kept\nalive\nsecond\n" {
+		t.Errorf("second exec stdout = %q", got)
+	}
+
+	b, err := os.ReadFile(filepath.Join(workDir, "bg.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !errors.Is(syscall.Kill(pid, 0), syscall.ESRCH) {
+		if time.Now().After(deadline) {
+			t.Fatalf("background process %d survived Close", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSession_ExecTimeout(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	s := openTestSession(t, workDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	res, err := s.Exec(ctx, sandbox.RunRequest{Command: "sleep 30", WorkDir: workDir, LogSink: &sandbox.DiscardSink{}})
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if res.Status != "timed_out" || res.ExitCode != 124 {
+		t.Errorf("exec = %+v, want timed_out with exit code 124", res)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("exec took %v after the timeout", elapsed)
+	}
+
+	// The session outlives a timed-out command.
+	sink := &bufferSink{}
+	res, err = s.Exec(context.Background(), sandbox.RunRequest{Command: "echo next", WorkDir: workDir, LogSink: sink})
+	if err != nil || res.Status != "succeeded" || sink.String() != "next\n" {
+		t.Errorf("exec after timeout = %+v, %v, stdout %q", res, err, sink.String())
+	}
+}
+
+func TestSession_ExecTerminal(t *testing.T) {
+	t.Parallel()
+
+	workDir := t.TempDir()
+	s := openTestSession(t, workDir)
+
+	input := make(chan sandbox.TerminalInput)
+	close(input)
+	sink := &bufferSink{}
+	res, err := s.Exec(context.Background(), sandbox.RunRequest{
+		Command:  "[ -t 0 ] && [ -t 1 ] && stty size",
+		WorkDir:  workDir,
+		LogSink:  sink,
+		Terminal: &sandbox.Terminal{Rows: 30, Cols: 100, Input: input},
+	})
+	if err != nil {
+		t.Fatalf("exec: %v", err)
+	}
+	if res.Status != "succeeded" {
+		t.Errorf("exec = %+v", res)
+	}
+	if got := strings.ReplaceAll(sink.String(), "\r\n", "\n"); got != "30 100\n" {
+		t.Errorf("terminal output = %q", got)
+	}
+}
@@ -0,0 +1,27 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+
+	"cybros.ai/nexus/helper"
+)
+
+// Jailer runs a Firecracker VMM under the jailer on behalf of a daemon that
+// is not root (through nexus-helper). The jail, its chroot and its process
+// are keyed by the directive ID.
+type Jailer interface {
+	// PrepareJail creates an empty chroot for a VMM running as uid (and gid
+	// uid) and returns its host path. The daemon owns the chroot; files it
+	// creates there belong to the VMM's group.
+	PrepareJail(ctx context.Context, directiveID string, uid int) (root string, err error)
+	// LaunchJail starts the VMM with the given stdio; p.Args are Firecracker
+	// arguments with chroot-relative paths.
+	LaunchJail(ctx context.Context, p helper.JailerLaunchParams, stdin, stdout, stderr *os.File) error
+	// WaitJail blocks until the VMM exits and returns its exit code.
+	WaitJail(ctx context.Context, directiveID string) (exitCode int, err error)
+	// KillJail kills the VMM.
+	KillJail(ctx context.Context, directiveID string) error
+	// CleanupJail kills the VMM if still running and removes the jail.
+	CleanupJail(ctx context.Context, directiveID string) error
+}
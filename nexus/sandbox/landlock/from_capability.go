@@ -10,7 +10,9 @@ import (
 // those are used directly. Otherwise falls back to workDir-based defaults.
 //
 // Standard system paths (/usr, /lib, /etc, /proc, /dev, /tmp) are always
-// added as read-only to keep the process functional.
+// added as read-only to keep the process functional, and the terminal and
+// null devices as writable (see systemWritablePaths). /tmp is left to the
+// driver: the host's is shared, a sandbox's is its own.
 func FromFsCapability(cap *protocol.FsCapabilityV1, workDir string) *Ruleset {
 	rs := NewRuleset()
 
@@ -27,6 +29,7 @@ func FromFsCapability(cap *protocol.FsCapabilityV1, workDir string) *Ruleset {
 
 	// Always allow read access to essential system paths
 	rs.AddReadOnly(systemReadOnlyPaths()...)
+	rs.AddWritable(systemWritablePaths()...)
 
 	return rs
 }
@@ -45,3 +48,16 @@ func systemReadOnlyPaths() []string {
 		"/run",
 	}
 }
+
+// systemWritablePaths returns the devices commands routinely write to
+// (`>/dev/null`, terminals). Rights on a device file are limited to reading
+// and writing it; /dev/pts holds the terminals themselves.
+func systemWritablePaths() []string {
+	return []string{
+		"/dev/null",
+		"/dev/zero",
+		"/dev/tty",
+		"/dev/ptmx",
+		"/dev/pts",
+	}
+}
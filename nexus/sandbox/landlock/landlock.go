@@ -27,7 +27,6 @@ package landlock
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
 	"syscall"
 	"unsafe"
@@ -40,6 +39,9 @@ const (
 	sysLandlockAddRule       = 445
 	sysLandlockRestrictSelf  = 446
 
+	// Flags for create_ruleset
+	createRulesetVersion = 1 << 0
+
 	// Rule types
 	rulePathBeneath = 1
 
@@ -66,9 +68,16 @@ const (
 
 	accessAll = accessRead | accessWrite
 
+	// accessFile is the subset of rights the kernel accepts on a non-directory
+	// path_beneath rule (e.g. /dev/null); directory-only rights yield EINVAL.
+	accessFile = accessFsExecute | accessFsWriteFile | accessFsReadFile
+
 	// prctl constants — defined locally for Go < 1.25 compatibility
 	// (syscall.PR_SET_NO_NEW_PRIVS was added in Go 1.25)
 	prSetNoNewPrivs = 38 // stable Linux ABI, see prctl(2)
+
+	// oPath is O_PATH (amd64 / arm64); the syscall package does not define it.
+	oPath = 0x200000
 )
 
 // landlock_ruleset_attr for create_ruleset syscall
@@ -85,9 +94,9 @@ type pathBeneathAttr struct {
 
 // Ruleset accumulates filesystem access rules before applying them atomically.
 type Ruleset struct {
-	writablePaths  []string
-	readOnlyPaths  []string
-	applied        bool
+	writablePaths []string
+	readOnlyPaths []string
+	applied       bool
 }
 
 // NewRuleset creates an empty ruleset.
@@ -133,6 +142,21 @@ func Available() bool {
 	return true
 }
 
+// ABIVersion returns the Landlock ABI version supported by the running
+// kernel, or 0 if Landlock is unavailable or disabled.
+func ABIVersion() int {
+	v, _, errno := syscall.RawSyscall(
+		sysLandlockCreateRuleset,
+		0,
+		0,
+		createRulesetVersion,
+	)
+	if errno != 0 {
+		return 0
+	}
+	return int(v)
+}
+
 // Apply creates a Landlock ruleset, adds all configured paths, and restricts
 // the calling process. Once applied, the restriction is permanent.
 //
@@ -195,14 +219,19 @@ func (rs *Ruleset) Apply() error {
 
 // addPathRule opens a path and adds a Landlock path-beneath rule to the ruleset.
 func addPathRule(rulesetFd int, path string, access uint64) error {
-	// Open the path to get an fd for the kernel
-	fd, err := os.Open(path)
+	// Open the path to get an fd for the kernel. O_PATH does not open the
+	// file itself, which for devices such as /dev/tty could fail or act.
+	pathFd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
 	if err != nil {
 		return fmt.Errorf("open %q: %w", path, err)
 	}
-	defer fd.Close()
+	defer syscall.Close(pathFd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(pathFd, &st); err == nil && st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= accessFile
+	}
 
-	pathFd := int(fd.Fd())
 	rule := pathBeneathAttr{
 		allowedAccess: access,
 		parentFd:      int32(pathFd),
@@ -6,8 +6,8 @@ import "fmt"
 
 // Ruleset is a no-op on non-Linux platforms.
 type Ruleset struct {
-	writablePaths  []string
-	readOnlyPaths  []string
+	writablePaths []string
+	readOnlyPaths []string
 }
 
 // NewRuleset creates an empty ruleset (no-op on non-Linux).
@@ -30,6 +30,11 @@ func Available() bool {
 	return false
 }
 
+// ABIVersion always returns 0 on non-Linux.
+func ABIVersion() int {
+	return 0
+}
+
 // Apply returns an error on non-Linux — Landlock is Linux-only.
 // Callers should check Available() first.
 func (rs *Ruleset) Apply() error {
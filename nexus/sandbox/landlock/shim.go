@@ -0,0 +1,198 @@
+package landlock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"cybros.ai/nexus/protocol"
+)
+
+// Landlock is irrevocable and applies to the calling process, so drivers
+// cannot call Apply inside nexusd without confining the daemon itself.
+// Instead they re-exec the nexusd binary as a small shim:
+//
+//	NEXUS_LANDLOCK_SPEC='{"writable":[...]}' nexusd __nexus_landlock_shim -- /bin/sh -c '...'
+//
+// The shim applies the ruleset to itself and then execve()s the user command,
+// which inherits the restriction. Binaries that may act as the shim must call
+// RunShimIfRequested at the very top of main (or TestMain).
+
+// ShimArg is the argv[1] sentinel that switches a binary into shim mode.
+const ShimArg = "__nexus_landlock_shim"
+
+// shimSpecEnv carries the JSON-encoded ShimSpec. It is removed from the
+// environment before the user command is executed.
+const shimSpecEnv = "NEXUS_LANDLOCK_SPEC"
+
+// ShimSpec is the serialized form of a Ruleset passed to the shim.
+type ShimSpec struct {
+	Writable []string `json:"writable,omitempty"`
+	ReadOnly []string `json:"read_only,omitempty"`
+}
+
+// Spec returns the serializable form of the ruleset.
+func (rs *Ruleset) Spec() ShimSpec {
+	return ShimSpec{
+		Writable: append([]string(nil), rs.writablePaths...),
+		ReadOnly: append([]string(nil), rs.readOnlyPaths...),
+	}
+}
+
+// Required reports whether the capability demands Landlock enforcement.
+// Only the Phase 2c fields (WritableRoots/ReadOnlySubpaths) are enforceable;
+// the logical Read/Write selectors are audit-only.
+func Required(cap *protocol.FsCapabilityV1) bool {
+	return cap != nil && (len(cap.WritableRoots) > 0 || len(cap.ReadOnlySubpaths) > 0)
+}
+
+// Unenforceable returns the read-only subpaths of cap that lie within one
+// of its writable roots. Landlock rights only add up, so such a subpath
+// stays writable (e.g. /workspace/.git under a writable /workspace).
+func Unenforceable(cap *protocol.FsCapabilityV1) []string {
+	if cap == nil {
+		return nil
+	}
+	var out []string
+	for _, ro := range cap.ReadOnlySubpaths {
+		for _, root := range cap.WritableRoots {
+			if within(ro, root) {
+				out = append(out, ro)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Check fails when Landlock cannot enforce cap as given (see Unenforceable).
+// Drivers call it before running a command that requires Landlock, so the
+// command never runs with a read-only subpath left writable.
+func Check(cap *protocol.FsCapabilityV1) error {
+	if paths := Unenforceable(cap); len(paths) > 0 {
+		return fmt.Errorf("fs capability cannot be enforced: read-only subpaths inside writable roots: %s", strings.Join(paths, ", "))
+	}
+	return nil
+}
+
+func within(p, root string) bool {
+	p, root = filepath.Clean(p), filepath.Clean(root)
+	return p == root || root == "/" || strings.HasPrefix(p, root+"/")
+}
+
+// Summary describes Landlock enforcement for effective_capabilities_summary.
+// Read-only subpaths Landlock cannot enforce are listed under
+// "unenforced_read_only" (and the command is refused, see Check).
+func Summary(cap *protocol.FsCapabilityV1) map[string]any {
+	abi := ABIVersion()
+	unenforced := Unenforceable(cap)
+	summary := map[string]any{
+		"mechanism": "landlock",
+		"required":  Required(cap),
+		"enforced":  Required(cap) && abi > 0 && len(unenforced) == 0,
+		"abi":       abi,
+	}
+	if len(unenforced) > 0 {
+		summary["unenforced_read_only"] = unenforced
+	}
+	return summary
+}
+
+// ShimCommand wraps argv so that it runs under the given spec via the shim
+// binary at self. It returns the new argv and the environment entry
+// ("KEY=value") that must be added to the child's environment.
+func ShimCommand(self string, spec ShimSpec, argv []string) ([]string, string, error) {
+	if self == "" {
+		return nil, "", errors.New("landlock shim: executable path is required")
+	}
+	if len(argv) == 0 {
+		return nil, "", errors.New("landlock shim: command is required")
+	}
+	if len(spec.Writable) == 0 && len(spec.ReadOnly) == 0 {
+		return nil, "", errors.New("landlock shim: empty ruleset")
+	}
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return nil, "", fmt.Errorf("landlock shim: encode spec: %w", err)
+	}
+	out := make([]string, 0, len(argv)+3)
+	out = append(out, self, ShimArg, "--")
+	out = append(out, argv...)
+	return out, shimSpecEnv + "=" + string(b), nil
+}
+
+// RunShimIfRequested turns the current process into the Landlock shim when
+// invoked with ShimArg. On success it never returns (the process image is
+// replaced by the user command); on failure it exits with status 126 so the
+// command is never run unconfined. Without ShimArg it is a no-op.
+func RunShimIfRequested() {
+	if len(os.Args) < 2 || os.Args[1] != ShimArg {
+		return
+	}
+	err := runShim(os.Args[2:])
+	fmt.Fprintf(os.Stderr, "nexus: %v\n", err)
+	os.Exit(126)
+}
+
+func runShim(args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return errors.New("landlock shim: missing command")
+	}
+
+	raw := os.Getenv(shimSpecEnv)
+	_ = os.Unsetenv(shimSpecEnv)
+	var spec ShimSpec
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		return fmt.Errorf("landlock shim: decode spec: %w", err)
+	}
+	if len(spec.Writable) == 0 && len(spec.ReadOnly) == 0 {
+		return errors.New("landlock shim: empty ruleset")
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return fmt.Errorf("landlock shim: %w", err)
+	}
+
+	// Missing paths grant nothing, so dropping them keeps the policy closed
+	// while tolerating host layout differences (e.g. no /lib64 on arm64).
+	writable := existingPaths(spec.Writable)
+	readOnly := existingPaths(spec.ReadOnly)
+	if len(writable) == 0 && len(readOnly) == 0 {
+		// Apply would be a no-op and the command would run unconfined.
+		return errors.New("landlock shim: no ruleset paths exist")
+	}
+	rs := NewRuleset()
+	rs.AddWritable(writable...)
+	rs.AddReadOnly(readOnly...)
+
+	// landlock_restrict_self and PR_SET_NO_NEW_PRIVS are per-thread; execve
+	// must happen on the same OS thread for the child to inherit them.
+	runtime.LockOSThread()
+	if err := rs.Apply(); err != nil {
+		return err
+	}
+	if err := syscall.Exec(path, args, os.Environ()); err != nil {
+		return fmt.Errorf("landlock shim: exec %s: %w", path, err)
+	}
+	return nil
+}
+
+func existingPaths(paths []string) []string {
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			out = append(out, p)
+		}
+	}
+	return out
+}
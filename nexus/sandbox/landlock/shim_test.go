@@ -0,0 +1,109 @@
+package landlock
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"cybros.ai/nexus/protocol"
+)
+
+func TestRequired(t *testing.T) {
+	cases := []struct {
+		name string
+		cap  *protocol.FsCapabilityV1
+		want bool
+	}{
+		{"nil", nil, false},
+		{"selectors only", &protocol.FsCapabilityV1{Read: []string{"workspace:**"}}, false},
+		{"writable roots", &protocol.FsCapabilityV1{WritableRoots: []string{"/srv/ws"}}, true},
+		{"read-only subpaths", &protocol.FsCapabilityV1{ReadOnlySubpaths: []string{"/srv/ref"}}, true},
+	}
+	for _, tc := range cases {
+		if got := Required(tc.cap); got != tc.want {
+			t.Errorf("%s: Required = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestShimCommand(t *testing.T) {
+	spec := ShimSpec{Writable: []string{"/srv/ws"}, ReadOnly: []string{"/usr"}}
+	argv, env, err := ShimCommand("/usr/bin/nexusd", spec, []string{"/bin/sh", "-c", "true"})
+	if err != nil {
+		t.Fatalf("ShimCommand: %v", err)
+	}
+
+	want := "/usr/bin/nexusd " + ShimArg + " -- /bin/sh -c true"
+	if got := strings.Join(argv, " "); got != want {
+		t.Errorf("argv = %q, want %q", got, want)
+	}
+
+	key, val, ok := strings.Cut(env, "=")
+	if !ok || key != shimSpecEnv {
+		t.Fatalf("env = %q, want %s=...", env, shimSpecEnv)
+	}
+	var decoded ShimSpec
+	if err := json.Unmarshal([]byte(val), &decoded); err != nil {
+		t.Fatalf("decode spec: %v", err)
+	}
+	if len(decoded.Writable) != 1 || decoded.Writable[0] != "/srv/ws" {
+		t.Errorf("decoded writable = %v", decoded.Writable)
+	}
+}
+
+func TestShimCommand_Errors(t *testing.T) {
+	spec := ShimSpec{Writable: []string{"/srv/ws"}}
+	if _, _, err := ShimCommand("", spec, []string{"/bin/true"}); err == nil {
+		t.Error("expected error for empty executable path")
+	}
+	if _, _, err := ShimCommand("/usr/bin/nexusd", spec, nil); err == nil {
+		t.Error("expected error for empty command")
+	}
+	if _, _, err := ShimCommand("/usr/bin/nexusd", ShimSpec{}, []string{"/bin/true"}); err == nil {
+		t.Error("expected error for empty ruleset (would run unconfined)")
+	}
+}
+
+func TestRunShim_RejectsEmptySpec(t *testing.T) {
+	t.Setenv(shimSpecEnv, `{}`)
+	if err := runShim([]string{"--", "/bin/true"}); err == nil {
+		t.Error("expected error for empty spec")
+	}
+}
+
+func TestRunShim_RejectsMissingPaths(t *testing.T) {
+	t.Setenv(shimSpecEnv, `{"writable":["/nonexistent/nexus-landlock-test"]}`)
+	if err := runShim([]string{"--", "/bin/true"}); err == nil {
+		t.Error("expected error when no ruleset path exists")
+	}
+}
+
+func TestUnenforceable(t *testing.T) {
+	cap := &protocol.FsCapabilityV1{
+		WritableRoots:    []string{"/srv/ws", "/srv/out/"},
+		ReadOnlySubpaths: []string{"/srv/ws/.git", "/srv/ref", "/srv/out", "/srv/wsx"},
+	}
+	got := Unenforceable(cap)
+	if want := []string{"/srv/ws/.git", "/srv/out"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Unenforceable = %v, want %v", got, want)
+	}
+	if err := Check(cap); err == nil || !strings.Contains(err.Error(), "/srv/ws/.git") {
+		t.Errorf("Check = %v, want an error naming /srv/ws/.git", err)
+	}
+	if err := Check(&protocol.FsCapabilityV1{WritableRoots: []string{"/srv/ws"}, ReadOnlySubpaths: []string{"/srv/ref"}}); err != nil {
+		t.Errorf("Check of disjoint paths: %v", err)
+	}
+}
+
+func TestSummary_NestedReadOnly(t *testing.T) {
+	s := Summary(&protocol.FsCapabilityV1{
+		WritableRoots:    []string{"/srv/ws"},
+		ReadOnlySubpaths: []string{"/srv/ws/.git"},
+	})
+	if s["enforced"] != false {
+		t.Errorf("enforced = %v, want false", s["enforced"])
+	}
+	if got, _ := s["unenforced_read_only"].([]string); len(got) != 1 || got[0] != "/srv/ws/.git" {
+		t.Errorf("unenforced_read_only = %v", s["unenforced_read_only"])
+	}
+}
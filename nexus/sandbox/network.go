@@ -0,0 +1,26 @@
+package sandbox
+
+import (
+	"context"
+
+	"cybros.ai/nexus/helper"
+)
+
+// TapNetwork creates a directive's TAP device and its nftables egress table
+// on behalf of a daemon that cannot do so itself (through nexus-helper).
+// Device and table names are derived from the directive ID.
+type TapNetwork interface {
+	// CreateTap creates the TAP device with hostCIDR as its host-side
+	// address and returns its name. owner, if non-zero, is the uid (and gid)
+	// that will open the device instead of nexusd.
+	CreateTap(ctx context.Context, directiveID, hostCIDR string, owner int) (string, error)
+	// ApplyEgress installs or atomically replaces the device's rules.
+	ApplyEgress(ctx context.Context, rules helper.NftApplyParams) error
+	// RemoveTap deletes the rules and the device.
+	RemoveTap(ctx context.Context, directiveID string) error
+	// CreateNetns creates the directive's network namespace, holding only a
+	// loopback device, for a jailed VMM (helper.JailerLaunchParams.Netns).
+	CreateNetns(ctx context.Context, directiveID string) error
+	// DeleteNetns deletes the namespace.
+	DeleteNetns(ctx context.Context, directiveID string) error
+}
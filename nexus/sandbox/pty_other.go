@@ -0,0 +1,20 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os"
+)
+
+func ptySupported() bool { return false }
+
+var errPTYUnsupported = errors.New("pseudo-terminals are not supported on this platform")
+
+func openPTY() (pty, tty *os.File, err error) {
+	return nil, nil, errPTYUnsupported
+}
+
+func setWinsize(pty *os.File, rows, cols int) error {
+	return errPTYUnsupported
+}
@@ -0,0 +1,235 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"cybros.ai/nexus/egressproxy"
+)
+
+// SecretsMountPath is where isolated drivers expose file secrets inside the
+// sandbox. Host-executing drivers point CYBROS_SECRETS_DIR at the host-side
+// tmpfs directory instead.
+const SecretsMountPath = "/run/secrets"
+
+// SecretsDirEnv names the directory holding file secrets for the command.
+const SecretsDirEnv = "CYBROS_SECRETS_DIR"
+
+// ErrSecretsTmpfsUnsupported is returned when file secrets are requested but
+// no memory-backed directory is available to hold them.
+var ErrSecretsTmpfsUnsupported = errors.New("secrets: no memory-backed filesystem available")
+
+// EgressCAFile is the file secret holding the CA bundle that trusts the
+// egress proxy's credential injection (Secrets.Intercept).
+const EgressCAFile = "cybros-egress-ca.pem"
+
+// EgressCAEnv are the variables pointing common TLS clients (OpenSSL, curl,
+// git, Python requests, Node) at EgressCAFile.
+var EgressCAEnv = []string{
+	"SSL_CERT_FILE", "CURL_CA_BUNDLE", "GIT_SSL_CAINFO", "REQUESTS_CA_BUNDLE", "NODE_EXTRA_CA_CERTS",
+}
+
+// SecretsDeliverer is implemented by drivers that can deliver Secrets into the
+// sandbox without writing values to disk or process arguments. Directives
+// that request secrets are rejected on other drivers.
+type SecretsDeliverer interface {
+	DeliversSecrets() bool
+}
+
+// CredentialInjector is implemented by drivers that route every egress
+// connection through the directive's egress proxy, which can then inject
+// HTTP credentials (Secrets.Intercept). Directives with http_auth secrets
+// are rejected on other drivers.
+type CredentialInjector interface {
+	InjectsCredentials() bool
+}
+
+// Secrets are the resolved secret values for one directive. Values only live
+// in memory: Env is passed through process environments and, on Linux, every
+// value is also materialized under Dir on tmpfs:
+//
+//	<Dir>/files/<name>  file secrets (exposed at SecretsMountPath)
+//	<Dir>/env/<NAME>    env secrets, for drivers whose sandbox entrypoint
+//	                    cannot inherit the driver's environment
+//
+// Intercept holds the http_auth secrets, which never enter the sandbox: the
+// egress proxy injects them (the sandbox only gets EgressCAFile).
+type Secrets struct {
+	Env       map[string]string
+	Files     map[string]string
+	Dir       string
+	Intercept *egressproxy.Intercept
+}
+
+// NewSecrets materializes env and file secrets for a directive under a fresh
+// directory in base, which must be memory-backed (tmpfs/ramfs). On platforms
+// without tmpfs, env-only secrets are kept in memory and file secrets fail
+// with ErrSecretsTmpfsUnsupported. Close removes everything.
+func NewSecrets(base, directiveID string, env, files map[string]string) (*Secrets, error) {
+	s := &Secrets{Env: env, Files: files}
+	if len(env) == 0 && len(files) == 0 {
+		return s, nil
+	}
+	if !secretsTmpfsSupported() {
+		if len(files) > 0 {
+			return nil, ErrSecretsTmpfsUnsupported
+		}
+		return s, nil
+	}
+
+	ok, err := isMemoryBacked(base)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not tmpfs", ErrSecretsTmpfsUnsupported, base)
+	}
+	dir, err := os.MkdirTemp(base, "nexus-secrets-"+directiveID+"-")
+	if err != nil {
+		return nil, fmt.Errorf("secrets: %w", err)
+	}
+	s.Dir = dir
+	if err := writeSecretFiles(filepath.Join(dir, "files"), files); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	if err := writeSecretFiles(filepath.Join(dir, "env"), env); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func writeSecretFiles(dir string, values map[string]string) error {
+	if err := os.Mkdir(dir, 0o700); err != nil {
+		return fmt.Errorf("secrets: %w", err)
+	}
+	for name, v := range values {
+		if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+			return fmt.Errorf("secrets: invalid name %q", name)
+		}
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o400)
+		if err != nil {
+			return fmt.Errorf("secrets: %w", err)
+		}
+		_, werr := f.WriteString(v)
+		cerr := f.Close()
+		if err := errors.Join(werr, cerr); err != nil {
+			return fmt.Errorf("secrets: write %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// FilesDir returns the host directory holding file secrets, or "" if there
+// are none.
+func (s *Secrets) FilesDir() string {
+	if s == nil || s.Dir == "" || len(s.Files) == 0 {
+		return ""
+	}
+	return filepath.Join(s.Dir, "files")
+}
+
+// EnvDir returns the host directory holding env secret values, or "" if
+// they are not materialized.
+func (s *Secrets) EnvDir() string {
+	if s == nil || s.Dir == "" || len(s.Env) == 0 {
+		return ""
+	}
+	return filepath.Join(s.Dir, "env")
+}
+
+// HostEnv returns the variables to add to a host-executed command's
+// environment: the env secrets plus SecretsDirEnv pointing at the host-side
+// files directory.
+func (s *Secrets) HostEnv() map[string]string {
+	if s == nil {
+		return nil
+	}
+	env := make(map[string]string, len(s.Env)+1)
+	for k, v := range s.Env {
+		env[k] = v
+	}
+	if dir := s.FilesDir(); dir != "" {
+		env[SecretsDirEnv] = dir
+	}
+	return env
+}
+
+// EgressIntercept returns the proxy's credential injection, or nil.
+func (s *Secrets) EgressIntercept() *egressproxy.Intercept {
+	if s == nil {
+		return nil
+	}
+	return s.Intercept
+}
+
+// TrustEnv returns the variables that make TLS clients in an isolated
+// sandbox trust the egress proxy's CA, or nil without credential injection.
+func (s *Secrets) TrustEnv() map[string]string {
+	if s.EgressIntercept() == nil {
+		return nil
+	}
+	env := make(map[string]string, len(EgressCAEnv))
+	for _, k := range EgressCAEnv {
+		env[k] = SecretsMountPath + "/" + EgressCAFile
+	}
+	return env
+}
+
+// EnvNames returns the env secret names, sorted.
+func (s *Secrets) EnvNames() []string {
+	if s == nil {
+		return nil
+	}
+	return sortedKeys(s.Env)
+}
+
+// FileNames returns the file secret names, sorted.
+func (s *Secrets) FileNames() []string {
+	if s == nil {
+		return nil
+	}
+	return sortedKeys(s.Files)
+}
+
+// Values returns every secret value, for log redaction.
+func (s *Secrets) Values() []string {
+	if s == nil {
+		return nil
+	}
+	out := make([]string, 0, len(s.Env)+len(s.Files))
+	for _, v := range s.Env {
+		out = append(out, v)
+	}
+	for name, v := range s.Files {
+		if name == EgressCAFile && s.Intercept != nil {
+			continue // public certificates
+		}
+		out = append(out, v)
+	}
+	return append(out, s.Intercept.Values()...)
+}
+
+// Close removes the tmpfs directory. Safe on nil and to call more than once.
+func (s *Secrets) Close() error {
+	if s == nil || s.Dir == "" {
+		return nil
+	}
+	// Files are 0400 inside 0700 dirs: removal only needs the dirs writable.
+	err := os.RemoveAll(s.Dir)
+	s.Dir = ""
+	return err
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package sandbox
+
+import "syscall"
+
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+func secretsTmpfsSupported() bool { return true }
+
+// isMemoryBacked reports whether path lives on tmpfs or ramfs.
+func isMemoryBacked(path string) (bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false, err
+	}
+	t := uint32(st.Type)
+	return t == tmpfsMagic || t == ramfsMagic, nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package sandbox
+
+// Without tmpfs, only env secrets (held in memory) are supported.
+func secretsTmpfsSupported() bool { return false }
+
+func isMemoryBacked(path string) (bool, error) { return false, nil }
@@ -0,0 +1,97 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestNewSecrets_Empty(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewSecrets(t.TempDir(), "d-1", nil, nil)
+	if err != nil {
+		t.Fatalf("NewSecrets: %v", err)
+	}
+	if s.Dir != "" || s.FilesDir() != "" || s.EnvDir() != "" || len(s.HostEnv()) != 0 {
+		t.Fatalf("expected nothing materialized, got %+v", s)
+	}
+}
+
+func TestNewSecrets_RejectsDiskBackedDir(t *testing.T) {
+	t.Parallel()
+
+	base := t.TempDir()
+	if ok, _ := isMemoryBacked(base); ok {
+		t.Skip("temp dir is memory-backed")
+	}
+	if _, err := NewSecrets(base, "d-1", nil, map[string]string{"key": "v"}); err == nil {
+		t.Fatal("expected error for a disk-backed secrets dir")
+	}
+	entries, _ := os.ReadDir(base)
+	if len(entries) != 0 {
+		t.Fatalf("nothing may be written to a disk-backed dir, found %d entries", len(entries))
+	}
+}
+
+func TestNewSecrets_Tmpfs(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("tmpfs secrets are Linux-only")
+	}
+	if ok, _ := isMemoryBacked("/dev/shm"); !ok {
+		t.Skip("/dev/shm is not tmpfs")
+	}
+
+	s, err := NewSecrets("/dev/shm", "d-1",
+		map[string]string{"TOKEN": "tok\n"},
+		map[string]string{"key.pem": "pem"})
+	if err != nil {
+		t.Fatalf("NewSecrets: %v", err)
+	}
+	defer s.Close()
+
+	b, err := os.ReadFile(filepath.Join(s.FilesDir(), "key.pem"))
+	if err != nil || string(b) != "pem" {
+		t.Fatalf("file secret: %q, %v", b, err)
+	}
+	b, err = os.ReadFile(filepath.Join(s.EnvDir(), "TOKEN"))
+	if err != nil || string(b) != "tok\n" {
+		t.Fatalf("env secret: %q, %v", b, err)
+	}
+	fi, err := os.Stat(filepath.Join(s.FilesDir(), "key.pem"))
+	if err != nil || fi.Mode().Perm() != 0o400 {
+		t.Fatalf("expected mode 0400, got %v, %v", fi.Mode(), err)
+	}
+	env := s.HostEnv()
+	if env["TOKEN"] != "tok\n" || env[SecretsDirEnv] != s.FilesDir() {
+		t.Fatalf("unexpected host env: %v", env)
+	}
+
+	dir := s.Dir
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected %s removed, got %v", dir, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+func TestNewSecrets_InvalidName(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("tmpfs secrets are Linux-only")
+	}
+	if ok, _ := isMemoryBacked("/dev/shm"); !ok {
+		t.Skip("/dev/shm is not tmpfs")
+	}
+	if _, err := NewSecrets("/dev/shm", "d-1", nil, map[string]string{"../x": "v"}); err == nil {
+		t.Fatal("expected error for a path-like secret name")
+	}
+}
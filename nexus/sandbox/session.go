@@ -0,0 +1,240 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"cybros.ai/nexus/sandbox/sessionagent"
+)
+
+const (
+	// sessionDrainTimeout is how long an exec's output may go on after the
+	// command exited, while processes it left behind hold the streams.
+	sessionDrainTimeout = 2 * time.Second
+	// sessionStopTimeout is how long a session's sandbox may take to end
+	// after its agent was told to stop, before it is killed.
+	sessionStopTimeout = 5 * time.Second
+	// maxSessionSetupLog bounds the setup output passed on to the opener.
+	maxSessionSetupLog = 1 << 20
+)
+
+// SessionRunner is implemented by drivers that can keep one sandbox alive
+// for several commands (DirectiveSpec.Session). Sessions are rejected on
+// other drivers.
+type SessionRunner interface {
+	// OpenSession sets up the sandbox for req as Run would (egress proxy,
+	// facility preparation, secrets, limits) but runs no command. Setup
+	// output goes to req.LogSink.
+	OpenSession(ctx context.Context, req RunRequest) (Session, error)
+}
+
+// Session is a sandbox kept alive across commands: files, the environment
+// set up at open and processes a command leaves in the background persist
+// until Close.
+type Session interface {
+	// Exec runs req.Command in the session like Driver.Run. Only the
+	// command's own fields (Command, Shell, Cwd, Env, Terminal, LogSink)
+	// are used; everything else stays as the session was opened.
+	Exec(ctx context.Context, req RunRequest) (RunResult, error)
+	// Close ends the session and whatever still runs in it.
+	Close() error
+}
+
+// SessionStart is how a driver starts the sandbox of an AgentSession.
+type SessionStart struct {
+	// Cmd returns the command starting the sandbox, whose last setup step
+	// runs the session agent (sessionagent.Command) connecting to the
+	// socket at the given host path. Its output is the session's setup log.
+	Cmd func(socket string) (*exec.Cmd, error)
+	// Command maps an Exec request to what the agent runs, as seen inside
+	// the sandbox.
+	Command func(RunRequest) (sessionagent.Request, error)
+	// CgroupLimits applies req.Limits to the sandbox (see ApplyLimits).
+	CgroupLimits bool
+	// Kill, when set, force-stops a sandbox that did not end by itself
+	// (besides killing Cmd's process group).
+	Kill func()
+	// Cleanup releases what the sandbox used once it ended.
+	Cleanup func()
+}
+
+// AgentSession is a Session whose commands run through a session agent
+// (see package sessionagent). Exec runs one command at a time.
+type AgentSession struct {
+	agent   *sessionagent.Conn
+	command func(RunRequest) (sessionagent.Request, error)
+
+	stopOnce sync.Once
+	stop     func()
+}
+
+// StartAgentSession starts the sandbox described by start for req, waits
+// until its agent connected, and passes the setup output on to
+// req.LogSink as "stderr". start.Cleanup runs when the session ends, or
+// right away if it could not be started.
+func StartAgentSession(ctx context.Context, req RunRequest, start SessionStart) (*AgentSession, error) {
+	cleanup := func() {}
+	if start.Cleanup != nil {
+		cleanup = start.Cleanup
+	}
+
+	// The socket lives in a directory of its own that the sandbox never
+	// sees, so nothing inside can redirect the connection.
+	dir, err := os.MkdirTemp("", "nexus-session-*")
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("create session dir: %w", err)
+	}
+	socket := filepath.Join(dir, "agent.sock")
+	abort := func(err error) (*AgentSession, error) {
+		cleanup()
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return abort(fmt.Errorf("listen for session agent: %w", err))
+	}
+	defer l.Close()
+	setupLog, err := os.Create(filepath.Join(dir, "setup.log"))
+	if err != nil {
+		return abort(fmt.Errorf("create setup log: %w", err))
+	}
+	defer setupLog.Close()
+
+	cmd, err := start.Cmd(socket)
+	if err != nil {
+		return abort(err)
+	}
+	cmd.Stdout, cmd.Stderr = setupLog, setupLog
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	if err := cmd.Start(); err != nil {
+		return abort(fmt.Errorf("start session: %w", err))
+	}
+	pgid := cmd.Process.Pid
+
+	cgCleanup := func() {}
+	if start.CgroupLimits {
+		// Fail-closed, as in Run: no session without the requested limits.
+		cgCleanup, err = ApplyLimits(ctx, req, pgid)
+		if err != nil {
+			_ = syscall.Kill(-pgid, syscall.SIGKILL)
+			_ = cmd.Wait()
+			return abort(fmt.Errorf("cgroup limits required but failed to apply: %w", err))
+		}
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	var agent *sessionagent.Conn
+	stop := func() {
+		if agent != nil {
+			_ = agent.Close()
+		}
+		select {
+		case <-exited:
+		case <-time.After(sessionStopTimeout):
+			if start.Kill != nil {
+				start.Kill()
+			}
+		}
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		<-exited
+		cgCleanup()
+		cleanup()
+		_ = os.RemoveAll(dir)
+	}
+
+	agent, err = sessionagent.Accept(ctx, l, exited)
+	if req.LogSink != nil {
+		if f, openErr := os.Open(setupLog.Name()); openErr == nil {
+			_ = req.LogSink.Consume(ctx, "stderr", io.LimitReader(f, maxSessionSetupLog))
+			f.Close()
+		}
+	}
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	return &AgentSession{agent: agent, command: start.Command, stop: stop}, nil
+}
+
+// NewAgentSession is an AgentSession over an agent the driver connected
+// itself; stop ends the sandbox once the agent was closed.
+func NewAgentSession(agent *sessionagent.Conn, command func(RunRequest) (sessionagent.Request, error), stop func()) *AgentSession {
+	return &AgentSession{agent: agent, command: command, stop: func() {
+		_ = agent.Close()
+		stop()
+	}}
+}
+
+// Exec implements Session.
+func (s *AgentSession) Exec(ctx context.Context, req RunRequest) (RunResult, error) {
+	if req.Command == "" {
+		return RunResult{}, errors.New("empty command")
+	}
+	if req.LogSink == nil {
+		return RunResult{}, errors.New("LogSink is required")
+	}
+	areq, err := s.command(req)
+	if err != nil {
+		return RunResult{}, err
+	}
+	areq.TTY = req.Terminal != nil
+
+	cio, streams, err := SessionIO(req)
+	if err != nil {
+		return RunResult{}, err
+	}
+	defer cio.Close()
+	if err := s.agent.Start(areq, streams); err != nil {
+		return RunResult{}, err
+	}
+
+	streamed := make(chan error, 1)
+	go func() { streamed <- cio.Stream(ctx, req.LogSink) }()
+	code, waitErr := s.agent.Wait(ctx)
+	cio.Drain(sessionDrainTimeout)
+	consumeErr := <-streamed
+	if waitErr != nil && ctx.Err() == nil {
+		return RunResult{}, waitErr
+	}
+
+	result := RunResult{ExitCode: code, Status: "succeeded"}
+	if code != 0 {
+		result.Status = "failed"
+	}
+	if tr, ok := req.LogSink.(TruncationReporter); ok {
+		result.StdoutTruncated = tr.StdoutTruncated()
+		result.StderrTruncated = tr.StderrTruncated()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		result.Status = "timed_out"
+		result.ExitCode = 124
+	} else if errors.Is(ctx.Err(), context.Canceled) {
+		result.Status = "canceled"
+		result.ExitCode = 128 + int(syscall.SIGKILL)
+	}
+	return result, consumeErr
+}
+
+// Close implements Session. Safe to call more than once.
+func (s *AgentSession) Close() error {
+	s.stopOnce.Do(s.stop)
+	return nil
+}
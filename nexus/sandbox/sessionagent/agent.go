@@ -0,0 +1,436 @@
+// Package sessionagent runs the commands of a long-lived sandbox session.
+//
+// A session keeps one sandbox alive for several commands. Its last setup
+// step runs the nexusd binary as the session agent:
+//
+//	nexusd __nexus_session_agent /run/nexus-session.sock
+//
+// The agent connects to the driver's socket (bind-mounted into the sandbox)
+// and waits for commands on that connection. For each command the driver
+// sends a Request together with the command's standard streams
+// (SCM_RIGHTS), so the output goes straight to the driver's pipes or
+// terminal; the agent starts the command in a process group of its own and
+// answers with a Reply once it exited. Processes a command leaves in the
+// background keep running: the connection closing ends the session, and the
+// agent then kills every group it started and exits.
+//
+// Inside a VM the agent reaches the host over vsock instead, which cannot
+// carry descriptors:
+//
+//	nexusd __nexus_session_agent vsock:<port>
+//
+// Then each command's stdout and stderr are connections the agent makes to
+// the same port, announced with a token from the Request (see ServeRelay);
+// stdin is /dev/null.
+// Binaries that may act as the agent must call RunIfRequested at the very
+// top of main (or TestMain).
+package sessionagent
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Arg is the argv[1] sentinel that switches a binary into agent mode.
+const Arg = "__nexus_session_agent"
+
+// maxRequestBytes bounds one encoded Request.
+const maxRequestBytes = 1 << 20
+
+// Request is one message to the agent: a command to run, sent with its
+// stdin, stdout and stderr attached, or Kill.
+type Request struct {
+	Argv []string `json:"argv,omitempty"`
+	Dir  string   `json:"dir,omitempty"`
+	// Env is added to the agent's own environment, which the sandbox set
+	// up (proxy, secrets); later entries win.
+	Env []string `json:"env,omitempty"`
+	// TTY makes the streams, all three one terminal, the command's
+	// controlling terminal in a new session.
+	TTY bool `json:"tty,omitempty"`
+
+	// Relay is set instead of attaching streams (see ServeRelay): the agent
+	// connects the command's stdout and stderr back, each announced by a
+	// line "<Relay> <1|2>".
+	Relay string `json:"relay,omitempty"`
+
+	// Kill kills the running command's process group (no streams).
+	Kill bool `json:"kill,omitempty"`
+	// Freeze and Thaw freeze and thaw the filesystem mounted at the path
+	// (no streams), so the driver can read its image between commands.
+	Freeze string `json:"freeze,omitempty"`
+	Thaw   string `json:"thaw,omitempty"`
+}
+
+// Reply reports how a command ended.
+type Reply struct {
+	ExitCode int `json:"exit_code"`
+	// Error is set when the command could not be started.
+	Error string `json:"error,omitempty"`
+}
+
+// Command returns the argv that runs the binary at self as the agent
+// connecting to socket.
+func Command(self, socket string) []string {
+	return []string{self, Arg, socket}
+}
+
+// VsockTarget is the socket argument of an agent inside a VM connecting to
+// port on the host.
+func VsockTarget(port int) string {
+	return fmt.Sprintf("%s%d", vsockPrefix, port)
+}
+
+const vsockPrefix = "vsock:"
+
+// RunIfRequested turns the current process into the session agent when
+// invoked with Arg, and exits when the session ends. Without Arg it is a
+// no-op.
+func RunIfRequested() {
+	if len(os.Args) < 2 || os.Args[1] != Arg {
+		return
+	}
+	if len(os.Args) != 3 {
+		fmt.Fprintf(os.Stderr, "nexus: session agent: usage: %s %s <socket|vsock:port>\n", os.Args[0], Arg)
+		os.Exit(2)
+	}
+	if err := run(os.Args[2]); err != nil {
+		fmt.Fprintf(os.Stderr, "nexus: session agent: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func run(socket string) error {
+	if p, ok := strings.CutPrefix(socket, vsockPrefix); ok {
+		port, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid vsock port %q", p)
+		}
+		dial := func() (*os.File, error) { return dialVsock(uint32(port)) }
+		conn, err := dial()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		return ServeRelay(conn, dial)
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return Serve(conn)
+}
+
+// Serve runs the commands requested on conn, one at a time, until conn is
+// closed, then kills every process group it started.
+func Serve(conn *net.UnixConn) error {
+	return serve(conn, func() (Request, []*os.File, error) { return readRequest(conn) }, nil)
+}
+
+// ServeRelay is Serve over a connection that cannot carry descriptors: the
+// streams of each command are connections made with dial, each starting
+// with the line announcing it (see Request.Relay).
+func ServeRelay(conn io.ReadWriter, dial func() (*os.File, error)) error {
+	return serve(conn, func() (Request, []*os.File, error) {
+		req, err := readPlainRequest(conn)
+		return req, nil, err
+	}, dial)
+}
+
+func serve(conn io.Writer, read func() (Request, []*os.File, error), dial func() (*os.File, error)) error {
+	a := &agent{conn: conn, dial: dial, groups: map[int]struct{}{}, frozen: map[string]struct{}{}}
+	defer a.killAll()
+	for {
+		req, files, err := read()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		switch {
+		case req.Kill:
+			a.kill()
+		case req.Freeze != "" || req.Thaw != "":
+			a.freeze(req)
+		default:
+			if req.Relay != "" {
+				if files, err = a.connectStreams(req.Relay); err != nil {
+					a.mu.Lock()
+					a.reply(Reply{Error: fmt.Sprintf("connect streams: %v", err)})
+					a.mu.Unlock()
+					continue
+				}
+			}
+			a.start(req, files)
+		}
+	}
+}
+
+type agent struct {
+	conn io.Writer
+	dial func() (*os.File, error) // relay mode only
+
+	mu      sync.Mutex
+	running int                 // process group of the running command, or 0
+	groups  map[int]struct{}    // process groups started, killed when the session ends
+	frozen  map[string]struct{} // filesystems frozen, thawed when the session ends
+}
+
+// connectStreams makes a relayed command's streams: /dev/null and the two
+// connections announced with token.
+func (a *agent) connectStreams(token string) ([]*os.File, error) {
+	if a.dial == nil {
+		return nil, errors.New("streams must be attached on this connection")
+	}
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		return nil, err
+	}
+	files := []*os.File{null}
+	for i := 1; i <= 2; i++ {
+		f, err := a.dial()
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+		if _, err := fmt.Fprintf(f, "%s %d\n", token, i); err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// start starts req with files as its streams; the Reply is sent once it
+// exited.
+func (a *agent) start(req Request, files []*os.File) {
+	defer closeFiles(files) // the command holds its own copies once started
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running != 0 {
+		a.reply(Reply{Error: "a command is already running"})
+		return
+	}
+	if len(files) != 3 {
+		a.reply(Reply{Error: fmt.Sprintf("got %d streams, want 3", len(files))})
+		return
+	}
+	if len(req.Argv) == 0 {
+		a.reply(Reply{Error: "empty command"})
+		return
+	}
+
+	cmd := exec.Command(req.Argv[0], req.Argv[1:]...)
+	cmd.Dir = req.Dir
+	cmd.Env = append(os.Environ(), req.Env...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = files[0], files[1], files[2]
+	if req.TTY {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	} else {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	a.prune()
+	if err := cmd.Start(); err != nil {
+		a.reply(Reply{Error: fmt.Sprintf("start: %v", err)})
+		return
+	}
+	pgid := cmd.Process.Pid
+	a.running = pgid
+	a.groups[pgid] = struct{}{}
+
+	go func() {
+		_ = cmd.Wait()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.running = 0
+		a.reply(Reply{ExitCode: exitCode(cmd.ProcessState)})
+	}()
+}
+
+// reply sends r; the caller holds a.mu, which keeps replies whole.
+func (a *agent) reply(r Reply) {
+	_ = json.NewEncoder(a.conn).Encode(r)
+}
+
+func (a *agent) kill() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.running != 0 {
+		_ = syscall.Kill(-a.running, syscall.SIGKILL)
+	}
+}
+
+// prune forgets process groups that are gone, so their IDs are not
+// signalled once reused. The caller holds a.mu.
+func (a *agent) prune() {
+	for pgid := range a.groups {
+		if err := syscall.Kill(-pgid, 0); errors.Is(err, syscall.ESRCH) {
+			delete(a.groups, pgid)
+		}
+	}
+}
+
+// freeze freezes or thaws a filesystem for the driver.
+func (a *agent) freeze(req Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var err error
+	if req.Freeze != "" {
+		if err = freezeFS(req.Freeze, true); err == nil {
+			a.frozen[req.Freeze] = struct{}{}
+		}
+	} else {
+		if err = freezeFS(req.Thaw, false); err == nil {
+			delete(a.frozen, req.Thaw)
+		}
+	}
+	if err != nil {
+		a.reply(Reply{Error: err.Error()})
+		return
+	}
+	a.reply(Reply{})
+}
+
+func (a *agent) killAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for path := range a.frozen {
+		_ = freezeFS(path, false)
+	}
+	for pgid := range a.groups {
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+}
+
+// A request on the wire is a 4-byte big-endian length and the JSON-encoded
+// Request, with the streams (if any) attached to the first byte.
+
+func writeRequest(conn *net.UnixConn, req Request, files []*os.File) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	msg := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	msg = append(msg, payload...)
+	var oob []byte
+	if len(files) > 0 {
+		fds := make([]int, len(files))
+		for i, f := range files {
+			fds[i] = int(f.Fd())
+		}
+		oob = syscall.UnixRights(fds...)
+	}
+	n, _, err := conn.WriteMsgUnix(msg, oob, nil)
+	if err == nil && n < len(msg) {
+		_, err = conn.Write(msg[n:])
+	}
+	return err
+}
+
+// readPlainRequest reads a request sent without streams.
+func readPlainRequest(r io.Reader) (Request, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Request{}, err
+	}
+	return readPayload(r, binary.BigEndian.Uint32(hdr[:]))
+}
+
+func readPayload(r io.Reader, size uint32) (Request, error) {
+	if size > maxRequestBytes {
+		return Request{}, fmt.Errorf("request of %d bytes exceeds %d", size, maxRequestBytes)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Request{}, err
+	}
+	var req Request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return Request{}, fmt.Errorf("decode request: %w", err)
+	}
+	return req, nil
+}
+
+func readRequest(conn *net.UnixConn) (Request, []*os.File, error) {
+	var hdr [4]byte
+	oob := make([]byte, syscall.CmsgSpace(3*4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(hdr[:], oob)
+	if err != nil {
+		return Request{}, nil, err
+	}
+	if n == 0 {
+		return Request{}, nil, io.EOF
+	}
+	files, err := parseFiles(oob[:oobn])
+	if err != nil {
+		return Request{}, nil, err
+	}
+	if _, err := io.ReadFull(conn, hdr[n:]); err != nil {
+		closeFiles(files)
+		return Request{}, nil, err
+	}
+	req, err := readPayload(conn, binary.BigEndian.Uint32(hdr[:]))
+	if err != nil {
+		closeFiles(files)
+		return Request{}, nil, err
+	}
+	return req, files, nil
+}
+
+// parseFiles returns the descriptors passed in oob, close-on-exec so they
+// do not leak into later commands.
+func parseFiles(oob []byte) ([]*os.File, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, fmt.Errorf("receive streams: %w", err)
+	}
+	var files []*os.File
+	for _, m := range msgs {
+		fds, err := syscall.ParseUnixRights(&m)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			syscall.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), "stream"))
+		}
+	}
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// exitCode follows sandbox.ExitCode: the exit status, or 128+signal.
+func exitCode(ps *os.ProcessState) int {
+	if ps == nil {
+		return 1
+	}
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok {
+		if ws.Exited() {
+			return ws.ExitStatus()
+		}
+		if ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+	}
+	return 1
+}
@@ -0,0 +1,228 @@
+package sessionagent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startAgent serves an in-process agent and returns the driver's end.
+func startAgent(t *testing.T) *Conn {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socket, Net: "unix"})
+		if err != nil {
+			served <- err
+			return
+		}
+		defer c.Close()
+		served <- Serve(c)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := Accept(ctx, l, nil)
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		if err := <-served; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return conn
+}
+
+// runScript runs script on conn and returns its exit code and output.
+func runScript(t *testing.T, ctx context.Context, conn *Conn, script string) (int, string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+
+	err = conn.Start(Request{Argv: []string{"/bin/sh", "-c", script}, Env: []string{"AGENT_TEST=env"}}, [3]*os.File{null, w, w})
+	w.Close()
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	out := make(chan string, 1)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	code, err := conn.Wait(ctx)
+	return code, <-out, err
+}
+
+func TestAgent_ExitCodesAndOutput(t *testing.T) {
+	t.Parallel()
+
+	conn := startAgent(t)
+
+	code, out, err := runScript(t, context.Background(), conn, `echo "$AGENT_TEST"; echo err >&2; exit 7`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 7 || out != "env\nerr\n" {
+		t.Errorf("got exit code %d, output %q", code, out)
+	}
+
+	code, _, err = runScript(t, context.Background(), conn, `kill -KILL $$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 137 {
+		t.Errorf("killed command exit code = %d, want 137", code)
+	}
+}
+
+func TestAgent_StartError(t *testing.T) {
+	t.Parallel()
+
+	conn := startAgent(t)
+
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	if err := conn.Start(Request{Argv: []string{"/nonexistent/binary"}}, [3]*os.File{null, null, null}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Wait(context.Background()); err == nil || !strings.Contains(err.Error(), "start") {
+		t.Errorf("Wait error = %v, want a start error", err)
+	}
+
+	// The agent keeps serving.
+	if code, out, err := runScript(t, context.Background(), conn, "echo ok"); err != nil || code != 0 || out != "ok\n" {
+		t.Errorf("after a start error: %d %q %v", code, out, err)
+	}
+}
+
+func TestAgent_WaitCanceledKillsCommand(t *testing.T) {
+	t.Parallel()
+
+	conn := startAgent(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The output only ends once the whole process group is gone.
+	_, _, err := runScript(t, ctx, conn, "sleep 30 & sleep 30")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait error = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("killing took %v", elapsed)
+	}
+
+	if code, out, err := runScript(t, context.Background(), conn, "echo next"); err != nil || code != 0 || out != "next\n" {
+		t.Errorf("after a kill: %d %q %v", code, out, err)
+	}
+}
+
+func TestAccept_SandboxExited(t *testing.T) {
+	t.Parallel()
+
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "agent.sock"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	close(exited)
+	if _, err := Accept(context.Background(), l, exited); err == nil {
+		t.Fatal("Accept succeeded without an agent")
+	}
+}
+
+// startRelayAgent serves an in-process agent the way it runs inside a VM,
+// with its streams dialed back to the listener.
+func startRelayAgent(t *testing.T) *Conn {
+	t.Helper()
+	addr := &net.UnixAddr{Name: filepath.Join(t.TempDir(), "agent.sock"), Net: "unix"}
+	l, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := func() (*os.File, error) {
+		c, err := net.DialUnix("unix", nil, addr)
+		if err != nil {
+			return nil, err
+		}
+		defer c.Close()
+		return c.File()
+	}
+	served := make(chan error, 1)
+	go func() {
+		c, err := dial()
+		if err != nil {
+			served <- err
+			return
+		}
+		defer c.Close()
+		served <- ServeRelay(c, dial)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := AcceptRelay(ctx, l, nil)
+	if err != nil {
+		t.Fatalf("AcceptRelay: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		if err := <-served; err != nil {
+			t.Errorf("ServeRelay: %v", err)
+		}
+	})
+	return conn
+}
+
+func TestAgent_Relay(t *testing.T) {
+	t.Parallel()
+
+	conn := startRelayAgent(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for i, tc := range []struct {
+		script string
+		code   int
+		out    string
+	}{
+		{`echo $AGENT_TEST; read x || echo eof`, 0, "env\neof\n"},
+		{`echo err >&2; exit 3`, 3, "err\n"},
+	} {
+		code, out, err := runScript(t, ctx, conn, tc.script)
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+		if code != tc.code || out != tc.out {
+			t.Errorf("run %d = %d, %q; want %d, %q", i, code, out, tc.code, tc.out)
+		}
+	}
+
+	if err := conn.Start(Request{Argv: []string{"/bin/true"}, TTY: true}, [3]*os.File{os.Stdin, os.Stdout, os.Stderr}); err == nil {
+		t.Error("relayed terminal: no error")
+	}
+}
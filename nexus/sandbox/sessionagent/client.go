@@ -0,0 +1,303 @@
+package sessionagent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	// killWait bounds how long Wait waits for a killed command's Reply.
+	killWait = 5 * time.Second
+	// callWait bounds how long Freeze and Thaw wait for their Reply.
+	callWait = 30 * time.Second
+	// relayConnectWait bounds how long a relayed command's streams may take
+	// to connect.
+	relayConnectWait = 10 * time.Second
+	// maxStreamHeader bounds the line announcing a relayed stream.
+	maxStreamHeader = 64
+)
+
+// Conn is the driver's end of a session agent's connection. It runs one
+// command at a time.
+type Conn struct {
+	conn    *net.UnixConn
+	replies chan Reply
+	readErr error // set before replies is closed
+	broken  error // a Reply went missing; later ones cannot be matched
+	closed  chan struct{}
+
+	// relay mode (AcceptRelay): the listener the streams connect to, and a
+	// channel closed once the last command's streams connected or gave up.
+	relay   *net.UnixListener
+	relayed chan struct{}
+}
+
+// Accept waits for the session agent to connect to l, then closes l so
+// nothing else can. It gives up when ctx ends or exited is closed (the
+// sandbox is gone before its agent connected).
+func Accept(ctx context.Context, l *net.UnixListener, exited <-chan struct{}) (*Conn, error) {
+	conn, err := accept(ctx, l, exited)
+	l.Close()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(conn), nil
+}
+
+// AcceptRelay is Accept for an agent serving with ServeRelay (inside a VM):
+// l stays open for the agent to connect each command's streams to, until
+// Close.
+func AcceptRelay(ctx context.Context, l *net.UnixListener, exited <-chan struct{}) (*Conn, error) {
+	conn, err := accept(ctx, l, exited)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	c := newConn(conn)
+	c.relay = l
+	c.relayed = make(chan struct{})
+	close(c.relayed)
+	return c, nil
+}
+
+func accept(ctx context.Context, l *net.UnixListener, exited <-chan struct{}) (*net.UnixConn, error) {
+	type accepted struct {
+		conn *net.UnixConn
+		err  error
+	}
+	ch := make(chan accepted, 1)
+	go func() {
+		c, err := l.AcceptUnix()
+		ch <- accepted{c, err}
+	}()
+
+	var err error
+	select {
+	case a := <-ch:
+		if a.err != nil {
+			return nil, fmt.Errorf("session agent: %w", a.err)
+		}
+		return a.conn, nil
+	case <-exited:
+		err = errors.New("session agent: sandbox exited before the agent connected")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	// Unblock the pending accept.
+	_ = l.SetDeadline(time.Now())
+	go func() {
+		if a := <-ch; a.conn != nil {
+			a.conn.Close()
+		}
+	}()
+	return nil, err
+}
+
+func newConn(conn *net.UnixConn) *Conn {
+	c := &Conn{conn: conn, replies: make(chan Reply), closed: make(chan struct{})}
+	go func() {
+		dec := json.NewDecoder(conn)
+		for {
+			var r Reply
+			if err := dec.Decode(&r); err != nil {
+				c.readErr = err
+				close(c.replies)
+				return
+			}
+			select {
+			case c.replies <- r:
+			case <-c.closed:
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// Start sends req to the agent with streams as the command's stdin,
+// stdout and stderr; the agent holds its own copies of the streams once
+// Start returns. Call Wait before starting the next command.
+func (c *Conn) Start(req Request, streams [3]*os.File) error {
+	if c.broken != nil {
+		return c.broken
+	}
+	req.Kill = false
+	if c.relay != nil {
+		return c.startRelay(req, streams)
+	}
+	if err := writeRequest(c.conn, req, streams[:]); err != nil {
+		return fmt.Errorf("session agent: send request: %w", err)
+	}
+	return nil
+}
+
+// startRelay sends req for the agent to connect the streams back, and
+// copies them to copies of streams[1] and streams[2] (stdin is /dev/null).
+func (c *Conn) startRelay(req Request, streams [3]*os.File) error {
+	if req.TTY {
+		return errors.New("session agent: a terminal cannot be relayed")
+	}
+	<-c.relayed
+	var out [2]*os.File
+	for i, f := range streams[1:] {
+		fd, err := syscall.Dup(int(f.Fd()))
+		if err != nil {
+			closeFiles(out[:i])
+			return fmt.Errorf("session agent: %w", err)
+		}
+		syscall.CloseOnExec(fd)
+		out[i] = os.NewFile(uintptr(fd), f.Name())
+	}
+	token := make([]byte, 16)
+	_, _ = rand.Read(token)
+	req.Relay = hex.EncodeToString(token)
+	if err := writeRequest(c.conn, req, nil); err != nil {
+		closeFiles(out[:])
+		return fmt.Errorf("session agent: send request: %w", err)
+	}
+	done := make(chan struct{})
+	c.relayed = done
+	go c.relayStreams(req.Relay, out, done)
+	return nil
+}
+
+// relayStreams accepts the connections announced with token and copies
+// them to out until they end. Others are dropped; a stream that did not
+// connect within relayConnectWait is closed.
+func (c *Conn) relayStreams(token string, out [2]*os.File, done chan<- struct{}) {
+	defer close(done)
+	deadline := time.Now().Add(relayConnectWait)
+	_ = c.relay.SetDeadline(deadline)
+	for out[0] != nil || out[1] != nil {
+		conn, err := c.relay.AcceptUnix()
+		if err != nil {
+			break
+		}
+		i := readStreamHeader(conn, token, deadline)
+		if i < 0 || out[i] == nil {
+			conn.Close()
+			continue
+		}
+		f := out[i]
+		out[i] = nil
+		go func() {
+			_, _ = io.Copy(f, conn)
+			conn.Close()
+			f.Close()
+		}()
+	}
+	for _, f := range out {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+// readStreamHeader returns which stream conn announces (0 for stdout, 1 for
+// stderr), or -1 unless it is announced with token.
+func readStreamHeader(conn *net.UnixConn, token string, deadline time.Time) int {
+	_ = conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < maxStreamHeader {
+		if _, err := conn.Read(b); err != nil {
+			return -1
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	switch string(line) {
+	case token + " 1":
+		return 0
+	case token + " 2":
+		return 1
+	}
+	return -1
+}
+
+// Wait returns the exit code of the command Start started. When ctx ends
+// first, the command's process group is killed and ctx's error returned.
+func (c *Conn) Wait(ctx context.Context) (int, error) {
+	select {
+	case r, ok := <-c.replies:
+		if !ok {
+			return 0, fmt.Errorf("session agent: %w", c.readErr)
+		}
+		if r.Error != "" {
+			return 0, errors.New("session agent: " + r.Error)
+		}
+		return r.ExitCode, nil
+	case <-ctx.Done():
+	}
+
+	if err := writeRequest(c.conn, Request{Kill: true}, nil); err != nil {
+		c.broken = fmt.Errorf("session agent: send kill: %w", err)
+		return 0, ctx.Err()
+	}
+	select {
+	case _, ok := <-c.replies:
+		if !ok {
+			c.broken = fmt.Errorf("session agent: %w", c.readErr)
+		}
+	case <-time.After(killWait):
+		c.broken = errors.New("session agent: killed command did not exit")
+	}
+	return 0, ctx.Err()
+}
+
+// Freeze freezes the filesystem mounted at path in the sandbox, and Thaw
+// thaws it; the agent thaws what is still frozen when the session ends.
+// Call them between commands.
+func (c *Conn) Freeze(path string) error { return c.call(Request{Freeze: path}) }
+
+// Thaw undoes Freeze.
+func (c *Conn) Thaw(path string) error { return c.call(Request{Thaw: path}) }
+
+func (c *Conn) call(req Request) error {
+	if c.broken != nil {
+		return c.broken
+	}
+	if err := writeRequest(c.conn, req, nil); err != nil {
+		return fmt.Errorf("session agent: send request: %w", err)
+	}
+	select {
+	case r, ok := <-c.replies:
+		if !ok {
+			return fmt.Errorf("session agent: %w", c.readErr)
+		}
+		if r.Error != "" {
+			return errors.New("session agent: " + r.Error)
+		}
+		return nil
+	case <-time.After(callWait):
+		c.broken = errors.New("session agent: no reply")
+		return c.broken
+	}
+}
+
+// Close ends the session: the agent kills what is left of its commands
+// and exits.
+func (c *Conn) Close() error {
+	select {
+	case <-c.closed:
+		return nil
+	default:
+		close(c.closed)
+	}
+	if c.relay != nil {
+		c.relay.Close()
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package sessionagent
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	afVsock       = 40 // AF_VSOCK
+	vmaddrCIDHost = 2  // VMADDR_CID_HOST
+	// Filesystem freeze ioctls, _IOWR('X', 119/120, int).
+	fiFreeze = 0xC0045877
+	fiThaw   = 0xC0045878
+)
+
+// sockaddrVM is struct sockaddr_vm.
+type sockaddrVM struct {
+	family    uint16
+	reserved1 uint16
+	port      uint32
+	cid       uint32
+	flags     uint8
+	zero      [3]uint8
+}
+
+// dialVsock connects to port on the host from inside a VM.
+func dialVsock(port uint32) (*os.File, error) {
+	fd, err := syscall.Socket(afVsock, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("vsock socket: %w", err)
+	}
+	sa := sockaddrVM{family: afVsock, port: port, cid: vmaddrCIDHost}
+	for {
+		_, _, errno := syscall.Syscall(syscall.SYS_CONNECT, uintptr(fd), uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa))
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			syscall.Close(fd)
+			return nil, fmt.Errorf("vsock connect to port %d: %w", port, errno)
+		}
+		return os.NewFile(uintptr(fd), "vsock"), nil
+	}
+}
+
+// freezeFS freezes (or thaws) the filesystem mounted at path. Freezing
+// flushes the journal, so the image is consistent for a reader outside.
+func freezeFS(path string, freeze bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	req, op := uintptr(fiThaw), "thaw"
+	if freeze {
+		req, op = fiFreeze, "freeze"
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, 0); errno != 0 {
+		return fmt.Errorf("%s %s: %w", op, path, errno)
+	}
+	return nil
+}
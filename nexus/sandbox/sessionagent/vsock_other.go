@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sessionagent
+
+import (
+	"errors"
+	"os"
+)
+
+var errVsockUnsupported = errors.New("vsock is not supported on this platform")
+
+func dialVsock(port uint32) (*os.File, error) {
+	return nil, errVsockUnsupported
+}
+
+func freezeFS(path string, freeze bool) error {
+	return errors.New("freezing filesystems is not supported on this platform")
+}
@@ -0,0 +1,273 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Default size of a terminal whose size was not given.
+const (
+	DefaultTerminalRows = 24
+	DefaultTerminalCols = 80
+)
+
+// Terminal is the input side of an interactive directive: the command runs
+// on a pseudo-terminal whose size starts at Rows x Cols and which reads
+// what arrives on Input. Its output (stdout and stderr, merged by the
+// terminal) goes to the LogSink as "stdout".
+type Terminal struct {
+	Rows, Cols int
+	// Input is closed when no more input will arrive.
+	Input <-chan TerminalInput
+}
+
+// TerminalInput is one input event: Data is typed into the terminal, a
+// non-zero Rows and Cols resize it, and EOF then types ^D, which ends the
+// input of a reader at the start of a line.
+type TerminalInput struct {
+	Data       []byte
+	Rows, Cols int
+	EOF        bool
+}
+
+// TerminalRunner is implemented by drivers that can run a command on a
+// pseudo-terminal (RunRequest.Terminal). Interactive directives are
+// rejected on other drivers.
+type TerminalRunner interface {
+	RunsTerminal() bool
+}
+
+// TerminalSupported reports whether this platform can run commands on a
+// pseudo-terminal (currently Linux only).
+func TerminalSupported() bool { return ptySupported() }
+
+// CommandIO connects a driver's command to the directive's streams: pipes
+// to the LogSink, or a pseudo-terminal when the request has a Terminal.
+type CommandIO struct {
+	stdout, stderr io.ReadCloser
+
+	// child holds the command's ends of the streams when they are passed on
+	// by hand (SessionIO); closed once the command has them.
+	child []*os.File
+	// drain are the pipes' read ends, for Drain.
+	drain []*os.File
+
+	// terminal mode
+	pty   *os.File // master
+	tty   *os.File // slave, closed in the parent once the command starts
+	input <-chan TerminalInput
+}
+
+// AttachIO sets up cmd's standard streams for req. Call it after setting
+// cmd.SysProcAttr and before cmd.Start; in terminal mode the command runs
+// in a new session with the terminal as its controlling terminal (and so,
+// as session leader, still in a process group of its own). After Start,
+// call Stream; call Close in any case.
+func AttachIO(cmd *exec.Cmd, req RunRequest) (*CommandIO, error) {
+	if req.Terminal == nil {
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		stderr, err := cmd.StderrPipe()
+		if err != nil {
+			return nil, err
+		}
+		return &CommandIO{stdout: stdout, stderr: stderr}, nil
+	}
+
+	c, err := newTerminalIO(req)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = c.tty, c.tty, c.tty
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = false // setsid makes a new group; setpgid would then fail
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0 // the child's stdin
+	return c, nil
+}
+
+// SessionIO sets up the standard streams of a command started on req's
+// behalf by someone else (a session agent, see Session): the returned
+// files are the command's stdin, stdout and stderr, to hand over before
+// calling Stream. Without a Terminal, stdin is /dev/null. Call Close in
+// any case.
+func SessionIO(req RunRequest) (*CommandIO, [3]*os.File, error) {
+	if req.Terminal == nil {
+		null, err := os.Open(os.DevNull)
+		if err != nil {
+			return nil, [3]*os.File{}, err
+		}
+		c := &CommandIO{child: []*os.File{null}}
+		var streams [3]*os.File
+		streams[0] = null
+		for i := 1; i < 3; i++ {
+			r, w, err := os.Pipe()
+			if err != nil {
+				c.Close()
+				return nil, [3]*os.File{}, err
+			}
+			c.child = append(c.child, w)
+			c.drain = append(c.drain, r)
+			streams[i] = w
+		}
+		c.stdout, c.stderr = drainReader{c.drain[0]}, drainReader{c.drain[1]}
+		return c, streams, nil
+	}
+
+	c, err := newTerminalIO(req)
+	if err != nil {
+		return nil, [3]*os.File{}, err
+	}
+	return c, [3]*os.File{c.tty, c.tty, c.tty}, nil
+}
+
+// newTerminalIO opens the pseudo-terminal for req.Terminal, sized as
+// requested (or DefaultTerminalRows x DefaultTerminalCols).
+func newTerminalIO(req RunRequest) (*CommandIO, error) {
+	pty, tty, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	rows, cols := req.Terminal.Rows, req.Terminal.Cols
+	if rows <= 0 || cols <= 0 {
+		rows, cols = DefaultTerminalRows, DefaultTerminalCols
+	}
+	if err := setWinsize(pty, rows, cols); err != nil {
+		pty.Close()
+		tty.Close()
+		return nil, err
+	}
+	return &CommandIO{pty: pty, tty: tty, input: req.Terminal.Input}, nil
+}
+
+// Drain lets the output end d from now even if processes the command left
+// behind still hold the streams.
+func (c *CommandIO) Drain(d time.Duration) {
+	deadline := time.Now().Add(d)
+	for _, f := range c.drain {
+		_ = f.SetReadDeadline(deadline)
+	}
+	if c.pty != nil {
+		_ = c.pty.SetReadDeadline(deadline)
+	}
+}
+
+// Stream forwards the command's output to sink until it ends (and, in
+// terminal mode, input to the terminal meanwhile). Call it after
+// cmd.Start succeeded, and before cmd.Wait: Wait closes the pipes, which
+// would drop output not yet read.
+func (c *CommandIO) Stream(ctx context.Context, sink LogSink) error {
+	for _, f := range c.child {
+		f.Close()
+	}
+	c.child = nil
+
+	if c.pty == nil {
+		errCh := make(chan error, 2)
+		go func() { errCh <- sink.Consume(ctx, "stdout", c.stdout) }()
+		go func() { errCh <- sink.Consume(ctx, "stderr", c.stderr) }()
+		return errors.Join(<-errCh, <-errCh)
+	}
+
+	// The output ends (EIO on the master) once every process holding the
+	// terminal is gone, which includes us until we let go of it.
+	c.tty.Close()
+	c.tty = nil
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.forwardInput(ctx, done)
+	}()
+	err := sink.Consume(ctx, "stdout", ptyReader{c.pty})
+	close(done)
+	wg.Wait()
+	return err
+}
+
+// forwardInput writes input events to the terminal until the input is
+// closed, done is closed, or ctx ends.
+func (c *CommandIO) forwardInput(ctx context.Context, done <-chan struct{}) {
+	for {
+		var in TerminalInput
+		var ok bool
+		select {
+		case in, ok = <-c.input:
+			if !ok {
+				return
+			}
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		}
+		if in.Rows > 0 && in.Cols > 0 {
+			_ = setWinsize(c.pty, in.Rows, in.Cols)
+		}
+		if len(in.Data) > 0 {
+			if _, err := c.pty.Write(in.Data); err != nil {
+				return
+			}
+		}
+		if in.EOF {
+			if _, err := c.pty.Write([]byte{0x04}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close releases the pipes or the terminal.
+func (c *CommandIO) Close() {
+	for _, f := range c.child {
+		f.Close()
+	}
+	for _, f := range c.drain {
+		f.Close()
+	}
+	if c.tty != nil {
+		c.tty.Close()
+	}
+	if c.pty != nil {
+		c.pty.Close()
+	}
+}
+
+// ptyReader reads the terminal's master side. Reads fail with EIO once
+// the slave side is closed for good, which is the end of the output (as
+// is the deadline Drain sets).
+type ptyReader struct{ f *os.File }
+
+func (r ptyReader) Read(b []byte) (int, error) {
+	n, err := r.f.Read(b)
+	if errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// drainReader reads a pipe whose end may also be the deadline Drain sets.
+type drainReader struct{ f *os.File }
+
+func (r drainReader) Read(b []byte) (int, error) {
+	n, err := r.f.Read(b)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (r drainReader) Close() error { return r.f.Close() }
@@ -0,0 +1,161 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// captureSink collects what is written to each stream as it arrives.
+type captureSink struct {
+	mu      sync.Mutex
+	streams map[string]*bytes.Buffer
+}
+
+func (s *captureSink) Consume(_ context.Context, stream string, r io.Reader) error {
+	buf := make([]byte, 1024)
+	for {
+		n, err := r.Read(buf)
+		s.mu.Lock()
+		if s.streams == nil {
+			s.streams = map[string]*bytes.Buffer{}
+		}
+		if s.streams[stream] == nil {
+			s.streams[stream] = &bytes.Buffer{}
+		}
+		s.streams[stream].Write(buf[:n])
+		s.mu.Unlock()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *captureSink) String(stream string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams[stream] == nil {
+		return ""
+	}
+	return strings.ReplaceAll(s.streams[stream].String(), "\r\n", "\n")
+}
+
+// waitFor waits until stream has output containing substr.
+func (s *captureSink) waitFor(t *testing.T, stream, substr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(s.String(stream), substr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: %q never appeared in %q", stream, substr, s.String(stream))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startAttached starts script the way drivers do, with req's streams;
+// wait returns once the output has ended and the script exited.
+func startAttached(t *testing.T, script string, req RunRequest) (sink *captureSink, wait func() error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cio, err := AttachIO(cmd, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cio.Close)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	sink = &captureSink{}
+	streamed := make(chan error, 1)
+	go func() { streamed <- cio.Stream(ctx, sink) }()
+	return sink, func() error {
+		if err := <-streamed; err != nil {
+			return err
+		}
+		return cmd.Wait()
+	}
+}
+
+func TestAttachIO_Pipes(t *testing.T) {
+	t.Parallel()
+
+	sink, wait := startAttached(t, "echo out; echo err >&2; [ -t 0 ] || echo notty", RunRequest{})
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.String("stdout"); got != "out\nnotty\n" {
+		t.Errorf("stdout = %q", got)
+	}
+	if got := sink.String("stderr"); got != "err\n" {
+		t.Errorf("stderr = %q", got)
+	}
+}
+
+func TestAttachIO_Terminal(t *testing.T) {
+	t.Parallel()
+
+	input := make(chan TerminalInput)
+	script := `stty -echo; [ -t 0 ] && [ -t 1 ] && echo tty; stty size; read a; echo "got $a" >&2; ` +
+		`read b; stty size; echo "got $b"`
+	sink, wait := startAttached(t, script, RunRequest{Terminal: &Terminal{Rows: 30, Cols: 100, Input: input}})
+
+	sink.waitFor(t, "stdout", "30 100\n") // echo is off by now
+	input <- TerminalInput{Data: []byte("hello\n")}
+	sink.waitFor(t, "stdout", "got hello\n")
+	input <- TerminalInput{Rows: 40, Cols: 120}
+	input <- TerminalInput{Data: []byte("again\n")}
+	close(input)
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := sink.String("stdout"); got != "tty\n30 100\ngot hello\n40 120\ngot again\n" {
+		t.Errorf("terminal output = %q", got)
+	}
+	if got := sink.String("stderr"); got != "" {
+		t.Errorf("stderr = %q, want it merged into the terminal", got)
+	}
+}
+
+func TestAttachIO_TerminalEOF(t *testing.T) {
+	t.Parallel()
+
+	input := make(chan TerminalInput)
+	sink, wait := startAttached(t, `stty -echo; echo ready; cat; echo done`, RunRequest{Terminal: &Terminal{Input: input}})
+
+	sink.waitFor(t, "stdout", "ready\n")
+	input <- TerminalInput{Data: []byte("line\n"), EOF: true}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.String("stdout"); got != "ready\nline\ndone\n" {
+		t.Errorf("terminal output = %q", got)
+	}
+}
+
+func TestAttachIO_TerminalDefaultSize(t *testing.T) {
+	t.Parallel()
+
+	sink, wait := startAttached(t, `stty size`, RunRequest{Terminal: &Terminal{}})
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.String("stdout"); got != "24 80\n" {
+		t.Errorf("terminal output = %q", got)
+	}
+}
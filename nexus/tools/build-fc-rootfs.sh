@@ -78,6 +78,43 @@ mount -t devtmpfs devtmpfs /dev
 mount -t tmpfs tmpfs /tmp
 mount -t tmpfs tmpfs /run
 
+# Snapshot mode (nexus.snapshot=1): nexusd snapshots the VM here, then
+# restores it per directive with fresh command/workspace drives. Wait for
+# the host's handoff on vsock port 9081: the wall clock, then an RNG seed.
+if grep -qw nexus.snapshot=1 /proc/cmdline; then
+  ( socat -u VSOCK-LISTEN:9081 - | {
+      read -r NOW && date -s "@$NOW" >/dev/null 2>&1
+      cat > /dev/urandom
+    } ) &
+  HANDOFF_PID=$!
+  sleep 0.1
+  echo "NEXUS_SNAPSHOT_READY"
+  wait $HANDOFF_PID
+  # Forget anything cached from the placeholder drives.
+  blockdev --flushbufs /dev/vdb /dev/vdc 2>/dev/null || echo 3 > /proc/sys/vm/drop_caches
+fi
+
+# Tap mode (nexus.net=<guest-cidr>,<gateway>): bring up eth0 and use the
+# gateway as the resolver; nftables on the host enforces the egress policy.
+# Otherwise (vsock mode) 127.0.0.1:53 is bridged to the host resolver on
+# vsock port 9053 below. Either way the resolver answers only allowlisted
+# names. The rootfs is read-only, so resolv.conf (a symlink into /run on
+# Ubuntu) is written under the /run tmpfs.
+NET_ARG=$(tr ' ' '\n' < /proc/cmdline | sed -n 's/^nexus\.net=//p')
+NAMESERVER=127.0.0.1
+ip link set lo up 2>/dev/null || true
+if [ -n "$NET_ARG" ]; then
+  GUEST_CIDR=${NET_ARG%,*}
+  NAMESERVER=${NET_ARG#*,}
+  ip addr add "$GUEST_CIDR" dev eth0
+  ip link set eth0 up
+  ip route add default via "$NAMESERVER"
+fi
+mkdir -p /run/systemd/resolve
+echo "nameserver $NAMESERVER" > /run/systemd/resolve/stub-resolv.conf
+echo "nameserver $NAMESERVER" > /run/resolv.conf
+[ -L /etc/resolv.conf ] || mount --bind /run/resolv.conf /etc/resolv.conf 2>/dev/null || true
+
 mkdir -p /mnt/cmd /workspace
 mount -t ext4 -o ro /dev/vdb /mnt/cmd
 mount -t ext4 /dev/vdc /workspace
@@ -86,12 +123,19 @@ mount -t ext4 /dev/vdc /workspace
 # Guest CID=3, host CID=2. Port 9080 matches the egress proxy convention.
 socat TCP-LISTEN:9080,fork,reuseaddr VSOCK-CONNECT:2:9080 &
 SOCAT_PID=$!
-trap "kill $SOCAT_PID 2>/dev/null" EXIT
+# DNS bridge (vsock mode): one query per vsock connection to port 9053.
+DNS_PID=
+if [ -z "$NET_ARG" ]; then
+  socat UDP4-RECVFROM:53,bind=127.0.0.1,fork VSOCK-CONNECT:2:9053 &
+  DNS_PID=$!
+fi
+trap "kill $SOCAT_PID $DNS_PID 2>/dev/null" EXIT
 
 # Brief pause for socat to start listening.
 sleep 0.1
 
 # Execute the command wrapper.
+echo "NEXUS_GUEST_READY"
 sh /mnt/cmd/run.sh
 EXIT_CODE=$?
 
@@ -6,15 +6,21 @@ ROOT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")/.." && pwd)"
 SRC_NET="${ROOT_DIR}/docs/protocol/directivespec_capabilities_net.schema.v1.json"
 DST_NET="${ROOT_DIR}/protocol/schema/directivespec_capabilities_net.schema.v1.json"
 
+SRC_NET_ALLOW_V2="${ROOT_DIR}/docs/protocol/directivespec_capabilities_net_allowlist.schema.v2.json"
+DST_NET_ALLOW_V2="${ROOT_DIR}/protocol/schema/directivespec_capabilities_net_allowlist.schema.v2.json"
+
 SRC_FS="${ROOT_DIR}/docs/protocol/directivespec_capabilities_fs.schema.v1.json"
 DST_FS="${ROOT_DIR}/protocol/schema/directivespec_capabilities_fs.schema.v1.json"
 
 mkdir -p "$(dirname "${DST_NET}")"
 cp -f "${SRC_NET}" "${DST_NET}"
+cp -f "${SRC_NET_ALLOW_V2}" "${DST_NET_ALLOW_V2}"
 cp -f "${SRC_FS}" "${DST_FS}"
 
 echo "Synced schema:"
 echo "  ${SRC_NET}"
 echo "  -> ${DST_NET}"
+echo "  ${SRC_NET_ALLOW_V2}"
+echo "  -> ${DST_NET_ALLOW_V2}"
 echo "  ${SRC_FS}"
 echo "  -> ${DST_FS}"